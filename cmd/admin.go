@@ -20,6 +20,7 @@ type serverConfig struct {
 	Permissions   json.RawMessage `json:"permissions"`
 	Update        *AppUpdate      `json:"update"`
 	NeedsRestart  bool            `json:"needs_restart"`
+	SendingHalted bool            `json:"sending_halted"`
 	HasLegacyUser bool            `json:"has_legacy_user"`
 	Version       string          `json:"version"`
 }
@@ -62,6 +63,7 @@ func handleGetServerConfig(c echo.Context) error {
 	out.Update = app.update
 	app.Unlock()
 	out.Version = versionString
+	out.SendingHalted = app.manager.IsHalted()
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -86,11 +88,19 @@ func handleGetDashboardCounts(c echo.Context) error {
 		app = c.Get("app").(*App)
 	)
 
-	out, err := app.core.GetDashboardCounts()
+	counts, err := app.core.GetDashboardCounts()
 	if err != nil {
 		return err
 	}
 
+	// Surface the emergency stop along with the counts.
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(counts, &out); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			app.i18n.Ts("globals.messages.errorFetching", "name", "dashboard stats", "error", err.Error()))
+	}
+	out["sending_halted"] = app.manager.IsHalted()
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
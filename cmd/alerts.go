@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// alertMonitorInterval is the interval at which running campaigns are checked against the alert rules.
+const alertMonitorInterval = time.Minute
+
+// handleGetAlertRules returns the bounce and complaint rate alert rules.
+func handleGetAlertRules(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	out := s.AlertRules
+	if out == nil {
+		out = []models.AlertRule{}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateAlertRule adds a new alert rule. Rules are read by the alert
+// monitor on every run and do not require an app reload.
+func handleCreateAlertRule(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	var r models.AlertRule
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := validateAlertRule(r, app); err != nil {
+		return err
+	}
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	r.UUID = uuid.Must(uuid.NewV4()).String()
+	s.AlertRules = append(s.AlertRules, r)
+
+	if err := app.core.UpdateSettings(s); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{r})
+}
+
+// handleUpdateAlertRule updates an existing alert rule.
+func handleUpdateAlertRule(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		id  = c.Param("uuid")
+	)
+
+	var r models.AlertRule
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := validateAlertRule(r, app); err != nil {
+		return err
+	}
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, a := range s.AlertRules {
+		if a.UUID == id {
+			r.UUID = id
+			s.AlertRules[i] = r
+			found = true
+			break
+		}
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound,
+			app.i18n.Ts("globals.messages.notFound", "name", "{settings.alerts.rule}"))
+	}
+
+	if err := app.core.UpdateSettings(s); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{r})
+}
+
+// handleDeleteAlertRule deletes an alert rule.
+func handleDeleteAlertRule(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		id  = c.Param("uuid")
+	)
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	rules := make([]models.AlertRule, 0, len(s.AlertRules))
+	for _, a := range s.AlertRules {
+		if a.UUID != id {
+			rules = append(rules, a)
+		}
+	}
+	if len(rules) == len(s.AlertRules) {
+		return echo.NewHTTPError(http.StatusNotFound,
+			app.i18n.Ts("globals.messages.notFound", "name", "{settings.alerts.rule}"))
+	}
+	s.AlertRules = rules
+
+	if err := app.core.UpdateSettings(s); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateAlertRule validates an alert rule's fields.
+func validateAlertRule(r models.AlertRule, app *App) error {
+	if !strHasLen(r.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+	if r.Metric != models.AlertMetricBounce && r.Metric != models.AlertMetricComplaint {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "metric"))
+	}
+	if r.Threshold <= 0 || r.Threshold > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "threshold"))
+	}
+	if r.MinSends < 1 || (r.MaxSends != 0 && r.MaxSends < r.MinSends) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "min_sends, max_sends"))
+	}
+
+	return nil
+}
+
+// runAlertMonitor periodically evaluates the alert rules against the bounce and
+// complaint rates of running campaigns. A campaign that crosses a rule's threshold
+// is paused (if the rule says so) and admins are notified once per rule.
+func runAlertMonitor(interval time.Duration, app *App) {
+	// campaign ID:rule UUID of alerts that have already fired.
+	fired := make(map[string]struct{})
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() {
+			continue
+		}
+
+		s, err := app.core.GetSettings()
+		if err != nil {
+			app.log.Printf("error reading alert rules: %v", err)
+			continue
+		}
+
+		hasRules := false
+		for _, r := range s.AlertRules {
+			if r.Enabled {
+				hasRules = true
+				break
+			}
+		}
+		if !hasRules {
+			continue
+		}
+
+		camps, err := app.core.GetCampaignBounceRates()
+		if err != nil {
+			continue
+		}
+
+		for _, c := range camps {
+			if c.Sent == 0 {
+				continue
+			}
+
+			for _, r := range s.AlertRules {
+				if !r.Enabled || c.Sent < r.MinSends || (r.MaxSends > 0 && c.Sent > r.MaxSends) {
+					continue
+				}
+
+				key := strconv.Itoa(c.ID) + ":" + r.UUID
+				if _, ok := fired[key]; ok {
+					continue
+				}
+
+				count := c.Bounces
+				if r.Metric == models.AlertMetricComplaint {
+					count = c.Complaints
+				}
+
+				rate := float64(count) / float64(c.Sent) * 100
+				if rate <= r.Threshold {
+					continue
+				}
+				fired[key] = struct{}{}
+
+				triggerAlert(r, c, rate, app)
+			}
+		}
+	}
+}
+
+// triggerAlert pauses a campaign that has crossed an alert rule's threshold
+// and notifies admins.
+func triggerAlert(r models.AlertRule, c models.CampaignBounceRate, rate float64, app *App) {
+	metric := app.i18n.T("globals.terms.bounce")
+	if r.Metric == models.AlertMetricComplaint {
+		metric = app.i18n.T("bounces.complaint")
+	}
+
+	msg := app.i18n.Ts("email.alert.rate",
+		"name", c.Name,
+		"metric", metric,
+		"rate", fmt.Sprintf("%.2f", rate),
+		"num", strconv.Itoa(c.Sent),
+		"rule", r.Name,
+		"threshold", fmt.Sprintf("%.2f", r.Threshold))
+
+	paused := false
+	if r.Pause {
+		if _, err := app.core.UpdateCampaignStatus(c.ID, models.CampaignStatusPaused); err != nil {
+			app.log.Printf("error pausing campaign (%s) on alert: %v", c.Name, err)
+		} else {
+			app.manager.StopCampaign(c.ID)
+			app.core.RecordCampaignEvent(c.ID, c.Name, models.CampaignStatusPaused, 0)
+			paused = true
+			msg += " " + app.i18n.T("email.alert.ratePaused")
+		}
+	}
+
+	app.log.Printf("alert rule '%s' triggered for campaign (%s): %s rate %.2f%%", r.Name, c.Name, r.Metric, rate)
+
+	app.notify(notifs.Notif{
+		Event:   notifs.EventBounce,
+		Subject: app.i18n.Ts("email.alert.rateTitle", "name", c.Name),
+		Message: msg,
+		Data: map[string]interface{}{
+			"campaign_id":   c.ID,
+			"campaign_name": c.Name,
+			"rule":          r.Name,
+			"metric":        r.Metric,
+			"rate":          rate,
+			"threshold":     r.Threshold,
+			"sent":          c.Sent,
+			"paused":        paused,
+		},
+		Tpl: notifTplAlert,
+	})
+}
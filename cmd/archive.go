@@ -3,11 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"html"
 	"html/template"
 	"net/http"
 	"net/url"
+	"path"
+	"strings"
 
 	"github.com/gorilla/feeds"
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -21,8 +25,21 @@ type campArchive struct {
 	CreatedAt null.Time `json:"created_at"`
 	SendAt    null.Time `json:"send_at"`
 	URL       string    `json:"url"`
+
+	// Snippet of the body with the matched words highlighted in searches.
+	Snippet template.HTML `json:"snippet,omitempty"`
+}
+
+// archiveSearchResult is a campaign matching an admin archive search.
+type archiveSearchResult struct {
+	models.ArchiveSearchResult
+	Snippet template.HTML `json:"snippet"`
+	URL     string        `json:"url"`
 }
 
+// Maximum number of results in a page of public archive search results.
+const archiveSearchMaxPerPage = 20
+
 // handleGetCampaignArchives renders the public campaign archives page.
 func handleGetCampaignArchives(c echo.Context) error {
 	var (
@@ -30,7 +47,7 @@ func handleGetCampaignArchives(c echo.Context) error {
 		pg  = app.paginator.NewFromURL(c.Request().URL.Query())
 	)
 
-	camps, total, err := getCampaignArchives(pg.Offset, pg.Limit, false, app)
+	camps, total, err := getCampaignArchives(pg.Offset, pg.Limit, c.QueryParam("list"), false, app)
 	if err != nil {
 		return err
 	}
@@ -50,7 +67,9 @@ func handleGetCampaignArchives(c echo.Context) error {
 	return c.JSON(200, okResp{out})
 }
 
-// handleGetCampaignArchivesFeed renders the public campaign archives RSS feed.
+// handleGetCampaignArchivesFeed renders the public campaign archives as an RSS
+// (archive.xml), Atom (archive.atom), or JSON Feed (archive.json) feed. The
+// optional ?list={uuid} param restricts the feed to campaigns sent to a list.
 func handleGetCampaignArchivesFeed(c echo.Context) error {
 	var (
 		app             = c.Get("app").(*App)
@@ -58,7 +77,12 @@ func handleGetCampaignArchivesFeed(c echo.Context) error {
 		showFullContent = app.constants.EnablePublicArchiveRSSContent
 	)
 
-	camps, _, err := getCampaignArchives(pg.Offset, pg.Limit, showFullContent, app)
+	listUUID := c.QueryParam("list")
+	if listUUID != "" && !reUUID.MatchString(listUUID) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	camps, _, err := getCampaignArchives(pg.Offset, pg.Limit, listUUID, showFullContent, app)
 	if err != nil {
 		return err
 	}
@@ -72,6 +96,7 @@ func handleGetCampaignArchivesFeed(c echo.Context) error {
 		}
 
 		out = append(out, &feeds.Item{
+			Id:      c.URL,
 			Title:   c.Subject,
 			Link:    &feeds.Link{Href: c.URL},
 			Content: c.Content,
@@ -85,9 +110,35 @@ func handleGetCampaignArchivesFeed(c echo.Context) error {
 		Description: app.i18n.T("public.archiveTitle"),
 		Items:       out,
 	}
+	if len(out) > 0 {
+		feed.Updated = out[0].Created
+	}
+
+	// Name the feed after the list. Private list names are not exposed.
+	if listUUID != "" {
+		feed.Link.Href = app.constants.ArchiveURL + "?list=" + listUUID
+		if l, err := app.core.GetList(0, listUUID); err == nil && l.Type == models.ListTypePublic {
+			feed.Title = app.constants.SiteName + " - " + l.Name
+		}
+	}
 
-	if err := feed.WriteRss(c.Response().Writer); err != nil {
-		app.log.Printf("error generating archive RSS feed: %v", err)
+	var (
+		w   = c.Response().Writer
+		ext = path.Ext(c.Path())
+	)
+	switch ext {
+	case ".atom":
+		c.Response().Header().Set(echo.HeaderContentType, "application/atom+xml; charset=utf-8")
+		err = feed.WriteAtom(w)
+	case ".json":
+		c.Response().Header().Set(echo.HeaderContentType, "application/feed+json; charset=utf-8")
+		err = feed.WriteJSON(w)
+	default:
+		c.Response().Header().Set(echo.HeaderContentType, "application/rss+xml; charset=utf-8")
+		err = feed.WriteRss(w)
+	}
+	if err != nil {
+		app.log.Printf("error generating archive %s feed: %v", ext, err)
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.errorProcessingRequest"))
 	}
 
@@ -101,20 +152,155 @@ func handleCampaignArchivesPage(c echo.Context) error {
 		pg  = app.paginator.NewFromURL(c.Request().URL.Query())
 	)
 
-	out, total, err := getCampaignArchives(pg.Offset, pg.Limit, false, app)
+	// Optionally, only show the archives of a particular list.
+	listUUID := c.QueryParam("list")
+	if listUUID != "" && !reUUID.MatchString(listUUID) {
+		listUUID = ""
+	}
+
+	out, total, err := getCampaignArchives(pg.Offset, pg.Limit, listUUID, false, app)
 	if err != nil {
 		return err
 	}
 	pg.SetTotal(total)
 
+	pgURI := "?page=%d"
+	if listUUID != "" {
+		pgURI = "?list=" + listUUID + "&page=%d"
+	}
+
 	title := app.i18n.T("public.archiveTitle")
 	return c.Render(http.StatusOK, "archive", struct {
 		Title       string
 		Description string
+		ListUUID    string
+		Query       string
 		Campaigns   []campArchive
 		TotalPages  int
 		Pagination  template.HTML
-	}{title, title, out, pg.TotalPages, template.HTML(pg.HTML("?page=%d"))})
+	}{title, title, listUUID, "", out, pg.TotalPages, template.HTML(pg.HTML(pgURI))})
+}
+
+// handleSearchCampaignArchives runs a full-text search of the public campaign
+// archives and returns the matches with highlighted snippets.
+func handleSearchCampaignArchives(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		pg    = app.paginator.NewFromURL(c.Request().URL.Query())
+		query = strings.TrimSpace(c.QueryParam("q"))
+	)
+
+	if !strHasLen(query, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "q"))
+	}
+
+	listUUID := c.QueryParam("list")
+	if listUUID != "" && !reUUID.MatchString(listUUID) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	limitArchiveSearchPage(&pg.Limit, &pg.PerPage)
+	out, total, err := searchCampaignArchives(query, listUUID, pg.Offset, pg.Limit, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{models.PageResults{
+		Results: out,
+		Query:   query,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}})
+}
+
+// handleCampaignArchiveSearchPage renders the public campaign archives page
+// with the results of a full-text search.
+func handleCampaignArchiveSearchPage(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		pg    = app.paginator.NewFromURL(c.Request().URL.Query())
+		query = strings.TrimSpace(c.QueryParam("q"))
+	)
+
+	listUUID := c.QueryParam("list")
+	if listUUID != "" && !reUUID.MatchString(listUUID) {
+		listUUID = ""
+	}
+
+	if !strHasLen(query, 0, stdInputMaxLen) {
+		query = ""
+	}
+
+	var (
+		out   = []campArchive{}
+		total int
+	)
+	if query != "" {
+		limitArchiveSearchPage(&pg.Limit, &pg.PerPage)
+
+		var err error
+		if out, total, err = searchCampaignArchives(query, listUUID, pg.Offset, pg.Limit, app); err != nil {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingCampaign")))
+		}
+	}
+	pg.SetTotal(total)
+
+	// The escaped query is a part of the page link's format string.
+	pgURI := "?q=" + strings.ReplaceAll(url.QueryEscape(query), "%", "%%") + "&page=%d"
+	if listUUID != "" {
+		pgURI = "?list=" + listUUID + "&" + pgURI[1:]
+	}
+
+	title := app.i18n.T("public.archiveTitle")
+	return c.Render(http.StatusOK, "archive", struct {
+		Title       string
+		Description string
+		ListUUID    string
+		Query       string
+		Campaigns   []campArchive
+		TotalPages  int
+		Pagination  template.HTML
+	}{title, title, listUUID, query, out, pg.TotalPages, template.HTML(pg.HTML(pgURI))})
+}
+
+// handleSearchCampaignArchivesAdmin runs a full-text search of all the sent
+// campaigns, archived or not, that the user has access to.
+func handleSearchCampaignArchivesAdmin(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		pg    = app.paginator.NewFromURL(c.Request().URL.Query())
+		query = strings.TrimSpace(c.QueryParam("q"))
+	)
+
+	if !strHasLen(query, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "q"))
+	}
+
+	getAll, listIDs := campaignListScope(user, false)
+	res, total, err := app.core.SearchCampaignArchives(query, false, "", getAll, listIDs, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := make([]archiveSearchResult, 0, len(res))
+	for _, r := range res {
+		a := archiveSearchResult{ArchiveSearchResult: r, Snippet: highlightSnippet(r.Snippet)}
+		if r.Archive {
+			a.URL = archiveURL(r.UUID, r.ArchiveSlug, app)
+		}
+		out = append(out, a)
+	}
+
+	return c.JSON(http.StatusOK, okResp{models.PageResults{
+		Results: out,
+		Query:   query,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}})
 }
 
 // handleCampaignArchivePage renders the public campaign archives page.
@@ -177,7 +363,7 @@ func handleCampaignArchivePageLatest(c echo.Context) error {
 		app = c.Get("app").(*App)
 	)
 
-	camps, _, err := getCampaignArchives(0, 1, true, app)
+	camps, _, err := getCampaignArchives(0, 1, "", true, app)
 	if err != nil {
 		return err
 	}
@@ -192,8 +378,8 @@ func handleCampaignArchivePageLatest(c echo.Context) error {
 	return c.HTML(http.StatusOK, camp.Content)
 }
 
-func getCampaignArchives(offset, limit int, renderBody bool, app *App) ([]campArchive, int, error) {
-	pubCamps, total, err := app.core.GetArchivedCampaigns(offset, limit)
+func getCampaignArchives(offset, limit int, listUUID string, renderBody bool, app *App) ([]campArchive, int, error) {
+	pubCamps, total, err := app.core.GetArchivedCampaigns(offset, limit, listUUID)
 	if err != nil {
 		return []campArchive{}, total, echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("public.errorFetchingCampaign"))
 	}
@@ -214,11 +400,7 @@ func getCampaignArchives(offset, limit int, renderBody bool, app *App) ([]campAr
 			SendAt:    camp.SendAt,
 		}
 
-		if camp.ArchiveSlug.Valid {
-			archive.URL, _ = url.JoinPath(app.constants.ArchiveURL, camp.ArchiveSlug.String)
-		} else {
-			archive.URL, _ = url.JoinPath(app.constants.ArchiveURL, camp.UUID)
-		}
+		archive.URL = archiveURL(camp.UUID, camp.ArchiveSlug, app)
 
 		if renderBody {
 			msg, err := app.manager.NewCampaignMessage(camp, m.Subscriber)
@@ -234,6 +416,55 @@ func getCampaignArchives(offset, limit int, renderBody bool, app *App) ([]campAr
 	return out, total, nil
 }
 
+// searchCampaignArchives runs a full-text search of the public campaign archives.
+func searchCampaignArchives(query, listUUID string, offset, limit int, app *App) ([]campArchive, int, error) {
+	res, total, err := app.core.SearchCampaignArchives(query, true, listUUID, true, nil, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]campArchive, 0, len(res))
+	for _, r := range res {
+		out = append(out, campArchive{
+			UUID:      r.UUID,
+			Subject:   r.Subject,
+			CreatedAt: r.CreatedAt,
+			SendAt:    r.SendAt,
+			URL:       archiveURL(r.UUID, r.ArchiveSlug, app),
+			Snippet:   highlightSnippet(r.Snippet),
+		})
+	}
+
+	return out, total, nil
+}
+
+// limitArchiveSearchPage caps the page size of public archive searches as
+// every result's snippet is generated from its body.
+func limitArchiveSearchPage(limit, perPage *int) {
+	if *limit < 1 || *limit > archiveSearchMaxPerPage {
+		*limit = archiveSearchMaxPerPage
+		*perPage = archiveSearchMaxPerPage
+	}
+}
+
+// highlightSnippet escapes a search result snippet and highlights the matched
+// words, which are delimited by \x02 and \x03, with <mark>.
+func highlightSnippet(s string) template.HTML {
+	s = html.EscapeString(html.UnescapeString(strings.Join(strings.Fields(s), " ")))
+	return template.HTML(strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(s))
+}
+
+// archiveURL returns the public archive URL of a campaign.
+func archiveURL(uuid string, slug null.String, app *App) string {
+	var u string
+	if slug.Valid {
+		u, _ = url.JoinPath(app.constants.ArchiveURL, slug.String)
+	} else {
+		u, _ = url.JoinPath(app.constants.ArchiveURL, uuid)
+	}
+	return u
+}
+
 func compileArchiveCampaigns(camps []models.Campaign, app *App) ([]manager.CampaignMessage, error) {
 	var (
 		b = bytes.Buffer{}
@@ -242,7 +473,7 @@ func compileArchiveCampaigns(camps []models.Campaign, app *App) ([]manager.Campa
 	out := make([]manager.CampaignMessage, 0, len(camps))
 	for _, c := range camps {
 		camp := c
-		if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
+		if err := app.manager.CompileCampaign(&camp); err != nil {
 			app.log.Printf("error compiling template: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("public.errorFetchingCampaign"))
 		}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/stripe"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// handleStripeWebhook handles Stripe subscription webhook events. Subscribers
+// are subscribed to paid lists whose price is in an active subscription and
+// are unsubscribed from them when the subscription lapses or is cancelled.
+// Customers who aren't subscribers yet are created.
+func handleStripeWebhook(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		app.log.Printf("error reading stripe webhook body: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.internalError"))
+	}
+
+	ev, err := app.stripe.ParseEvent(c.Request().Header.Get("Stripe-Signature"), body)
+	if err != nil {
+		app.log.Printf("error processing stripe webhook: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidData"))
+	}
+
+	// Other events are acknowledged and ignored.
+	switch ev.Type {
+	case stripe.EventSubCreated, stripe.EventSubUpdated, stripe.EventSubDeleted:
+	default:
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	var s stripe.Subscription
+	if err := json.Unmarshal(ev.Data.Object, &s); err != nil {
+		app.log.Printf("error parsing stripe subscription: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidData"))
+	}
+
+	// Stripe retries the webhook on errors.
+	cust, err := app.stripe.GetCustomer(s.Customer)
+	if err != nil {
+		app.log.Printf("error fetching stripe customer %s: %v", s.Customer, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.Ts("globals.messages.internalError"))
+	}
+
+	email, err := app.importer.SanitizeEmail(cust.Email)
+	if err != nil {
+		app.log.Printf("ignoring stripe subscription %s: invalid customer e-mail: %v", s.ID, err)
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	sub, err := getOrCreateStripeSubscriber(email, cust.Name, app)
+	if err != nil {
+		return err
+	}
+
+	var periodEnd null.Time
+	if s.CurrentPeriodEnd > 0 {
+		periodEnd = null.TimeFrom(time.Unix(s.CurrentPeriodEnd, 0))
+	}
+
+	if err := app.core.SyncStripeSubscription(sub.ID, s.ID, s.Customer, s.PriceIDs(), s.Status, s.IsActive(), periodEnd); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleBillingPortal redirects a subscriber from the subscription management
+// page to their Stripe customer portal.
+func handleBillingPortal(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		campUUID = c.Param("campUUID")
+		subUUID  = c.Param("subUUID")
+	)
+
+	sub, err := app.core.GetSubscriber(0, subUUID, "")
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	subs, err := app.core.GetStripeSubscriptions(sub.ID)
+	if err != nil || len(subs) == 0 {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.billingNotFound")))
+	}
+
+	// The portal's "return" link goes back to the preferences page.
+	ret := app.manager.UnsubURL(campUUID, subUUID) + "&manage=true"
+	u, err := app.stripe.NewPortalSession(subs[0].CustomerID, ret)
+	if err != nil {
+		app.log.Printf("error creating stripe portal session: %v", err)
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	return c.Redirect(http.StatusSeeOther, u)
+}
+
+// getOrCreateStripeSubscriber returns the subscriber with the given e-mail,
+// creating one if it doesn't exist.
+func getOrCreateStripeSubscriber(email, name string, app *App) (models.Subscriber, error) {
+	sub, err := app.core.GetSubscriber(0, "", email)
+	if err == nil {
+		return sub, nil
+	}
+	if e, ok := err.(*echo.HTTPError); !ok || e.Code != http.StatusBadRequest {
+		return models.Subscriber{}, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > stdInputMaxLen {
+		name = strings.Split(email, "@")[0]
+	}
+
+	sub, _, err = app.core.InsertSubscriber(models.Subscriber{
+		Email:  email,
+		Name:   name,
+		Status: models.SubscriberStatusEnabled,
+		Source: models.SourceStripe,
+	}, nil, nil, true)
+
+	return sub, err
+}
@@ -33,7 +33,17 @@ func handleGetBounces(c echo.Context) error {
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
-	res, total, err := app.core.QueryBounces(campID, 0, source, orderBy, order, pg.Offset, pg.Limit)
+	// Cursor pagination orders by ID.
+	cur, err := getPageCursor(c, app)
+	if err != nil {
+		return err
+	}
+	offset := pg.Offset
+	if cur.enabled {
+		orderBy, offset = "id", 0
+	}
+
+	res, total, err := app.core.QueryBounces(campID, 0, source, orderBy, order, cur.afterID, offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -50,6 +60,7 @@ func handleGetBounces(c echo.Context) error {
 	out.Total = total
 	out.Page = pg.Page
 	out.PerPage = pg.PerPage
+	out.NextCursor = cur.next(len(res), pg.Limit, res[len(res)-1].ID)
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -65,7 +76,7 @@ func handleGetSubscriberBounces(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
 	}
 
-	out, _, err := app.core.QueryBounces(0, subID, "", "", "", 0, 1000)
+	out, _, err := app.core.QueryBounces(0, subID, "", "", "", 0, 0, 1000)
 	if err != nil {
 		return err
 	}
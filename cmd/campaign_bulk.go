@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// maxBulkCampaigns is the maximum number of campaigns that a bulk operation can act on.
+const maxBulkCampaigns = 10000
+
+const (
+	bulkTagsAdd    = "add"
+	bulkTagsRemove = "remove"
+	bulkTagsSet    = "set"
+)
+
+// campaignBulkReq is the request of bulk campaign operations.
+type campaignBulkReq struct {
+	IDs []int `json:"ids"`
+
+	// Tags.
+	Action string   `json:"action"`
+	Tags   []string `json:"tags"`
+
+	Archive    bool   `json:"archive"`
+	Status     string `json:"status"`
+	TemplateID int    `json:"template_id"`
+}
+
+// campaignBulkResp is the response of bulk campaign operations.
+type campaignBulkResp struct {
+	Updated int `json:"updated"`
+
+	// Errors by campaign ID for operations that are applied campaign by campaign.
+	Errors map[int]string `json:"errors,omitempty"`
+}
+
+// handleBulkDeleteCampaigns deletes (moves to the trash) multiple campaigns.
+func handleBulkDeleteCampaigns(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	ids, err := parseStringIDs(c.Request().URL.Query()["id"])
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+	}
+	if err := validateBulkCampaignIDs(ids, user, app); err != nil {
+		return err
+	}
+
+	n, err := app.core.DeleteCampaigns(ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		app.manager.DeleteCampaignTpls(id)
+	}
+
+	return c.JSON(http.StatusOK, okResp{campaignBulkResp{Updated: n}})
+}
+
+// handleBulkUpdateCampaignTags adds, removes, or replaces tags on multiple campaigns.
+func handleBulkUpdateCampaignTags(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req campaignBulkReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validateBulkCampaignIDs(req.IDs, user, app); err != nil {
+		return err
+	}
+
+	switch req.Action {
+	case bulkTagsAdd, bulkTagsRemove, bulkTagsSet:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+	}
+	if req.Tags == nil {
+		req.Tags = []string{}
+	}
+
+	n, err := app.core.UpdateCampaignsTags(req.IDs, req.Action, req.Tags)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{campaignBulkResp{Updated: n}})
+}
+
+// handleBulkUpdateCampaignArchive publishes or unpublishes multiple campaigns on the public archive.
+func handleBulkUpdateCampaignArchive(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req campaignBulkReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validateBulkCampaignIDs(req.IDs, user, app); err != nil {
+		return err
+	}
+
+	n, err := app.core.UpdateCampaignsArchive(req.IDs, req.Archive)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{campaignBulkResp{Updated: n}})
+}
+
+// handleBulkUpdateCampaignTemplate sets the template on multiple campaigns.
+// Running campaigns are skipped.
+func handleBulkUpdateCampaignTemplate(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req campaignBulkReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validateBulkCampaignIDs(req.IDs, user, app); err != nil {
+		return err
+	}
+
+	tpl, err := app.core.GetTemplate(req.TemplateID, true)
+	if err != nil {
+		return err
+	}
+	if tpl.Type != models.TemplateTypeCampaign {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "template_id"))
+	}
+
+	n, err := app.core.UpdateCampaignsTemplate(req.IDs, tpl.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range req.IDs {
+		app.manager.DeleteCampaignTpls(id)
+	}
+
+	return c.JSON(http.StatusOK, okResp{campaignBulkResp{Updated: n}})
+}
+
+// handleBulkUpdateCampaignStatus changes the status of multiple campaigns.
+// The change is applied campaign by campaign with the same checks as that of
+// a single campaign, and the campaigns that couldn't be changed are returned
+// with their errors.
+func handleBulkUpdateCampaignStatus(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req campaignBulkReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validateBulkCampaignIDs(req.IDs, user, app); err != nil {
+		return err
+	}
+
+	out := campaignBulkResp{Errors: map[int]string{}}
+	for _, id := range req.IDs {
+		if _, err := updateCampaignStatus(id, req.Status, user, app); err != nil {
+			if e, ok := err.(*echo.HTTPError); ok {
+				out.Errors[id] = fmt.Sprintf("%v", e.Message)
+			} else {
+				out.Errors[id] = err.Error()
+			}
+			continue
+		}
+		out.Updated++
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateBulkCampaignIDs validates the campaign IDs of a bulk operation and
+// checks whether the user can modify all of them.
+func validateBulkCampaignIDs(ids []int, user models.User, app *App) error {
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.errorNoIDs"))
+	}
+	if len(ids) > maxBulkCampaigns {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.errorBulkMax", "num", strconv.Itoa(maxBulkCampaigns)))
+	}
+	for _, id := range ids {
+		if id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+		}
+	}
+
+	return hasCampaignPerm(user, ids, true, app)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	checklistTestSent          = "test_sent"
+	checklistLinksChecked      = "links_checked"
+	checklistPreviewApproved   = "preview_approved"
+	checklistAudienceConfirmed = "audience_confirmed"
+)
+
+// campaignChecklistItems are the items on every campaign's pre-send checklist,
+// in order. test_sent is checked off automatically when a test message is sent.
+var campaignChecklistItems = []string{
+	checklistTestSent,
+	checklistLinksChecked,
+	checklistPreviewApproved,
+	checklistAudienceConfirmed,
+}
+
+// campChecklist is a campaign's pre-send checklist.
+type campChecklist struct {
+	Items []models.CampaignChecklistItem `json:"items"`
+
+	// Whether the checklist has to be complete before the campaign can be started.
+	Required bool `json:"required"`
+	Complete bool `json:"complete"`
+}
+
+// handleGetCampaignChecklist returns a campaign's pre-send checklist.
+func handleGetCampaignChecklist(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := getCampaignChecklist(id, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateCampaignChecklist checks or unchecks an item on a campaign's
+// pre-send checklist.
+func handleUpdateCampaignChecklist(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		Item string `json:"item"`
+		Done bool   `json:"done"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if !strSliceContains(req.Item, campaignChecklistItems) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "item"))
+	}
+
+	if err := app.core.SetCampaignChecklistItem(id, req.Item, req.Done, user.ID); err != nil {
+		return err
+	}
+
+	out, err := getCampaignChecklist(id, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getCampaignChecklist returns a campaign's pre-send checklist and whether
+// all of its items have been checked off.
+func getCampaignChecklist(id int, app *App) (campChecklist, error) {
+	items, err := app.core.GetCampaignChecklist(id, campaignChecklistItems)
+	if err != nil {
+		return campChecklist{}, err
+	}
+
+	out := campChecklist{
+		Items:    items,
+		Required: app.constants.RequireCampaignChecklist,
+		Complete: true,
+	}
+	for _, i := range items {
+		if !i.Done {
+			out.Complete = false
+			break
+		}
+	}
+
+	return out, nil
+}
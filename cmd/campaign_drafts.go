@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	campSectionContent  = "content"
+	campSectionAudience = "audience"
+	campSectionSettings = "settings"
+
+	// maxCampaignRevisions is the number of autosaved revisions retained per campaign.
+	maxCampaignRevisions = 20
+
+	// maxCampaignRevisionSize is the maximum size of an autosaved revision.
+	maxCampaignRevisionSize = 5 * 1024 * 1024
+)
+
+// campaignSections are the sections of a campaign that can be updated and
+// validated independently, and their fields (JSON keys).
+var campaignSections = map[string][]string{
+	campSectionContent: {"subject", "preheader", "content_type", "body", "altbody", "template_id",
+		"variants", "attachment_urls", "media"},
+	campSectionAudience: {"lists", "list_groups", "subscriber_query_id"},
+	campSectionSettings: {"name", "from_email", "tags", "messenger", "headers", "send_at", "send_at_tz", "event",
+		"archive", "archive_slug", "archive_template_id", "archive_meta"},
+}
+
+// handleCreateCampaignDraft creates a draft campaign with only a name (and
+// optionally, a template). The rest of the campaign is built incrementally by
+// updating its sections.
+func handleCreateCampaignDraft(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Name       string `json:"name"`
+			TemplateID int    `json:"template_id"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !strHasLen(req.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.fieldInvalidName"))
+	}
+
+	o := models.Campaign{
+		Type:           models.CampaignTypeRegular,
+		Name:           req.Name,
+		FromEmail:      app.constants.FromEmail,
+		ContentType:    models.CampaignContentTypeRichtext,
+		Messenger:      emailMsgr,
+		TemplateID:     req.TemplateID,
+		Headers:        make(models.Headers, 0),
+		Tags:           pq.StringArray{},
+		ArchiveMeta:    json.RawMessage("{}"),
+		ListGroupIDs:   pq.Int64Array{},
+		AttachmentURLs: pq.StringArray{},
+		Variants:       models.CampaignVariants{},
+	}
+	o.ArchiveTemplateID = o.TemplateID
+
+	out, err := app.core.CreateCampaign(o, []int{}, []int{})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateCampaignContent updates the content section of a campaign.
+func handleUpdateCampaignContent(c echo.Context) error {
+	return updateCampaignSection(c, campSectionContent)
+}
+
+// handleUpdateCampaignAudience updates the audience section of a campaign.
+func handleUpdateCampaignAudience(c echo.Context) error {
+	return updateCampaignSection(c, campSectionAudience)
+}
+
+// handleUpdateCampaignSettings updates the settings section of a campaign.
+func handleUpdateCampaignSettings(c echo.Context) error {
+	return updateCampaignSection(c, campSectionSettings)
+}
+
+// updateCampaignSection updates the fields of one section of a campaign with
+// PATCH semantics: only the fields in the request are updated, and only the
+// section is validated. Fields of other sections are not accepted.
+func updateCampaignSection(c echo.Context, section string) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.cantUpdate"))
+	}
+
+	// Resolve relative and timezone-less schedules.
+	if err := resolveSendAtReq(c, app); err != nil {
+		return err
+	}
+
+	// Read the request twice: once to know which fields were sent and
+	// once for their values.
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+
+	var (
+		keys map[string]json.RawMessage
+		req  campaignReq
+	)
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
+	out, err := saveCampaignSection(c, cm, section, keys, req, user, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// saveCampaignSection applies the fields (JSON keys) of a section from a
+// request to a campaign, validates the section, and saves the campaign.
+func saveCampaignSection(c echo.Context, cm models.Campaign, section string, keys map[string]json.RawMessage,
+	req campaignReq, user models.User, app *App) (models.Campaign, error) {
+	allowed := make(map[string]bool, len(campaignSections[section]))
+	for _, k := range campaignSections[section] {
+		allowed[k] = true
+	}
+
+	o := campaignReq{Campaign: cm, ListIDs: campaignListIDs(cm), MediaIDs: campaignMediaIDs(cm)}
+	for k := range keys {
+		if !allowed[k] {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
+				app.i18n.Ts("campaigns.fieldNotInSection", "name", k, "section", section))
+		}
+		setCampaignField(&o, req, k)
+	}
+
+	// Reject the update if the campaign has been edited by someone else in the meantime.
+	cur := campaignReq{Campaign: cm, ListIDs: campaignListIDs(cm), MediaIDs: campaignMediaIDs(cm)}
+	if err := checkVersion(c, cm.Version, cur, o, app, campaignSections[section]...); err != nil {
+		return models.Campaign{}, err
+	}
+
+	var err error
+	switch section {
+	case campSectionContent:
+		o, err = validateCampaignContent(o, app)
+	case campSectionAudience:
+		o, err = validateCampaignAudience(o, app)
+	case campSectionSettings:
+		o, err = validateCampaignSettings(o, app)
+	}
+	if err != nil {
+		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// The user should be able to send to the lists.
+	if section == campSectionAudience {
+		if err := hasCampaignListPerm(user, o.ListIDs, o.ListGroupIDs, app); err != nil {
+			return models.Campaign{}, err
+		}
+	}
+
+	// The saved subscriber query, if it has changed, should be accessible to the user.
+	if o.SubscriberQueryID.Valid && o.SubscriberQueryID != cm.SubscriberQueryID {
+		if _, err := getSavedSubscriberQuery(o.SubscriberQueryID.Int, user, app); err != nil {
+			return models.Campaign{}, err
+		}
+	}
+
+	out, err := app.core.UpdateCampaign(cm.ID, o.Campaign, o.ListIDs, o.MediaIDs)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	// Drop the compiled templates of the previous revision.
+	app.manager.DeleteCampaignTpls(cm.ID)
+
+	recordCampaignRevision(cm, out, user, app)
+
+	return out, nil
+}
+
+// handleAutosaveCampaign records the unsaved state of a campaign being edited
+// as a revision. The state is stored as-is and is not validated or applied
+// to the campaign.
+func handleAutosaveCampaign(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.cantUpdate"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxCampaignRevisionSize+1))
+	if err != nil || len(body) > maxCampaignRevisionSize {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+
+	// The revision should be a JSON object of campaign fields.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+
+	out, err := app.core.InsertCampaignRevision(id, models.CampaignRevisionAutosave, body, user.ID, maxCampaignRevisions)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignRevisions returns the revisions of a campaign, optionally
+// of a kind (?kind=autosave|update), without their data.
+func handleGetCampaignRevisions(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	kind := c.QueryParam("kind")
+	if kind != "" && kind != models.CampaignRevisionAutosave && kind != models.CampaignRevisionUpdate {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "kind"))
+	}
+
+	out, err := app.core.GetCampaignRevisions(id, kind)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignRevision returns a revision of a campaign with its data.
+func handleGetCampaignRevision(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		revID, _ = strconv.Atoi(c.Param("rev_id"))
+	)
+
+	if id < 1 || revID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignRevision(id, revID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// draftMissingSections returns the sections of a draft campaign that haven't
+// been filled in yet. Drafts created with only a name can't be started until
+// they have content and an audience.
+func draftMissingSections(cm models.Campaign) []string {
+	var out []string
+	if strings.TrimSpace(cm.Subject) == "" {
+		out = append(out, campSectionContent)
+	}
+	if len(campaignListIDs(cm)) == 0 && len(cm.ListGroupIDs) == 0 {
+		out = append(out, campSectionAudience)
+	}
+
+	return out
+}
+
+// setCampaignField copies the field with the given JSON key from one campaign request to another.
+func setCampaignField(o *campaignReq, req campaignReq, key string) {
+	switch key {
+	// Content.
+	case "subject":
+		o.Subject = req.Subject
+	case "preheader":
+		o.Preheader = req.Preheader
+	case "content_type":
+		o.ContentType = req.ContentType
+	case "body":
+		o.Body = req.Body
+	case "altbody":
+		o.AltBody = req.AltBody
+	case "template_id":
+		o.TemplateID = req.TemplateID
+	case "variants":
+		o.Variants = req.Variants
+	case "attachment_urls":
+		o.AttachmentURLs = req.AttachmentURLs
+	case "media":
+		o.MediaIDs = req.MediaIDs
+
+	// Audience.
+	case "lists":
+		o.ListIDs = req.ListIDs
+	case "list_groups":
+		o.ListGroupIDs = req.ListGroupIDs
+	case "subscriber_query_id":
+		o.SubscriberQueryID = req.SubscriberQueryID
+
+	// Settings.
+	case "name":
+		o.Name = req.Name
+	case "from_email":
+		o.FromEmail = req.FromEmail
+	case "tags":
+		o.Tags = req.Tags
+	case "messenger":
+		o.Messenger = req.Messenger
+	case "headers":
+		o.Headers = req.Headers
+	case "send_at":
+		o.SendAt = req.SendAt
+	case "send_at_tz":
+		o.SendAtTZ = req.SendAtTZ
+	case "event":
+		o.Event = req.Event
+	case "archive":
+		o.Archive = req.Archive
+	case "archive_slug":
+		o.ArchiveSlug = req.ArchiveSlug
+	case "archive_template_id":
+		o.ArchiveTemplateID = req.ArchiveTemplateID
+	case "archive_meta":
+		o.ArchiveMeta = req.ArchiveMeta
+	}
+}
+
+// campaignListIDs returns the IDs of the (non-deleted) lists of a campaign.
+func campaignListIDs(cm models.Campaign) []int {
+	var lists []struct {
+		ID int `json:"id"`
+	}
+	_ = json.Unmarshal(cm.Lists, &lists)
+
+	out := make([]int, 0, len(lists))
+	for _, l := range lists {
+		if l.ID > 0 {
+			out = append(out, l.ID)
+		}
+	}
+
+	return out
+}
+
+// campaignMediaIDs returns the IDs of the (non-deleted) media of a campaign.
+func campaignMediaIDs(cm models.Campaign) []int {
+	var media []struct {
+		ID int `json:"id"`
+	}
+	_ = json.Unmarshal(cm.Media, &media)
+
+	out := make([]int, 0, len(media))
+	for _, m := range media {
+		if m.ID > 0 {
+			out = append(out, m.ID)
+		}
+	}
+
+	return out
+}
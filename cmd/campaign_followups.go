@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// followupScheduleInterval is the interval at which the follow-ups of
+	// finished campaigns are scheduled.
+	followupScheduleInterval = time.Minute
+
+	// Maximum number of days after the parent campaign finishes that a follow-up is sent.
+	maxFollowupDelayDays = 365
+)
+
+// handleGetCampaignFollowups returns the chain of follow-ups below a campaign
+// with their audience sizes and send and engagement counts.
+func handleGetCampaignFollowups(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignFollowups(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpsertCampaignFollowup makes a draft campaign a follow-up of a campaign
+// that's automatically scheduled to the campaign's openers or clickers a
+// number of days after it finishes.
+func handleUpsertCampaignFollowup(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req models.CampaignFollowup
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.CampaignID < 1 || req.CampaignID == id {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "campaign_id"))
+	}
+
+	switch req.Audience {
+	case models.FollowupAudienceOpeners:
+		req.URL = ""
+	case models.FollowupAudienceClickers:
+		req.URL = strings.TrimSpace(req.URL)
+		if req.URL != "" && !strHasLen(req.URL, 1, stdInputMaxLen) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "audience"))
+	}
+
+	if req.DelayDays < 0 || req.DelayDays > maxFollowupDelayDays {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "delay_days"))
+	}
+
+	// The follow-up campaign is scheduled by the chain and has to be editable by the user.
+	if err := hasCampaignPerm(user, []int{req.CampaignID}, true, app); err != nil {
+		return err
+	}
+
+	parent, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if parent.Status == models.CampaignStatusCancelled {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.followupCancelled"))
+	}
+
+	camp, err := app.core.GetCampaign(req.CampaignID, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.Status != models.CampaignStatusDraft {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.followupNotDraft"))
+	}
+
+	if err := app.core.UpsertCampaignFollowup(id, req); err != nil {
+		return err
+	}
+
+	out, err := app.core.GetCampaignFollowups(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteCampaignFollowup removes a follow-up from a campaign's chain.
+func handleDeleteCampaignFollowup(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		id, _     = strconv.Atoi(c.Param("id"))
+		campID, _ = strconv.Atoi(c.Param("campID"))
+	)
+
+	if id < 1 || campID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteCampaignFollowup(id, campID); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// runFollowupScheduler periodically schedules the follow-ups of campaigns that
+// have finished to be sent after their delays, and cancels the follow-ups of
+// campaigns that were cancelled. This blocks and is meant to be run in a goroutine.
+func runFollowupScheduler(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() {
+			continue
+		}
+
+		res, err := app.core.ScheduleCampaignFollowups()
+		if err != nil {
+			continue
+		}
+
+		for _, f := range res {
+			app.log.Printf("follow-up campaign %d of campaign %d: %s", f.CampaignID, f.ParentID, f.Status)
+		}
+	}
+}
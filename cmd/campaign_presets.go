@@ -0,0 +1,378 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// maxUTMParamLen is the maximum length of a preset's UTM parameter.
+const maxUTMParamLen = 200
+
+var (
+	// Links in HTML (href="...") and Markdown ([text](...)) bodies that UTM
+	// parameters are added to.
+	regexpHTMLLink = regexp.MustCompile(`(href=["'])(https?://[^"'\s]+)`)
+	regexpMDLink   = regexp.MustCompile(`(\]\()(https?://[^)\s]+)`)
+)
+
+// handleGetCampaignPresets returns all campaign presets without their bodies.
+func handleGetCampaignPresets(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := app.core.GetCampaignPresets()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignPreset returns a single campaign preset.
+func handleGetCampaignPreset(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignPreset(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateCampaignPreset creates a new campaign preset.
+func handleCreateCampaignPreset(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	var o models.CampaignPreset
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateCampaignPreset(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateCampaignPreset(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateCampaignPresetFromCampaign saves a campaign's content, headers,
+// messenger, and lists as a new preset.
+func handleCreateCampaignPresetFromCampaign(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		Name        string             `json:"name"`
+		Description string             `json:"description"`
+		UTM         models.CampaignUTM `json:"utm"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	o := models.CampaignPreset{
+		Name:        req.Name,
+		Description: req.Description,
+		Subject:     cm.Subject,
+		Preheader:   cm.Preheader,
+		FromEmail:   cm.FromEmail,
+		Body:        cm.Body,
+		AltBody:     cm.AltBody,
+		ContentType: cm.ContentType,
+		TemplateID:  null.NewInt(cm.TemplateID, cm.TemplateID > 0),
+		Headers:     cm.Headers,
+		Messenger:   cm.Messenger,
+		Tags:        cm.Tags,
+		ListIDs:     pq.Int64Array{},
+		UTM:         req.UTM,
+	}
+	for _, lID := range campaignListIDs(cm) {
+		o.ListIDs = append(o.ListIDs, int64(lID))
+	}
+	if o.Name == "" {
+		o.Name = cm.Name
+	}
+
+	o, err = validateCampaignPreset(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateCampaignPreset(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateCampaignPreset updates a campaign preset.
+func handleUpdateCampaignPreset(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var o models.CampaignPreset
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateCampaignPreset(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateCampaignPreset(id, o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteCampaignPreset deletes a campaign preset.
+func handleDeleteCampaignPreset(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteCampaignPreset(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleInstantiateCampaignPreset creates a new campaign from a preset. The
+// preset's lists can be overridden in the request. If the campaign ends up
+// without lists, it's created as an incomplete draft whose audience can be
+// filled in later.
+func handleInstantiateCampaignPreset(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	// Resolve relative and timezone-less schedules.
+	if err := resolveSendAtReq(c, app); err != nil {
+		return err
+	}
+
+	var req struct {
+		Name     string    `json:"name"`
+		ListIDs  []int     `json:"lists"`
+		SendAt   null.Time `json:"send_at"`
+		SendAtTZ string    `json:"send_at_tz"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	p, err := app.core.GetCampaignPreset(id)
+	if err != nil {
+		return err
+	}
+
+	o := campaignReq{
+		Campaign: models.Campaign{
+			Type:           models.CampaignTypeRegular,
+			Name:           strings.TrimSpace(req.Name),
+			Subject:        p.Subject,
+			Preheader:      p.Preheader,
+			FromEmail:      p.FromEmail,
+			Body:           p.Body,
+			AltBody:        p.AltBody,
+			ContentType:    p.ContentType,
+			TemplateID:     int(p.TemplateID.Int),
+			Headers:        p.Headers,
+			Messenger:      p.Messenger,
+			Tags:           p.Tags,
+			SendAt:         req.SendAt,
+			SendAtTZ:       req.SendAtTZ,
+			ListGroupIDs:   pq.Int64Array{},
+			AttachmentURLs: pq.StringArray{},
+			Variants:       models.CampaignVariants{},
+		},
+		ListIDs:  req.ListIDs,
+		MediaIDs: []int{},
+	}
+	if o.Name == "" {
+		o.Name = p.Name
+	}
+	if o.Messenger == "" {
+		o.Messenger = emailMsgr
+	}
+	if o.ListIDs == nil {
+		for _, lID := range p.ListIDs {
+			o.ListIDs = append(o.ListIDs, int(lID))
+		}
+	}
+	o.Body = addUTMParams(o.Body, o.ContentType, p.UTM, o.Name)
+	o.ArchiveTemplateID = o.TemplateID
+
+	// Validate. The audience is only validated if there are lists.
+	if o, err = validateCampaignSettings(o, app); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(o.ListIDs) > 0 {
+		if o, err = validateCampaignAudience(o, app); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if o, err = validateCampaignContent(o, app); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// The user should be able to send to the lists.
+	if err := hasCampaignListPerm(user, o.ListIDs, o.ListGroupIDs, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateCampaignPreset validates the fields of a campaign preset.
+func validateCampaignPreset(o models.CampaignPreset, app *App) (models.CampaignPreset, error) {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.fieldInvalidName"))
+	}
+	if !strHasLen(o.Description, 0, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+
+	if o.ContentType == "" {
+		o.ContentType = models.CampaignContentTypeRichtext
+	}
+	if o.Messenger != "" && !app.manager.HasMessenger(o.Messenger) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("campaigns.fieldInvalidMessenger", "name", o.Messenger))
+	}
+	if o.TemplateID.Int < 1 {
+		o.TemplateID = null.Int{}
+	}
+
+	o.UTM.Source = strings.TrimSpace(o.UTM.Source)
+	o.UTM.Medium = strings.TrimSpace(o.UTM.Medium)
+	o.UTM.Campaign = strings.TrimSpace(o.UTM.Campaign)
+	for _, v := range []string{o.UTM.Source, o.UTM.Medium, o.UTM.Campaign} {
+		if !strHasLen(v, 0, maxUTMParamLen) {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "utm"))
+		}
+	}
+
+	return o, nil
+}
+
+// addUTMParams adds the UTM parameters to the http(s) links in a campaign body.
+// The campaign name is used as the utm_campaign if the preset has none. Links
+// with template expressions or that already have the parameters are left as-is.
+func addUTMParams(body, contentType string, utm models.CampaignUTM, campName string) string {
+	if utm.Source == "" && utm.Medium == "" {
+		return body
+	}
+
+	params := []string{}
+	for _, p := range [][2]string{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+	} {
+		if p[0] == "utm_campaign" && p[1] == "" {
+			p[1] = campName
+		}
+		if p[1] != "" {
+			params = append(params, p[0]+"="+url.QueryEscape(p[1]))
+		}
+	}
+
+	// Ampersands are escaped in HTML attributes.
+	sep := "&"
+	re := regexpHTMLLink
+	switch contentType {
+	case models.CampaignContentTypeMarkdown:
+		re = regexpMDLink
+	case models.CampaignContentTypePlain:
+		return body
+	default:
+		sep = "&amp;"
+	}
+	query := strings.Join(params, sep)
+
+	return re.ReplaceAllStringFunc(body, func(s string) string {
+		m := re.FindStringSubmatch(s)
+		prefix, link := m[1], m[2]
+		if strings.Contains(link, "{{") || strings.Contains(link, "utm_source=") {
+			return s
+		}
+
+		// Keep the @TrackLink shorthand and #fragments at the end.
+		var suffix string
+		if i := strings.Index(link, "@TrackLink"); i > -1 {
+			link, suffix = link[:i], link[i:]
+		}
+		if i := strings.Index(link, "#"); i > -1 {
+			link, suffix = link[:i], link[i:]+suffix
+		}
+
+		if strings.Contains(link, "?") {
+			link += sep + query
+		} else {
+			link += "?" + query
+		}
+
+		return prefix + link + suffix
+	})
+}
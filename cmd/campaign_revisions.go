@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/htmldiff"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// revisionFieldDiff is the difference in a content field between two
+// versions of a campaign.
+type revisionFieldDiff struct {
+	Field string        `json:"field"`
+	Ops   []htmldiff.Op `json:"ops"`
+}
+
+// handleGetCampaignRevisionDiff returns the differences in the content of a
+// revision and the saved campaign, or another revision (?to=id), field by field.
+// Only the fields that differ are returned.
+func handleGetCampaignRevisionDiff(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		revID, _ = strconv.Atoi(c.Param("rev_id"))
+		toID, _  = strconv.Atoi(c.QueryParam("to"))
+	)
+
+	if id < 1 || revID < 1 || toID < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	rev, err := app.core.GetCampaignRevision(id, revID)
+	if err != nil {
+		return err
+	}
+
+	// Compare against the saved campaign by default.
+	var to json.RawMessage
+	if toID > 0 {
+		r, err := app.core.GetCampaignRevision(id, toID)
+		if err != nil {
+			return err
+		}
+		to = r.Data
+	} else {
+		cm, err := app.core.GetCampaign(id, "", "")
+		if err != nil {
+			return err
+		}
+		if to, err = campaignContent(cm); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	fields, err := diffCampaignContent(rev.Data, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		From   int                 `json:"from"`
+		To     int                 `json:"to"`
+		Fields []revisionFieldDiff `json:"fields"`
+	}{revID, toID, fields}})
+}
+
+// handleRestoreCampaignRevision restores the content of a campaign from a
+// revision. The content that's replaced is itself recorded as a revision, so
+// a restore can be reverted.
+func handleRestoreCampaignRevision(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		user     = c.Get(auth.UserKey).(models.User)
+		id, _    = strconv.Atoi(c.Param("id"))
+		revID, _ = strconv.Atoi(c.Param("rev_id"))
+	)
+
+	if id < 1 || revID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.cantUpdate"))
+	}
+
+	rev, err := app.core.GetCampaignRevision(id, revID)
+	if err != nil {
+		return err
+	}
+
+	// Only the content fields of the revision are restored. Autosaved
+	// revisions have the other fields of the editor too.
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(rev.Data, &all); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+	keys := make(map[string]json.RawMessage)
+	for _, k := range campaignSections[campSectionContent] {
+		if v, ok := all[k]; ok {
+			keys[k] = v
+		}
+	}
+
+	var req campaignReq
+	if err := remarshal(keys, &req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
+	out, err := saveCampaignSection(c, cm, campSectionContent, keys, req, user, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// recordCampaignRevision records the content of a campaign as it was before
+// an update as a revision if the update changed it, so that accidental edits
+// can be reviewed and reverted. Errors are only logged as they shouldn't fail
+// the update.
+func recordCampaignRevision(prev, cur models.Campaign, user models.User, app *App) {
+	a, err := campaignContent(prev)
+	if err != nil {
+		app.log.Printf("error recording campaign revision: %v", err)
+		return
+	}
+	b, err := campaignContent(cur)
+	if err != nil {
+		app.log.Printf("error recording campaign revision: %v", err)
+		return
+	}
+	if bytes.Equal(a, b) {
+		return
+	}
+
+	if _, err := app.core.InsertCampaignRevision(prev.ID, models.CampaignRevisionUpdate, a, user.ID, maxCampaignRevisions); err != nil {
+		app.log.Printf("error recording campaign revision: %v", err)
+	}
+}
+
+// campaignContent returns the content section fields of a campaign as a JSON
+// object in the shape of a campaign update request.
+func campaignContent(cm models.Campaign) (json.RawMessage, error) {
+	var all map[string]json.RawMessage
+	if err := remarshal(campaignReq{Campaign: cm, MediaIDs: campaignMediaIDs(cm)}, &all); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]json.RawMessage, len(campaignSections[campSectionContent]))
+	for _, k := range campaignSections[campSectionContent] {
+		if v, ok := all[k]; ok {
+			out[k] = v
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// diffCampaignContent returns the differences in the content fields of two
+// versions of a campaign. Fields that are missing in either version (eg: in
+// older autosaves) are skipped. Language variants are compared by language.
+func diffCampaignContent(from, to json.RawMessage) ([]revisionFieldDiff, error) {
+	a, err := contentFields(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := contentFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []revisionFieldDiff{}
+	for _, k := range campaignSections[campSectionContent] {
+		if k == "variants" {
+			continue
+		}
+
+		av, aok := a[k]
+		bv, bok := b[k]
+		if !aok || !bok || av == bv {
+			continue
+		}
+		out = append(out, revisionFieldDiff{Field: k, Ops: htmldiff.Diff(av, bv)})
+	}
+
+	// Variants that were added or removed are compared against empty ones.
+	if _, ok := a["variants"]; !ok {
+		return out, nil
+	}
+	if _, ok := b["variants"]; !ok {
+		return out, nil
+	}
+
+	var (
+		keys = []string{}
+		seen = map[string]bool{}
+	)
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			if strings.HasPrefix(k, "variants.") && !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if a[k] != b[k] {
+			out = append(out, revisionFieldDiff{Field: k, Ops: htmldiff.Diff(a[k], b[k])})
+		}
+	}
+
+	return out, nil
+}
+
+// contentFields returns the content fields of a version of a campaign as text.
+// Strings are returned as-is and other values as JSON. The subjects and bodies
+// of language variants are returned as variants.<lang>.subject and
+// variants.<lang>.body along with a variants key.
+func contentFields(data json.RawMessage) (map[string]string, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(all))
+	for _, k := range campaignSections[campSectionContent] {
+		v, ok := all[k]
+		if !ok {
+			continue
+		}
+
+		if k == "variants" {
+			var vars models.CampaignVariants
+			if err := json.Unmarshal(v, &vars); err != nil {
+				return nil, err
+			}
+
+			out[k] = ""
+			for _, vr := range vars {
+				out[k+"."+vr.Lang+".subject"] = vr.Subject
+				out[k+"."+vr.Lang+".body"] = vr.Body
+			}
+			continue
+		}
+
+		var s *string
+		if err := json.Unmarshal(v, &s); err == nil {
+			if s != nil {
+				out[k] = *s
+			} else {
+				out[k] = ""
+			}
+			continue
+		}
+
+		var b bytes.Buffer
+		if err := json.Compact(&b, v); err != nil {
+			return nil, err
+		}
+		out[k] = b.String()
+	}
+
+	return out, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
@@ -32,8 +35,14 @@ type campaignReq struct {
 
 	MediaIDs []int `json:"media"`
 
-	// This is only relevant to campaign test requests.
+	// These are only relevant to campaign test requests.
 	SubscriberEmails pq.StringArray `json:"subscribers"`
+
+	// TestVariants sends the default content and every language variant to
+	// every test address, and TestTemplateIDs sends them with each of the
+	// templates instead of the campaign's template.
+	TestVariants    bool  `json:"test_variants"`
+	TestTemplateIDs []int `json:"test_template_ids"`
 }
 
 // campaignContentReq wraps params coming from API requests for converting
@@ -44,6 +53,32 @@ type campaignContentReq struct {
 	To   string `json:"to"`
 }
 
+const (
+	// Campaign bodies larger than this are stored compressed by default.
+	defaultCampaignBodyCompressSize = 100 * 1024
+
+	// maxCompareCampaigns is the maximum number of campaigns that can be compared at once.
+	maxCompareCampaigns = 10
+
+	// compareTopLinks is the number of top clicked links returned per campaign in comparisons.
+	compareTopLinks = 5
+
+	// maxAttachmentURLs is the maximum number of templated attachment URLs on a campaign.
+	maxAttachmentURLs = 10
+
+	// maxCampaignVariants is the maximum number of language variants on a campaign.
+	maxCampaignVariants = 50
+
+	// maxTestMessages is the maximum number of messages in a campaign test of the variant matrix.
+	maxTestMessages = 100
+
+	// dryRunMaxDomains is the number of top recipient domains returned in a campaign dry run.
+	dryRunMaxDomains = 20
+
+	// maxCampaignDomainStats is the number of top recipient domains returned in campaign domain stats.
+	maxCampaignDomainStats = 100
+)
+
 var (
 	regexFromAddress = regexp.MustCompile(`((.+?)\s)?<(.+?)@(.+?)>`)
 	regexSlug        = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]`)
@@ -52,26 +87,39 @@ var (
 // handleGetCampaigns handles retrieval of campaigns.
 func handleGetCampaigns(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		pg  = app.paginator.NewFromURL(c.Request().URL.Query())
-
-		status    = c.QueryParams()["status"]
-		tags      = c.QueryParams()["tag"]
-		query     = strings.TrimSpace(c.FormValue("query"))
-		orderBy   = c.FormValue("order_by")
-		order     = c.FormValue("order")
-		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		pg   = app.paginator.NewFromURL(c.Request().URL.Query())
+
+		status      = c.QueryParams()["status"]
+		tags        = c.QueryParams()["tag"]
+		query       = strings.TrimSpace(c.FormValue("query"))
+		orderBy     = c.FormValue("order_by")
+		order       = c.FormValue("order")
+		noBody, _   = strconv.ParseBool(c.QueryParam("no_body"))
+		folderID, _ = strconv.Atoi(c.QueryParam("folder_id"))
+		fields      = getFieldsParam(c)
 	)
 
-	res, total, err := app.core.QueryCampaigns(query, status, tags, orderBy, order, pg.Offset, pg.Limit)
+	// Only the campaigns of the lists that the user has access to.
+	getAll, permittedIDs := campaignListScope(user, false)
+
+	// Cursor pagination orders by ID.
+	cur, err := getPageCursor(c, app)
 	if err != nil {
 		return err
 	}
+	offset := pg.Offset
+	if cur.enabled {
+		orderBy, offset = "id", 0
+	}
 
-	if noBody {
-		for i := 0; i < len(res); i++ {
-			res[i].Body = ""
-		}
+	// Bodies are only fetched (and decompressed) if they're asked for.
+	withBody := !noBody && hasField(fields, "body")
+
+	res, total, err := app.core.QueryCampaigns(query, status, tags, folderID, orderBy, order, getAll, permittedIDs, withBody, cur.afterID, offset, pg.Limit)
+	if err != nil {
+		return err
 	}
 
 	var out models.PageResults
@@ -80,12 +128,19 @@ func handleGetCampaigns(c echo.Context) error {
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
+	// Only the requested fields.
+	results, err := sparseFields(res, fields)
+	if err != nil {
+		return err
+	}
+
 	// Meta.
 	out.Query = query
-	out.Results = res
+	out.Results = results
 	out.Total = total
 	out.Page = pg.Page
 	out.PerPage = pg.PerPage
+	out.NextCursor = cur.next(len(res), pg.Limit, res[len(res)-1].ID)
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -107,6 +162,156 @@ func handleGetCampaign(c echo.Context) error {
 		out.Body = ""
 	}
 
+	setETag(c, out.Version)
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignRSVPs returns the counts of RSVP responses to a campaign's calendar invite.
+func handleGetCampaignRSVPs(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignRSVPs(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignVariantStats returns the per-language variant stats of a campaign.
+func handleGetCampaignVariantStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignVariantStats(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignDomainStats returns the per-recipient-domain delivery, bounce,
+// and engagement stats of a campaign.
+func handleGetCampaignDomainStats(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignDomainStats(id, maxCampaignDomainStats)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateCampaignRetry creates a retry of a finished campaign that's sent
+// to its soft-bounced recipients after a delay.
+func handleCreateCampaignRetry(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		Delay string `json:"delay"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	var delay time.Duration
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "delay"))
+		}
+		delay = d
+	}
+
+	camp, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if camp.Status != models.CampaignStatusFinished {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.retryNotFinished"))
+	}
+
+	if camp.RetryAttempt >= app.constants.BounceRetryMaxAttempts {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.retryMaxAttempts", "num", strconv.Itoa(app.constants.BounceRetryMaxAttempts)))
+	}
+
+	// The campaign can only be retried once, and only if there are soft bounces to retry.
+	retries, err := app.core.GetCampaignRetries(id)
+	if err != nil {
+		return err
+	}
+	for _, r := range retries {
+		if r.RetryOf == id {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.retryExists"))
+		}
+	}
+	if len(retries) == 0 || retries[0].SoftBounces == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.retryNoBounces"))
+	}
+
+	// Name the retry after the original campaign, stripping the attempt suffix of a retry being retried.
+	name := camp.Name
+	if camp.RetryAttempt > 0 {
+		name = strings.TrimSuffix(name, app.i18n.Ts("campaigns.retryName", "name", "", "num", strconv.Itoa(camp.RetryAttempt)))
+	}
+
+	out, err := app.core.CreateCampaignRetry(id,
+		app.i18n.Ts("campaigns.retryName", "name", name, "num", strconv.Itoa(camp.RetryAttempt+1)), time.Now().Add(delay))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignRetries returns a campaign and the chain of its soft-bounce
+// retries with their send, bounce, and engagement counts.
+func handleGetCampaignRetries(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetCampaignRetries(id)
+	if err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -128,9 +333,14 @@ func handlePreviewCampaign(c echo.Context) error {
 	}
 
 	// There's a body in the request to preview instead of the body in the DB.
+	// With ?lang=, it's the body of the language variant.
 	if c.Request().Method == http.MethodPost {
 		camp.ContentType = c.FormValue("content_type")
-		camp.Body = c.FormValue("body")
+		if v := camp.Variant(c.FormValue("lang")); v != nil {
+			v.Body = c.FormValue("body")
+		} else {
+			camp.Body = c.FormValue("body")
+		}
 	}
 
 	// Use a dummy campaign ID to prevent views and clicks from {{ TrackView }}
@@ -142,8 +352,10 @@ func handlePreviewCampaign(c echo.Context) error {
 			app.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 	}
 
-	// Render the message body.
-	msg, err := app.manager.NewCampaignMessage(&camp, dummySubscriber)
+	// Render the message body. ?lang= previews the campaign's variant for the language.
+	sub := dummySubscriber
+	sub.Lang = c.FormValue("lang")
+	msg, err := app.manager.NewCampaignMessage(&camp, sub)
 	if err != nil {
 		app.log.Printf("error rendering message: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest,
@@ -154,7 +366,81 @@ func handlePreviewCampaign(c echo.Context) error {
 		return c.String(http.StatusOK, string(msg.Body()))
 	}
 
-	return c.HTML(http.StatusOK, string(msg.Body()))
+	// Optionally, show the message the way dark mode clients would.
+	body := msg.Body()
+	if c.QueryParam("dark") == "true" || c.FormValue("dark") == "true" {
+		body = manager.SimulateDarkMode(body)
+	}
+
+	return c.HTML(http.StatusOK, string(body))
+}
+
+// handleRenderCampaign renders a campaign for a given subscriber and returns
+// the message exactly as it is (or would be) sent to them, including the
+// subscriber's tracking and unsubscribe links. It's rendered from the campaign's
+// current content, and the returned hash of the body can be used to tell
+// whether two renders are identical.
+func handleRenderCampaign(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		user     = c.Get(auth.UserKey).(models.User)
+		id, _    = strconv.Atoi(c.Param("id"))
+		subID, _ = strconv.Atoi(c.Param("subscriber_id"))
+	)
+
+	if id < 1 || subID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := hasSubPerm(user, []int{subID}, app); err != nil {
+		return err
+	}
+
+	camp, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	sub, err := app.core.GetSubscriber(subID, "", "")
+	if err != nil {
+		return err
+	}
+
+	if err := app.manager.CompileCampaign(&camp); err != nil {
+		app.log.Printf("error compiling template: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	msg, err := app.manager.NewCampaignMessage(&camp, sub)
+	if err != nil {
+		app.log.Printf("error rendering message: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	body := msg.Body()
+	out := struct {
+		Subject     string         `json:"subject"`
+		FromEmail   string         `json:"from_email"`
+		ToEmail     string         `json:"to_email"`
+		ContentType string         `json:"content_type"`
+		Headers     models.Headers `json:"headers"`
+		Body        string         `json:"body"`
+		AltBody     string         `json:"altbody"`
+		Hash        string         `json:"hash"`
+	}{
+		Subject:     msg.Subject(),
+		FromEmail:   camp.FromEmail,
+		ToEmail:     sub.Email,
+		ContentType: camp.ContentType,
+		Headers:     camp.Headers,
+		Body:        string(body),
+		AltBody:     string(msg.AltBody()),
+		Hash:        fmt.Sprintf("%x", sha256.Sum256(body)),
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // handleCampaignContent handles campaign content (body) format conversions.
@@ -185,10 +471,16 @@ func handleCampaignContent(c echo.Context) error {
 // Newly created campaigns are always drafts.
 func handleCreateCampaign(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		o   campaignReq
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		o    campaignReq
 	)
 
+	// Resolve relative and timezone-less schedules.
+	if err := resolveSendAtReq(c, app); err != nil {
+		return err
+	}
+
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
@@ -222,6 +514,18 @@ func handleCreateCampaign(c echo.Context) error {
 		o.ArchiveTemplateID = o.TemplateID
 	}
 
+	// The user should be able to send to the lists.
+	if err := hasCampaignListPerm(user, o.ListIDs, o.ListGroupIDs, app); err != nil {
+		return err
+	}
+
+	// The saved subscriber query, if any, should be accessible to the user.
+	if o.SubscriberQueryID.Valid {
+		if _, err := getSavedSubscriberQuery(o.SubscriberQueryID.Int, user, app); err != nil {
+			return err
+		}
+	}
+
 	out, err := app.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
 	if err != nil {
 		return err
@@ -235,6 +539,7 @@ func handleCreateCampaign(c echo.Context) error {
 func handleUpdateCampaign(c echo.Context) error {
 	var (
 		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
 		id, _ = strconv.Atoi(c.Param("id"))
 	)
 
@@ -252,6 +557,11 @@ func handleUpdateCampaign(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.cantUpdate"))
 	}
 
+	// Resolve relative and timezone-less schedules.
+	if err := resolveSendAtReq(c, app); err != nil {
+		return err
+	}
+
 	// Read the incoming params into the existing campaign fields from the DB.
 	// This allows updating of values that have been sent whereas fields
 	// that are not in the request retain the old values.
@@ -260,17 +570,47 @@ func handleUpdateCampaign(c echo.Context) error {
 		return err
 	}
 
+	// Reject the update if the campaign has been edited by someone else in the meantime.
+	// Lists and media that aren't in the request aren't a part of the diff.
+	cur, upd := campaignReq{Campaign: cm, ListIDs: campaignListIDs(cm), MediaIDs: campaignMediaIDs(cm)}, o
+	if upd.ListIDs == nil {
+		upd.ListIDs = cur.ListIDs
+	}
+	if upd.MediaIDs == nil {
+		upd.MediaIDs = cur.MediaIDs
+	}
+	if err := checkVersion(c, cm.Version, cur, upd, app); err != nil {
+		return err
+	}
+
 	if c, err := validateCampaignFields(o, app); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	} else {
 		o = c
 	}
 
+	// The user should be able to send to the lists.
+	if err := hasCampaignListPerm(user, o.ListIDs, o.ListGroupIDs, app); err != nil {
+		return err
+	}
+
+	// The saved subscriber query, if it has changed, should be accessible to the user.
+	if o.SubscriberQueryID.Valid && o.SubscriberQueryID != cm.SubscriberQueryID {
+		if _, err := getSavedSubscriberQuery(o.SubscriberQueryID.Int, user, app); err != nil {
+			return err
+		}
+	}
+
 	out, err := app.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
 	if err != nil {
 		return err
 	}
 
+	// Drop the compiled templates of the previous revision.
+	app.manager.DeleteCampaignTpls(id)
+
+	recordCampaignRevision(cm, out, user, app)
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -278,6 +618,7 @@ func handleUpdateCampaign(c echo.Context) error {
 func handleUpdateCampaignStatus(c echo.Context) error {
 	var (
 		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
 		id, _ = strconv.Atoi(c.Param("id"))
 	)
 
@@ -293,16 +634,79 @@ func handleUpdateCampaignStatus(c echo.Context) error {
 		return err
 	}
 
-	out, err := app.core.UpdateCampaignStatus(id, o.Status)
+	out, err := updateCampaignStatus(id, o.Status, user, app)
 	if err != nil {
 		return err
 	}
 
-	if o.Status == models.CampaignStatusPaused || o.Status == models.CampaignStatusCancelled {
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// updateCampaignStatus changes the status of a campaign after checking that
+// drafts are complete and that campaigns being started are within the
+// message size budgets.
+func updateCampaignStatus(id int, status string, user models.User, app *App) (models.Campaign, error) {
+	// Drafts built incrementally should have all their sections before they're started.
+	if status == models.CampaignStatusRunning || status == models.CampaignStatusScheduled {
+		cm, err := app.core.GetCampaign(id, "", "")
+		if err != nil {
+			return models.Campaign{}, err
+		}
+		if cm.Status == models.CampaignStatusDraft {
+			if m := draftMissingSections(cm); len(m) > 0 {
+				return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
+					app.i18n.Ts("campaigns.draftIncomplete", "sections", strings.Join(m, ", ")))
+			}
+
+			// The pre-send checklist, if it's required, should be complete.
+			if app.constants.RequireCampaignChecklist {
+				cl, err := getCampaignChecklist(id, app)
+				if err != nil {
+					return models.Campaign{}, err
+				}
+				if !cl.Complete {
+					return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.checklistIncomplete"))
+				}
+			}
+		}
+	}
+
+	// Campaigns over the message size and image weight budgets, or that don't
+	// meet the compliance profiles of their lists, can't be started. The
+	// preflight check, which fetches remote images, includes the compliance
+	// check and is only run if there are budgets.
+	if status == models.CampaignStatusRunning || status == models.CampaignStatusScheduled {
+		var errs []string
+		if app.constants.MessageSizeLimit > 0 || app.constants.ImageWeightLimit > 0 {
+			p, err := runCampaignPreflight(id, app)
+			if err != nil {
+				return models.Campaign{}, err
+			}
+			errs = p.Errors
+		} else {
+			cc, err := checkCampaignCompliance(id, app)
+			if err != nil {
+				return models.Campaign{}, err
+			}
+			errs = cc.Errors
+		}
+
+		if len(errs) > 0 {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, strings.Join(errs, " "))
+		}
+	}
+
+	out, err := app.core.UpdateCampaignStatus(id, status)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	app.core.RecordCampaignEvent(id, out.Name, status, user.ID)
+
+	if status == models.CampaignStatusPaused || status == models.CampaignStatusCancelled {
 		app.manager.StopCampaign(id)
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	return out, nil
 }
 
 // handleUpdateCampaignArchive handles campaign status modification.
@@ -339,6 +743,28 @@ func handleUpdateCampaignArchive(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{req})
 }
 
+// handleUpdateCampaignSimulate handles switching a campaign in or out of simulation.
+func handleUpdateCampaignSimulate(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	req := struct {
+		Simulate bool `json:"simulate"`
+	}{}
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := app.core.UpdateCampaignSimulate(id, req.Simulate); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{req})
+}
+
 // handleDeleteCampaign handles campaign deletion.
 // Only scheduled campaigns that have not started yet can be deleted.
 func handleDeleteCampaign(c echo.Context) error {
@@ -354,6 +780,7 @@ func handleDeleteCampaign(c echo.Context) error {
 	if err := app.core.DeleteCampaign(id); err != nil {
 		return err
 	}
+	app.manager.DeleteCampaignTpls(id)
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
@@ -361,7 +788,8 @@ func handleDeleteCampaign(c echo.Context) error {
 // handleGetRunningCampaignStats returns stats of a given set of campaign IDs.
 func handleGetRunningCampaignStats(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
 	)
 
 	out, err := app.core.GetRunningCampaignStats()
@@ -369,6 +797,27 @@ func handleGetRunningCampaignStats(c echo.Context) error {
 		return err
 	}
 
+	// Only the campaigns of the lists that the user has access to.
+	if all, listIDs := campaignListScope(user, false); !all && len(out) > 0 {
+		ids := make([]int, 0, len(out))
+		for _, c := range out {
+			ids = append(ids, c.ID)
+		}
+
+		has, err := app.core.HasCampaignLists(ids, listIDs)
+		if err != nil {
+			return err
+		}
+
+		res := out[:0]
+		for _, c := range out {
+			if has[c.ID] {
+				res = append(res, c)
+			}
+		}
+		out = res
+	}
+
 	if len(out) == 0 {
 		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
 	}
@@ -397,11 +846,54 @@ func handleGetRunningCampaignStats(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// handleCampaignDryRun resolves a campaign's audience without sending it and
+// returns the recipient counts and the estimated time to send at the current
+// rate settings.
+func handleCampaignDryRun(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	camp, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.GetCampaignSendPlan(camp, dryRunMaxDomains)
+	if err != nil {
+		return err
+	}
+
+	// Messages per second is the number of concurrent workers x the per-worker
+	// rate, capped by the sliding window rate, if it's enabled.
+	rate := float64(app.constants.Concurrency * app.constants.MessageRate)
+	if app.constants.SlidingWindow && app.constants.SlidingWindowDuration > 0 && app.constants.SlidingWindowRate > 0 {
+		if r := float64(app.constants.SlidingWindowRate) / app.constants.SlidingWindowDuration.Seconds(); r < rate {
+			rate = r
+		}
+	}
+
+	if rate > 0 {
+		d := time.Duration(float64(out.Recipients) / rate * float64(time.Second)).Round(time.Second)
+		out.Rate = rate
+		out.EstimatedSecs = int(d.Seconds())
+		out.EstimatedTime = d.String()
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handleTestCampaign handles the sending of a campaign message to
 // arbitrary subscribers for testing.
 func handleTestCampaign(c echo.Context) error {
 	var (
 		app       = c.Get("app").(*App)
+		user      = c.Get(auth.UserKey).(models.User)
 		campID, _ = strconv.Atoi(c.Param("id"))
 		tplID, _  = strconv.Atoi(c.FormValue("template_id"))
 		req       campaignReq
@@ -441,21 +933,26 @@ func handleTestCampaign(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	applyTestCampaignReq(&camp, req)
 
-	// Override certain values from the DB with incoming values.
-	camp.Name = req.Name
-	camp.Subject = req.Subject
-	camp.FromEmail = req.FromEmail
-	camp.Body = req.Body
-	camp.AltBody = req.AltBody
-	camp.Messenger = req.Messenger
-	camp.ContentType = req.ContentType
-	camp.Headers = req.Headers
-	camp.TemplateID = req.TemplateID
-	for _, id := range req.MediaIDs {
-		if id > 0 {
-			camp.MediaIDs = append(camp.MediaIDs, int64(id))
+	// Send every variant to every test address and report the result of each message.
+	if req.TestVariants || len(req.TestTemplateIDs) > 0 {
+		out, err := sendTestMatrix(camp, subs, req, app)
+		if err != nil {
+			return err
 		}
+
+		// Check off the test on the campaign's pre-send checklist if any message was sent.
+		for _, r := range out {
+			if r.Sent {
+				if err := app.core.SetCampaignChecklistItem(campID, checklistTestSent, true, user.ID); err != nil {
+					return err
+				}
+				break
+			}
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
 	}
 
 	// Send the test messages.
@@ -469,13 +966,40 @@ func handleTestCampaign(c echo.Context) error {
 		}
 	}
 
+	// Check off the test on the campaign's pre-send checklist.
+	if err := app.core.SetCampaignChecklistItem(campID, checklistTestSent, true, user.ID); err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// applyTestCampaignReq overrides the values of a campaign from the DB with the
+// unsaved values in a test request.
+func applyTestCampaignReq(camp *models.Campaign, req campaignReq) {
+	camp.Name = req.Name
+	camp.Subject = req.Subject
+	camp.Preheader = req.Preheader
+	camp.Variants = req.Variants
+	camp.FromEmail = req.FromEmail
+	camp.Body = req.Body
+	camp.AltBody = req.AltBody
+	camp.Messenger = req.Messenger
+	camp.ContentType = req.ContentType
+	camp.Headers = req.Headers
+	camp.TemplateID = req.TemplateID
+	for _, id := range req.MediaIDs {
+		if id > 0 {
+			camp.MediaIDs = append(camp.MediaIDs, int64(id))
+		}
+	}
+}
+
 // handleGetCampaignViewAnalytics retrieves view counts for a campaign.
 func handleGetCampaignViewAnalytics(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
 
 		typ  = c.Param("type")
 		from = c.QueryParams().Get("from")
@@ -497,6 +1021,10 @@ func handleGetCampaignViewAnalytics(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("analytics.invalidDates"))
 	}
 
+	if err := hasCampaignPerm(user, ids, false, app); err != nil {
+		return err
+	}
+
 	// Campaign link stats.
 	if typ == "links" {
 		out, err := app.core.GetCampaignAnalyticsLinks(ids, typ, from, to)
@@ -516,6 +1044,49 @@ func handleGetCampaignViewAnalytics(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// handleCompareCampaigns returns side-by-side performance metrics of the
+// campaigns in ?ids=1,2,3.
+func handleCompareCampaigns(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var strIDs []string
+	for _, v := range c.QueryParams()["ids"] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				strIDs = append(strIDs, id)
+			}
+		}
+	}
+
+	ids, err := parseStringIDs(strIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+	}
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("globals.messages.missingFields", "name", "`ids`"))
+	}
+	if len(ids) > maxCompareCampaigns {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.tooManyToCompare", "num", strconv.Itoa(maxCompareCampaigns)))
+	}
+
+	if err := hasCampaignPerm(user, ids, false, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.GetCampaignComparison(ids, compareTopLinks)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // sendTestMessage takes a campaign and a subscriber and sends out a sample campaign message.
 func sendTestMessage(sub models.Subscriber, camp *models.Campaign, app *App) error {
 	if err := camp.CompileTemplate(app.manager.TemplateFuncs(camp)); err != nil {
@@ -535,8 +1106,142 @@ func sendTestMessage(sub models.Subscriber, camp *models.Campaign, app *App) err
 	return app.manager.PushCampaignMessage(msg)
 }
 
+// testSendResult is the result of a message in a campaign test of the variant matrix.
+type testSendResult struct {
+	Email      string `json:"email"`
+	Variant    string `json:"variant"`
+	Lang       string `json:"lang"`
+	TemplateID int    `json:"template_id"`
+	Sent       bool   `json:"sent"`
+	Error      string `json:"error"`
+}
+
+// sendTestMatrix sends the default content and the language variants of a
+// campaign, with each of the test templates if there are any, to every test
+// subscriber. The subjects are prefixed with the variant, eg: [pt-BR] or
+// [Newsletter / default]. Errors are recorded per message and the remaining
+// messages are still sent.
+func sendTestMatrix(camp models.Campaign, subs models.Subscribers, req campaignReq, app *App) ([]testSendResult, error) {
+	type tplVariant struct {
+		id   int
+		name string
+		camp models.Campaign
+	}
+
+	// Templates. Without test templates, the campaign's template is used.
+	tpls := []tplVariant{{id: camp.TemplateID, camp: camp}}
+	if len(req.TestTemplateIDs) > 0 {
+		tpls = tpls[:0]
+		for _, id := range req.TestTemplateIDs {
+			tpl, err := app.core.GetTemplate(id, true)
+			if err != nil {
+				return nil, err
+			}
+			if tpl.Type != models.TemplateTypeCampaign {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "test_template_ids"))
+			}
+
+			c, err := app.core.GetCampaignForPreview(camp.ID, id)
+			if err != nil {
+				return nil, err
+			}
+			applyTestCampaignReq(&c, req)
+			c.TemplateID = id
+
+			tpls = append(tpls, tplVariant{id: id, name: tpl.Name, camp: c})
+		}
+	}
+
+	// Content variants. An empty language is the default content.
+	langs := []string{""}
+	if req.TestVariants {
+		for _, v := range camp.Variants {
+			langs = append(langs, v.Lang)
+		}
+	}
+
+	if n := len(tpls) * len(langs) * len(subs); n > maxTestMessages {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.tooManyTestMessages", "num", strconv.Itoa(maxTestMessages)))
+	}
+
+	out := make([]testSendResult, 0, len(tpls)*len(langs)*len(subs))
+
+	// Test addresses that aren't subscribers.
+	known := make(map[string]bool, len(subs))
+	for _, s := range subs {
+		known[strings.ToLower(s.Email)] = true
+	}
+	for _, e := range req.SubscriberEmails {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !known[e] {
+			out = append(out, testSendResult{Email: e,
+				Error: app.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}")})
+		}
+	}
+	for _, t := range tpls {
+		for _, lang := range langs {
+			label := lang
+			if label == "" {
+				label = "default"
+			}
+			if len(req.TestTemplateIDs) > 0 {
+				label = t.name + " / " + label
+			}
+			prefix := "[" + label + "] "
+
+			// Prefix the subjects of the default content and the variants on a
+			// copy so that the variants of the other messages aren't affected.
+			c := t.camp
+			c.Subject = prefix + c.Subject
+			c.Variants = make(models.CampaignVariants, 0, len(t.camp.Variants))
+			for _, v := range t.camp.Variants {
+				v.Subject = prefix + v.Subject
+				c.Variants = append(c.Variants, v)
+			}
+
+			for _, s := range subs {
+				// The subscriber's language picks the variant.
+				sub := s
+				sub.Lang = lang
+
+				res := testSendResult{Email: sub.Email, Variant: label, Lang: lang, TemplateID: t.id}
+				cc := c
+				if err := sendTestMessage(sub, &cc, app); err != nil {
+					app.log.Printf("error sending test message (%s) to %s: %v", label, sub.Email, err)
+					res.Error = err.Error()
+					if e, ok := err.(*echo.HTTPError); ok {
+						res.Error = fmt.Sprintf("%v", e.Message)
+					}
+				} else {
+					res.Sent = true
+				}
+
+				out = append(out, res)
+			}
+		}
+	}
+
+	return out, nil
+}
+
 // validateCampaignFields validates incoming campaign field values.
 func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
+	c, err := validateCampaignSettings(c, app)
+	if err != nil {
+		return c, err
+	}
+
+	if c, err = validateCampaignAudience(c, app); err != nil {
+		return c, err
+	}
+
+	return validateCampaignContent(c, app)
+}
+
+// validateCampaignSettings validates the name, sender, messenger, schedule,
+// and archive fields of a campaign.
+func validateCampaignSettings(c campaignReq, app *App) (campaignReq, error) {
 	if c.FromEmail == "" {
 		c.FromEmail = app.constants.FromEmail
 	} else if !regexFromAddress.Match([]byte(c.FromEmail)) {
@@ -549,11 +1254,6 @@ func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
 		return c, errors.New(app.i18n.T("campaigns.fieldInvalidName"))
 	}
 
-	// Larger char limit for subject as it can contain {{ go templating }} logic.
-	if !strHasLen(c.Subject, 1, 5000) {
-		return c, errors.New(app.i18n.T("campaigns.fieldInvalidSubject"))
-	}
-
 	// If there's a "send_at" date, it should be in the future.
 	if c.SendAt.Valid {
 		if c.SendAt.Time.Before(time.Now()) {
@@ -561,17 +1261,41 @@ func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
 		}
 	}
 
-	if len(c.ListIDs) == 0 {
-		return c, errors.New(app.i18n.T("campaigns.fieldInvalidListIDs"))
+	// The schedule's timezone, which is only for display, defaults to the instance's.
+	if c.SendAtTZ != "" {
+		if _, err := time.LoadLocation(c.SendAtTZ); err != nil {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidSendAtTZ", "name", c.SendAtTZ))
+		}
+	} else if c.SendAt.Valid {
+		c.SendAtTZ = app.constants.DefaultTimezone
 	}
 
 	if !app.manager.HasMessenger(c.Messenger) {
 		return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidMessenger", "name", c.Messenger))
 	}
 
-	camp := models.Campaign{Body: c.Body, TemplateBody: tplTag}
-	if err := c.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
-		return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
+	// A zero folder ID is no folder. Others should exist.
+	if c.FolderID.Int < 1 {
+		c.FolderID.Valid = false
+	} else if _, err := app.core.GetFolder(int(c.FolderID.Int)); err != nil {
+		return c, errors.New(app.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.folder}"))
+	}
+
+	// An event without a title or a start time is no event.
+	if e := c.Event; e != nil {
+		if e.Title == "" && e.StartAt.IsZero() {
+			c.Event = nil
+		} else {
+			if !strHasLen(e.Title, 1, stdInputMaxLen) || e.StartAt.IsZero() {
+				return c, errors.New(app.i18n.T("campaigns.fieldInvalidEvent"))
+			}
+			if e.EndAt.IsZero() {
+				e.EndAt = e.StartAt.Add(time.Hour)
+			}
+			if e.EndAt.Before(e.StartAt) {
+				return c, errors.New(app.i18n.T("campaigns.fieldInvalidEvent"))
+			}
+		}
 	}
 
 	if len(c.Headers) == 0 {
@@ -597,6 +1321,109 @@ func validateCampaignFields(c campaignReq, app *App) (campaignReq, error) {
 	return c, nil
 }
 
+// validateCampaignAudience validates the lists, list groups, and the saved
+// subscriber query that a campaign targets.
+func validateCampaignAudience(c campaignReq, app *App) (campaignReq, error) {
+	// A campaign should target at least one list or list group.
+	if len(c.ListIDs) == 0 && len(c.ListGroupIDs) == 0 {
+		return c, errors.New(app.i18n.T("campaigns.fieldInvalidListIDs"))
+	}
+	if c.ListGroupIDs == nil {
+		c.ListGroupIDs = pq.Int64Array{}
+	}
+
+	// A zero subscriber query ID is no query.
+	if c.SubscriberQueryID.Int < 1 {
+		c.SubscriberQueryID.Valid = false
+	}
+
+	return c, nil
+}
+
+// validateCampaignContent validates the subject, preheader, body, language
+// variants, and attachments of a campaign.
+func validateCampaignContent(c campaignReq, app *App) (campaignReq, error) {
+	// Larger char limit for subject as it can contain {{ go templating }} logic.
+	if !strHasLen(c.Subject, 1, 5000) {
+		return c, errors.New(app.i18n.T("campaigns.fieldInvalidSubject"))
+	}
+
+	// The preheader can have {{ templating }} logic too.
+	c.Preheader = strings.TrimSpace(c.Preheader)
+	if !strHasLen(c.Preheader, 0, 2000) {
+		return c, errors.New(app.i18n.T("campaigns.fieldInvalidPreheader"))
+	}
+
+	// Templated per-subscriber attachment URLs.
+	if len(c.AttachmentURLs) > maxAttachmentURLs {
+		return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidAttachmentURLs", "num", strconv.Itoa(maxAttachmentURLs)))
+	}
+	urls := make(pq.StringArray, 0, len(c.AttachmentURLs))
+	for _, u := range c.AttachmentURLs {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if !strHasLen(u, 1, 2000) || !(strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "{{")) {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidAttachmentURLs", "num", strconv.Itoa(maxAttachmentURLs)))
+		}
+		urls = append(urls, u)
+	}
+	c.AttachmentURLs = urls
+
+	// Language variants.
+	if len(c.Variants) > maxCampaignVariants {
+		return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidVariants", "num", strconv.Itoa(maxCampaignVariants)))
+	}
+	if c.Variants == nil {
+		c.Variants = models.CampaignVariants{}
+	}
+	langs := make(map[string]bool, len(c.Variants))
+	for i, v := range c.Variants {
+		v.Lang = strings.ToLower(strings.TrimSpace(v.Lang))
+		if v.Lang == "" || len(v.Lang) > 6 || reLangCode.MatchString(v.Lang) || langs[v.Lang] {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidVariant", "lang", v.Lang))
+		}
+		if !strHasLen(v.Subject, 1, 5000) || strings.TrimSpace(v.Body) == "" {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidVariant", "lang", v.Lang))
+		}
+		langs[v.Lang] = true
+		c.Variants[i] = v
+	}
+
+	camp := models.Campaign{Body: c.Body, TemplateBody: tplTag}
+	if err := c.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
+		return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
+	}
+
+	// The subject and preheader are rendered for every subscriber. Render them
+	// against a dummy subscriber to catch errors that only surface at runtime.
+	var b bytes.Buffer
+	msg := manager.CampaignMessage{Campaign: &c.Campaign, Subscriber: dummySubscriber}
+	if c.SubjectTpl != nil {
+		if err := c.SubjectTpl.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidSubjectTpl", "error", err.Error()))
+		}
+	}
+	for _, v := range c.Variants {
+		if v.SubjectTpl == nil {
+			continue
+		}
+		b.Reset()
+		if err := v.SubjectTpl.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidSubjectTpl", "error", v.Lang+": "+err.Error()))
+		}
+	}
+	if c.PreheaderTpl != nil {
+		b.Reset()
+		if err := c.PreheaderTpl.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+			return c, errors.New(app.i18n.Ts("campaigns.fieldInvalidPreheaderTpl", "error", err.Error()))
+		}
+	}
+
+	return c, nil
+}
+
 // canEditCampaign returns true if a campaign is in a status where updating
 // its properties is allowed.
 func canEditCampaign(status string) bool {
@@ -605,6 +1432,96 @@ func canEditCampaign(status string) bool {
 		status == models.CampaignStatusScheduled
 }
 
+// campaignPerm is a middleware that checks whether the user has access to the
+// campaign in the :id param by the lists that the campaign targets. Reading
+// requires the get or send permission on the lists, and modifying (write)
+// requires the send permission.
+func campaignPerm(next echo.HandlerFunc, write bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var (
+			app   = c.Get("app").(*App)
+			user  = c.Get(auth.UserKey).(models.User)
+			id, _ = strconv.Atoi(c.Param("id"))
+		)
+
+		if id > 0 {
+			if err := hasCampaignPerm(user, []int{id}, write, app); err != nil {
+				return err
+			}
+		}
+
+		return next(c)
+	}
+}
+
+// campaignListScope returns whether the user has access to the campaigns of all
+// lists, and if not, the IDs of the lists whose campaigns the user has access to.
+func campaignListScope(user models.User, write bool) (bool, []int) {
+	if user.UserRole.ID == auth.SuperAdminRoleID || user.HasPerm(models.PermListSendAll) {
+		return true, nil
+	}
+
+	out := append([]int{}, user.SendListIDs...)
+	if write {
+		return false, out
+	}
+
+	if user.HasPerm(models.PermListGetAll) {
+		return true, nil
+	}
+
+	return false, append(out, user.GetListIDs...)
+}
+
+// hasCampaignPerm checks whether the user has access to all the given campaigns.
+func hasCampaignPerm(user models.User, campIDs []int, write bool, app *App) error {
+	all, listIDs := campaignListScope(user, write)
+	if all {
+		return nil
+	}
+
+	res, err := app.core.HasCampaignLists(campIDs, listIDs)
+	if err != nil {
+		return err
+	}
+
+	for id, has := range res {
+		if !has {
+			return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", fmt.Sprintf("campaign: %d", id)))
+		}
+	}
+
+	return nil
+}
+
+// hasCampaignListPerm checks whether the user can send campaigns to the
+// given lists and the member lists of the given list groups.
+func hasCampaignListPerm(user models.User, listIDs []int, groupIDs pq.Int64Array, app *App) error {
+	if all, _ := campaignListScope(user, true); all {
+		return nil
+	}
+
+	ids := append([]int{}, listIDs...)
+	if len(groupIDs) > 0 {
+		gIDs := make([]int, 0, len(groupIDs))
+		for _, id := range groupIDs {
+			gIDs = append(gIDs, int(id))
+		}
+
+		lIDs, err := app.core.GetListGroupListIDs(gIDs)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, lIDs...)
+	}
+
+	if len(user.FilterListsBySendPerm(ids)) != len(ids) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", "list"))
+	}
+
+	return nil
+}
+
 // makeOptinCampaignMessage makes a default opt-in campaign message body.
 func makeOptinCampaignMessage(o campaignReq, app *App) (campaignReq, error) {
 	if len(o.ListIDs) == 0 {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// maxSenderLinks is the maximum number of links on a sender profile.
+const maxSenderLinks = 10
+
+var reHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// complianceCheck is the result of checking a campaign's rendered messages
+// against the compliance profiles of the lists it targets.
+type complianceCheck struct {
+	Profiles []string `json:"profiles"`
+
+	// Errors block the campaign from being started.
+	Errors []string `json:"errors"`
+}
+
+// checkCampaignCompliance checks a campaign against the compliance profiles
+// of the lists it targets.
+func checkCampaignCompliance(id int, app *App) (complianceCheck, error) {
+	camp, err := app.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return complianceCheck{}, err
+	}
+
+	// Use a dummy campaign ID to prevent views and clicks from being registered.
+	camp.UUID = dummySubscriber.UUID
+	if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
+		return complianceCheck{}, echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	return checkCompliance(camp, app)
+}
+
+// checkCompliance checks that the messages of a compiled campaign, rendered
+// for a dummy subscriber in the default content and every language variant,
+// have the elements required by the compliance profiles of the lists the
+// campaign targets: the sender's name, the unsubscribe link, and the
+// physical postal address.
+func checkCompliance(camp models.Campaign, app *App) (complianceCheck, error) {
+	names, err := app.core.GetCampaignComplianceProfiles(camp.ID)
+	if err != nil {
+		return complianceCheck{}, err
+	}
+
+	out := complianceCheck{Profiles: names, Errors: []string{}}
+	if len(names) == 0 {
+		return out, nil
+	}
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return complianceCheck{}, err
+	}
+
+	// The profiles that require each of the elements.
+	var sender, unsub, addr []string
+	for _, p := range s.ComplianceProfiles {
+		if !strSliceContains(p.Name, names) {
+			continue
+		}
+
+		label := strings.ToUpper(p.Name)
+		if p.RequireSender {
+			sender = append(sender, label)
+		}
+		if p.RequireUnsubscribe {
+			unsub = append(unsub, label)
+		}
+		if p.RequireAddress {
+			addr = append(addr, label)
+		}
+	}
+
+	// The sender should be identified by name, eg: Company <news@company.com>.
+	if len(sender) > 0 {
+		if m := regexFromAddress.FindStringSubmatch(camp.FromEmail); m == nil || strings.Trim(m[2], `" `) == "" {
+			out.Errors = append(out.Errors, app.i18n.Ts("campaigns.compliance.noSender", "profiles", strings.Join(sender, ", ")))
+		}
+	}
+
+	if len(unsub) == 0 && len(addr) == 0 {
+		return out, nil
+	}
+
+	// The unsubscribe URL without the signature, which the manage URL shares.
+	unsubURL, _, _ := strings.Cut(app.manager.UnsubURL(camp.UUID, dummySubscriber.UUID), "?")
+
+	langs := []string{""}
+	for _, v := range camp.Variants {
+		langs = append(langs, v.Lang)
+	}
+	for _, lang := range langs {
+		sub := dummySubscriber
+		sub.Lang = lang
+
+		msg, err := app.manager.NewCampaignMessage(&camp, sub)
+		if err != nil {
+			return complianceCheck{}, echo.NewHTTPError(http.StatusBadRequest,
+				app.i18n.Ts("templates.errorRendering", "error", err.Error()))
+		}
+
+		// The address is that of the sender profile of the campaign's lists
+		// or the instance, which is the same for every variant.
+		lines := addressLines(msg.Sender)
+		if len(addr) > 0 && len(lines) == 0 {
+			out.Errors = append(out.Errors, app.i18n.Ts("campaigns.compliance.noAddressSet", "profiles", strings.Join(addr, ", ")))
+			addr = nil
+		}
+
+		// Errors of language variants are prefixed with the language.
+		prefix := ""
+		if lang != "" {
+			prefix = lang + ": "
+		}
+
+		body := string(msg.Body())
+		if len(unsub) > 0 && !strings.Contains(body, unsubURL) {
+			out.Errors = append(out.Errors, prefix+app.i18n.Ts("campaigns.compliance.noUnsubscribe", "profiles", strings.Join(unsub, ", ")))
+		}
+
+		if len(addr) > 0 {
+			text := normalizeText(html.UnescapeString(reHTMLTag.ReplaceAllString(body, " ")))
+			for _, l := range lines {
+				if !strings.Contains(text, l) {
+					out.Errors = append(out.Errors, prefix+app.i18n.Ts("campaigns.compliance.noAddress", "profiles", strings.Join(addr, ", ")))
+					break
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// addressLines returns the normalized lines of a sender profile's postal
+// address. The lines are matched separately as they're usually separated by
+// commas or line breaks in messages.
+func addressLines(p models.SenderProfile) []string {
+	var out []string
+	for _, l := range p.AddressLines() {
+		if l = strings.Trim(normalizeText(l), " ,"); l != "" {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
+
+// normalizeText lowercases a string and collapses its whitespace.
+func normalizeText(s string) string {
+	return strings.ToLower(regexpSpaces.ReplaceAllString(s, " "))
+}
+
+// validateSenderProfile validates and sanitizes the fields of a sender profile.
+func validateSenderProfile(p models.SenderProfile, app *App) (models.SenderProfile, error) {
+	p.CompanyName = strings.TrimSpace(p.CompanyName)
+	if !strHasLen(p.CompanyName, 0, stdInputMaxLen) {
+		return p, errors.New(app.i18n.Ts("globals.messages.invalidFields", "name", "company_name"))
+	}
+
+	p.Address = strings.TrimSpace(p.Address)
+	if !strHasLen(p.Address, 0, 2000) {
+		return p, errors.New(app.i18n.Ts("globals.messages.invalidFields", "name", "address"))
+	}
+
+	if len(p.Links) > maxSenderLinks {
+		return p, errors.New(app.i18n.Ts("settings.sender.invalidLinks", "num", strconv.Itoa(maxSenderLinks)))
+	}
+	links := make([]models.SenderLink, 0, len(p.Links))
+	for _, l := range p.Links {
+		l.Name = strings.TrimSpace(l.Name)
+		l.URL = strings.TrimSpace(l.URL)
+		if l.Name == "" && l.URL == "" {
+			continue
+		}
+
+		u, err := url.Parse(l.URL)
+		if !strHasLen(l.Name, 1, stdInputMaxLen) || err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(l.URL) > 2000 {
+			return p, errors.New(app.i18n.Ts("settings.sender.invalidLinks", "num", strconv.Itoa(maxSenderLinks)))
+		}
+		links = append(links, l)
+	}
+	p.Links = links
+
+	return p, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// pageCursor is the position of a page in cursor (keyset) pagination, which
+// is used instead of offset pagination when the ?cursor= param is present
+// (0 for the first page). Like the event log, results are ordered by ID and
+// every page picks up after the last ID of the previous page, the
+// next_cursor, which is fast at any depth unlike large offsets.
+type pageCursor struct {
+	enabled bool
+	afterID int
+}
+
+// getPageCursor reads the cursor from the request's query params.
+func getPageCursor(c echo.Context, app *App) (pageCursor, error) {
+	if !c.QueryParams().Has("cursor") {
+		return pageCursor{}, nil
+	}
+
+	s := c.QueryParam("cursor")
+	if s == "" {
+		return pageCursor{enabled: true}, nil
+	}
+
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return pageCursor{}, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "cursor"))
+	}
+
+	return pageCursor{enabled: true, afterID: id}, nil
+}
+
+// next returns the cursor of the page after a page of n results whose last
+// result has the ID lastID. It's 0 if there are no more pages.
+func (p pageCursor) next(n, limit, lastID int) int {
+	if !p.enabled || limit < 1 || n < limit {
+		return 0
+	}
+
+	return lastID
+}
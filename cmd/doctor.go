@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/stuffbin"
+	"golang.org/x/mod/semver"
+)
+
+const doctorDialTimeout = time.Second * 5
+
+// Doctor check statuses.
+const (
+	doctorOK   = "OK"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+// doctorResult is the result of a single --doctor check.
+type doctorResult struct {
+	status string
+	name   string
+	msg    string
+	fix    string
+}
+
+// doctorOrphanCheck is a query that counts rows that are inconsistent with
+// the rows they refer to, and the suggested fix.
+type doctorOrphanCheck struct {
+	name   string
+	status string
+	query  string
+	msg    string
+	fix    string
+}
+
+var (
+	reSchemaTable  = regexp.MustCompile(`^CREATE TABLE ([a-z_]+) \(`)
+	reSchemaColumn = regexp.MustCompile(`^\s+([a-z_]+)\s+[A-Za-z]`)
+
+	doctorOrphanChecks = []doctorOrphanCheck{
+		{
+			name:   "subscriptions",
+			status: doctorFail,
+			query: `SELECT COUNT(*) FROM subscriber_lists sl WHERE sl.list_id IS NULL
+				OR NOT EXISTS (SELECT 1 FROM lists WHERE id = sl.list_id)`,
+			msg: "%d subscriptions point to deleted lists",
+			fix: "DELETE FROM subscriber_lists WHERE list_id IS NULL OR list_id NOT IN (SELECT id FROM lists);",
+		},
+		{
+			name:   "subscriptions",
+			status: doctorFail,
+			query: `SELECT COUNT(*) FROM subscriber_lists sl WHERE sl.subscriber_id IS NULL
+				OR NOT EXISTS (SELECT 1 FROM subscribers WHERE id = sl.subscriber_id)`,
+			msg: "%d subscriptions point to deleted subscribers",
+			fix: "DELETE FROM subscriber_lists WHERE subscriber_id IS NULL OR subscriber_id NOT IN (SELECT id FROM subscribers);",
+		},
+		{
+			name:   "bounces",
+			status: doctorFail,
+			query:  `SELECT COUNT(*) FROM bounces b WHERE NOT EXISTS (SELECT 1 FROM subscribers WHERE id = b.subscriber_id)`,
+			msg:    "%d bounces point to deleted subscribers",
+			fix:    "DELETE FROM bounces WHERE subscriber_id NOT IN (SELECT id FROM subscribers);",
+		},
+		{
+			name:   "campaigns",
+			status: doctorFail,
+			query: `SELECT COUNT(*) FROM campaigns c WHERE c.template_id IS NOT NULL
+				AND NOT EXISTS (SELECT 1 FROM templates WHERE id = c.template_id)`,
+			msg: "%d campaigns point to deleted templates",
+			fix: "UPDATE campaigns SET template_id = (SELECT id FROM templates WHERE is_default LIMIT 1) WHERE template_id NOT IN (SELECT id FROM templates);",
+		},
+		{
+			name:   "campaigns",
+			status: doctorWarn,
+			query: `SELECT COUNT(*) FROM campaigns c WHERE c.status IN ('draft', 'scheduled', 'paused')
+				AND NOT EXISTS (SELECT 1 FROM campaign_lists cl JOIN lists l ON l.id = cl.list_id WHERE cl.campaign_id = c.id)`,
+			msg: "%d unfinished campaigns have no lists left to send to",
+			fix: "Assign lists to the campaigns or delete them from the admin.",
+		},
+		{
+			name:   "templates",
+			status: doctorFail,
+			query:  `SELECT (CASE WHEN EXISTS (SELECT 1 FROM templates WHERE is_default) THEN 0 ELSE 1 END)`,
+			msg:    "there is no default campaign template",
+			fix:    "Set a campaign template as the default in Campaigns -> Templates.",
+		},
+		{
+			name:   "users",
+			status: doctorFail,
+			query: `SELECT COUNT(*) FROM users u WHERE NOT EXISTS (SELECT 1 FROM roles WHERE id = u.user_role_id)
+				OR (u.list_role_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM roles WHERE id = u.list_role_id))`,
+			msg: "%d users point to deleted roles",
+			fix: "Assign the users valid roles in Users.",
+		},
+	}
+)
+
+// doctor runs a series of checks on the database schema, the integrity of the
+// data, and the settings, and prints a report with suggested fixes. It exits
+// with a non-zero status if any of the checks fail.
+func doctor(db *sqlx.DB, fs stuffbin.FileSystem) {
+	var out []doctorResult
+
+	// Schema.
+	out = append(out, doctorCheckVersion(db)...)
+	out = append(out, doctorCheckSchema(db, fs)...)
+
+	// Orphan rows.
+	for _, c := range doctorOrphanChecks {
+		var n int
+		if err := db.Get(&n, c.query); err != nil {
+			out = append(out, doctorResult{doctorFail, c.name, fmt.Sprintf("error running check: %v", err), ""})
+			continue
+		}
+		if n > 0 {
+			msg := c.msg
+			if strings.Contains(msg, "%d") {
+				msg = fmt.Sprintf(msg, n)
+			}
+			out = append(out, doctorResult{c.status, c.name, msg, c.fix})
+		}
+	}
+
+	// Settings.
+	if q, ok := readQueries(queryFilePath, db, fs)["get-settings"]; ok {
+		initSettings(q.Query, db, ko)
+		out = append(out, doctorCheckSettings()...)
+	}
+
+	// Print the report.
+	var numFail, numWarn int
+	for _, r := range out {
+		switch r.status {
+		case doctorFail:
+			numFail++
+		case doctorWarn:
+			numWarn++
+		}
+
+		fmt.Printf("[%-4s] %s: %s\n", r.status, r.name, r.msg)
+		if r.fix != "" {
+			fmt.Printf("       fix: %s\n", r.fix)
+		}
+	}
+
+	fmt.Printf("\n%d checks, %d failed, %d warnings\n", len(out), numFail, numWarn)
+	if numFail > 0 {
+		os.Exit(1)
+	}
+}
+
+// doctorCheckVersion checks the database's migration version against the
+// version this build expects.
+func doctorCheckVersion(db *sqlx.DB) []doctorResult {
+	lastVer, toRun, err := getPendingMigrations(db)
+	if err != nil {
+		return []doctorResult{{doctorFail, "schema", fmt.Sprintf("error checking migrations: %v", err), ""}}
+	}
+
+	curVer := migList[len(migList)-1].version
+	if len(toRun) > 0 {
+		vers := make([]string, 0, len(toRun))
+		for _, m := range toRun {
+			vers = append(vers, m.version)
+		}
+		return []doctorResult{{doctorFail, "schema",
+			fmt.Sprintf("database is at %s, %d pending migration(s): %s", lastVer, len(toRun), strings.Join(vers, ", ")),
+			"Backup the database and run listmonk --upgrade"}}
+	}
+
+	if semver.Compare(lastVer, curVer) > 0 {
+		return []doctorResult{{doctorWarn, "schema",
+			fmt.Sprintf("database is at %s which is newer than this build (%s)", lastVer, curVer),
+			"Upgrade the listmonk binary"}}
+	}
+
+	return []doctorResult{{doctorOK, "schema", fmt.Sprintf("database is at %s", lastVer), ""}}
+}
+
+// doctorCheckSchema checks that all the tables and columns in the bundled
+// schema.sql exist in the database.
+func doctorCheckSchema(db *sqlx.DB, fs stuffbin.FileSystem) []doctorResult {
+	b, err := fs.Read("/schema.sql")
+	if err != nil {
+		return []doctorResult{{doctorFail, "schema", fmt.Sprintf("error reading schema.sql: %v", err), ""}}
+	}
+
+	// Parse the tables and their columns from the schema file.
+	var (
+		tables  = map[string][]string{}
+		names   []string
+		current string
+	)
+	sc := bufio.NewScanner(strings.NewReader(string(b)))
+	for sc.Scan() {
+		line := sc.Text()
+		if m := reSchemaTable.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			names = append(names, current)
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ");") {
+			current = ""
+			continue
+		}
+		if m := reSchemaColumn.FindStringSubmatch(line); m != nil {
+			tables[current] = append(tables[current], m[1])
+		}
+	}
+
+	// Get the columns in the DB.
+	var cols []struct {
+		Table  string `db:"table_name"`
+		Column string `db:"column_name"`
+	}
+	if err := db.Select(&cols, `SELECT table_name, column_name FROM information_schema.columns
+		WHERE table_schema = CURRENT_SCHEMA()`); err != nil {
+		return []doctorResult{{doctorFail, "schema", fmt.Sprintf("error fetching columns: %v", err), ""}}
+	}
+	have := make(map[string]map[string]bool)
+	for _, c := range cols {
+		if _, ok := have[c.Table]; !ok {
+			have[c.Table] = make(map[string]bool)
+		}
+		have[c.Table][c.Column] = true
+	}
+
+	var out []doctorResult
+	for _, t := range names {
+		dbCols, ok := have[t]
+		if !ok {
+			out = append(out, doctorResult{doctorFail, "schema", fmt.Sprintf("table %s is missing", t),
+				"Backup the database and run listmonk --upgrade"})
+			continue
+		}
+
+		var missing []string
+		for _, c := range tables[t] {
+			if !dbCols[c] {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) > 0 {
+			out = append(out, doctorResult{doctorFail, "schema",
+				fmt.Sprintf("table %s is missing columns: %s", t, strings.Join(missing, ", ")),
+				"Backup the database and run listmonk --upgrade"})
+		}
+	}
+
+	if len(out) == 0 {
+		out = append(out, doctorResult{doctorOK, "schema", fmt.Sprintf("%d tables match schema.sql", len(names)), ""})
+	}
+
+	return out
+}
+
+// doctorCheckSettings checks the sanity of the settings loaded from the DB.
+func doctorCheckSettings() []doctorResult {
+	var out []doctorResult
+
+	// Root URL.
+	rootURL := ko.String("app.root_url")
+	if u, err := url.Parse(rootURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		out = append(out, doctorResult{doctorFail, "settings", fmt.Sprintf("invalid root URL: %s", rootURL),
+			"Set a full http(s) URL in Settings -> General -> Root URL"})
+	} else if h := u.Hostname(); h == "localhost" || net.ParseIP(h).IsLoopback() {
+		out = append(out, doctorResult{doctorWarn, "settings",
+			fmt.Sprintf("root URL %s is a local address. Links in e-mails will not work for subscribers", rootURL),
+			"Set the public URL of the installation in Settings -> General -> Root URL"})
+	} else {
+		out = append(out, doctorResult{doctorOK, "settings", fmt.Sprintf("root URL is %s", rootURL), ""})
+	}
+
+	// From e-mail.
+	if _, err := mail.ParseAddress(ko.String("app.from_email")); err != nil {
+		out = append(out, doctorResult{doctorFail, "settings", fmt.Sprintf("invalid default 'from' e-mail: %v", err),
+			"Set a valid e-mail in Settings -> General -> Default 'from' email"})
+	}
+
+	// SMTP servers.
+	numSMTP := 0
+	for i, s := range ko.Slices("smtp") {
+		if !s.Bool("enabled") {
+			continue
+		}
+		numSMTP++
+
+		name := fmt.Sprintf("smtp #%d", i+1)
+		addr := net.JoinHostPort(s.String("host"), strconv.Itoa(s.Int("port")))
+		if err := doctorDialSMTP(s.String("host"), addr, s.String("hello_hostname"), s.String("tls_type"), s.Bool("tls_skip_verify")); err != nil {
+			out = append(out, doctorResult{doctorFail, name, fmt.Sprintf("error connecting to %s: %v", addr, err),
+				"Check the host, port and TLS settings in Settings -> SMTP"})
+			continue
+		}
+		out = append(out, doctorResult{doctorOK, name, fmt.Sprintf("connected to %s", addr), ""})
+	}
+	if numSMTP == 0 {
+		out = append(out, doctorResult{doctorFail, "smtp", "no SMTP servers are enabled", "Enable an SMTP server in Settings -> SMTP"})
+	}
+
+	// Postback messengers.
+	for _, m := range ko.Slices("messengers") {
+		if !m.Bool("enabled") {
+			continue
+		}
+
+		name := "messenger " + m.String("name")
+		u, err := url.Parse(m.String("root_url"))
+		if err != nil || u.Host == "" {
+			out = append(out, doctorResult{doctorFail, name, fmt.Sprintf("invalid URL: %s", m.String("root_url")),
+				"Set a valid URL in Settings -> Messengers"})
+			continue
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), doctorDialTimeout)
+		if err != nil {
+			out = append(out, doctorResult{doctorFail, name, fmt.Sprintf("error connecting to %s: %v", u.Host, err),
+				"Check the URL in Settings -> Messengers"})
+			continue
+		}
+		conn.Close()
+		out = append(out, doctorResult{doctorOK, name, fmt.Sprintf("connected to %s", u.Host), ""})
+	}
+
+	// Media uploads.
+	if ko.String("upload.provider") == "filesystem" {
+		dir := ko.String("upload.filesystem.upload_path")
+		f, err := os.CreateTemp(dir, ".listmonk-doctor-")
+		if err != nil {
+			out = append(out, doctorResult{doctorFail, "uploads", fmt.Sprintf("upload path %s is not writable: %v", dir, err),
+				"Create the directory or set a writable path in Settings -> Media"})
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+			out = append(out, doctorResult{doctorOK, "uploads", fmt.Sprintf("upload path %s is writable", filepath.Clean(dir)), ""})
+		}
+	}
+
+	return out
+}
+
+// doctorDialSMTP connects to an SMTP server and does the EHLO (and STARTTLS)
+// handshake without authenticating or sending anything.
+func doctorDialSMTP(host, addr, helo, tlsType string, skipVerify bool) error {
+	tlsCfg := &tls.Config{ServerName: host, InsecureSkipVerify: skipVerify}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if tlsType == "TLS" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: doctorDialTimeout}, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, doctorDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(doctorDialTimeout * 2))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if helo == "" {
+		helo = "localhost"
+	}
+	if err := c.Hello(helo); err != nil {
+		return err
+	}
+
+	if tlsType == "STARTTLS" {
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("STARTTLS: %v", err)
+		}
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Maximum de-duplication window of an event rule (30 days).
+const maxEventDedupMinutes = 30 * 24 * 60
+
+// reEventName matches the names of events, eg: cart_abandoned, order.shipped.
+var reEventName = regexp.MustCompile(`^[a-zA-Z0-9_.:\-]{1,100}$`)
+
+// handleGetEventRules returns all event rules.
+func handleGetEventRules(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := app.core.GetEventRules()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetEventRule returns a single event rule.
+func handleGetEventRule(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetEventRule(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateEventRule creates a new event rule.
+func handleCreateEventRule(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	o := models.EventRule{Enabled: true}
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateEventRule(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateEventRule(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateEventRule updates an event rule.
+func handleUpdateEventRule(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var o models.EventRule
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateEventRule(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateEventRule(id, o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteEventRule deletes an event rule.
+func handleDeleteEventRule(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteEventRule(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleTriggerEvent records a named event posted by an external system for
+// a subscriber and runs the actions of the event's rules: the rules' tags are
+// added, the subscriber is subscribed to the rules' lists, and the rules'
+// transactional templates are sent with the event's payload. Rules that were
+// triggered for the subscriber within their de-duplication windows are skipped.
+func handleTriggerEvent(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Event           string      `json:"event"`
+			SubscriberID    int         `json:"subscriber_id"`
+			SubscriberUUID  string      `json:"subscriber_uuid"`
+			SubscriberEmail string      `json:"subscriber_email"`
+			Data            models.JSON `json:"data"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Event = strings.TrimSpace(req.Event)
+	if !reEventName.MatchString(req.Event) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "event"))
+	}
+
+	req.SubscriberEmail = strings.ToLower(strings.TrimSpace(req.SubscriberEmail))
+	if (req.SubscriberID < 1 && req.SubscriberUUID == "" && req.SubscriberEmail == "") ||
+		(req.SubscriberUUID != "" && !reUUID.MatchString(req.SubscriberUUID)) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "subscriber"))
+	}
+
+	sub, err := app.core.GetSubscriber(req.SubscriberID, req.SubscriberUUID, req.SubscriberEmail)
+	if err != nil {
+		return err
+	}
+
+	// Blocklisted subscribers don't trigger anything.
+	out := struct {
+		Triggered []int `json:"triggered"`
+	}{[]int{}}
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	rules, err := app.core.TriggerEventRules(req.Event, sub.ID, req.Data)
+	if err != nil {
+		return err
+	}
+
+	source := models.SourceEvent + ":" + req.Event
+	for _, r := range rules {
+		out.Triggered = append(out.Triggered, r.ID)
+
+		if len(r.ListIDs) > 0 {
+			listIDs := make([]int, 0, len(r.ListIDs))
+			for _, id := range r.ListIDs {
+				listIDs = append(listIDs, int(id))
+			}
+
+			if err := app.core.AddSubscriptions([]int{sub.ID}, listIDs, "", source); err != nil {
+				app.log.Printf("error subscribing on event rule (%s): %v", r.Name, err)
+			} else if app.constants.SendOptinConfirmation {
+				if _, err := sendOptinConfirmationHook(app)(sub, listIDs); err != nil {
+					app.log.Printf("error sending opt-in confirmation on event rule (%s): %v", r.Name, err)
+				}
+			}
+		}
+
+		if r.TxTemplateID.Valid && !app.manager.IsHalted() {
+			data := map[string]interface{}{}
+			for k, v := range req.Data {
+				data[k] = v
+			}
+			data["event"] = req.Event
+
+			if err := sendTxTemplate(int(r.TxTemplateID.Int), sub, data, app); err != nil {
+				app.log.Printf("error sending message on event rule (%s): %v", r.Name, err)
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateEventRule validates the fields of an event rule.
+func validateEventRule(o models.EventRule, app *App) (models.EventRule, error) {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	o.Event = strings.TrimSpace(o.Event)
+	if !reEventName.MatchString(o.Event) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "event"))
+	}
+
+	if o.DedupMinutes < 0 || o.DedupMinutes > maxEventDedupMinutes {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "dedup_minutes"))
+	}
+
+	for _, id := range o.ListIDs {
+		if id < 1 {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "lists"))
+		}
+	}
+
+	if o.TxTemplateID.Int < 1 {
+		o.TxTemplateID = null.Int{}
+	} else {
+		tpl, err := app.core.GetTemplate(int(o.TxTemplateID.Int), true)
+		if err != nil || tpl.Type != models.TemplateTypeTx {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "tx_template_id"))
+		}
+	}
+
+	// A rule without actions does nothing.
+	if !o.TxTemplateID.Valid && len(o.ListIDs) == 0 && len(o.Tags) == 0 {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("events.ruleNoActions"))
+	}
+
+	return o, nil
+}
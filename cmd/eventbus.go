@@ -0,0 +1,11 @@
+package main
+
+// publishEvent publishes an internal event on the event bus if it's enabled.
+// It never blocks.
+func (app *App) publishEvent(typ string, data interface{}) {
+	if app.bus == nil {
+		return
+	}
+
+	app.bus.Publish(typ, data)
+}
@@ -4,11 +4,77 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+// eventLogPerPage is the default number of event log entries returned per page.
+const eventLogPerPage = 20
+
+// eventLogTypes are the types of entries recorded in the event log.
+var eventLogTypes = []string{models.EventLogCampaign, models.EventLogImport, models.EventLogSettings, models.EventLogBounce, models.EventLogSubscribers}
+
+// handleEvents serves the live event stream to clients that request it
+// (EventSource) and the event log (activity feed) to the rest.
+func handleEvents(c echo.Context) error {
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		return handleEventStream(c)
+	}
+
+	return handleGetEventLog(c)
+}
+
+// handleGetEventLog returns event log entries, newest first, filtered by the
+// optional ?type= params. It's paginated with the ?cursor= param, which is the
+// next_cursor value returned in the previous response.
+func handleGetEventLog(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		pg        = app.paginator.NewFromURL(c.Request().URL.Query())
+		types     = c.QueryParams()["type"]
+		cursor, _ = strconv.ParseInt(c.QueryParam("cursor"), 10, 64)
+	)
+
+	for _, t := range types {
+		if !strSliceContains(t, eventLogTypes) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+
+	// per_page=all isn't supported on the cursor paginated log.
+	perPage := pg.PerPage
+	if perPage < 1 {
+		perPage = eventLogPerPage
+	}
+
+	res, err := app.core.QueryEventLog(types, cursor, perPage)
+	if err != nil {
+		return err
+	}
+
+	// There may be more entries if the page is full.
+	var next int64
+	if len(res) == perPage {
+		next = res[len(res)-1].ID
+	}
+
+	out := struct {
+		Results    []models.EventLog `json:"results"`
+		PerPage    int               `json:"per_page"`
+		NextCursor int64             `json:"next_cursor"`
+	}{res, perPage, next}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handleEventStream serves an endpoint that never closes and pushes a
 // live event stream (text/event-stream) such as a error messages.
 func handleEventStream(c echo.Context) error {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// getFieldsParam returns the JSON fields requested in the ?fields= param,
+// which is comma separated and/or repeated, eg: ?fields=id,name&fields=status
+func getFieldsParam(c echo.Context) []string {
+	var out []string
+	for _, v := range c.QueryParams()["fields"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				out = append(out, f)
+			}
+		}
+	}
+
+	return out
+}
+
+// sparseFields returns the given results (a slice of structs) with only the
+// requested JSON fields. The id is always returned. Heavy fields such as the
+// campaign body or subscriber attributes are thus only returned when they're
+// asked for. If no fields are requested, the results are returned as they are.
+func sparseFields(results interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return results, nil
+	}
+
+	var rows []map[string]json.RawMessage
+	if err := remarshal(results, &rows); err != nil {
+		return nil, err
+	}
+
+	out := make([]map[string]json.RawMessage, 0, len(rows))
+	for _, r := range rows {
+		row := make(map[string]json.RawMessage, len(fields)+1)
+		if v, ok := r["id"]; ok {
+			row["id"] = v
+		}
+		for _, f := range fields {
+			if v, ok := r[f]; ok {
+				row[f] = v
+			}
+		}
+		out = append(out, row)
+	}
+
+	return out, nil
+}
+
+// hasField checks whether a field is requested. If no fields are requested,
+// all of them are.
+func hasField(fields []string, f string) bool {
+	return len(fields) == 0 || strSliceContains(f, fields)
+}
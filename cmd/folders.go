@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// folderMoveReq represents a request to move campaigns and templates into a folder.
+type folderMoveReq struct {
+	FolderID    int   `json:"folder_id"`
+	CampaignIDs []int `json:"campaign_ids"`
+	TemplateIDs []int `json:"template_ids"`
+}
+
+// handleGetFolders handles retrieval of folders.
+func handleGetFolders(c echo.Context) error {
+	var (
+		app         = c.Get("app").(*App)
+		id, _       = strconv.Atoi(c.Param("id"))
+		archived, _ = strconv.ParseBool(c.QueryParam("archived"))
+	)
+
+	// Fetch one folder.
+	if id > 0 {
+		out, err := app.core.GetFolder(id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out, err := app.core.GetFolders(archived)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateFolder handles folder creation.
+func handleCreateFolder(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	var f models.Folder
+	if err := c.Bind(&f); err != nil {
+		return err
+	}
+
+	if err := validateFolder(&f, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateFolder(f)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateFolder handles folder modification, including archiving and
+// unarchiving.
+func handleUpdateFolder(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var f models.Folder
+	if err := c.Bind(&f); err != nil {
+		return err
+	}
+
+	if err := validateFolder(&f, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateFolder(id, f)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteFolder handles folder deletion. Campaigns and templates in the
+// folder are not deleted.
+func handleDeleteFolder(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteFolder(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleMoveToFolder moves campaigns and templates into a folder. A folder_id
+// of 0 moves them out of their folders.
+func handleMoveToFolder(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req folderMoveReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if len(req.CampaignIDs) == 0 && len(req.TemplateIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	// The route allows either of the campaign or template permissions. Check
+	// the specific permission for each type of item being moved.
+	if len(req.CampaignIDs) > 0 && !isSuperAdmin(user) && !user.HasPerm(models.PermCampaignsManage) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermCampaignsManage))
+	}
+	if len(req.TemplateIDs) > 0 && !isSuperAdmin(user) && !user.HasPerm(models.PermTemplatesManage) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermTemplatesManage))
+	}
+
+	// Validate the folder.
+	if req.FolderID > 0 {
+		if _, err := app.core.GetFolder(req.FolderID); err != nil {
+			return err
+		}
+	}
+
+	n, err := app.core.MoveToFolder(req.FolderID, req.CampaignIDs, req.TemplateIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{n}})
+}
+
+// validateFolder validates folder fields.
+func validateFolder(f *models.Folder, app *App) error {
+	f.Name = strings.TrimSpace(f.Name)
+	if !strHasLen(f.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("folders.invalidName"))
+	}
+
+	f.Description = strings.TrimSpace(f.Description)
+	if len(f.Description) > 2000 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+
+	return nil
+}
@@ -6,8 +6,10 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"strings"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/paginator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -39,8 +41,13 @@ type pagination struct {
 }
 
 var (
-	reUUID     = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
-	reLangCode = regexp.MustCompile("[^a-zA-Z_0-9\\-]")
+	reUUID       = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+	reLangCode   = regexp.MustCompile("[^a-zA-Z_0-9\\-]")
+	reRefCode    = regexp.MustCompile("^[a-zA-Z0-9]{1,64}$")
+	reHexColor   = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+	reWidgetID   = regexp.MustCompile("^[a-zA-Z0-9_\\-]{1,32}$")
+	reReasonCode = regexp.MustCompile("^[a-z0-9_.\\-]{1,64}$")
+	reHostname   = regexp.MustCompile("^([a-z0-9]([a-z0-9\\-]{0,61}[a-z0-9])?\\.)+[a-z0-9\\-]{2,63}$")
 
 	paginate = paginator.New(paginator.Opt{
 		DefaultPerPage: 20,
@@ -110,18 +117,56 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 	api.GET("/api/health", handleHealthCheck)
 	api.GET("/api/config", handleGetServerConfig)
 	api.GET("/api/lang/:lang", handleGetI18nLang)
+	api.GET("/api/langs", pm(handleGetI18nLangs, "settings:get"))
+	api.GET("/api/langs/:lang/missing", pm(handleGetI18nMissingKeys, "settings:get"))
 	api.GET("/api/dashboard/charts", handleGetDashboardCharts)
 	api.GET("/api/dashboard/counts", handleGetDashboardCounts)
+	api.GET("/api/search", handleSearch)
+
+	api.GET("/api/folders", pm(handleGetFolders, "campaigns:get", "templates:get"))
+	api.GET("/api/folders/:id", pm(handleGetFolders, "campaigns:get", "templates:get"))
+	api.POST("/api/folders", pm(handleCreateFolder, "campaigns:manage", "templates:manage"))
+	api.PUT("/api/folders/items", pm(handleMoveToFolder, "campaigns:manage", "templates:manage"))
+	api.PUT("/api/folders/:id", pm(handleUpdateFolder, "campaigns:manage", "templates:manage"))
+	api.DELETE("/api/folders/:id", pm(handleDeleteFolder, "campaigns:manage", "templates:manage"))
+
+	// Permissions on the trash are applied within the handlers per object type.
+	api.GET("/api/trash", handleGetTrash)
+	api.PUT("/api/trash/restore", handleRestoreTrash)
+	api.DELETE("/api/trash", handlePurgeTrash)
+
+	// Permissions on tags are applied within the handlers per object type.
+	api.GET("/api/tags", handleGetTags)
+	api.POST("/api/tags/merge", handleMergeTags)
+	api.PUT("/api/tags/:tag", handleRenameTag)
+	api.DELETE("/api/tags/:tag", handleDeleteTag)
 
 	api.GET("/api/settings", pm(handleGetSettings, "settings:get"))
 	api.PUT("/api/settings", pm(handleUpdateSettings, "settings:manage"))
 	api.POST("/api/settings/smtp/test", pm(handleTestSMTPSettings, "settings:manage"))
+	api.GET("/api/settings/smtp/mx", pm(handleGetMXStats, "settings:get"))
+	api.GET("/api/postmaster/stats", pm(handleGetPostmasterStats, "campaigns:get_analytics"))
+	api.POST("/api/postmaster/stats", pm(handleImportPostmasterStats, "settings:manage"))
+	api.POST("/api/settings/preview", pm(handlePreviewSettings, "settings:manage"))
+	api.GET("/api/settings/halt", pm(handleGetSendingHalt, "settings:get"))
+	api.PUT("/api/settings/halt", pm(handleUpdateSendingHalt, "settings:manage"))
+	api.POST("/api/settings/appearance/preview", pm(handlePreviewPublicPage, "settings:manage"))
+	api.GET("/api/settings/alerts", pm(handleGetAlertRules, "settings:get"))
+	api.POST("/api/settings/alerts", pm(handleCreateAlertRule, "settings:manage"))
+	api.PUT("/api/settings/alerts/:uuid", pm(handleUpdateAlertRule, "settings:manage"))
+	api.DELETE("/api/settings/alerts/:uuid", pm(handleDeleteAlertRule, "settings:manage"))
+
+	api.GET("/api/settings/suppression/syncs", pm(handleGetSuppressionSyncs, "settings:get"))
+	api.GET("/api/settings/suppression/syncs/:id", pm(handleGetSuppressionSync, "settings:get"))
+	api.PUT("/api/settings/suppression/syncs/:id/:action", pm(handleApplySuppressionSync, "settings:manage"))
+	api.POST("/api/settings/suppression/sources/:uuid/sync", pm(handleRunSuppressionSync, "settings:manage"))
 	api.POST("/api/admin/reload", pm(handleReloadApp, "settings:manage"))
 	api.GET("/api/logs", pm(handleGetLogs, "settings:get"))
-	api.GET("/api/events", pm(handleEventStream, "settings:get"))
+	api.GET("/api/events", pm(handleEvents, "settings:get"))
 	api.GET("/api/about", handleGetAboutInfo)
 
 	api.GET("/api/subscribers", pm(handleQuerySubscribers, "subscribers:get_all", "subscribers:get"))
+	api.POST("/api/subscribers/count", pm(handleCountSubscribers, "subscribers:get_all", "subscribers:get"))
 	api.GET("/api/subscribers/:id", pm(handleGetSubscriber, "subscribers:get_all", "subscribers:get"))
 	api.GET("/api/subscribers/:id/export", pm(handleExportSubscriberData, "subscribers:get_all", "subscribers:get"))
 	api.GET("/api/subscribers/:id/bounces", pm(handleGetSubscriberBounces, "bounces:get"))
@@ -129,8 +174,12 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 	api.POST("/api/subscribers", pm(handleCreateSubscriber, "subscribers:manage"))
 	api.PUT("/api/subscribers/:id", pm(handleUpdateSubscriber, "subscribers:manage"))
 	api.POST("/api/subscribers/:id/optin", pm(handleSubscriberSendOptin, "subscribers:manage"))
+	api.PUT("/api/subscribers/:id/public-key", pm(handleUpdateSubscriberPublicKey, "subscribers:manage"))
+	api.PUT("/api/subscribers/:id/uuid", pm(handleRotateSubscriberUUID, "subscribers:manage"))
+	api.POST("/api/subscribers/:id/preview", pm(handleCreateSubscriberPreview, "subscribers:get_all", "subscribers:get"))
 	api.PUT("/api/subscribers/blocklist", pm(handleBlocklistSubscribers, "subscribers:manage"))
 	api.PUT("/api/subscribers/:id/blocklist", pm(handleBlocklistSubscribers, "subscribers:manage"))
+	api.PUT("/api/subscribers/:id/subscriptions", pm(handleSetSubscriptionStatuses, "subscribers:manage"))
 	api.PUT("/api/subscribers/lists/:id", pm(handleManageSubscriberLists, "subscribers:manage"))
 	api.PUT("/api/subscribers/lists", pm(handleManageSubscriberLists, "subscribers:manage"))
 	api.DELETE("/api/subscribers/:id", pm(handleDeleteSubscribers, "subscribers:manage"))
@@ -144,37 +193,134 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 	// Subscriber operations based on arbitrary SQL queries.
 	// These aren't very REST-like.
 	api.POST("/api/subscribers/query/delete", pm(handleDeleteSubscribersByQuery, "subscribers:manage"))
+	api.GET("/api/subscribers/domains", pm(handleGetSubscribersDomainJob, "subscribers:manage"))
+	api.POST("/api/subscribers/domains", pm(handleSubscribersByDomain, "subscribers:manage"))
 	api.PUT("/api/subscribers/query/blocklist", pm(handleBlocklistSubscribersByQuery, "subscribers:manage"))
 	api.PUT("/api/subscribers/query/lists", pm(handleManageSubscriberListsByQuery, "subscribers:manage"))
 	api.GET("/api/subscribers/export",
 		pm(middleware.GzipWithConfig(middleware.GzipConfig{Level: 9})(handleExportSubscribers), "subscribers:get_all", "subscribers:get"))
 
+	api.GET("/api/subscribers/queries", pm(handleGetSubscriberQueries, "subscribers:get_all", "subscribers:get"))
+	api.GET("/api/subscribers/queries/:id", pm(handleGetSubscriberQuery, "subscribers:get_all", "subscribers:get"))
+	api.POST("/api/subscribers/queries", pm(handleCreateSubscriberQuery, "subscribers:sql_query"))
+	api.PUT("/api/subscribers/queries/:id", pm(handleUpdateSubscriberQuery, "subscribers:sql_query"))
+	api.DELETE("/api/subscribers/queries/:id", pm(handleDeleteSubscriberQuery, "subscribers:sql_query"))
+
 	api.GET("/api/import/subscribers", pm(handleGetImportSubscribers, "subscribers:import"))
 	api.GET("/api/import/subscribers/logs", pm(handleGetImportSubscriberStats, "subscribers:import"))
 	api.POST("/api/import/subscribers", pm(handleImportSubscribers, "subscribers:import"))
 	api.DELETE("/api/import/subscribers", pm(handleStopImportSubscribers, "subscribers:import"))
+	api.GET("/api/import/jobs", pm(handleGetImportJobs, "subscribers:import"))
+	api.GET("/api/import/jobs/:id", pm(handleGetImportJob, "subscribers:import"))
+	api.GET("/api/import/jobs/:id/logs", pm(handleGetImportJobLogs, "subscribers:import"))
+	api.PUT("/api/import/jobs/:id/:action", pm(handlePauseImportJob, "subscribers:import"))
+	api.DELETE("/api/import/jobs/:id", pm(handleDeleteImportJob, "subscribers:import"))
+
+	// Lead forms add subscribers to any list with their keys, like imports.
+	api.GET("/api/lead-forms", pm(handleGetLeadForms, "subscribers:import"))
+	api.GET("/api/lead-forms/:id", pm(handleGetLeadForm, "subscribers:import"))
+	api.POST("/api/lead-forms", pm(handleCreateLeadForm, "subscribers:import"))
+	api.PUT("/api/lead-forms/:id", pm(handleUpdateLeadForm, "subscribers:import"))
+	api.PUT("/api/lead-forms/:id/key", pm(handleRegenerateLeadFormKey, "subscribers:import"))
+	api.DELETE("/api/lead-forms/:id", pm(handleDeleteLeadForm, "subscribers:import"))
 
 	// Individual list permissions are applied directly within handleGetLists.
+	api.GET("/api/lists/groups", pm(handleGetListGroups, "lists:get_all"))
+	api.GET("/api/lists/groups/:id", pm(handleGetListGroups, "lists:get_all"))
+	api.POST("/api/lists/groups", pm(handleCreateListGroup, "lists:manage_all"))
+	api.PUT("/api/lists/groups/:id", pm(handleUpdateListGroup, "lists:manage_all"))
+	api.PUT("/api/lists/groups/:id/lists", pm(handleSetListGroupLists, "lists:manage_all"))
+	api.DELETE("/api/lists/groups/:id", pm(handleDeleteListGroup, "lists:manage_all"))
 	api.GET("/api/lists", handleGetLists)
+	api.POST("/api/lists/dynamic/preview", pm(handlePreviewDynamicList, "subscribers:get_all"))
 	api.GET("/api/lists/:id", listPerm(handleGetList))
 	api.POST("/api/lists", pm(handleCreateList, "lists:manage_all"))
 	api.PUT("/api/lists/:id", listPerm(handleUpdateList))
 	api.DELETE("/api/lists/:id", listPerm(handleDeleteLists))
+	api.GET("/api/lists/:id/referrers", listPerm(handleGetListReferrers))
+	api.GET("/api/lists/:id/archive", listPerm(handleExportListArchive))
+	api.POST("/api/lists/archive", pm(handleImportListArchive, "lists:manage_all"))
 
 	api.GET("/api/campaigns", pm(handleGetCampaigns, "campaigns:get"))
 	api.GET("/api/campaigns/running/stats", pm(handleGetRunningCampaignStats, "campaigns:get"))
-	api.GET("/api/campaigns/:id", pm(handleGetCampaign, "campaigns:get"))
+	api.GET("/api/campaigns/:id", pm(campaignPerm(handleGetCampaign, false), "campaigns:get"))
 	api.GET("/api/campaigns/analytics/:type", pm(handleGetCampaignViewAnalytics, "campaigns:get_analytics"))
-	api.GET("/api/campaigns/:id/preview", pm(handlePreviewCampaign, "campaigns:get"))
-	api.POST("/api/campaigns/:id/preview", pm(handlePreviewCampaign, "campaigns:get"))
-	api.POST("/api/campaigns/:id/content", pm(handleCampaignContent, "campaigns:manage"))
-	api.POST("/api/campaigns/:id/text", pm(handlePreviewCampaign, "campaigns:manage"))
-	api.POST("/api/campaigns/:id/test", pm(handleTestCampaign, "campaigns:manage"))
+	api.GET("/api/campaigns/compare", pm(handleCompareCampaigns, "campaigns:get_analytics"))
+	api.GET("/api/campaigns/:id/preview", pm(campaignPerm(handlePreviewCampaign, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/rsvps", pm(campaignPerm(handleGetCampaignRSVPs, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/replies", pm(campaignPerm(handleGetCampaignReplies, false), "campaigns:get_analytics"))
+	api.DELETE("/api/campaigns/:id/replies", pm(campaignPerm(handleDeleteCampaignReplies, true), "campaigns:manage"))
+	api.DELETE("/api/campaigns/:id/replies/:replyID", pm(campaignPerm(handleDeleteCampaignReplies, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/variants/stats", pm(campaignPerm(handleGetCampaignVariantStats, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/translations", pm(campaignPerm(handleExportCampaignTranslations, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/translations", pm(campaignPerm(handleImportCampaignTranslations, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/analytics/domains", pm(campaignPerm(handleGetCampaignDomainStats, false), "campaigns:get_analytics"))
+	api.GET("/api/campaigns/:id/retries", pm(campaignPerm(handleGetCampaignRetries, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/retry", pm(campaignPerm(handleCreateCampaignRetry, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/followups", pm(campaignPerm(handleGetCampaignFollowups, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/followups", pm(campaignPerm(handleUpsertCampaignFollowup, true), "campaigns:manage"))
+	api.DELETE("/api/campaigns/:id/followups/:campID", pm(campaignPerm(handleDeleteCampaignFollowup, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/outbox", pm(campaignPerm(handleGetCampaignOutbox, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/outbox/:msgID", pm(campaignPerm(handleGetCampaignOutboxMessage, false), "campaigns:get"))
+	api.PUT("/api/campaigns/:id/outbox", pm(campaignPerm(handleUpdateCampaignOutbox, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/preflight", pm(campaignPerm(handleGetCampaignPreflight, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/checklist", pm(campaignPerm(handleGetCampaignChecklist, false), "campaigns:get"))
+	api.PUT("/api/campaigns/:id/checklist", pm(campaignPerm(handleUpdateCampaignChecklist, true), "campaigns:manage"))
+	api.POST("/api/campaigns/:id/dry-run", pm(campaignPerm(handleCampaignDryRun, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/render/:subscriber_id", pm(campaignPerm(handleRenderCampaign, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/preview", pm(campaignPerm(handlePreviewCampaign, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/content", pm(campaignPerm(handleCampaignContent, true), "campaigns:manage"))
+	api.POST("/api/campaigns/:id/text", pm(campaignPerm(handlePreviewCampaign, true), "campaigns:manage"))
+	api.POST("/api/campaigns/:id/test", pm(campaignPerm(handleTestCampaign, true), "campaigns:manage"))
 	api.POST("/api/campaigns", pm(handleCreateCampaign, "campaigns:manage"))
-	api.PUT("/api/campaigns/:id", pm(handleUpdateCampaign, "campaigns:manage"))
-	api.PUT("/api/campaigns/:id/status", pm(handleUpdateCampaignStatus, "campaigns:manage"))
-	api.PUT("/api/campaigns/:id/archive", pm(handleUpdateCampaignArchive, "campaigns:manage"))
-	api.DELETE("/api/campaigns/:id", pm(handleDeleteCampaign, "campaigns:manage"))
+	api.POST("/api/campaigns/drafts", pm(handleCreateCampaignDraft, "campaigns:manage"))
+	api.PATCH("/api/campaigns/:id/content", pm(campaignPerm(handleUpdateCampaignContent, true), "campaigns:manage"))
+	api.PATCH("/api/campaigns/:id/audience", pm(campaignPerm(handleUpdateCampaignAudience, true), "campaigns:manage"))
+	api.PATCH("/api/campaigns/:id/settings", pm(campaignPerm(handleUpdateCampaignSettings, true), "campaigns:manage"))
+	api.PUT("/api/campaigns/:id/autosave", pm(campaignPerm(handleAutosaveCampaign, true), "campaigns:manage"))
+	api.GET("/api/campaigns/:id/revisions", pm(campaignPerm(handleGetCampaignRevisions, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/revisions/:rev_id", pm(campaignPerm(handleGetCampaignRevision, false), "campaigns:get"))
+	api.GET("/api/campaigns/:id/revisions/:rev_id/diff", pm(campaignPerm(handleGetCampaignRevisionDiff, false), "campaigns:get"))
+	api.POST("/api/campaigns/:id/revisions/:rev_id/restore", pm(campaignPerm(handleRestoreCampaignRevision, true), "campaigns:manage"))
+	api.PUT("/api/campaigns/:id", pm(campaignPerm(handleUpdateCampaign, true), "campaigns:manage"))
+	api.PUT("/api/campaigns/:id/status", pm(campaignPerm(handleUpdateCampaignStatus, true), "campaigns:manage"))
+	api.PUT("/api/campaigns/:id/archive", pm(campaignPerm(handleUpdateCampaignArchive, true), "campaigns:manage"))
+	api.PUT("/api/campaigns/:id/simulate", pm(campaignPerm(handleUpdateCampaignSimulate, true), "campaigns:manage"))
+	api.DELETE("/api/campaigns/:id", pm(campaignPerm(handleDeleteCampaign, true), "campaigns:manage"))
+	api.DELETE("/api/campaigns", pm(handleBulkDeleteCampaigns, "campaigns:manage"))
+	api.PUT("/api/campaigns/tags", pm(handleBulkUpdateCampaignTags, "campaigns:manage"))
+	api.PUT("/api/campaigns/archive", pm(handleBulkUpdateCampaignArchive, "campaigns:manage"))
+	api.GET("/api/campaigns/archive/search", pm(handleSearchCampaignArchivesAdmin, "campaigns:get"))
+	api.PUT("/api/campaigns/status", pm(handleBulkUpdateCampaignStatus, "campaigns:manage"))
+	api.PUT("/api/campaigns/template", pm(handleBulkUpdateCampaignTemplate, "campaigns:manage"))
+	api.POST("/api/campaigns/:id/preset", pm(campaignPerm(handleCreateCampaignPresetFromCampaign, false), "campaigns:manage"))
+
+	api.GET("/api/campaign-presets", pm(handleGetCampaignPresets, "campaigns:get"))
+	api.GET("/api/campaign-presets/:id", pm(handleGetCampaignPreset, "campaigns:get"))
+	api.POST("/api/campaign-presets", pm(handleCreateCampaignPreset, "campaigns:manage"))
+	api.POST("/api/campaign-presets/:id/instantiate", pm(handleInstantiateCampaignPreset, "campaigns:manage"))
+	api.PUT("/api/campaign-presets/:id", pm(handleUpdateCampaignPreset, "campaigns:manage"))
+	api.DELETE("/api/campaign-presets/:id", pm(handleDeleteCampaignPreset, "campaigns:manage"))
+
+	api.GET("/api/repermissions", pm(handleGetRepermissions, "campaigns:get"))
+	api.GET("/api/repermissions/targets", pm(handleGetRepermissionTargets, "campaigns:get"))
+	api.GET("/api/repermissions/:id", pm(handleGetRepermission, "campaigns:get"))
+	api.POST("/api/repermissions", pm(handleCreateRepermission, "campaigns:manage"))
+	api.PUT("/api/repermissions/:id", pm(handleUpdateRepermission, "campaigns:manage"))
+	api.DELETE("/api/repermissions/:id", pm(handleCancelRepermission, "campaigns:manage"))
+
+	api.GET("/api/reports", pm(handleGetReports, "reports:get"))
+	api.GET("/api/reports/:id", pm(handleGetReport, "reports:get"))
+	api.POST("/api/reports", pm(handleCreateReport, "reports:manage"))
+	api.PUT("/api/reports/:id", pm(handleUpdateReport, "reports:manage"))
+	api.DELETE("/api/reports/:id", pm(handleDeleteReport, "reports:manage"))
+	api.POST("/api/reports/:id/run", pm(handleRunReport, "reports:get"))
+	api.GET("/api/reports/:id/results", pm(handleGetReportResults, "reports:get"))
+	api.GET("/api/reports/:id/results/:resultID", pm(handleGetReportResult, "reports:get"))
+
+	api.GET("/api/warehouse", pm(handleGetWarehouseExports, "settings:get"))
+	api.POST("/api/warehouse/:table/run", pm(handleRunWarehouseExport, "settings:manage"))
+	api.DELETE("/api/warehouse/:table", pm(handleResetWarehouseExport, "settings:manage"))
 
 	api.GET("/api/media", pm(handleGetMedia, "media:get"))
 	api.GET("/api/media/:id", pm(handleGetMedia, "media:get"))
@@ -190,11 +336,19 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 	api.PUT("/api/templates/:id/default", pm(handleTemplateSetDefault, "templates:manage"))
 	api.DELETE("/api/templates/:id", pm(handleDeleteTemplate, "templates:manage"))
 
+	api.GET("/api/admin/migrations", pm(handleGetMigrations, "settings:get"))
 	api.DELETE("/api/maintenance/subscribers/:type", pm(handleGCSubscribers, "settings:maintain"))
 	api.DELETE("/api/maintenance/analytics/:type", pm(handleGCCampaignAnalytics, "settings:maintain"))
 	api.DELETE("/api/maintenance/subscriptions/unconfirmed", pm(handleGCSubscriptions, "settings:maintain"))
 
 	api.POST("/api/tx", pm(handleSendTxMessage, "tx:send"))
+	api.POST("/api/events/trigger", pm(handleTriggerEvent, "tx:send"))
+
+	api.GET("/api/events/rules", pm(handleGetEventRules, "subscribers:import"))
+	api.GET("/api/events/rules/:id", pm(handleGetEventRule, "subscribers:import"))
+	api.POST("/api/events/rules", pm(handleCreateEventRule, "subscribers:import"))
+	api.PUT("/api/events/rules/:id", pm(handleUpdateEventRule, "subscribers:import"))
+	api.DELETE("/api/events/rules/:id", pm(handleDeleteEventRule, "subscribers:import"))
 
 	api.GET("/api/profile", handleGetUserProfile)
 	api.PUT("/api/profile", handleUpdateUserProfile)
@@ -222,6 +376,12 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 		p.POST("/webhooks/service/:service", handleBounceWebhook)
 	}
 
+	if app.constants.StripeEnabled {
+		p.POST("/webhooks/stripe", handleStripeWebhook)
+		p.POST("/subscription/:campUUID/:subUUID/billing", validateUUID(subscriberExists(signedLink(handleBillingPortal, manager.LinkManage)),
+			"campUUID", "subUUID"))
+	}
+
 	// =================================================================
 	// Public API endpoints.
 
@@ -242,40 +402,56 @@ func initHTTPHandlers(e *echo.Echo, app *App) {
 	// Public APIs.
 	p.GET("/api/public/lists", handleGetPublicLists)
 	p.POST("/api/public/subscription", handlePublicSubscription)
+	p.POST("/api/public/subscription/confirm", handlePublicOptinCode)
+	p.POST("/api/public/leads", handlePublicLead)
 	if app.constants.EnablePublicArchive {
 		p.GET("/api/public/archive", handleGetCampaignArchives)
+		p.GET("/api/public/archive/search", handleSearchCampaignArchives)
 	}
 
 	// /public/static/* file server is registered in initHTTPServer().
 	// Public subscriber facing views.
 	p.GET("/subscription/form", handleSubscriptionFormPage)
 	p.POST("/subscription/form", handleSubscriptionForm)
-	p.GET("/subscription/:campUUID/:subUUID", noIndex(validateUUID(subscriberExists(handleSubscriptionPage),
+	p.GET("/subscription/widget", handleSubscriptionWidget)
+	p.GET("/lists/:listUUID", validateUUID(handleListPage, "listUUID"))
+	p.GET("/subscription/:campUUID/:subUUID", noIndex(validateUUID(subscriberExists(signedLink(handleSubscriptionPage, manager.LinkManage)),
 		"campUUID", "subUUID")))
-	p.POST("/subscription/:campUUID/:subUUID", validateUUID(subscriberExists(handleSubscriptionPrefs),
+	p.POST("/subscription/:campUUID/:subUUID", validateUUID(subscriberExists(signedLink(handleSubscriptionPrefs, manager.LinkManage)),
 		"campUUID", "subUUID"))
-	p.GET("/subscription/optin/:subUUID", noIndex(validateUUID(subscriberExists(handleOptinPage), "subUUID")))
-	p.POST("/subscription/optin/:subUUID", validateUUID(subscriberExists(handleOptinPage), "subUUID"))
-	p.POST("/subscription/export/:subUUID", validateUUID(subscriberExists(handleSelfExportSubscriberData),
+	p.GET("/subscription/optin/:subUUID", noIndex(validateUUID(subscriberExists(signedLink(handleOptinPage, manager.LinkOptin)), "subUUID")))
+	p.POST("/subscription/optin/:subUUID", validateUUID(subscriberExists(signedLink(handleOptinPage, manager.LinkOptin)), "subUUID"))
+	p.POST("/subscription/optin/code", handleOptinCode)
+	p.GET("/subscription/preview/:id", noIndex(handleSubscriberPortalPreview))
+	p.POST("/subscription/export/:subUUID", validateUUID(subscriberExists(signedLink(handleSelfExportSubscriberData, manager.LinkManage)),
 		"subUUID"))
-	p.POST("/subscription/wipe/:subUUID", validateUUID(subscriberExists(handleWipeSubscriberData),
+	p.POST("/subscription/wipe/:subUUID", validateUUID(subscriberExists(signedLink(handleWipeSubscriberData, manager.LinkManage)),
 		"subUUID"))
 	p.GET("/link/:linkUUID/:campUUID/:subUUID", noIndex(validateUUID(handleLinkRedirect,
 		"linkUUID", "campUUID", "subUUID")))
+	p.GET("/l/:slug", noIndex(handleShortLinkRedirect))
+	p.GET("/l/:slug/:sub", noIndex(handleShortLinkRedirect))
 	p.GET("/campaign/:campUUID/:subUUID", noIndex(validateUUID(handleViewCampaignMessage,
 		"campUUID", "subUUID")))
 	p.GET("/campaign/:campUUID/:subUUID/px.png", noIndex(validateUUID(handleRegisterCampaignView,
 		"campUUID", "subUUID")))
+	p.GET("/campaign/:campUUID/:subUUID/rsvp/:status", noIndex(validateUUID(handleCampaignRSVP,
+		"campUUID", "subUUID")))
+	p.GET("/files/:name", noIndex(handleDownloadFile))
 
 	if app.constants.EnablePublicArchive {
 		p.GET("/archive", handleCampaignArchivesPage)
 		p.GET("/archive.xml", handleGetCampaignArchivesFeed)
+		p.GET("/archive.atom", handleGetCampaignArchivesFeed)
+		p.GET("/archive.json", handleGetCampaignArchivesFeed)
 		p.GET("/archive/:id", handleCampaignArchivePage)
 		p.GET("/archive/latest", handleCampaignArchivePageLatest)
+		p.GET("/archive/search", noIndex(handleCampaignArchiveSearchPage))
 	}
 
 	p.GET("/public/custom.css", serveCustomAppearance("public.custom_css"))
 	p.GET("/public/custom.js", serveCustomAppearance("public.custom_js"))
+	p.GET("/public/widget.js", handleWidgetJS)
 
 	// Public health API endpoint.
 	p.GET("/health", handleHealthCheck)
@@ -385,6 +561,82 @@ func subscriberExists(next echo.HandlerFunc, params ...string) echo.HandlerFunc
 	}
 }
 
+// signedLink verifies the signed token (?t=) of a subscriber's public link for
+// the given purpose. Links without a token, sent before links were signed,
+// are allowed unless signed links are required in the privacy settings.
+func signedLink(next echo.HandlerFunc, purpose string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var (
+			app = c.Get("app").(*App)
+			tok = c.FormValue("t")
+		)
+
+		if tok == "" {
+			if !app.constants.Privacy.RequireSignedLinks {
+				return next(c)
+			}
+			return c.Render(http.StatusForbidden, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.invalidLink")))
+		}
+
+		if err := app.manager.CheckLinkToken(tok, purpose, c.Param("subUUID")); err != nil {
+			msg := "public.invalidLink"
+			if err == manager.ErrLinkExpired {
+				msg = "public.linkExpired"
+			}
+			return c.Render(http.StatusForbidden, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T(msg)))
+		}
+
+		return next(c)
+	}
+}
+
+// listDomainRouter routes requests on the custom domain of a list to the list's
+// public pages. The root shows the list's landing page and the archive is
+// filtered to the list. Everything other than the public pages, including the
+// admin, isn't served on list domains.
+func listDomainRouter(app *App) echo.MiddlewareFunc {
+	// Media uploads on the filesystem are served on list domains too.
+	uploadURI := strings.TrimRight(ko.String("upload.filesystem.upload_uri"), "/") + "/"
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			listUUID := app.getListDomain(c.Request().Host)
+			if listUUID == "" {
+				return next(c)
+			}
+
+			req := c.Request()
+			switch p := req.URL.Path; {
+			case p == "/", p == "/subscription/form" && req.Method == http.MethodGet:
+				req.URL.Path = "/lists/" + listUUID
+				req.URL.RawPath = ""
+
+			case p == "/archive", p == "/archive.xml", p == "/archive.atom", p == "/archive.json",
+				p == "/archive/search", p == "/api/public/archive", p == "/api/public/archive/search":
+				q := req.URL.Query()
+				q.Set("list", listUUID)
+				req.URL.RawQuery = q.Encode()
+
+			case p == "/lists/"+listUUID, p == "/health", p == "/api/public/lists",
+				strings.HasPrefix(p, "/subscription/"), strings.HasPrefix(p, "/archive/"),
+				strings.HasPrefix(p, "/campaign/"), strings.HasPrefix(p, "/link/"),
+				strings.HasPrefix(p, "/public/"), strings.HasPrefix(p, "/api/public/"),
+				uploadURI != "/" && strings.HasPrefix(p, uploadURI):
+
+			default:
+				return echo.NewHTTPError(http.StatusNotFound, "404 page not found")
+			}
+
+			// Links on the public pages point to the list's domain.
+			c.Set(rootURLKey, c.Scheme()+"://"+req.Host)
+
+			return next(c)
+		}
+	}
+}
+
 // noIndex adds the HTTP header requesting robots to not crawl the page.
 func noIndex(next echo.HandlerFunc, params ...string) echo.HandlerFunc {
 	return func(c echo.Context) error {
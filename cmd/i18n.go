@@ -3,10 +3,17 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
 )
@@ -21,6 +28,22 @@ type i18nLangRaw struct {
 	Name string `json:"_.name"`
 }
 
+// langPack is a language map along with the public page and e-mail notification
+// templates compiled against it.
+type langPack struct {
+	i18n      *i18n.I18n
+	pubTpls   *template.Template
+	notifTpls *template.Template
+}
+
+// langPacks lazily loads and caches language packs for languages other than
+// the app's default language that are set on subscribers and lists.
+type langPacks struct {
+	def   *langPack
+	packs map[string]*langPack
+	sync.Mutex
+}
+
 // handleGetI18nLang returns the JSON language pack given the language code.
 func handleGetI18nLang(c echo.Context) error {
 	app := c.Get("app").(*App)
@@ -30,7 +53,7 @@ func handleGetI18nLang(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid language code.")
 	}
 
-	i, ok, err := getI18nLang(lang, app.fs)
+	i, ok, err := getI18nLang(lang, app.fs, app.constants.I18nOverrideDir)
 	if err != nil && !ok {
 		return echo.NewHTTPError(http.StatusBadRequest, "Unknown language.")
 	}
@@ -38,14 +61,66 @@ func handleGetI18nLang(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{json.RawMessage(i.JSON())})
 }
 
-// getI18nLangList returns the list of available i18n languages.
+// handleGetI18nLangs returns the list of available languages along with the
+// number of keys in each that are missing translations.
+func handleGetI18nLangs(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	langs, err := getI18nLangList(app.constants.Lang, app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	type lang struct {
+		i18nLang
+		Override bool `json:"override"`
+		Missing  int  `json:"missing"`
+	}
+
+	out := make([]lang, 0, len(langs))
+	for _, l := range langs {
+		missing, err := getI18nMissingKeys(l.Code, app)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+
+		b, _ := readI18nOverride(l.Code, app.constants.I18nOverrideDir)
+		out = append(out, lang{i18nLang: l, Override: b != nil, Missing: len(missing)})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetI18nMissingKeys returns the keys in the default language that
+// are missing in the given language.
+func handleGetI18nMissingKeys(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	lang := c.Param("lang")
+	if len(lang) > 6 || reLangCode.MatchString(lang) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid language code.")
+	}
+
+	out, err := getI18nMissingKeys(lang, app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getI18nLangList returns the list of available i18n languages, both bundled
+// and in the override directory.
 func getI18nLangList(lang string, app *App) ([]i18nLang, error) {
 	list, err := app.fs.Glob("/i18n/*.json")
 	if err != nil {
 		return nil, err
 	}
 
-	var out []i18nLang
+	var (
+		out  []i18nLang
+		seen = make(map[string]bool)
+	)
 	for _, l := range list {
 		b, err := app.fs.Get(l)
 		if err != nil {
@@ -57,12 +132,42 @@ func getI18nLangList(lang string, app *App) ([]i18nLang, error) {
 			return out, fmt.Errorf("error parsing lang file: %s: %v", l, err)
 		}
 
+		seen[lang.Code] = true
 		out = append(out, i18nLang{
 			Code: lang.Code,
 			Name: lang.Name,
 		})
 	}
 
+	// Languages that only exist in the override directory.
+	if dir := app.constants.I18nOverrideDir; dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return out, err
+		}
+
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return out, fmt.Errorf("error reading lang file: %s: %v", f, err)
+			}
+
+			var lang i18nLangRaw
+			if err := json.Unmarshal(b, &lang); err != nil {
+				return out, fmt.Errorf("error parsing lang file: %s: %v", f, err)
+			}
+
+			if lang.Code == "" || seen[lang.Code] {
+				continue
+			}
+			seen[lang.Code] = true
+			out = append(out, i18nLang{
+				Code: lang.Code,
+				Name: lang.Name,
+			})
+		}
+	}
+
 	sort.SliceStable(out, func(i, j int) bool {
 		return out[i].Code < out[j].Code
 	})
@@ -71,8 +176,9 @@ func getI18nLangList(lang string, app *App) ([]i18nLang, error) {
 }
 
 // The bool indicates whether the specified language could be loaded. If it couldn't
-// be, the app shouldn't halt but throw a warning.
-func getI18nLang(lang string, fs stuffbin.FileSystem) (*i18n.I18n, bool, error) {
+// be, the app shouldn't halt but throw a warning. Language files in the override
+// dir (optional) are loaded on top of the bundled ones.
+func getI18nLang(lang string, fs stuffbin.FileSystem, dir string) (*i18n.I18n, bool, error) {
 	const def = "en"
 
 	b, err := fs.Read(fmt.Sprintf("/i18n/%s.json", def))
@@ -86,15 +192,186 @@ func getI18nLang(lang string, fs stuffbin.FileSystem) (*i18n.I18n, bool, error)
 		return nil, false, fmt.Errorf("error unmarshalling i18n language: %s: %v", lang, err)
 	}
 
-	// Load the selected language on top of it.
-	b, err = fs.Read(fmt.Sprintf("/i18n/%s.json", lang))
-	if err != nil {
-		return i, true, fmt.Errorf("error reading i18n language file: %s: %v", lang, err)
+	// Overrides to the default language apply to all languages.
+	if lang != def {
+		if b, err := readI18nOverride(def, dir); err != nil {
+			return i, true, err
+		} else if b != nil {
+			if err := i.Load(b); err != nil {
+				return i, true, fmt.Errorf("error loading i18n language file: %s: %v", def, err)
+			}
+		}
+	}
+
+	// Load the selected language on top of it from the bundled files and the override dir.
+	found := false
+	if b, err := fs.Read(fmt.Sprintf("/i18n/%s.json", lang)); err == nil {
+		if err := i.Load(b); err != nil {
+			return i, true, fmt.Errorf("error loading i18n language file: %s: %v", lang, err)
+		}
+		found = true
+	}
+
+	if b, err := readI18nOverride(lang, dir); err != nil {
+		return i, true, err
+	} else if b != nil {
+		if err := i.Load(b); err != nil {
+			return i, true, fmt.Errorf("error loading i18n language file: %s: %v", lang, err)
+		}
+		found = true
 	}
 
-	if err := i.Load(b); err != nil {
-		return i, true, fmt.Errorf("error loading i18n language file: %s: %v", lang, err)
+	if !found {
+		return i, true, fmt.Errorf("error reading i18n language file: %s", lang)
 	}
 
 	return i, true, nil
 }
+
+// readI18nOverride reads a language file from the override directory. If the
+// directory isn't set or the file doesn't exist, nil is returned.
+func readI18nOverride(lang, dir string) ([]byte, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, lang+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading i18n language file: %s: %v", lang, err)
+	}
+
+	return b, nil
+}
+
+// getI18nMissingKeys returns the sorted list of keys in the default language
+// that are not translated in the given language.
+func getI18nMissingKeys(lang string, app *App) ([]string, error) {
+	// Collect the keys from the bundled and override files of the language.
+	b, _ := app.fs.Read(fmt.Sprintf("/i18n/%s.json", lang))
+	o, err := readI18nOverride(lang, app.constants.I18nOverrideDir)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil && o == nil {
+		return nil, fmt.Errorf("unknown language: %s", lang)
+	}
+
+	keys := make(map[string]bool)
+	for _, f := range [][]byte{b, o} {
+		if f == nil {
+			continue
+		}
+
+		var m map[string]string
+		if err := json.Unmarshal(f, &m); err != nil {
+			return nil, fmt.Errorf("error parsing lang file: %s: %v", lang, err)
+		}
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	def, _, err := getI18nLang("en", app.fs, app.constants.I18nOverrideDir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	for _, k := range def.Keys() {
+		if !keys[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// watchI18nOverrides periodically checks the override directory for changes to
+// language files and reloads the app's language and the cached language packs.
+func watchI18nOverrides(dir string, interval time.Duration, app *App) {
+	var last string
+	for {
+		// Fingerprint the directory with the names, sizes, and modification times of the files.
+		var sig strings.Builder
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		for _, f := range files {
+			if st, err := os.Stat(f); err == nil {
+				fmt.Fprintf(&sig, "%s:%d:%d;", f, st.Size(), st.ModTime().UnixNano())
+			}
+		}
+
+		if s := sig.String(); last == "" {
+			last = s
+		} else if s != last {
+			last = s
+
+			i, ok, err := getI18nLang(app.constants.Lang, app.fs, dir)
+			if err != nil || !ok {
+				app.log.Printf("error reloading language files from %s: %v", dir, err)
+			} else {
+				app.i18n.Replace(i)
+
+				// Drop cached language packs so that they're reloaded on use.
+				app.langs.Lock()
+				app.langs.packs = make(map[string]*langPack)
+				app.langs.Unlock()
+
+				app.log.Printf("reloaded language files from %s", dir)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// getLang returns the language pack for the given language code. If the code
+// is empty or the language can't be loaded, the default language pack is returned.
+func (app *App) getLang(code string) *langPack {
+	code = strings.TrimSpace(code)
+	if code == "" || code == app.i18n.Code() || len(code) > 6 || reLangCode.MatchString(code) {
+		return app.langs.def
+	}
+
+	app.langs.Lock()
+	defer app.langs.Unlock()
+
+	if l, ok := app.langs.packs[code]; ok {
+		return l
+	}
+
+	// Unknown languages fall back to the default pack so that the filesystem isn't
+	// looked up on every request.
+	l := app.langs.def
+	if i, ok, err := getI18nLang(code, app.fs, app.constants.I18nOverrideDir); err != nil || !ok {
+		app.log.Printf("error loading language '%s': %v", code, err)
+	} else if pub, err := loadPublicTpls(i, app); err != nil {
+		app.log.Printf("error compiling public templates for language '%s': %v", code, err)
+	} else if notif, err := stuffbin.ParseTemplatesGlob(initTplFuncs(i, app.constants), app.fs, "/static/email-templates/*.html"); err != nil {
+		app.log.Printf("error compiling notification templates for language '%s': %v", code, err)
+	} else {
+		l = &langPack{i18n: i, pubTpls: pub, notifTpls: notif}
+	}
+
+	app.langs.packs[code] = l
+	return l
+}
+
+// getSubLang returns the language pack for a subscriber. The subscriber's own
+// language takes precedence, followed by the first of the given lists that has one.
+func (app *App) getSubLang(sub models.Subscriber, lists []models.List) *langPack {
+	if sub.Lang != "" {
+		return app.getLang(sub.Lang)
+	}
+
+	for _, l := range lists {
+		if l.Lang != "" {
+			return app.getLang(l.Lang)
+		}
+	}
+
+	return app.langs.def
+}
@@ -2,9 +2,8 @@ package main
 
 import (
 	"encoding/json"
-	"io"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 
 	"github.com/knadh/listmonk/internal/subimporter"
@@ -17,14 +16,27 @@ import (
 func handleImportSubscribers(c echo.Context) error {
 	app := c.Get("app").(*App)
 
-	// Is an import already running?
-	if app.importer.GetStats().Status == subimporter.StatusImporting {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("import.alreadyRunning"))
+	// Stream the uploaded file to disk instead of buffering it in memory.
+	// The importer reads it from there.
+	file, vals, err := streamFormFile(c, "file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("import.invalidFile", "error", err.Error()))
 	}
 
+	// The file is deleted unless the import job that reads it is queued.
+	queued := false
+	defer func() {
+		if queued {
+			file.File.Close()
+			return
+		}
+		file.Close()
+	}()
+
 	// Unmarshal the JSON params.
 	var opt subimporter.SessionOpt
-	if err := json.Unmarshal([]byte(c.FormValue("params")), &opt); err != nil {
+	if err := json.Unmarshal([]byte(vals["params"]), &opt); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			app.i18n.Ts("import.invalidParams", "error", err.Error()))
 	}
@@ -50,61 +62,57 @@ func handleImportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("import.invalidSubStatus"))
 	}
 
-	if len(opt.Delim) != 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("import.invalidDelim"))
-	}
-
-	file, err := c.FormFile("file")
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			app.i18n.Ts("import.invalidFile", "error", err.Error()))
-	}
-
-	src, err := file.Open()
-	if err != nil {
-		return err
+	// If no conflict policy is specified, derive it from the legacy overwrite flag.
+	if opt.OnConflict == "" {
+		if opt.Overwrite {
+			opt.OnConflict = subimporter.ConflictOverwrite
+		} else {
+			opt.OnConflict = subimporter.ConflictSubscribe
+		}
 	}
-	defer src.Close()
 
-	out, err := os.CreateTemp("", "listmonk")
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			app.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
+	switch opt.OnConflict {
+	case subimporter.ConflictOverwrite, subimporter.ConflictMerge, subimporter.ConflictSubscribe, subimporter.ConflictSkip:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("import.invalidOnConflict"))
 	}
-	defer out.Close()
 
-	if _, err = io.Copy(out, src); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			app.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
+	if len(opt.Delim) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("import.invalidDelim"))
 	}
 
-	// Start the importer session.
+	// Create the import job.
 	opt.Filename = file.Filename
 	impSess, err := app.importer.NewSession(opt)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			app.i18n.Ts("import.errorStarting", "error", err.Error()))
 	}
-	go impSess.Start()
 
-	if strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
-		go impSess.LoadCSV(out.Name(), rune(opt.Delim[0]))
-	} else {
+	srcPath := file.Name()
+	if !strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
 		// Only 1 CSV from the ZIP is considered. If multiple files have
 		// to be processed, counting the net number of lines (to track progress),
 		// keeping the global import state (failed / successful) etc. across
 		// multiple files becomes complex. Instead, it's just easier for the
 		// end user to concat multiple CSVs (if there are multiple in the first)
 		// place and upload as one in the first place.
-		dir, files, err := impSess.ExtractZIP(out.Name(), 1)
+		dir, files, err := impSess.ExtractZIP(file.Name(), 1)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError,
 				app.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
 		}
-		go impSess.LoadCSV(dir+"/"+files[0], rune(opt.Delim[0]))
+		srcPath = dir + "/" + files[0]
 	}
 
-	return c.JSON(http.StatusOK, okResp{app.importer.GetStats()})
+	// Queue the job. It runs when one of the import workers is free.
+	if err := app.importer.Queue(impSess, srcPath, rune(opt.Delim[0])); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("import.errorStarting", "error", err.Error()))
+	}
+	queued = true
+
+	return c.JSON(http.StatusOK, okResp{impSess.GetStats()})
 }
 
 // handleGetImportSubscribers returns import statistics.
@@ -130,3 +138,90 @@ func handleStopImportSubscribers(c echo.Context) error {
 	app.importer.Stop()
 	return c.JSON(http.StatusOK, okResp{app.importer.GetStats()})
 }
+
+// handleGetImportJobs returns all the queued, active, and past import jobs.
+func handleGetImportJobs(c echo.Context) error {
+	app := c.Get("app").(*App)
+	return c.JSON(http.StatusOK, okResp{app.importer.GetJobs()})
+}
+
+// handleGetImportJob returns the statistics of an import job.
+func handleGetImportJob(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	job, err := getImportJob(c, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{job.GetStats()})
+}
+
+// handleGetImportJobLogs returns the logs of an import job.
+func handleGetImportJobLogs(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	job, err := getImportJob(c, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{string(job.GetLogs())})
+}
+
+// handlePauseImportJob pauses or resumes an active import job.
+func handlePauseImportJob(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	job, err := getImportJob(c, app)
+	if err != nil {
+		return err
+	}
+
+	switch c.Param("action") {
+	case "pause":
+		err = job.Pause()
+	case "resume":
+		err = job.Resume()
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{job.GetStats()})
+}
+
+// handleDeleteImportJob stops a queued or active import job, or deletes
+// a finished job from the job history.
+func handleDeleteImportJob(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	job, err := getImportJob(c, app)
+	if err != nil {
+		return err
+	}
+
+	if err := app.importer.DeleteJob(job.GetStats().ID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getImportJob returns the import job by the ID in the request.
+func getImportJob(c echo.Context, app *App) (*subimporter.Session, error) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	job, err := app.importer.GetJob(id)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound,
+			app.i18n.Ts("globals.messages.notFound", "name", "{import.job}"))
+	}
+
+	return job, nil
+}
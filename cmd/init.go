@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -34,14 +35,24 @@ import (
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/mailcrypt"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
+	"github.com/knadh/listmonk/internal/messenger/devnull"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/messenger/postback"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/querylog"
+	"github.com/knadh/listmonk/internal/replies"
+	"github.com/knadh/listmonk/internal/sanitize"
+	"github.com/knadh/listmonk/internal/stripe"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/tracker"
+	"github.com/knadh/listmonk/internal/warehouse"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
@@ -56,19 +67,29 @@ const (
 
 // constants contains static, constant config values required by the app.
 type constants struct {
-	SiteName                      string   `koanf:"site_name"`
-	RootURL                       string   `koanf:"root_url"`
-	LogoURL                       string   `koanf:"logo_url"`
-	FaviconURL                    string   `koanf:"favicon_url"`
-	LoginURL                      string   `koanf:"login_url"`
-	FromEmail                     string   `koanf:"from_email"`
-	NotifyEmails                  []string `koanf:"notify_emails"`
-	EnablePublicSubPage           bool     `koanf:"enable_public_subscription_page"`
-	EnablePublicArchive           bool     `koanf:"enable_public_archive"`
-	EnablePublicArchiveRSSContent bool     `koanf:"enable_public_archive_rss_content"`
-	SendOptinConfirmation         bool     `koanf:"send_optin_confirmation"`
-	Lang                          string   `koanf:"lang"`
-	DBBatchSize                   int      `koanf:"batch_size"`
+	SiteName                      string        `koanf:"site_name"`
+	RootURL                       string        `koanf:"root_url"`
+	LogoURL                       string        `koanf:"logo_url"`
+	FaviconURL                    string        `koanf:"favicon_url"`
+	LoginURL                      string        `koanf:"login_url"`
+	FromEmail                     string        `koanf:"from_email"`
+	NotifyEmails                  []string      `koanf:"notify_emails"`
+	EnablePublicSubPage           bool          `koanf:"enable_public_subscription_page"`
+	EnablePublicArchive           bool          `koanf:"enable_public_archive"`
+	EnablePublicArchiveRSSContent bool          `koanf:"enable_public_archive_rss_content"`
+	SendOptinConfirmation         bool          `koanf:"send_optin_confirmation"`
+	Lang                          string        `koanf:"lang"`
+	DBBatchSize                   int           `koanf:"batch_size"`
+	TrashRetentionDays            int           `koanf:"trash_retention_days"`
+	MessageSizeLimit              int           `koanf:"message_size_limit"`
+	ImageWeightLimit              int           `koanf:"image_weight_limit"`
+	RequireCampaignChecklist      bool          `koanf:"require_campaign_checklist"`
+	DefaultTimezone               string        `koanf:"default_timezone"`
+	Concurrency                   int           `koanf:"concurrency"`
+	MessageRate                   int           `koanf:"message_rate"`
+	SlidingWindow                 bool          `koanf:"message_sliding_window"`
+	SlidingWindowDuration         time.Duration `koanf:"message_sliding_window_duration"`
+	SlidingWindowRate             int           `koanf:"message_sliding_window_rate"`
 	Privacy                       struct {
 		IndividualTracking bool            `koanf:"individual_tracking"`
 		AllowPreferences   bool            `koanf:"allow_preferences"`
@@ -76,9 +97,19 @@ type constants struct {
 		AllowExport        bool            `koanf:"allow_export"`
 		AllowWipe          bool            `koanf:"allow_wipe"`
 		RecordOptinIP      bool            `koanf:"record_optin_ip"`
+		RecordConsent      bool            `koanf:"record_consent"`
+		ConsentVersion     string          `koanf:"consent_version"`
+		OptinSMSMessenger  string          `koanf:"optin_sms_messenger"`
 		UnsubHeader        bool            `koanf:"unsubscribe_header"`
+		FilterBotClicks    bool            `koanf:"filter_bot_clicks"`
+		RequireSignedLinks bool            `koanf:"require_signed_links"`
 		Exportable         map[string]bool `koanf:"-"`
 		DomainBlocklist    []string        `koanf:"-"`
+		BlockRoleAccounts  bool            `koanf:"block_role_accounts"`
+		RoleAccounts       []string        `koanf:"role_accounts"`
+		CheckMX            bool            `koanf:"check_mx"`
+		EmailDenyPatterns  []string        `koanf:"email_deny_patterns"`
+		BotClickNets       []*net.IPNet    `koanf:"-"`
 	} `koanf:"privacy"`
 	Security struct {
 		OIDC struct {
@@ -91,6 +122,10 @@ type constants struct {
 		EnableCaptcha bool   `koanf:"enable_captcha"`
 		CaptchaKey    string `koanf:"captcha_key"`
 		CaptchaSecret string `koanf:"captcha_secret"`
+
+		// SigningKey is the secret used to sign public URLs. It's generated
+		// on first boot and is not exposed via the settings API.
+		SigningKey string `koanf:"signing_key"`
 	} `koanf:"security"`
 
 	Appearance struct {
@@ -98,27 +133,65 @@ type constants struct {
 		AdminJS   []byte `koanf:"admin.custom_js"`
 		PublicCSS []byte `koanf:"public.custom_css"`
 		PublicJS  []byte `koanf:"public.custom_js"`
+
+		PublicTemplates []models.PublicTemplate `koanf:"public.templates"`
 	}
 
-	HasLegacyUser bool
-	UnsubURL      string
-	LinkTrackURL  string
-	ViewTrackURL  string
-	OptinURL      string
-	MessageURL    string
-	ArchiveURL    string
-	AssetVersion  string
+	HasLegacyUser   bool
+	I18nOverrideDir string
+	UnsubURL        string
+	LinkTrackURL    string
+	ShortLinkURL    string
+	ViewTrackURL    string
+	OptinURL        string
+	MessageURL      string
+	RSVPURL         string
+	ReferralURL     string
+	ArchiveURL      string
+	FileURL         string
+	AssetVersion    string
 
 	MediaUpload struct {
 		Provider   string
 		Extensions []string
 	}
 
+	// Maximum sizes of request bodies in bytes. 0 disables a limit.
+	BodyLimit struct {
+		Default int64
+		Media   int64
+		Import  int64
+	}
+
+	// Campaign bodies larger than CompressSize bytes are stored compressed
+	// in the DB or in the media store (Storage). 0 disables compression.
+	CampaignBody struct {
+		CompressSize int
+		Storage      string
+	}
+
+	// Saved SQL reports are run as the DBRole Postgres role with a statement
+	// timeout, and their results are capped to MaxRows rows. The latest
+	// KeepResults results of each report are kept.
+	Reports struct {
+		DBRole      string
+		Timeout     time.Duration
+		MaxRows     int
+		KeepResults int
+	}
+
+	// Location of DefaultTimezone, in which campaign schedules without
+	// a timezone are resolved.
+	DefaultLocation *time.Location `koanf:"-"`
+
 	BounceWebhooksEnabled     bool
 	BounceSESEnabled          bool
 	BounceSendgridEnabled     bool
 	BouncePostmarkEnabled     bool
 	BounceForwardemailEnabled bool
+	BounceRetryMaxAttempts    int
+
+	StripeEnabled bool
 
 	PermissionsRaw json.RawMessage
 	Permissions    map[string]struct{}
@@ -143,10 +216,14 @@ func initFlags() {
 	f.Bool("install", false, "setup database (first time)")
 	f.Bool("idempotent", false, "make --install run only if the database isn't already setup")
 	f.Bool("upgrade", false, "upgrade database to the current version")
+	f.Bool("dry-run", false, "list the pending migrations with --upgrade without running them")
+	f.Bool("encrypt-settings", false, "encrypt the secrets in the settings with app.secrets_key (or decrypt them if it's not set) and exit")
+	f.Bool("doctor", false, "check the database schema, data integrity, and settings, and print a report with fixes")
 	f.Bool("version", false, "show current version of the build")
 	f.Bool("new-config", false, "generate sample config file")
 	f.String("static-dir", "", "(optional) path to directory with static files")
 	f.String("i18n-dir", "", "(optional) path to directory with i18n language files")
+	f.String("i18n-override-dir", "", "(optional) path to directory with i18n language files that are merged over the bundled ones and reloaded on changes")
 	f.Bool("yes", false, "assume 'yes' to prompts during --install/upgrade")
 	f.Bool("passive", false, "run in passive mode where campaigns are not processed")
 	if err := f.Parse(os.Args[1:]); err != nil {
@@ -286,31 +363,48 @@ func initFS(appDir, frontendDir, staticDir, i18nDir string) stuffbin.FileSystem
 // SQL queries into a prepared query map.
 func initDB() *sqlx.DB {
 	var c struct {
-		Host        string        `koanf:"host"`
-		Port        int           `koanf:"port"`
-		User        string        `koanf:"user"`
-		Password    string        `koanf:"password"`
-		DBName      string        `koanf:"database"`
-		SSLMode     string        `koanf:"ssl_mode"`
-		Params      string        `koanf:"params"`
-		MaxOpen     int           `koanf:"max_open"`
-		MaxIdle     int           `koanf:"max_idle"`
-		MaxLifetime time.Duration `koanf:"max_lifetime"`
+		Host               string        `koanf:"host"`
+		Port               int           `koanf:"port"`
+		User               string        `koanf:"user"`
+		Password           string        `koanf:"password"`
+		DBName             string        `koanf:"database"`
+		SSLMode            string        `koanf:"ssl_mode"`
+		Params             string        `koanf:"params"`
+		MaxOpen            int           `koanf:"max_open"`
+		MaxIdle            int           `koanf:"max_idle"`
+		MaxLifetime        time.Duration `koanf:"max_lifetime"`
+		MaxIdleTime        time.Duration `koanf:"max_idle_time"`
+		StatementTimeout   time.Duration `koanf:"statement_timeout"`
+		SlowQueryThreshold time.Duration `koanf:"slow_query_threshold"`
 	}
 	if err := ko.Unmarshal("db", &c); err != nil {
 		lo.Fatalf("error loading db config: %v", err)
 	}
 
-	lo.Printf("connecting to db: %s:%d/%s", c.Host, c.Port, c.DBName)
-	db, err := sqlx.Connect("postgres",
-		fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, c.Params))
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, c.Params)
+
+	// Postgres aborts any statement that takes longer than this.
+	if c.StatementTimeout > 0 {
+		dsn += fmt.Sprintf(" statement_timeout=%d", c.StatementTimeout.Milliseconds())
+	}
+
+	// Wrap the driver to log slow queries.
+	queryLog = querylog.New(c.SlowQueryThreshold, lo)
+	conn, err := queryLog.Connector(dsn)
 	if err != nil {
 		lo.Fatalf("error connecting to DB: %v", err)
 	}
 
+	lo.Printf("connecting to db: %s:%d/%s", c.Host, c.Port, c.DBName)
+	db := sqlx.NewDb(sql.OpenDB(conn), "postgres")
+	if err := db.Ping(); err != nil {
+		lo.Fatalf("error connecting to DB: %v", err)
+	}
+
 	db.SetMaxOpenConns(c.MaxOpen)
 	db.SetMaxIdleConns(c.MaxIdle)
 	db.SetConnMaxLifetime(c.MaxLifetime)
+	db.SetConnMaxIdleTime(c.MaxIdleTime)
 
 	return db
 }
@@ -342,8 +436,13 @@ func prepareQueries(qMap goyesql.Queries, db *sqlx.DB, ko *koanf.Koanf) *models.
 	}
 
 	// These don't exist in the SQL file but are in the queries struct to be prepared.
+	// Views from prefetching image proxies are optionally excluded from view analytics.
+	viewsTable := "campaign_views"
+	if ko.Bool("privacy.discount_proxy_opens") {
+		viewsTable = "(SELECT * FROM campaign_views WHERE NOT proxy) AS campaign_views"
+	}
 	qMap["get-campaign-view-counts"] = &goyesql.Query{
-		Query: fmt.Sprintf(qMap[countQuery].Query, "campaign_views"),
+		Query: fmt.Sprintf(qMap[countQuery].Query, viewsTable),
 		Tags:  map[string]string{"name": "get-campaign-view-counts"},
 	}
 	qMap["get-campaign-click-counts"] = &goyesql.Query{
@@ -351,6 +450,8 @@ func prepareQueries(qMap goyesql.Queries, db *sqlx.DB, ko *koanf.Koanf) *models.
 		Tags:  map[string]string{"name": "get-campaign-click-counts"},
 	}
 	qMap["get-campaign-link-counts"].Query = fmt.Sprintf(qMap["get-campaign-link-counts"].Query, linkSel)
+	qMap["get-campaign-comparison"].Query = fmt.Sprintf(qMap["get-campaign-comparison"].Query, linkSel, linkSel, linkSel)
+	qMap["get-campaign-top-links"].Query = fmt.Sprintf(qMap["get-campaign-top-links"].Query, linkSel, linkSel)
 
 	// Scan and prepare all queries.
 	var q models.Queries
@@ -358,6 +459,13 @@ func prepareQueries(qMap goyesql.Queries, db *sqlx.DB, ko *koanf.Koanf) *models.
 		lo.Fatalf("error preparing SQL queries: %v", err)
 	}
 
+	// Map the queries to their names for the slow query log.
+	names := make(map[string]string, len(qMap))
+	for name, q := range qMap {
+		names[q.Query] = name
+	}
+	queryLog.SetNames(names)
+
 	return &q
 }
 
@@ -381,11 +489,43 @@ func initSettings(query string, db *sqlx.DB, ko *koanf.Koanf) {
 	if err := json.Unmarshal(s, &out); err != nil {
 		lo.Fatalf("error unmarshalling settings from DB: %v", err)
 	}
+
+	// Decrypt the secrets that are encrypted at rest.
+	if err := sealer.DecryptFields(out, models.SecretSettings); err != nil {
+		lo.Fatalf("error decrypting settings from DB: %v. Check app.secrets_key", err)
+	}
+
+	// Replace secret references (file:, vault:, ssm:) with the secrets.
+	if err := secretResolver.ResolveMap(out); err != nil {
+		lo.Fatalf("error resolving secret in settings: %v", err)
+	}
+
 	if err := ko.Load(confmap.Provider(out, "."), nil); err != nil {
 		lo.Fatalf("error parsing settings from DB: %v", err)
 	}
 }
 
+// initSigningKey generates and stores the random key that's used to sign public
+// URLs if there isn't one already, and loads it into the given Koanf map.
+func initSigningKey(query string, db *sqlx.DB, ko *koanf.Koanf) {
+	if ko.String("security.signing_key") != "" {
+		return
+	}
+
+	newKey, err := generateRandomString(64)
+	if err != nil {
+		lo.Fatalf("error generating signing key: %v", err)
+	}
+
+	// If another instance has stored a key in the meantime, that's returned.
+	var key string
+	if err := db.Get(&key, query, newKey); err != nil {
+		lo.Fatalf("error storing signing key: %v", err)
+	}
+
+	ko.Set("security.signing_key", key)
+}
+
 func initConstants() *constants {
 	// Read constants.
 	var c constants
@@ -406,11 +546,70 @@ func initConstants() *constants {
 	c.RootURL = strings.TrimRight(c.RootURL, "/")
 	c.LoginURL = path.Join(uriAdmin, "/login")
 	c.Lang = ko.String("app.lang")
+	c.I18nOverrideDir = ko.String("i18n-override-dir")
 	c.Privacy.Exportable = maps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
 	c.MediaUpload.Provider = ko.String("upload.provider")
 	c.MediaUpload.Extensions = ko.Strings("upload.extensions")
+
+	c.BodyLimit.Default = defaultBodyLimit
+	if ko.Exists("app.body_limit") {
+		c.BodyLimit.Default = ko.Int64("app.body_limit")
+	}
+	c.BodyLimit.Media = defaultMediaBodyLimit
+	if ko.Exists("app.body_limit_media") {
+		c.BodyLimit.Media = ko.Int64("app.body_limit_media")
+	}
+	c.BodyLimit.Import = defaultImportBodyLimit
+	if ko.Exists("app.body_limit_import") {
+		c.BodyLimit.Import = ko.Int64("app.body_limit_import")
+	}
+
+	// Campaign schedules without a timezone are in the default timezone.
+	c.DefaultLocation = time.UTC
+	if c.DefaultTimezone != "" {
+		l, err := time.LoadLocation(c.DefaultTimezone)
+		if err != nil {
+			lo.Printf("invalid app.default_timezone '%s': %v. Using UTC.", c.DefaultTimezone, err)
+		} else {
+			c.DefaultLocation = l
+		}
+	}
+	c.DefaultTimezone = c.DefaultLocation.String()
+
+	c.CampaignBody.CompressSize = defaultCampaignBodyCompressSize
+	if ko.Exists("app.campaign_body_compress_size") {
+		c.CampaignBody.CompressSize = ko.Int("app.campaign_body_compress_size")
+	}
+	c.CampaignBody.Storage = core.CampaignBodyStorageDB
+	if ko.String("app.campaign_body_storage") == core.CampaignBodyStorageMedia {
+		c.CampaignBody.Storage = core.CampaignBodyStorageMedia
+	}
+
+	c.Reports.DBRole = ko.String("reports.db_role")
+	c.Reports.Timeout = defaultReportTimeout
+	if d := ko.Duration("reports.timeout"); d > 0 {
+		c.Reports.Timeout = d
+	}
+	c.Reports.MaxRows = defaultReportMaxRows
+	if n := ko.Int("reports.max_rows"); n > 0 {
+		c.Reports.MaxRows = n
+	}
+	c.Reports.KeepResults = defaultReportKeepResults
+	if n := ko.Int("reports.keep_results"); n > 0 {
+		c.Reports.KeepResults = n
+	}
+
 	c.Privacy.DomainBlocklist = ko.Strings("privacy.domain_blocklist")
 
+	for _, s := range ko.Strings("privacy.bot_click_ips") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			lo.Printf("ignoring invalid bot click IP range '%s': %v", s, err)
+			continue
+		}
+		c.Privacy.BotClickNets = append(c.Privacy.BotClickNets, n)
+	}
+
 	// Static URLS.
 	// url.com/subscription/{campaign_uuid}/{subscriber_uuid}
 	c.UnsubURL = fmt.Sprintf("%s/subscription/%%s/%%s", c.RootURL)
@@ -421,12 +620,22 @@ func initConstants() *constants {
 	// url.com/link/{campaign_uuid}/{subscriber_uuid}/{link_uuid}
 	c.LinkTrackURL = fmt.Sprintf("%s/link/%%s/%%s/%%s", c.RootURL)
 
+	// url.com/l/{slug}
+	c.ShortLinkURL = fmt.Sprintf("%s/l/%%s", c.RootURL)
+
 	// url.com/link/{campaign_uuid}/{subscriber_uuid}
-	c.MessageURL = fmt.Sprintf("%s/campaign/%%s/%%s", c.RootURL)
+	c.MessageURL = fmt.Sprintf("%s/campaign/%%s/%%s?sig=%%s", c.RootURL)
+	c.RSVPURL = fmt.Sprintf("%s/campaign/%%s/%%s/rsvp/%%s?sig=%%s", c.RootURL)
+
+	// url.com/subscription/form?ref={referral_code}
+	c.ReferralURL = fmt.Sprintf("%s/subscription/form?ref=%%s", c.RootURL)
 
 	// url.com/archive
 	c.ArchiveURL = c.RootURL + "/archive"
 
+	// url.com/files/{filename}?exp={timestamp}&sig={signature}
+	c.FileURL = fmt.Sprintf("%s/files/%%s?exp=%%d&sig=%%s", c.RootURL)
+
 	// url.com/campaign/{campaign_uuid}/{subscriber_uuid}/px.png
 	c.ViewTrackURL = fmt.Sprintf("%s/campaign/%%s/%%s/px.png", c.RootURL)
 
@@ -435,6 +644,8 @@ func initConstants() *constants {
 	c.BounceSendgridEnabled = ko.Bool("bounce.sendgrid_enabled")
 	c.BouncePostmarkEnabled = ko.Bool("bounce.postmark.enabled")
 	c.BounceForwardemailEnabled = ko.Bool("bounce.forwardemail.enabled")
+	c.BounceRetryMaxAttempts = ko.Int("bounce.retry_max_attempts")
+	c.StripeEnabled = ko.Bool("billing.stripe.enabled")
 	c.HasLegacyUser = ko.Exists("app.admin_username") || ko.Exists("app.admin_password")
 
 	b := md5.Sum([]byte(time.Now().String()))
@@ -469,8 +680,8 @@ func initConstants() *constants {
 // loaded from the filesystem. English is a loaded first as the default map
 // and then the selected language is loaded on top of it so that if there are
 // missing translations in it, the default English translations show up.
-func initI18n(lang string, fs stuffbin.FileSystem) *i18n.I18n {
-	i, ok, err := getI18nLang(lang, fs)
+func initI18n(lang string, fs stuffbin.FileSystem, overrideDir string) *i18n.I18n {
+	i, ok, err := getI18nLang(lang, fs, overrideDir)
 	if err != nil {
 		if ok {
 			lo.Println(err)
@@ -484,38 +695,103 @@ func initI18n(lang string, fs stuffbin.FileSystem) *i18n.I18n {
 // initCampaignManager initializes the campaign manager.
 func initCampaignManager(q *models.Queries, cs *constants, app *App) *manager.Manager {
 	campNotifCB := func(subject string, data interface{}) error {
-		return app.sendNotification(cs.NotifyEmails, subject, notifTplCampaign, data, nil)
+		return app.notify(notifs.Notif{Event: notifs.EventCampaign, Subject: subject, Data: data, Tpl: notifTplCampaign})
 	}
 
 	if ko.Bool("passive") {
 		lo.Println("running in passive mode. won't process campaigns.")
 	}
 
+	// The instance's sender profile for campaign templates.
+	var sender models.SenderProfile
+	if err := ko.UnmarshalWithConf("sender", &sender, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading sender profile: %v", err)
+	}
+
 	return manager.New(manager.Config{
 		BatchSize:             ko.Int("app.batch_size"),
 		Concurrency:           ko.Int("app.concurrency"),
 		MessageRate:           ko.Int("app.message_rate"),
+		AdaptiveConcurrency:   ko.Bool("app.adaptive_concurrency"),
+		MinConcurrency:        ko.Int("app.min_concurrency"),
+		AdaptiveLatency:       ko.Duration("app.adaptive_latency"),
+		AdaptiveErrorRate:     float64(ko.Int("app.adaptive_error_rate")) / 100,
+		Sender:                sender,
+		SimulationMessenger:   devnull.Name,
+		BreakerThreshold:      ko.Int("app.breaker_threshold"),
+		BreakerCooldown:       ko.Duration("app.breaker_cooldown"),
 		MaxSendErrors:         ko.Int("app.max_send_errors"),
+		OutboxReviewThreshold: ko.Int("app.outbox_review_threshold"),
 		FromEmail:             cs.FromEmail,
 		IndividualTracking:    ko.Bool("privacy.individual_tracking"),
 		UnsubURL:              cs.UnsubURL,
 		OptinURL:              cs.OptinURL,
 		LinkTrackURL:          cs.LinkTrackURL,
+		ShortLinkURL:          cs.ShortLinkURL,
+		ShortLinks:            ko.Bool("app.short_links"),
 		ViewTrackURL:          cs.ViewTrackURL,
 		MessageURL:            cs.MessageURL,
+		RSVPURL:               cs.RSVPURL,
+		ReferralURL:           cs.ReferralURL,
+		SigningKey:            []byte(cs.Security.SigningKey),
+		PublicLinkExpiry:      ko.Duration("privacy.signed_link_expiry"),
+		AttachmentMaxSize:     ko.Int64("app.attachment_max_size"),
+		AttachmentTimeout:     ko.Duration("app.attachment_timeout"),
+		AttachmentCacheSize:   ko.Int64("app.attachment_cache_size"),
+		AttachmentCacheTTL:    ko.Duration("app.attachment_cache_ttl"),
 		ArchiveURL:            cs.ArchiveURL,
+		FileURL:               cs.FileURL,
+		FileURLExpiry:         ko.Duration("app.file_url_expiry"),
 		RootURL:               cs.RootURL,
 		UnsubHeader:           ko.Bool("privacy.unsubscribe_header"),
+		FeedbackID:            ko.Bool("feedback_id.enabled"),
+		FeedbackIDSender:      ko.String("feedback_id.sender"),
+		ReplyTracking:         ko.Bool("replies.enabled"),
+		Sanitize: sanitize.Policy{
+			Enabled:        ko.Bool("security.sanitize_enabled"),
+			Mode:           ko.String("security.sanitize_mode"),
+			MaxNameLen:     ko.Int("security.sanitize_max_name_len"),
+			MaxAttribLen:   ko.Int("security.sanitize_max_attrib_len"),
+			AllowedAttribs: ko.Strings("security.sanitize_allowed_attribs"),
+			RawFields:      ko.Strings("security.sanitize_raw_fields"),
+		},
+		TemplateFuncs:         ko.Strings("security.template_funcs"),
+		RenderTimeout:         ko.Duration("security.template_render_timeout"),
+		RenderMaxSize:         ko.Int("security.template_max_size") * 1024,
+		DarkModeMeta:          ko.Bool("app.dark_mode_meta"),
 		SlidingWindow:         ko.Bool("app.message_sliding_window"),
 		SlidingWindowDuration: ko.Duration("app.message_sliding_window_duration"),
 		SlidingWindowRate:     ko.Int("app.message_sliding_window_rate"),
-		ScanInterval:          time.Second * 5,
-		ScanCampaigns:         !ko.Bool("passive"),
-	}, newManagerStore(q, app.core, app.media), campNotifCB, app.i18n, lo)
+		SlidingWindowCB: func(sent int, wait time.Duration) {
+			app.notify(notifs.Notif{
+				Event:   notifs.EventQuota,
+				Subject: app.i18n.T("email.alert.quotaTitle"),
+				Message: app.i18n.Ts("email.alert.quota", "num", fmt.Sprintf("%d", sent),
+					"window", ko.Duration("app.message_sliding_window_duration").String(), "wait", wait.Round(time.Second).String()),
+				Data: map[string]interface{}{"sent": sent, "wait": wait.Seconds()},
+			})
+		},
+		MessageSentCB: func(c *models.Campaign, sub models.Subscriber) {
+			app.publishEvent(eventbus.TypeMessageSent, eventbus.Message{
+				CampaignID:     c.ID,
+				CampaignUUID:   c.UUID,
+				SubscriberID:   sub.ID,
+				SubscriberUUID: sub.UUID,
+				Email:          sub.Email,
+				Messenger:      c.Messenger,
+			})
+		},
+		LoadLang: func(code string) *i18n.I18n {
+			return app.getLang(code).i18n
+		},
+		ScanInterval:  time.Second * 5,
+		ScanCampaigns: !ko.Bool("passive"),
+		IsLeader:      app.isLeader,
+	}, newManagerStore(q, app.core, app.media, app.db.Unsafe()), campNotifCB, app.i18n, lo)
 }
 
 func initTxTemplates(m *manager.Manager, app *App) {
-	tpls, err := app.core.GetTemplates(models.TemplateTypeTx, false)
+	tpls, err := app.core.GetTemplates(models.TemplateTypeTx, false, 0)
 	if err != nil {
 		lo.Fatalf("error loading transactional templates: %v", err)
 	}
@@ -534,22 +810,57 @@ func initTxTemplates(m *manager.Manager, app *App) {
 func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, app *App) *subimporter.Importer {
 	return subimporter.New(
 		subimporter.Options{
-			DomainBlocklist:    app.constants.Privacy.DomainBlocklist,
+			DomainBlocklist: app.constants.Privacy.DomainBlocklist,
+			Policy: subimporter.Policy{
+				BlockRoleAccounts: app.constants.Privacy.BlockRoleAccounts,
+				RoleAccounts:      app.constants.Privacy.RoleAccounts,
+				CheckMX:           app.constants.Privacy.CheckMX,
+				DenyPatterns:      app.constants.Privacy.EmailDenyPatterns,
+			},
 			UpsertStmt:         q.UpsertSubscriber.Stmt,
 			BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
+			CountEmailsStmt:    q.CountSubscribersByEmails.Stmt,
 			UpdateListDateStmt: q.UpdateListsDate.Stmt,
+			Concurrency:        ko.Int("app.import_concurrency"),
 			NotifCB: func(subject string, data interface{}) error {
 				// Refresh cached subscriber counts and stats.
 				core.RefreshMatViews(true)
 
-				app.sendNotification(app.constants.NotifyEmails, subject, notifTplImport, data, nil)
+				core.RecordEvent(models.EventLogImport, subject, data, 0)
+				app.notify(notifs.Notif{Event: notifs.EventImport, Subject: subject, Data: data, Tpl: notifTplImport})
 				return nil
 			},
 		}, db.DB, app.i18n)
 }
 
+// initTracker initializes the buffered campaign view and link click tracker.
+func initTracker(app *App) *tracker.Tracker {
+	return tracker.New(tracker.Opt{
+		FlushInterval: trackerFlushInterval,
+		BatchSize:     trackerBatchSize,
+		MaxBuffer:     trackerMaxBuffer,
+	}, app.core, app.log)
+}
+
 // initSMTPMessenger initializes the SMTP messenger.
 func initSMTPMessenger(m *manager.Manager) manager.Messenger {
+	// Deliver e-mails directly to the recipients' mail servers instead of
+	// via SMTP servers.
+	if ko.Bool("smtp_mx.enabled") {
+		msgr := email.NewMX(email.MXOpt{
+			HelloHostname: ko.String("smtp_mx.hello_hostname"),
+			RequireTLS:    ko.Bool("smtp_mx.require_tls"),
+			MaxConns:      ko.Int("smtp_mx.max_conns"),
+			IdleTimeout:   ko.Duration("smtp_mx.idle_timeout"),
+			MaxRetries:    ko.Int("smtp_mx.max_retries"),
+			RetryInterval: ko.Duration("smtp_mx.retry_interval"),
+		})
+		msgr.SetCrypt(initCrypt())
+		lo.Println("loaded email messenger with direct MX delivery")
+
+		return msgr
+	}
+
 	var (
 		mapKeys = ko.MapKeys("smtp")
 		servers = make([]email.Server, 0, len(mapKeys))
@@ -586,9 +897,27 @@ func initSMTPMessenger(m *manager.Manager) manager.Messenger {
 		lo.Fatalf("error loading e-mail messenger: %v", err)
 	}
 
+	msgr.SetCrypt(initCrypt())
+
 	return msgr
 }
 
+// initCrypt returns the config for S/MIME signing and encryption to
+// subscribers' public keys.
+func initCrypt() email.Crypt {
+	cr := email.Crypt{Encrypt: ko.Bool("security.encrypt_messages")}
+	if ko.Bool("security.smime_sign") {
+		s, err := mailcrypt.NewSigner(ko.String("security.smime_cert"), ko.String("security.smime_key"))
+		if err != nil {
+			lo.Fatalf("error loading S/MIME signing certificate: %v", err)
+		}
+		cr.Signer = s
+		lo.Println("S/MIME signing outgoing e-mails")
+	}
+
+	return cr
+}
+
 // initPostbackMessengers initializes and returns all the enabled
 // HTTP postback messenger backends.
 func initPostbackMessengers(m *manager.Manager) []manager.Messenger {
@@ -625,6 +954,14 @@ func initPostbackMessengers(m *manager.Manager) []manager.Messenger {
 	return out
 }
 
+// initDevnullMessenger initializes the devnull messenger that discards messages.
+func initDevnullMessenger() manager.Messenger {
+	return devnull.New(devnull.Options{
+		Latency:   ko.Duration("simulation.latency"),
+		ErrorRate: ko.Float64("simulation.error_rate"),
+	})
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore() media.Store {
 	switch provider := ko.String("upload.provider"); provider {
@@ -694,6 +1031,45 @@ func initNotifTemplates(path string, fs stuffbin.FileSystem, i *i18n.I18n, cs *c
 	return out
 }
 
+// initLangPacks initializes the language pack cache with the default language
+// and its compiled public page templates.
+func initLangPacks(app *App) *langPacks {
+	tpl, err := loadPublicTpls(app.i18n, app)
+	if err != nil {
+		lo.Fatalf("error parsing public templates: %v", err)
+	}
+
+	return &langPacks{
+		def: &langPack{
+			i18n:      app.i18n,
+			pubTpls:   tpl,
+			notifTpls: app.notifTpls.tpls,
+		},
+		packs: make(map[string]*langPack),
+	}
+}
+
+// initNotifs initializes the admin notification channels.
+func initNotifs(app *App) *notifs.Notifs {
+	var chans []notifs.Channel
+	if err := ko.UnmarshalWithConf("notifications", &chans, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading notification channels config: %v", err)
+	}
+
+	// E-mail channels render notifications with their own templates, or the generic alert template.
+	email := func(to []string, n notifs.Notif) error {
+		if n.Tpl == "" || n.Tpl == notifTplAlert {
+			return app.sendNotification(to, n.Subject, notifTplAlert, n, nil)
+		}
+		return app.sendNotification(to, n.Subject, n.Tpl, n.Data, nil)
+	}
+
+	return notifs.New(notifs.Opt{
+		Channels: chans,
+		RootURL:  app.constants.RootURL,
+	}, email, lo)
+}
+
 // initBounceManager initializes the bounce manager that scans mailboxes and listens to webhooks
 // for incoming bounce events.
 func initBounceManager(app *App) *bounce.Manager {
@@ -719,6 +1095,7 @@ func initBounceManager(app *App) *bounce.Manager {
 			ko.String("bounce.forwardemail.key"),
 		},
 		RecordBounceCB: app.core.RecordBounce,
+		IsLeader:       app.isLeader,
 	}
 
 	// For now, only one mailbox is supported.
@@ -772,6 +1149,14 @@ func initAbout(q *models.Queries, db *sqlx.DB) about {
 		GoArch:    runtime.GOARCH,
 		GoVersion: runtime.Version(),
 		Database:  info,
+		DBPool: aboutDBPool{
+			MaxOpen:            ko.Int("db.max_open"),
+			MaxIdle:            ko.Int("db.max_idle"),
+			MaxLifetime:        ko.Duration("db.max_lifetime").String(),
+			MaxIdleTime:        ko.Duration("db.max_idle_time").String(),
+			StatementTimeout:   ko.Duration("db.statement_timeout").String(),
+			SlowQueryThreshold: ko.Duration("db.slow_query_threshold").String(),
+		},
 		System: aboutSystem{
 			NumCPU: runtime.NumCPU(),
 		},
@@ -790,6 +1175,9 @@ func initHTTPServer(app *App) *echo.Echo {
 	var srv = echo.New()
 	srv.HideBanner = true
 
+	// Route requests on the custom domains of lists to their public pages.
+	srv.Pre(listDomainRouter(app))
+
 	// Register app (*App) to be injected into all HTTP handlers.
 	srv.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -798,12 +1186,7 @@ func initHTTPServer(app *App) *echo.Echo {
 		}
 	})
 
-	tpl, err := stuffbin.ParseTemplatesGlob(initTplFuncs(app.i18n, app.constants), app.fs, "/public/templates/*.html")
-	if err != nil {
-		lo.Fatalf("error parsing public templates: %v", err)
-	}
 	srv.Renderer = &tplRenderer{
-		templates:           tpl,
 		SiteName:            app.constants.SiteName,
 		RootURL:             app.constants.RootURL,
 		LogoURL:             app.constants.LogoURL,
@@ -828,6 +1211,9 @@ func initHTTPServer(app *App) *echo.Echo {
 		srv.Static(ko.String("upload.filesystem.upload_uri"), ko.String("upload.filesystem.upload_path"))
 	}
 
+	// Limit the size of request bodies.
+	srv.Use(bodyLimiter(app))
+
 	// Register all HTTP handlers.
 	initHTTPHandlers(srv, app)
 
@@ -851,11 +1237,78 @@ func initCaptcha() *captcha.Captcha {
 	})
 }
 
-func initCron(core *core.Core) {
+func initStripe() *stripe.Stripe {
+	if ko.String("billing.stripe.webhook_secret") == "" {
+		lo.Println("WARNING: billing.stripe.webhook_secret is not set. Stripe webhooks will be rejected.")
+	}
+
+	return stripe.New(stripe.Opt{
+		SecretKey:     ko.String("billing.stripe.secret_key"),
+		WebhookSecret: ko.String("billing.stripe.webhook_secret"),
+	})
+}
+
+func initWarehouse() *warehouse.Warehouse {
+	var o warehouse.Opt
+	if err := ko.Unmarshal("warehouse", &o); err != nil {
+		lo.Fatalf("error unmarshalling warehouse config: %v", err)
+	}
+
+	w, err := warehouse.New(o)
+	if err != nil {
+		lo.Fatalf("error initializing warehouse export: %v", err)
+	}
+	lo.Printf("warehouse export destination: %s", o.Destination)
+
+	return w
+}
+
+// initEventBus initializes the event bus that internal events are published to.
+func initEventBus() *eventbus.Bus {
+	var o eventbus.Opt
+	if err := ko.Unmarshal("eventbus", &o); err != nil {
+		lo.Fatalf("error unmarshalling eventbus config: %v", err)
+	}
+
+	b, err := eventbus.New(o, lo)
+	if err != nil {
+		lo.Fatalf("error initializing event bus: %v", err)
+	}
+	lo.Printf("publishing events to %s", o.Driver)
+
+	return b
+}
+
+// initReplies initializes the watcher that records replies to campaign
+// messages from the reply mailbox.
+func initReplies(app *App) *replies.Watcher {
+	var o replies.Opt
+	if err := ko.Unmarshal("replies", &o); err != nil {
+		lo.Fatalf("error unmarshalling replies config: %v", err)
+	}
+
+	w, err := replies.New(o, replies.Hooks{
+		Record:       app.core.InsertCampaignReply,
+		ForwardEmail: app.forwardReply,
+		IsLeader:     app.isLeader,
+	}, lo)
+	if err != nil {
+		lo.Fatalf("error initializing reply tracking: %v", err)
+	}
+
+	return w
+}
+
+func initCron(app *App) {
 	c := cron.New()
 	_, err := c.Add(ko.MustString("app.cache_slow_queries_interval"), func() {
+		// With multiple instances, only the leader refreshes the cache.
+		if !app.isLeader() {
+			return
+		}
+
 		lo.Println("refreshing slow query cache")
-		_ = core.RefreshMatViews(true)
+		_ = app.core.RefreshMatViews(true)
 		lo.Println("done refreshing slow query cache")
 	})
 	if err != nil {
@@ -867,7 +1320,7 @@ func initCron(core *core.Core) {
 	lo.Printf("IMPORTANT: database slow query caching is enabled. Aggregate numbers and stats will not be realtime. Next refresh at: %v", c.Entries()[0].Next)
 }
 
-func awaitReload(sigChan chan os.Signal, closerWait chan bool, closer func()) chan bool {
+func awaitReload(sigChan chan os.Signal, closerWait chan bool, timeout time.Duration, closer func()) chan bool {
 	// The blocking signal handler that main() waits on.
 	out := make(chan bool)
 
@@ -889,7 +1342,7 @@ func awaitReload(sigChan chan os.Signal, closerWait chan bool, closer func()) ch
 			case <-closerWait:
 				// Wait for the closer to finish.
 				respawn()
-			case <-time.After(time.Second * 3):
+			case <-time.After(timeout + time.Second*10):
 				// Or timeout and force close.
 				respawn()
 			}
@@ -928,6 +1381,18 @@ func initTplFuncs(i *i18n.I18n, cs *constants) template.FuncMap {
 		"L": func() *i18n.I18n {
 			return i
 		},
+		"IsRTL": func() bool {
+			return i.IsRTL()
+		},
+		"TextDir": func() string {
+			return i.Dir()
+		},
+		"FormatDate": func(t time.Time, layout string) string {
+			return i.FormatDate(t, layout)
+		},
+		"FormatNumber": func(n float64, decimals int) string {
+			return i.FormatNumber(n, decimals)
+		},
 		"Safe": func(safeHTML string) template.HTML {
 			return template.HTML(safeHTML)
 		},
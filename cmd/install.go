@@ -8,6 +8,7 @@ import (
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/lib/pq"
@@ -119,6 +120,11 @@ func installLists(q *models.Queries) (int, int) {
 		models.ListOptinSingle,
 		pq.StringArray{"test"},
 		"",
+		"",
+		"",
+		"",
+		models.OptinMethodLink,
+		"",
 	); err != nil {
 		lo.Fatalf("error creating list: %v", err)
 	}
@@ -129,6 +135,11 @@ func installLists(q *models.Queries) (int, int) {
 		models.ListOptinDouble,
 		pq.StringArray{"test"},
 		"",
+		"",
+		"",
+		"",
+		models.OptinMethodLink,
+		"",
 	); err != nil {
 		lo.Fatalf("error creating list: %v", err)
 	}
@@ -145,7 +156,8 @@ func installSubs(defListID, optinListID int, q *models.Queries) {
 		`{"type": "known", "good": true, "city": "Bengaluru"}`,
 		pq.Int64Array{int64(defListID)},
 		models.SubscriptionStatusUnconfirmed,
-		true); err != nil {
+		subimporter.ConflictOverwrite,
+		models.SourceAdmin); err != nil {
 		lo.Fatalf("Error creating subscriber: %v", err)
 	}
 	if _, err := q.UpsertSubscriber.Exec(
@@ -155,7 +167,8 @@ func installSubs(defListID, optinListID int, q *models.Queries) {
 		`{"type": "unknown", "good": true, "city": "Bengaluru"}`,
 		pq.Int64Array{int64(optinListID)},
 		models.SubscriptionStatusUnconfirmed,
-		true); err != nil {
+		subimporter.ConflictOverwrite,
+		models.SourceAdmin); err != nil {
 		lo.Fatalf("error creating subscriber: %v", err)
 	}
 }
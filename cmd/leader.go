@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	"github.com/knadh/listmonk/internal/leader"
+)
+
+// leaderCheckInterval is the interval at which instances campaign for
+// leadership and the leader checks that it still holds it.
+const leaderCheckInterval = time.Second * 10
+
+// initLeader returns the leader elector if leader election (app.leader_election)
+// is enabled for running multiple instances against the same DB.
+func initLeader(app *App) *leader.Elector {
+	if !ko.Bool("app.leader_election") {
+		return nil
+	}
+
+	lo.Println("leader election is enabled. scheduled jobs will only run on the leader instance")
+	return leader.New(app.db, leaderCheckInterval, lo)
+}
+
+// isLeader returns true if this instance should run scheduled jobs
+// (campaign processing, bounce mailbox scans, pruning, syncs). Without leader
+// election, every non-passive instance is the leader.
+func (app *App) isLeader() bool {
+	if app.leader == nil {
+		return true
+	}
+	return app.leader.IsLeader()
+}
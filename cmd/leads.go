@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// Maximum number of submissions per minute from an IP that a lead form can allow.
+	maxLeadRateLimit = 1000
+
+	// leadRateWindow is the window of the lead form rate limits.
+	leadRateWindow = time.Minute
+)
+
+// leadHits counts the lead form submissions per form and IP.
+var leadHits = &rateLimiter{hits: make(map[string]*rateWindow)}
+
+// rateWindow is the number of hits in a fixed window that started at start.
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+// rateLimiter is a fixed window, in-memory rate limiter. Limits apply per
+// instance and are reset on restarts.
+type rateLimiter struct {
+	hits map[string]*rateWindow
+	sync.Mutex
+}
+
+// allow records a hit for the given key and returns false if the key has
+// more than limit hits in the current window.
+func (r *rateLimiter) allow(key string, limit int, window time.Duration, t time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	// Periodically sweep expired windows so that the map doesn't grow unbounded.
+	if len(r.hits) > 10000 {
+		for k, w := range r.hits {
+			if t.Sub(w.start) > window {
+				delete(r.hits, k)
+			}
+		}
+	}
+
+	w, ok := r.hits[key]
+	if !ok || t.Sub(w.start) > window {
+		w = &rateWindow{start: t}
+		r.hits[key] = w
+	}
+	w.n++
+
+	return w.n <= limit
+}
+
+// handleGetLeadForms returns all lead forms.
+func handleGetLeadForms(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := app.core.GetLeadForms()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetLeadForm returns a single lead form.
+func handleGetLeadForm(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetLeadForm(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateLeadForm creates a new lead form with a random API key.
+func handleCreateLeadForm(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	o := models.LeadForm{Enabled: true}
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateLeadForm(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateLeadForm(o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateLeadForm updates a lead form.
+func handleUpdateLeadForm(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var o models.LeadForm
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateLeadForm(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateLeadForm(id, o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRegenerateLeadFormKey replaces a lead form's API key. Submissions
+// with the old key are rejected.
+func handleRegenerateLeadFormKey(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.RegenerateLeadFormKey(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteLeadForm deletes a lead form.
+func handleDeleteLeadForm(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteLeadForm(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handlePublicLead captures a lead submitted by a website form. The form is
+// identified by its API key, sent in the X-Lead-Key header or the key field.
+// The subscriber is created or updated, tagged, and subscribed to the form's
+// lists, and the form's transactional template, if any, is sent to them.
+func handlePublicLead(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Key      string      `json:"key" form:"key"`
+			Email    string      `json:"email" form:"email"`
+			Name     string      `json:"name" form:"name"`
+			Lang     string      `json:"lang" form:"lang"`
+			Attribs  models.JSON `json:"attribs" form:"-"`
+			Referrer string      `json:"referrer" form:"referrer"`
+
+			UTMSource   string `json:"utm_source" form:"utm_source"`
+			UTMMedium   string `json:"utm_medium" form:"utm_medium"`
+			UTMCampaign string `json:"utm_campaign" form:"utm_campaign"`
+			UTMTerm     string `json:"utm_term" form:"utm_term"`
+			UTMContent  string `json:"utm_content" form:"utm_content"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	key := c.Request().Header.Get("X-Lead-Key")
+	if key == "" {
+		key = req.Key
+	}
+	if !strHasLen(key, 1, 100) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.T("leads.invalidKey"))
+	}
+
+	form, err := app.core.GetLeadFormByKey(key)
+	if err != nil {
+		return err
+	}
+
+	if !leadHits.allow(fmt.Sprintf("%d:%s", form.ID, c.RealIP()), form.RateLimit, leadRateWindow, time.Now()) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, app.i18n.T("leads.rateLimited"))
+	}
+
+	// Validate fields.
+	sr, err := app.importer.ValidateFields(subimporter.SubReq{Subscriber: models.Subscriber{
+		Email:   req.Email,
+		Name:    req.Name,
+		Attribs: req.Attribs,
+	}})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !strHasLen(sr.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidName"))
+	}
+
+	// Unknown language codes are ignored.
+	if len(req.Lang) > 6 || reLangCode.MatchString(req.Lang) {
+		req.Lang = ""
+	}
+
+	utm := models.LeadUTM{
+		Source:   req.UTMSource,
+		Medium:   req.UTMMedium,
+		Campaign: req.UTMCampaign,
+		Term:     req.UTMTerm,
+		Content:  req.UTMContent,
+	}
+	for _, v := range []string{utm.Source, utm.Medium, utm.Campaign, utm.Term, utm.Content} {
+		if !strHasLen(v, 0, maxUTMParamLen) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "utm"))
+		}
+	}
+
+	if req.Referrer == "" {
+		req.Referrer = c.Request().Referer()
+	}
+	if len(req.Referrer) > stdInputMaxLen {
+		req.Referrer = req.Referrer[:stdInputMaxLen]
+	}
+
+	sub := sr.Subscriber
+	sub.Lang = req.Lang
+	sub.Source = fmt.Sprintf("%s:%d", models.SourceLead, form.ID)
+
+	sub, created, hasOptin, err := app.core.CaptureLead(form, sub, utm, req.Referrer)
+	if err != nil {
+		return err
+	}
+
+	// Record the proof of consent on the subscriptions.
+	if app.constants.Privacy.RecordConsent && len(form.ListIDs) > 0 {
+		listIDs := make([]int, 0, len(form.ListIDs))
+		for _, id := range form.ListIDs {
+			listIDs = append(listIDs, int(id))
+		}
+		if err := app.core.RecordConsent(sub.ID, listIDs, nil, makeConsent(c, models.SourceLead)); err != nil {
+			app.log.Printf("error recording lead consent: %v", err)
+		}
+	}
+
+	// Send the form's transactional message to the lead. Blocklisted
+	// subscribers aren't sent anything.
+	if form.TxTemplateID.Valid && sub.Status != models.SubscriberStatusBlockListed && !app.manager.IsHalted() {
+		data := map[string]interface{}{
+			"form":    form.Name,
+			"created": created,
+			"utm":     utm,
+		}
+		if err := sendTxTemplate(int(form.TxTemplateID.Int), sub, data, app); err != nil {
+			app.log.Printf("error sending lead form (%s) message: %v", form.Name, err)
+		}
+	}
+
+	// HTML form submissions are redirected to the form's URL.
+	if form.RedirectURL != "" && !strings.HasPrefix(c.Request().Header.Get("Content-Type"), echo.MIMEApplicationJSON) {
+		return c.Redirect(http.StatusSeeOther, form.RedirectURL)
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		HasOptin bool `json:"has_optin"`
+	}{hasOptin}})
+}
+
+// validateLeadForm validates the fields of a lead form.
+func validateLeadForm(o models.LeadForm, app *App) (models.LeadForm, error) {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	if o.RateLimit == 0 {
+		o.RateLimit = 10
+	}
+	if o.RateLimit < 1 || o.RateLimit > maxLeadRateLimit {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "rate_limit"))
+	}
+
+	for _, id := range o.ListIDs {
+		if id < 1 {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "lists"))
+		}
+	}
+
+	if o.TxTemplateID.Int < 1 {
+		o.TxTemplateID = null.Int{}
+	} else {
+		tpl, err := app.core.GetTemplate(int(o.TxTemplateID.Int), true)
+		if err != nil || tpl.Type != models.TemplateTypeTx {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "tx_template_id"))
+		}
+	}
+
+	o.RedirectURL = strings.TrimSpace(o.RedirectURL)
+	if o.RedirectURL != "" {
+		u, err := url.Parse(o.RedirectURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(o.RedirectURL) > stdInputMaxLen {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "redirect_url"))
+		}
+	}
+
+	return o, nil
+}
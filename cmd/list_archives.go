@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// listArchiveVersion is the version of the portable list archive format.
+const listArchiveVersion = 1
+
+// handleExportListArchive sends a list, its subscribers, and their subscription
+// states as a portable JSON list archive that can be imported into another instance.
+func handleExportListArchive(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	list, err := app.core.GetList(id, "")
+	if err != nil {
+		return err
+	}
+
+	lb, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// The archive is built in a temporary file before anything is sent so that
+	// errors midway are returned as errors and not as a truncated archive.
+	f, err := os.CreateTemp("", "listmonk-list-archive")
+	if err != nil {
+		app.log.Printf("error creating list archive: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			app.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", err.Error()))
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if err := writeListArchive(f, lb, app.core.ExportListArchive(id, app.constants.DBBatchSize)); err != nil {
+		app.log.Printf("error creating list archive: %v", err)
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			app.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", err.Error()))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentDisposition, "attachment; filename="+"list-"+list.UUID+".json")
+	h.Set("Cache-Control", "no-cache")
+
+	return c.Stream(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, f)
+}
+
+// writeListArchive writes a list archive with the JSON encoded list and the
+// subscribers from next, which are fetched in batches. The archive is written
+// by hand so that subscribers don't have to be held in memory. The list is always
+// written before the subscribers as imports rely on the order.
+func writeListArchive(w io.Writer, list []byte, next func() ([]models.ListArchiveSubscriber, error)) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(`{"version":` + strconv.Itoa(listArchiveVersion) + `,"list":` + string(list) + `,"subscribers":[`); err != nil {
+		return err
+	}
+
+	first := true
+	for {
+		out, err := next()
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			break
+		}
+
+		for _, s := range out {
+			b, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+
+			if !first {
+				if err := bw.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			if _, err := bw.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := bw.WriteString("]}"); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// handleImportListArchive creates a list from an uploaded list archive and imports
+// its subscribers with their subscription states.
+func handleImportListArchive(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	// Creating the list requires lists:manage_all (on the route) and creating subscribers
+	// requires subscribers:import.
+	if !isSuperAdmin(user) && !user.HasPerm(models.PermSubscribersImport) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermSubscribersImport))
+	}
+
+	// Stream the archive to disk instead of buffering it in memory.
+	src, vals, err := streamFormFile(c, "file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+	defer src.Close()
+
+	var (
+		preserveUUIDs, _ = strconv.ParseBool(vals["preserve_uuids"])
+		overwrite, _     = strconv.ParseBool(vals["overwrite"])
+	)
+
+	dec := json.NewDecoder(src)
+	list, err := readListArchiveHeader(dec, app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+
+	// Iterate through the streamed subscribers, skipping invalid records.
+	skipped := 0
+	next := func() (*models.ListArchiveSubscriber, error) {
+		for dec.More() {
+			var s models.ListArchiveSubscriber
+			if err := dec.Decode(&s); err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest,
+					app.i18n.Ts("import.invalidFile", "error", err.Error()))
+			}
+
+			if !validateListArchiveSubscriber(&s, app) {
+				skipped++
+				continue
+			}
+
+			return &s, nil
+		}
+
+		return nil, nil
+	}
+
+	out, n, err := app.core.ImportListArchive(list, preserveUUIDs, overwrite, next)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		List     models.List `json:"list"`
+		Imported int         `json:"imported"`
+		Skipped  int         `json:"skipped"`
+	}{out, n, skipped}})
+}
+
+// readListArchiveHeader reads a list archive from the decoder up to the start of
+// the subscribers array and returns the validated list. The decoder is left positioned
+// on the first subscriber (if any).
+func readListArchiveHeader(dec *json.Decoder, app *App) (models.List, error) {
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return models.List{}, errors.New("expected a JSON object")
+	}
+
+	var (
+		list    models.List
+		version int
+	)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return models.List{}, err
+		}
+
+		switch t {
+		case "version":
+			if err := dec.Decode(&version); err != nil {
+				return models.List{}, err
+			}
+			if version != listArchiveVersion {
+				return models.List{}, errors.New("unsupported archive version " + strconv.Itoa(version))
+			}
+
+		case "list":
+			if err := dec.Decode(&list); err != nil {
+				return models.List{}, err
+			}
+
+		case "subscribers":
+			if version == 0 || list.Name == "" {
+				return models.List{}, errors.New("version and list should precede subscribers")
+			}
+			if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+				return models.List{}, errors.New("expected an array of subscribers")
+			}
+			if err := validateListFields(&list, app); err != nil {
+				return models.List{}, err
+			}
+
+			// An invalid UUID is replaced with a generated one on import.
+			list.UUID = strings.ToLower(strings.TrimSpace(list.UUID))
+			if !reUUID.MatchString(list.UUID) {
+				list.UUID = ""
+			}
+			if list.Type != models.ListTypePrivate && list.Type != models.ListTypePublic {
+				list.Type = models.ListTypePrivate
+			}
+			if list.Optin != models.ListOptinSingle && list.Optin != models.ListOptinDouble {
+				list.Optin = models.ListOptinSingle
+			}
+			return list, nil
+
+		default:
+			// Skip unknown fields.
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return models.List{}, err
+			}
+		}
+	}
+
+	return models.List{}, io.ErrUnexpectedEOF
+}
+
+// validateListArchiveSubscriber validates and sanitizes a subscriber record from a
+// list archive and returns false if it's invalid.
+func validateListArchiveSubscriber(s *models.ListArchiveSubscriber, app *App) bool {
+	// Invalid UUIDs are replaced with generated ones on import.
+	s.UUID = strings.ToLower(strings.TrimSpace(s.UUID))
+	if !reUUID.MatchString(s.UUID) {
+		s.UUID = ""
+	}
+
+	em, err := app.importer.SanitizeEmail(s.Email)
+	if err != nil {
+		return false
+	}
+	s.Email = em
+
+	if !strHasLen(s.Name, 1, stdInputMaxLen) {
+		s.Name = em
+	}
+
+	switch s.Status {
+	case models.SubscriberStatusEnabled, models.SubscriberStatusDisabled, models.SubscriberStatusBlockListed:
+	case "":
+		s.Status = models.SubscriberStatusEnabled
+	default:
+		return false
+	}
+
+	switch s.SubscriptionStatus {
+	case models.SubscriptionStatusUnconfirmed, models.SubscriptionStatusConfirmed, models.SubscriptionStatusUnsubscribed:
+	case "":
+		s.SubscriptionStatus = models.SubscriptionStatusUnconfirmed
+	default:
+		return false
+	}
+
+	// Blocklisted subscribers are always unsubscribed.
+	if s.Status == models.SubscriberStatusBlockListed {
+		s.SubscriptionStatus = models.SubscriptionStatusUnsubscribed
+	}
+
+	return true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// handleGetListGroups handles retrieval of list groups along with their member
+// lists and aggregate subscriber stats.
+func handleGetListGroups(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	// Fetch one group.
+	if id > 0 {
+		out, err := app.core.GetListGroup(id)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out, err := app.core.GetListGroups()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateListGroup handles list group creation.
+func handleCreateListGroup(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	var g models.ListGroup
+	if err := c.Bind(&g); err != nil {
+		return err
+	}
+
+	g.Name = strings.TrimSpace(g.Name)
+	if !strHasLen(g.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidName"))
+	}
+
+	out, err := app.core.CreateListGroup(g)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateListGroup handles list group modification.
+func handleUpdateListGroup(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var g models.ListGroup
+	if err := c.Bind(&g); err != nil {
+		return err
+	}
+
+	g.Name = strings.TrimSpace(g.Name)
+	if !strHasLen(g.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidName"))
+	}
+
+	out, err := app.core.UpdateListGroup(id, g)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteListGroup handles list group deletion.
+func handleDeleteListGroup(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteListGroup(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleSetListGroupLists sets the member lists of a list group.
+func handleSetListGroupLists(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		ListIDs []int `json:"lists"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Validate the group.
+	if _, err := app.core.GetListGroup(id); err != nil {
+		return err
+	}
+
+	out, err := app.core.SetListGroupLists(id, req.ListIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
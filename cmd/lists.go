@@ -1,15 +1,33 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// Number of subscribers on a list's referral leaderboard.
+	defaultReferrers = 20
+	maxReferrers     = 100
+
+	// Number of matching subscribers returned in a dynamic list preview.
+	dynamicListPreviewSize = 20
+
+	// Interval at which the custom domains of lists are reloaded from the DB.
+	listDomainsRefreshInterval = time.Minute
+)
+
 // handleGetLists retrieves lists with additional metadata like subscriber counts.
 func handleGetLists(c echo.Context) error {
 	var (
@@ -84,6 +102,30 @@ func handleGetList(c echo.Context) error {
 		return err
 	}
 
+	setETag(c, out.Version)
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetListReferrers returns the referral leaderboard of a list.
+func handleGetListReferrers(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		listID, _ = strconv.Atoi(c.Param("id"))
+		limit, _  = strconv.Atoi(c.QueryParam("limit"))
+	)
+
+	if listID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+	if limit < 1 || limit > maxReferrers {
+		limit = defaultReferrers
+	}
+
+	out, err := app.core.GetListReferrers(listID, limit)
+	if err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -99,14 +141,16 @@ func handleCreateList(c echo.Context) error {
 	}
 
 	// Validate.
-	if !strHasLen(l.Name, 1, stdInputMaxLen) {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidName"))
+	if err := validateListFields(&l, app); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	out, err := app.core.CreateList(l)
 	if err != nil {
 		return err
 	}
+	app.refreshListDomains()
+	app.syncDynamicList(out)
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -128,19 +172,126 @@ func handleUpdateList(c echo.Context) error {
 		return err
 	}
 
+	// Reject the update if the list has been edited by someone else in the meantime.
+	cur, err := app.core.GetList(id, "")
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, cur.Version, cur, l, app, listVersionFields...); err != nil {
+		return err
+	}
+
 	// Validate.
-	if !strHasLen(l.Name, 1, stdInputMaxLen) {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidName"))
+	if err := validateListFields(&l, app); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	out, err := app.core.UpdateList(id, l)
 	if err != nil {
 		return err
 	}
+	app.refreshListDomains()
+	app.syncDynamicList(out)
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// listVersionFields are the fields of a list that are compared on concurrent edits.
+var listVersionFields = []string{"name", "type", "optin", "tags", "description", "logo_url",
+	"lang", "stripe_price_id", "optin_method", "domain", "rules", "compliance_profile", "sender_profile"}
+
+// validateListFields validates and sanitizes incoming list field values.
+func validateListFields(l *models.List, app *App) error {
+	if !strHasLen(l.Name, 1, stdInputMaxLen) {
+		return errors.New(app.i18n.T("lists.invalidName"))
+	}
+
+	l.LogoURL = strings.TrimSpace(l.LogoURL)
+	if l.LogoURL != "" {
+		u, err := url.Parse(l.LogoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(l.LogoURL) > 2000 {
+			return errors.New(app.i18n.T("lists.invalidLogoURL"))
+		}
+	}
+
+	l.Lang = strings.TrimSpace(l.Lang)
+	if len(l.Lang) > 6 || reLangCode.MatchString(l.Lang) {
+		return errors.New(app.i18n.T("lists.invalidLang"))
+	}
+
+	switch l.OptinMethod {
+	case "", models.OptinMethodLink, models.OptinMethodCode, models.OptinMethodSMS:
+	default:
+		return errors.New(app.i18n.T("lists.invalidOptinMethod"))
+	}
+
+	l.StripePriceID = strings.TrimSpace(l.StripePriceID)
+	if len(l.StripePriceID) > stdInputMaxLen || strings.ContainsAny(l.StripePriceID, " /") {
+		return errors.New(app.i18n.T("lists.invalidStripePrice"))
+	}
+
+	// Dynamic lists. Their members are computed from the rules and can't
+	// be asked to confirm or subscribe themselves.
+	if l.Rules.Valid {
+		if _, err := core.CompileListRules(l.Rules.JSON); err != nil {
+			return errors.New(app.i18n.Ts("subscribers.invalidFilter", "error", err.Error()))
+		}
+		if l.Type == models.ListTypePublic || l.Optin == models.ListOptinDouble || l.StripePriceID != "" {
+			return errors.New(app.i18n.T("lists.invalidDynamic"))
+		}
+	}
+
+	switch l.ComplianceProfile {
+	case "", models.ComplianceCANSPAM, models.ComplianceCASL, models.ComplianceGDPR:
+	default:
+		return errors.New(app.i18n.T("lists.invalidComplianceProfile"))
+	}
+
+	sp, err := validateSenderProfile(l.SenderProfile, app)
+	if err != nil {
+		return err
+	}
+	l.SenderProfile = sp
+
+	// The root URL's domain can't be taken over by a list.
+	l.Domain = strings.ToLower(strings.TrimSpace(l.Domain))
+	if l.Domain != "" {
+		u, _ := url.Parse(app.constants.RootURL)
+		if len(l.Domain) > 253 || !reHostname.MatchString(l.Domain) || (u != nil && u.Hostname() == l.Domain) {
+			return errors.New(app.i18n.T("lists.invalidDomain"))
+		}
+	}
+
+	return nil
+}
+
+// handlePreviewDynamicList returns the number of subscribers that match the
+// given dynamic list rules and the first few of them.
+func handlePreviewDynamicList(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Rules json.RawMessage `json:"rules"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	res, total, err := app.core.PreviewDynamicList(req.Rules, dynamicListPreviewSize)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{models.PageResults{
+		Results: res,
+		Total:   total,
+		PerPage: dynamicListPreviewSize,
+		Page:    1,
+	}})
+}
+
 // handleDeleteLists handles list deletion, either a single one (ID in the URI), or a list.
 func handleDeleteLists(c echo.Context) error {
 	var (
@@ -160,6 +311,7 @@ func handleDeleteLists(c echo.Context) error {
 	if err := app.core.DeleteLists(ids); err != nil {
 		return err
 	}
+	app.refreshListDomains()
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
@@ -197,3 +349,54 @@ func listPerm(next echo.HandlerFunc) echo.HandlerFunc {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.permissionDenied", "name", "list"))
 	}
 }
+
+// syncDynamicList recomputes the members of a dynamic list in the background
+// after it's saved.
+func (app *App) syncDynamicList(l models.List) {
+	if !l.Rules.Valid {
+		return
+	}
+
+	go func() {
+		if _, err := app.core.SyncDynamicList(l.ID, l.Rules.JSON); err != nil {
+			app.log.Printf("error syncing dynamic list %d: %v", l.ID, err)
+		}
+	}()
+}
+
+// refreshListDomains reloads the custom public domains of lists that
+// are used for host based routing of public pages.
+func (app *App) refreshListDomains() {
+	doms, err := app.core.GetListDomains()
+	if err != nil {
+		return
+	}
+
+	app.listDomainsMu.Lock()
+	app.listDomains = doms
+	app.listDomainsMu.Unlock()
+}
+
+// runListDomainsRefresher periodically reloads the custom public domains of
+// lists so that changes made on other nodes are picked up. This blocks and is
+// meant to be run in a goroutine.
+func runListDomainsRefresher(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		app.refreshListDomains()
+	}
+}
+
+// getListDomain returns the UUID of the list whose custom domain is the given
+// request host, if any.
+func (app *App) getListDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	app.listDomainsMu.RLock()
+	defer app.listDomainsMu.RUnlock()
+
+	return app.listDomains[strings.ToLower(host)]
+}
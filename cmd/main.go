@@ -20,42 +20,68 @@ import (
 	"github.com/knadh/listmonk/internal/buflog"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/leader"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/messenger/devnull"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/querylog"
+	"github.com/knadh/listmonk/internal/replies"
+	"github.com/knadh/listmonk/internal/seal"
+	"github.com/knadh/listmonk/internal/secrets"
+	"github.com/knadh/listmonk/internal/stripe"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/suppression"
+	"github.com/knadh/listmonk/internal/tracker"
+	"github.com/knadh/listmonk/internal/warehouse"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/paginator"
 	"github.com/knadh/stuffbin"
+	"github.com/labstack/echo/v4"
 )
 
 const (
 	emailMsgr = "email"
+
+	// defaultShutdownTimeout is the time to wait for running campaigns and
+	// requests to finish on shutdown if app.shutdown_timeout isn't set.
+	defaultShutdownTimeout = time.Second * 30
 )
 
 // App contains the "global" components that are
 // passed around, especially through HTTP handlers.
 type App struct {
-	core       *core.Core
-	fs         stuffbin.FileSystem
-	db         *sqlx.DB
-	queries    *models.Queries
-	constants  *constants
-	manager    *manager.Manager
-	importer   *subimporter.Importer
-	messengers map[string]manager.Messenger
-	auth       *auth.Auth
-	media      media.Store
-	i18n       *i18n.I18n
-	bounce     *bounce.Manager
-	paginator  *paginator.Paginator
-	captcha    *captcha.Captcha
-	events     *events.Events
-	notifTpls  *notifTpls
-	about      about
-	log        *log.Logger
-	bufLog     *buflog.BufLog
+	core        *core.Core
+	fs          stuffbin.FileSystem
+	db          *sqlx.DB
+	queries     *models.Queries
+	constants   *constants
+	manager     *manager.Manager
+	importer    *subimporter.Importer
+	tracker     *tracker.Tracker
+	messengers  map[string]manager.Messenger
+	auth        *auth.Auth
+	media       media.Store
+	i18n        *i18n.I18n
+	bounce      *bounce.Manager
+	paginator   *paginator.Paginator
+	captcha     *captcha.Captcha
+	stripe      *stripe.Stripe
+	suppression *suppression.Fetcher
+	warehouse   *warehouse.Warehouse
+	replies     *replies.Watcher
+	bus         *eventbus.Bus
+	events      *events.Events
+	notifTpls   *notifTpls
+	langs       *langPacks
+	notifs      *notifs.Notifs
+	leader      *leader.Elector
+	about       about
+	log         *log.Logger
+	bufLog      *buflog.BufLog
 
 	// Channel for passing reload signals.
 	chReload chan os.Signal
@@ -69,6 +95,15 @@ type App struct {
 
 	// Global state that stores data on an available remote update.
 	update *AppUpdate
+
+	// Custom public domains of lists (domain => list UUID). They're looked up
+	// on every public request and have their own lock.
+	listDomains   map[string]string
+	listDomainsMu sync.RWMutex
+
+	// The current (or last) background job that blocklists or deletes
+	// subscribers by domain.
+	domainJob *domainJob
 	sync.Mutex
 }
 
@@ -79,10 +114,17 @@ var (
 	lo       = log.New(io.MultiWriter(os.Stdout, bufLog, evStream.ErrWriter()), "",
 		log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
 
-	ko      = koanf.New(".")
-	fs      stuffbin.FileSystem
-	db      *sqlx.DB
-	queries *models.Queries
+	ko       = koanf.New(".")
+	fs       stuffbin.FileSystem
+	db       *sqlx.DB
+	queries  *models.Queries
+	queryLog *querylog.QueryLog
+
+	// Encrypts the secrets in the settings at rest. nil if there's no key.
+	sealer *seal.Seal
+
+	// Resolves secret references (file:, vault:, ssm:) in the config and settings.
+	secretResolver *secrets.Resolver
 
 	// Compile-time variables.
 	buildString   string
@@ -128,6 +170,12 @@ func init() {
 		lo.Fatalf("error loading config from env: %v", err)
 	}
 
+	// Replace secret references in the config with secrets from their providers.
+	secretResolver = initSecrets(ko)
+	resolveConfigSecrets(secretResolver, ko)
+
+	sealer = initSeal(ko)
+
 	// Connect to the database, load the filesystem to read SQL queries.
 	db = initDB()
 	fs = initFS(appDir, frontendDir, ko.String("static-dir"), ko.String("i18n-dir"))
@@ -147,8 +195,18 @@ func init() {
 		lo.Fatal("the database does not appear to be setup. Run --install.")
 	}
 
+	if ko.Bool("doctor") {
+		doctor(db, fs)
+		os.Exit(0)
+	}
+
 	if ko.Bool("upgrade") {
-		upgrade(db, fs, !ko.Bool("yes"))
+		upgrade(db, fs, !ko.Bool("yes"), ko.Bool("dry-run"))
+		os.Exit(0)
+	}
+
+	if ko.Bool("encrypt-settings") {
+		encryptSettings(db, sealer)
 		os.Exit(0)
 	}
 
@@ -162,6 +220,9 @@ func init() {
 	if q, ok := qMap["get-settings"]; ok {
 		initSettings(q.Query, db, ko)
 	}
+	if q, ok := qMap["init-signing-key"]; ok {
+		initSigningKey(q.Query, db, ko)
+	}
 
 	// Prepare queries.
 	queries = prepareQueries(qMap, db, ko)
@@ -192,29 +253,61 @@ func main() {
 	}
 
 	// Load i18n language map.
-	app.i18n = initI18n(app.constants.Lang, fs)
+	app.i18n = initI18n(app.constants.Lang, fs, app.constants.I18nOverrideDir)
 	cOpt := &core.Opt{
 		Constants: core.Constants{
 			SendOptinConfirmation: app.constants.SendOptinConfirmation,
 			CacheSlowQueries:      ko.Bool("app.cache_slow_queries"),
+
+			CampaignBodyCompressSize: app.constants.CampaignBody.CompressSize,
+			CampaignBodyStorage:      app.constants.CampaignBody.Storage,
+
+			ReportsDBRole:      app.constants.Reports.DBRole,
+			ReportsTimeout:     app.constants.Reports.Timeout,
+			ReportsMaxRows:     app.constants.Reports.MaxRows,
+			ReportsKeepResults: app.constants.Reports.KeepResults,
 		},
 		Queries: queries,
 		DB:      db,
 		I18n:    app.i18n,
 		Log:     lo,
+		Seal:    sealer,
+
+		BodyStore: app.media,
 	}
 
 	if err := ko.Unmarshal("bounce.actions", &cOpt.Constants.BounceActions); err != nil {
 		lo.Fatalf("error unmarshalling bounce config: %v", err)
 	}
 
+	// Publish internal events to a Kafka topic or NATS subject.
+	if ko.Bool("eventbus.enabled") {
+		app.bus = initEventBus()
+		app.bus.Start()
+	}
+
 	app.core = core.New(cOpt, &core.Hooks{
 		SendOptinConfirmation: sendOptinConfirmationHook(app),
+		PublishEvent:          app.publishEvent,
+		ValidateEmailPolicy: func(email string) error {
+			return app.importer.ValidatePolicy(email)
+		},
 	})
 
 	app.queries = queries
+
+	// Elect a leader among multiple instances sharing the DB to run scheduled jobs.
+	// Passive instances never run them.
+	if !ko.Bool("passive") {
+		app.leader = initLeader(app)
+	}
+	if app.leader != nil {
+		go app.leader.Run()
+	}
+
 	app.manager = initCampaignManager(app.queries, app.constants, app)
 	app.importer = initImporter(app.queries, db, app.core, app)
+	app.tracker = initTracker(app)
 
 	hasUsers, auth := initAuth(db.DB, ko, app.core)
 	app.auth = auth
@@ -223,8 +316,19 @@ func main() {
 	app.needsUserSetup = !hasUsers
 
 	app.notifTpls = initNotifTemplates("/email-templates/*.html", fs, app.i18n, app.constants)
+	app.langs = initLangPacks(app)
+	app.notifs = initNotifs(app)
 	initTxTemplates(app.manager, app)
 
+	if app.constants.StripeEnabled {
+		app.stripe = initStripe()
+	}
+
+	// Load the custom public domains of lists for host based routing, and reload
+	// them periodically to pick up changes made on other nodes.
+	app.refreshListDomains()
+	go runListDomainsRefresher(listDomainsRefreshInterval, app)
+
 	if ko.Bool("bounce.enabled") {
 		app.bounce = initBounceManager(app)
 		go app.bounce.Run()
@@ -233,6 +337,10 @@ func main() {
 	// Initialize the default SMTP (`email`) messenger.
 	app.messengers[emailMsgr] = initSMTPMessenger(app.manager)
 
+	// Initialize the built-in messenger that discards messages, for simulating
+	// campaigns.
+	app.messengers[devnull.Name] = initDevnullMessenger()
+
 	// Initialize any additional postback messengers.
 	for _, m := range initPostbackMessengers(app.manager) {
 		app.messengers[m.Name()] = m
@@ -248,16 +356,76 @@ func main() {
 
 	// Start cronjobs.
 	if cOpt.Constants.CacheSlowQueries {
-		initCron(app.core)
+		initCron(app)
+	}
+
+	// Reload language files in the override directory when they change.
+	if app.constants.I18nOverrideDir != "" {
+		go watchI18nOverrides(app.constants.I18nOverrideDir, time.Second*10, app)
+	}
+
+	// Periodically purge expired items from the trash.
+	if app.constants.TrashRetentionDays > 0 {
+		go runTrashPurger(app.constants.TrashRetentionDays, app)
+	}
+
+	// Watch running campaigns' bounce and complaint rates against the alert rules.
+	if !ko.Bool("passive") {
+		go runAlertMonitor(alertMonitorInterval, app)
+	}
+
+	// Schedule the follow-ups of campaigns that have finished.
+	if !ko.Bool("passive") {
+		go runFollowupScheduler(followupScheduleInterval, app)
+	}
+
+	// Remove the unconfirmed subscriptions of re-permission runs past their deadlines.
+	if !ko.Bool("passive") {
+		go runRepermissionProcessor(repermissionProcessInterval, app)
+	}
+
+	// Run the saved reports that have schedules.
+	if !ko.Bool("passive") {
+		go runReportScheduler(reportScheduleInterval, app)
+	}
+
+	// Export tables to the data warehouse on their schedules.
+	if ko.Bool("warehouse.enabled") {
+		app.warehouse = initWarehouse()
+		if !ko.Bool("passive") {
+			go runWarehouseExporter(warehouseScheduleInterval, app)
+		}
+	}
+
+	// Record replies to campaign messages from the reply mailbox.
+	if ko.Bool("replies.enabled") {
+		app.replies = initReplies(app)
+		if !ko.Bool("passive") {
+			go app.replies.Run()
+		}
+	}
+
+	// Periodically sync external suppression lists into the blocklist.
+	app.suppression = suppression.New(time.Minute * 2)
+	if !ko.Bool("passive") {
+		go runSuppressionSync(suppressionSyncInterval, app)
 	}
 
 	// Start the campaign workers. The campaign batches (fetch from DB, push out
 	// messages) get processed at the specified interval.
 	go app.manager.Run()
 
+	// Start the buffered view and click tracker.
+	app.tracker.Start()
+
 	// Start the app server.
 	srv := initHTTPServer(app)
 
+	// Re-fetch external secrets periodically and reload the app when they change.
+	if d := ko.Duration("secrets.refresh_interval"); d > 0 && secretResolver.NumRefs() > 0 {
+		go runSecretsRefresh(d, app)
+	}
+
 	// Star the update checker.
 	if ko.Bool("app.check_updates") {
 		go checkUpdates(versionString, time.Hour*24, app)
@@ -269,25 +437,73 @@ func main() {
 	app.chReload = make(chan os.Signal)
 	signal.Notify(app.chReload, syscall.SIGHUP)
 
-	closerWait := make(chan bool)
-	<-awaitReload(app.chReload, closerWait, func() {
-		// Stop the HTTP server.
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		srv.Shutdown(ctx)
-
-		// Close the campaign manager.
-		app.manager.Close()
+	// Drain running campaigns and flush buffers before exiting on termination.
+	shutdownTimeout := ko.Duration("app.shutdown_timeout")
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
 
-		// Close the DB pool.
-		app.db.DB.Close()
+	chStop := make(chan os.Signal, 1)
+	signal.Notify(chStop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-chStop
+		lo.Println("shutting down ...")
+		shutdown(srv, shutdownTimeout, app)
+		os.Exit(0)
+	}()
 
-		// Close the messenger pool.
-		for _, m := range app.messengers {
-			m.Close()
-		}
+	closerWait := make(chan bool)
+	<-awaitReload(app.chReload, closerWait, shutdownTimeout, func() {
+		shutdown(srv, shutdownTimeout, app)
 
 		// Signal the close.
 		closerWait <- true
 	})
 }
+
+// shutdown gracefully shuts down the app's components within the timeout. The
+// HTTP server stops accepting requests and finishes the ones in progress, the
+// running campaigns finish their current batches and save their checkpoints,
+// buffered views and clicks are flushed, and the messenger pools are closed.
+func shutdown(srv *echo.Echo, timeout time.Duration, app *App) {
+	start := time.Now()
+
+	// Stop the HTTP server.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		lo.Printf("error shutting down HTTP server: %v", err)
+	}
+
+	// Let the running campaigns finish their batches and save their checkpoints.
+	if !app.manager.Drain(timeout - time.Since(start)) {
+		lo.Println("could not save the state of all running campaigns before the shutdown timeout")
+	}
+	app.manager.Close()
+
+	// Flush buffered views and clicks.
+	app.tracker.Close()
+
+	// Publish the queued events.
+	if app.bus != nil {
+		app.bus.Close()
+	}
+
+	// Release leadership so that another instance takes over right away.
+	if app.leader != nil {
+		app.leader.Close()
+	}
+
+	// Flush and close the messenger pools.
+	for _, m := range app.messengers {
+		if err := m.Flush(); err != nil {
+			lo.Printf("error flushing messenger %s: %v", m.Name(), err)
+		}
+		m.Close()
+	}
+
+	// Close the DB pool.
+	app.db.DB.Close()
+
+	lo.Printf("shut down in %s", time.Since(start).Round(time.Millisecond))
+}
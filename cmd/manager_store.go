@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
+	"strings"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
@@ -17,6 +21,7 @@ type store struct {
 	queries *models.Queries
 	core    *core.Core
 	media   media.Store
+	db      *sqlx.DB
 	h       *http.Client
 }
 
@@ -26,13 +31,18 @@ type runningCamp struct {
 	LastSubscriberID int    `db:"last_subscriber_id"`
 	MaxSubscriberID  int    `db:"max_subscriber_id"`
 	ListID           int    `db:"list_id"`
+	SubscriberQuery  string `db:"subscriber_query"`
+	RetryOf          int    `db:"retry_of"`
+	RetryAttempt     int    `db:"retry_attempt"`
+	IsFollowup       bool   `db:"is_followup"`
 }
 
-func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
+func newManagerStore(q *models.Queries, c *core.Core, m media.Store, db *sqlx.DB) *store {
 	return &store{
 		queries: q,
 		core:    c,
 		media:   m,
+		db:      db,
 	}
 }
 
@@ -40,9 +50,41 @@ func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
 // campaigns that are also being processed. Additionally, it takes a map of campaignID:sentCount
 // of campaigns that are being processed and updates them in the DB.
 func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error) {
+	// The members of dynamic lists are computed right before their campaigns are
+	// picked up so that the to_send counts include them.
+	if err := s.core.SyncCampaignsDynamicLists(currentIDs); err != nil {
+		return nil, err
+	}
+
 	var out []*models.Campaign
-	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts))
-	return out, err
+	if err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts)); err != nil {
+		return nil, err
+	}
+
+	// Load compressed bodies. Campaigns whose bodies can't be loaded (eg: the
+	// media store object is missing) are paused with the error instead of failing
+	// all of them or being picked up again on every run.
+	camps := make([]*models.Campaign, 0, len(out))
+	for _, c := range out {
+		if err := s.core.LoadCampaignBody(c); err != nil {
+			lo.Printf("error loading body of campaign %d: %v. pausing it", c.ID, err)
+			if _, err := s.queries.UpdateCampaignStatus.Exec(c.ID, models.CampaignStatusPaused); err != nil {
+				lo.Printf("error pausing campaign %d: %v", c.ID, err)
+				continue
+			}
+			s.core.RecordCampaignErrorEvent(c.ID, c.Name, models.CampaignStatusPaused, err)
+			continue
+		}
+
+		// The list based to_send of campaigns that target a saved subscriber query
+		// is replaced with the count of the query's recipients.
+		if err := s.core.UpdateCampaignQueryToSend(c); err != nil {
+			lo.Printf("error counting subscribers of campaign %d: %v", c.ID, err)
+		}
+		camps = append(camps, c)
+	}
+
+	return camps, nil
 }
 
 // NextSubscribers retrieves a subset of subscribers of a given campaign.
@@ -64,22 +106,134 @@ func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error)
 		return nil, nil
 	}
 
-	var out []models.Subscriber
-	err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
+	var (
+		c   = camps[0]
+		out []models.Subscriber
+	)
+
+	// The campaign targets a saved subscriber query. The expression has already been
+	// validated to be readonly when the query was saved.
+	var exp string
+	if c.SubscriberQuery != "" {
+		exp = " AND " + c.SubscriberQuery
+	}
+
+	// Retries only go to the soft-bounced recipients of the campaign they retry.
+	if c.RetryAttempt > 0 {
+		exp += " AND " + core.RetryQuery(c.RetryOf)
+	}
+
+	// Follow-ups only go to the openers or clickers of their parent campaigns.
+	if c.IsFollowup {
+		exp += " AND " + core.FollowupQuery(c.CampaignID)
+	}
+
+	if exp != "" {
+		return s.nextSubscribersByQuery(c, exp, listIDs, limit)
+	}
+
+	err := s.queries.NextCampaignSubscribers.Select(&out, c.CampaignID, c.CampaignType, c.LastSubscriberID, c.MaxSubscriberID, pq.Array(listIDs), limit)
 	return out, err
 }
 
+// nextSubscribersByQuery retrieves the next batch of subscribers of a campaign
+// that's filtered by a subscriber query expression. The expression is arbitrary,
+// so the batch is fetched in a read-only transaction and the checkpoint is
+// moved forward separately.
+func (s *store) nextSubscribersByQuery(c runningCamp, exp string, listIDs []int, limit int) ([]models.Subscriber, error) {
+	stmt := strings.ReplaceAll(s.queries.NextCampaignSubscribersByQuery, "%query%", exp)
+
+	tx, err := s.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var out []models.Subscriber
+	if err := tx.Select(&out, stmt, c.CampaignID, c.CampaignType, c.LastSubscriberID, c.MaxSubscriberID, pq.Array(listIDs), limit); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	if _, err := s.queries.UpdateCampaignCheckpoint.Exec(c.CampaignID, out[len(out)-1].ID); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 // GetCampaign fetches a campaign from the database.
 func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
 	var out = &models.Campaign{}
-	err := s.queries.GetCampaign.Get(out, campID, nil, nil, "default")
+	if err := s.queries.GetCampaign.Get(out, campID, nil, nil, "default"); err != nil {
+		return out, err
+	}
+
+	err := s.core.LoadCampaignBody(out)
 	return out, err
 }
 
 // UpdateCampaignStatus updates a campaign's status.
 func (s *store) UpdateCampaignStatus(campID int, status string) error {
-	_, err := s.queries.UpdateCampaignStatus.Exec(campID, status)
-	return err
+	if _, err := s.queries.UpdateCampaignStatus.Exec(campID, status); err != nil {
+		return err
+	}
+
+	if c, err := s.GetCampaign(campID); err == nil {
+		s.core.RecordCampaignEvent(campID, c.Name, status, 0)
+	}
+	return nil
+}
+
+// PauseCampaign pauses a campaign and records the error due to which it was
+// paused in the event log, and the messenger whose circuit breaker paused it, if any.
+func (s *store) PauseCampaign(campID int, reason error, breaker string) error {
+	if _, err := s.queries.PauseCampaign.Exec(campID, breaker); err != nil {
+		return err
+	}
+
+	if c, err := s.GetCampaign(campID); err == nil {
+		s.core.RecordCampaignErrorEvent(campID, c.Name, models.CampaignStatusPaused, reason)
+	}
+	return nil
+}
+
+// ResumeCampaign sets a campaign paused by a circuit breaker to running. It
+// returns false if the campaign isn't paused by a breaker anymore, eg: it has
+// been paused or resumed by hand.
+func (s *store) ResumeCampaign(campID int) (bool, error) {
+	res, err := s.queries.ResumeCampaign.Exec(campID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if c, err := s.GetCampaign(campID); err == nil {
+		s.core.RecordCampaignEvent(campID, c.Name, models.CampaignStatusRunning, 0)
+	}
+	return true, nil
+}
+
+// GetBreakerPausedCampaigns returns the IDs of the campaigns paused by circuit
+// breakers mapped to the messengers whose breakers paused them.
+func (s *store) GetBreakerPausedCampaigns() (map[int]string, error) {
+	var res []struct {
+		ID      int    `db:"id"`
+		Breaker string `db:"paused_by_breaker"`
+	}
+	if err := s.queries.GetBreakerPausedCampaigns.Select(&res); err != nil {
+		return nil, err
+	}
+
+	out := make(map[int]string, len(res))
+	for _, r := range res {
+		out[r.ID] = r.Breaker
+	}
+	return out, nil
 }
 
 // UpdateCampaignCounts updates a campaign's status.
@@ -88,6 +242,82 @@ func (s *store) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID
 	return err
 }
 
+// UpdateCampaignVariantCounts adds to the sent counts of a campaign's language variants.
+func (s *store) UpdateCampaignVariantCounts(campID int, sent map[string]int) error {
+	var (
+		langs  = make([]string, 0, len(sent))
+		counts = make([]int64, 0, len(sent))
+	)
+	for l, n := range sent {
+		langs = append(langs, l)
+		counts = append(counts, int64(n))
+	}
+
+	_, err := s.queries.UpdateCampaignVariantCounts.Exec(campID, pq.Array(langs), pq.Array(counts))
+	return err
+}
+
+// UpdateCampaignDomainCounts adds to the sent counts of a campaign's recipient domains.
+func (s *store) UpdateCampaignDomainCounts(campID int, sent map[string]int) error {
+	var (
+		domains = make([]string, 0, len(sent))
+		counts  = make([]int64, 0, len(sent))
+	)
+	for d, n := range sent {
+		domains = append(domains, d)
+		counts = append(counts, int64(n))
+	}
+
+	_, err := s.queries.UpdateCampaignDomainCounts.Exec(campID, pq.Array(domains), pq.Array(counts))
+	return err
+}
+
+// StartOutbox holds a campaign with up to maxRecipients recipients for review.
+// It returns true if the campaign's messages are to be rendered into the outbox.
+func (s *store) StartOutbox(campID, maxRecipients int) (bool, error) {
+	var n int
+	if err := s.queries.StartCampaignOutbox.Get(&n, campID, maxRecipients); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// SaveOutboxMessage stores a campaign's rendered message in the outbox.
+func (s *store) SaveOutboxMessage(m models.OutboxMessage) error {
+	_, err := s.queries.InsertOutboxMessage.Exec(m.CampaignID, m.SubscriberID, m.Email, m.Subject, m.Body, m.AltBody)
+	return err
+}
+
+// GetOutboxMessages returns a campaign's outbox messages for the given subscribers
+// mapped by the subscriber IDs.
+func (s *store) GetOutboxMessages(campID int, subIDs []int) (map[int]models.OutboxMessage, error) {
+	var res []models.OutboxMessage
+	if err := s.queries.GetOutboxMessages.Select(&res, campID, pq.Array(subIDs)); err != nil {
+		return nil, err
+	}
+
+	out := make(map[int]models.OutboxMessage, len(res))
+	for _, m := range res {
+		out[m.SubscriberID] = m
+	}
+
+	return out, nil
+}
+
+// FinishOutbox pauses a campaign whose messages have all been rendered into
+// the outbox, for approval.
+func (s *store) FinishOutbox(campID int) error {
+	if _, err := s.queries.FinishCampaignOutbox.Exec(campID); err != nil {
+		return err
+	}
+
+	if c, err := s.GetCampaign(campID); err == nil {
+		s.core.RecordCampaignEvent(campID, c.Name, models.CampaignStatusPaused, 0)
+	}
+	return nil
+}
+
 // GetAttachment fetches a media attachment blob.
 func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
 	m, err := s.core.GetMedia(mediaID, "", s.media)
@@ -124,6 +354,21 @@ func (s *store) CreateLink(url string) (string, error) {
 	return out, nil
 }
 
+// CreateLinkSlug registers a short slug for a link in a campaign and returns
+// it. If the link already has a slug in the campaign, that's returned. An empty
+// string is returned if the slug is taken by another link.
+func (s *store) CreateLinkSlug(campID int, linkUUID, slug string) (string, error) {
+	var out string
+	if err := s.queries.CreateLinkSlug.Get(&out, campID, linkUUID, slug); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return out, nil
+}
+
 // RecordBounce records a bounce event and returns the bounce count.
 func (s *store) RecordBounce(b models.Bounce) (int64, int, error) {
 	var res = struct {
@@ -151,3 +396,10 @@ func (s *store) DeleteSubscriber(id int64) error {
 	_, err := s.queries.DeleteSubscribers.Exec(pq.Int64Array{id})
 	return err
 }
+
+// IsSendingHalted returns true if all sending has been halted (the emergency stop).
+func (s *store) IsSendingHalted() (bool, error) {
+	var out bool
+	err := s.queries.GetSendingHalted.Get(&out)
+	return out, err
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
-	"mime/multipart"
+	"crypto/hmac"
+	"io"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -16,6 +20,9 @@ import (
 const (
 	thumbPrefix   = "thumb_"
 	thumbnailSize = 250
+
+	// Length of the random suffix in the stored names of files.
+	fileSuffixLen = 16
 )
 
 var (
@@ -29,24 +36,26 @@ func handleUploadMedia(c echo.Context) error {
 		app     = c.Get("app").(*App)
 		cleanUp = false
 	)
-	file, err := c.FormFile("file")
+	// Stream the file to disk instead of buffering it in memory.
+	file, values, err := streamFormFile(c, "file")
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			app.i18n.Ts("media.invalidFile", "error", err.Error()))
 	}
+	defer file.Close()
 
-	// Read file contents in memory
-	src, err := file.Open()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			app.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+	typ := values["type"]
+	if typ == "" {
+		typ = media.TypeMedia
+	}
+	if typ != media.TypeMedia && typ != media.TypeFile {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
 	}
-	defer src.Close()
 
 	var (
 		// Naive check for content type and extension.
 		ext         = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
-		contentType = file.Header.Get("Content-Type")
+		contentType = file.ContentType
 	)
 	if !isASCII(file.Filename) {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity,
@@ -64,12 +73,18 @@ func handleUploadMedia(c echo.Context) error {
 	// Sanitize filename.
 	fName := makeFilename(file.Filename)
 
-	// Add a random suffix to the filename to ensure uniqueness.
-	suffix, _ := generateRandomString(6)
+	// Add a random suffix to the filename to ensure uniqueness. Files are
+	// only meant to be downloaded with signed URLs, so their names in the
+	// store are made hard to guess.
+	sLen := 6
+	if typ == media.TypeFile {
+		sLen = fileSuffixLen
+	}
+	suffix, _ := generateRandomString(sLen)
 	fName = appendSuffixToFilename(fName, suffix)
 
 	// Upload the file.
-	fName, err = app.media.Put(fName, contentType, src)
+	fName, err = app.media.Put(fName, contentType, file)
 	if err != nil {
 		app.log.Printf("error uploading file: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -94,7 +109,7 @@ func handleUploadMedia(c echo.Context) error {
 	}()
 
 	// Create thumbnail from file for non-vector formats.
-	isImage := inArray(ext, imageExts)
+	isImage := typ == media.TypeMedia && inArray(ext, imageExts)
 	if isImage {
 		thumbFile, w, h, err := processImage(file)
 		if err != nil {
@@ -116,7 +131,7 @@ func handleUploadMedia(c echo.Context) error {
 		}
 		thumbfName = tf
 	}
-	if inArray(ext, vectorExts) {
+	if typ == media.TypeMedia && inArray(ext, vectorExts) {
 		thumbfName = fName
 	}
 
@@ -128,7 +143,7 @@ func handleUploadMedia(c echo.Context) error {
 			"height": height,
 		}
 	}
-	m, err := app.core.InsertMedia(fName, thumbfName, contentType, meta, app.constants.MediaUpload.Provider, app.media)
+	m, err := app.core.InsertMedia(fName, thumbfName, contentType, meta, app.constants.MediaUpload.Provider, typ, app.media)
 	if err != nil {
 		cleanUp = true
 		return err
@@ -142,6 +157,7 @@ func handleGetMedia(c echo.Context) error {
 		app   = c.Get("app").(*App)
 		pg    = app.paginator.NewFromURL(c.Request().URL.Query())
 		query = c.FormValue("query")
+		typ   = c.FormValue("type")
 		id, _ = strconv.Atoi(c.Param("id"))
 	)
 
@@ -154,7 +170,14 @@ func handleGetMedia(c echo.Context) error {
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
-	res, total, err := app.core.QueryMedia(app.constants.MediaUpload.Provider, app.media, query, pg.Offset, pg.Limit)
+	if typ == "" {
+		typ = media.TypeMedia
+	}
+	if typ != media.TypeMedia && typ != media.TypeFile {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	res, total, err := app.core.QueryMedia(app.constants.MediaUpload.Provider, typ, app.media, query, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -191,14 +214,50 @@ func handleDeleteMedia(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// handleDownloadFile serves a file uploaded as the "file" media type and
+// counts the download. This is the link the {{ FileURL "name" }} template tag
+// in campaigns generates, which is signed and expires.
+func handleDownloadFile(c echo.Context) error {
+	var (
+		app    = c.Get("app").(*App)
+		name   = c.Param("name")
+		sig    = c.QueryParam("sig")
+		exp, _ = strconv.ParseInt(c.QueryParam("exp"), 10, 64)
+	)
+
+	if exp < time.Now().Unix() || !hmac.Equal([]byte(sig), []byte(app.manager.FileSig(name, exp))) {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.fileNotFound")))
+	}
+
+	m, err := app.core.RegisterFileDownload(name)
+	if err != nil {
+		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusNotFound {
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.fileNotFound")))
+		}
+
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	b, err := app.media.GetBlob(m.Filename)
+	if err != nil {
+		app.log.Printf("error reading file %s: %v", m.Filename, err)
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	c.Response().Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": m.Filename}))
+	return c.Blob(http.StatusOK, m.ContentType, b)
+}
+
 // processImage reads the image file and returns thumbnail bytes and
 // the original image's width, and height.
-func processImage(file *multipart.FileHeader) (*bytes.Reader, int, int, error) {
-	src, err := file.Open()
-	if err != nil {
+func processImage(src io.ReadSeeker) (*bytes.Reader, int, int, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
 		return nil, 0, 0, err
 	}
-	defer src.Close()
 
 	img, err := imaging.Decode(src)
 	if err != nil {
@@ -6,14 +6,17 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 )
 
 const (
-	notifTplImport       = "import-status"
-	notifTplCampaign     = "campaign-status"
-	notifSubscriberOptin = "subscriber-optin"
-	notifSubscriberData  = "subscriber-data"
+	notifTplImport           = "import-status"
+	notifTplCampaign         = "campaign-status"
+	notifSubscriberOptin     = "subscriber-optin"
+	notifSubscriberOptinCode = "subscriber-optin-code"
+	notifSubscriberData      = "subscriber-data"
+	notifTplAlert            = "alert"
 )
 
 var (
@@ -27,14 +30,36 @@ type notifData struct {
 	LogoURL string
 }
 
+// notify sends an admin notification to the configured notification channels.
+// Notifications with an e-mail template are also e-mailed to the admin
+// notification e-mails in the settings.
+func (app *App) notify(n notifs.Notif) error {
+	app.notifs.Push(n)
+
+	if n.Tpl == "" {
+		return nil
+	}
+
+	// The generic alert template renders the notification itself.
+	if n.Tpl == notifTplAlert {
+		return app.sendNotification(app.constants.NotifyEmails, n.Subject, n.Tpl, n, nil)
+	}
+	return app.sendNotification(app.constants.NotifyEmails, n.Subject, n.Tpl, n.Data, nil)
+}
+
 // sendNotification sends out an e-mail notification to admins.
 func (app *App) sendNotification(toEmails []string, subject, tplName string, data interface{}, headers textproto.MIMEHeader) error {
+	return app.sendLangNotification(app.langs.def, toEmails, subject, tplName, data, headers)
+}
+
+// sendLangNotification sends out an e-mail notification rendered in the given language.
+func (app *App) sendLangNotification(lang *langPack, toEmails []string, subject, tplName string, data interface{}, headers textproto.MIMEHeader) error {
 	if len(toEmails) == 0 {
 		return nil
 	}
 
 	var buf bytes.Buffer
-	if err := app.notifTpls.tpls.ExecuteTemplate(&buf, tplName, data); err != nil {
+	if err := lang.notifTpls.ExecuteTemplate(&buf, tplName, data); err != nil {
 		app.log.Printf("error compiling notification template '%s': %v", tplName, err)
 		return err
 	}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// handleGetCampaignOutbox returns the messages of a campaign held for review
+// in the outbox.
+func handleGetCampaignOutbox(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		pg    = app.paginator.NewFromURL(c.Request().URL.Query())
+		id, _ = strconv.Atoi(c.Param("id"))
+		query = strings.TrimSpace(c.FormValue("query"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	res, total, err := app.core.QueryOutboxMessages(id, query, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Query:   query,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetCampaignOutboxMessage returns a message with its body from a
+// campaign's outbox.
+func handleGetCampaignOutboxMessage(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		msgID, _ = strconv.ParseInt(c.Param("msgID"), 10, 64)
+	)
+
+	if id < 1 || msgID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetOutboxMessage(id, msgID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateCampaignOutbox approves a campaign's reviewed outbox, which starts
+// sending its messages, or rejects it, which discards the messages.
+func handleUpdateCampaignOutbox(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	switch req.Action {
+	case "approve":
+		if err := app.core.ApproveCampaignOutbox(id); err != nil {
+			return err
+		}
+		app.core.RecordEvent(models.EventLogCampaign, app.i18n.Ts("events.campaignOutboxApproved", "name", cm.Name),
+			models.JSON{"campaign_id": id, "outbox": models.CampaignOutboxApproved}, user.ID)
+
+	case "reject":
+		if err := app.core.RejectCampaignOutbox(id); err != nil {
+			return err
+		}
+		app.core.RecordEvent(models.EventLogCampaign, app.i18n.Ts("events.campaignOutboxRejected", "name", cm.Name),
+			models.JSON{"campaign_id": id, "outbox": ""}, user.ID)
+
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+	}
+
+	out, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
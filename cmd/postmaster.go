@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Maximum number of postmaster stats in a single import.
+const maxPostmasterImport = 5000
+
+// handleGetPostmasterStats returns the postmaster stats imported from mailbox
+// providers, optionally filtered by the provider, domain, campaign, and dates.
+func handleGetPostmasterStats(c echo.Context) error {
+	var (
+		app       = c.Get("app").(*App)
+		pg        = app.paginator.NewFromURL(c.Request().URL.Query())
+		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
+		from      = c.QueryParam("from")
+		to        = c.QueryParam("to")
+	)
+
+	for _, d := range []string{from, to} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "date"))
+		}
+	}
+
+	res, total, err := app.core.QueryPostmasterStats(c.QueryParam("provider"), c.QueryParam("domain"), campID, from, to, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleImportPostmasterStats imports a batch of a day's aggregate stats of
+// sending domains and Feedback-ID identifiers, eg: from Gmail Postmaster Tools.
+// Existing stats of the same provider, domain, date, and identifier are replaced.
+func handleImportPostmasterStats(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	var req []models.PostmasterStat
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if len(req) == 0 || len(req) > maxPostmasterImport {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "stats"))
+	}
+
+	for i, s := range req {
+		s, err := validatePostmasterStat(s, app)
+		if err != nil {
+			return err
+		}
+		req[i] = s
+	}
+
+	if err := app.core.UpsertPostmasterStats(req); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{map[string]int{"imported": len(req)}})
+}
+
+// validatePostmasterStat validates and sanitizes an imported postmaster stat.
+func validatePostmasterStat(s models.PostmasterStat, app *App) (models.PostmasterStat, error) {
+	s.Provider = strings.ToLower(strings.TrimSpace(s.Provider))
+	if !strHasLen(s.Provider, 1, 50) {
+		return s, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "provider"))
+	}
+
+	s.Domain = strings.ToLower(strings.TrimSpace(s.Domain))
+	if !strHasLen(s.Domain, 1, 253) {
+		return s, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "domain"))
+	}
+
+	if _, err := time.Parse("2006-01-02", s.Date); err != nil {
+		return s, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "date"))
+	}
+
+	s.Identifier = strings.TrimSpace(s.Identifier)
+	if len(s.Identifier) > 200 {
+		return s, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "identifier"))
+	}
+
+	// Rates are ratios between 0 and 1.
+	for name, r := range map[string]null.Float64{
+		"spam_rate":           s.SpamRate,
+		"spf_success_rate":    s.SPFSuccessRate,
+		"dkim_success_rate":   s.DKIMSuccessRate,
+		"dmarc_success_rate":  s.DMARCSuccessRate,
+		"delivery_error_rate": s.DeliveryErrorRate,
+	} {
+		if r.Valid && (r.Float64 < 0 || r.Float64 > 1) {
+			return s, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", name))
+		}
+	}
+
+	s.DomainReputation = strings.ToUpper(strings.TrimSpace(s.DomainReputation))
+	s.IPReputation = strings.ToUpper(strings.TrimSpace(s.IPReputation))
+
+	return s, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Gmail clips messages whose HTML is larger than ~102KB and hides
+	// the rest behind a "View entire message" link.
+	gmailClipSize = 102 * 1024
+
+	// Limits for sizing remote images in the preflight check.
+	preflightMaxImages    = 50
+	preflightImageWorkers = 5
+	preflightImageTimeout = time.Second * 5
+	preflightMaxImageSize = 20 * 1024 * 1024
+)
+
+var (
+	reImgSrc = regexp.MustCompile(`(?i)<img[^>]+src\s*=\s*["']([^"']+)["']`)
+
+	preflightClient = &http.Client{Timeout: preflightImageTimeout}
+)
+
+// preflight is the result of a campaign's message size, image weight, and
+// compliance checks.
+type preflight struct {
+	HTMLSize         int              `json:"html_size"`
+	HTMLSizeLimit    int              `json:"html_size_limit"`
+	ClipThreshold    int              `json:"clip_threshold"`
+	Images           []preflightImage `json:"images"`
+	InlineImageSize  int64            `json:"inline_image_size"`
+	RemoteImageSize  int64            `json:"remote_image_size"`
+	ImageWeightLimit int64            `json:"image_weight_limit"`
+	Compliance       complianceCheck  `json:"compliance"`
+
+	// Warnings are advisory. Errors block the campaign from being started.
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors"`
+}
+
+type preflightImage struct {
+	URL    string `json:"url"`
+	Inline bool   `json:"inline"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleGetCampaignPreflight checks a campaign's rendered message size and
+// image weight against the configured budgets.
+func handleGetCampaignPreflight(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := runCampaignPreflight(id, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// runCampaignPreflight renders a campaign for a dummy subscriber and measures
+// the size of its HTML and the weight of its inlined (data URI) and remote images,
+// and checks it against the compliance profiles of its lists.
+func runCampaignPreflight(id int, app *App) (preflight, error) {
+	camp, err := app.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return preflight{}, err
+	}
+
+	// Use a dummy campaign ID to prevent views and clicks from being registered.
+	camp.UUID = dummySubscriber.UUID
+	if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
+		return preflight{}, echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	msg, err := app.manager.NewCampaignMessage(&camp, dummySubscriber)
+	if err != nil {
+		return preflight{}, echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	body := msg.Body()
+	out := preflight{
+		HTMLSize:         len(body),
+		HTMLSizeLimit:    app.constants.MessageSizeLimit * 1024,
+		ClipThreshold:    gmailClipSize,
+		Images:           []preflightImage{},
+		ImageWeightLimit: int64(app.constants.ImageWeightLimit) * 1024,
+		Warnings:         []string{},
+		Errors:           []string{},
+	}
+
+	if camp.ContentType != models.CampaignContentTypePlain {
+		out.Images = measureImages(string(body))
+	}
+
+	for _, img := range out.Images {
+		if img.Inline {
+			out.InlineImageSize += img.Size
+		} else {
+			out.RemoteImageSize += img.Size
+		}
+
+		if img.Error != "" {
+			out.Warnings = append(out.Warnings, app.i18n.Ts("campaigns.preflight.imageError", "url", img.URL, "error", img.Error))
+		}
+	}
+
+	// Flag subscriber fields that'd render as empty text (eg: "Hi ,") for
+	// subscribers who don't have them.
+	texts := []string{camp.Subject, camp.Preheader, camp.Body, camp.AltBody.String}
+	for _, v := range camp.Variants {
+		texts = append(texts, v.Subject, v.Body)
+	}
+	if f := manager.MergeFieldsWithoutFallback(texts...); len(f) > 0 {
+		out.Warnings = append(out.Warnings, app.i18n.Ts("campaigns.preflight.noFallback", "fields", strings.Join(f, ", ")))
+	}
+
+	// Messages that don't meet the compliance profiles of the campaign's lists.
+	cc, err := checkCompliance(camp, app)
+	if err != nil {
+		return preflight{}, err
+	}
+	out.Compliance = cc
+	out.Errors = append(out.Errors, cc.Errors...)
+
+	if out.HTMLSize > gmailClipSize {
+		out.Warnings = append(out.Warnings, app.i18n.Ts("campaigns.preflight.gmailClip", "size", kbStr(int64(out.HTMLSize))))
+	}
+	if out.HTMLSizeLimit > 0 && out.HTMLSize > out.HTMLSizeLimit {
+		out.Errors = append(out.Errors, app.i18n.Ts("campaigns.preflight.sizeExceeded",
+			"size", kbStr(int64(out.HTMLSize)), "limit", kbStr(int64(out.HTMLSizeLimit))))
+	}
+	if w := out.InlineImageSize + out.RemoteImageSize; out.ImageWeightLimit > 0 && w > out.ImageWeightLimit {
+		out.Errors = append(out.Errors, app.i18n.Ts("campaigns.preflight.imageWeightExceeded",
+			"size", kbStr(w), "limit", kbStr(out.ImageWeightLimit)))
+	}
+
+	return out, nil
+}
+
+// measureImages returns the sizes of the images in an HTML body. The sizes of
+// inlined images are decoded from their data URIs and those of remote images
+// are fetched.
+func measureImages(body string) []preflightImage {
+	var (
+		out  = []preflightImage{}
+		seen = map[string]bool{}
+	)
+	for _, m := range reImgSrc.FindAllStringSubmatch(body, -1) {
+		src := strings.TrimSpace(m[1])
+
+		// Skip tracking pixels (that have the dummy campaign UUID) and duplicates.
+		if seen[src] || strings.Contains(src, dummySubscriber.UUID) {
+			continue
+		}
+		seen[src] = true
+
+		if strings.HasPrefix(strings.ToLower(src), "data:") {
+			out = append(out, preflightImage{URL: truncateURI(src), Inline: true, Size: dataURISize(src)})
+			continue
+		}
+
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			out = append(out, preflightImage{URL: src})
+		}
+
+		if len(out) >= preflightMaxImages {
+			break
+		}
+	}
+
+	// Fetch the sizes of remote images concurrently.
+	var (
+		wg sync.WaitGroup
+		ch = make(chan int)
+	)
+	for i := 0; i < preflightImageWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range ch {
+				size, err := fetchImageSize(out[n].URL)
+				out[n].Size = size
+				if err != nil {
+					out[n].Error = err.Error()
+				}
+			}
+		}()
+	}
+	for n, img := range out {
+		if !img.Inline {
+			ch <- n
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	return out
+}
+
+// fetchImageSize returns the size of a remote image from its Content-Length
+// header, or failing that, by downloading it.
+func fetchImageSize(u string) (int64, error) {
+	resp, err := preflightClient.Head(u)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.ContentLength > 0 {
+			return resp.ContentLength, nil
+		}
+	}
+
+	resp, err = preflightClient.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return io.Copy(io.Discard, io.LimitReader(resp.Body, preflightMaxImageSize))
+}
+
+// dataURISize returns the decoded size of a data URI's payload.
+func dataURISize(uri string) int64 {
+	meta, data, ok := strings.Cut(uri, ",")
+	if !ok {
+		return 0
+	}
+
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		return int64(base64.StdEncoding.DecodedLen(len(data)))
+	}
+
+	return int64(len(data))
+}
+
+// truncateURI shortens long data URIs for display.
+func truncateURI(u string) string {
+	if len(u) > 64 {
+		return u[:64] + "..."
+	}
+
+	return u
+}
+
+func kbStr(b int64) string {
+	return fmt.Sprintf("%.1f KB", float64(b)/1024)
+}
@@ -2,30 +2,41 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"database/sql"
 	"fmt"
 	"html/template"
 	"image"
 	"image/png"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
+	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 )
 
 const (
 	tplMessage = "message"
+
+	// langKey is the request context key for the language pack of public pages.
+	langKey = "lang"
+
+	// rootURLKey is the request context key for the root URL of public pages
+	// served on a list's custom domain.
+	rootURLKey = "root_url"
 )
 
 // tplRenderer wraps a template.tplRenderer for echo.
 type tplRenderer struct {
-	templates           *template.Template
 	SiteName            string
 	RootURL             string
 	LogoURL             string
@@ -61,17 +72,33 @@ type unsubTpl struct {
 	Subscriber       models.Subscriber
 	Subscriptions    []models.Subscription
 	SubUUID          string
+	CampUUID         string
 	AllowBlocklist   bool
 	AllowExport      bool
 	AllowWipe        bool
 	AllowPreferences bool
 	ShowManage       bool
+
+	// Token is the signed token of the link, carried to the page's actions.
+	Token string
+
+	// Preview renders a read-only admin preview of the page.
+	Preview bool
+
+	// Stripe subscriptions (billing status) of paid lists.
+	StripeSubscriptions []models.StripeSubscription
+}
+
+type optinCodeTpl struct {
+	publicTpl
+	Email string
 }
 
 type optinTpl struct {
 	publicTpl
 	SubUUID   string
 	ListUUIDs []string      `query:"l" form:"l"`
+	Token     string        `query:"t" form:"t"`
 	Lists     []models.List `query:"-" form:"-"`
 }
 
@@ -84,6 +111,27 @@ type msgTpl struct {
 type subFormTpl struct {
 	publicTpl
 	Lists      []models.List
+	Lang       string
+	Ref        string
+	CaptchaKey string
+}
+
+type widgetTpl struct {
+	publicTpl
+	Lists    []models.List
+	Lang     string
+	ID       string
+	Theme    string
+	Color    string
+	Heading  string
+	ShowName bool
+}
+
+type listPageTpl struct {
+	publicTpl
+	List       models.List
+	ArchiveURL string
+	Ref        string
 	CaptchaKey string
 }
 
@@ -93,9 +141,10 @@ var (
 
 // Render executes and renders a template for echo.
 func (t *tplRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, tplData{
+	lang := getCtxLang(c)
+	return lang.pubTpls.ExecuteTemplate(w, name, tplData{
 		SiteName:            t.SiteName,
-		RootURL:             t.RootURL,
+		RootURL:             getPublicRootURL(c, t.RootURL),
 		LogoURL:             t.LogoURL,
 		FaviconURL:          t.FaviconURL,
 		AssetVersion:        t.AssetVersion,
@@ -103,10 +152,69 @@ func (t *tplRenderer) Render(w io.Writer, name string, data interface{}, c echo.
 		EnablePublicArchive: t.EnablePublicArchive,
 		IndividualTracking:  t.IndividualTracking,
 		Data:                data,
-		L:                   c.Get("app").(*App).i18n,
+		L:                   lang.i18n,
 	})
 }
 
+// compilePublicTpls compiles the bundled public page templates for a language
+// and applies the given template overrides on top of them.
+func compilePublicTpls(i *i18n.I18n, overrides []models.PublicTemplate, app *App) (*template.Template, error) {
+	tpl, err := stuffbin.ParseTemplatesGlob(initTplFuncs(i, app.constants), app.fs, "/public/templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range overrides {
+		if tpl.Lookup(o.Name) == nil {
+			return nil, fmt.Errorf("unknown template '%s'", o.Name)
+		}
+
+		// Parsing a template with an existing name replaces it.
+		if _, err := tpl.New(o.Name).Parse(o.Body); err != nil {
+			return nil, fmt.Errorf("error compiling template '%s': %v", o.Name, err)
+		}
+	}
+
+	return tpl, nil
+}
+
+// loadPublicTpls compiles the public page templates for a language with the
+// template overrides in the appearance settings. If the overrides don't compile,
+// the bundled templates are used so that the public pages stay up.
+func loadPublicTpls(i *i18n.I18n, app *App) (*template.Template, error) {
+	tpl, err := compilePublicTpls(i, app.constants.Appearance.PublicTemplates, app)
+	if err == nil {
+		return tpl, nil
+	}
+
+	app.log.Printf("error applying public template overrides, using the bundled templates: %v", err)
+	return compilePublicTpls(i, nil, app)
+}
+
+// getPublicRootURL returns the root URL of the list domain the request is on,
+// or the given default root URL.
+func getPublicRootURL(c echo.Context, def string) string {
+	if u, ok := c.Get(rootURLKey).(string); ok {
+		return u
+	}
+
+	return def
+}
+
+// setCtxLang sets the language pack in which public pages in the request are rendered.
+func setCtxLang(c echo.Context, l *langPack) *langPack {
+	c.Set(langKey, l)
+	return l
+}
+
+// getCtxLang returns the language pack set on the request, or the default pack.
+func getCtxLang(c echo.Context) *langPack {
+	if l, ok := c.Get(langKey).(*langPack); ok {
+		return l
+	}
+	return c.Get("app").(*App).langs.def
+}
+
 // handleGetPublicLists returns the list of public lists with minimal fields
 // required to submit a subscription.
 func handleGetPublicLists(c echo.Context) error {
@@ -121,15 +229,25 @@ func handleGetPublicLists(c echo.Context) error {
 	}
 
 	type list struct {
-		UUID string `json:"uuid"`
-		Name string `json:"name"`
+		UUID        string `json:"uuid"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		LogoURL     string `json:"logo_url"`
+		Lang        string `json:"lang"`
+		URL         string `json:"url"`
+		Paid        bool   `json:"paid"`
 	}
 
 	out := make([]list, 0, len(lists))
 	for _, l := range lists {
 		out = append(out, list{
-			UUID: l.UUID,
-			Name: l.Name,
+			UUID:        l.UUID,
+			Name:        l.Name,
+			Description: l.Description,
+			LogoURL:     l.LogoURL,
+			Lang:        l.Lang,
+			URL:         app.constants.RootURL + "/lists/" + l.UUID,
+			Paid:        l.StripePriceID != "",
 		})
 	}
 
@@ -138,13 +256,22 @@ func handleGetPublicLists(c echo.Context) error {
 
 // handleViewCampaignMessage renders the HTML view of a campaign message.
 // This is the view the {{ MessageURL }} template tag links to in e-mail campaigns.
+// The message is rendered without view and link tracking.
 func handleViewCampaignMessage(c echo.Context) error {
 	var (
 		app      = c.Get("app").(*App)
 		campUUID = c.Param("campUUID")
 		subUUID  = c.Param("subUUID")
+		sig      = c.QueryParam("sig")
 	)
 
+	// Links in messages sent before the URLs were signed don't have a signature.
+	// If there's one, it should be valid.
+	if sig != "" && !hmac.Equal([]byte(sig), []byte(app.manager.MessageSig(campUUID, subUUID))) {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.campaignNotFound")))
+	}
+
 	// Get the campaign.
 	camp, err := app.core.GetCampaign(0, campUUID, "")
 	if err != nil {
@@ -172,14 +299,14 @@ func handleViewCampaignMessage(c echo.Context) error {
 	}
 
 	// Compile the template.
-	if err := camp.CompileTemplate(app.manager.TemplateFuncs(&camp)); err != nil {
+	if err := app.manager.CompileCampaign(&camp); err != nil {
 		app.log.Printf("error compiling template: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
 	// Render the message body.
-	msg, err := app.manager.NewCampaignMessage(&camp, sub)
+	msg, err := app.manager.NewWebViewMessage(&camp, sub)
 	if err != nil {
 		app.log.Printf("error rendering message: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
@@ -189,6 +316,44 @@ func handleViewCampaignMessage(c echo.Context) error {
 	return c.HTML(http.StatusOK, string(msg.Body()))
 }
 
+// handleCampaignRSVP records a subscriber's RSVP response to a campaign's
+// calendar invite. This is the view the {{ RSVPURL "accepted" }} template tag
+// links to in e-mail campaigns.
+func handleCampaignRSVP(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		campUUID = c.Param("campUUID")
+		subUUID  = c.Param("subUUID")
+		status   = c.Param("status")
+		sig      = c.QueryParam("sig")
+	)
+
+	if !hmac.Equal([]byte(sig), []byte(app.manager.MessageSig(campUUID, subUUID))) {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.campaignNotFound")))
+	}
+
+	switch status {
+	case models.RSVPAccepted, models.RSVPDeclined, models.RSVPTentative:
+	default:
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.invalidFeature")))
+	}
+
+	if err := app.core.RecordCampaignRSVP(campUUID, subUUID, status); err != nil {
+		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusBadRequest {
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.campaignNotFound")))
+		}
+
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(app.i18n.T("public.rsvpTitle"), "", app.i18n.T("public.rsvp."+status)))
+}
+
 // handleSubscriptionPage renders the subscription management page and
 // handles unsubscriptions. This is the view that {{ UnsubscribeURL }} in
 // campaigns link to.
@@ -200,7 +365,8 @@ func handleSubscriptionPage(c echo.Context) error {
 		out           = unsubTpl{}
 	)
 	out.SubUUID = subUUID
-	out.Title = app.i18n.T("public.unsubscribeTitle")
+	out.CampUUID = c.Param("campUUID")
+	out.Token = c.FormValue("t")
 	out.AllowBlocklist = app.constants.Privacy.AllowBlocklist
 	out.AllowExport = app.constants.Privacy.AllowExport
 	out.AllowWipe = app.constants.Privacy.AllowWipe
@@ -213,9 +379,13 @@ func handleSubscriptionPage(c echo.Context) error {
 	}
 	out.Subscriber = s
 
+	// Render the page in the subscriber's language.
+	lang := setCtxLang(c, app.getSubLang(s, nil))
+	out.Title = lang.i18n.T("public.unsubscribeTitle")
+
 	if s.Status == models.SubscriberStatusBlockListed {
 		return c.Render(http.StatusOK, tplMessage,
-			makeMsgTpl(app.i18n.T("public.noSubTitle"), "", app.i18n.Ts("public.blocklisted")))
+			makeMsgTpl(lang.i18n.T("public.noSubTitle"), "", lang.i18n.Ts("public.blocklisted")))
 	}
 
 	// Only show preference management if it's enabled in settings.
@@ -223,25 +393,138 @@ func handleSubscriptionPage(c echo.Context) error {
 		out.ShowManage = showManage
 	}
 	if out.ShowManage {
-		// Get the subscriber's lists.
-		subs, err := app.core.GetSubscriptions(0, subUUID, false)
+		if err := out.loadPrefs(lang, app); err != nil {
+			return err
+		}
+	}
+
+	return c.Render(http.StatusOK, "subscription", out)
+}
+
+// handleSubscriberPortalPreview renders a read-only preview of a subscriber's
+// preferences page, or of a campaign's hosted view as rendered for them, for
+// the temporary preview links generated by admins. The preview doesn't carry
+// the subscriber's UUID or link tokens, and its forms are disabled.
+func handleSubscriberPortalPreview(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		id, _    = strconv.Atoi(c.Param("id"))
+		campUUID = c.QueryParam("campaign")
+	)
+
+	if campUUID != "" && !reUUID.MatchString(campUUID) {
+		campUUID = ""
+	}
+
+	// The token is bound to the subscriber and the campaign, and is only
+	// issued to users who have access to both (handleCreateSubscriberPreview).
+	if err := app.manager.CheckLinkToken(c.QueryParam("t"), manager.LinkPreview, previewKey(id, campUUID)); err != nil {
+		msg := "public.invalidLink"
+		if err == manager.ErrLinkExpired {
+			msg = "public.linkExpired"
+		}
+		return c.Render(http.StatusForbidden, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T(msg)))
+	}
+
+	sub, err := app.core.GetSubscriber(id, "", "")
+	if err != nil {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.Ts("globals.messages.notFound",
+				"name", app.i18n.T("globals.terms.subscriber"))))
+	}
+
+	// Links in the preview shouldn't work as the subscriber's.
+	sub.UUID = dummyUUID
+
+	// Campaign message as the subscriber sees it.
+	if campUUID != "" {
+		camp, err := app.core.GetCampaign(0, campUUID, "")
 		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.errorFetchingLists"))
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.campaignNotFound")))
 		}
 
-		out.Subscriptions = make([]models.Subscription, 0, len(subs))
-		for _, s := range subs {
-			if s.Type == models.ListTypePrivate {
-				continue
-			}
+		if err := app.manager.CompileCampaign(&camp); err != nil {
+			app.log.Printf("error compiling template: %v", err)
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingCampaign")))
+		}
+
+		msg, err := app.manager.NewWebViewMessage(&camp, sub)
+		if err != nil {
+			app.log.Printf("error rendering message: %v", err)
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingCampaign")))
+		}
+
+		return c.HTML(http.StatusOK, string(msg.Body()))
+	}
+
+	// Preferences page.
+	out := unsubTpl{
+		Subscriber:       sub,
+		SubUUID:          dummyUUID,
+		CampUUID:         dummyUUID,
+		AllowBlocklist:   app.constants.Privacy.AllowBlocklist,
+		AllowExport:      app.constants.Privacy.AllowExport,
+		AllowWipe:        app.constants.Privacy.AllowWipe,
+		AllowPreferences: app.constants.Privacy.AllowPreferences,
+		ShowManage:       app.constants.Privacy.AllowPreferences,
+		Preview:          true,
+	}
+
+	lang := setCtxLang(c, app.getSubLang(sub, nil))
+	out.Title = lang.i18n.T("public.unsubscribeTitle")
 
-			out.Subscriptions = append(out.Subscriptions, s)
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(lang.i18n.T("public.noSubTitle"), "", lang.i18n.Ts("public.blocklisted")))
+	}
+
+	if out.ShowManage {
+		if err := out.loadPrefs(lang, app); err != nil {
+			return err
 		}
 	}
 
 	return c.Render(http.StatusOK, "subscription", out)
 }
 
+// loadPrefs loads the subscriber's public list subscriptions and the billing
+// status of their paid lists for the preferences page.
+func (out *unsubTpl) loadPrefs(lang *langPack, app *App) error {
+	subs, err := app.core.GetSubscriptions(out.Subscriber.ID, "", false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, lang.i18n.T("public.errorFetchingLists"))
+	}
+
+	out.Subscriptions = make([]models.Subscription, 0, len(subs))
+	for _, s := range subs {
+		if s.Type == models.ListTypePrivate {
+			continue
+		}
+
+		out.Subscriptions = append(out.Subscriptions, s)
+	}
+
+	// Billing status of paid lists.
+	if app.stripe != nil {
+		bs, err := app.core.GetStripeSubscriptions(out.Subscriber.ID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, lang.i18n.T("public.errorProcessingRequest"))
+		}
+		out.StripeSubscriptions = bs
+	}
+
+	return nil
+}
+
+// previewKey returns the key that a subscriber's portal preview link is signed for.
+func previewKey(subID int, campUUID string) string {
+	return strconv.Itoa(subID) + ":" + campUUID
+}
+
 // handleSubscriptionPrefs renders the subscription management page and
 // handles unsubscriptions. This is the view that {{ UnsubscribeURL }} in
 // campaigns link to.
@@ -259,10 +542,21 @@ func handleSubscriptionPrefs(c echo.Context) error {
 		}
 	)
 
+	// Get the subscriber from the DB.
+	sub, err := app.core.GetSubscriber(0, subUUID, "")
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("globals.messages.pFound",
+				"name", app.i18n.T("globals.terms.subscriber"))))
+	}
+
+	// Render responses in the subscriber's language.
+	lang := setCtxLang(c, app.getSubLang(sub, nil))
+
 	// Read the form.
 	if err := c.Bind(&req); err != nil {
 		return c.Render(http.StatusBadRequest, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("globals.messages.invalidData")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("globals.messages.invalidData")))
 	}
 
 	// Simple unsubscribe.
@@ -270,39 +564,32 @@ func handleSubscriptionPrefs(c echo.Context) error {
 	if !req.Manage || blocklist {
 		if err := app.core.UnsubscribeByCampaign(subUUID, campUUID, blocklist); err != nil {
 			return c.Render(http.StatusInternalServerError, tplMessage,
-				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.errorProcessingRequest")))
+				makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("public.errorProcessingRequest")))
 		}
 
 		return c.Render(http.StatusOK, tplMessage,
-			makeMsgTpl(app.i18n.T("public.unsubbedTitle"), "", app.i18n.T("public.unsubbedInfo")))
+			makeMsgTpl(lang.i18n.T("public.unsubbedTitle"), "", lang.i18n.T("public.unsubbedInfo")))
 	}
 
 	// Is preference management enabled?
 	if !app.constants.Privacy.AllowPreferences {
 		return c.Render(http.StatusBadRequest, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.invalidFeature")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("public.invalidFeature")))
 	}
 
 	// Manage preferences.
 	req.Name = strings.TrimSpace(req.Name)
 	if req.Name == "" || len(req.Name) > 256 {
 		return c.Render(http.StatusBadRequest, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("subscribers.invalidName")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("subscribers.invalidName")))
 	}
 
-	// Get the subscriber from the DB.
-	sub, err := app.core.GetSubscriber(0, subUUID, "")
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("globals.messages.pFound",
-				"name", app.i18n.T("globals.terms.subscriber"))))
-	}
 	sub.Name = req.Name
 
 	// Update name.
 	if _, err := app.core.UpdateSubscriber(sub.ID, sub); err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("public.errorProcessingRequest")))
 	}
 
 	// Get the subscriber's lists and whatever is not sent in the request (unchecked),
@@ -314,7 +601,7 @@ func handleSubscriptionPrefs(c echo.Context) error {
 
 	subs, err := app.core.GetSubscriptions(0, subUUID, false)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.errorFetchingLists"))
+		return echo.NewHTTPError(http.StatusBadRequest, lang.i18n.T("public.errorFetchingLists"))
 	}
 
 	unsubUUIDs := make([]string, 0, len(req.ListUUIDs))
@@ -330,12 +617,12 @@ func handleSubscriptionPrefs(c echo.Context) error {
 	// Unsubscribe from lists.
 	if err := app.core.UnsubscribeLists([]int{sub.ID}, nil, unsubUUIDs); err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("public.errorProcessingRequest")))
 
 	}
 
 	return c.Render(http.StatusOK, tplMessage,
-		makeMsgTpl(app.i18n.T("globals.messages.done"), "", app.i18n.T("public.prefsSaved")))
+		makeMsgTpl(lang.i18n.T("globals.messages.done"), "", lang.i18n.T("public.prefsSaved")))
 }
 
 // handleOptinPage renders the double opt-in confirmation page that subscribers
@@ -349,8 +636,6 @@ func handleOptinPage(c echo.Context) error {
 		out        = optinTpl{}
 	)
 	out.SubUUID = subUUID
-	out.Title = app.i18n.T("public.confirmOptinSubTitle")
-	out.SubUUID = subUUID
 
 	// Get and validate fields.
 	if err := c.Bind(&out); err != nil {
@@ -374,32 +659,34 @@ func handleOptinPage(c echo.Context) error {
 			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingLists")))
 	}
 
+	// Render the page in the subscriber's or the lists' language.
+	sub, _ := app.core.GetSubscriber(0, subUUID, "")
+	lang := setCtxLang(c, app.getSubLang(sub, lists))
+	out.Title = lang.i18n.T("public.confirmOptinSubTitle")
+
 	// There are no lists to confirm.
 	if len(lists) == 0 {
 		return c.Render(http.StatusOK, tplMessage,
-			makeMsgTpl(app.i18n.T("public.noSubTitle"), "", app.i18n.Ts("public.noSubInfo")))
+			makeMsgTpl(lang.i18n.T("public.noSubTitle"), "", lang.i18n.Ts("public.noSubInfo")))
 	}
 	out.Lists = lists
 
 	// Confirm.
 	if confirm {
-		meta := models.JSON{}
-		if app.constants.Privacy.RecordOptinIP {
-			if h := c.Request().Header.Get("X-Forwarded-For"); h != "" {
-				meta["optin_ip"] = h
-			} else if h := c.Request().RemoteAddr; h != "" {
-				meta["optin_ip"] = strings.Split(h, ":")[0]
-			}
-		}
-
-		if err := app.core.ConfirmOptionSubscription(subUUID, out.ListUUIDs, meta); err != nil {
+		if err := app.core.ConfirmOptionSubscription(subUUID, out.ListUUIDs, makeOptinMeta(c)); err != nil {
 			app.log.Printf("error unsubscribing: %v", err)
 			return c.Render(http.StatusInternalServerError, tplMessage,
-				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+				makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorProcessingRequest")))
+		}
+
+		if app.constants.Privacy.RecordConsent {
+			if err := app.core.RecordConsent(sub.ID, nil, out.ListUUIDs, makeConsent(c, models.SourceOptin)); err != nil {
+				app.log.Printf("error recording optin consent: %v", err)
+			}
 		}
 
 		return c.Render(http.StatusOK, tplMessage,
-			makeMsgTpl(app.i18n.T("public.subConfirmedTitle"), "", app.i18n.Ts("public.subConfirmed")))
+			makeMsgTpl(lang.i18n.T("public.subConfirmedTitle"), "", lang.i18n.Ts("public.subConfirmed")))
 	}
 
 	return c.Render(http.StatusOK, "optin", out)
@@ -409,29 +696,44 @@ func handleOptinPage(c echo.Context) error {
 // HTML subscription forms.
 func handleSubscriptionFormPage(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
+		app  = c.Get("app").(*App)
+		lang = setCtxLang(c, app.getLang(c.QueryParam("lang")))
 	)
 
 	if !app.constants.EnablePublicSubPage {
 		return c.Render(http.StatusNotFound, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.invalidFeature")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.invalidFeature")))
 	}
 
 	// Get all public lists.
 	lists, err := app.core.GetLists(models.ListTypePublic, true, nil)
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorFetchingLists")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorFetchingLists")))
 	}
 
 	if len(lists) == 0 {
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.noListsAvailable")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.noListsAvailable")))
 	}
 
+	// Paid lists can only be subscribed to via Stripe.
 	out := subFormTpl{}
-	out.Title = app.i18n.T("public.sub")
-	out.Lists = lists
+	out.Title = lang.i18n.T("public.sub")
+	out.Lists = make([]models.List, 0, len(lists))
+	for _, l := range lists {
+		if l.StripePriceID == "" {
+			out.Lists = append(out.Lists, l)
+		}
+	}
+	if lang != app.langs.def {
+		out.Lang = lang.i18n.Code()
+	}
+
+	// Referral code from a subscriber's {{ ReferralURL }} link.
+	if ref := c.QueryParam("ref"); reRefCode.MatchString(ref) {
+		out.Ref = ref
+	}
 
 	if app.constants.Security.EnableCaptcha {
 		out.CaptchaKey = app.constants.Security.CaptchaKey
@@ -440,16 +742,147 @@ func handleSubscriptionFormPage(c echo.Context) error {
 	return c.Render(http.StatusOK, "subscription-form", out)
 }
 
+// handleSubscriptionWidget renders the subscription form that the embeddable
+// widget (/public/widget.js) loads in an iframe. The form subscribes via the
+// public subscription API and reports back to the embedding page with
+// postMessage().
+func handleSubscriptionWidget(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		lang = setCtxLang(c, app.getLang(c.QueryParam("lang")))
+	)
+
+	if !app.constants.EnablePublicSubPage {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.invalidFeature")))
+	}
+
+	lists, err := app.core.GetLists(models.ListTypePublic, true, nil)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorFetchingLists")))
+	}
+
+	// Only show the public lists the widget was configured with, if any.
+	// Paid lists can only be subscribed to via Stripe.
+	var (
+		uuids = c.QueryParams()["l"]
+		want  = make(map[string]bool, len(uuids))
+	)
+	for _, u := range uuids {
+		want[u] = true
+	}
+
+	out := widgetTpl{
+		Theme:    "light",
+		ShowName: c.QueryParam("name") != "false",
+	}
+	out.Title = lang.i18n.T("public.sub")
+	for _, l := range lists {
+		if l.StripePriceID == "" && (len(want) == 0 || want[l.UUID]) {
+			out.Lists = append(out.Lists, l)
+		}
+	}
+	if len(out.Lists) == 0 {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.noListsAvailable")))
+	}
+
+	if lang != app.langs.def {
+		out.Lang = lang.i18n.Code()
+	}
+
+	// Theming.
+	if c.QueryParam("theme") == "dark" {
+		out.Theme = "dark"
+	}
+	if col := c.QueryParam("color"); reHexColor.MatchString(col) {
+		out.Color = col
+	}
+	if h := strings.TrimSpace(c.QueryParam("title")); len(h) <= stdInputMaxLen {
+		out.Heading = h
+	}
+	if id := c.QueryParam("id"); reWidgetID.MatchString(id) {
+		out.ID = id
+	}
+
+	return c.Render(http.StatusOK, "widget", out)
+}
+
+// handleWidgetJS serves the embeddable subscription widget script.
+func handleWidgetJS(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	if !app.constants.EnablePublicSubPage {
+		return echo.NewHTTPError(http.StatusNotFound, app.i18n.T("public.invalidFeature"))
+	}
+
+	b, err := app.fs.Read("/public/static/widget.js")
+	if err != nil {
+		app.log.Printf("error reading widget.js: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.Ts("globals.messages.internalError"))
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	return c.Blob(http.StatusOK, "application/javascript; charset=utf-8", b)
+}
+
+// handleListPage renders the public landing page of a public list with its
+// subscription form and a link to its archive.
+func handleListPage(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		listUUID = c.Param("listUUID")
+	)
+
+	if !app.constants.EnablePublicSubPage {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.invalidFeature")))
+	}
+
+	list, err := app.core.GetList(0, listUUID)
+	if err != nil || list.Type != models.ListTypePublic {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(app.i18n.T("public.notFoundTitle"), "", app.i18n.T("public.listNotFound")))
+	}
+
+	// Render the page in the list's language.
+	lang := setCtxLang(c, app.getLang(list.Lang))
+
+	// Paid lists can only be subscribed to via Stripe.
+	if list.StripePriceID != "" {
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(list.Name, "", lang.i18n.T("public.paidList")))
+	}
+
+	out := listPageTpl{List: list}
+	out.Title = list.Name
+	out.Description = list.Description
+
+	if app.constants.EnablePublicArchive {
+		out.ArchiveURL = getPublicRootURL(c, app.constants.RootURL) + "/archive?list=" + list.UUID
+	}
+	if ref := c.QueryParam("ref"); reRefCode.MatchString(ref) {
+		out.Ref = ref
+	}
+	if app.constants.Security.EnableCaptcha {
+		out.CaptchaKey = app.constants.Security.CaptchaKey
+	}
+
+	return c.Render(http.StatusOK, "list", out)
+}
+
 // handleSubscriptionForm handles subscription requests coming from public
 // HTML subscription forms.
 func handleSubscriptionForm(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
+		app  = c.Get("app").(*App)
+		lang = setCtxLang(c, app.getLang(c.FormValue("lang")))
 	)
 
 	// If there's a nonce value, a bot could've filled the form.
 	if c.FormValue("nonce") != "" {
-		return echo.NewHTTPError(http.StatusBadGateway, app.i18n.T("public.invalidFeature"))
+		return echo.NewHTTPError(http.StatusBadGateway, lang.i18n.T("public.invalidFeature"))
 	}
 
 	// Process CAPTCHA.
@@ -461,11 +894,11 @@ func handleSubscriptionForm(c echo.Context) error {
 
 		if !ok {
 			return c.Render(http.StatusBadRequest, tplMessage,
-				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.T("public.invalidCaptcha")))
+				makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.T("public.invalidCaptcha")))
 		}
 	}
 
-	hasOptin, err := processSubForm(c)
+	hasOptin, hasOptinCode, err := processSubForm(c)
 	if err != nil {
 		e, ok := err.(*echo.HTTPError)
 		if !ok {
@@ -473,7 +906,15 @@ func handleSubscriptionForm(c echo.Context) error {
 		}
 
 		return c.Render(e.Code, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", fmt.Sprintf("%s", e.Message)))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", fmt.Sprintf("%s", e.Message)))
+	}
+
+	// The subscriber was sent an opt-in code to enter.
+	if hasOptinCode {
+		out := optinCodeTpl{}
+		out.Title = lang.i18n.T("public.confirmSubTitle")
+		out.Email, _ = app.importer.SanitizeEmail(c.FormValue("email"))
+		return c.Render(http.StatusOK, "optin-code", out)
 	}
 
 	msg := "public.subConfirmed"
@@ -481,7 +922,7 @@ func handleSubscriptionForm(c echo.Context) error {
 		msg = "public.subOptinPending"
 	}
 
-	return c.Render(http.StatusOK, tplMessage, makeMsgTpl(app.i18n.T("public.subTitle"), "", app.i18n.Ts(msg)))
+	return c.Render(http.StatusOK, tplMessage, makeMsgTpl(lang.i18n.T("public.subTitle"), "", lang.i18n.Ts(msg)))
 }
 
 // handlePublicSubscription handles subscription requests coming from public
@@ -495,14 +936,65 @@ func handlePublicSubscription(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.invalidFeature"))
 	}
 
-	hasOptin, err := processSubForm(c)
+	hasOptin, hasOptinCode, err := processSubForm(c)
 	if err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, okResp{struct {
-		HasOptin bool `json:"has_optin"`
-	}{hasOptin}})
+		HasOptin     bool `json:"has_optin"`
+		HasOptinCode bool `json:"has_optin_code"`
+	}{hasOptin, hasOptinCode}})
+}
+
+// handleOptinCode confirms a subscriber's subscriptions with the opt-in code
+// sent to them by e-mail or SMS, entered on the opt-in code page.
+func handleOptinCode(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out := optinCodeTpl{}
+	out.Title = app.i18n.T("public.confirmSubTitle")
+	out.Email = c.FormValue("email")
+
+	if err := confirmOptinCode(c, out.Email, c.FormValue("code")); err != nil {
+		e, ok := err.(*echo.HTTPError)
+		if !ok || e.Code != http.StatusBadRequest {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+		}
+
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(app.i18n.T("public.errorTitle"), "", fmt.Sprintf("%s", e.Message)))
+	}
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(app.i18n.T("public.subConfirmedTitle"), "", app.i18n.Ts("public.subConfirmed")))
+}
+
+// handlePublicOptinCode confirms a subscriber's subscriptions with the opt-in
+// code sent to them for API driven signups.
+func handlePublicOptinCode(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Email string `json:"email" form:"email"`
+			Code  string `json:"code" form:"code"`
+		}
+	)
+
+	if !app.constants.EnablePublicSubPage {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.invalidFeature"))
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := confirmOptinCode(c, req.Email, req.Code); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
 }
 
 // handleLinkRedirect redirects a link UUID to its original underlying link
@@ -516,17 +1008,74 @@ func handleLinkRedirect(c echo.Context) error {
 		subUUID  = c.Param("subUUID")
 	)
 
-	// If individual tracking is disabled, do not record the subscriber ID.
-	if !app.constants.Privacy.IndividualTracking {
-		subUUID = ""
+	url, err := linkURLs.get(linkUUID, app)
+	if err != nil {
+		e := err.(*echo.HTTPError)
+		return c.Render(e.Code, tplMessage, makeMsgTpl(app.i18n.T("public.errorTitle"), "", e.Error()))
+	}
+
+	return registerLinkClick(c, url, linkUUID, campUUID, subUUID, app)
+}
+
+// handleShortLinkRedirect redirects a short link slug to its original
+// underlying link after recording the click. These links are generated by
+// {{ TrackLink }} tags in campaigns when short links are enabled. The optional
+// subscriber part of the link is the base36 subscriber ID and its signature.
+func handleShortLinkRedirect(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		slug  = c.Param("slug")
+		subID = 0
+	)
+
+	if sub := c.Param("sub"); sub != "" {
+		id, sig, _ := strings.Cut(sub, ".")
+		n, err := strconv.ParseInt(id, 36, 64)
+		if err != nil || n < 1 || n > math.MaxInt32 || !hmac.Equal([]byte(sig), []byte(app.manager.ShortLinkSig(slug, int(n)))) {
+			return c.Render(http.StatusBadRequest, tplMessage,
+				makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.invalidLink")))
+		}
+		subID = int(n)
 	}
 
-	url, err := app.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID)
+	l, err := app.core.GetLinkSlug(slug, subID)
 	if err != nil {
 		e := err.(*echo.HTTPError)
 		return c.Render(e.Code, tplMessage, makeMsgTpl(app.i18n.T("public.errorTitle"), "", e.Error()))
 	}
 
+	return registerLinkClick(c, l.URL, l.LinkUUID, l.CampaignUUID, l.SubscriberUUID, app)
+}
+
+// registerLinkClick records a link click and redirects to the link's URL.
+func registerLinkClick(c echo.Context, url, linkUUID, campUUID, subUUID string, app *App) error {
+	// Check for security scanners and bots before the subscriber ID is discarded.
+	botReason := ""
+	if app.constants.Privacy.FilterBotClicks {
+		botReason = botClickReason(c, campUUID, subUUID, app)
+	}
+
+	// If individual tracking is disabled, do not record the subscriber ID.
+	if !app.constants.Privacy.IndividualTracking {
+		subUUID = ""
+	}
+
+	// The click is buffered and written to the DB in bulk by the tracker.
+	app.tracker.AddClick(models.LinkClick{
+		LinkUUID:       linkUUID,
+		CampaignUUID:   campUUID,
+		SubscriberUUID: subUUID,
+		BotReason:      botReason,
+		CreatedAt:      time.Now(),
+	})
+	app.publishEvent(eventbus.TypeLinkClick, eventbus.Click{
+		LinkUUID:       linkUUID,
+		URL:            url,
+		CampaignUUID:   campUUID,
+		SubscriberUUID: subUUID,
+		BotReason:      botReason,
+	})
+
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
@@ -548,9 +1097,18 @@ func handleRegisterCampaignView(c echo.Context) error {
 
 	// Exclude dummy hits from template previews.
 	if campUUID != dummyUUID && subUUID != dummyUUID {
-		if err := app.core.RegisterCampaignView(campUUID, subUUID); err != nil {
-			app.log.Printf("error registering campaign view: %s", err)
-		}
+		proxy := isProxyView(c)
+		app.tracker.AddView(models.CampaignView{
+			CampaignUUID:   campUUID,
+			SubscriberUUID: subUUID,
+			Proxy:          proxy,
+			CreatedAt:      time.Now(),
+		})
+		app.publishEvent(eventbus.TypeCampaignView, eventbus.View{
+			CampaignUUID:   campUUID,
+			SubscriberUUID: subUUID,
+			Proxy:          proxy,
+		})
 	}
 
 	c.Response().Header().Set("Cache-Control", "no-cache")
@@ -572,6 +1130,10 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.invalidFeature")))
 	}
 
+	// Render the e-mail and responses in the subscriber's language.
+	sub, _ := app.core.GetSubscriber(0, subUUID, "")
+	lang := setCtxLang(c, app.getSubLang(sub, nil))
+
 	// Get the subscriber's data. A single query that gets the profile,
 	// list subscriptions, campaign views, and link clicks. Names of
 	// private lists are replaced with "Private list".
@@ -579,19 +1141,19 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 	if err != nil {
 		app.log.Printf("error exporting subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorProcessingRequest")))
 	}
 
 	// Prepare the attachment e-mail.
 	var msg bytes.Buffer
-	if err := app.notifTpls.tpls.ExecuteTemplate(&msg, notifSubscriberData, data); err != nil {
+	if err := lang.notifTpls.ExecuteTemplate(&msg, notifSubscriberData, data); err != nil {
 		app.log.Printf("error compiling notification template '%s': %v", notifSubscriberData, err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorProcessingRequest")))
 	}
 
 	var (
-		subject = app.i18n.Ts("email.data.title")
+		subject = lang.i18n.Ts("email.data.title")
 		body    = msg.Bytes()
 	)
 	subject, body = getTplSubject(subject, body)
@@ -614,11 +1176,11 @@ func handleSelfExportSubscriberData(c echo.Context) error {
 	}); err != nil {
 		app.log.Printf("error e-mailing subscriber profile: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorProcessingRequest")))
 	}
 
 	return c.Render(http.StatusOK, tplMessage,
-		makeMsgTpl(app.i18n.T("public.dataSentTitle"), "", app.i18n.T("public.dataSent")))
+		makeMsgTpl(lang.i18n.T("public.dataSentTitle"), "", lang.i18n.T("public.dataSent")))
 }
 
 // handleWipeSubscriberData allows a subscriber to delete their data. The
@@ -636,14 +1198,18 @@ func handleWipeSubscriberData(c echo.Context) error {
 			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.invalidFeature")))
 	}
 
+	// The subscriber's language is looked up before the profile is deleted.
+	sub, _ := app.core.GetSubscriber(0, subUUID, "")
+	lang := setCtxLang(c, app.getSubLang(sub, nil))
+
 	if err := app.core.DeleteSubscribers(nil, []string{subUUID}); err != nil {
 		app.log.Printf("error wiping subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(app.i18n.T("public.errorTitle"), "", app.i18n.Ts("public.errorProcessingRequest")))
+			makeMsgTpl(lang.i18n.T("public.errorTitle"), "", lang.i18n.Ts("public.errorProcessingRequest")))
 	}
 
 	return c.Render(http.StatusOK, tplMessage,
-		makeMsgTpl(app.i18n.T("public.dataRemovedTitle"), "", app.i18n.T("public.dataRemoved")))
+		makeMsgTpl(lang.i18n.T("public.dataRemovedTitle"), "", lang.i18n.T("public.dataRemoved")))
 }
 
 // drawTransparentImage draws a transparent PNG of given dimensions
@@ -658,25 +1224,28 @@ func drawTransparentImage(h, w int) []byte {
 }
 
 // processSubForm processes an incoming form/public API subscription request.
-// The bool indicates whether there was subscription to an optin list so that
-// an appropriate message can be shown.
-func processSubForm(c echo.Context) (bool, error) {
+// The first bool indicates whether there was subscription to an optin list so that
+// an appropriate message can be shown, and the second, whether an opt-in code was
+// sent for lists that are confirmed with a code.
+func processSubForm(c echo.Context) (bool, bool, error) {
 	var (
 		app = c.Get("app").(*App)
 		req struct {
 			Name          string   `form:"name" json:"name"`
 			Email         string   `form:"email" json:"email"`
 			FormListUUIDs []string `form:"l" json:"list_uuids"`
+			Lang          string   `form:"lang" json:"lang"`
+			Ref           string   `form:"ref" json:"ref"`
 		}
 	)
 
 	// Get and validate fields.
 	if err := c.Bind(&req); err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	if len(req.FormListUUIDs) == 0 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.noListsSelected"))
+		return false, false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.noListsSelected"))
 	}
 
 	// If there's no name, use the name bit from the e-mail.
@@ -687,46 +1256,160 @@ func processSubForm(c echo.Context) (bool, error) {
 
 	// Validate fields.
 	if len(req.Email) > 1000 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidEmail"))
+		return false, false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidEmail"))
 	}
 
 	em, err := app.importer.SanitizeEmail(req.Email)
 	if err != nil {
-		return false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return false, false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	req.Email = em
 
 	req.Name = strings.TrimSpace(req.Name)
 	if len(req.Name) == 0 || len(req.Name) > stdInputMaxLen {
-		return false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidName"))
+		return false, false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidName"))
 	}
 
-	listUUIDs := pq.StringArray(req.FormListUUIDs)
+	// Unknown language codes are ignored.
+	if len(req.Lang) > 6 || reLangCode.MatchString(req.Lang) {
+		req.Lang = ""
+	}
+
+	// Paid lists can only be subscribed to via Stripe.
+	lists, err := app.core.GetLists("", true, nil)
+	if err != nil {
+		return false, false, err
+	}
+	var (
+		paid = make(map[string]bool)
+		code = make(map[string]bool)
+	)
+	for _, l := range lists {
+		if l.StripePriceID != "" {
+			paid[l.UUID] = true
+		}
+		if l.Optin == models.ListOptinDouble && (l.OptinMethod == models.OptinMethodCode || l.OptinMethod == models.OptinMethodSMS) {
+			code[l.UUID] = true
+		}
+	}
+
+	listUUIDs := make(pq.StringArray, 0, len(req.FormListUUIDs))
+	for _, u := range req.FormListUUIDs {
+		if !paid[u] {
+			listUUIDs = append(listUUIDs, u)
+		}
+	}
+	if len(listUUIDs) == 0 {
+		return false, false, echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("public.noListsSelected"))
+	}
 
 	// Insert the subscriber into the DB.
-	_, hasOptin, err := app.core.InsertSubscriber(models.Subscriber{
+	sub, hasOptin, err := app.core.InsertSubscriber(models.Subscriber{
 		Name:   req.Name,
 		Email:  req.Email,
 		Status: models.SubscriberStatusEnabled,
+		Lang:   req.Lang,
+		Source: models.SourceForm,
 	}, nil, listUUIDs, false)
 	if err != nil {
-		// Subscriber already exists. Update subscriptions.
-		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusConflict {
-			sub, err := app.core.GetSubscriber(0, "", req.Email)
-			if err != nil {
-				return false, err
-			}
+		// Subscriber already exists. Update subscriptions. E-mails that don't
+		// pass the validation policy are rejected as they are.
+		e, ok := err.(*echo.HTTPError)
+		if ok && e.Code == http.StatusBadRequest {
+			return false, false, err
+		}
+		if !ok || e.Code != http.StatusConflict {
+			return false, false, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s", err.(*echo.HTTPError).Message))
+		}
 
-			_, hasOptin, err := app.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false)
-			if err != nil {
-				return false, err
-			}
+		sub, err = app.core.GetSubscriber(0, "", req.Email)
+		if err != nil {
+			return false, false, err
+		}
 
-			return hasOptin, nil
+		sub.Source = models.SourceForm
+		if _, hasOptin, err = app.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false); err != nil {
+			return false, false, err
+		}
+	} else if reRefCode.MatchString(req.Ref) {
+		// Attribute the new subscriber to the referrer. Only new signups count as referrals.
+		if err := app.core.RecordSubscriberReferral(sub.ID, req.Ref); err != nil {
+			app.log.Printf("error recording referral: %v", err)
 		}
+	}
 
-		return false, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s", err.(*echo.HTTPError).Message))
+	// Record the proof of consent on the subscriptions.
+	if app.constants.Privacy.RecordConsent {
+		if err := app.core.RecordConsent(sub.ID, nil, listUUIDs, makeConsent(c, models.SourceForm)); err != nil {
+			app.log.Printf("error recording form consent: %v", err)
+		}
 	}
 
-	return hasOptin, nil
+	hasOptinCode := false
+	if hasOptin {
+		for _, u := range listUUIDs {
+			hasOptinCode = hasOptinCode || code[u]
+		}
+	}
+
+	return hasOptin, hasOptinCode, nil
+}
+
+// confirmOptinCode confirms a subscriber's subscriptions with an opt-in code.
+// Unknown e-mails are reported as invalid codes.
+func confirmOptinCode(c echo.Context, email, code string) error {
+	app := c.Get("app").(*App)
+
+	em, err := app.importer.SanitizeEmail(email)
+	if err != nil || !strHasLen(code, 1, 10) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidOptinCode"))
+	}
+
+	sub, err := app.core.GetSubscriber(0, "", em)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidOptinCode"))
+	}
+
+	listIDs, err := app.core.ConfirmOptinCode(sub.ID, code, makeOptinMeta(c))
+	if err != nil {
+		return err
+	}
+
+	if app.constants.Privacy.RecordConsent && len(listIDs) > 0 {
+		if err := app.core.RecordConsent(sub.ID, listIDs, nil, makeConsent(c, models.SourceOptin)); err != nil {
+			app.log.Printf("error recording optin consent: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// makeOptinMeta returns the subscription meta recorded on opt-in confirmation.
+func makeOptinMeta(c echo.Context) models.JSON {
+	app := c.Get("app").(*App)
+
+	meta := models.JSON{}
+	if app.constants.Privacy.RecordOptinIP {
+		if h := c.Request().Header.Get("X-Forwarded-For"); h != "" {
+			meta["optin_ip"] = h
+		} else if h := c.Request().RemoteAddr; h != "" {
+			meta["optin_ip"] = strings.Split(h, ":")[0]
+		}
+	}
+
+	return meta
+}
+
+// makeConsent returns a proof-of-consent record with the requester's IP and
+// user agent, and the current consent text version.
+func makeConsent(c echo.Context, source string) models.Consent {
+	app := c.Get("app").(*App)
+
+	return models.Consent{
+		IP:          c.RealIP(),
+		UserAgent:   c.Request().UserAgent(),
+		Source:      source,
+		TextVersion: app.constants.Privacy.ConsentVersion,
+		Timestamp:   time.Now(),
+	}
 }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	// repermissionProcessInterval is the interval at which the re-permission
+	// runs whose deadlines have passed are processed.
+	repermissionProcessInterval = time.Minute
+
+	// Maximum number of days from now that a re-permission deadline can be set to.
+	maxRepermissionDeadlineDays = 365
+)
+
+type repermissionReq struct {
+	Name       string    `json:"name"`
+	Subject    string    `json:"subject"`
+	FromEmail  string    `json:"from_email"`
+	TemplateID int       `json:"template_id"`
+	ListIDs    []int     `json:"lists"`
+	Deadline   time.Time `json:"deadline"`
+	Action     string    `json:"action"`
+}
+
+// handleGetRepermissions returns the re-permission runs with their progress.
+func handleGetRepermissions(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	all, listIDs := campaignListScope(user, false)
+	out, err := app.core.QueryRepermissions(all, listIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetRepermission returns a re-permission run with its progress.
+func handleGetRepermission(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := getRepermission(id, user, false, app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetRepermissionTargets returns the number of unconfirmed subscriptions
+// to each of the lists in ?list_id= that a re-permission run would target.
+func handleGetRepermissionTargets(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	listIDs, err := parseStringIDs(c.Request().URL.Query()["list_id"])
+	if err != nil || len(listIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "list_id"))
+	}
+
+	if err := hasCampaignListPerm(user, listIDs, nil, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.GetRepermissionTargets(listIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateRepermission creates an opt-in campaign to the unconfirmed
+// subscribers of the given double opt-in lists and a re-permission run that
+// removes (or unsubscribes) the subscriptions that are still unconfirmed at the
+// deadline. The campaign is created as a draft to be reviewed and started.
+func handleCreateRepermission(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	// The run removes subscriptions at the deadline.
+	if !isSuperAdmin(user) && !user.HasPerm(models.PermSubscribersManage) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermSubscribersManage))
+	}
+
+	var req repermissionReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := validateRepermission(&req.Deadline, &req.Action, app); err != nil {
+		return err
+	}
+
+	if len(req.ListIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.fieldInvalidListIDs"))
+	}
+
+	// The user should be able to send to the lists.
+	if err := hasCampaignListPerm(user, req.ListIDs, nil, app); err != nil {
+		return err
+	}
+
+	// All the lists should be double opt-in and there should be someone to ask.
+	targets, err := app.core.GetRepermissionTargets(req.ListIDs)
+	if err != nil {
+		return err
+	}
+	if len(targets) != len(req.ListIDs) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.list}"))
+	}
+	total := 0
+	for _, t := range targets {
+		if t.Optin != models.ListOptinDouble {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("repermissions.notDoubleOptin", "name", t.ListName))
+		}
+		total += t.Unconfirmed
+	}
+	if total == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("repermissions.noTargets"))
+	}
+
+	// Prepare the opt-in campaign.
+	req.Subject = strings.TrimSpace(req.Subject)
+	if req.Subject == "" {
+		req.Subject = app.i18n.T("repermissions.defaultSubject")
+	}
+
+	o := campaignReq{
+		Campaign: models.Campaign{
+			Type:        models.CampaignTypeOptin,
+			Name:        strings.TrimSpace(req.Name),
+			Subject:     req.Subject,
+			FromEmail:   strings.TrimSpace(req.FromEmail),
+			ContentType: models.CampaignContentTypeRichtext,
+			Messenger:   "email",
+			TemplateID:  req.TemplateID,
+			Tags:        pq.StringArray{"re-permission"},
+		},
+		ListIDs: req.ListIDs,
+	}
+
+	o, err = makeOptinCampaignMessage(o, app)
+	if err != nil {
+		return err
+	}
+
+	if c, err := validateCampaignFields(o, app); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		o = c
+	}
+	o.ArchiveTemplateID = o.TemplateID
+
+	camp, err := app.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateRepermission(camp.ID, req.ListIDs, req.Deadline, req.Action, user.ID)
+	if err != nil {
+		// Don't leave behind a campaign without its run.
+		if err := app.core.DeleteCampaign(camp.ID); err != nil {
+			app.log.Printf("error deleting re-permission campaign %d: %v", camp.ID, err)
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateRepermission updates the deadline and action of a re-permission
+// run that hasn't been processed yet.
+func handleUpdateRepermission(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if !isSuperAdmin(user) && !user.HasPerm(models.PermSubscribersManage) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermSubscribersManage))
+	}
+
+	var req repermissionReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := validateRepermission(&req.Deadline, &req.Action, app); err != nil {
+		return err
+	}
+
+	if _, err := getRepermission(id, user, true, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateRepermission(id, req.Deadline, req.Action)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCancelRepermission cancels a re-permission run that hasn't been
+// processed yet. No subscriptions are removed and the campaign is left as it is.
+func handleCancelRepermission(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if _, err := getRepermission(id, user, true, app); err != nil {
+		return err
+	}
+
+	if err := app.core.CancelRepermission(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getRepermission returns a re-permission run if the user has access to its campaign.
+func getRepermission(id int, user models.User, write bool, app *App) (models.Repermission, error) {
+	out, err := app.core.GetRepermission(id)
+	if err != nil {
+		return out, err
+	}
+
+	if err := hasCampaignPerm(user, []int{out.CampaignID}, write, app); err != nil {
+		return models.Repermission{}, err
+	}
+
+	return out, nil
+}
+
+// validateRepermission validates the deadline and action of a re-permission
+// run. The action defaults to removing the subscriptions.
+func validateRepermission(deadline *time.Time, action *string, app *App) error {
+	now := time.Now()
+	if deadline.Before(now) || deadline.After(now.AddDate(0, 0, maxRepermissionDeadlineDays)) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "deadline"))
+	}
+
+	switch *action {
+	case "":
+		*action = models.RepermissionActionRemove
+	case models.RepermissionActionRemove, models.RepermissionActionUnsubscribe:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+	}
+
+	return nil
+}
+
+// runRepermissionProcessor periodically removes (or unsubscribes) the
+// subscriptions that are still unconfirmed in re-permission runs whose
+// campaigns have finished and whose deadlines have passed. This blocks and is
+// meant to be run in a goroutine.
+func runRepermissionProcessor(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() {
+			continue
+		}
+
+		res, err := app.core.ProcessRepermissions()
+		if err != nil {
+			continue
+		}
+
+		for _, r := range res {
+			app.log.Printf("re-permission %d of campaign %d: %s (%d subscriptions removed)", r.ID, r.CampaignID, r.Status, r.Removed)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/replies"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// handleGetCampaignReplies retrieves the replies to a campaign's messages,
+// optionally of a subscriber.
+func handleGetCampaignReplies(c echo.Context) error {
+	var (
+		app      = c.Get("app").(*App)
+		pg       = app.paginator.NewFromURL(c.Request().URL.Query())
+		id, _    = strconv.Atoi(c.Param("id"))
+		subID, _ = strconv.Atoi(c.QueryParam("subscriber_id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	res, total, err := app.core.QueryCampaignReplies(id, subID, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteCampaignReplies deletes a campaign's reply (ID in the URI),
+// or all of its replies.
+func handleDeleteCampaignReplies(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		pID   = c.Param("replyID")
+		ids   = []int64{}
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if pID != "" {
+		replyID, _ := strconv.ParseInt(pID, 10, 64)
+		if replyID < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+		}
+		ids = append(ids, replyID)
+	}
+
+	if err := app.core.DeleteCampaignReplies(id, ids); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// forwardReply forwards a campaign reply to the given e-mail addresses with
+// the replier as the Reply-To.
+func (app *App) forwardReply(to []string, r models.CampaignReply) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Reply-To", r.FromEmail)
+
+	return app.manager.PushMessage(models.Message{
+		From:        app.constants.FromEmail,
+		To:          to,
+		Subject:     "Fwd: " + r.Subject,
+		ContentType: models.CampaignContentTypePlain,
+		Body:        []byte(replies.ForwardBody(r)),
+		Headers:     h,
+		Messenger:   emailMsgr,
+	})
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdgvda/cron"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// reportScheduleInterval is the interval at which the schedules of
+	// reports are checked.
+	reportScheduleInterval = time.Minute
+
+	defaultReportTimeout     = time.Second * 30
+	defaultReportMaxRows     = 10000
+	defaultReportKeepResults = 10
+)
+
+// handleGetReports returns all saved reports.
+func handleGetReports(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := app.core.GetReports()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetReport returns a saved report.
+func handleGetReport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetReport(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateReport saves a new report.
+func handleCreateReport(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var o models.Report
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateReport(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateReport(o, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateReport updates a saved report.
+func handleUpdateReport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var o models.Report
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o, err := validateReport(o, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateReport(id, o)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteReport deletes a saved report and its results.
+func handleDeleteReport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteReport(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handleRunReport runs a report and returns the result.
+func handleRunReport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.RunReport(id, false, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetReportResults returns the kept results of a report without their data.
+func handleGetReportResults(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetReportResults(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetReportResult returns a result of a report (or the latest one) as
+// JSON, or with ?format=csv, as a CSV file.
+func handleGetReportResult(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		resID int64
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+	if p := c.Param("resultID"); p != "latest" {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+		}
+		resID = n
+	}
+
+	out, err := app.core.GetReportResult(id, resID)
+	if err != nil {
+		return err
+	}
+
+	switch c.QueryParam("format") {
+	case "", "json":
+		return c.JSON(http.StatusOK, okResp{out})
+	case "csv":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "format"))
+	}
+
+	b, err := reportResultCSV(out)
+	if err != nil {
+		app.log.Printf("error exporting report result: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.Ts("globals.messages.internalError"))
+	}
+
+	name := fmt.Sprintf("report-%d-%d.csv", id, out.ID)
+	c.Response().Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", b)
+}
+
+// reportResultCSV returns a report result as CSV with a header row of the column names.
+func reportResultCSV(r models.ReportResult) ([]byte, error) {
+	var cols []string
+	if err := json.Unmarshal(r.Columns, &cols); err != nil {
+		return nil, err
+	}
+
+	// Decode numbers as they are to not lose precision.
+	var rows [][]interface{}
+	dec := json.NewDecoder(bytes.NewReader(r.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+
+	var (
+		b  bytes.Buffer
+		wr = csv.NewWriter(&b)
+	)
+	if err := wr.Write(cols); err != nil {
+		return nil, err
+	}
+
+	rec := make([]string, len(cols))
+	for _, row := range rows {
+		for i := range rec {
+			rec[i] = ""
+			if i >= len(row) {
+				continue
+			}
+
+			switch v := row[i].(type) {
+			case nil:
+			case string:
+				rec[i] = v
+			case json.Number:
+				rec[i] = v.String()
+			case bool:
+				rec[i] = strconv.FormatBool(v)
+			default:
+				j, _ := json.Marshal(v)
+				rec[i] = string(j)
+			}
+		}
+		if err := wr.Write(rec); err != nil {
+			return nil, err
+		}
+	}
+	wr.Flush()
+
+	return b.Bytes(), wr.Error()
+}
+
+// validateReport validates the fields of a report.
+func validateReport(o models.Report, app *App) (models.Report, error) {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+	if !strHasLen(o.Description, 0, 2000) {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+	if strings.TrimSpace(o.Query) == "" {
+		return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "query"))
+	}
+
+	o.Schedule = strings.TrimSpace(o.Schedule)
+	if o.Schedule != "" {
+		if _, err := cron.ParseStandard(o.Schedule); err != nil {
+			return o, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("reports.invalidSchedule", "error", err.Error()))
+		}
+	}
+
+	return o, nil
+}
+
+// runReportScheduler periodically runs the reports whose cron schedules are
+// due since they were last run (or created). This blocks and is meant to be
+// run in a goroutine.
+func runReportScheduler(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() || app.constants.Reports.DBRole == "" {
+			continue
+		}
+
+		reports, err := app.core.GetReports()
+		if err != nil {
+			continue
+		}
+
+		now := time.Now()
+		for _, r := range reports {
+			if r.Schedule == "" {
+				continue
+			}
+
+			sched, err := cron.ParseStandard(r.Schedule)
+			if err != nil {
+				app.log.Printf("invalid schedule '%s' of report %d: %v", r.Schedule, r.ID, err)
+				continue
+			}
+
+			last := r.CreatedAt.Time
+			if r.LastRunAt.Valid {
+				last = r.LastRunAt.Time
+			}
+			if sched.Next(last).After(now) {
+				continue
+			}
+
+			res, err := app.core.RunReport(r.ID, true, 0)
+			if err != nil {
+				app.log.Printf("error running report %d: %v", r.ID, err)
+				continue
+			}
+			if res.Status == models.ReportStatusError {
+				app.log.Printf("error running report %d: %s", r.ID, res.Error)
+			}
+		}
+	}
+}
@@ -206,7 +206,7 @@ func validateListRole(r models.ListRole, app *App) error {
 
 	for _, l := range r.Lists {
 		for _, p := range l.Permissions {
-			if p != "list:get" && p != "list:manage" {
+			if p != models.PermListGet && p != models.PermListManage && p != models.PermListSend {
 				return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("list permission: %s", p)))
 			}
 		}
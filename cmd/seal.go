@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/seal"
+	"github.com/knadh/listmonk/models"
+)
+
+// initSeal returns the Seal that encrypts the secrets in the settings at rest
+// with the key in app.secrets_key or app.secrets_key_file. Old keys in
+// app.secrets_old_keys are used to decrypt values while keys are rotated.
+// If there's no key, nil is returned and secrets are stored as plaintext.
+func initSeal(ko *koanf.Koanf) *seal.Seal {
+	key := ko.String("app.secrets_key")
+	if fPath := ko.String("app.secrets_key_file"); fPath != "" {
+		b, err := os.ReadFile(fPath)
+		if err != nil {
+			lo.Fatalf("error reading app.secrets_key_file: %v", err)
+		}
+		key = strings.TrimSpace(string(b))
+	}
+
+	if key == "" {
+		return nil
+	}
+
+	s, err := seal.New(key)
+	if err != nil {
+		lo.Fatalf("invalid app.secrets_key: %v", err)
+	}
+
+	for _, k := range ko.Strings("app.secrets_old_keys") {
+		if err := s.AddOldKey(k); err != nil {
+			lo.Fatalf("invalid key in app.secrets_old_keys: %v", err)
+		}
+	}
+
+	return s
+}
+
+// encryptSettings re-encrypts all the secrets in the settings in the DB with
+// the current key. Plaintext secrets and secrets encrypted with an old key are
+// encrypted with the current key. If there's no key, the secrets are decrypted
+// and stored as plaintext.
+func encryptSettings(db *sqlx.DB, s *seal.Seal) {
+	var b []byte
+	if err := db.Get(&b, `SELECT JSON_OBJECT_AGG(key, value) FROM settings`); err != nil {
+		lo.Fatalf("error reading settings from DB: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		lo.Fatalf("error unmarshalling settings from DB: %v", err)
+	}
+
+	// Decrypt everything first so that values encrypted with old keys are
+	// re-encrypted with the current one.
+	if err := s.DecryptFields(m, models.SecretSettings); err != nil {
+		lo.Fatalf("error decrypting settings: %v", err)
+	}
+	if err := s.EncryptFields(m, models.SecretSettings); err != nil {
+		lo.Fatalf("error encrypting settings: %v", err)
+	}
+
+	// Only update the keys that contain secrets.
+	upd := map[string]interface{}{}
+	for _, p := range models.SecretSettings {
+		if v, ok := m[p[0]]; ok {
+			upd[p[0]] = v
+		}
+	}
+
+	out, err := json.Marshal(upd)
+	if err != nil {
+		lo.Fatalf("error marshalling settings: %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE settings AS s SET value = c.value, updated_at = NOW()
+		FROM (SELECT * FROM JSONB_EACH($1)) AS c(key, value) WHERE s.key = c.key`, out); err != nil {
+		lo.Fatalf("error updating settings: %v", err)
+	}
+
+	if s == nil {
+		lo.Printf("decrypted %d settings to plaintext as there's no app.secrets_key", len(upd))
+		return
+	}
+	lo.Printf("encrypted secrets in %d settings", len(upd))
+}
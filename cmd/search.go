@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	searchDefLimit = 10
+	searchMaxLimit = 50
+)
+
+// handleSearch performs a ranked full-text search across campaigns, templates,
+// lists, and subscribers. Object types that the user doesn't have permissions
+// to view are silently skipped.
+func handleSearch(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+
+		query    = strings.TrimSpace(c.FormValue("q"))
+		types    = c.QueryParams()["type"]
+		limit, _ = strconv.Atoi(c.FormValue("per_type"))
+	)
+
+	if !strHasLen(query, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "q"))
+	}
+	if limit < 1 || limit > searchMaxLimit {
+		limit = searchDefLimit
+	}
+	if len(types) == 0 {
+		types = []string{models.SearchTypeCampaign, models.SearchTypeTemplate, models.SearchTypeList, models.SearchTypeSubscriber}
+	}
+
+	// List permissions.
+	var (
+		listIDs     = user.GetListIDs
+		getAllLists = user.HasPerm(models.PermListGetAll)
+		getAllSubs  = user.HasPerm(models.PermSubscribersGetAll)
+
+		getAllCamps, campListIDs = campaignListScope(user, false)
+	)
+
+	// Only search the types that the user has permissions for.
+	permTypes := make([]string, 0, len(types))
+	for _, t := range types {
+		switch t {
+		case models.SearchTypeCampaign:
+			if !user.HasPerm(models.PermCampaignsGet) {
+				continue
+			}
+		case models.SearchTypeTemplate:
+			if !user.HasPerm(models.PermTemplatesGet) {
+				continue
+			}
+		case models.SearchTypeList:
+		case models.SearchTypeSubscriber:
+			if !getAllSubs && !user.HasPerm(models.PermSubscribersGet) {
+				continue
+			}
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+
+		permTypes = append(permTypes, t)
+	}
+
+	out, err := app.core.Search(query, permTypes, limit, getAllLists, listIDs, getAllSubs, getAllCamps, campListIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
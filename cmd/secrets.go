@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/secrets"
+)
+
+// Minimum interval at which secret references are re-fetched.
+const minSecretsRefreshInterval = time.Minute
+
+// initSecrets returns the resolver for secret references in the config and
+// settings (file:, vault:, ssm:). The providers are configured in the [secrets]
+// config section or with their standard environment variables.
+func initSecrets(ko *koanf.Koanf) *secrets.Resolver {
+	get := func(key, env string) string {
+		if v := ko.String(key); v != "" {
+			return v
+		}
+		return os.Getenv(env)
+	}
+
+	return secrets.New(secrets.Opt{
+		VaultAddr:       get("secrets.vault_address", "VAULT_ADDR"),
+		VaultToken:      get("secrets.vault_token", "VAULT_TOKEN"),
+		AWSRegion:       get("secrets.aws_region", "AWS_REGION"),
+		AWSAccessKey:    get("secrets.aws_access_key_id", "AWS_ACCESS_KEY_ID"),
+		AWSSecretKey:    get("secrets.aws_secret_access_key", "AWS_SECRET_ACCESS_KEY"),
+		AWSSessionToken: get("secrets.aws_session_token", "AWS_SESSION_TOKEN"),
+		Timeout:         ko.Duration("secrets.timeout"),
+	})
+}
+
+// resolveConfigSecrets replaces the secret references in the loaded config
+// with the secrets.
+func resolveConfigSecrets(r *secrets.Resolver, ko *koanf.Koanf) {
+	raw := ko.Raw()
+	if err := r.ResolveMap(raw); err != nil {
+		lo.Fatalf("error resolving secret in config: %v", err)
+	}
+
+	if r.NumRefs() == 0 {
+		return
+	}
+
+	if err := ko.Load(confmap.Provider(raw, "."), nil); err != nil {
+		lo.Fatalf("error loading resolved secrets into config: %v", err)
+	}
+	lo.Printf("resolved %d secret reference(s) in config", r.NumRefs())
+}
+
+// runSecretsRefresh periodically re-fetches the resolved secret references and
+// reloads the app when any of them changes. If there are running campaigns,
+// the reload is left to the admin like with settings changes.
+func runSecretsRefresh(interval time.Duration, app *App) {
+	if interval < minSecretsRefreshInterval {
+		interval = minSecretsRefreshInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		changed, err := secretResolver.Changed()
+		if err != nil {
+			app.log.Printf("error refreshing secrets: %v", err)
+			continue
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		app.log.Printf("secrets changed: %s", strings.Join(changed, ", "))
+		if app.manager.HasRunningCampaigns() {
+			app.Lock()
+			app.needsRestart = true
+			app.Unlock()
+			continue
+		}
+
+		app.chReload <- syscall.SIGHUP
+		return
+	}
+}
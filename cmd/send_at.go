@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// Relative offsets, eg: +2h, +1d, +1w2d3h30m.
+	reSendAtOffset = regexp.MustCompile(`^\+(?:(\d+)w)?(?:(\d+)d)?(.*)$`)
+
+	// Times of the day, eg: 9am, 9:30pm, 14:30.
+	reSendAtClock = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+	// Timestamps without a timezone offset, which are in the send_at timezone.
+	sendAtLayouts = []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	}
+
+	sendAtWeekdays = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	}
+)
+
+// resolveSendAtReq rewrites the send_at of a JSON campaign request into an
+// absolute timestamp before the request is bound. Besides timestamps, send_at
+// can be a timestamp without a timezone offset (2024-06-01 09:00), or a relative
+// expression (+2h, tomorrow 9am, next monday 9am Europe/Berlin), which are
+// resolved in the request's send_at_tz, or the expression's own timezone,
+// or the instance's default timezone, in that order. send_at_tz is set to
+// the timezone that the send_at was resolved in.
+func resolveSendAtReq(c echo.Context, app *App) error {
+	req := c.Request()
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	var (
+		fields map[string]json.RawMessage
+		sendAt string
+		tz     string
+	)
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// Let the binding report the error.
+		return nil
+	}
+	if v, ok := fields["send_at_tz"]; ok {
+		_ = json.Unmarshal(v, &tz)
+	}
+	if v, ok := fields["send_at"]; !ok || json.Unmarshal(v, &sendAt) != nil || sendAt == "" {
+		return nil
+	}
+
+	loc := app.constants.DefaultLocation
+	if tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("campaigns.fieldInvalidSendAtTZ", "name", tz))
+		}
+		loc = l
+	}
+
+	t, loc, err := parseSendAt(sendAt, loc, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("campaigns.fieldInvalidSendAtExp", "error", err.Error()))
+	}
+
+	fields["send_at"], _ = json.Marshal(t.Format(time.RFC3339))
+	fields["send_at_tz"], _ = json.Marshal(loc.String())
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData"))
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+
+	return nil
+}
+
+// parseSendAt parses a send_at timestamp or a relative scheduling expression
+// in the given timezone, relative to now. An IANA timezone at the end of an
+// expression (eg: next monday 9am Europe/Berlin) overrides the timezone.
+// The time and the timezone it was resolved in are returned.
+func parseSendAt(s string, loc *time.Location, now time.Time) (time.Time, *time.Location, error) {
+	s = strings.TrimSpace(s)
+
+	// Timestamps with an offset are absolute.
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, loc, nil
+	}
+
+	parts := strings.Fields(s)
+	if len(parts) > 1 {
+		if l, ok := sendAtLocation(parts[len(parts)-1]); ok {
+			loc = l
+			parts = parts[:len(parts)-1]
+		}
+	}
+	s = strings.Join(parts, " ")
+	now = now.In(loc)
+
+	// Timestamps without an offset.
+	for _, l := range sendAtLayouts {
+		if t, err := time.ParseInLocation(l, s, loc); err == nil {
+			return t, loc, nil
+		}
+	}
+
+	s = strings.ToLower(s)
+
+	// Relative offsets.
+	if m := reSendAtOffset.FindStringSubmatch(s); m != nil {
+		var d time.Duration
+		if m[1] != "" {
+			n, _ := strconv.Atoi(m[1])
+			d += time.Duration(n) * time.Hour * 24 * 7
+		}
+		if m[2] != "" {
+			n, _ := strconv.Atoi(m[2])
+			d += time.Duration(n) * time.Hour * 24
+		}
+		if m[3] != "" {
+			dr, err := time.ParseDuration(m[3])
+			if err != nil {
+				return time.Time{}, loc, fmt.Errorf("invalid offset '%s'", s)
+			}
+			d += dr
+		}
+		if d <= 0 {
+			return time.Time{}, loc, fmt.Errorf("invalid offset '%s'", s)
+		}
+
+		return now.Add(d), loc, nil
+	}
+
+	// Day (and time) expressions: today, tomorrow, monday, next monday + 9am.
+	parts = strings.Fields(s)
+	if len(parts) == 0 {
+		return time.Time{}, loc, errors.New("empty expression")
+	}
+
+	var (
+		days int
+		next bool
+	)
+	if parts[0] == "next" && len(parts) > 1 {
+		next = true
+		parts = parts[1:]
+	}
+	w, isDay := sendAtWeekdays[parts[0]]
+	switch {
+	case parts[0] == "today" && !next:
+		days = 0
+	case parts[0] == "tomorrow" && !next:
+		days = 1
+	case isDay:
+		days = (int(w) - int(now.Weekday()) + 7) % 7
+	default:
+		return time.Time{}, loc, fmt.Errorf("unknown expression '%s'", s)
+	}
+
+	// The time of the day is optional and defaults to the current time.
+	hour, mins := now.Hour(), now.Minute()
+	if clock := strings.Join(parts[1:], ""); clock != "" {
+		h, m, err := parseSendAtClock(clock)
+		if err != nil {
+			return time.Time{}, loc, err
+		}
+		hour, mins = h, m
+	}
+
+	t := time.Date(now.Year(), now.Month(), now.Day()+days, hour, mins, 0, 0, loc)
+
+	// "next monday" on a Monday, or a Monday whose time has passed, is the one next week.
+	if isDay && days == 0 && (next || !t.After(now)) {
+		t = t.AddDate(0, 0, 7)
+	}
+
+	return t, loc, nil
+}
+
+// parseSendAtClock parses a time of the day, eg: 9am, 9:30pm, 14:30, noon.
+func parseSendAtClock(s string) (int, int, error) {
+	switch s {
+	case "noon":
+		return 12, 0, nil
+	case "midnight":
+		return 0, 0, nil
+	}
+
+	m := reSendAtClock.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, fmt.Errorf("invalid time '%s'", s)
+	}
+
+	h, _ := strconv.Atoi(m[1])
+	mins := 0
+	if m[2] != "" {
+		mins, _ = strconv.Atoi(m[2])
+	}
+
+	switch m[3] {
+	case "am", "pm":
+		if h < 1 || h > 12 {
+			return 0, 0, fmt.Errorf("invalid time '%s'", s)
+		}
+		h = h % 12
+		if m[3] == "pm" {
+			h += 12
+		}
+	}
+	if h > 23 || mins > 59 {
+		return 0, 0, fmt.Errorf("invalid time '%s'", s)
+	}
+
+	return h, mins, nil
+}
+
+// sendAtLocation returns the location of an IANA timezone name, eg: Europe/Berlin.
+func sendAtLocation(name string) (*time.Location, bool) {
+	if name != "UTC" && !strings.Contains(name, "/") {
+		return nil, false
+	}
+
+	l, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+
+	return l, true
+}
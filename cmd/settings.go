@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"runtime"
 	"strings"
@@ -17,7 +20,14 @@ import (
 	"github.com/knadh/koanf/parsers/json"
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/mailcrypt"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger/devnull"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/sanitize"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -34,18 +44,51 @@ type aboutSystem struct {
 	AllocMB uint64 `json:"memory_alloc_mb"`
 	OSMB    uint64 `json:"memory_from_os_mb"`
 }
+type aboutDBPool struct {
+	MaxOpen            int    `json:"max_open"`
+	MaxIdle            int    `json:"max_idle"`
+	MaxLifetime        string `json:"max_lifetime"`
+	MaxIdleTime        string `json:"max_idle_time"`
+	StatementTimeout   string `json:"statement_timeout"`
+	SlowQueryThreshold string `json:"slow_query_threshold"`
+
+	Open         int    `json:"open"`
+	InUse        int    `json:"in_use"`
+	Idle         int    `json:"idle"`
+	WaitCount    int64  `json:"wait_count"`
+	WaitDuration string `json:"wait_duration"`
+	SlowQueries  uint64 `json:"slow_queries"`
+}
 type about struct {
-	Version   string         `json:"version"`
-	Build     string         `json:"build"`
-	GoVersion string         `json:"go_version"`
-	GoArch    string         `json:"go_arch"`
-	Database  types.JSONText `json:"database"`
-	System    aboutSystem    `json:"system"`
-	Host      aboutHost      `json:"host"`
+	Version     string                   `json:"version"`
+	Build       string                   `json:"build"`
+	GoVersion   string                   `json:"go_version"`
+	GoArch      string                   `json:"go_arch"`
+	Database    types.JSONText           `json:"database"`
+	DBPool      aboutDBPool              `json:"db_pool"`
+	Concurrency manager.ConcurrencyStats `json:"concurrency"`
+	Breakers    []manager.BreakerStats   `json:"breakers"`
+	System      aboutSystem              `json:"system"`
+	Host        aboutHost                `json:"host"`
+	IsLeader    bool                     `json:"is_leader"`
+}
+
+// settingsError is a validation error of a settings field.
+type settingsError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// settingsPreview is the result of validating a settings payload without applying it.
+type settingsPreview struct {
+	Valid   bool                    `json:"valid"`
+	Errors  []settingsError         `json:"errors"`
+	Changes []models.SettingsChange `json:"changes"`
 }
 
 var (
-	reAlphaNum = regexp.MustCompile(`[^a-z0-9\-]`)
+	reAlphaNum         = regexp.MustCompile(`[^a-z0-9\-]`)
+	reFeedbackIDSender = regexp.MustCompile(`^[a-zA-Z0-9._\-]{1,64}$`)
 )
 
 // handleGetSettings returns settings from the DB.
@@ -57,7 +100,19 @@ func handleGetSettings(c echo.Context) error {
 		return err
 	}
 
-	// Empty out passwords.
+	return c.JSON(http.StatusOK, okResp{maskSettings(s)})
+}
+
+// maskSettings replaces the passwords and secrets in settings with masks
+// of the same length.
+func maskSettings(s models.Settings) models.Settings {
+	// Copy the slices so that the original settings aren't masked.
+	s.SMTP = append(s.SMTP[:0:0], s.SMTP...)
+	s.BounceBoxes = append(s.BounceBoxes[:0:0], s.BounceBoxes...)
+	s.Messengers = append(s.Messengers[:0:0], s.Messengers...)
+	s.Notifications = append(s.Notifications[:0:0], s.Notifications...)
+	s.SuppressionSources = append(s.SuppressionSources[:0:0], s.SuppressionSources...)
+
 	for i := 0; i < len(s.SMTP); i++ {
 		s.SMTP[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SMTP[i].Password))
 	}
@@ -67,22 +122,32 @@ func handleGetSettings(c echo.Context) error {
 	for i := 0; i < len(s.Messengers); i++ {
 		s.Messengers[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Password))
 	}
+	for i := 0; i < len(s.Notifications); i++ {
+		s.Notifications[i].Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Notifications[i].Key))
+	}
+	for i := 0; i < len(s.SuppressionSources); i++ {
+		s.SuppressionSources[i].Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SuppressionSources[i].Key))
+	}
 
 	s.UploadS3AwsSecretAccessKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadS3AwsSecretAccessKey))
 	s.SendgridKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SendgridKey))
 	s.BouncePostmark.Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BouncePostmark.Password))
 	s.BounceForwardEmail.Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceForwardEmail.Key))
 	s.SecurityCaptchaSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityCaptchaSecret))
+	s.SecuritySMIMEKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecuritySMIMEKey))
 	s.OIDC.ClientSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.OIDC.ClientSecret))
+	s.Stripe.SecretKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Stripe.SecretKey))
+	s.Stripe.WebhookSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Stripe.WebhookSecret))
 
-	return c.JSON(http.StatusOK, okResp{s})
+	return s
 }
 
-// handleUpdateSettings returns settings from the DB.
+// handleUpdateSettings validates and updates the settings in the DB and reloads the app.
 func handleUpdateSettings(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		set models.Settings
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		set  models.Settings
 	)
 
 	// Unmarshal and marshal the fields once to sanitize the settings blob.
@@ -96,6 +161,83 @@ func handleUpdateSettings(c echo.Context) error {
 		return err
 	}
 
+	set, errs := prepareSettings(set, cur, app)
+	if len(errs) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, errs[0].Error)
+	}
+
+	// Update the settings in the DB.
+	if err := app.core.UpdateSettings(set); err != nil {
+		return err
+	}
+	app.core.RecordEvent(models.EventLogSettings, app.i18n.T("events.settingsUpdated"),
+		models.JSON{"keys": core.ChangedSettingsKeys(cur, set)}, user.ID)
+
+	// If there are any active campaigns, don't do an auto reload and
+	// warn the user on the frontend.
+	if app.manager.HasRunningCampaigns() {
+		app.Lock()
+		app.needsRestart = true
+		app.Unlock()
+
+		return c.JSON(http.StatusOK, okResp{struct {
+			NeedsRestart bool `json:"needs_restart"`
+		}{true}})
+	}
+
+	// No running campaigns. Reload the app.
+	go func() {
+		<-time.After(time.Millisecond * 500)
+		app.chReload <- syscall.SIGHUP
+	}()
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// handlePreviewSettings validates a settings payload without applying it and
+// returns all the invalid fields and the settings that would change.
+func handlePreviewSettings(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		set models.Settings
+	)
+
+	if err := c.Bind(&set); err != nil {
+		return err
+	}
+
+	cur, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	set, errs := prepareSettings(set, cur, app)
+
+	// Secrets are masked in the changed values.
+	keys := core.ChangedSettingsKeys(cur, set)
+	out := settingsPreview{
+		Valid:   len(errs) == 0,
+		Errors:  errs,
+		Changes: core.DiffSettings(maskSettings(cur), maskSettings(set), keys),
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// prepareSettings sanitizes an incoming settings payload, copies the unchanged
+// secrets from the current settings, and validates it. It returns the settings
+// and the errors of all the invalid fields.
+func prepareSettings(set, cur models.Settings, app *App) (models.Settings, []settingsError) {
+	errs := []settingsError{}
+	addErr := func(field, msg string) {
+		errs = append(errs, settingsError{Field: field, Error: msg})
+	}
+	checkDuration := func(field, val string) {
+		if d, err := time.ParseDuration(val); err != nil || d <= 0 {
+			addErr(field, app.i18n.Ts("globals.messages.invalidFields", "name", field))
+		}
+	}
+
 	// There should be at least one SMTP block that's enabled.
 	has := false
 	for i, s := range set.SMTP {
@@ -115,6 +257,10 @@ func handleUpdateSettings(c echo.Context) error {
 		// This is a common mistake when copy-pasting SMTP settings.
 		set.SMTP[i].Host = strings.TrimSpace(s.Host)
 
+		if s.Enabled {
+			validateSMTPSettings(i, set, addErr, checkDuration, app)
+		}
+
 		// If there's no password coming in from the frontend, copy the existing
 		// password by matching the UUID.
 		if s.Password == "" {
@@ -125,11 +271,77 @@ func handleUpdateSettings(c echo.Context) error {
 			}
 		}
 	}
-	if !has {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("settings.errorNoSMTP"))
+
+	// The Feedback-ID sender identifier is a field in the colon separated header.
+	set.FeedbackIDSender = strings.TrimSpace(set.FeedbackIDSender)
+	if set.FeedbackIDEnabled && !reFeedbackIDSender.MatchString(set.FeedbackIDSender) {
+		addErr("feedback_id.sender", app.i18n.Ts("globals.messages.invalidFields", "name", "feedback_id.sender"))
+	}
+
+	// SMTP servers aren't required when e-mails are delivered directly to the
+	// recipients' mail servers.
+	if set.SMTPMXEnabled {
+		set.SMTPMXHelloHostname = strings.TrimSpace(set.SMTPMXHelloHostname)
+		if set.SMTPMXMaxConns < 1 {
+			addErr("smtp_mx.max_conns", app.i18n.Ts("globals.messages.invalidFields", "name", "smtp_mx.max_conns"))
+		}
+		if set.SMTPMXMaxRetries < 0 {
+			addErr("smtp_mx.max_retries", app.i18n.Ts("globals.messages.invalidFields", "name", "smtp_mx.max_retries"))
+		}
+		checkDuration("smtp_mx.idle_timeout", set.SMTPMXIdleTimeout)
+		checkDuration("smtp_mx.retry_interval", set.SMTPMXRetryInterval)
+	} else if !has {
+		addErr("smtp", app.i18n.T("settings.errorNoSMTP"))
 	}
 
 	set.AppRootURL = strings.TrimRight(set.AppRootURL, "/")
+	if !isSettingsURL(set.AppRootURL) {
+		addErr("app.root_url", app.i18n.Ts("globals.messages.invalidFields", "name", "app.root_url"))
+	}
+	if set.AppLogoURL != "" && !isSettingsURL(set.AppLogoURL) {
+		addErr("app.logo_url", app.i18n.Ts("globals.messages.invalidFields", "name", "app.logo_url"))
+	}
+	if set.AppFaviconURL != "" && !isSettingsURL(set.AppFaviconURL) {
+		addErr("app.favicon_url", app.i18n.Ts("globals.messages.invalidFields", "name", "app.favicon_url"))
+	}
+	if set.OIDC.Enabled && !isSettingsURL(set.OIDC.ProviderURL) {
+		addErr("security.oidc.provider_url", app.i18n.Ts("globals.messages.invalidFields", "name", "security.oidc.provider_url"))
+	}
+	if set.UploadProvider == "s3" && set.UploadS3URL != "" && !isSettingsURL(set.UploadS3URL) {
+		addErr("upload.s3.url", app.i18n.Ts("globals.messages.invalidFields", "name", "upload.s3.url"))
+	}
+	if set.UploadProvider == "s3" {
+		checkDuration("upload.s3.expiry", set.UploadS3Expiry)
+	}
+	if set.AppMessageSlidingWindow {
+		checkDuration("app.message_sliding_window_duration", set.AppMessageSlidingWindowDuration)
+	}
+	if set.AppConcurrency < 1 {
+		addErr("app.concurrency", app.i18n.Ts("globals.messages.invalidFields", "name", "app.concurrency"))
+	}
+	if set.AppMessageRate < 1 {
+		addErr("app.message_rate", app.i18n.Ts("globals.messages.invalidFields", "name", "app.message_rate"))
+	}
+	if set.AppAdaptiveConcurrency {
+		if set.AppMinConcurrency < 1 || set.AppMinConcurrency > set.AppConcurrency {
+			addErr("app.min_concurrency", app.i18n.Ts("globals.messages.invalidFields", "name", "app.min_concurrency"))
+		}
+		checkDuration("app.adaptive_latency", set.AppAdaptiveLatency)
+		if set.AppAdaptiveErrorRate < 0 || set.AppAdaptiveErrorRate > 100 {
+			addErr("app.adaptive_error_rate", app.i18n.Ts("globals.messages.invalidFields", "name", "app.adaptive_error_rate"))
+		}
+	}
+	if set.AppBatchSize < 1 {
+		addErr("app.batch_size", app.i18n.Ts("globals.messages.invalidFields", "name", "app.batch_size"))
+	}
+	if set.AppBreakerThreshold < 0 {
+		addErr("app.breaker_threshold", app.i18n.Ts("globals.messages.invalidFields", "name", "app.breaker_threshold"))
+	} else if set.AppBreakerThreshold > 0 {
+		checkDuration("app.breaker_cooldown", set.AppBreakerCooldown)
+	}
+	if set.OutboxReviewThreshold < 0 {
+		addErr("app.outbox_review_threshold", app.i18n.Ts("globals.messages.invalidFields", "name", "app.outbox_review_threshold"))
+	}
 
 	// Bounce boxes.
 	for i, s := range set.BounceBoxes {
@@ -146,7 +358,7 @@ func handleUpdateSettings(c echo.Context) error {
 		set.BounceBoxes[i].Host = strings.TrimSpace(s.Host)
 
 		if d, _ := time.ParseDuration(s.ScanInterval); d.Minutes() < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("settings.bounces.invalidScanInterval"))
+			addErr(fmt.Sprintf("bounce.mailboxes.%d.scan_interval", i), app.i18n.T("settings.bounces.invalidScanInterval"))
 		}
 
 		// If there's no password coming in from the frontend, copy the existing
@@ -161,8 +373,8 @@ func handleUpdateSettings(c echo.Context) error {
 	}
 
 	// Validate and sanitize postback Messenger names. Duplicates are disallowed
-	// and "email" is a reserved name.
-	names := map[string]bool{emailMsgr: true}
+	// and "email" and "devnull" are reserved names.
+	names := map[string]bool{emailMsgr: true, devnull.Name: true}
 
 	for i, m := range set.Messengers {
 		// UUID to keep track of password changes similar to the SMTP logic above.
@@ -180,17 +392,113 @@ func handleUpdateSettings(c echo.Context) error {
 
 		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
 		if _, ok := names[name]; ok {
-			return echo.NewHTTPError(http.StatusBadRequest,
-				app.i18n.Ts("settings.duplicateMessengerName", "name", name))
+			addErr(fmt.Sprintf("messengers.%d.name", i), app.i18n.Ts("settings.duplicateMessengerName", "name", name))
 		}
 		if len(name) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("settings.invalidMessengerName"))
+			addErr(fmt.Sprintf("messengers.%d.name", i), app.i18n.T("settings.invalidMessengerName"))
+		}
+		if m.Enabled {
+			if !isSettingsURL(m.RootURL) {
+				addErr(fmt.Sprintf("messengers.%d.root_url", i), app.i18n.Ts("globals.messages.invalidFields", "name", "root_url"))
+			}
+			if m.MaxConns < 1 {
+				addErr(fmt.Sprintf("messengers.%d.max_conns", i), app.i18n.Ts("globals.messages.invalidFields", "name", "max_conns"))
+			}
+			checkDuration(fmt.Sprintf("messengers.%d.timeout", i), m.Timeout)
 		}
 
 		set.Messengers[i].Name = name
 		names[name] = true
 	}
 
+	// Validate notification channels.
+	for i, n := range set.Notifications {
+		// UUID to keep track of key changes similar to the SMTP logic above.
+		if n.UUID == "" {
+			set.Notifications[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if n.Key == "" {
+			for _, c := range cur.Notifications {
+				if n.UUID == c.UUID {
+					set.Notifications[i].Key = c.Key
+				}
+			}
+		}
+
+		switch n.Type {
+		case notifs.TypeEmail:
+		case notifs.TypeSlack, notifs.TypeWebhook:
+			if !strHasLen(n.URL, 1, stdInputMaxLen) || !isSettingsURL(n.URL) {
+				addErr(fmt.Sprintf("notifications.%d.url", i), app.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+			}
+		case notifs.TypePagerDuty:
+			if set.Notifications[i].Key == "" {
+				addErr(fmt.Sprintf("notifications.%d.key", i), app.i18n.Ts("globals.messages.invalidFields", "name", "key"))
+			}
+		default:
+			addErr(fmt.Sprintf("notifications.%d.type", i), app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+	}
+
+	// Validate suppression sources.
+	for i, src := range set.SuppressionSources {
+		if src.UUID == "" {
+			set.SuppressionSources[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		// Retain the existing key if it's not changed.
+		if src.Key == "" {
+			for _, c := range cur.SuppressionSources {
+				if src.UUID == c.UUID {
+					set.SuppressionSources[i].Key = c.Key
+				}
+			}
+		}
+
+		if err := validateSuppressionSource(set.SuppressionSources[i], app); err != nil {
+			addErr(fmt.Sprintf("privacy.suppression_sources.%d", i), httpErrMsg(err))
+		}
+	}
+
+	// Validate alert rules.
+	for i, r := range set.AlertRules {
+		if r.UUID == "" {
+			set.AlertRules[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+		if err := validateAlertRule(r, app); err != nil {
+			addErr(fmt.Sprintf("alert_rules.%d", i), httpErrMsg(err))
+		}
+	}
+
+	// Validate the sender profile.
+	sp, err := validateSenderProfile(models.SenderProfile{
+		CompanyName: set.SenderCompanyName,
+		Address:     set.SenderAddress,
+		Links:       set.SenderLinks,
+	}, app)
+	if err != nil {
+		addErr("sender", httpErrMsg(err))
+	}
+	set.SenderCompanyName, set.SenderAddress, set.SenderLinks = sp.CompanyName, sp.Address, sp.Links
+
+	// Validate compliance profiles. Only the known profiles can be configured,
+	// once each.
+	if set.ComplianceProfiles == nil {
+		set.ComplianceProfiles = []models.ComplianceProfile{}
+	}
+	profiles := map[string]bool{}
+	for i, p := range set.ComplianceProfiles {
+		switch p.Name {
+		case models.ComplianceCANSPAM, models.ComplianceCASL, models.ComplianceGDPR:
+			if !profiles[p.Name] {
+				profiles[p.Name] = true
+				continue
+			}
+		}
+		addErr(fmt.Sprintf("compliance.profiles.%d", i), app.i18n.Ts("globals.messages.invalidFields", "name", p.Name))
+	}
+
 	// S3 password?
 	if set.UploadS3AwsSecretAccessKey == "" {
 		set.UploadS3AwsSecretAccessKey = cur.UploadS3AwsSecretAccessKey
@@ -207,9 +515,23 @@ func handleUpdateSettings(c echo.Context) error {
 	if set.SecurityCaptchaSecret == "" {
 		set.SecurityCaptchaSecret = cur.SecurityCaptchaSecret
 	}
+	if set.SecuritySMIMEKey == "" {
+		set.SecuritySMIMEKey = cur.SecuritySMIMEKey
+	}
 	if set.OIDC.ClientSecret == "" {
 		set.OIDC.ClientSecret = cur.OIDC.ClientSecret
 	}
+	if set.Stripe.SecretKey == "" {
+		set.Stripe.SecretKey = cur.Stripe.SecretKey
+	}
+	if set.Stripe.WebhookSecret == "" {
+		set.Stripe.WebhookSecret = cur.Stripe.WebhookSecret
+	}
+
+	// The webhook grants paid subscriptions and can't be verified without a secret.
+	if set.Stripe.Enabled && set.Stripe.WebhookSecret == "" {
+		addErr("billing.stripe", app.i18n.Ts("globals.messages.invalidFields", "name", "billing.stripe.webhook_secret"))
+	}
 
 	for n, v := range set.UploadExtensions {
 		set.UploadExtensions[n] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))
@@ -225,37 +547,281 @@ func handleUpdateSettings(c echo.Context) error {
 	}
 	set.DomainBlocklist = doms
 
+	// Role account local parts and e-mail deny patterns.
+	roles := make([]string, 0)
+	for _, r := range set.PrivacyRoleAccounts {
+		r = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(r)), "@")
+		if r != "" {
+			roles = append(roles, r)
+		}
+	}
+	set.PrivacyRoleAccounts = roles
+
+	pats := make([]string, 0)
+	for _, p := range set.PrivacyEmailDenyPatterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := regexp.Compile(p); err != nil {
+			addErr("privacy.email_deny_patterns", app.i18n.Ts("globals.messages.invalidFields", "name", "privacy.email_deny_patterns: "+p))
+			continue
+		}
+		pats = append(pats, p)
+	}
+	set.PrivacyEmailDenyPatterns = pats
+
+	// S/MIME signing certificate and key.
+	if set.SecuritySMIMESign {
+		if _, err := mailcrypt.NewSigner(set.SecuritySMIMECert, set.SecuritySMIMEKey); err != nil {
+			addErr("security.smime_cert", app.i18n.Ts("settings.security.invalidSMIME", "error", err.Error()))
+		}
+	}
+
+	// Sanitization of subscriber data in templates.
+	if set.SecuritySanitizeMode != sanitize.ModeStrip && set.SecuritySanitizeMode != sanitize.ModeEscape {
+		addErr("security.sanitize_mode", app.i18n.Ts("globals.messages.invalidFields", "name", "security.sanitize_mode"))
+	}
+	if set.SecuritySanitizeMaxNameLen < 0 {
+		addErr("security.sanitize_max_name_len", app.i18n.Ts("globals.messages.invalidFields", "name", "security.sanitize_max_name_len"))
+	}
+	if set.SecuritySanitizeMaxAttribLen < 0 {
+		addErr("security.sanitize_max_attrib_len", app.i18n.Ts("globals.messages.invalidFields", "name", "security.sanitize_max_attrib_len"))
+	}
+
+	attribKeys := make([]string, 0)
+	for _, k := range set.SecuritySanitizeAllowedAttribs {
+		if k = strings.TrimSpace(k); k != "" {
+			attribKeys = append(attribKeys, k)
+		}
+	}
+	set.SecuritySanitizeAllowedAttribs = attribKeys
+
+	raw := make([]string, 0)
+	for _, f := range set.SecuritySanitizeRawFields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if f != sanitize.FieldName && (!strings.HasPrefix(f, "attribs.") || len(f) == len("attribs.")) {
+			addErr("security.sanitize_raw_fields", app.i18n.Ts("globals.messages.invalidFields", "name", "security.sanitize_raw_fields: "+f))
+			continue
+		}
+		raw = append(raw, f)
+	}
+	set.SecuritySanitizeRawFields = raw
+
+	// Template function allowlist and rendering limits.
+	funcs := make([]string, 0)
+	for _, f := range set.SecurityTemplateFuncs {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !manager.IsTemplateFunc(f) {
+			addErr("security.template_funcs", app.i18n.Ts("globals.messages.invalidFields", "name", "security.template_funcs: "+f))
+			continue
+		}
+		funcs = append(funcs, f)
+	}
+	set.SecurityTemplateFuncs = funcs
+
+	// 0 disables the render timeout.
+	set.SecurityTemplateRenderTimeout = strings.TrimSpace(set.SecurityTemplateRenderTimeout)
+	if set.SecurityTemplateRenderTimeout == "" {
+		set.SecurityTemplateRenderTimeout = "0"
+	}
+	if d, err := time.ParseDuration(set.SecurityTemplateRenderTimeout); err != nil || d < 0 {
+		addErr("security.template_render_timeout", app.i18n.Ts("globals.messages.invalidFields", "name", "security.template_render_timeout"))
+	}
+	if set.SecurityTemplateMaxSize < 0 {
+		addErr("security.template_max_size", app.i18n.Ts("globals.messages.invalidFields", "name", "security.template_max_size"))
+	}
+
+	// Bot click IP ranges.
+	for _, ip := range set.PrivacyBotClickIPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			addErr("privacy.bot_click_ips", app.i18n.Ts("globals.messages.invalidFields", "name", "privacy.bot_click_ips: "+ip))
+		}
+	}
+
+	// 0 doesn't expire the signed unsubscribe and opt-in links.
+	set.PrivacySignedLinkExpiry = strings.TrimSpace(set.PrivacySignedLinkExpiry)
+	if set.PrivacySignedLinkExpiry == "" {
+		set.PrivacySignedLinkExpiry = "0"
+	}
+	if d, err := time.ParseDuration(set.PrivacySignedLinkExpiry); err != nil || d < 0 {
+		addErr("privacy.signed_link_expiry", app.i18n.Ts("globals.messages.invalidFields", "name", "privacy.signed_link_expiry"))
+	}
+
+	// The default timezone of campaign schedules should be a valid IANA timezone.
+	if set.DefaultTimezone == "" {
+		set.DefaultTimezone = "UTC"
+	}
+	if _, err := time.LoadLocation(set.DefaultTimezone); err != nil {
+		addErr("app.default_timezone", app.i18n.Ts("globals.messages.invalidFields", "name", "app.default_timezone"))
+	}
+
+	// 0 disables automatic purging of the trash.
+	if set.TrashRetentionDays < 0 {
+		set.TrashRetentionDays = 0
+	}
+
+	// 0 disables retries of campaigns to soft-bounced recipients.
+	if set.BounceRetryMaxAttempts < 0 {
+		set.BounceRetryMaxAttempts = 0
+	}
+
+	// Public template overrides should compile. Duplicates and empty bodies are dropped.
+	tpls := make([]models.PublicTemplate, 0, len(set.PublicTemplates))
+	seen := map[string]bool{}
+	for _, t := range set.PublicTemplates {
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" || seen[t.Name] || strings.TrimSpace(t.Body) == "" {
+			continue
+		}
+		seen[t.Name] = true
+		tpls = append(tpls, t)
+	}
+	if _, err := compilePublicTpls(app.i18n, tpls, app); err != nil {
+		addErr("appearance.public.templates", app.i18n.Ts("settings.appearance.invalidTemplate", "error", err.Error()))
+	}
+	set.PublicTemplates = tpls
+
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
+			addErr("app.cache_slow_queries_interval", app.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
 		}
 	}
 
-	// Update the settings in the DB.
-	if err := app.core.UpdateSettings(set); err != nil {
+	return set, errs
+}
+
+// validateSMTPSettings validates the SMTP server at index i of the settings.
+func validateSMTPSettings(i int, set models.Settings, addErr func(string, string), checkDuration func(string, string), app *App) {
+	var (
+		s      = set.SMTP[i]
+		prefix = fmt.Sprintf("smtp.%d.", i)
+	)
+
+	if s.Host == "" || strings.ContainsAny(s.Host, " /:") {
+		addErr(prefix+"host", app.i18n.Ts("globals.messages.invalidFields", "name", "host"))
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		addErr(prefix+"port", app.i18n.Ts("globals.messages.invalidFields", "name", "port"))
+	}
+
+	switch s.AuthProtocol {
+	case "", "none", "cram", "plain", "login":
+	default:
+		addErr(prefix+"auth_protocol", app.i18n.Ts("globals.messages.invalidFields", "name", "auth_protocol"))
+	}
+
+	switch s.TLSType {
+	case "none", "STARTTLS", "TLS":
+	default:
+		addErr(prefix+"tls_type", app.i18n.Ts("globals.messages.invalidFields", "name", "tls_type"))
+	}
+
+	if s.MaxConns < 1 {
+		addErr(prefix+"max_conns", app.i18n.Ts("globals.messages.invalidFields", "name", "max_conns"))
+	}
+	if s.MaxMsgRetries < 0 {
+		addErr(prefix+"max_msg_retries", app.i18n.Ts("globals.messages.invalidFields", "name", "max_msg_retries"))
+	}
+	checkDuration(prefix+"idle_timeout", s.IdleTimeout)
+	checkDuration(prefix+"wait_timeout", s.WaitTimeout)
+}
+
+// isSettingsURL checks whether s is an absolute http(s) URL.
+func isSettingsURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// httpErrMsg returns the message of an HTTP error.
+func httpErrMsg(err error) string {
+	if e, ok := err.(*echo.HTTPError); ok {
+		return fmt.Sprintf("%v", e.Message)
+	}
+	return err.Error()
+}
+
+// handlePreviewPublicPage renders a public page with unsaved appearance
+// settings (custom CSS and template overrides) and returns its HTML for a
+// live preview.
+func handlePreviewPublicPage(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		req struct {
+			Page      string                  `json:"page"`
+			CustomCSS string                  `json:"custom_css"`
+			Templates []models.PublicTemplate `json:"templates"`
+		}
+	)
+
+	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
-	// If there are any active campaigns, don't do an auto reload and
-	// warn the user on the frontend.
-	if app.manager.HasRunningCampaigns() {
-		app.Lock()
-		app.needsRestart = true
-		app.Unlock()
+	tpl, err := compilePublicTpls(app.i18n, req.Templates, app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("settings.appearance.invalidTemplate", "error", err.Error()))
+	}
 
-		return c.JSON(http.StatusOK, okResp{struct {
-			NeedsRestart bool `json:"needs_restart"`
-		}{true}})
+	// Sample data for the previewable pages.
+	var data interface{}
+	switch req.Page {
+	case "home":
+		data = publicTpl{Title: "listmonk"}
+	case tplMessage:
+		data = makeMsgTpl(app.i18n.T("public.subConfirmedTitle"), "", app.i18n.T("public.subConfirmed"))
+	case "subscription-form", "optin":
+		lists, err := app.core.GetLists(models.ListTypePublic, true, nil)
+		if err != nil {
+			return err
+		}
+		if req.Page == "optin" {
+			out := optinTpl{SubUUID: dummyUUID, Lists: lists}
+			out.Title = app.i18n.T("public.confirmOptinSubTitle")
+			data = out
+		} else {
+			out := subFormTpl{Lists: lists}
+			out.Title = app.i18n.T("public.sub")
+			data = out
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "page"))
 	}
 
-	// No running campaigns. Reload the app.
-	go func() {
-		<-time.After(time.Millisecond * 500)
-		app.chReload <- syscall.SIGHUP
-	}()
+	var b bytes.Buffer
+	if err := tpl.ExecuteTemplate(&b, req.Page, tplData{
+		SiteName:            app.constants.SiteName,
+		RootURL:             app.constants.RootURL,
+		LogoURL:             app.constants.LogoURL,
+		FaviconURL:          app.constants.FaviconURL,
+		AssetVersion:        app.constants.AssetVersion,
+		EnablePublicSubPage: app.constants.EnablePublicSubPage,
+		EnablePublicArchive: app.constants.EnablePublicArchive,
+		IndividualTracking:  app.constants.Privacy.IndividualTracking,
+		Data:                data,
+		L:                   app.i18n,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("settings.appearance.invalidTemplate", "error", err.Error()))
+	}
 
-	return c.JSON(http.StatusOK, okResp{true})
+	// Inline the unsaved CSS after the saved custom CSS.
+	out := b.String()
+	if req.CustomCSS != "" {
+		css := "<style>" + strings.ReplaceAll(req.CustomCSS, "</", "<\\/") + "</style>\n</head>"
+		out = strings.Replace(out, "</head>", css, 1)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // handleGetLogs returns the log entries stored in the log buffer.
@@ -264,7 +830,8 @@ func handleGetLogs(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{app.bufLog.Lines()})
 }
 
-// handleTestSMTPSettings returns the log entries stored in the log buffer.
+// handleTestSMTPSettings runs a connection diagnostic against the given SMTP
+// server settings and optionally sends a test message.
 func handleTestSMTPSettings(c echo.Context) error {
 	app := c.Get("app").(*App)
 
@@ -288,38 +855,43 @@ func handleTestSMTPSettings(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.internalError"))
 	}
 
-	to := ko.String("email")
-	if to == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.missingFields", "name", "email"))
-	}
+	// Optional recipient of a test message.
+	m := models.Message{}
+	if to := ko.String("email"); to != "" {
+		var b bytes.Buffer
+		if err := app.notifTpls.tpls.ExecuteTemplate(&b, "smtp-test", nil); err != nil {
+			app.log.Printf("error compiling notification template '%s': %v", "smtp-test", err)
+			return err
+		}
 
-	// Initialize a new SMTP pool.
-	req.MaxConns = 1
-	req.IdleTimeout = time.Second * 2
-	req.PoolWaitTimeout = time.Second * 2
-	msgr, err := email.New(req)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			app.i18n.Ts("globals.messages.errorCreating", "name", "SMTP", "error", err.Error()))
+		m.ContentType = app.notifTpls.contentType
+		m.From = app.constants.FromEmail
+		m.To = []string{to}
+		m.Subject = app.i18n.T("settings.smtp.testConnection")
+		m.Body = b.Bytes()
 	}
 
-	var b bytes.Buffer
-	if err := app.notifTpls.tpls.ExecuteTemplate(&b, "smtp-test", nil); err != nil {
-		app.log.Printf("error compiling notification template '%s': %v", "smtp-test", err)
-		return err
+	// Connect to the server and run through EHLO, STARTTLS, auth, and the optional send.
+	out := email.Diagnose(req, m)
+	if !out.OK {
+		app.log.Printf("SMTP test (%s:%d) failed: %s", out.Host, out.Port, out.Error)
 	}
 
-	m := models.Message{}
-	m.ContentType = app.notifTpls.contentType
-	m.From = app.constants.FromEmail
-	m.To = []string{to}
-	m.Subject = app.i18n.T("settings.smtp.testConnection")
-	m.Body = b.Bytes()
-	if err := msgr.Push(m); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetMXStats returns the per-domain delivery stats of direct MX delivery.
+func handleGetMXStats(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out := []email.MXDomainStats{}
+	if e, ok := app.messengers[emailMsgr].(*email.Emailer); ok {
+		if s := e.MXStats(); s != nil {
+			out = s
+		}
 	}
 
-	return c.JSON(http.StatusOK, okResp{app.bufLog.Lines()})
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 func handleGetAboutInfo(c echo.Context) error {
@@ -334,5 +906,56 @@ func handleGetAboutInfo(c echo.Context) error {
 	out.System.AllocMB = mem.Alloc / 1024 / 1024
 	out.System.OSMB = mem.Sys / 1024 / 1024
 
+	// Live connection pool stats.
+	st := app.db.Stats()
+	out.DBPool.Open = st.OpenConnections
+	out.DBPool.InUse = st.InUse
+	out.DBPool.Idle = st.Idle
+	out.DBPool.WaitCount = st.WaitCount
+	out.DBPool.WaitDuration = st.WaitDuration.String()
+	out.DBPool.SlowQueries = queryLog.NumSlow()
+	out.Concurrency = app.manager.ConcurrencyStats()
+	out.Breakers = app.manager.BreakerStats()
+	out.IsLeader = app.isLeader() && !ko.Bool("passive")
+
 	return c.JSON(http.StatusOK, out)
 }
+
+// handleGetSendingHalt returns whether all sending has been halted.
+func handleGetSendingHalt(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Halted bool `json:"halted"`
+	}{app.manager.IsHalted()}})
+}
+
+// handleUpdateSendingHalt halts or resumes all campaign and transactional sending
+// (the emergency stop). The state is stored in the DB from where all the instances
+// that share it pick it up.
+func handleUpdateSendingHalt(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req struct {
+		Halted bool `json:"halted"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := app.core.SetSendingHalted(req.Halted); err != nil {
+		return err
+	}
+	app.manager.Halt(req.Halted)
+
+	msg := "events.sendingResumed"
+	if req.Halted {
+		msg = "events.sendingHalted"
+	}
+	app.core.RecordEvent(models.EventLogSettings, app.i18n.T(msg), models.JSON{"halted": req.Halted}, user.ID)
+
+	return handleGetSendingHalt(c)
+}
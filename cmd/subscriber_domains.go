@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	domainActionBlocklist = "blocklist"
+	domainActionDelete    = "delete"
+
+	domainJobRunning  = "running"
+	domainJobFinished = "finished"
+	domainJobFailed   = "failed"
+
+	// Maximum number of domains in a single request.
+	maxDomainJobDomains = 100
+
+	// Number of subscribers blocklisted or deleted in a single query.
+	domainJobBatchSize = 5000
+)
+
+// domainJob is a background job that blocklists or deletes all the
+// subscribers under one or more e-mail domains.
+type domainJob struct {
+	Action     string     `json:"action"`
+	Domains    []string   `json:"domains"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// subDomainReq is a request to blocklist or delete subscribers by domain.
+type subDomainReq struct {
+	Domains []string `json:"domains"`
+	Action  string   `json:"action"`
+
+	// A dry run returns the subscriber counts and the token that confirms them.
+	DryRun bool   `json:"dry_run"`
+	Token  string `json:"token"`
+}
+
+// handleSubscribersByDomain blocklists or deletes all the subscribers under the
+// given e-mail domains (and their subdomains) in a background job. The job
+// has to be confirmed with the token returned by a dry run, which counts the
+// subscribers that'll be affected without changing anything.
+func handleSubscribersByDomain(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		req  subDomainReq
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.Action != domainActionBlocklist && req.Action != domainActionDelete {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "action"))
+	}
+
+	domains, err := cleanDomains(req.Domains)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", err.Error()))
+	}
+
+	counts, err := app.core.CountSubscribersByDomain(domains, req.Action)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+
+	// The token confirms the action on the domains with the counts that
+	// the dry run returned. If the counts change, a new dry run is required.
+	token := domainJobToken(req.Action, domains, total)
+	if req.DryRun {
+		return c.JSON(http.StatusOK, okResp{struct {
+			Domains []models.SubscriberDomainCount `json:"domains"`
+			Total   int                            `json:"total"`
+			Token   string                         `json:"token"`
+		}{counts, total, token}})
+	}
+
+	if req.Token != token {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.domainJobConfirm"))
+	}
+
+	app.Lock()
+	if app.domainJob != nil && app.domainJob.Status == domainJobRunning {
+		app.Unlock()
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.domainJobRunning"))
+	}
+	job := &domainJob{
+		Action:    req.Action,
+		Domains:   domains,
+		Status:    domainJobRunning,
+		Total:     total,
+		StartedAt: time.Now(),
+	}
+	app.domainJob = job
+	app.Unlock()
+
+	go app.runDomainJob(job, user.ID)
+
+	return c.JSON(http.StatusOK, okResp{app.getDomainJob()})
+}
+
+// handleGetSubscribersDomainJob returns the status of the current (or last)
+// domain blocklist/delete job.
+func handleGetSubscribersDomainJob(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	return c.JSON(http.StatusOK, okResp{app.getDomainJob()})
+}
+
+// runDomainJob blocklists or deletes the subscribers of a domain job in batches.
+func (app *App) runDomainJob(job *domainJob, userID int) {
+	app.log.Printf("%s subscribers under domains: %s", job.Action, strings.Join(job.Domains, ", "))
+
+	var err error
+	for {
+		var n int
+		if job.Action == domainActionDelete {
+			n, err = app.core.DeleteSubscribersByDomain(job.Domains, domainJobBatchSize)
+		} else {
+			n, err = app.core.BlocklistSubscribersByDomain(job.Domains, domainJobBatchSize)
+		}
+		if err != nil || n == 0 {
+			break
+		}
+
+		app.Lock()
+		job.Done += n
+		app.Unlock()
+	}
+
+	now := time.Now()
+	app.Lock()
+	job.FinishedAt = &now
+	job.Status = domainJobFinished
+	if err != nil {
+		job.Status = domainJobFailed
+		job.Error = err.Error()
+	}
+	done := job.Done
+	app.Unlock()
+
+	if err != nil {
+		app.log.Printf("error in subscriber domain job: %v", err)
+	}
+	app.log.Printf("%s subscribers under domains finished: %d subscribers", job.Action, done)
+
+	app.core.RecordEvent(models.EventLogSubscribers,
+		app.i18n.Ts("events.domainJob", "action", job.Action, "num", fmt.Sprintf("%d", done), "domains", strings.Join(job.Domains, ", ")),
+		models.JSON{"action": job.Action, "domains": job.Domains, "count": done, "error": job.Error}, userID)
+}
+
+// getDomainJob returns a copy of the current domain job.
+func (app *App) getDomainJob() *domainJob {
+	app.Lock()
+	defer app.Unlock()
+
+	if app.domainJob == nil {
+		return nil
+	}
+
+	out := *app.domainJob
+	return &out
+}
+
+// cleanDomains validates, lowercases, and dedupes a list of domains.
+func cleanDomains(domains []string) ([]string, error) {
+	if len(domains) == 0 || len(domains) > maxDomainJobDomains {
+		return nil, fmt.Errorf("domains")
+	}
+
+	var (
+		out  = make([]string, 0, len(domains))
+		seen = make(map[string]bool, len(domains))
+	)
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(d), "@")))
+		if len(d) > 253 || !reHostname.MatchString(d) {
+			return nil, fmt.Errorf("%s", d)
+		}
+
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// domainJobToken returns the confirmation token of a domain job.
+func domainJobToken(action string, domains []string, total int) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", action, strings.Join(domains, ","), total)))
+	return hex.EncodeToString(h[:8])
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// handleGetSubscriberQueries returns the saved subscriber queries visible to the user.
+func handleGetSubscriberQueries(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	out, err := app.core.GetSubscriberQueries(user.ID, isSuperAdmin(user))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetSubscriberQuery returns a single saved subscriber query.
+func handleGetSubscriberQuery(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetSubscriberQuery(id, user.ID, isSuperAdmin(user))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateSubscriberQuery saves a new subscriber query.
+func handleCreateSubscriberQuery(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var o models.SubscriberQuery
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateSubscriberQuery(o, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.CreateSubscriberQuery(o, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleUpdateSubscriberQuery updates a saved subscriber query.
+func handleUpdateSubscriberQuery(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var o models.SubscriberQuery
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	if err := validateSubscriberQuery(o, app); err != nil {
+		return err
+	}
+
+	out, err := app.core.UpdateSubscriberQuery(id, o, user.ID, isSuperAdmin(user))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteSubscriberQuery deletes a saved subscriber query.
+func handleDeleteSubscriberQuery(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := app.core.DeleteSubscriberQuery(id, user.ID, isSuperAdmin(user)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getSavedSubscriberQuery returns the SQL expression of a saved subscriber query
+// that's visible to the given user. This is used by the various subscriber
+// query (listing, export, bulk actions) handlers that accept a `query_id`.
+func getSavedSubscriberQuery(id int, user models.User, app *App) (string, error) {
+	q, err := app.core.GetSubscriberQuery(id, user.ID, isSuperAdmin(user))
+	if err != nil {
+		return "", err
+	}
+
+	return q.Query, nil
+}
+
+// validateSubscriberQuery validates the fields of a saved subscriber query.
+func validateSubscriberQuery(o models.SubscriberQuery, app *App) error {
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscriberQueries.invalidName"))
+	}
+	if strings.TrimSpace(o.Query) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "query"))
+	}
+
+	return nil
+}
@@ -1,37 +1,71 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/mailcrypt"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/subfilter"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
 const (
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// Validity of opt-in confirmation codes.
+	optinCodeTTL = time.Minute * 15
+
+	// Maximum size of a subscriber's S/MIME certificate or PGP public key.
+	maxPublicKeyLen = 64 * 1024
+
+	// Validity of the links to admin previews of a subscriber's public pages.
+	subPreviewTTL = time.Minute * 15
+
+	// Maximum number of subscriptions that can be changed in a single request.
+	maxSubscriptionChanges = 1000
 )
 
 // subQueryReq is a "catch all" struct for reading various
 // subscriber related requests.
 type subQueryReq struct {
-	Query              string `json:"query"`
-	ListIDs            []int  `json:"list_ids"`
-	TargetListIDs      []int  `json:"target_list_ids"`
-	SubscriberIDs      []int  `json:"ids"`
-	Action             string `json:"action"`
-	Status             string `json:"status"`
-	SubscriptionStatus string `json:"subscription_status"`
-	All                bool   `json:"all"`
+	Query              string          `json:"query"`
+	QueryID            int             `json:"query_id"`
+	Filter             json.RawMessage `json:"filter"`
+	ListIDs            []int           `json:"list_ids"`
+	TargetListIDs      []int           `json:"target_list_ids"`
+	SubscriberIDs      []int           `json:"ids"`
+	Action             string          `json:"action"`
+	Status             string          `json:"status"`
+	SubscriptionStatus string          `json:"subscription_status"`
+	All                bool            `json:"all"`
+}
+
+// subCountReq is the audience of a prospective campaign to be counted.
+type subCountReq struct {
+	ListIDs        []int           `json:"list_ids"`
+	ListGroupIDs   []int           `json:"list_group_ids"`
+	ExcludeListIDs []int           `json:"exclude_list_ids"`
+	Query          string          `json:"query"`
+	QueryID        int             `json:"query_id"`
+	Filter         json.RawMessage `json:"filter"`
+	Type           string          `json:"type"`
+	Exact          bool            `json:"exact"`
 }
 
 // subProfileData represents a subscriber's collated data in JSON
@@ -51,6 +85,9 @@ type subOptin struct {
 	OptinURL string
 	UnsubURL string
 	Lists    []models.List
+
+	// Code is the opt-in confirmation code for lists that are confirmed with a code.
+	Code string
 }
 
 var (
@@ -97,30 +134,99 @@ func handleQuerySubscribers(c echo.Context) error {
 		user = c.Get(auth.UserKey).(models.User)
 		pg   = app.paginator.NewFromURL(c.Request().URL.Query())
 
-		// The "WHERE ?" bit.
-		query     = sanitizeSQLExp(c.FormValue("query"))
 		subStatus = c.FormValue("subscription_status")
 		orderBy   = c.FormValue("order_by")
 		order     = c.FormValue("order")
 		out       models.PageResults
 	)
 
+	// The "WHERE ?" bit.
+	query, err := makeSubQueryExp(c.FormValue("query"), []byte(c.FormValue("filter")), c.FormValue("query_id"), user, app)
+	if err != nil {
+		return err
+	}
+
 	// Filter list IDs by permission.
 	listIDs, err := filterListQeryByPerm(c.QueryParams(), user, app)
 	if err != nil {
 		return err
 	}
 
-	res, total, err := app.core.QuerySubscribers(query, listIDs, subStatus, order, orderBy, pg.Offset, pg.Limit)
+	// Optional tags filter.
+	exp := makeSubTagsExp(query, c.QueryParams()["tag"])
+
+	// Cursor pagination orders by ID.
+	cur, err := getPageCursor(c, app)
+	if err != nil {
+		return err
+	}
+	offset := pg.Offset
+	if cur.enabled {
+		orderBy, offset = "subscribers.id", 0
+	}
+
+	res, total, err := app.core.QuerySubscribers(exp, listIDs, subStatus, order, orderBy, cur.afterID, offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// Only the requested fields.
+	results, err := sparseFields(res, getFieldsParam(c))
 	if err != nil {
 		return err
 	}
 
 	out.Query = query
-	out.Results = res
+	out.Results = results
 	out.Total = total
 	out.Page = pg.Page
 	out.PerPage = pg.PerPage
+	if len(res) > 0 {
+		out.NextCursor = cur.next(len(res), pg.Limit, res[len(res)-1].ID)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCountSubscribers returns the number of recipients that a campaign sent
+// to a set of lists, list groups, and an optional segment, minus the
+// subscribers on the excluded lists, would have. Large audiences are estimated
+// unless an exact count is requested.
+func handleCountSubscribers(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		req  subCountReq
+	)
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.Type == "" {
+		req.Type = models.CampaignTypeRegular
+	}
+	if req.Type != models.CampaignTypeRegular && req.Type != models.CampaignTypeOptin {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	// The segment.
+	query, err := makeSubQueryExp(req.Query, req.Filter, strconv.Itoa(req.QueryID), user, app)
+	if err != nil {
+		return err
+	}
+
+	// Filter list IDs by permission.
+	listIDs := user.FilterListsByPerm(req.ListIDs, true, true)
+	excludeIDs := user.FilterListsByPerm(req.ExcludeListIDs, true, true)
+	if len(listIDs) == 0 && len(req.ListGroupIDs) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.AudienceCount{}})
+	}
+
+	out, err := app.core.CountAudience(listIDs, req.ListGroupIDs, excludeIDs, req.Type, query, req.Exact)
+	if err != nil {
+		return err
+	}
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -130,11 +236,14 @@ func handleExportSubscribers(c echo.Context) error {
 	var (
 		app  = c.Get("app").(*App)
 		user = c.Get(auth.UserKey).(models.User)
-
-		// The "WHERE ?" bit.
-		query = sanitizeSQLExp(c.FormValue("query"))
 	)
 
+	// The "WHERE ?" bit.
+	query, err := makeSubQueryExp(c.FormValue("query"), []byte(c.FormValue("filter")), c.FormValue("query_id"), user, app)
+	if err != nil {
+		return err
+	}
+
 	// Filter list IDs by permission.
 	listIDs, err := filterListQeryByPerm(c.QueryParams(), user, app)
 	if err != nil {
@@ -151,7 +260,7 @@ func handleExportSubscribers(c echo.Context) error {
 	subStatus := c.QueryParam("subscription_status")
 
 	// Get the batched export iterator.
-	exp, err := app.core.ExportSubscribers(query, subIDs, listIDs, subStatus, app.constants.DBBatchSize)
+	exp, err := app.core.ExportSubscribers(makeSubTagsExp(query, c.QueryParams()["tag"]), subIDs, listIDs, subStatus, app.constants.DBBatchSize)
 	if err != nil {
 		return err
 	}
@@ -213,16 +322,26 @@ func handleCreateSubscriber(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if len(req.Lang) > 6 || reLangCode.MatchString(req.Lang) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidLang"))
+	}
 
 	// Filter lists against the current user's permitted lists.
 	listIDs := user.FilterListsByPerm(req.Lists, false, true)
 
 	// Insert the subscriber into the DB.
+	req.Source = makeSubSource(user)
 	sub, _, err := app.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs)
 	if err != nil {
 		return err
 	}
 
+	if req.Consent != nil {
+		if err := app.core.RecordConsent(sub.ID, listIDs, nil, makeAPIConsent(*req.Consent, user, app)); err != nil {
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, okResp{sub})
 }
 
@@ -235,8 +354,9 @@ func handleUpdateSubscriber(c echo.Context) error {
 		id, _ = strconv.Atoi(c.Param("id"))
 		req   struct {
 			models.Subscriber
-			Lists          []int `json:"lists"`
-			PreconfirmSubs bool  `json:"preconfirm_subscriptions"`
+			Lists          []int           `json:"lists"`
+			PreconfirmSubs bool            `json:"preconfirm_subscriptions"`
+			Consent        *models.Consent `json:"consent"`
 		}
 	)
 
@@ -258,15 +378,25 @@ func handleUpdateSubscriber(c echo.Context) error {
 	if req.Name != "" && !strHasLen(req.Name, 1, stdInputMaxLen) {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.invalidName"))
 	}
+	if len(req.Lang) > 6 || reLangCode.MatchString(req.Lang) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("lists.invalidLang"))
+	}
 
 	// Filter lists against the current user's permitted lists.
 	listIDs := user.FilterListsByPerm(req.Lists, false, true)
 
+	req.Source = makeSubSource(user)
 	out, _, err := app.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true)
 	if err != nil {
 		return err
 	}
 
+	if req.Consent != nil {
+		if err := app.core.RecordConsent(id, listIDs, nil, makeAPIConsent(*req.Consent, user, app)); err != nil {
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -294,6 +424,166 @@ func handleSubscriberSendOptin(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// handleUpdateSubscriberPublicKey sets or removes the S/MIME certificate or
+// OpenPGP public key that e-mails to a subscriber are encrypted with.
+func handleUpdateSubscriberPublicKey(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := hasSubPerm(user, []int{id}, app); err != nil {
+		return err
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.PublicKey = strings.TrimSpace(req.PublicKey)
+	if len(req.PublicKey) > maxPublicKeyLen {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("subscribers.invalidPublicKey", "error", "too big"))
+	}
+	if req.PublicKey != "" {
+		key, err := mailcrypt.ParseKey(req.PublicKey)
+		if err == nil {
+			err = key.Check()
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				app.i18n.Ts("subscribers.invalidPublicKey", "error", err.Error()))
+		}
+	}
+
+	out, err := app.core.UpdateSubscriberPublicKey(id, req.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleCreateSubscriberPreview generates a temporary link to a read-only
+// preview of a subscriber's preferences page, or of a campaign's hosted view
+// as rendered for them, so that support can see what the subscriber sees
+// without the subscriber's own links.
+func handleCreateSubscriberPreview(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := hasSubPerm(user, []int{id}, app); err != nil {
+		return err
+	}
+
+	var req struct {
+		CampaignID int `json:"campaign_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if _, err := app.core.GetSubscriber(id, "", ""); err != nil {
+		return err
+	}
+
+	// The token gives access to the campaign's message, so the user should
+	// have access to the campaign as on the campaign APIs.
+	campUUID := ""
+	if req.CampaignID > 0 {
+		if !isSuperAdmin(user) && !user.HasPerm(models.PermCampaignsGet) {
+			return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", models.PermCampaignsGet))
+		}
+		if err := hasCampaignPerm(user, []int{req.CampaignID}, false, app); err != nil {
+			return err
+		}
+
+		camp, err := app.core.GetCampaign(req.CampaignID, "", "")
+		if err != nil {
+			return err
+		}
+		campUUID = camp.UUID
+	}
+
+	q := url.Values{}
+	q.Set("t", app.manager.TempLinkToken(manager.LinkPreview, previewKey(id, campUUID), subPreviewTTL))
+	if campUUID != "" {
+		q.Set("campaign", campUUID)
+	}
+
+	out := struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		URL:       fmt.Sprintf("%s/subscription/preview/%d?%s", app.constants.RootURL, id, q.Encode()),
+		ExpiresAt: time.Now().Add(subPreviewTTL),
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRotateSubscriberUUID replaces a subscriber's UUID, eg: after a forwarded
+// e-mail exposed their unsubscribe link. Links in the e-mails sent to them
+// before the rotation stop working.
+func handleRotateSubscriberUUID(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := hasSubPerm(user, []int{id}, app); err != nil {
+		return err
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if !strHasLen(req.Reason, 0, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "reason"))
+	}
+
+	old, err := app.core.GetSubscriber(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.RotateSubscriberUUID(id, req.Reason, user.ID)
+	if err != nil {
+		return err
+	}
+
+	app.core.RecordEvent(models.EventLogSubscribers,
+		app.i18n.Ts("events.subscriberUUIDRotated", "email", out.Email),
+		models.JSON{"subscriber_id": out.ID, "old_uuid": old.UUID, "uuid": out.UUID, "reason": req.Reason}, user.ID)
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handleBlocklistSubscribers handles the blocklisting of one or more subscribers.
 // It takes either an ID in the URI, or a list of IDs in the request body.
 func handleBlocklistSubscribers(c echo.Context) error {
@@ -376,7 +666,7 @@ func handleManageSubscriberLists(c echo.Context) error {
 	var err error
 	switch req.Action {
 	case "add":
-		err = app.core.AddSubscriptions(subIDs, listIDs, req.Status)
+		err = app.core.AddSubscriptions(subIDs, listIDs, req.Status, makeSubSource(user))
 	case "remove":
 		err = app.core.DeleteSubscriptions(subIDs, listIDs)
 	case "unsubscribe":
@@ -392,6 +682,105 @@ func handleManageSubscriberLists(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// handleSetSubscriptionStatuses sets the statuses of a subscriber's subscriptions
+// to individual lists, each with an optional reason code and note. Subscriptions
+// that don't exist are created. Every change is pushed to the notification
+// channels that subscribe to subscription events.
+func handleSetSubscriptionStatuses(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		user  = c.Get(auth.UserKey).(models.User)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	var req struct {
+		Subscriptions []models.SubscriptionChange `json:"subscriptions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.Subscriptions) == 0 || len(req.Subscriptions) > maxSubscriptionChanges {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "subscriptions"))
+	}
+
+	// Validate the changes.
+	var (
+		listIDs = make([]int, 0, len(req.Subscriptions))
+		seen    = make(map[int]bool, len(req.Subscriptions))
+	)
+	for i, s := range req.Subscriptions {
+		if s.ListID < 1 || seen[s.ListID] {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "list_id"))
+		}
+		seen[s.ListID] = true
+
+		switch s.Status {
+		case models.SubscriptionStatusUnconfirmed, models.SubscriptionStatusConfirmed, models.SubscriptionStatusUnsubscribed:
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+		}
+
+		s.Reason = strings.ToLower(strings.TrimSpace(s.Reason))
+		if s.Reason != "" && !reReasonCode.MatchString(s.Reason) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "reason"))
+		}
+
+		s.Note = strings.TrimSpace(s.Note)
+		if !strHasLen(s.Note, 0, stdInputMaxLen) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "note"))
+		}
+
+		req.Subscriptions[i] = s
+		listIDs = append(listIDs, s.ListID)
+	}
+
+	// The user should be able to manage the subscriber and all the lists.
+	if err := hasSubPerm(user, []int{id}, app); err != nil {
+		return err
+	}
+	if ids := user.FilterListsByPerm(listIDs, false, true); len(ids) != len(listIDs) {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	sub, err := app.core.GetSubscriber(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Blocklisted subscribers can only be unsubscribed.
+	if sub.Status == models.SubscriberStatusBlockListed {
+		for _, s := range req.Subscriptions {
+			if s.Status != models.SubscriptionStatusUnsubscribed {
+				return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("subscribers.errorBlocklistedSubscribe"))
+			}
+		}
+	}
+
+	out, err := app.core.SetSubscriptionStatuses(id, req.Subscriptions, user.ID, makeSubSource(user))
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range out {
+		app.notify(notifs.Notif{
+			Event: notifs.EventSubscription,
+			Subject: app.i18n.Ts("subscribers.subscriptionChanged",
+				"email", sub.Email, "list", ch.ListName, "status", ch.Status),
+			Data: models.JSON{
+				"subscriber":   models.JSON{"id": sub.ID, "uuid": sub.UUID, "email": sub.Email},
+				"subscription": ch,
+				"user":         user.Username,
+			},
+		})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // handleDeleteSubscribers handles subscriber deletion.
 // It takes either an ID in the URI, or a list of IDs in the request body.
 func handleDeleteSubscribers(c echo.Context) error {
@@ -433,14 +822,22 @@ func handleDeleteSubscribers(c echo.Context) error {
 // arbitrary SQL expression.
 func handleDeleteSubscribersByQuery(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		req subQueryReq
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		req  subQueryReq
 	)
 
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
+	// The "WHERE ?" bit.
+	q, err := makeSubQueryExp(req.Query, req.Filter, strconv.Itoa(req.QueryID), user, app)
+	if err != nil {
+		return err
+	}
+	req.Query = q
+
 	if req.All {
 		req.Query = ""
 	} else if req.Query == "" {
@@ -458,14 +855,22 @@ func handleDeleteSubscribersByQuery(c echo.Context) error {
 // based on an arbitrary SQL expression.
 func handleBlocklistSubscribersByQuery(c echo.Context) error {
 	var (
-		app = c.Get("app").(*App)
-		req subQueryReq
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+		req  subQueryReq
 	)
 
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
+	// The "WHERE ?" bit.
+	q, err := makeSubQueryExp(req.Query, req.Filter, strconv.Itoa(req.QueryID), user, app)
+	if err != nil {
+		return err
+	}
+	req.Query = q
+
 	if req.Query == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "query"))
 	}
@@ -495,15 +900,21 @@ func handleManageSubscriberListsByQuery(c echo.Context) error {
 			app.i18n.T("subscribers.errorNoListsGiven"))
 	}
 
+	// The "WHERE ?" bit.
+	q, err := makeSubQueryExp(req.Query, req.Filter, strconv.Itoa(req.QueryID), user, app)
+	if err != nil {
+		return err
+	}
+	req.Query = q
+
 	// Filter lists against the current user's permitted lists.
 	sourceListIDs := user.FilterListsByPerm(req.ListIDs, false, true)
 	targetListIDs := user.FilterListsByPerm(req.TargetListIDs, false, true)
 
 	// Action.
-	var err error
 	switch req.Action {
 	case "add":
-		err = app.core.AddSubscriptionsByQuery(req.Query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus)
+		err = app.core.AddSubscriptionsByQuery(req.Query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus, makeSubSource(user))
 	case "remove":
 		err = app.core.DeleteSubscriptionsByQuery(req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
 	case "unsubscribe":
@@ -603,6 +1014,38 @@ func exportSubscriberData(id int, subUUID string, exportables map[string]bool, a
 	return data, b, nil
 }
 
+// makeSubQueryExp returns the subscriber query expression for a request. A saved
+// query (queryID) takes precedence over a structured filter, which takes precedence
+// over a raw SQL expression. Raw SQL expressions require the subscribers:sql_query
+// permission while structured filters are compiled into safe SQL and are open to all.
+func makeSubQueryExp(query string, filter []byte, queryID string, user models.User, app *App) (string, error) {
+	// Use a saved subscriber query?
+	if id, _ := strconv.Atoi(queryID); id > 0 {
+		return getSavedSubscriberQuery(id, user, app)
+	}
+
+	// Structured filter.
+	if f := strings.TrimSpace(string(filter)); f != "" && f != "null" {
+		fl, err := subfilter.Parse([]byte(f))
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("subscribers.invalidFilter", "error", err.Error()))
+		}
+
+		exp, err := fl.Compile()
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("subscribers.invalidFilter", "error", err.Error()))
+		}
+		return exp, nil
+	}
+
+	query = sanitizeSQLExp(query)
+	if query != "" && !isSuperAdmin(user) && !user.HasPerm(models.PermSubscribersSqlQuery) {
+		return "", echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", "subscribers:sql_query"))
+	}
+
+	return query, nil
+}
+
 // sanitizeSQLExp does basic sanitisation on arbitrary
 // SQL query expressions coming from the frontend.
 func sanitizeSQLExp(q string) string {
@@ -618,6 +1061,50 @@ func sanitizeSQLExp(q string) string {
 	return q
 }
 
+// makeSubSource returns the provenance recorded on subscribers and subscriptions
+// created by a user, eg: api:apiuser or admin:john.
+func makeSubSource(u models.User) string {
+	if u.Type == models.UserTypeAPI {
+		return models.SourceAPI + ":" + u.Username
+	}
+	return models.SourceAdmin + ":" + u.Username
+}
+
+// makeAPIConsent fills the blank fields of a proof-of-consent record that's
+// collected externally and sent along with a subscriber API request.
+func makeAPIConsent(cn models.Consent, u models.User, app *App) models.Consent {
+	if cn.Source == "" {
+		cn.Source = makeSubSource(u)
+	}
+	if cn.TextVersion == "" {
+		cn.TextVersion = app.constants.Privacy.ConsentVersion
+	}
+	if cn.Timestamp.IsZero() {
+		cn.Timestamp = time.Now()
+	}
+
+	return cn
+}
+
+// makeSubTagsExp appends a filter for the given subscriber tags to an arbitrary
+// subscriber query expression.
+func makeSubTagsExp(query string, tags []string) string {
+	if len(tags) == 0 {
+		return query
+	}
+
+	tg := make([]string, 0, len(tags))
+	for _, t := range tags {
+		tg = append(tg, pq.QuoteLiteral(t))
+	}
+	exp := fmt.Sprintf("subscribers.tags @> ARRAY[%s]::VARCHAR(100)[]", strings.Join(tg, ","))
+
+	if query == "" {
+		return exp
+	}
+	return "(" + query + ") AND " + exp
+}
+
 func getQueryInts(param string, qp url.Values) ([]int, error) {
 	var out []int
 	if vals, ok := qp[param]; ok {
@@ -651,18 +1138,45 @@ func sendOptinConfirmationHook(app *App) func(sub models.Subscriber, listIDs []i
 		if len(lists) == 0 {
 			return 0, nil
 		}
+		num := len(lists)
 
+		// Lists that are confirmed with a code instead of a link get a code.
 		var (
-			out      = subOptin{Subscriber: sub, Lists: lists}
+			linkLists = make([]models.List, 0, len(lists))
+			codeLists []models.List
+			sms       = false
+		)
+		for _, l := range lists {
+			switch l.OptinMethod {
+			case models.OptinMethodCode, models.OptinMethodSMS:
+				codeLists = append(codeLists, l)
+				sms = sms || l.OptinMethod == models.OptinMethodSMS
+			default:
+				linkLists = append(linkLists, l)
+			}
+		}
+		if len(codeLists) > 0 {
+			if err := sendOptinCode(sub, codeLists, sms, app); err != nil {
+				return 0, err
+			}
+		}
+		if len(linkLists) == 0 {
+			return num, nil
+		}
+		lists = linkLists
+
+		var (
+			out      = subOptin{Subscriber: app.manager.SanitizeSubscriber(sub), Lists: lists}
 			qListIDs = url.Values{}
 		)
 
-		// Construct the opt-in URL with list IDs.
+		// Construct the signed opt-in URL with list IDs.
 		for _, l := range out.Lists {
 			qListIDs.Add("l", l.UUID)
 		}
+		qListIDs.Set("t", app.manager.LinkToken(manager.LinkOptin, sub.UUID))
 		out.OptinURL = fmt.Sprintf(app.constants.OptinURL, sub.UUID, qListIDs.Encode())
-		out.UnsubURL = fmt.Sprintf(app.constants.UnsubURL, dummyUUID, sub.UUID)
+		out.UnsubURL = app.manager.UnsubURL(dummyUUID, sub.UUID)
 
 		// Unsub headers.
 		h := textproto.MIMEHeader{}
@@ -670,21 +1184,86 @@ func sendOptinConfirmationHook(app *App) func(sub models.Subscriber, listIDs []i
 
 		// Attach List-Unsubscribe headers?
 		if app.constants.Privacy.UnsubHeader {
-			unsubURL := fmt.Sprintf(app.constants.UnsubURL, dummyUUID, sub.UUID)
 			h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
-			h.Set("List-Unsubscribe", `<`+unsubURL+`>`)
+			h.Set("List-Unsubscribe", `<`+out.UnsubURL+`>`)
 		}
 
-		// Send the e-mail.
-		if err := app.sendNotification([]string{sub.Email}, app.i18n.T("subscribers.optinSubject"), notifSubscriberOptin, out, h); err != nil {
+		// Send the e-mail in the subscriber's language.
+		lang := app.getSubLang(sub, lists)
+		if err := app.sendLangNotification(lang, []string{sub.Email}, lang.i18n.T("subscribers.optinSubject"), notifSubscriberOptin, out, h); err != nil {
 			app.log.Printf("error sending opt-in e-mail for subscriber %d (%s): %s", sub.ID, sub.UUID, err)
 			return 0, err
 		}
 
-		return len(lists), nil
+		return num, nil
 	}
 }
 
+// sendOptinCode sends a subscriber a short-lived opt-in confirmation code for
+// lists that are confirmed with a code instead of a link. The code is sent via
+// the SMS messenger if any of the lists use SMS, and by e-mail otherwise.
+func sendOptinCode(sub models.Subscriber, lists []models.List, sms bool, app *App) error {
+	code, err := generateOptinCode()
+	if err != nil {
+		app.log.Printf("error generating opt-in code: %v", err)
+		return err
+	}
+
+	ids := make([]int, 0, len(lists))
+	for _, l := range lists {
+		ids = append(ids, l.ID)
+	}
+	if err := app.core.SetOptinCode(sub.ID, code, ids, optinCodeTTL); err != nil {
+		return err
+	}
+
+	lang := app.getSubLang(sub, lists)
+
+	if sms {
+		name := app.constants.Privacy.OptinSMSMessenger
+		if _, ok := app.messengers[name]; ok {
+			if err := app.manager.PushMessage(models.Message{
+				From:        app.constants.FromEmail,
+				To:          []string{sub.Email},
+				Subject:     lang.i18n.T("subscribers.optinSubject"),
+				ContentType: models.CampaignContentTypePlain,
+				Body:        []byte(lang.i18n.Ts("email.optin.codeSMS", "code", code)),
+				Subscriber:  sub,
+				Messenger:   name,
+			}); err != nil {
+				app.log.Printf("error sending opt-in SMS for subscriber %d (%s): %s", sub.ID, sub.UUID, err)
+				return err
+			}
+			return nil
+		}
+
+		app.log.Printf("opt-in SMS messenger '%s' not found. E-mailing the opt-in code to subscriber %d", name, sub.ID)
+	}
+
+	out := subOptin{Subscriber: app.manager.SanitizeSubscriber(sub), Lists: lists, Code: code}
+	out.UnsubURL = app.manager.UnsubURL(dummyUUID, sub.UUID)
+
+	h := textproto.MIMEHeader{}
+	h.Set(models.EmailHeaderSubscriberUUID, sub.UUID)
+
+	if err := app.sendLangNotification(lang, []string{sub.Email}, lang.i18n.T("subscribers.optinSubject"), notifSubscriberOptinCode, out, h); err != nil {
+		app.log.Printf("error sending opt-in code e-mail for subscriber %d (%s): %s", sub.ID, sub.UUID, err)
+		return err
+	}
+
+	return nil
+}
+
+// generateOptinCode returns a random 6 digit opt-in confirmation code.
+func generateOptinCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
 // hasSubPerm checks whether the current user has permission to access the given list
 // of subscriber IDs.
 func hasSubPerm(u models.User, subIDs []int, app *App) error {
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/secrets"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// suppressionSyncInterval is the interval at which suppression sources are checked for due syncs.
+	suppressionSyncInterval = time.Minute
+
+	// suppressionDefaultInterval is the sync interval of sources that don't specify one.
+	suppressionDefaultInterval = time.Hour * 24
+
+	// Minimum sync interval of a source.
+	suppressionMinInterval = time.Minute * 15
+)
+
+// suppressionMut serialises syncs so that scheduled and manual syncs of a
+// source don't run concurrently.
+var suppressionMut sync.Mutex
+
+// handleGetSuppressionSyncs returns the latest suppression syncs.
+func handleGetSuppressionSyncs(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := app.core.GetSuppressionSyncs()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleGetSuppressionSync returns a suppression sync with its diff report.
+func handleGetSuppressionSync(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	out, err := app.core.GetSuppressionSync(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRunSuppressionSync syncs a suppression source right away.
+func handleRunSuppressionSync(c echo.Context) error {
+	var (
+		app = c.Get("app").(*App)
+		id  = c.Param("uuid")
+	)
+
+	s, err := app.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	for _, src := range s.SuppressionSources {
+		if src.UUID == id {
+			out, err := syncSuppressionSource(src, app)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, okResp{out})
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusNotFound,
+		app.i18n.Ts("globals.messages.notFound", "name", "{settings.suppression.source}"))
+}
+
+// handleApplySuppressionSync applies or rejects a pending suppression sync.
+func handleApplySuppressionSync(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+		apply = c.Param("action") == "apply"
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	sc, err := app.core.GetSuppressionSync(id)
+	if err != nil {
+		return err
+	}
+	if sc.Status != models.SuppressionSyncPending {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("settings.suppression.notPending"))
+	}
+
+	if !apply {
+		if err := app.core.UpdateSuppressionSyncStatus(id, models.SuppressionSyncRejected); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	suppressionMut.Lock()
+	defer suppressionMut.Unlock()
+
+	if err := app.core.BlocklistEmails(sc.Added, suppressionSubSource(sc.SourceUUID)); err != nil {
+		return err
+	}
+	if err := app.core.UpdateSuppressionSyncStatus(id, models.SuppressionSyncApplied); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// syncSuppressionSource fetches a suppression source's e-mails and diffs them
+// against the blocklist. The new e-mails are blocklisted right away unless the
+// source requires approval, in which case the sync waits as pending.
+func syncSuppressionSource(src models.SuppressionSource, app *App) (models.SuppressionSync, error) {
+	suppressionMut.Lock()
+	defer suppressionMut.Unlock()
+
+	sc := models.SuppressionSync{SourceUUID: src.UUID, SourceName: src.Name}
+
+	// The sources are read from the DB and the key may be a secret reference.
+	var (
+		emails []string
+		err    error
+	)
+	if secrets.IsRef(src.Key) {
+		src.Key, err = secretResolver.Resolve(src.Key)
+	}
+	if err == nil {
+		emails, err = app.suppression.Fetch(src)
+	}
+	if err != nil {
+		app.log.Printf("error fetching suppression source (%s): %v", src.Name, err)
+		sc.Status = models.SuppressionSyncFailed
+		sc.Error = err.Error()
+		if sc.ID, err = app.core.InsertSuppressionSync(sc); err != nil {
+			return sc, err
+		}
+		return sc, nil
+	}
+
+	diff, err := app.core.GetSuppressionDiff(emails, suppressionSubSource(src.UUID))
+	if err != nil {
+		return sc, err
+	}
+	sc.Total, sc.Added, sc.Removed = diff.Total, diff.Added, diff.Removed
+	sc.NumAdded, sc.NumRemoved = diff.NumAdded, diff.NumRemoved
+
+	// Apply the changes right away.
+	sc.Status = models.SuppressionSyncApplied
+	if src.RequireApproval && sc.NumAdded > 0 {
+		sc.Status = models.SuppressionSyncPending
+	} else if sc.NumAdded > 0 {
+		if err := app.core.BlocklistEmails(sc.Added, suppressionSubSource(src.UUID)); err != nil {
+			return sc, err
+		}
+		sc.AppliedAt.Time, sc.AppliedAt.Valid = time.Now(), true
+	}
+
+	if sc.ID, err = app.core.InsertSuppressionSync(sc); err != nil {
+		return sc, err
+	}
+
+	app.log.Printf("synced suppression source (%s): %d e-mails, %d new, %d removed (%s)",
+		src.Name, sc.Total, sc.NumAdded, sc.NumRemoved, sc.Status)
+
+	if sc.Status == models.SuppressionSyncPending {
+		app.notify(notifs.Notif{
+			Event:   notifs.EventImport,
+			Subject: app.i18n.Ts("settings.suppression.pendingTitle", "name", src.Name),
+			Message: app.i18n.Ts("settings.suppression.pending",
+				"name", src.Name, "added", strconv.Itoa(sc.NumAdded), "removed", strconv.Itoa(sc.NumRemoved)),
+			Data: map[string]interface{}{
+				"sync_id":     sc.ID,
+				"source":      src.Name,
+				"num_added":   sc.NumAdded,
+				"num_removed": sc.NumRemoved,
+			},
+			Tpl: notifTplAlert,
+		})
+	}
+
+	// Don't return the (large) e-mail lists.
+	sc.Added, sc.Removed = nil, nil
+
+	return sc, nil
+}
+
+// runSuppressionSync periodically syncs the enabled suppression sources
+// that are due as per their intervals.
+func runSuppressionSync(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() {
+			continue
+		}
+
+		s, err := app.core.GetSettings()
+		if err != nil {
+			app.log.Printf("error reading suppression sources: %v", err)
+			continue
+		}
+
+		hasSources := false
+		for _, src := range s.SuppressionSources {
+			if src.Enabled {
+				hasSources = true
+				break
+			}
+		}
+		if !hasSources {
+			continue
+		}
+
+		last, err := app.core.GetSuppressionLastSyncs()
+		if err != nil {
+			continue
+		}
+
+		for _, src := range s.SuppressionSources {
+			if !src.Enabled {
+				continue
+			}
+
+			if t, ok := last[src.UUID]; ok && time.Since(t) < suppressionInterval(src) {
+				continue
+			}
+
+			if _, err := syncSuppressionSource(src, app); err != nil {
+				app.log.Printf("error syncing suppression source (%s): %v", src.Name, err)
+			}
+		}
+	}
+}
+
+// validateSuppressionSource validates a suppression source's fields.
+func validateSuppressionSource(s models.SuppressionSource, app *App) error {
+	if !strHasLen(s.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	switch s.Type {
+	case models.SuppressionTypeCSV, models.SuppressionTypeMailgun:
+		if !strHasLen(s.URL, 1, 2000) || !(strings.HasPrefix(s.URL, "http://") || strings.HasPrefix(s.URL, "https://")) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+		}
+	case models.SuppressionTypeSendgrid, models.SuppressionTypePostmark:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	if s.Type != models.SuppressionTypeCSV && s.Key == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "key"))
+	}
+
+	if s.Interval != "" {
+		d, err := time.ParseDuration(s.Interval)
+		if err != nil || d < suppressionMinInterval {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "interval"))
+		}
+	}
+
+	return nil
+}
+
+// suppressionInterval returns the sync interval of a source.
+func suppressionInterval(s models.SuppressionSource) time.Duration {
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil || d < suppressionMinInterval {
+		return suppressionDefaultInterval
+	}
+	return d
+}
+
+// suppressionSubSource returns the subscriber source (provenance) that a
+// suppression source's e-mails are blocklisted with.
+func suppressionSubSource(uuid string) string {
+	return "suppression:" + uuid
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// tagTypes are the object types that can be tagged.
+var tagTypes = []string{"campaign", "list", "subscriber"}
+
+// tagPerms maps a tag object type to the permissions required to read and
+// modify its tags.
+var tagPerms = map[string][2]string{
+	"campaign":   {models.PermCampaignsGet, models.PermCampaignsManage},
+	"list":       {models.PermListGetAll, models.PermListManageAll},
+	"subscriber": {models.PermSubscribersGetAll, models.PermSubscribersManage},
+}
+
+// tagsReq represents a request for renaming, merging, or deleting tags.
+type tagsReq struct {
+	Tags  []string `json:"tags"`
+	Name  string   `json:"name"`
+	Types []string `json:"types"`
+}
+
+// handleGetTags returns all tags along with their usage counts.
+func handleGetTags(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	types, err := filterTagTypes(c.QueryParams()["type"], user, false, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.GetTags(types)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRenameTag renames a tag across campaigns, lists, and subscribers. If the
+// new name is an existing tag, the two are merged.
+func handleRenameTag(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	tag, err := url.PathUnescape(c.Param("tag"))
+	if err != nil || strings.TrimSpace(tag) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "tag"))
+	}
+
+	var req tagsReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if !strHasLen(strings.TrimSpace(req.Name), 1, 100) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	types, err := filterTagTypes(req.Types, user, true, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.MergeTags([]string{tag}, req.Name, types)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleMergeTags merges one or more tags into a single tag across campaigns,
+// lists, and subscribers.
+func handleMergeTags(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req tagsReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.Tags) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "tags"))
+	}
+	if !strHasLen(strings.TrimSpace(req.Name), 1, 100) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	types, err := filterTagTypes(req.Types, user, true, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.MergeTags(req.Tags, req.Name, types)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleDeleteTag deletes a tag from campaigns, lists, and subscribers.
+func handleDeleteTag(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	tag, err := url.PathUnescape(c.Param("tag"))
+	if err != nil || strings.TrimSpace(tag) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "tag"))
+	}
+
+	types, err := filterTagTypes(c.QueryParams()["type"], user, true, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.MergeTags([]string{tag}, "", types)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// filterTagTypes validates the given tag object types (all types if empty) and
+// returns the ones that the user has read (or manage) permissions for.
+func filterTagTypes(types []string, user models.User, manage bool, app *App) ([]string, error) {
+	if len(types) == 0 {
+		types = tagTypes
+	}
+
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		p, ok := tagPerms[t]
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+
+		perm := p[0]
+		if manage {
+			perm = p[1]
+		}
+		if !isSuperAdmin(user) && !user.HasPerm(perm) {
+			continue
+		}
+
+		out = append(out, t)
+	}
+
+	return out, nil
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -36,8 +37,9 @@ func handleGetTemplates(c echo.Context) error {
 	var (
 		app = c.Get("app").(*App)
 
-		id, _     = strconv.Atoi(c.Param("id"))
-		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+		id, _       = strconv.Atoi(c.Param("id"))
+		noBody, _   = strconv.ParseBool(c.QueryParam("no_body"))
+		folderID, _ = strconv.Atoi(c.QueryParam("folder_id"))
 	)
 
 	// Fetch one list.
@@ -47,10 +49,11 @@ func handleGetTemplates(c echo.Context) error {
 			return err
 		}
 
+		setETag(c, out.Version)
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
-	out, err := app.core.GetTemplates("", noBody)
+	out, err := app.core.GetTemplates("", noBody, folderID)
 	if err != nil {
 		return err
 	}
@@ -135,6 +138,11 @@ func handlePreviewTemplate(c echo.Context) error {
 		out = m.Body
 	}
 
+	// Optionally, show the message the way dark mode clients would.
+	if c.QueryParam("dark") == "true" || c.FormValue("dark") == "true" {
+		out = manager.SimulateDarkMode(out)
+	}
+
 	return c.HTML(http.StatusOK, string(out))
 }
 
@@ -200,6 +208,15 @@ func handleUpdateTemplate(c echo.Context) error {
 		return err
 	}
 
+	// Reject the update if the template has been edited by someone else in the meantime.
+	cur, err := app.core.GetTemplate(id, false)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, cur.Version, cur, o, app, "name", "subject", "body"); err != nil {
+		return err
+	}
+
 	if err := validateTemplate(o, app); err != nil {
 		return err
 	}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Reasons for flagging link clicks as bot clicks.
+	botReasonUserAgent = "user_agent"
+	botReasonIP        = "ip"
+	botReasonBurst     = "burst"
+
+	// A subscriber clicking clickBurstNum or more links in a campaign within
+	// clickBurstWindow is likely a security scanner following all links in an e-mail.
+	clickBurstNum    = 3
+	clickBurstWindow = time.Second * 5
+
+	// Buffered views and clicks are written to the DB every trackerFlushInterval
+	// or when trackerBatchSize hits accumulate, whichever is earlier.
+	trackerFlushInterval = time.Second
+	trackerBatchSize     = 1000
+	trackerMaxBuffer     = 100000
+
+	// Maximum number of link UUID -> URL mappings cached in memory.
+	linkCacheSize = 10000
+)
+
+var (
+	// proxyUserAgents are substrings of the user agents of mail provider
+	// image proxies that fetch tracking pixels on behalf of (or before) the recipient.
+	proxyUserAgents = []string{
+		"GoogleImageProxy",
+		"ggpht.com",
+		"YahooMailProxy",
+	}
+
+	// botUserAgents are (lowercased) substrings of the user agents of
+	// security scanners, link checkers, and HTTP libraries.
+	botUserAgents = []string{
+		"bot", "spider", "crawl", "scanner", "headless", "preview",
+		"python-requests", "python-urllib", "go-http-client", "curl/", "wget", "okhttp", "java/",
+		"barracuda", "mimecast", "proofpoint", "symantec", "trendmicro", "forcepoint", "sophos",
+	}
+
+	// clickBursts tracks recent clicks per campaign+subscriber to detect bursts.
+	clickBursts = &burstTracker{clicks: make(map[string][]time.Time)}
+
+	// linkURLs caches link UUID -> URL mappings for redirecting clicks without a DB lookup.
+	linkURLs = &linkCache{urls: make(map[string]string)}
+
+	// proxyNets are IP ranges of mail provider image proxies.
+	// Apple Mail Privacy Protection prefetches images from Apple's 17.0.0.0/8.
+	proxyNets = mustParseCIDRs("17.0.0.0/8")
+)
+
+// isProxyView checks whether a tracking pixel request came from a known
+// prefetching image proxy rather than the recipient's mail client.
+func isProxyView(c echo.Context) bool {
+	ua := c.Request().UserAgent()
+
+	// Apple Mail Privacy Protection fetches with a bare user agent.
+	if ua == "Mozilla/5.0" {
+		return true
+	}
+	for _, p := range proxyUserAgents {
+		if strings.Contains(ua, p) {
+			return true
+		}
+	}
+
+	return ipInNets(c.RealIP(), proxyNets)
+}
+
+// ipInNets checks whether the given IP is in any of the given networks.
+func ipInNets(ip string, nets []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mustParseCIDRs parses a list of CIDR ranges and panics on invalid ones.
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+
+	return out
+}
+
+// botClickReason checks whether a link click request came from a security
+// scanner or bot and returns the reason. An empty string means the click
+// looks genuine.
+func botClickReason(c echo.Context, campUUID, subUUID string, app *App) string {
+	ua := strings.ToLower(c.Request().UserAgent())
+	if ua == "" {
+		return botReasonUserAgent
+	}
+	for _, b := range botUserAgents {
+		if strings.Contains(ua, b) {
+			return botReasonUserAgent
+		}
+	}
+
+	if ipInNets(c.RealIP(), app.constants.Privacy.BotClickNets) {
+		return botReasonIP
+	}
+
+	if subUUID != "" && clickBursts.add(campUUID+subUUID, time.Now()) {
+		return botReasonBurst
+	}
+
+	return ""
+}
+
+// burstTracker records click timestamps per key in memory to detect
+// clicks in quick succession.
+type burstTracker struct {
+	clicks map[string][]time.Time
+	sync.Mutex
+}
+
+// add records a click for the given key and returns true if it's part of a burst.
+func (b *burstTracker) add(key string, t time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	// Periodically sweep stale entries so that the map doesn't grow unbounded.
+	if len(b.clicks) > 10000 {
+		for k, ts := range b.clicks {
+			if t.Sub(ts[len(ts)-1]) > clickBurstWindow {
+				delete(b.clicks, k)
+			}
+		}
+	}
+
+	// Retain only the clicks within the window.
+	var recent []time.Time
+	for _, ts := range b.clicks[key] {
+		if t.Sub(ts) <= clickBurstWindow {
+			recent = append(recent, ts)
+		}
+	}
+	recent = append(recent, t)
+	b.clicks[key] = recent
+
+	return len(recent) >= clickBurstNum
+}
+
+// linkCache is a simple in-memory cache of link UUID -> URL mappings. Links
+// are never modified once created, so the cache never has to be invalidated.
+type linkCache struct {
+	urls map[string]string
+	sync.RWMutex
+}
+
+// get returns the URL of a link UUID, looking it up in the DB if it's not cached.
+func (l *linkCache) get(linkUUID string, app *App) (string, error) {
+	l.RLock()
+	url, ok := l.urls[linkUUID]
+	l.RUnlock()
+	if ok {
+		return url, nil
+	}
+
+	url, err := app.core.GetLinkURL(linkUUID)
+	if err != nil {
+		return "", err
+	}
+
+	l.Lock()
+	// Reset the cache instead of growing unbounded.
+	if len(l.urls) >= linkCacheSize {
+		l.urls = make(map[string]string)
+	}
+	l.urls[linkUUID] = url
+	l.Unlock()
+
+	return url, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/l10n"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// maxTranslationFileSize is the maximum size of an uploaded translation file.
+const maxTranslationFileSize = 10 * 1024 * 1024
+
+// handleExportCampaignTranslations exports the translatable strings of a
+// campaign's subject and body as an XLIFF or PO file for external translation.
+// With ?lang=, the strings of the campaign's existing variant for the language
+// are filled in as the translations.
+func handleExportCampaignTranslations(c echo.Context) error {
+	var (
+		app    = c.Get("app").(*App)
+		id, _  = strconv.Atoi(c.Param("id"))
+		format = c.QueryParam("format")
+		lang   = strings.ToLower(strings.TrimSpace(c.QueryParam("lang")))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+	if format == "" {
+		format = l10n.FormatXLIFF
+	}
+	if format != l10n.FormatXLIFF && format != l10n.FormatPO {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "format"))
+	}
+	if len(lang) > 6 || reLangCode.MatchString(lang) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "lang"))
+	}
+
+	camp, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	units := l10n.Extract(l10n.Content{Subject: camp.Subject, Body: camp.Body, ContentType: camp.ContentType})
+
+	// Fill in the existing translations if the variant's body has the same
+	// structure as the campaign's.
+	if v := campaignVariant(camp, lang); v != nil {
+		tr := l10n.Extract(l10n.Content{Subject: v.Subject, Body: v.Body, ContentType: camp.ContentType})
+		if len(tr) == len(units) {
+			for i := range units {
+				units[i].Target = tr[i].Source
+			}
+		}
+	}
+
+	var (
+		b        bytes.Buffer
+		original = fmt.Sprintf("campaign-%d", camp.ID)
+	)
+	if format == l10n.FormatPO {
+		err = l10n.WritePO(&b, units, original, app.constants.Lang, lang)
+	} else {
+		err = l10n.WriteXLIFF(&b, units, original, app.constants.Lang, lang)
+	}
+	if err != nil {
+		app.log.Printf("error exporting campaign translations: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.Ts("globals.messages.internalError"))
+	}
+
+	var (
+		name  = original
+		ctype = "application/x-xliff+xml"
+		ext   = ".xlf"
+	)
+	if lang != "" {
+		name += "-" + lang
+	}
+	if format == l10n.FormatPO {
+		ctype, ext = "text/x-gettext-translation; charset=utf-8", ".po"
+	}
+
+	c.Response().Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ext}))
+	return c.Blob(http.StatusOK, ctype, b.Bytes())
+}
+
+// handleImportCampaignTranslations imports a translated XLIFF or PO file and
+// creates (or replaces) the campaign's language variant from it. Strings that
+// aren't translated in the file are left in the campaign's language.
+func handleImportCampaignTranslations(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		id, _ = strconv.Atoi(c.Param("id"))
+	)
+
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	file, vals, err := streamFormFile(c, "file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.translationsInvalidFile", "error", err.Error()))
+	}
+	defer file.Close()
+
+	if file.Size > maxTranslationFileSize {
+		return errBodyTooLarge(maxTranslationFileSize, app)
+	}
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.translationsInvalidFile", "error", err.Error()))
+	}
+
+	cm, err := app.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.cantUpdate"))
+	}
+
+	// Parse the file.
+	var (
+		lang string
+		tr   map[string]string
+	)
+	if translationFormat(file.Filename, b) == l10n.FormatPO {
+		lang, tr, err = l10n.ParsePO(bytes.NewReader(b))
+	} else {
+		lang, tr, err = l10n.ParseXLIFF(bytes.NewReader(b))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.translationsInvalidFile", "error", err.Error()))
+	}
+
+	// The language in the request overrides the one in the file.
+	if l := strings.TrimSpace(vals["lang"]); l != "" {
+		lang = l
+	}
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.translationsNoLang"))
+	}
+
+	// Apply the translations to the campaign's content.
+	src := l10n.Content{Subject: cm.Subject, Body: cm.Body, ContentType: cm.ContentType}
+	out, missing, err := l10n.Apply(src, tr)
+	if err != nil {
+		if errors.Is(err, l10n.ErrNoTranslations) {
+			return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("campaigns.translationsEmpty"))
+		}
+		return echo.NewHTTPError(http.StatusBadRequest,
+			app.i18n.Ts("campaigns.translationsInvalidFile", "error", err.Error()))
+	}
+
+	// Create or replace the variant.
+	var (
+		o = campaignReq{Campaign: cm, ListIDs: campaignListIDs(cm), MediaIDs: campaignMediaIDs(cm)}
+		v = models.CampaignVariant{Lang: lang, Subject: out.Subject, Body: out.Body}
+	)
+	o.Variants = make(models.CampaignVariants, 0, len(cm.Variants)+1)
+	for _, cv := range cm.Variants {
+		if !strings.EqualFold(cv.Lang, lang) {
+			o.Variants = append(o.Variants, cv)
+		}
+	}
+	o.Variants = append(o.Variants, v)
+
+	o, err = validateCampaignContent(o, app)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	camp, err := app.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	if err != nil {
+		return err
+	}
+
+	// Drop the compiled templates of the previous revision.
+	app.manager.DeleteCampaignTpls(id)
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Campaign models.Campaign `json:"campaign"`
+		Lang     string          `json:"lang"`
+		Missing  int             `json:"missing"`
+	}{camp, lang, missing}})
+}
+
+// campaignVariant returns the campaign's variant for the exact language, if
+// there's one.
+func campaignVariant(c models.Campaign, lang string) *models.CampaignVariant {
+	if lang == "" {
+		return nil
+	}
+
+	for i, v := range c.Variants {
+		if strings.EqualFold(v.Lang, lang) {
+			return &c.Variants[i]
+		}
+	}
+
+	return nil
+}
+
+// translationFormat returns the format of a translation file by its extension,
+// or by its contents if the extension isn't known.
+func translationFormat(filename string, b []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".po", ".pot":
+		return l10n.FormatPO
+	case ".xlf", ".xliff", ".xml":
+		return l10n.FormatXLIFF
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("<")) {
+		return l10n.FormatXLIFF
+	}
+	return l10n.FormatPO
+}
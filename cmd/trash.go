@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// trashPurgeInterval is the interval at which expired items in the trash are purged.
+const trashPurgeInterval = time.Hour
+
+// trashPerms maps a trash item type to the permission required to view,
+// restore, and purge it.
+var trashPerms = map[string]string{
+	models.TrashTypeCampaign: models.PermCampaignsManage,
+	models.TrashTypeList:     models.PermListManageAll,
+	models.TrashTypeTemplate: models.PermTemplatesManage,
+}
+
+// trashReq represents a request to restore or purge items in the trash.
+type trashReq struct {
+	Type string `json:"type"`
+	IDs  []int  `json:"ids"`
+}
+
+// handleGetTrash returns items in the trash that the user has permissions for.
+func handleGetTrash(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	types, err := filterTrashTypes(c.QueryParams()["type"], user, app)
+	if err != nil {
+		return err
+	}
+
+	out, err := app.core.GetTrash(types)
+	if err != nil {
+		return err
+	}
+
+	// Compute when each item will be automatically purged.
+	if days := app.constants.TrashRetentionDays; days > 0 {
+		for i, t := range out {
+			out[i].PurgeAt = null.TimeFrom(t.DeletedAt.AddDate(0, 0, days))
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRestoreTrash restores items of a given type from the trash.
+func handleRestoreTrash(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req trashReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if len(req.IDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
+	}
+
+	types, err := filterTrashTypes([]string{req.Type}, user, app)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", trashPerms[req.Type]))
+	}
+
+	n, err := app.core.RestoreTrash(req.Type, req.IDs)
+	if err != nil {
+		return err
+	}
+	if req.Type == models.TrashTypeList {
+		app.refreshListDomains()
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{n}})
+}
+
+// handlePurgeTrash permanently deletes items from the trash. If no IDs are
+// given, all items of the given type (or all types) in the trash are deleted.
+func handlePurgeTrash(c echo.Context) error {
+	var (
+		app  = c.Get("app").(*App)
+		user = c.Get(auth.UserKey).(models.User)
+	)
+
+	var req trashReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	var in []string
+	if req.Type != "" {
+		in = []string{req.Type}
+	}
+
+	types, err := filterTrashTypes(in, user, app)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, app.i18n.Ts("globals.messages.permissionDenied", "name", trashPerms[req.Type]))
+	}
+
+	n, err := app.core.PurgeTrash(types, req.IDs, time.Now())
+	if err != nil {
+		return err
+	}
+	app.refreshListDomains()
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{n}})
+}
+
+// filterTrashTypes validates the given trash item types (all types if empty) and
+// returns the ones that the user has permissions for.
+func filterTrashTypes(types []string, user models.User, app *App) ([]string, error) {
+	if len(types) == 0 {
+		types = []string{models.TrashTypeCampaign, models.TrashTypeList, models.TrashTypeTemplate}
+	}
+
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		perm, ok := trashPerms[t]
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+		}
+
+		if !isSuperAdmin(user) && !user.HasPerm(perm) {
+			continue
+		}
+
+		out = append(out, t)
+	}
+
+	return out, nil
+}
+
+// runTrashPurger periodically and permanently deletes items that have been in the
+// trash for longer than the retention period. This blocks and is meant to be
+// run in a goroutine.
+func runTrashPurger(days int, app *App) {
+	types := []string{models.TrashTypeCampaign, models.TrashTypeList, models.TrashTypeTemplate}
+
+	purge := func() {
+		if !app.isLeader() {
+			return
+		}
+
+		n, err := app.core.PurgeTrash(types, nil, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			app.log.Printf("purged %d item(s) from the trash", n)
+		}
+	}
+
+	purge()
+	t := time.NewTicker(trashPurgeInterval)
+	defer t.Stop()
+	for range t.C {
+		purge()
+	}
+}
@@ -20,6 +20,11 @@ func handleSendTxMessage(c echo.Context) error {
 		m   models.TxMessage
 	)
 
+	// All sending has been halted.
+	if app.manager.IsHalted() {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, app.i18n.T("settings.sendingHalted"))
+	}
+
 	// If it's a multipart form, there may be file attachments.
 	if strings.HasPrefix(c.Request().Header.Get("Content-Type"), "multipart/form-data") {
 		form, err := c.MultipartForm()
@@ -115,7 +120,7 @@ func handleSendTxMessage(c echo.Context) error {
 		}
 
 		// Render the message.
-		if err := m.Render(sub, tpl); err != nil {
+		if err := m.Render(app.manager.SanitizeSubscriber(sub), tpl); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
 				app.i18n.Ts("globals.messages.errorFetching", "name"))
 		}
@@ -205,3 +210,33 @@ func validateTxMessage(m models.TxMessage, app *App) (models.TxMessage, error) {
 
 	return m, nil
 }
+
+// sendTxTemplate renders a transactional template for a subscriber with the
+// given data and sends it with the default messenger and from address.
+func sendTxTemplate(tplID int, sub models.Subscriber, data map[string]interface{}, app *App) error {
+	tpl, err := app.manager.GetTpl(tplID)
+	if err != nil {
+		return err
+	}
+
+	m := models.TxMessage{
+		TemplateID:  tplID,
+		Data:        data,
+		FromEmail:   app.constants.FromEmail,
+		ContentType: models.CampaignContentTypeHTML,
+		Messenger:   emailMsgr,
+	}
+	if err := m.Render(app.manager.SanitizeSubscriber(sub), tpl); err != nil {
+		return err
+	}
+
+	return app.manager.PushMessage(models.Message{
+		From:        m.FromEmail,
+		To:          []string{sub.Email},
+		Subject:     m.Subject,
+		ContentType: m.ContentType,
+		Body:        m.Body,
+		Messenger:   m.Messenger,
+		Subscriber:  sub,
+	})
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/migrations"
 	"github.com/knadh/stuffbin"
+	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 	"golang.org/x/mod/semver"
 )
@@ -41,11 +44,32 @@ var migList = []migFunc{
 	{"v4.0.0", migrations.V4_0_0},
 	{"v4.1.0", migrations.V4_1_0},
 	{"v5.0.0", migrations.V5_0_0},
+	{"v5.1.0", migrations.V5_1_0},
 }
 
 // upgrade upgrades the database to the current version by running SQL migration files
-// for all version from the last known version to the current one.
-func upgrade(db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
+// for all version from the last known version to the current one. If dryRun is set,
+// the pending migrations are only listed.
+func upgrade(db *sqlx.DB, fs stuffbin.FileSystem, prompt, dryRun bool) {
+	lastVer, toRun, err := getPendingMigrations(db)
+	if err != nil {
+		lo.Fatalf("error checking migrations: %v", err)
+	}
+
+	// No migrations to run.
+	if len(toRun) == 0 {
+		lo.Printf("no upgrades to run. Database is up to date.")
+		return
+	}
+
+	if dryRun {
+		lo.Printf("database is at %s. %d pending migration(s):", lastVer, len(toRun))
+		for _, m := range toRun {
+			lo.Printf("  %s", m.version)
+		}
+		return
+	}
+
 	if prompt {
 		var ok string
 		fmt.Printf("** IMPORTANT: Take a backup of the database before upgrading.\n")
@@ -59,35 +83,47 @@ func upgrade(db *sqlx.DB, fs stuffbin.FileSystem, prompt bool) {
 		}
 	}
 
-	_, toRun, err := getPendingMigrations(db)
+	// Acquire the migration lock so that other instances upgrading
+	// the same database wait for this one to finish.
+	lo.Printf("acquiring migration lock")
+	unlock, err := migrations.Lock(db)
 	if err != nil {
-		lo.Fatalf("error checking migrations: %v", err)
+		lo.Fatalf("error acquiring migration lock: %v", err)
 	}
+	defer unlock()
 
-	// No migrations to run.
+	// Another instance may have run the migrations while this one was waiting for the lock.
+	_, toRun, err = getPendingMigrations(db)
+	if err != nil {
+		lo.Fatalf("error checking migrations: %v", err)
+	}
 	if len(toRun) == 0 {
 		lo.Printf("no upgrades to run. Database is up to date.")
 		return
 	}
 
 	// Execute migrations in succession.
+	start := time.Now()
 	for _, m := range toRun {
 		lo.Printf("running migration %s", m.version)
+
+		t := time.Now()
 		if err := m.fn(db, fs, ko, lo); err != nil {
-			lo.Fatalf("error running migration %s: %v", m.version, err)
+			lo.Fatalf("error running migration %s (after %s): %v", m.version, time.Since(t).Round(time.Millisecond), err)
 		}
 
 		// Record the migration version in the settings table. There was no
 		// settings table until v0.7.0, so ignore the no-table errors.
 		if err := recordMigrationVersion(m.version, db); err != nil {
-			if isTableNotExistErr(err) {
-				continue
+			if !isTableNotExistErr(err) {
+				lo.Fatalf("error recording migration version %s: %v", m.version, err)
 			}
-			lo.Fatalf("error recording migration version %s: %v", m.version, err)
 		}
+
+		lo.Printf("migration %s completed in %s", m.version, time.Since(t).Round(time.Millisecond))
 	}
 
-	lo.Printf("upgrade complete")
+	lo.Printf("upgrade complete in %s", time.Since(start).Round(time.Millisecond))
 }
 
 // checkUpgrade checks if the current database schema matches the expected
@@ -103,6 +139,24 @@ func checkUpgrade(db *sqlx.DB) {
 		return
 	}
 
+	// Another instance is running the migrations. Wait for it to finish and check again.
+	if ok, err := migrations.IsLocked(db); err == nil && ok {
+		lo.Printf("waiting for the database upgrade running on another instance to finish")
+		unlock, err := migrations.Lock(db)
+		if err != nil {
+			lo.Fatalf("error acquiring migration lock: %v", err)
+		}
+		unlock()
+
+		lastVer, toRun, err = getPendingMigrations(db)
+		if err != nil {
+			lo.Fatalf("error checking migrations: %v", err)
+		}
+		if len(toRun) == 0 {
+			return
+		}
+	}
+
 	var vers []string
 	for _, m := range toRun {
 		vers = append(vers, m.version)
@@ -160,3 +214,45 @@ func isTableNotExistErr(err error) bool {
 	}
 	return false
 }
+
+// handleGetMigrations returns the database migration status: the version the
+// database is at, the migrations that have been applied, the ones that are
+// pending, and whether an upgrade is running on any instance.
+func handleGetMigrations(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	lastVer, toRun, err := getPendingMigrations(app.db)
+	if err != nil {
+		app.log.Printf("error checking migrations: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			app.i18n.Ts("globals.messages.errorFetching", "name", "migrations", "error", err.Error()))
+	}
+
+	var applied pq.StringArray
+	if err := app.db.Get(&applied, `SELECT COALESCE(ARRAY(SELECT JSONB_ARRAY_ELEMENTS_TEXT(value)
+		FROM settings WHERE key='migrations'), '{}')`); err != nil {
+		app.log.Printf("error fetching applied migrations: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			app.i18n.Ts("globals.messages.errorFetching", "name", "migrations", "error", err.Error()))
+	}
+
+	running, err := migrations.IsLocked(app.db)
+	if err != nil {
+		app.log.Printf("error checking migration lock: %v", err)
+	}
+
+	pending := make([]string, 0, len(toRun))
+	for _, m := range toRun {
+		pending = append(pending, m.version)
+	}
+
+	out := struct {
+		Version   string   `json:"version"`
+		DBVersion string   `json:"db_version"`
+		Applied   []string `json:"applied"`
+		Pending   []string `json:"pending"`
+		Running   bool     `json:"running"`
+	}{migList[len(migList)-1].version, lastVer, applied, pending, running}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultBodyLimit       = 10 * 1024 * 1024
+	defaultMediaBodyLimit  = 50 * 1024 * 1024
+	defaultImportBodyLimit = 1024 * 1024 * 1024
+
+	// maxFormValueSize is the maximum size of a non-file field in a streamed
+	// multipart form.
+	maxFormValueSize = 1024 * 1024
+)
+
+// formUpload is a file in a multipart form that's streamed to a temporary
+// file on disk instead of being buffered in memory.
+type formUpload struct {
+	*os.File
+
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// Close closes and deletes the temporary file.
+func (u *formUpload) Close() error {
+	u.File.Close()
+	return os.Remove(u.File.Name())
+}
+
+// bodyLimiter limits the size of request bodies. Media uploads and imports
+// have their own limits. Requests with bodies over the limit are rejected
+// with a 413.
+func bodyLimiter(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit := app.constants.BodyLimit.Default
+			switch c.Path() {
+			case "/api/media":
+				limit = app.constants.BodyLimit.Media
+			case "/api/import/subscribers", "/api/lists/archive":
+				limit = app.constants.BodyLimit.Import
+			}
+
+			if limit <= 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.ContentLength > limit {
+				return errBodyTooLarge(limit, app)
+			}
+			body := &limitedBody{ReadCloser: http.MaxBytesReader(c.Response(), req.Body, limit)}
+			req.Body = body
+
+			err := next(c)
+
+			// Handlers wrap read errors in different ways when the body is cut
+			// off at the limit while it's being read.
+			if body.exceeded {
+				return errBodyTooLarge(limit, app)
+			}
+
+			return err
+		}
+	}
+}
+
+// limitedBody is a request body that records whether it was read past the
+// size limit.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	var mErr *http.MaxBytesError
+	if errors.As(err, &mErr) {
+		b.exceeded = true
+	}
+
+	return n, err
+}
+
+// errBodyTooLarge returns the error for a request body over the size limit.
+func errBodyTooLarge(limit int64, app *App) error {
+	return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+		app.i18n.Ts("globals.messages.requestTooLarge", "size", fmt.Sprintf("%.1f MB", float64(limit)/1024/1024)))
+}
+
+// streamFormFile reads a multipart form request part by part and streams the
+// file in the given field to a temporary file. The values of the other fields
+// are returned. The caller should Close() the returned file, which deletes it.
+func streamFormFile(c echo.Context, field string) (*formUpload, map[string]string, error) {
+	r, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		file   *formUpload
+		values = map[string]string{}
+	)
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if file != nil {
+				file.Close()
+			}
+			return nil, nil, err
+		}
+
+		// Regular form field.
+		if part.FileName() == "" {
+			b, err := io.ReadAll(io.LimitReader(part, maxFormValueSize))
+			part.Close()
+			if err != nil {
+				if file != nil {
+					file.Close()
+				}
+				return nil, nil, err
+			}
+			values[part.FormName()] = string(b)
+			continue
+		}
+
+		// Only the first file in the field is picked.
+		if part.FormName() != field || file != nil {
+			part.Close()
+			continue
+		}
+
+		f, err := os.CreateTemp("", "listmonk-upload")
+		if err != nil {
+			part.Close()
+			return nil, nil, err
+		}
+
+		n, err := io.Copy(f, part)
+		part.Close()
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, nil, err
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, nil, err
+		}
+
+		file = &formUpload{
+			File:        f,
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        n,
+		}
+	}
+
+	if file == nil {
+		return nil, nil, http.ErrMissingFile
+	}
+
+	// Fall back to the extension if the client didn't send the content type.
+	if file.ContentType == "" {
+		file.ContentType = mime.TypeByExtension(filepath.Ext(file.Filename))
+	}
+
+	return file, values, nil
+}
@@ -294,3 +294,8 @@ func cacheUsers(co *core.Core, a *auth.Auth) (bool, error) {
 	a.CacheAPIUsers(apiUsers)
 	return hasUser, nil
 }
+
+// isSuperAdmin checks whether the given user has the Super Admin role.
+func isSuperAdmin(u models.User) bool {
+	return u.UserRole.ID == auth.SuperAdminRoleID
+}
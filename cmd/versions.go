@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Fields that change on every edit and are not a part of conflict diffs.
+var versionSkipFields = map[string]bool{
+	"version":    true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// setETag sets the version of an object as the response's ETag. Clients
+// send it back in the If-Match header of update requests to detect edits
+// that have happened in between.
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// checkVersion checks the If-Match precondition of an update request against
+// the current version of an object. If the object has been edited since the
+// client fetched it, a 409 is returned with the object's current version, its
+// current values, and the fields whose values the update would overwrite.
+// Only the given fields (JSON keys) are compared, or all, if there are none.
+// Requests without If-Match aren't checked.
+func checkVersion(c echo.Context, version int, current, update interface{}, app *App, fields ...string) error {
+	h := c.Request().Header.Get("If-Match")
+	if h == "" {
+		return nil
+	}
+
+	for _, t := range strings.Split(h, ",") {
+		t = strings.Trim(strings.TrimPrefix(strings.TrimSpace(t), "W/"), `"`)
+		if t == "*" || t == strconv.Itoa(version) {
+			return nil
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusConflict, map[string]interface{}{
+		"message": app.i18n.T("globals.messages.editConflict"),
+		"data": map[string]interface{}{
+			"version": version,
+			"fields":  conflictFields(current, update, fields),
+			"current": current,
+		},
+	})
+}
+
+// conflictFields returns the JSON keys of the fields whose values differ between two objects.
+func conflictFields(a, b interface{}, fields []string) []string {
+	var am, bm map[string]json.RawMessage
+	if err := remarshal(a, &am); err != nil {
+		return []string{}
+	}
+	if err := remarshal(b, &bm); err != nil {
+		return []string{}
+	}
+
+	if len(fields) == 0 {
+		for k := range bm {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+	}
+
+	out := []string{}
+	for _, k := range fields {
+		if versionSkipFields[k] {
+			continue
+		}
+		if !bytes.Equal(am[k], bm[k]) {
+			out = append(out, k)
+		}
+	}
+
+	return out
+}
+
+// remarshal marshals an object to JSON and unmarshals it into out.
+func remarshal(v interface{}, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, out)
+}
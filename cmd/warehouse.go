@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// warehouseScheduleInterval is the interval at which the tables that are
+	// due for export to the data warehouse are checked.
+	warehouseScheduleInterval = time.Minute
+
+	// Pause between the batches of an export to not load the DB.
+	warehouseBatchPause = time.Second
+)
+
+// warehouseTables are the tables that can be exported, in display order.
+var warehouseTables = []string{
+	models.WarehouseTableSubscribers,
+	models.WarehouseTableDeliveries,
+	models.WarehouseTableViews,
+	models.WarehouseTableClicks,
+}
+
+type warehouseResp struct {
+	Enabled     bool                     `json:"enabled"`
+	Destination string                   `json:"destination"`
+	Tables      []models.WarehouseExport `json:"tables"`
+}
+
+// handleGetWarehouseExports returns the config and the export states of the
+// tables that can be exported to the data warehouse.
+func handleGetWarehouseExports(c echo.Context) error {
+	app := c.Get("app").(*App)
+
+	out, err := getWarehouseExports(app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleRunWarehouseExport starts exporting a table to the data warehouse in
+// the background.
+func handleRunWarehouseExport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		table = c.Param("table")
+	)
+
+	if err := checkWarehouseTable(table, app); err != nil {
+		return err
+	}
+	if !app.warehouse.Lock(table) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("warehouse.running"))
+	}
+
+	go func() {
+		defer app.warehouse.Unlock(table)
+		if _, err := exportWarehouseTable(table, app); err != nil {
+			app.log.Printf("error exporting %s to the warehouse: %v", table, err)
+		}
+	}()
+
+	out, err := getWarehouseExports(app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// handleResetWarehouseExport resets the cursor of a table's export so that
+// the whole table is exported again on its next run.
+func handleResetWarehouseExport(c echo.Context) error {
+	var (
+		app   = c.Get("app").(*App)
+		table = c.Param("table")
+	)
+
+	if err := checkWarehouseTable(table, app); err != nil {
+		return err
+	}
+	if app.warehouse.IsRunning(table) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("warehouse.running"))
+	}
+
+	if err := app.core.ResetWarehouseExport(table); err != nil {
+		return err
+	}
+
+	out, err := getWarehouseExports(app)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getWarehouseExports returns the export states of all the tables with their
+// config.
+func getWarehouseExports(app *App) (warehouseResp, error) {
+	res, err := app.core.GetWarehouseExports()
+	if err != nil {
+		return warehouseResp{}, err
+	}
+
+	states := make(map[string]models.WarehouseExport, len(res))
+	for _, e := range res {
+		states[e.Name] = e
+	}
+
+	out := warehouseResp{Enabled: app.warehouse != nil, Tables: make([]models.WarehouseExport, 0, len(warehouseTables))}
+	if app.warehouse != nil {
+		out.Destination = app.warehouse.Destination()
+	}
+
+	for _, name := range warehouseTables {
+		e, ok := states[name]
+		if !ok {
+			e = models.WarehouseExport{Name: name}
+		}
+
+		if app.warehouse != nil {
+			t := app.warehouse.Table(name)
+			e.Enabled = t.Enabled
+			e.Format = t.Format
+			e.Target = t.Target
+			e.Running = app.warehouse.IsRunning(name)
+			if t.Interval > 0 {
+				e.Interval = t.Interval.String()
+			}
+		}
+		out.Tables = append(out.Tables, e)
+	}
+
+	return out, nil
+}
+
+// checkWarehouseTable checks that the warehouse export is enabled and that a
+// table is configured for export.
+func checkWarehouseTable(table string, app *App) error {
+	if app.warehouse == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("warehouse.disabled"))
+	}
+	if !app.warehouse.Table(table).Enabled {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("warehouse.tableDisabled", "name", table))
+	}
+
+	return nil
+}
+
+// exportWarehouseTable exports the rows of a table that have been added or
+// updated since its last export to the data warehouse in batches, advancing
+// the table's cursor after every exported file. It returns the number of
+// exported rows. The table should be locked by the caller.
+func exportWarehouseTable(table string, app *App) (int, error) {
+	w := app.warehouse
+
+	res, err := app.core.GetWarehouseExports()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		cursorAt time.Time
+		cursorID int64
+	)
+	for _, e := range res {
+		if e.Name == table {
+			cursorAt, cursorID = e.CursorAt.Time, e.CursorID
+			break
+		}
+	}
+
+	total := 0
+	for {
+		rows, at, id, err := app.core.GetWarehouseRows(table, cursorAt, cursorID, w.BatchSize())
+		if err != nil {
+			setWarehouseError(table, err, app)
+			return total, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		if err := w.Export(table, rows); err != nil {
+			setWarehouseError(table, err, app)
+			return total, err
+		}
+
+		if err := app.core.UpdateWarehouseCursor(table, at, id, len(rows)); err != nil {
+			return total, err
+		}
+		cursorAt, cursorID = at, id
+		total += len(rows)
+
+		if len(rows) < w.BatchSize() {
+			break
+		}
+		time.Sleep(warehouseBatchPause)
+	}
+
+	if err := app.core.UpdateWarehouseRun(table, models.WarehouseStatusSuccess, ""); err != nil {
+		return total, err
+	}
+
+	return total, nil
+}
+
+// setWarehouseError records the error of a failed run of a table's export.
+func setWarehouseError(table string, err error, app *App) {
+	msg := err.Error()
+	if e, ok := err.(*echo.HTTPError); ok {
+		msg = fmt.Sprintf("%v", e.Message)
+	}
+
+	app.core.UpdateWarehouseRun(table, models.WarehouseStatusError, msg)
+}
+
+// runWarehouseExporter periodically exports the tables that are due for
+// export to the data warehouse, one after the other. This blocks and is meant
+// to be run in a goroutine.
+func runWarehouseExporter(interval time.Duration, app *App) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !app.isLeader() {
+			continue
+		}
+
+		res, err := app.core.GetWarehouseExports()
+		if err != nil {
+			continue
+		}
+
+		last := make(map[string]time.Time, len(res))
+		for _, e := range res {
+			if e.LastRunAt.Valid {
+				last[e.Name] = e.LastRunAt.Time
+			}
+		}
+
+		for _, name := range warehouseTables {
+			opt := app.warehouse.Table(name)
+			if !opt.Enabled {
+				continue
+			}
+			if l, ok := last[name]; ok && time.Since(l) < opt.Interval {
+				continue
+			}
+
+			// Skip tables that are being exported on demand.
+			if !app.warehouse.Lock(name) {
+				continue
+			}
+			n, err := exportWarehouseTable(name, app)
+			app.warehouse.Unlock(name)
+			if err != nil {
+				app.log.Printf("error exporting %s to the warehouse: %v", name, err)
+				continue
+			}
+			if n > 0 {
+				app.log.Printf("exported %d %s to the warehouse", n, name)
+			}
+		}
+	}
+}
@@ -113,6 +113,14 @@ export const getDashboardCharts = () => http.get(
   { loading: models.dashboard },
 );
 
+export const getEventLog = (params) => http.get(
+  '/api/events',
+  {
+    params,
+    camelCase: (keyPath) => !keyPath.startsWith('.results.*.meta'),
+  },
+);
+
 // Lists.
 export const getLists = (params) => http.get(
   '/api/lists',
@@ -136,6 +144,11 @@ export const getList = async (id) => http.get(
   { loading: models.list },
 );
 
+export const getListReferrers = async (id) => http.get(
+  `/api/lists/${id}/referrers`,
+  { loading: models.list },
+);
+
 export const createList = (data) => http.post(
   '/api/lists',
   data,
@@ -145,6 +158,12 @@ export const createList = (data) => http.post(
 export const updateList = (data) => http.put(
   `/api/lists/${data.id}`,
   data,
+  { loading: models.lists, headers: data.version ? { 'If-Match': `"${data.version}"` } : {} },
+);
+
+export const previewDynamicList = (data) => http.post(
+  '/api/lists/dynamic/preview',
+  data,
   { loading: models.lists },
 );
 
@@ -164,6 +183,11 @@ export const getSubscribers = async (params) => http.get(
   },
 );
 
+export const countSubscribers = async (data) => http.post(
+  '/api/subscribers/count',
+  data,
+);
+
 export const getSubscriber = async (id) => http.get(
   `/api/subscribers/${id}`,
   { loading: models.subscribers },
@@ -201,6 +225,24 @@ export const updateSubscriber = (data) => http.put(
   { loading: models.subscribers },
 );
 
+export const updateSubscriberPublicKey = (id, publicKey) => http.put(
+  `/api/subscribers/${id}/public-key`,
+  { public_key: publicKey },
+  { loading: models.subscribers },
+);
+
+export const rotateSubscriberUUID = (id, reason) => http.put(
+  `/api/subscribers/${id}/uuid`,
+  { reason },
+  { loading: models.subscribers },
+);
+
+export const createSubscriberPreview = (id, data) => http.post(
+  `/api/subscribers/${id}/preview`,
+  data,
+  { loading: models.subscribers },
+);
+
 export const sendSubscriberOptin = (id) => http.post(
   `/api/subscribers/${id}/optin`,
   {},
@@ -281,6 +323,29 @@ export const getCampaign = async (id) => http.get(`/api/campaigns/${id}`, {
 
 export const getCampaignStats = async () => http.get('/api/campaigns/running/stats', {});
 
+export const getCampaignRSVPs = async (id) => http.get(`/api/campaigns/${id}/rsvps`, {});
+
+export const getCampaignVariantStats = async (id) => http.get(`/api/campaigns/${id}/variants/stats`, {});
+
+export const importCampaignTranslations = async (id, data) => http.post(
+  `/api/campaigns/${id}/translations`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const getCampaignPreflight = async (id) => http.get(
+  `/api/campaigns/${id}/preflight`,
+  { loading: models.campaigns },
+);
+
+export const getCampaignChecklist = async (id) => http.get(`/api/campaigns/${id}/checklist`, {});
+
+export const updateCampaignChecklist = async (id, data) => http.put(
+  `/api/campaigns/${id}/checklist`,
+  data,
+  {},
+);
+
 export const createCampaign = async (data) => http.post(
   '/api/campaigns',
   data,
@@ -319,9 +384,113 @@ export const testCampaign = async (data) => http.post(
   { loading: models.campaigns },
 );
 
-export const updateCampaign = async (id, data) => http.put(
+// If a version is given, the update is rejected with a 409 if the campaign
+// has been edited by someone else since it was loaded.
+export const updateCampaign = async (id, data, version) => http.put(
   `/api/campaigns/${id}`,
   data,
+  {
+    loading: models.campaigns,
+    headers: version ? { 'If-Match': `"${version}"` } : {},
+    disableToast: !!version,
+  },
+);
+
+export const autosaveCampaign = async (id, data) => http.put(
+  `/api/campaigns/${id}/autosave`,
+  data,
+  { headers: { 'Content-Type': 'application/json' } },
+);
+
+export const getCampaignFollowups = async (id) => http.get(`/api/campaigns/${id}/followups`, {});
+
+export const upsertCampaignFollowup = async (id, data) => http.post(
+  `/api/campaigns/${id}/followups`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const deleteCampaignFollowup = async (id, campID) => http.delete(
+  `/api/campaigns/${id}/followups/${campID}`,
+  { loading: models.campaigns },
+);
+
+export const getCampaignOutbox = async (id, params) => http.get(
+  `/api/campaigns/${id}/outbox`,
+  { params, loading: models.campaigns },
+);
+
+export const getCampaignOutboxMessage = async (id, msgID) => http.get(
+  `/api/campaigns/${id}/outbox/${msgID}`,
+  { loading: models.campaigns },
+);
+
+export const updateCampaignOutbox = async (id, action) => http.put(
+  `/api/campaigns/${id}/outbox`,
+  { action },
+  { loading: models.campaigns },
+);
+
+// Campaign presets.
+export const createCampaignRetry = async (id, data) => http.post(
+  `/api/campaigns/${id}/retry`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const getCampaignPresets = async () => http.get('/api/campaign-presets', {});
+
+export const createCampaignPresetFromCampaign = async (id, data) => http.post(
+  `/api/campaigns/${id}/preset`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const instantiateCampaignPreset = async (id, data) => http.post(
+  `/api/campaign-presets/${id}/instantiate`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const deleteCampaignPreset = async (id) => http.delete(`/api/campaign-presets/${id}`);
+
+// Re-permission (re-opt-in) runs.
+export const getRepermissions = async () => http.get('/api/repermissions', { loading: models.campaigns });
+
+export const getRepermissionTargets = async (listIDs) => http.get(
+  '/api/repermissions/targets',
+  { params: { list_id: listIDs } },
+);
+
+export const createRepermission = async (data) => http.post(
+  '/api/repermissions',
+  data,
+  { loading: models.campaigns },
+);
+
+export const updateRepermission = async (id, data) => http.put(
+  `/api/repermissions/${id}`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const cancelRepermission = async (id) => http.delete(
+  `/api/repermissions/${id}`,
+  { loading: models.campaigns },
+);
+
+export const getCampaignRevisions = async (id, params) => http.get(`/api/campaigns/${id}/revisions`, { params });
+
+export const getCampaignRevision = async (id, revID) => http.get(`/api/campaigns/${id}/revisions/${revID}`, {});
+
+export const getCampaignRevisionDiff = async (id, revID, params) => http.get(
+  `/api/campaigns/${id}/revisions/${revID}/diff`,
+  { params, loading: models.campaigns },
+);
+
+export const restoreCampaignRevision = async (id, revID) => http.post(
+  `/api/campaigns/${id}/revisions/${revID}/restore`,
+  {},
   { loading: models.campaigns },
 );
 
@@ -338,6 +507,26 @@ export const updateCampaignArchive = async (id, data) => http.put(
   { loading: models.campaigns },
 );
 
+export const updateCampaignSimulate = async (id, simulate) => http.put(
+  `/api/campaigns/${id}/simulate`,
+  { simulate },
+  { loading: models.campaigns },
+);
+
+// Bulk campaign operations.
+export const deleteCampaigns = async (params) => http.delete(
+  '/api/campaigns',
+  { params, loading: models.campaigns },
+);
+
+export const updateCampaignsTags = async (data) => http.put('/api/campaigns/tags', data, { loading: models.campaigns });
+
+export const updateCampaignsArchive = async (data) => http.put('/api/campaigns/archive', data, { loading: models.campaigns });
+
+export const updateCampaignsStatus = async (data) => http.put('/api/campaigns/status', data, { loading: models.campaigns });
+
+export const updateCampaignsTemplate = async (data) => http.put('/api/campaigns/template', data, { loading: models.campaigns });
+
 export const deleteCampaign = async (id) => http.delete(
   `/api/campaigns/${id}`,
   { loading: models.campaigns },
@@ -375,7 +564,7 @@ export const getTemplates = async () => http.get(
 export const updateTemplate = async (data) => http.put(
   `/api/templates/${data.id}`,
   data,
-  { loading: models.templates },
+  { loading: models.templates, headers: data.version ? { 'If-Match': `"${data.version}"` } : {} },
 );
 
 export const makeTemplateDefault = async (id) => http.put(
@@ -400,18 +589,54 @@ export const getSettings = async () => http.get(
   { loading: models.settings, store: models.settings, camelCase: false },
 );
 
+export const previewSettings = async (data) => http.post(
+  '/api/settings/preview',
+  data,
+  { loading: models.settings },
+);
+
 export const updateSettings = async (data) => http.put(
   '/api/settings',
   data,
   { loading: models.settings },
 );
 
+export const updateSendingHalt = async (halted) => http.put(
+  '/api/settings/halt',
+  { halted },
+  { loading: models.settings },
+);
+
 export const testSMTP = async (data) => http.post(
   '/api/settings/smtp/test',
   data,
   { loading: models.settings, disableToast: true },
 );
 
+export const getMXStats = async () => http.get('/api/settings/smtp/mx', {});
+
+export const previewPublicPage = async (data) => http.post(
+  '/api/settings/appearance/preview',
+  data,
+  { disableToast: true },
+);
+
+export const getSuppressionSyncs = async () => http.get('/api/settings/suppression/syncs', {});
+
+export const getSuppressionSync = async (id) => http.get(`/api/settings/suppression/syncs/${id}`, {});
+
+export const updateSuppressionSync = async (id, action) => http.put(
+  `/api/settings/suppression/syncs/${id}/${action}`,
+  {},
+  { loading: models.settings },
+);
+
+export const runSuppressionSync = async (uuid) => http.post(
+  `/api/settings/suppression/sources/${uuid}/sync`,
+  {},
+  { loading: models.settings },
+);
+
 export const getLogs = async () => http.get(
   '/api/logs',
   { loading: models.logs, camelCase: false },
@@ -527,3 +752,47 @@ export const deleteRole = (id) => http.delete(
   `/api/roles/${id}`,
   { loading: models.userRoles },
 );
+
+// Reports.
+export const getReports = async () => http.get('/api/reports', { loading: models.reports });
+
+export const createReport = async (data) => http.post(
+  '/api/reports',
+  data,
+  { loading: models.reports },
+);
+
+export const updateReport = async (id, data) => http.put(
+  `/api/reports/${id}`,
+  data,
+  { loading: models.reports },
+);
+
+export const deleteReport = async (id) => http.delete(`/api/reports/${id}`, { loading: models.reports });
+
+export const runReport = async (id) => http.post(
+  `/api/reports/${id}/run`,
+  {},
+  { loading: models.reports, camelCase: (keyPath) => !keyPath.startsWith('.data') },
+);
+
+export const getReportResults = async (id) => http.get(`/api/reports/${id}/results`, {});
+
+export const getReportResult = async (id, resultID) => http.get(
+  `/api/reports/${id}/results/${resultID}`,
+  { loading: models.reports, camelCase: (keyPath) => !keyPath.startsWith('.data') },
+);
+
+// Data warehouse exports.
+export const getWarehouseExports = async () => http.get('/api/warehouse', { loading: models.warehouse });
+
+export const runWarehouseExport = async (table) => http.post(
+  `/api/warehouse/${table}/run`,
+  {},
+  { loading: models.warehouse },
+);
+
+export const resetWarehouseExport = async (table) => http.delete(
+  `/api/warehouse/${table}`,
+  { loading: models.warehouse },
+);
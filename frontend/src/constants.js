@@ -15,6 +15,8 @@ export const models = Object.freeze({
   settings: 'settings',
   logs: 'logs',
   maintenance: 'maintenance',
+  reports: 'reports',
+  warehouse: 'warehouse',
 });
 
 // Ad-hoc URIs that are used outside of vuex requests.
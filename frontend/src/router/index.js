@@ -89,6 +89,12 @@ const routes = [
     meta: { title: 'analytics.title', group: 'campaigns' },
     component: () => import('../views/CampaignAnalytics.vue'),
   },
+  {
+    path: '/campaigns/repermissions',
+    name: 'repermissions',
+    meta: { title: 'repermissions.title', group: 'campaigns' },
+    component: () => import('../views/Repermissions.vue'),
+  },
   {
     path: '/campaigns/:id',
     name: 'campaign',
@@ -131,6 +137,18 @@ const routes = [
     meta: { title: 'users.listRoles', group: 'users' },
     component: () => import('../views/Roles.vue'),
   },
+  {
+    path: '/settings/reports',
+    name: 'reports',
+    meta: { title: 'globals.terms.reports', group: 'settings' },
+    component: () => import('../views/Reports.vue'),
+  },
+  {
+    path: '/settings/warehouse',
+    name: 'warehouse',
+    meta: { title: 'warehouse.title', group: 'settings' },
+    component: () => import('../views/Warehouse.vue'),
+  },
   {
     path: '/settings/maintenance',
     name: 'maintenance',
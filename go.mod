@@ -34,6 +34,7 @@ require (
 	github.com/zerodha/easyjson v1.0.0
 	github.com/zerodha/simplesessions/stores/postgres/v3 v3.0.0
 	github.com/zerodha/simplesessions/v3 v3.0.0
+	golang.org/x/crypto v0.31.0
 	golang.org/x/mod v0.17.0
 	golang.org/x/oauth2 v0.13.0
 	golang.org/x/text v0.21.0
@@ -64,7 +65,6 @@ require (
 	github.com/spf13/cast v1.5.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
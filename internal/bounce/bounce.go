@@ -44,6 +44,10 @@ type Opt struct {
 	}
 
 	RecordBounceCB func(models.Bounce) error
+
+	// IsLeader, if set, is checked before every mailbox scan so that only
+	// one of multiple instances sharing the DB scans the mailbox.
+	IsLeader func() bool
 }
 
 // Manager handles e-mail bounces.
@@ -139,8 +143,10 @@ func (m *Manager) Run() {
 // runMailboxScanner runs a blocking loop that scans the mailbox at given intervals.
 func (m *Manager) runMailboxScanner() {
 	for {
-		if err := m.mailbox.Scan(1000, m.queue); err != nil {
-			m.log.Printf("error scanning bounce mailbox: %v", err)
+		if m.opt.IsLeader == nil || m.opt.IsLeader() {
+			if err := m.mailbox.Scan(1000, m.queue); err != nil {
+				m.log.Printf("error scanning bounce mailbox: %v", err)
+			}
 		}
 
 		time.Sleep(m.opt.Mailbox.ScanInterval)
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// SyncStripeSubscription records a subscriber's Stripe subscription and
+// subscribes them to (or unsubscribes them from) the paid lists of the
+// subscription's prices depending on whether the subscription is active.
+func (c *Core) SyncStripeSubscription(subID int, id, customerID string, priceIDs []string, status string, active bool, periodEnd null.Time) error {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error beginning stripe subscription sync: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Stmtx(c.q.UpsertStripeSubscription).Exec(id, subID, customerID, pq.StringArray(priceIDs), status, periodEnd); err != nil {
+		c.log.Printf("error recording stripe subscription: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	if _, err := tx.Stmtx(c.q.SyncPaidListSubscriptions).Exec(subID, pq.StringArray(priceIDs), active, models.SourceStripe); err != nil {
+		c.log.Printf("error syncing paid list subscriptions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error committing stripe subscription sync: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetStripeSubscriptions returns a subscriber's Stripe subscriptions.
+func (c *Core) GetStripeSubscriptions(subID int) ([]models.StripeSubscription, error) {
+	out := []models.StripeSubscription{}
+	if err := c.q.GetStripeSubscriptions.Select(&out, subID); err != nil {
+		c.log.Printf("error fetching stripe subscriptions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
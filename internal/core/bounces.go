@@ -4,16 +4,17 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 )
 
-var bounceQuerySortFields = []string{"email", "campaign_name", "source", "created_at"}
+var bounceQuerySortFields = []string{"id", "email", "campaign_name", "source", "created_at"}
 
 // QueryBounces retrieves paginated bounce entries based on the given params.
 // It also returns the total number of bounce records in the DB.
-func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, offset, limit int) ([]models.Bounce, int, error) {
+func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, afterID, offset, limit int) ([]models.Bounce, int, error) {
 	if !strSliceContains(orderBy, bounceQuerySortFields) {
 		orderBy = "created_at"
 	}
@@ -23,6 +24,7 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 
 	out := []models.Bounce{}
 	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", orderBy+" "+order)
+	stmt = strings.ReplaceAll(stmt, "%cursor%", cursorExp("bounces.id", afterID, order))
 	if err := c.db.Select(&out, stmt, 0, campID, subID, source, offset, limit); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
@@ -41,6 +43,7 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 func (c *Core) GetBounce(id int) (models.Bounce, error) {
 	var out []models.Bounce
 	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", "id "+SortAsc)
+	stmt = strings.ReplaceAll(stmt, "%cursor%", "")
 	if err := c.db.Select(&out, stmt, id, 0, 0, "", 0, 1); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return models.Bounce{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -56,6 +59,18 @@ func (c *Core) GetBounce(id int) (models.Bounce, error) {
 	return out[0], nil
 }
 
+// GetCampaignBounceRates retrieves the bounce and complaint counts of running campaigns.
+func (c *Core) GetCampaignBounceRates() ([]models.CampaignBounceRate, error) {
+	out := []models.CampaignBounceRate{}
+	if err := c.q.GetCampaignBounceRates.Select(&out); err != nil {
+		c.log.Printf("error fetching campaign bounce rates: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // RecordBounce records a new bounce.
 func (c *Core) RecordBounce(b models.Bounce) error {
 	action, ok := c.consts.BounceActions[b.Type]
@@ -81,9 +96,20 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		}
 
 		c.log.Printf("error recording bounce: %v", err)
+		return err
 	}
 
-	return err
+	c.RecordEvent(models.EventLogBounce, c.i18n.Ts("events.bounce", "type", b.Type, "email", b.Email),
+		models.JSON{"email": b.Email, "type": b.Type, "source": b.Source, "campaign_uuid": b.CampaignUUID}, 0)
+	c.publishEvent(eventbus.TypeBounce, eventbus.Bounce{
+		Email:          b.Email,
+		SubscriberUUID: b.SubscriberUUID,
+		CampaignUUID:   b.CampaignUUID,
+		Type:           b.Type,
+		Source:         b.Source,
+	})
+
+	return nil
 }
 
 // DeleteBounce deletes a list.
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	CampaignBodyStorageDB    = "db"
+	CampaignBodyStorageMedia = "media"
+
+	// campaignBodySearchLen is the number of characters of a campaign body that
+	// are full-text indexed (idx_camps_search).
+	campaignBodySearchLen = 100000
+)
+
+// packCampaignBody compresses a campaign body that's larger than the configured
+// size. Depending on the body storage, the compressed body is either returned
+// to be stored in the DB (body_gz), or uploaded to the media store, whose object
+// name is returned (body_ref). Smaller bodies are returned as they are.
+func (c *Core) packCampaignBody(body string) (string, []byte, string, error) {
+	if c.consts.CampaignBodyCompressSize <= 0 || len(body) <= c.consts.CampaignBodyCompressSize {
+		return body, nil, "", nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(body)); err != nil {
+		return "", nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", nil, "", err
+	}
+
+	if c.consts.CampaignBodyStorage != CampaignBodyStorageMedia || c.bodyStore == nil {
+		return "", buf.Bytes(), "", nil
+	}
+
+	// Random, hard to guess object names as the media store may be public.
+	uu, err := uuid.NewV4()
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	ref, err := c.bodyStore.Put(fmt.Sprintf("campaign_body_%s.gz", uu), "application/gzip", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	return "", nil, ref, nil
+}
+
+// campaignBodySearch returns the text that's stored in body_search for full-text
+// search of a campaign body that's stored compressed, as its body column is empty.
+func campaignBodySearch(body, packed string) string {
+	if packed != "" {
+		return ""
+	}
+
+	r := []rune(body)
+	if len(r) > campaignBodySearchLen {
+		r = r[:campaignBodySearchLen]
+	}
+	return string(r)
+}
+
+// LoadCampaignBody loads a campaign's body that's stored compressed in the DB
+// or in the media store into Body. Bodies that aren't compressed are left as
+// they are.
+func (c *Core) LoadCampaignBody(camp *models.Campaign) error {
+	var b []byte
+	switch {
+	case camp.BodyRef != "":
+		if c.bodyStore == nil {
+			return fmt.Errorf("no media store to load the campaign body '%s' from", camp.BodyRef)
+		}
+
+		blob, err := c.bodyStore.GetBlob(camp.BodyRef)
+		if err != nil {
+			return err
+		}
+		b = blob
+	case len(camp.BodyGz) > 0:
+		b = camp.BodyGz
+	default:
+		return nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		return err
+	}
+
+	camp.Body = string(body)
+	camp.BodyGz = nil
+
+	return nil
+}
+
+// loadCampaignBody loads a campaign's compressed body and returns an HTTP error
+// on failure.
+func (c *Core) loadCampaignBody(camp *models.Campaign) error {
+	if err := c.LoadCampaignBody(camp); err != nil {
+		c.log.Printf("error loading campaign body: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return nil
+}
+
+// loadCampaignBodies loads the compressed bodies of the given campaigns.
+func (c *Core) loadCampaignBodies(camps models.Campaigns) error {
+	for i := range camps {
+		if err := c.loadCampaignBody(&camps[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getCampaignBodyRef returns the media store object of a campaign's body, if
+// it's not shared with any other campaign (eg: a retry).
+func (c *Core) getCampaignBodyRef(id int) (string, error) {
+	var out struct {
+		BodyRef string `db:"body_ref"`
+		Shared  bool   `db:"shared"`
+	}
+	if err := c.q.GetCampaignBodyRef.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if out.Shared {
+		return "", nil
+	}
+
+	return out.BodyRef, nil
+}
+
+// deleteCampaignBodies deletes campaign bodies from the media store.
+func (c *Core) deleteCampaignBodies(refs []string) {
+	if c.bodyStore == nil {
+		return
+	}
+
+	for _, r := range refs {
+		if err := c.bodyStore.Delete(r); err != nil {
+			c.log.Printf("error deleting campaign body %s: %v", r, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// FollowupQuery returns the subscriber query expression that picks the openers
+// or clickers of a follow-up campaign's parent. It's a no-op for campaigns that
+// aren't follow-ups.
+func FollowupQuery(campID int) string {
+	return fmt.Sprintf(`(NOT EXISTS (SELECT 1 FROM campaign_followups WHERE campaign_id = %d) OR subscribers.id IN (
+		SELECT cv.subscriber_id FROM campaign_followups f JOIN campaign_views cv ON (cv.campaign_id = f.parent_id)
+			WHERE f.campaign_id = %d AND f.audience = 'openers'
+		UNION
+		SELECT lc.subscriber_id FROM campaign_followups f JOIN link_clicks lc ON (lc.campaign_id = f.parent_id)
+			JOIN links ON (links.id = lc.link_id)
+			WHERE f.campaign_id = %d AND f.audience = 'clickers' AND (f.url = '' OR links.url = f.url)
+	))`, campID, campID, campID)
+}
+
+// GetCampaignFollowups returns the chain of follow-ups below a campaign with
+// their audience sizes and send and engagement counts.
+func (c *Core) GetCampaignFollowups(id int) ([]models.CampaignFollowup, error) {
+	out := []models.CampaignFollowup{}
+	if err := c.q.GetCampaignFollowups.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign follow-ups: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.followups}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpsertCampaignFollowup makes a campaign a follow-up of another campaign, or
+// updates it if it already is one.
+func (c *Core) UpsertCampaignFollowup(parentID int, o models.CampaignFollowup) error {
+	var ids []int
+	if err := c.q.UpsertCampaignFollowup.Select(&ids, parentID, o.CampaignID, o.Audience, o.URL, o.DelayDays); err != nil {
+		c.log.Printf("error saving campaign follow-up: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.followups}", "error", pqErrMsg(err)))
+	}
+
+	// The parent is the follow-up campaign itself or one of its follow-ups.
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.followupLoop"))
+	}
+
+	return nil
+}
+
+// DeleteCampaignFollowup removes a follow-up from a campaign's chain. The
+// follow-up campaign itself is left as it is.
+func (c *Core) DeleteCampaignFollowup(parentID, campID int) error {
+	res, err := c.q.DeleteCampaignFollowup.Exec(parentID, campID)
+	if err != nil {
+		c.log.Printf("error deleting campaign follow-up: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{campaigns.followups}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.followups}"))
+	}
+
+	return nil
+}
+
+// ScheduleCampaignFollowups schedules the follow-ups of campaigns that have
+// finished and cancels those of campaigns that were cancelled or deleted.
+// The follow-ups that were updated are returned.
+func (c *Core) ScheduleCampaignFollowups() ([]models.CampaignFollowup, error) {
+	out := []models.CampaignFollowup{}
+	if err := c.q.ScheduleCampaignFollowups.Select(&out); err != nil {
+		c.log.Printf("error scheduling campaign follow-ups: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetCampaignPresets returns all campaign presets without their bodies.
+func (c *Core) GetCampaignPresets() ([]models.CampaignPreset, error) {
+	out := []models.CampaignPreset{}
+	if err := c.q.GetCampaignPresets.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching campaign presets: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.presets}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignPreset returns a campaign preset.
+func (c *Core) GetCampaignPreset(id int) (models.CampaignPreset, error) {
+	var out []models.CampaignPreset
+	if err := c.q.GetCampaignPresets.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign preset: %v", err)
+		return models.CampaignPreset{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.preset}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.CampaignPreset{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.preset}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateCampaignPreset creates a new campaign preset.
+func (c *Core) CreateCampaignPreset(o models.CampaignPreset) (models.CampaignPreset, error) {
+	o = presetDefaults(o)
+
+	var newID int
+	if err := c.q.CreateCampaignPreset.Get(&newID, o.Name, o.Description, o.Subject, o.Preheader,
+		o.FromEmail, o.Body, o.AltBody, o.ContentType, o.TemplateID, o.Headers, o.Messenger,
+		pq.StringArray(normalizeTags(o.Tags)), o.ListIDs, o.UTM); err != nil {
+		c.log.Printf("error creating campaign preset: %v", err)
+		return models.CampaignPreset{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{campaigns.preset}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetCampaignPreset(newID)
+}
+
+// UpdateCampaignPreset updates a campaign preset.
+func (c *Core) UpdateCampaignPreset(id int, o models.CampaignPreset) (models.CampaignPreset, error) {
+	o = presetDefaults(o)
+
+	res, err := c.q.UpdateCampaignPreset.Exec(id, o.Name, o.Description, o.Subject, o.Preheader,
+		o.FromEmail, o.Body, o.AltBody, o.ContentType, o.TemplateID, o.Headers, o.Messenger,
+		pq.StringArray(normalizeTags(o.Tags)), o.ListIDs, o.UTM)
+	if err != nil {
+		c.log.Printf("error updating campaign preset: %v", err)
+		return models.CampaignPreset{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.preset}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.CampaignPreset{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.preset}"))
+	}
+
+	return c.GetCampaignPreset(id)
+}
+
+// DeleteCampaignPreset deletes a campaign preset.
+func (c *Core) DeleteCampaignPreset(id int) error {
+	res, err := c.q.DeleteCampaignPreset.Exec(id)
+	if err != nil {
+		c.log.Printf("error deleting campaign preset: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{campaigns.preset}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.preset}"))
+	}
+
+	return nil
+}
+
+// presetDefaults replaces the nil values of a preset's NOT NULL array fields.
+func presetDefaults(o models.CampaignPreset) models.CampaignPreset {
+	if o.Headers == nil {
+		o.Headers = models.Headers{}
+	}
+	if o.ListIDs == nil {
+		o.ListIDs = pq.Int64Array{}
+	}
+
+	return o
+}
@@ -1,8 +1,12 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -23,8 +27,10 @@ const (
 
 // QueryCampaigns retrieves paginated campaigns optionally filtering them by the given arbitrary
 // query expression. It also returns the total number of records in the DB.
-func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy, order string, offset, limit int) (models.Campaigns, int, error) {
+// If getAll is false, only the campaigns whose lists are all in permittedListIDs are returned.
+func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, folderID int, orderBy, order string, getAll bool, permittedListIDs []int, withBody bool, afterID, offset, limit int) (models.Campaigns, int, error) {
 	queryStr, stmt := makeSearchQuery(searchStr, orderBy, order, c.q.QueryCampaigns, campQuerySortFields)
+	stmt = strings.ReplaceAll(stmt, "%cursor%", cursorExp("c.id", afterID, order))
 
 	if statuses == nil {
 		statuses = []string{}
@@ -36,12 +42,19 @@ func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy
 
 	// Unsafe to ignore scanning fields not present in models.Campaigns.
 	var out models.Campaigns
-	if err := c.db.Select(&out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, offset, limit); err != nil {
+	if err := c.db.Select(&out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, offset, limit, folderID, getAll, pq.Array(permittedListIDs), withBody, makeLikeQuery(searchStr)); err != nil {
 		c.log.Printf("error fetching campaigns: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	// Compressed bodies are only loaded if they're asked for.
+	if withBody {
+		if err := c.loadCampaignBodies(out); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	for i := 0; i < len(out); i++ {
 		// Replace null tags.
 		if out[i].Tags == nil {
@@ -64,6 +77,160 @@ func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy
 	return out, total, nil
 }
 
+// HasCampaignLists checks whether all the lists that the given campaigns target
+// are in listIDs, and returns the result by campaign ID.
+func (c *Core) HasCampaignLists(campIDs []int, listIDs []int) (map[int]bool, error) {
+	res := []struct {
+		CampID int  `db:"campaign_id"`
+		Has    bool `db:"has"`
+	}{}
+
+	if err := c.q.HasCampaignLists.Select(&res, pq.Array(campIDs), pq.Array(listIDs)); err != nil {
+		c.log.Printf("error fetching campaign lists: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	out := make(map[int]bool, len(res))
+	for _, r := range res {
+		out[r.CampID] = r.Has
+	}
+
+	return out, nil
+}
+
+// GetCampaignSendPlan resolves the audience of a campaign without sending it and
+// returns the recipient counts per list and the top n recipient domains.
+func (c *Core) GetCampaignSendPlan(camp models.Campaign, numDomains int) (models.CampaignSendPlan, error) {
+	var subQuery, exp string
+	if camp.SubscriberQueryID.Valid {
+		q, err := c.GetSubscriberQuery(int(camp.SubscriberQueryID.Int), 0, true)
+		if err != nil {
+			return models.CampaignSendPlan{}, err
+		}
+		subQuery = q.Query
+		exp = " AND " + subQuery
+	}
+
+	// Retries only go to the soft-bounced recipients of the campaign they retry.
+	if camp.RetryAttempt > 0 {
+		exp += " AND " + RetryQuery(int(camp.RetryOf.Int))
+	}
+
+	// Follow-ups only go to the openers or clickers of their parent campaigns.
+	exp += " AND " + FollowupQuery(camp.ID)
+	stmt := strings.ReplaceAll(c.q.GetCampaignSendPlan, "%query%", exp)
+
+	// The saved query is an arbitrary expression.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing campaign send plan: %v", err)
+		return models.CampaignSendPlan{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var out models.CampaignSendPlan
+	if err := tx.Get(&out, stmt, camp.ID, camp.Type, numDomains); err != nil {
+		c.log.Printf("error fetching campaign send plan: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	out.SubscriberQuery = subQuery
+
+	return out, nil
+}
+
+// UpdateCampaignQueryToSend counts the recipients of a campaign that targets a
+// saved subscriber query and sets its to_send, as the list based counts of
+// create-campaign and next-campaigns don't apply the query.
+func (c *Core) UpdateCampaignQueryToSend(camp *models.Campaign) error {
+	if !camp.SubscriberQueryID.Valid {
+		return nil
+	}
+
+	q, err := c.GetSubscriberQuery(int(camp.SubscriberQueryID.Int), 0, true)
+	if err != nil {
+		return err
+	}
+
+	exp := " AND " + q.Query
+	if camp.RetryAttempt > 0 {
+		exp += " AND " + RetryQuery(int(camp.RetryOf.Int))
+	}
+	exp += " AND " + FollowupQuery(camp.ID)
+	stmt := strings.ReplaceAll(c.q.CountCampaignSubscribersByQuery, "%query%", exp)
+
+	// The saved query is an arbitrary expression.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing campaign subscriber count: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.Get(&n, stmt, camp.ID, camp.Type); err != nil {
+		c.log.Printf("error counting campaign subscribers: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if _, err := c.q.UpdateCampaignToSend.Exec(camp.ID, n); err != nil {
+		c.log.Printf("error updating campaign to_send: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	camp.ToSend = n
+
+	return nil
+}
+
+// RetryQuery returns the subscriber query expression that picks the soft-bounced
+// recipients of a campaign for a retry of it.
+func RetryQuery(campID int) string {
+	return fmt.Sprintf(`subscribers.id IN (SELECT subscriber_id FROM bounces WHERE campaign_id = %d AND type = 'soft')
+		AND subscribers.id NOT IN (SELECT subscriber_id FROM bounces WHERE campaign_id = %d AND type != 'soft')`, campID, campID)
+}
+
+// CreateCampaignRetry creates a copy of a campaign that's scheduled to be sent
+// at sendAt to the campaign's soft-bounced recipients.
+func (c *Core) CreateCampaignRetry(id int, name string, sendAt time.Time) (models.Campaign, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	var newID int
+	if err := c.q.CreateCampaignRetry.Get(&newID, id, uu, name, sendAt); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error creating campaign retry: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetCampaign(newID, "", "")
+}
+
+// GetCampaignRetries returns a campaign and the chain of its soft-bounce retries
+// with their send, bounce, and engagement counts.
+func (c *Core) GetCampaignRetries(id int) ([]models.CampaignRetry, error) {
+	out := []models.CampaignRetry{}
+	if err := c.q.GetCampaignRetries.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign retries: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // GetCampaign retrieves a campaign.
 func (c *Core) GetCampaign(id int, uuid, archiveSlug string) (models.Campaign, error) {
 	return c.getCampaign(id, uuid, archiveSlug, campaignTplDefault)
@@ -114,6 +281,10 @@ func (c *Core) getCampaign(id int, uuid, archiveSlug string, tplType string) (mo
 		}
 	}
 
+	if err := c.loadCampaignBodies(out); err != nil {
+		return models.Campaign{}, err
+	}
+
 	// Lazy load stats.
 	if err := out.LoadStats(c.q.GetCampaignStats); err != nil {
 		c.log.Printf("error fetching campaign stats: %v", err)
@@ -138,18 +309,57 @@ func (c *Core) GetCampaignForPreview(id, tplID int) (models.Campaign, error) {
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	if err := c.loadCampaignBody(&out); err != nil {
+		return models.Campaign{}, err
+	}
+
 	return out, nil
 }
 
-// GetArchivedCampaigns retrieves campaigns with a template body.
-func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, error) {
+// GetArchivedCampaigns retrieves campaigns with a template body. If listUUID is
+// given, only the campaigns sent to that list are retrieved.
+func (c *Core) GetArchivedCampaigns(offset, limit int, listUUID string) (models.Campaigns, int, error) {
 	var out models.Campaigns
-	if err := c.q.GetArchivedCampaigns.Select(&out, offset, limit, campaignTplArchive); err != nil {
+	if err := c.q.GetArchivedCampaigns.Select(&out, offset, limit, campaignTplArchive, listUUID); err != nil {
 		c.log.Printf("error fetching public campaigns: %v", err)
 		return models.Campaigns{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	if err := c.loadCampaignBodies(out); err != nil {
+		return models.Campaigns{}, 0, err
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// SearchCampaignArchives runs a ranked full-text search of the sent campaigns,
+// or only the archived ones, returning them with snippets of their bodies.
+// Campaigns are optionally filtered to the ones sent to a list (UUID) and by
+// the given list IDs unless getAll is set.
+func (c *Core) SearchCampaignArchives(query string, archivedOnly bool, listUUID string, getAll bool, listIDs []int, offset, limit int) ([]models.ArchiveSearchResult, int, error) {
+	out := []models.ArchiveSearchResult{}
+
+	tsq := makeTSQuery(query)
+	if tsq == "" {
+		return out, 0, nil
+	}
+
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+
+	if err := c.q.SearchCampaignArchives.Select(&out, tsq, archivedOnly, listUUID, getAll, pq.Array(listIDs), offset, limit); err != nil {
+		c.log.Printf("error searching campaign archives: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
 	total := 0
 	if len(out) > 0 {
 		total = out[0].Total
@@ -167,6 +377,14 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
 	}
 
+	// Large bodies are stored compressed.
+	body, bodyGz, bodyRef, err := c.packCampaignBody(o.Body)
+	if err != nil {
+		c.log.Printf("error compressing campaign body: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
 	// Insert and read ID.
 	var newID int
 	if err := c.q.CreateCampaign.Get(&newID,
@@ -175,7 +393,7 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.Name,
 		o.Subject,
 		o.FromEmail,
-		o.Body,
+		body,
 		o.AltBody,
 		o.ContentType,
 		o.SendAt,
@@ -189,10 +407,28 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
+		o.SubscriberQueryID,
+		o.FolderID,
+		o.ListGroupIDs,
+		o.AttachmentURLs,
+		o.Event,
+		o.Preheader,
+		o.Variants,
+		bodyGz,
+		bodyRef,
+		o.SendAtTZ,
+		campaignBodySearch(o.Body, body),
 	); err != nil {
+		if bodyRef != "" {
+			c.deleteCampaignBodies([]string{bodyRef})
+		}
+
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
 		}
+		if isArchiveSlugConflict(err) {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.archiveSlugExists"))
+		}
 
 		c.log.Printf("error creating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -204,16 +440,36 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		return models.Campaign{}, err
 	}
 
+	if err := c.UpdateCampaignQueryToSend(&out); err != nil {
+		return models.Campaign{}, err
+	}
+
 	return out, nil
 }
 
 // UpdateCampaign updates a campaign.
 func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
-	_, err := c.q.UpdateCampaign.Exec(id,
+	// The body that's being replaced, to be deleted from the media store.
+	oldRef, err := c.getCampaignBodyRef(id)
+	if err != nil {
+		c.log.Printf("error fetching campaign body: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	// Large bodies are stored compressed.
+	body, bodyGz, bodyRef, err := c.packCampaignBody(o.Body)
+	if err != nil {
+		c.log.Printf("error compressing campaign body: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	if _, err := c.q.UpdateCampaign.Exec(id,
 		o.Name,
 		o.Subject,
 		o.FromEmail,
-		o.Body,
+		body,
 		o.AltBody,
 		o.ContentType,
 		o.SendAt,
@@ -226,18 +482,43 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveSlug,
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
-		pq.Array(mediaIDs))
-	if err != nil {
+		pq.Array(mediaIDs),
+		o.SubscriberQueryID,
+		o.ListGroupIDs,
+		o.AttachmentURLs,
+		o.Event,
+		o.Preheader,
+		o.Variants,
+		bodyGz,
+		bodyRef,
+		o.SendAtTZ,
+		campaignBodySearch(o.Body, body)); err != nil {
+		if bodyRef != "" {
+			c.deleteCampaignBodies([]string{bodyRef})
+		}
+
+		if isArchiveSlugConflict(err) {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.archiveSlugExists"))
+		}
+
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	if oldRef != "" && oldRef != bodyRef {
+		c.deleteCampaignBodies([]string{oldRef})
+	}
+
 	out, err := c.GetCampaign(id, "", "")
 	if err != nil {
 		return models.Campaign{}, err
 	}
 
+	if err := c.UpdateCampaignQueryToSend(&out); err != nil {
+		return models.Campaign{}, err
+	}
+
 	return out, nil
 }
 
@@ -266,8 +547,13 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 		if cm.Status != models.CampaignStatusPaused && cm.Status != models.CampaignStatusDraft {
 			errMsg = c.i18n.T("campaigns.onlyPausedDraft")
 		}
+		if cm.Outbox == models.CampaignOutboxPending {
+			errMsg = c.i18n.T("campaigns.outboxPending")
+		}
 	case models.CampaignStatusPaused:
-		if cm.Status != models.CampaignStatusRunning {
+		// A campaign paused by a circuit breaker can be paused by hand so
+		// that it isn't resumed when the messenger recovers.
+		if cm.Status != models.CampaignStatusRunning && !(cm.Status == models.CampaignStatusPaused && cm.PausedByBreaker != "") {
 			errMsg = c.i18n.T("campaigns.onlyActivePause")
 		}
 	case models.CampaignStatusCancelled:
@@ -300,6 +586,9 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 // UpdateCampaignArchive updates a campaign's archive properties.
 func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta models.JSON, archiveSlug string) error {
 	if _, err := c.q.UpdateCampaignArchive.Exec(id, enabled, archiveSlug, tplID, meta); err != nil {
+		if isArchiveSlugConflict(err) {
+			return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.archiveSlugExists"))
+		}
 		c.log.Printf("error updating campaign: %v", err)
 
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -309,17 +598,32 @@ func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta model
 	return nil
 }
 
-// DeleteCampaign deletes a campaign.
-func (c *Core) DeleteCampaign(id int) error {
-	res, err := c.q.DeleteCampaign.Exec(id)
+// UpdateCampaignSimulate switches a campaign that hasn't started in or out of
+// simulation, where its messages are discarded instead of delivered.
+func (c *Core) UpdateCampaignSimulate(id int, simulate bool) error {
+	res, err := c.q.UpdateCampaignSimulate.Exec(id, simulate)
 	if err != nil {
-		c.log.Printf("error deleting campaign: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+		c.log.Printf("error updating campaign: %v", err)
 
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
 	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.onlyDraftSimulate"))
+	}
+
+	return nil
+}
+
+// DeleteCampaign deletes a campaign.
+func (c *Core) DeleteCampaign(id int) error {
+	n, err := c.DeleteCampaigns([]int{id})
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
 	}
@@ -327,6 +631,50 @@ func (c *Core) DeleteCampaign(id int) error {
 	return nil
 }
 
+// DeleteCampaigns deletes one or more campaigns and returns the number of campaigns deleted.
+func (c *Core) DeleteCampaigns(ids []int) (int, error) {
+	res, err := c.q.DeleteCampaigns.Exec(pq.Array(ids))
+	if err != nil {
+		c.log.Printf("error deleting campaigns: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// UpdateCampaignsTags adds, removes, or replaces (action = add|remove|set) tags on
+// one or more campaigns and returns the number of campaigns updated.
+func (c *Core) UpdateCampaignsTags(ids []int, action string, tags []string) (int, error) {
+	return c.updateCampaigns(c.q.UpdateCampaignsTags, pq.Array(ids), action, pq.StringArray(normalizeTags(tags)))
+}
+
+// UpdateCampaignsArchive publishes or unpublishes one or more campaigns on the
+// public archive and returns the number of campaigns updated.
+func (c *Core) UpdateCampaignsArchive(ids []int, archive bool) (int, error) {
+	return c.updateCampaigns(c.q.UpdateCampaignsArchive, pq.Array(ids), archive)
+}
+
+// UpdateCampaignsTemplate sets the template on one or more campaigns that
+// aren't running and returns the number of campaigns updated.
+func (c *Core) UpdateCampaignsTemplate(ids []int, templateID int) (int, error) {
+	return c.updateCampaigns(c.q.UpdateCampaignsTemplate, pq.Array(ids), templateID)
+}
+
+// updateCampaigns runs a bulk campaign update query and returns the number of rows updated.
+func (c *Core) updateCampaigns(stmt *sqlx.Stmt, args ...interface{}) (int, error) {
+	res, err := stmt.Exec(args...)
+	if err != nil {
+		c.log.Printf("error updating campaigns: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 // GetRunningCampaignStats returns the progress stats of running campaigns.
 func (c *Core) GetRunningCampaignStats() ([]models.CampaignStats, error) {
 	out := []models.CampaignStats{}
@@ -385,35 +733,139 @@ func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate st
 	return out, nil
 }
 
-// RegisterCampaignView registers a subscriber's view on a campaign.
-func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
-	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "campaign_id" {
-			return nil
+// GetCampaignComparison returns performance metrics and the top numLinks clicked
+// links of the given campaigns, in the order of the given IDs.
+func (c *Core) GetCampaignComparison(campIDs []int, numLinks int) ([]models.CampaignComparison, error) {
+	var res []models.CampaignComparison
+	if err := c.q.GetCampaignComparison.Select(&res, pq.Array(campIDs)); err != nil {
+		c.log.Printf("error fetching campaign comparison: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	var links []struct {
+		CampaignID int `db:"campaign_id"`
+		models.CampaignAnalyticsLink
+	}
+	if err := c.q.GetCampaignTopLinks.Select(&links, pq.Array(campIDs), numLinks); err != nil {
+		c.log.Printf("error fetching campaign top links: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	camps := make(map[int]models.CampaignComparison, len(res))
+	for _, m := range res {
+		m.TopLinks = []models.CampaignAnalyticsLink{}
+		if m.Sent > 0 {
+			sent := float64(m.Sent)
+			m.OpenRate = float64(m.Views) / sent * 100
+			m.AdjustedOpenRate = float64(m.ViewsAdjusted) / sent * 100
+			m.ClickRate = float64(m.Clicks) / sent * 100
+			m.BounceRate = float64(m.Bounces) / sent * 100
+			m.UnsubscribeRate = float64(m.Unsubscribes) / sent * 100
+		}
+		camps[m.ID] = m
+	}
+	for _, l := range links {
+		if m, ok := camps[l.CampaignID]; ok {
+			m.TopLinks = append(m.TopLinks, l.CampaignAnalyticsLink)
+			camps[l.CampaignID] = m
+		}
+	}
+
+	out := make([]models.CampaignComparison, 0, len(camps))
+	for _, id := range campIDs {
+		if m, ok := camps[id]; ok {
+			out = append(out, m)
+			delete(camps, id)
 		}
+	}
+
+	return out, nil
+}
 
-		c.log.Printf("error registering campaign view: %s", err)
+// RegisterCampaignViews bulk inserts campaign views.
+func (c *Core) RegisterCampaignViews(views []models.CampaignView) error {
+	var (
+		campUUIDs = make([]string, len(views))
+		subUUIDs  = make([]string, len(views))
+		proxy     = make([]bool, len(views))
+		dates     = make([]string, len(views))
+	)
+	for i, v := range views {
+		campUUIDs[i] = v.CampaignUUID
+		subUUIDs[i] = v.SubscriberUUID
+		proxy[i] = v.Proxy
+		dates[i] = v.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	if _, err := c.q.RegisterCampaignViews.Exec(pq.Array(campUUIDs), pq.Array(subUUIDs), pq.Array(proxy), pq.Array(dates)); err != nil {
+		c.log.Printf("error registering campaign views: %s", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
+
 	return nil
 }
 
-// RegisterCampaignLinkClick registers a subscriber's link click on a campaign.
-func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (string, error) {
+// GetLinkURL returns the URL of a tracked link by its UUID.
+func (c *Core) GetLinkURL(linkUUID string) (string, error) {
 	var url string
-	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
+	if err := c.q.GetLinkURL.Get(&url, linkUUID); err != nil {
+		if err == sql.ErrNoRows {
 			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
 		}
 
-		c.log.Printf("error registering link click: %s", err)
+		c.log.Printf("error fetching link: %s", err)
 		return "", echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
 	}
 
 	return url, nil
 }
 
+// GetLinkSlug returns the link and campaign of a short link slug and the UUID
+// of the given subscriber ID, if there is one.
+func (c *Core) GetLinkSlug(slug string, subID int) (models.LinkSlug, error) {
+	var out models.LinkSlug
+	if err := c.q.GetLinkSlug.Get(&out, slug, subID); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
+		}
+
+		c.log.Printf("error fetching link slug: %s", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return out, nil
+}
+
+// RegisterLinkClicks bulk inserts link clicks. Clicks with a BotReason are
+// recorded separately as bot clicks that are excluded from campaign click stats.
+func (c *Core) RegisterLinkClicks(clicks []models.LinkClick) error {
+	var (
+		linkUUIDs = make([]string, len(clicks))
+		campUUIDs = make([]string, len(clicks))
+		subUUIDs  = make([]string, len(clicks))
+		reasons   = make([]string, len(clicks))
+		dates     = make([]string, len(clicks))
+	)
+	for i, l := range clicks {
+		linkUUIDs[i] = l.LinkUUID
+		campUUIDs[i] = l.CampaignUUID
+		subUUIDs[i] = l.SubscriberUUID
+		reasons[i] = l.BotReason
+		dates[i] = l.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	if _, err := c.q.RegisterLinkClicks.Exec(pq.Array(linkUUIDs), pq.Array(campUUIDs), pq.Array(subUUIDs),
+		pq.Array(reasons), pq.Array(dates)); err != nil {
+		c.log.Printf("error registering link clicks: %s", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
+
 // DeleteCampaignViews deletes campaign views older than a given date.
 func (c *Core) DeleteCampaignViews(before time.Time) error {
 	if _, err := c.q.DeleteCampaignViews.Exec(before); err != nil {
@@ -433,3 +885,203 @@ func (c *Core) DeleteCampaignLinkClicks(before time.Time) error {
 
 	return nil
 }
+
+// RecordCampaignRSVP records a subscriber's RSVP response to a campaign's calendar invite.
+func (c *Core) RecordCampaignRSVP(campUUID, subUUID, status string) error {
+	res, err := c.q.UpsertCampaignRSVP.Exec(campUUID, subUUID, status)
+	if err != nil {
+		c.log.Printf("error recording campaign RSVP: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+	}
+
+	return nil
+}
+
+// GetCampaignRSVPs returns the counts of RSVP responses to a campaign's calendar invite.
+func (c *Core) GetCampaignRSVPs(id int) (models.CampaignRSVPs, error) {
+	var out models.CampaignRSVPs
+	if err := c.q.GetCampaignRSVPs.Get(&out, id); err != nil {
+		c.log.Printf("error fetching campaign RSVPs: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignVariantStats returns the sent, view, and click counts of a campaign's language variants.
+func (c *Core) GetCampaignVariantStats(id int) ([]models.CampaignVariantStats, error) {
+	out := []models.CampaignVariantStats{}
+	if err := c.q.GetCampaignVariantStats.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign variant stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignDomainStats returns the sent, bounce, view, and click counts of the top
+// n recipient domains of a campaign.
+func (c *Core) GetCampaignDomainStats(id, n int) ([]models.CampaignDomainStats, error) {
+	out := []models.CampaignDomainStats{}
+	if err := c.q.GetCampaignDomainStats.Select(&out, id, n); err != nil {
+		c.log.Printf("error fetching campaign domain stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// InsertCampaignRevision records a revision of a kind (autosave, update) of a
+// campaign and prunes all but its latest maxRevisions revisions of the kind.
+func (c *Core) InsertCampaignRevision(campID int, kind string, data json.RawMessage, userID int, maxRevisions int) (models.CampaignRevision, error) {
+	var out models.CampaignRevision
+	if err := c.q.InsertCampaignRevision.Get(&out, campID, kind, data, userID, maxRevisions); err != nil {
+		c.log.Printf("error inserting campaign revision: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignRevisions returns the revisions of a campaign, optionally of a kind,
+// latest first, without their data.
+func (c *Core) GetCampaignRevisions(campID int, kind string) ([]models.CampaignRevision, error) {
+	out := []models.CampaignRevision{}
+	if err := c.q.GetCampaignRevisions.Select(&out, campID, 0, kind); err != nil {
+		c.log.Printf("error fetching campaign revisions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignChecklist returns the given items of a campaign's pre-send checklist.
+func (c *Core) GetCampaignChecklist(campID int, items []string) ([]models.CampaignChecklistItem, error) {
+	out := []models.CampaignChecklistItem{}
+	if err := c.q.GetCampaignChecklist.Select(&out, campID, pq.StringArray(items)); err != nil {
+		c.log.Printf("error fetching campaign checklist: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// SetCampaignChecklistItem checks or unchecks an item on a campaign's pre-send checklist.
+func (c *Core) SetCampaignChecklistItem(campID int, item string, done bool, userID int) error {
+	if _, err := c.q.SetCampaignChecklistItem.Exec(campID, item, done, userID); err != nil {
+		c.log.Printf("error updating campaign checklist: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignRevision returns a revision of a campaign with its data.
+func (c *Core) GetCampaignRevision(campID, id int) (models.CampaignRevision, error) {
+	var out []models.CampaignRevision
+	if err := c.q.GetCampaignRevisions.Select(&out, campID, id, ""); err != nil {
+		c.log.Printf("error fetching campaign revision: %v", err)
+		return models.CampaignRevision{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.CampaignRevision{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.revision}"))
+	}
+
+	return out[0], nil
+}
+
+// isArchiveSlugConflict checks whether an error is a violation of the unique
+// archive slug of campaigns that aren't in the trash.
+func isArchiveSlugConflict(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Constraint == "idx_camps_archive_slug"
+}
+
+// QueryOutboxMessages returns a page of a campaign's outbox messages (without
+// their bodies), optionally searched by the recipients' e-mails and names.
+func (c *Core) QueryOutboxMessages(campID int, query string, offset, limit int) ([]models.OutboxMessage, int, error) {
+	if query != "" {
+		query = "%" + query + "%"
+	}
+
+	out := []models.OutboxMessage{}
+	if err := c.q.QueryOutboxMessages.Select(&out, campID, 0, query, offset, limit); err != nil {
+		c.log.Printf("error fetching campaign outbox: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.outbox}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// GetOutboxMessage returns a message with its body from a campaign's outbox.
+func (c *Core) GetOutboxMessage(campID int, id int64) (models.OutboxMessage, error) {
+	var out []models.OutboxMessage
+	if err := c.q.QueryOutboxMessages.Select(&out, campID, id, "", 0, 1); err != nil {
+		c.log.Printf("error fetching campaign outbox message: %v", err)
+		return models.OutboxMessage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{campaigns.outbox}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.OutboxMessage{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{campaigns.outbox}"))
+	}
+
+	return out[0], nil
+}
+
+// ApproveCampaignOutbox approves a campaign's reviewed outbox and starts
+// sending its messages.
+func (c *Core) ApproveCampaignOutbox(id int) error {
+	res, err := c.q.ApproveCampaignOutbox.Exec(id)
+	if err != nil {
+		c.log.Printf("error approving campaign outbox: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.outbox}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.outboxNotPending"))
+	}
+
+	return nil
+}
+
+// RejectCampaignOutbox discards a campaign's reviewed outbox. The campaign
+// stays paused and is rendered for review again when it's started.
+func (c *Core) RejectCampaignOutbox(id int) error {
+	var n int
+	if err := c.q.RejectCampaignOutbox.Get(&n, id); err != nil {
+		c.log.Printf("error rejecting campaign outbox: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{campaigns.outbox}", "error", pqErrMsg(err)))
+	}
+
+	if n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.outboxNotPending"))
+	}
+
+	return nil
+}
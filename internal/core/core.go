@@ -10,9 +10,12 @@ import (
 	"log"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/seal"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
 )
@@ -34,7 +37,10 @@ type Core struct {
 	i18n   *i18n.I18n
 	db     *sqlx.DB
 	q      *models.Queries
+	seal   *seal.Seal
 	log    *log.Logger
+
+	bodyStore media.Store
 }
 
 // Constants represents constant config.
@@ -45,11 +51,31 @@ type Constants struct {
 		Action string
 	}
 	CacheSlowQueries bool
+
+	// Campaign bodies larger than this (in bytes) are stored compressed,
+	// in the DB or in the media store (CampaignBodyStorage). 0 disables it.
+	CampaignBodyCompressSize int
+	CampaignBodyStorage      string
+
+	// Saved SQL reports are run as ReportsDBRole in read-only transactions
+	// that are aborted after ReportsTimeout. Results are capped to
+	// ReportsMaxRows rows and the latest ReportsKeepResults are kept.
+	ReportsDBRole      string
+	ReportsTimeout     time.Duration
+	ReportsMaxRows     int
+	ReportsKeepResults int
 }
 
 // Hooks contains external function hooks that are required by the core package.
 type Hooks struct {
 	SendOptinConfirmation func(models.Subscriber, []int) (int, error)
+
+	// PublishEvent, if set, publishes an event on the event bus.
+	PublishEvent func(typ string, data interface{})
+
+	// ValidateEmailPolicy, if set, checks the e-mail of a new subscriber
+	// against the validation policy.
+	ValidateEmailPolicy func(email string) error
 }
 
 // Opt contains the controllers required to start the core.
@@ -59,13 +85,20 @@ type Opt struct {
 	DB        *sqlx.DB
 	Queries   *models.Queries
 	Log       *log.Logger
+
+	// Seal encrypts the secrets in the settings at rest. If it's nil,
+	// they're stored as plaintext.
+	Seal *seal.Seal
+
+	// BodyStore is the media store in which large campaign bodies are stored.
+	BodyStore media.Store
 }
 
 var (
-	regexFullTextQuery  = regexp.MustCompile(`\s+`)
+	regexTSQueryChars   = regexp.MustCompile(`[&|!():*<>'\\]`)
 	regexpSpaces        = regexp.MustCompile(`[\s]+`)
-	campQuerySortFields = []string{"name", "status", "created_at", "updated_at"}
-	subQuerySortFields  = []string{"email", "status", "name", "created_at", "updated_at"}
+	campQuerySortFields = []string{"id", "name", "status", "created_at", "updated_at"}
+	subQuerySortFields  = []string{"subscribers.id", "email", "status", "name", "created_at", "updated_at"}
 	listQuerySortFields = []string{"name", "status", "created_at", "updated_at", "subscriber_count"}
 )
 
@@ -77,7 +110,17 @@ func New(o *Opt, h *Hooks) *Core {
 		i18n:   o.I18n,
 		db:     o.DB,
 		q:      o.Queries,
+		seal:   o.Seal,
 		log:    o.Log,
+
+		bodyStore: o.BodyStore,
+	}
+}
+
+// publishEvent publishes an event on the event bus if it's enabled.
+func (c *Core) publishEvent(typ string, data interface{}) {
+	if c.h.PublishEvent != nil {
+		c.h.PublishEvent(typ, data)
 	}
 }
 
@@ -130,9 +173,7 @@ func pqErrMsg(err error) string {
 // query SQL statement (string interpolated) and returns the
 // search query string along with the SQL expression.
 func makeSearchQuery(searchStr, orderBy, order, query string, querySortFields []string) (string, string) {
-	if searchStr != "" {
-		searchStr = `%` + string(regexFullTextQuery.ReplaceAll([]byte(searchStr), []byte("&"))) + `%`
-	}
+	searchStr = makeTSQuery(searchStr)
 
 	// Sort params.
 	if !strSliceContains(orderBy, querySortFields) {
@@ -147,6 +188,49 @@ func makeSearchQuery(searchStr, orderBy, order, query string, querySortFields []
 	return searchStr, query
 }
 
+// cursorExp returns the keyset (cursor) pagination condition on the given ID
+// column that picks the rows after afterID in the sort order. It's empty if
+// there's no cursor.
+func cursorExp(col string, afterID int, order string) string {
+	if afterID <= 0 {
+		return ""
+	}
+
+	op := "<"
+	if order == SortAsc {
+		op = ">"
+	}
+	return fmt.Sprintf(" AND %s %s %d", col, op, afterID)
+}
+
+// makeTSQuery converts a free-form search string into a Postgres tsquery
+// expression that prefix-matches all the words in it.
+// eg: `hello wor` => `'hello':* & 'wor':*`
+func makeTSQuery(s string) string {
+	var out []string
+	for _, w := range strings.Fields(s) {
+		// Strip tsquery operators and quotes that would break the expression.
+		w = regexTSQueryChars.ReplaceAllString(w, "")
+		if w == "" {
+			continue
+		}
+		out = append(out, "'"+w+"':*")
+	}
+
+	return strings.Join(out, " & ")
+}
+
+// makeLikeQuery returns an ILIKE pattern that matches a search string anywhere
+// in a value. It's empty if the search string is.
+func makeLikeQuery(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
 // strSliceContains checks if a string is present in the string slice.
 func strSliceContains(str string, sl []string) bool {
 	for _, s := range sl {
@@ -176,6 +260,21 @@ func normalizeTags(tags []string) []string {
 	return out
 }
 
+// makeTagsArray normalizes the given tags for insertion into the DB.
+// nil tags remain nil (NULL) so that queries can retain existing tags.
+func makeTagsArray(tags []string) pq.StringArray {
+	if tags == nil {
+		return nil
+	}
+
+	out := normalizeTags(tags)
+	if out == nil {
+		out = []string{}
+	}
+
+	return pq.StringArray(out)
+}
+
 // sanitizeSQLExp does basic sanitisation on arbitrary
 // SQL query expressions coming from the frontend.
 func sanitizeSQLExp(q string) string {
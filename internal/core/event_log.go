@@ -0,0 +1,61 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// QueryEventLog retrieves event log entries of the given types (all if empty)
+// older than the given cursor ID (the latest if 0), newest first.
+func (c *Core) QueryEventLog(types []string, cursor int64, limit int) ([]models.EventLog, error) {
+	if types == nil {
+		types = []string{}
+	}
+
+	out := []models.EventLog{}
+	if err := c.q.QueryEventLog.Select(&out, pq.Array(types), cursor, limit); err != nil {
+		c.log.Printf("error fetching event log: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.events}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RecordEvent records an entry in the event log. userID is 0 for events that
+// are not triggered by a user. Errors are only logged as the event log should
+// never fail the action it records.
+func (c *Core) RecordEvent(typ, message string, meta interface{}, userID int) {
+	if meta == nil {
+		meta = models.JSON{}
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		c.log.Printf("error encoding event log meta: %v", err)
+		b = []byte("{}")
+	}
+
+	if _, err := c.q.InsertEventLog.Exec(typ, message, string(b), userID); err != nil {
+		c.log.Printf("error recording event log: %v", err)
+	}
+}
+
+// RecordCampaignEvent records a campaign status change in the event log.
+func (c *Core) RecordCampaignEvent(id int, name, status string, userID int) {
+	c.RecordEvent(models.EventLogCampaign,
+		c.i18n.Ts("events.campaignStatus", "name", name, "status", c.i18n.T("campaigns.status."+status)),
+		models.JSON{"campaign_id": id, "status": status}, userID)
+}
+
+// RecordCampaignErrorEvent records a campaign status change that was made by
+// the system due to an error in the event log.
+func (c *Core) RecordCampaignErrorEvent(id int, name, status string, e error) {
+	c.RecordEvent(models.EventLogCampaign,
+		c.i18n.Ts("events.campaignStatusError", "name", name, "status", c.i18n.T("campaigns.status."+status), "error", e.Error()),
+		models.JSON{"campaign_id": id, "status": status, "error": e.Error()}, 0)
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetEventRules returns all event rules with their trigger counts.
+func (c *Core) GetEventRules() ([]models.EventRule, error) {
+	out := []models.EventRule{}
+	if err := c.q.GetEventRules.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching event rules: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{events.rules}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetEventRule returns an event rule.
+func (c *Core) GetEventRule(id int) (models.EventRule, error) {
+	var out []models.EventRule
+	if err := c.q.GetEventRules.Select(&out, id); err != nil {
+		c.log.Printf("error fetching event rule: %v", err)
+		return models.EventRule{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{events.rule}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.EventRule{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{events.rule}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateEventRule creates a new event rule.
+func (c *Core) CreateEventRule(o models.EventRule) (models.EventRule, error) {
+	o = eventRuleDefaults(o)
+
+	var newID int
+	if err := c.q.CreateEventRule.Get(&newID, o.Name, o.Event, o.Enabled, o.TxTemplateID,
+		o.ListIDs, o.Tags, o.DedupMinutes); err != nil {
+		c.log.Printf("error creating event rule: %v", err)
+		return models.EventRule{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{events.rule}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetEventRule(newID)
+}
+
+// UpdateEventRule updates an event rule.
+func (c *Core) UpdateEventRule(id int, o models.EventRule) (models.EventRule, error) {
+	o = eventRuleDefaults(o)
+
+	res, err := c.q.UpdateEventRule.Exec(id, o.Name, o.Event, o.Enabled, o.TxTemplateID,
+		o.ListIDs, o.Tags, o.DedupMinutes)
+	if err != nil {
+		c.log.Printf("error updating event rule: %v", err)
+		return models.EventRule{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{events.rule}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.EventRule{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{events.rule}"))
+	}
+
+	return c.GetEventRule(id)
+}
+
+// DeleteEventRule deletes an event rule and its trigger log.
+func (c *Core) DeleteEventRule(id int) error {
+	res, err := c.q.DeleteEventRule.Exec(id)
+	if err != nil {
+		c.log.Printf("error deleting event rule: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{events.rule}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{events.rule}"))
+	}
+
+	return nil
+}
+
+// TriggerEventRules records an event for a subscriber and returns the rules that
+// were triggered by it. Rules that were already triggered for the subscriber
+// within their de-duplication windows are skipped. The tags of the triggered
+// rules are added to the subscriber.
+func (c *Core) TriggerEventRules(event string, subID int, payload models.JSON) ([]models.EventRule, error) {
+	if payload == nil {
+		payload = models.JSON{}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.invalidFields", "name", "data"))
+	}
+
+	out := []models.EventRule{}
+	if err := c.q.TriggerEventRules.Select(&out, event, subID, json.RawMessage(b)); err != nil {
+		c.log.Printf("error triggering event rules: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{events.rules}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// eventRuleDefaults replaces the nil values of an event rule's NOT NULL array fields.
+func eventRuleDefaults(o models.EventRule) models.EventRule {
+	if o.ListIDs == nil {
+		o.ListIDs = pq.Int64Array{}
+	}
+	o.Tags = pq.StringArray(normalizeTags(o.Tags))
+	if o.Tags == nil {
+		o.Tags = pq.StringArray{}
+	}
+
+	return o
+}
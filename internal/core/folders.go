@@ -0,0 +1,102 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// GetFolders returns all folders along with their campaign and template counts.
+func (c *Core) GetFolders(archived bool) ([]models.Folder, error) {
+	out := []models.Folder{}
+	if err := c.q.GetFolders.Select(&out, 0, archived); err != nil {
+		c.log.Printf("error fetching folders: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.folders}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetFolder returns a single folder.
+func (c *Core) GetFolder(id int) (models.Folder, error) {
+	var out []models.Folder
+	if err := c.q.GetFolders.Select(&out, id, true); err != nil {
+		c.log.Printf("error fetching folder: %v", err)
+		return models.Folder{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.folder}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Folder{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.folder}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateFolder creates a new folder.
+func (c *Core) CreateFolder(f models.Folder) (models.Folder, error) {
+	var newID int
+	if err := c.q.CreateFolder.Get(&newID, f.Name, f.Description); err != nil {
+		c.log.Printf("error creating folder: %v", err)
+		return models.Folder{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.folder}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetFolder(newID)
+}
+
+// UpdateFolder updates a folder's properties, including archiving (and unarchiving) it.
+func (c *Core) UpdateFolder(id int, f models.Folder) (models.Folder, error) {
+	res, err := c.q.UpdateFolder.Exec(id, f.Name, f.Description, f.Archived)
+	if err != nil {
+		c.log.Printf("error updating folder: %v", err)
+		return models.Folder{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.folder}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Folder{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.folder}"))
+	}
+
+	return c.GetFolder(id)
+}
+
+// DeleteFolder deletes a folder. Campaigns and templates in it are moved out of the folder.
+func (c *Core) DeleteFolder(id int) error {
+	if _, err := c.q.DeleteFolder.Exec(id); err != nil {
+		c.log.Printf("error deleting folder: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.folder}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// MoveToFolder moves the given campaigns and templates into a folder. If folderID
+// is 0, they are moved out of their folders.
+func (c *Core) MoveToFolder(folderID int, campIDs, tplIDs []int) (int, error) {
+	if campIDs == nil {
+		campIDs = []int{}
+	}
+	if tplIDs == nil {
+		tplIDs = []int{}
+	}
+
+	var (
+		id = null.NewInt(folderID, folderID > 0)
+		n  int
+	)
+	if err := c.q.MoveToFolder.Get(&n, id, pq.Array(campIDs), pq.Array(tplIDs)); err != nil {
+		c.log.Printf("error moving to folder: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.folder}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/utils"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// leadFormKeyLen is the length of the random API keys of lead forms.
+const leadFormKeyLen = 32
+
+// GetLeadForms returns all lead forms with their capture counts.
+func (c *Core) GetLeadForms() ([]models.LeadForm, error) {
+	out := []models.LeadForm{}
+	if err := c.q.GetLeadForms.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching lead forms: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{leads.forms}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetLeadForm returns a lead form.
+func (c *Core) GetLeadForm(id int) (models.LeadForm, error) {
+	var out []models.LeadForm
+	if err := c.q.GetLeadForms.Select(&out, id); err != nil {
+		c.log.Printf("error fetching lead form: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{leads.form}"))
+	}
+
+	return out[0], nil
+}
+
+// GetLeadFormByKey returns the enabled lead form with the given API key.
+func (c *Core) GetLeadFormByKey(key string) (models.LeadForm, error) {
+	var out models.LeadForm
+	if err := c.q.GetLeadFormByKey.Get(&out, key); err != nil && err != sql.ErrNoRows {
+		c.log.Printf("error fetching lead form: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	if out.ID == 0 || !out.Enabled {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusForbidden, c.i18n.T("leads.invalidKey"))
+	}
+
+	return out, nil
+}
+
+// CreateLeadForm creates a new lead form with a random API key.
+func (c *Core) CreateLeadForm(o models.LeadForm) (models.LeadForm, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	key, err := utils.GenerateRandomString(leadFormKeyLen)
+	if err != nil {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{leads.form}", "error", err.Error()))
+	}
+
+	o = leadFormDefaults(o)
+
+	var newID int
+	if err := c.q.CreateLeadForm.Get(&newID, uu.String(), o.Name, key, o.Enabled, o.ListIDs,
+		o.Tags, o.TxTemplateID, o.RateLimit, o.RedirectURL); err != nil {
+		c.log.Printf("error creating lead form: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetLeadForm(newID)
+}
+
+// UpdateLeadForm updates a lead form.
+func (c *Core) UpdateLeadForm(id int, o models.LeadForm) (models.LeadForm, error) {
+	o = leadFormDefaults(o)
+
+	res, err := c.q.UpdateLeadForm.Exec(id, o.Name, o.Enabled, o.ListIDs, o.Tags,
+		o.TxTemplateID, o.RateLimit, o.RedirectURL)
+	if err != nil {
+		c.log.Printf("error updating lead form: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{leads.form}"))
+	}
+
+	return c.GetLeadForm(id)
+}
+
+// RegenerateLeadFormKey replaces a lead form's API key with a new random one.
+func (c *Core) RegenerateLeadFormKey(id int) (models.LeadForm, error) {
+	key, err := utils.GenerateRandomString(leadFormKeyLen)
+	if err != nil {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{leads.form}", "error", err.Error()))
+	}
+
+	res, err := c.q.UpdateLeadFormKey.Exec(id, key)
+	if err != nil {
+		c.log.Printf("error updating lead form key: %v", err)
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.LeadForm{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{leads.form}"))
+	}
+
+	return c.GetLeadForm(id)
+}
+
+// DeleteLeadForm deletes a lead form and its capture records. The captured
+// subscribers are left as they are.
+func (c *Core) DeleteLeadForm(id int) error {
+	res, err := c.q.DeleteLeadForm.Exec(id)
+	if err != nil {
+		c.log.Printf("error deleting lead form: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{leads.form}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{leads.form}"))
+	}
+
+	return nil
+}
+
+// CaptureLead creates or updates the subscriber of a lead captured by a form,
+// tags them, subscribes them to the form's lists, and records the capture with
+// its UTM data and referrer. The bools indicate if the subscriber was created
+// and if they were sent an opt-in confirmation.
+func (c *Core) CaptureLead(f models.LeadForm, sub models.Subscriber, utm models.LeadUTM, referrer string) (models.Subscriber, bool, bool, error) {
+	if err := c.validateNewEmail(sub.Email); err != nil {
+		return models.Subscriber{}, false, false, err
+	}
+
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.Subscriber{}, false, false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	attribs := []byte("{}")
+	if len(sub.Attribs) > 0 {
+		if b, err := json.Marshal(sub.Attribs); err == nil {
+			attribs = b
+		}
+	}
+
+	var res struct {
+		ID      int  `db:"id"`
+		Created bool `db:"created"`
+	}
+	if err := c.q.UpsertLead.Get(&res, f.ID, uu.String(), sub.Email, strings.TrimSpace(sub.Name),
+		json.RawMessage(attribs), sub.Lang, utm, referrer, sub.Source); err != nil {
+		c.log.Printf("error capturing lead: %v", err)
+		return models.Subscriber{}, false, false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	out, err := c.GetSubscriber(res.ID, "", "")
+	if err != nil {
+		return models.Subscriber{}, false, false, err
+	}
+
+	listIDs := make([]int, 0, len(f.ListIDs))
+	for _, id := range f.ListIDs {
+		listIDs = append(listIDs, int(id))
+	}
+	c.publishSubscribed(out, listIDs, nil, models.SubscriptionStatusUnconfirmed)
+
+	hasOptin := false
+	if c.consts.SendOptinConfirmation && len(listIDs) > 0 {
+		// Send a confirmation e-mail (if there are any double opt-in lists).
+		num, _ := c.h.SendOptinConfirmation(out, listIDs)
+		hasOptin = num > 0
+	}
+
+	return out, res.Created, hasOptin, nil
+}
+
+// leadFormDefaults replaces the nil values of a lead form's NOT NULL array fields.
+func leadFormDefaults(o models.LeadForm) models.LeadForm {
+	if o.ListIDs == nil {
+		o.ListIDs = pq.Int64Array{}
+	}
+	o.Tags = pq.StringArray(normalizeTags(o.Tags))
+	if o.Tags == nil {
+		o.Tags = pq.StringArray{}
+	}
+
+	return o
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetListGroups returns all list groups with their member lists and aggregate stats.
+func (c *Core) GetListGroups() ([]models.ListGroup, error) {
+	out := []models.ListGroup{}
+	if err := c.q.GetListGroups.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching list groups: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{lists.groups}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetListGroup returns a single list group.
+func (c *Core) GetListGroup(id int) (models.ListGroup, error) {
+	var out []models.ListGroup
+	if err := c.q.GetListGroups.Select(&out, id); err != nil {
+		c.log.Printf("error fetching list group: %v", err)
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{lists.group}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateListGroup creates a new list group.
+func (c *Core) CreateListGroup(g models.ListGroup) (models.ListGroup, error) {
+	var newID int
+	if err := c.q.CreateListGroup.Get(&newID, g.Name, g.Description); err != nil {
+		c.log.Printf("error creating list group: %v", err)
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetListGroup(newID)
+}
+
+// UpdateListGroup updates a list group.
+func (c *Core) UpdateListGroup(id int, g models.ListGroup) (models.ListGroup, error) {
+	res, err := c.q.UpdateListGroup.Exec(id, g.Name, g.Description)
+	if err != nil {
+		c.log.Printf("error updating list group: %v", err)
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{lists.group}"))
+	}
+
+	return c.GetListGroup(id)
+}
+
+// DeleteListGroup deletes a list group. Its member lists are not deleted.
+func (c *Core) DeleteListGroup(id int) error {
+	if _, err := c.q.DeleteListGroup.Exec(id); err != nil {
+		c.log.Printf("error deleting list group: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// SetListGroupLists sets the member lists of a list group. Lists that are already
+// in another group are moved to this group.
+func (c *Core) SetListGroupLists(id int, listIDs []int) (models.ListGroup, error) {
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+
+	if _, err := c.q.SetListGroupLists.Exec(id, pq.Array(listIDs)); err != nil {
+		c.log.Printf("error setting list group lists: %v", err)
+		return models.ListGroup{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetListGroup(id)
+}
+
+// GetListGroupListIDs returns the IDs of the member lists of the given groups.
+func (c *Core) GetListGroupListIDs(groupIDs []int) ([]int, error) {
+	out := []int{}
+	if err := c.q.GetListGroupListIDs.Select(&out, pq.Array(groupIDs)); err != nil {
+		c.log.Printf("error fetching list group lists: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{lists.group}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
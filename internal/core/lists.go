@@ -2,8 +2,10 @@ package core
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/subfilter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
@@ -127,11 +129,17 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 	if l.Optin == "" {
 		l.Optin = models.ListOptinSingle
 	}
+	if l.OptinMethod == "" {
+		l.OptinMethod = models.OptinMethodLink
+	}
 
 	// Insert and read ID.
 	var newID int
 	l.UUID = uu.String()
-	if err := c.q.CreateList.Get(&newID, l.UUID, l.Name, l.Type, l.Optin, pq.StringArray(normalizeTags(l.Tags)), l.Description); err != nil {
+	if err := c.q.CreateList.Get(&newID, l.UUID, l.Name, l.Type, l.Optin, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.LogoURL, l.Lang, l.StripePriceID, l.OptinMethod, l.Domain, l.Rules, l.ComplianceProfile, l.SenderProfile); err != nil {
+		if isListDomainConflict(err) {
+			return models.List{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("lists.domainExists"))
+		}
 		c.log.Printf("error creating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -142,8 +150,11 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 
 // UpdateList updates a given list.
 func (c *Core) UpdateList(id int, l models.List) (models.List, error) {
-	res, err := c.q.UpdateList.Exec(id, l.Name, l.Type, l.Optin, pq.StringArray(normalizeTags(l.Tags)), l.Description)
+	res, err := c.q.UpdateList.Exec(id, l.Name, l.Type, l.Optin, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.LogoURL, l.Lang, l.StripePriceID, l.OptinMethod, l.Domain, l.Rules, l.ComplianceProfile, l.SenderProfile)
 	if err != nil {
+		if isListDomainConflict(err) {
+			return models.List{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("lists.domainExists"))
+		}
 		c.log.Printf("error updating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -157,6 +168,79 @@ func (c *Core) UpdateList(id int, l models.List) (models.List, error) {
 	return c.GetList(id, "")
 }
 
+// CompileListRules compiles the structured subscriber filter of a dynamic list
+// into an SQL expression.
+func CompileListRules(rules []byte) (string, error) {
+	f, err := subfilter.Parse(rules)
+	if err != nil {
+		return "", err
+	}
+
+	return f.Compile()
+}
+
+// SyncDynamicList recomputes the members of a dynamic list from its rules and
+// returns the number of members.
+func (c *Core) SyncDynamicList(id int, rules []byte) (int, error) {
+	exp, err := CompileListRules(rules)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.invalidFilter", "error", err.Error()))
+	}
+
+	var res struct {
+		Total   int `db:"total"`
+		Added   int `db:"added"`
+		Removed int `db:"removed"`
+	}
+	stmt := strings.ReplaceAll(c.q.SyncDynamicList, "%query%", exp)
+	if err := c.db.Get(&res, stmt, id); err != nil {
+		c.log.Printf("error syncing dynamic list %d: %v", id, err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+
+	if res.Added > 0 || res.Removed > 0 {
+		c.log.Printf("synced dynamic list %d: %d members (%d added, %d removed)", id, res.Total, res.Added, res.Removed)
+		_ = c.refreshCache(matListSubStats, true)
+	}
+
+	return res.Total, nil
+}
+
+// SyncCampaignsDynamicLists recomputes the members of the dynamic lists of the
+// campaigns that are about to be processed, excluding the given campaigns that
+// are already being processed.
+func (c *Core) SyncCampaignsDynamicLists(excludeCampIDs []int64) error {
+	var lists []struct {
+		ID    int    `db:"id"`
+		Rules []byte `db:"rules"`
+	}
+	if err := c.q.GetCampaignsDynamicLists.Select(&lists, pq.Int64Array(excludeCampIDs)); err != nil {
+		c.log.Printf("error fetching dynamic lists: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	for _, l := range lists {
+		if _, err := c.SyncDynamicList(l.ID, l.Rules); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PreviewDynamicList returns the total number of subscribers that match the
+// given dynamic list rules and the first few of them, without saving anything.
+func (c *Core) PreviewDynamicList(rules []byte, limit int) (models.Subscribers, int, error) {
+	exp, err := CompileListRules(rules)
+	if err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.invalidFilter", "error", err.Error()))
+	}
+
+	return c.QuerySubscribers("subscribers.status != 'blocklisted' AND ("+exp+")", nil, "", "", "", 0, 0, limit)
+}
+
 // DeleteList deletes a list.
 func (c *Core) DeleteList(id int) error {
 	return c.DeleteLists([]int{id})
@@ -171,3 +255,185 @@ func (c *Core) DeleteLists(ids []int) error {
 	}
 	return nil
 }
+
+// GetListDomains returns the custom public domains of public lists as a
+// map of domain => list UUID.
+func (c *Core) GetListDomains() (map[string]string, error) {
+	var res []struct {
+		Domain string `db:"domain"`
+		UUID   string `db:"uuid"`
+	}
+	if err := c.q.GetListDomains.Select(&res); err != nil {
+		c.log.Printf("error fetching list domains: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	out := make(map[string]string, len(res))
+	for _, r := range res {
+		out[r.Domain] = r.UUID
+	}
+
+	return out, nil
+}
+
+// GetCampaignComplianceProfiles returns the compliance profiles of the lists
+// that a campaign targets.
+func (c *Core) GetCampaignComplianceProfiles(campID int) ([]string, error) {
+	out := []string{}
+	if err := c.q.GetCampaignComplianceProfiles.Select(&out, campID); err != nil {
+		c.log.Printf("error fetching list compliance profiles: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetListReferrers returns the referral leaderboard of a list: the subscribers
+// who referred the most subscribers to it.
+func (c *Core) GetListReferrers(listID, limit int) ([]models.ListReferrer, error) {
+	out := []models.ListReferrer{}
+	if err := c.q.GetListReferrers.Select(&out, listID, limit); err != nil {
+		c.log.Printf("error fetching list referrers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// ExportListArchive returns an iterator function that provides batches of a list's
+// subscribers along with their subscription states for exporting a portable list archive.
+// The iterator function can be called repeatedly until there are nil subscribers.
+func (c *Core) ExportListArchive(listID, batchSize int) func() ([]models.ListArchiveSubscriber, error) {
+	id := 0
+	return func() ([]models.ListArchiveSubscriber, error) {
+		var out []models.ListArchiveSubscriber
+		if err := c.q.ExportListArchive.Select(&out, listID, id, batchSize); err != nil {
+			c.log.Printf("error exporting list archive: %v", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+		}
+		if len(out) == 0 {
+			return nil, nil
+		}
+
+		id = out[len(out)-1].ID
+		return out, nil
+	}
+}
+
+// ImportListArchive creates a list from a list archive and imports its subscribers
+// and their subscription states using the given iterator function that returns
+// subscribers until it returns nil. If preserveUUIDs is true, the archived list and
+// subscriber UUIDs are retained where they don't conflict with existing ones. If
+// overwrite is true, existing subscribers' data and subscriptions are overwritten.
+// The import is atomic and the list and the number of subscribers imported are returned.
+func (c *Core) ImportListArchive(l models.List, preserveUUIDs, overwrite bool, next func() (*models.ListArchiveSubscriber, error)) (models.List, int, error) {
+	if !preserveUUIDs || l.UUID == "" {
+		uu, err := uuid.NewV4()
+		if err != nil {
+			c.log.Printf("error generating UUID: %v", err)
+			return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+		}
+		l.UUID = uu.String()
+	}
+
+	if l.Type == "" {
+		l.Type = models.ListTypePrivate
+	}
+	if l.Optin == "" {
+		l.Optin = models.ListOptinSingle
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error beginning list archive import: %v", err)
+		return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var listID int
+	if err := tx.Stmtx(c.q.ImportListArchive).Get(&listID, l.UUID, l.Name, l.Type, l.Optin, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.LogoURL, l.Lang); err != nil {
+		c.log.Printf("error creating list from archive: %v", err)
+		return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+
+	var (
+		stmt = tx.Stmtx(c.q.ImportListArchiveSubscriber)
+		n    = 0
+	)
+	for {
+		s, err := next()
+		if err != nil {
+			return models.List{}, 0, err
+		}
+		if s == nil {
+			break
+		}
+
+		uu, err := uuid.NewV4()
+		if err != nil {
+			c.log.Printf("error generating UUID: %v", err)
+			return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+		}
+
+		// New subscribers whose e-mails don't pass the validation policy are skipped.
+		if err := c.validateNewEmail(s.Email); err != nil {
+			if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusBadRequest {
+				c.log.Printf("skipping list archive subscriber %s: %v", s.Email, e.Message)
+				continue
+			}
+			return models.List{}, 0, err
+		}
+
+		subUUID := ""
+		if preserveUUIDs {
+			subUUID = s.UUID
+		}
+
+		var (
+			attribs = "{}"
+			meta    = "{}"
+		)
+		if len(s.Attribs) > 0 && string(s.Attribs) != "null" {
+			attribs = string(s.Attribs)
+		}
+		if len(s.SubscriptionMeta) > 0 && string(s.SubscriptionMeta) != "null" {
+			meta = string(s.SubscriptionMeta)
+		}
+
+		if _, err := stmt.Exec(subUUID, uu.String(), s.Email, s.Name, attribs, s.Status, pq.StringArray(normalizeTags(s.Tags)),
+			listID, s.SubscriptionStatus, meta, overwrite, models.SourceArchive); err != nil {
+			c.log.Printf("error importing list archive subscriber: %v", err)
+			return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+		}
+		n++
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error committing list archive import: %v", err)
+		return models.List{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+
+	out, err := c.GetList(listID, "")
+	if err != nil {
+		return models.List{}, 0, err
+	}
+
+	return out, n, nil
+}
+
+// isListDomainConflict checks whether an error is a violation of the unique
+// custom domain of lists that aren't in the trash.
+func isListDomainConflict(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Constraint == "idx_lists_domain"
+}
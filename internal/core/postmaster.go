@@ -0,0 +1,45 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// QueryPostmasterStats returns a page of imported postmaster stats, optionally
+// filtered by the provider, domain, campaign, and date range (YYYY-MM-DD).
+func (c *Core) QueryPostmasterStats(provider, domain string, campID int, from, to string, offset, limit int) ([]models.PostmasterStat, int, error) {
+	out := []models.PostmasterStat{}
+	if err := c.q.QueryPostmasterStats.Select(&out, provider, domain, campID, from, to, offset, limit); err != nil {
+		c.log.Printf("error fetching postmaster stats: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{settings.postmaster.stats}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// UpsertPostmasterStats inserts or replaces a batch of postmaster stats.
+func (c *Core) UpsertPostmasterStats(stats []models.PostmasterStat) error {
+	for _, s := range stats {
+		if s.Meta == nil {
+			s.Meta = models.JSON{}
+		}
+
+		if _, err := c.q.UpsertPostmasterStat.Exec(s.Provider, s.Domain, s.Date, s.Identifier,
+			s.SpamRate, s.DomainReputation, s.IPReputation, s.SPFSuccessRate, s.DKIMSuccessRate,
+			s.DMARCSuccessRate, s.DeliveryErrorRate, s.Meta); err != nil {
+			c.log.Printf("error importing postmaster stats: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", "{settings.postmaster.stats}", "error", pqErrMsg(err)))
+		}
+	}
+
+	return nil
+}
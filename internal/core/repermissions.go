@@ -0,0 +1,115 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetRepermissionTargets returns the number of unconfirmed subscriptions to each
+// of the given lists that a re-permission run would target.
+func (c *Core) GetRepermissionTargets(listIDs []int) ([]models.RepermissionTarget, error) {
+	out := []models.RepermissionTarget{}
+	if err := c.q.GetRepermissionTargets.Select(&out, pq.Array(listIDs)); err != nil {
+		c.log.Printf("error fetching re-permission targets: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// CreateRepermission creates a re-permission run of an opt-in campaign that
+// targets the current unconfirmed subscriptions to the given lists.
+func (c *Core) CreateRepermission(campID int, listIDs []int, deadline time.Time, action string, userID int) (models.Repermission, error) {
+	var id int
+	if err := c.q.CreateRepermission.Get(&id, campID, pq.Array(listIDs), deadline, action, userID); err != nil {
+		c.log.Printf("error creating re-permission: %v", err)
+		return models.Repermission{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetRepermission(id)
+}
+
+// QueryRepermissions returns the re-permission runs with their progress.
+// If getAll is false, only the runs whose lists are all in listIDs are returned.
+func (c *Core) QueryRepermissions(getAll bool, listIDs []int) ([]models.Repermission, error) {
+	out := []models.Repermission{}
+	if err := c.q.QueryRepermissions.Select(&out, 0, getAll, pq.Array(listIDs)); err != nil {
+		c.log.Printf("error fetching re-permissions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetRepermission returns a re-permission run with its progress.
+func (c *Core) GetRepermission(id int) (models.Repermission, error) {
+	var out models.Repermission
+	if err := c.q.QueryRepermissions.Get(&out, id, true, nil); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{repermissions.title}"))
+		}
+
+		c.log.Printf("error fetching re-permission: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpdateRepermission updates the deadline and action of a re-permission run
+// that hasn't been processed yet.
+func (c *Core) UpdateRepermission(id int, deadline time.Time, action string) (models.Repermission, error) {
+	res, err := c.q.UpdateRepermission.Exec(id, deadline, action)
+	if err != nil {
+		c.log.Printf("error updating re-permission: %v", err)
+		return models.Repermission{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Repermission{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("repermissions.notWaiting"))
+	}
+
+	return c.GetRepermission(id)
+}
+
+// CancelRepermission cancels a re-permission run that hasn't been processed
+// yet. Its campaign is left as it is.
+func (c *Core) CancelRepermission(id int) error {
+	res, err := c.q.CancelRepermission.Exec(id)
+	if err != nil {
+		c.log.Printf("error cancelling re-permission: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{repermissions.title}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("repermissions.notWaiting"))
+	}
+
+	return nil
+}
+
+// ProcessRepermissions removes or unsubscribes the subscriptions that are still
+// unconfirmed in the runs whose campaigns have finished and whose deadlines
+// have passed, and cancels the runs of cancelled or deleted campaigns. The runs
+// that were processed are returned.
+func (c *Core) ProcessRepermissions() ([]models.Repermission, error) {
+	out := []models.Repermission{}
+	if err := c.q.ProcessRepermissions.Select(&out); err != nil {
+		c.log.Printf("error processing re-permissions: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
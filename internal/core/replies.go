@@ -0,0 +1,60 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// InsertCampaignReply records a reply to a campaign message. ok is false if
+// the campaign or the subscriber doesn't exist, or if the reply was already
+// recorded.
+func (c *Core) InsertCampaignReply(r models.CampaignReply) (models.CampaignReply, bool, error) {
+	var out models.CampaignReply
+	if err := c.q.InsertCampaignReply.Get(&out, r.CampaignUUID, r.SubscriberUUID,
+		r.MessageID, r.FromEmail, r.Subject, r.Body); err != nil {
+		if err == sql.ErrNoRows {
+			return out, false, nil
+		}
+
+		c.log.Printf("error recording campaign reply: %v", err)
+		return out, false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.reply}", "error", pqErrMsg(err)))
+	}
+
+	return out, true, nil
+}
+
+// QueryCampaignReplies retrieves paginated replies to campaign messages,
+// optionally of a campaign and or a subscriber. It also returns the total
+// number of matching replies.
+func (c *Core) QueryCampaignReplies(campID, subID, offset, limit int) ([]models.CampaignReply, int, error) {
+	out := []models.CampaignReply{}
+	if err := c.q.QueryCampaignReplies.Select(&out, campID, subID, offset, limit); err != nil {
+		c.log.Printf("error fetching campaign replies: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.replies}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// DeleteCampaignReplies deletes a campaign's replies by IDs, or all of them
+// if there are no IDs.
+func (c *Core) DeleteCampaignReplies(campID int, ids []int64) error {
+	if _, err := c.q.DeleteCampaignReplies.Exec(campID, pq.Array(ids)); err != nil {
+		c.log.Printf("error deleting campaign replies: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.replies}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetReports returns all saved reports.
+func (c *Core) GetReports() ([]models.Report, error) {
+	out := []models.Report{}
+	if err := c.q.GetReports.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching reports: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.reports}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetReport returns a saved report.
+func (c *Core) GetReport(id int) (models.Report, error) {
+	var out []models.Report
+	if err := c.q.GetReports.Select(&out, id); err != nil {
+		c.log.Printf("error fetching report: %v", err)
+		return models.Report{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.report}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Report{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.report}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateReport validates and saves a new report.
+func (c *Core) CreateReport(o models.Report, userID int) (models.Report, error) {
+	q, err := c.validateReportQuery(o.Query)
+	if err != nil {
+		return models.Report{}, err
+	}
+
+	var newID int
+	if err := c.q.CreateReport.Get(&newID, o.Name, o.Description, q, o.Schedule, userID); err != nil {
+		c.log.Printf("error creating report: %v", err)
+		return models.Report{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.report}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetReport(newID)
+}
+
+// UpdateReport validates and updates a saved report.
+func (c *Core) UpdateReport(id int, o models.Report) (models.Report, error) {
+	q, err := c.validateReportQuery(o.Query)
+	if err != nil {
+		return models.Report{}, err
+	}
+
+	res, err := c.q.UpdateReport.Exec(id, o.Name, o.Description, q, o.Schedule)
+	if err != nil {
+		c.log.Printf("error updating report: %v", err)
+		return models.Report{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.report}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Report{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.report}"))
+	}
+
+	return c.GetReport(id)
+}
+
+// DeleteReport deletes a saved report and its results.
+func (c *Core) DeleteReport(id int) error {
+	if _, err := c.q.DeleteReport.Exec(id); err != nil {
+		c.log.Printf("error deleting report: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.report}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetReportResults returns the kept results of a report without their data.
+func (c *Core) GetReportResults(reportID int) ([]models.ReportResult, error) {
+	out := []models.ReportResult{}
+	if err := c.q.GetReportResults.Select(&out, reportID); err != nil {
+		c.log.Printf("error fetching report results: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.report}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetReportResult returns a result of a report with its data. A zero id
+// returns the latest result.
+func (c *Core) GetReportResult(reportID int, id int64) (models.ReportResult, error) {
+	var out models.ReportResult
+	if err := c.q.GetReportResult.Get(&out, reportID, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{reports.result}"))
+		}
+
+		c.log.Printf("error fetching report result: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{reports.result}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RunReport runs a report's query as the reports DB role and records the
+// result. Query errors don't fail the run and are recorded in the result.
+func (c *Core) RunReport(id int, scheduled bool, userID int) (models.ReportResult, error) {
+	if c.consts.ReportsDBRole == "" {
+		return models.ReportResult{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("reports.noDBRole"))
+	}
+
+	r, err := c.GetReport(id)
+	if err != nil {
+		return models.ReportResult{}, err
+	}
+
+	var (
+		start = time.Now()
+		out   = models.ReportResult{ReportID: id, Status: models.ReportStatusSuccess, Scheduled: scheduled}
+	)
+
+	cols, rows, truncated, err := c.execReport(r.Query)
+	if err != nil {
+		out.Status = models.ReportStatusError
+		out.Error = pqErrMsg(err)
+		cols, rows = []string{}, [][]interface{}{}
+	}
+
+	if out.Columns, err = json.Marshal(cols); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("reports.errorRunning", "error", err.Error()))
+	}
+	if out.Data, err = json.Marshal(rows); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("reports.errorRunning", "error", err.Error()))
+	}
+	out.NumRows = len(rows)
+	out.Truncated = truncated
+	out.DurationMS = int(time.Since(start).Milliseconds())
+
+	keep := c.consts.ReportsKeepResults
+	if keep < 1 {
+		keep = 1
+	}
+	if err := c.q.InsertReportResult.Get(&out.ID, id, out.Status, out.Scheduled, out.Columns, out.Data,
+		out.NumRows, out.Truncated, out.Error, out.DurationMS, userID, keep); err != nil {
+		c.log.Printf("error saving report result: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{reports.result}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetReportResult(id, out.ID)
+}
+
+// execReport runs a report query in a read-only transaction as the reports DB
+// role with the statement timeout, and returns its columns and up to the
+// maximum number of rows.
+func (c *Core) execReport(query string) ([]string, [][]interface{}, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.consts.ReportsTimeout+time.Second*5)
+	defer cancel()
+
+	tx, err := c.beginReportTx(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Preparing the query rejects multiple statements that could otherwise
+	// end the transaction or reset the role.
+	stmt, err := tx.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	var (
+		out       = [][]interface{}{}
+		truncated = false
+	)
+	for rows.Next() {
+		if len(out) >= c.consts.ReportsMaxRows {
+			truncated = true
+			break
+		}
+
+		var (
+			vals = make([]interface{}, len(cols))
+			ptrs = make([]interface{}, len(cols))
+		)
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, false, err
+		}
+
+		// Numeric, text, and other types that the driver returns as bytes.
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				vals[i] = string(b)
+			}
+		}
+		out = append(out, vals)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, false, err
+	}
+
+	return cols, out, truncated, nil
+}
+
+// beginReportTx begins a read-only transaction for running report queries as
+// the reports DB role with the statement timeout.
+func (c *Core) beginReportTx(ctx context.Context) (*sqlx.Tx, error) {
+	tx, err := c.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.consts.ReportsDBRole != "" {
+		if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+pq.QuoteIdentifier(c.consts.ReportsDBRole)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", c.consts.ReportsTimeout.Milliseconds())); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// validateReportQuery checks that a report query is a single query that
+// returns rows and that it parses, and returns it without trailing semicolons.
+func (c *Core) validateReportQuery(q string) (string, error) {
+	q = strings.TrimRight(strings.TrimSpace(q), "; \t\r\n")
+
+	switch strings.ToLower(firstSQLWord(q)) {
+	case "select", "with", "values", "table":
+	default:
+		return q, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("reports.invalidQuery"))
+	}
+
+	// Prepare the query to catch syntax errors and unknown tables and columns.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := c.beginReportTx(ctx)
+	if err != nil {
+		c.log.Printf("error preparing report query: %v", err)
+		return q, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("reports.errorQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PreparexContext(ctx, q)
+	if err != nil {
+		return q, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("reports.errorQuery", "error", pqErrMsg(err)))
+	}
+	stmt.Close()
+
+	return q, nil
+}
+
+// firstSQLWord returns the first word of an SQL query, skipping comments.
+func firstSQLWord(q string) string {
+	for {
+		q = strings.TrimSpace(q)
+		switch {
+		case strings.HasPrefix(q, "--"):
+			_, q, _ = strings.Cut(q, "\n")
+		case strings.HasPrefix(q, "/*"):
+			_, q, _ = strings.Cut(q, "*/")
+		default:
+			end := strings.IndexFunc(q, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				return q
+			}
+			return q[:end]
+		}
+	}
+}
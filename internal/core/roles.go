@@ -85,8 +85,8 @@ func (c *Core) UpsertListPermissions(roleID int, lp []models.ListPermission) err
 		listIDs = append(listIDs, p.ID)
 
 		// For the Postgres array unnesting query to work, all permissions arrays should
-		// have equal number of entries. Add "" in case there's only one of either list:get or list:manage
-		perms := make([]string, 2)
+		// have equal number of entries. Add "" for any of list:get, list:manage, or list:send that isn't there.
+		perms := make([]string, 3)
 		copy(perms[:], p.Permissions[:])
 		listPerms = append(listPerms, perms)
 	}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Search runs a ranked full-text search for the given query across the given
+// object types (campaigns, templates, lists, subscribers), returning at most
+// `limit` results per type. Lists and subscribers are filtered by the given
+// list IDs unless getAllLists / getAllSubs are set.
+func (c *Core) Search(query string, types []string, limit int, getAllLists bool, listIDs []int, getAllSubs, getAllCamps bool, campListIDs []int) ([]models.SearchResult, error) {
+	out := []models.SearchResult{}
+
+	tsq := makeTSQuery(query)
+	if tsq == "" || len(types) == 0 {
+		return out, nil
+	}
+
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+	if campListIDs == nil {
+		campListIDs = []int{}
+	}
+
+	if err := c.q.Search.Select(&out, tsq, pq.StringArray(types), limit, getAllLists, pq.Array(listIDs), getAllSubs, getAllCamps, pq.Array(campListIDs)); err != nil {
+		c.log.Printf("error searching: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.search}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
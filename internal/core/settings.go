@@ -1,8 +1,10 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
+	"sort"
 
 	"github.com/jmoiron/sqlx/types"
 	"github.com/knadh/listmonk/models"
@@ -22,6 +24,19 @@ func (c *Core) GetSettings() (models.Settings, error) {
 				"name", "{globals.terms.settings}", "error", pqErrMsg(err)))
 	}
 
+	// Decrypt the secrets that are encrypted at rest.
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(b), &m); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+	if err := c.seal.DecryptFields(m, models.SecretSettings); err != nil {
+		c.log.Printf("error decrypting settings: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+	b, _ = json.Marshal(m)
+
 	// Unmarshal the settings and filter out sensitive fields.
 	if err := json.Unmarshal([]byte(b), &out); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
@@ -40,6 +55,23 @@ func (c *Core) UpdateSettings(s models.Settings) error {
 			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
 	}
 
+	// Encrypt the secrets before they're stored.
+	if c.seal != nil {
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+		}
+		if err := c.seal.EncryptFields(m, models.SecretSettings); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+		}
+		if b, err = json.Marshal(m); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+		}
+	}
+
 	// Update the settings in the DB.
 	if _, err := c.q.UpdateSettings.Exec(b); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -48,3 +80,57 @@ func (c *Core) UpdateSettings(s models.Settings) error {
 
 	return nil
 }
+
+// SetSendingHalted sets the emergency stop that halts all sending.
+func (c *Core) SetSendingHalted(halted bool) error {
+	if _, err := c.q.SetSendingHalted.Exec(halted); err != nil {
+		c.log.Printf("error updating sending halt: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// DiffSettings returns the old (a) and new (b) values of the given settings keys.
+func DiffSettings(a, b models.Settings, keys []string) []models.SettingsChange {
+	var ma, mb map[string]json.RawMessage
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if err := json.Unmarshal(ja, &ma); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(jb, &mb); err != nil {
+		return nil
+	}
+
+	out := make([]models.SettingsChange, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, models.SettingsChange{Key: k, Old: ma[k], New: mb[k]})
+	}
+
+	return out
+}
+
+// ChangedSettingsKeys returns the keys of the settings that differ between a and b.
+func ChangedSettingsKeys(a, b models.Settings) []string {
+	var ma, mb map[string]json.RawMessage
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if err := json.Unmarshal(ja, &ma); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(jb, &mb); err != nil {
+		return nil
+	}
+
+	out := []string{}
+	for k, v := range mb {
+		if !bytes.Equal(ma[k], v) {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+
+	return out
+}
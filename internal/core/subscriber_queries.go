@@ -0,0 +1,114 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetSubscriberQueries returns the saved subscriber queries visible to the given user.
+// If all is true, all queries irrespective of their owners are returned.
+func (c *Core) GetSubscriberQueries(userID int, all bool) ([]models.SubscriberQuery, error) {
+	out := []models.SubscriberQuery{}
+	if err := c.q.GetSubscriberQueries.Select(&out, 0, userID, all); err != nil {
+		c.log.Printf("error fetching subscriber queries: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscriberQueries.query}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSubscriberQuery returns a saved subscriber query visible to the given user.
+func (c *Core) GetSubscriberQuery(id, userID int, all bool) (models.SubscriberQuery, error) {
+	var out []models.SubscriberQuery
+	if err := c.q.GetSubscriberQueries.Select(&out, id, userID, all); err != nil {
+		c.log.Printf("error fetching subscriber query: %v", err)
+		return models.SubscriberQuery{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscriberQueries.query}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.SubscriberQuery{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{subscriberQueries.query}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateSubscriberQuery validates and saves a new subscriber query owned by the given user.
+func (c *Core) CreateSubscriberQuery(o models.SubscriberQuery, userID int) (models.SubscriberQuery, error) {
+	if err := c.validateSubscriberQuery(o.Query); err != nil {
+		return models.SubscriberQuery{}, err
+	}
+
+	var newID int
+	if err := c.q.CreateSubscriberQuery.Get(&newID, o.Name, sanitizeSQLExp(o.Query), o.Shared, userID); err != nil {
+		c.log.Printf("error creating subscriber query: %v", err)
+		return models.SubscriberQuery{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{subscriberQueries.query}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSubscriberQuery(newID, userID, true)
+}
+
+// UpdateSubscriberQuery validates and updates a saved subscriber query. Unless all is true,
+// only the user who created the query can update it.
+func (c *Core) UpdateSubscriberQuery(id int, o models.SubscriberQuery, userID int, all bool) (models.SubscriberQuery, error) {
+	if err := c.validateSubscriberQuery(o.Query); err != nil {
+		return models.SubscriberQuery{}, err
+	}
+
+	res, err := c.q.UpdateSubscriberQuery.Exec(id, o.Name, sanitizeSQLExp(o.Query), o.Shared, userID, all)
+	if err != nil {
+		c.log.Printf("error updating subscriber query: %v", err)
+		return models.SubscriberQuery{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{subscriberQueries.query}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.SubscriberQuery{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{subscriberQueries.query}"))
+	}
+
+	return c.GetSubscriberQuery(id, userID, true)
+}
+
+// DeleteSubscriberQuery deletes a saved subscriber query. Queries that are
+// used by campaigns that are yet to finish cannot be deleted. Unless all is true,
+// only the user who created the query can delete it.
+func (c *Core) DeleteSubscriberQuery(id, userID int, all bool) error {
+	var res struct {
+		Used    int `db:"used"`
+		Deleted int `db:"deleted"`
+	}
+	if err := c.q.DeleteSubscriberQuery.Get(&res, id, userID, all); err != nil {
+		c.log.Printf("error deleting subscriber query: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{subscriberQueries.query}", "error", pqErrMsg(err)))
+	}
+
+	if res.Used > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscriberQueries.inUse", "num", strconv.Itoa(res.Used)))
+	}
+	if res.Deleted == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{subscriberQueries.query}"))
+	}
+
+	return nil
+}
+
+// validateSubscriberQuery dry runs an arbitrary subscriber query expression
+// in a readonly transaction to ensure that it's valid and readonly.
+func (c *Core) validateSubscriberQuery(query string) error {
+	if _, err := c.q.CompileSubscriberQueryTpl(sanitizeSQLExp(query), c.db, ""); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
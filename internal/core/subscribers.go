@@ -2,13 +2,17 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
@@ -87,7 +91,8 @@ func (c *Core) GetSubscribersByEmail(emails []string) (models.Subscribers, error
 }
 
 // QuerySubscribers queries and returns paginated subscrribers based on the given params including the total count.
-func (c *Core) QuerySubscribers(query string, listIDs []int, subStatus string, order, orderBy string, offset, limit int) (models.Subscribers, int, error) {
+// If afterID is set, only the subscribers after it in the sort order (by ID) are returned (cursor pagination).
+func (c *Core) QuerySubscribers(query string, listIDs []int, subStatus string, order, orderBy string, afterID, offset, limit int) (models.Subscribers, int, error) {
 	// There's an arbitrary query condition.
 	cond := ""
 	if query != "" {
@@ -102,6 +107,9 @@ func (c *Core) QuerySubscribers(query string, listIDs []int, subStatus string, o
 		order = SortDesc
 	}
 
+	// Cursor pagination.
+	cond += cursorExp("subscribers.id", afterID, order)
+
 	// Required for pq.Array()
 	if listIDs == nil {
 		listIDs = []int{}
@@ -254,10 +262,40 @@ func (c *Core) ExportSubscribers(query string, subIDs, listIDs []int, subStatus
 	}, nil
 }
 
+// validateNewEmail checks the e-mail of a subscriber that's being created
+// against the validation policy. The e-mails of existing subscribers, which
+// are only updated, aren't subject to the policy.
+func (c *Core) validateNewEmail(email string) error {
+	if c.h.ValidateEmailPolicy == nil {
+		return nil
+	}
+
+	err := c.h.ValidateEmailPolicy(email)
+	if err == nil {
+		return nil
+	}
+
+	var n int
+	if e := c.q.CountSubscribersByEmails.Get(&n, pq.Array([]string{strings.ToLower(email)})); e != nil {
+		c.log.Printf("error fetching subscriber: %v", e)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscriber}", "error", pqErrMsg(e)))
+	}
+	if n > 0 {
+		return nil
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
 // InsertSubscriber inserts a subscriber and returns the ID. The first bool indicates if
 // it was a new subscriber, and the second bool indicates if the subscriber was sent an optin confirmation.
 // bool = optinSent?
 func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm bool) (models.Subscriber, bool, error) {
+	if err := c.validateNewEmail(sub.Email); err != nil {
+		return models.Subscriber{}, false, err
+	}
+
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -290,7 +328,10 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 		sub.Attribs,
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
-		subStatus); err != nil {
+		subStatus,
+		makeTagsArray(sub.Tags),
+		sub.Lang,
+		sub.Source); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "subscribers_email_key" {
 			return models.Subscriber{}, false, echo.NewHTTPError(http.StatusConflict, c.i18n.T("subscribers.emailExists"))
 		} else {
@@ -307,6 +348,7 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 	if err != nil {
 		return models.Subscriber{}, false, err
 	}
+	c.publishSubscribed(out, listIDs, listUUIDs, subStatus)
 
 	hasOptin := false
 	if !preconfirm && c.consts.SendOptinConfirmation {
@@ -337,6 +379,7 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 		strings.TrimSpace(sub.Name),
 		sub.Status,
 		json.RawMessage(attribs),
+		sub.Lang,
 	)
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
@@ -352,9 +395,53 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 	return out, nil
 }
 
+// UpdateSubscriberPublicKey sets a subscriber's public key. An empty key removes it.
+func (c *Core) UpdateSubscriberPublicKey(id int, key string) (models.Subscriber, error) {
+	res, err := c.q.UpdateSubscriberPublicKey.Exec(id, key)
+	if err != nil {
+		c.log.Printf("error updating subscriber public key: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+	}
+
+	return c.GetSubscriber(id, "", "")
+}
+
+// RotateSubscriberUUID replaces a subscriber's UUID with a new one, invalidating
+// the unsubscribe, preferences, and tracking links in the e-mails sent to them.
+// The old UUID is logged so that tracking hits recorded before the rotation
+// are still attributed to the subscriber.
+func (c *Core) RotateSubscriberUUID(id int, reason string, userID int) (models.Subscriber, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	res, err := c.q.RotateSubscriberUUID.Exec(id, uu.String(), reason, userID)
+	if err != nil {
+		c.log.Printf("error rotating subscriber UUID: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+	}
+
+	return c.GetSubscriber(id, "", "")
+}
+
 // UpdateSubscriberWithLists updates a subscriber's properties.
 // If deleteLists is set to true, all existing subscriptions are deleted and only
-// the ones provided are added or retained.
+// the ones provided are added or retained. sub.Source is recorded on new subscriptions.
 func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, deleteLists bool) (models.Subscriber, bool, error) {
 	subStatus := models.SubscriptionStatusUnconfirmed
 	if preconfirm {
@@ -373,6 +460,16 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		}
 	}
 
+	// Lists that the subscriber is already subscribed to aren't published as
+	// new subscriptions.
+	var (
+		curIDs   map[int]bool
+		curUUIDs map[string]bool
+	)
+	if c.h.PublishEvent != nil && (len(listIDs) > 0 || len(listUUIDs) > 0) {
+		curIDs, curUUIDs = c.getActiveSubscriptions(id)
+	}
+
 	_, err := c.q.UpdateSubscriberWithLists.Exec(id,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
@@ -381,7 +478,10 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
 		subStatus,
-		deleteLists)
+		deleteLists,
+		makeTagsArray(sub.Tags),
+		sub.Lang,
+		sub.Source)
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
 		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusInternalServerError,
@@ -392,6 +492,23 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 	if err != nil {
 		return models.Subscriber{}, false, err
 	}
+	if c.h.PublishEvent != nil {
+		var (
+			newIDs   = []int{}
+			newUUIDs = []string{}
+		)
+		for _, l := range listIDs {
+			if !curIDs[l] {
+				newIDs = append(newIDs, l)
+			}
+		}
+		for _, u := range listUUIDs {
+			if !curUUIDs[u] {
+				newUUIDs = append(newUUIDs, u)
+			}
+		}
+		c.publishSubscribed(out, newIDs, newUUIDs, subStatus)
+	}
 
 	hasOptin := false
 	if !preconfirm && c.consts.SendOptinConfirmation {
@@ -411,6 +528,15 @@ func (c *Core) BlocklistSubscribers(subIDs []int) error {
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", err.Error()))
 	}
 
+	// Blocklisting unsubscribes from all lists.
+	for _, id := range subIDs {
+		c.publishEvent(eventbus.TypeUnsubscribed, eventbus.Subscription{
+			SubscriberID: id,
+			Status:       models.SubscriptionStatusUnsubscribed,
+			Source:       models.SubscriberStatusBlockListed,
+		})
+	}
+
 	return nil
 }
 
@@ -443,6 +569,48 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 	return nil
 }
 
+// CountSubscribersByDomain returns the number of subscribers under each of the
+// given e-mail domains (including their subdomains) that the action (blocklist,
+// delete) would apply to.
+func (c *Core) CountSubscribersByDomain(domains []string, action string) ([]models.SubscriberDomainCount, error) {
+	out := []models.SubscriberDomainCount{}
+	if err := c.q.CountSubscribersByDomain.Select(&out, pq.Array(domains), action); err != nil {
+		c.log.Printf("error counting subscribers by domain: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// BlocklistSubscribersByDomain blocklists a batch of subscribers under the given
+// e-mail domains and returns the number blocklisted, which is 0 when there are
+// no more.
+func (c *Core) BlocklistSubscribersByDomain(domains []string, batchSize int) (int, error) {
+	var n int
+	if err := c.q.BlocklistSubscribersByDomain.Get(&n, pq.Array(domains), batchSize); err != nil {
+		c.log.Printf("error blocklisting subscribers by domain: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// DeleteSubscribersByDomain deletes a batch of subscribers under the given
+// e-mail domains and returns the number deleted, which is 0 when there are
+// no more.
+func (c *Core) DeleteSubscribersByDomain(domains []string, batchSize int) (int, error) {
+	var n int
+	if err := c.q.DeleteSubscribersByDomain.Get(&n, pq.Array(domains), batchSize); err != nil {
+		c.log.Printf("error deleting subscribers by domain: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
 // DeleteSubscribersByQuery deletes subscribers by a given arbitrary query expression.
 func (c *Core) DeleteSubscribersByQuery(query string, listIDs []int, subStatus string) error {
 	err := c.q.ExecSubQueryTpl(sanitizeSQLExp(query), c.q.DeleteSubscribersByQuery, listIDs, c.db, subStatus)
@@ -463,6 +631,12 @@ func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) e
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	c.publishEvent(eventbus.TypeUnsubscribed, eventbus.Subscription{
+		SubscriberUUID: subUUID,
+		CampaignUUID:   campUUID,
+		Status:         models.SubscriptionStatusUnsubscribed,
+	})
+
 	return nil
 }
 
@@ -478,6 +652,84 @@ func (c *Core) ConfirmOptionSubscription(subUUID string, listUUIDs []string, met
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	c.publishEvent(eventbus.TypeSubscribed, eventbus.Subscription{
+		SubscriberUUID: subUUID,
+		ListUUIDs:      listUUIDs,
+		Status:         models.SubscriptionStatusConfirmed,
+		Source:         models.SourceOptin,
+	})
+
+	return nil
+}
+
+// maxOptinCodeAttempts is the number of wrong opt-in codes after which
+// a code is invalidated.
+const maxOptinCodeAttempts = 5
+
+// SetOptinCode sets a subscriber's opt-in confirmation code for the given lists.
+// Only the hash of the code is stored.
+func (c *Core) SetOptinCode(subID int, code string, listIDs []int, ttl time.Duration) error {
+	if _, err := c.q.UpsertOptinCode.Exec(subID, hashOptinCode(code), pq.Array(listIDs), fmt.Sprintf("%d seconds", int(ttl.Seconds()))); err != nil {
+		c.log.Printf("error setting opt-in code: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// ConfirmOptinCode confirms a subscriber's unconfirmed subscriptions to the lists
+// of their opt-in code if the given code matches. The IDs of the lists are returned.
+func (c *Core) ConfirmOptinCode(subID int, code string, meta models.JSON) ([]int, error) {
+	if meta == nil {
+		meta = models.JSON{}
+	}
+
+	var ids pq.Int64Array
+	if err := c.q.UseOptinCode.Get(&ids, subID, hashOptinCode(code), maxOptinCodeAttempts, meta); err != nil {
+		if err == sql.ErrNoRows {
+			if _, err := c.q.IncrOptinCodeAttempts.Exec(subID); err != nil {
+				c.log.Printf("error updating opt-in code attempts: %v", err)
+			}
+			return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("subscribers.invalidOptinCode"))
+		}
+
+		c.log.Printf("error confirming opt-in code: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, int(id))
+	}
+
+	if len(out) > 0 {
+		c.publishEvent(eventbus.TypeSubscribed, eventbus.Subscription{
+			SubscriberID: subID,
+			ListIDs:      out,
+			Status:       models.SubscriptionStatusConfirmed,
+			Source:       models.SourceOptin,
+		})
+	}
+
+	return out, nil
+}
+
+func hashOptinCode(code string) string {
+	h := sha256.Sum256([]byte(strings.TrimSpace(code)))
+	return hex.EncodeToString(h[:])
+}
+
+// RecordSubscriberReferral attributes a subscriber to the referrer with the given
+// referral code. Unknown codes are ignored.
+func (c *Core) RecordSubscriberReferral(subID int, code string) error {
+	if _, err := c.q.InsertSubscriberReferral.Exec(subID, code); err != nil {
+		c.log.Printf("error recording subscriber referral: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
 	return nil
 }
 
@@ -523,6 +775,56 @@ func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
 	return int(n), nil
 }
 
+// audienceEstimateThreshold is the estimated audience size above which
+// CountAudience returns the estimate instead of an exact count.
+const audienceEstimateThreshold = 100000
+
+// CountAudience returns the number of distinct recipients that a campaign of the
+// given type sent to the lists and list groups, minus the subscribers on the
+// excluded lists, would have. exp is an optional subscriber query expression.
+// Unless exact is set, the query planner's estimate is returned for audiences
+// larger than audienceEstimateThreshold to avoid counting them row by row.
+func (c *Core) CountAudience(listIDs, groupIDs, excludeIDs []int, campType, exp string, exact bool) (models.AudienceCount, error) {
+	if exp != "" {
+		exp = " AND (" + exp + ")"
+	}
+	stmt := strings.ReplaceAll(c.q.CountAudience, "%query%", exp)
+
+	// The expression is arbitrary. Run it in a readonly transaction.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing subscriber query: %v", err)
+		return models.AudienceCount{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{pq.Array(listIDs), pq.Array(groupIDs), pq.Array(excludeIDs), campType}
+
+	if !exact {
+		var plan []byte
+		if err := tx.Get(&plan, "EXPLAIN (FORMAT JSON) "+stmt, args...); err != nil {
+			return models.AudienceCount{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+		}
+
+		var p []struct {
+			Plan struct {
+				Rows float64 `json:"Plan Rows"`
+			} `json:"Plan"`
+		}
+		if err := json.Unmarshal(plan, &p); err == nil && len(p) > 0 && p[0].Plan.Rows >= audienceEstimateThreshold {
+			return models.AudienceCount{Count: int(p[0].Plan.Rows), Estimated: true}, nil
+		}
+	}
+
+	var out models.AudienceCount
+	if err := tx.Get(&out.Count, "SELECT COUNT(*) FROM ("+stmt+") AS s", args...); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 func (c *Core) getSubscriberCount(cond, subStatus string, listIDs []int) (int, error) {
 	// If there's no condition, it's a "get all" call which can probably be optionally pulled from cache.
 	if cond == "" {
@@ -556,3 +858,49 @@ func (c *Core) getSubscriberCount(cond, subStatus string, listIDs []int) (int, e
 
 	return total, nil
 }
+
+// publishSubscribed publishes the subscription of a subscriber who was
+// created or updated with lists on the event bus.
+func (c *Core) publishSubscribed(sub models.Subscriber, listIDs []int, listUUIDs []string, status string) {
+	if len(listIDs) == 0 && len(listUUIDs) == 0 {
+		return
+	}
+
+	// Blocklisted subscribers are added to lists as unsubscribed.
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return
+	}
+
+	c.publishEvent(eventbus.TypeSubscribed, eventbus.Subscription{
+		SubscriberID:   sub.ID,
+		SubscriberUUID: sub.UUID,
+		Email:          sub.Email,
+		ListIDs:        listIDs,
+		ListUUIDs:      listUUIDs,
+		Status:         status,
+		Source:         sub.Source,
+	})
+}
+
+// getActiveSubscriptions returns the IDs and UUIDs of the lists that a
+// subscriber is subscribed to and hasn't unsubscribed from.
+func (c *Core) getActiveSubscriptions(subID int) (map[int]bool, map[string]bool) {
+	var (
+		subs  []models.Subscription
+		ids   = map[int]bool{}
+		uuids = map[string]bool{}
+	)
+	if err := c.q.GetSubscriptions.Select(&subs, subID, "", false); err != nil {
+		c.log.Printf("error getting subscriptions: %v", err)
+		return ids, uuids
+	}
+
+	for _, s := range subs {
+		if s.SubscriptionStatus.String != models.SubscriptionStatusUnsubscribed {
+			ids[s.ID] = true
+			uuids[s.UUID] = true
+		}
+	}
+
+	return ids, uuids
+}
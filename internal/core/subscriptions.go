@@ -1,9 +1,11 @@
 package core
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/knadh/listmonk/internal/eventbus"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
@@ -22,25 +24,92 @@ func (c *Core) GetSubscriptions(subID int, subUUID string, allLists bool) ([]mod
 	return out, err
 }
 
-// AddSubscriptions adds list subscriptions to subscribers.
-func (c *Core) AddSubscriptions(subIDs, listIDs []int, status string) error {
-	if _, err := c.q.AddSubscribersToLists.Exec(pq.Array(subIDs), pq.Array(listIDs), status); err != nil {
+// AddSubscriptions adds list subscriptions to subscribers. source is the
+// provenance recorded on new subscriptions.
+func (c *Core) AddSubscriptions(subIDs, listIDs []int, status, source string) error {
+	if _, err := c.q.AddSubscribersToLists.Exec(pq.Array(subIDs), pq.Array(listIDs), status, source); err != nil {
 		c.log.Printf("error adding subscriptions: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
 	}
 
+	typ := eventbus.TypeSubscribed
+	if status == models.SubscriptionStatusUnsubscribed {
+		typ = eventbus.TypeUnsubscribed
+	}
+	for _, id := range subIDs {
+		c.publishEvent(typ, eventbus.Subscription{SubscriberID: id, ListIDs: listIDs, Status: status, Source: source})
+	}
+
+	return nil
+}
+
+// SetSubscriptionStatuses sets the statuses of a subscriber's subscriptions to
+// lists, creating the subscriptions that don't exist with the given source, and
+// returns the subscriptions whose status changed.
+func (c *Core) SetSubscriptionStatuses(subID int, subs []models.SubscriptionChange, userID int, source string) ([]models.SubscriptionChange, error) {
+	var (
+		listIDs  = make([]int, 0, len(subs))
+		statuses = make([]string, 0, len(subs))
+		reasons  = make([]string, 0, len(subs))
+		notes    = make([]string, 0, len(subs))
+	)
+	for _, s := range subs {
+		listIDs = append(listIDs, s.ListID)
+		statuses = append(statuses, s.Status)
+		reasons = append(reasons, s.Reason)
+		notes = append(notes, s.Note)
+	}
+
+	out := []models.SubscriptionChange{}
+	if err := c.q.SetSubscriptionStatuses.Select(&out, subID, pq.Array(listIDs), pq.StringArray(statuses),
+		pq.StringArray(reasons), pq.StringArray(notes), userID, source); err != nil {
+		c.log.Printf("error setting subscription statuses: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriptions}", "error", pqErrMsg(err)))
+	}
+
+	for _, s := range out {
+		typ := eventbus.TypeSubscribed
+		if s.Status == models.SubscriptionStatusUnsubscribed {
+			typ = eventbus.TypeUnsubscribed
+		}
+		c.publishEvent(typ, eventbus.Subscription{SubscriberID: subID, ListIDs: []int{s.ListID}, Status: s.Status, Source: source})
+	}
+
+	return out, nil
+}
+
+// RecordConsent appends a proof-of-consent record to a subscriber's subscriptions
+// to the given lists, identified either by IDs or UUIDs.
+func (c *Core) RecordConsent(subID int, listIDs []int, listUUIDs []string, cn models.Consent) error {
+	if cn.Timestamp.IsZero() {
+		cn.Timestamp = time.Now()
+	}
+
+	b, err := json.Marshal(cn)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.q.RecordSubscriptionConsent.Exec(subID, pq.Array(listIDs), pq.Array(listUUIDs), b); err != nil {
+		c.log.Printf("error recording consent: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
 	return nil
 }
 
 // AddSubscriptionsByQuery adds list subscriptions to subscribers by a given arbitrary query expression.
 // sourceListIDs is the list of list IDs to filter the subscriber query with.
-func (c *Core) AddSubscriptionsByQuery(query string, sourceListIDs, targetListIDs []int, status string, subStatus string) error {
+// source is the provenance recorded on new subscriptions.
+func (c *Core) AddSubscriptionsByQuery(query string, sourceListIDs, targetListIDs []int, status string, subStatus string, source string) error {
 	if sourceListIDs == nil {
 		sourceListIDs = []int{}
 	}
 
-	err := c.q.ExecSubQueryTpl(sanitizeSQLExp(query), c.q.AddSubscribersToListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs), status)
+	err := c.q.ExecSubQueryTpl(sanitizeSQLExp(query), c.q.AddSubscribersToListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs), status, source)
 	if err != nil {
 		c.log.Printf("error adding subscriptions by query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -87,6 +156,15 @@ func (c *Core) UnsubscribeLists(subIDs, listIDs []int, listUUIDs []string) error
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
 	}
 
+	for _, id := range subIDs {
+		c.publishEvent(eventbus.TypeUnsubscribed, eventbus.Subscription{
+			SubscriberID: id,
+			ListIDs:      listIDs,
+			ListUUIDs:    listUUIDs,
+			Status:       models.SubscriptionStatusUnsubscribed,
+		})
+	}
+
 	return nil
 }
 
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// blocklistBatchSize is the number of e-mails blocklisted in a single query.
+const blocklistBatchSize = 5000
+
+// GetSuppressionDiff diffs the e-mails of an external suppression source against
+// the local blocklist. source is the subscriber source that the source's
+// e-mails are blocklisted with.
+func (c *Core) GetSuppressionDiff(emails []string, source string) (models.SuppressionSync, error) {
+	var out models.SuppressionSync
+	if err := c.q.GetSuppressionDiff.Get(&out, pq.Array(emails), source); err != nil {
+		c.log.Printf("error diffing suppression list: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	out.NumAdded = len(out.Added)
+	out.NumRemoved = len(out.Removed)
+
+	return out, nil
+}
+
+// InsertSuppressionSync records a suppression sync and returns its ID.
+func (c *Core) InsertSuppressionSync(s models.SuppressionSync) (int, error) {
+	var id int
+	if err := c.q.InsertSuppressionSync.Get(&id, s.SourceUUID, s.SourceName, s.Status, s.Total,
+		pq.Array(s.Added), pq.Array(s.Removed), s.Error); err != nil {
+		c.log.Printf("error inserting suppression sync: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	return id, nil
+}
+
+// GetSuppressionSyncs returns the latest suppression syncs without their e-mail lists.
+func (c *Core) GetSuppressionSyncs() ([]models.SuppressionSync, error) {
+	out := []models.SuppressionSync{}
+	if err := c.q.GetSuppressionSyncs.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching suppression syncs: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSuppressionSync returns a suppression sync with its e-mail lists.
+func (c *Core) GetSuppressionSync(id int) (models.SuppressionSync, error) {
+	var out []models.SuppressionSync
+	if err := c.q.GetSuppressionSyncs.Select(&out, id); err != nil {
+		c.log.Printf("error fetching suppression sync: %v", err)
+		return models.SuppressionSync{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.SuppressionSync{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{settings.suppression.name}"))
+	}
+
+	return out[0], nil
+}
+
+// GetSuppressionLastSyncs returns the time of the last sync of every source by UUID.
+func (c *Core) GetSuppressionLastSyncs() (map[string]time.Time, error) {
+	var res []struct {
+		UUID      string    `db:"source_uuid"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	if err := c.q.GetSuppressionLastSyncs.Select(&res); err != nil {
+		c.log.Printf("error fetching suppression syncs: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	out := make(map[string]time.Time, len(res))
+	for _, r := range res {
+		out[r.UUID] = r.CreatedAt
+	}
+
+	return out, nil
+}
+
+// UpdateSuppressionSyncStatus sets the status of a pending suppression sync.
+func (c *Core) UpdateSuppressionSyncStatus(id int, status string) error {
+	res, err := c.q.UpdateSuppressionSyncStatus.Exec(id, status)
+	if err != nil {
+		c.log.Printf("error updating suppression sync: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{settings.suppression.name}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("settings.suppression.notPending"))
+	}
+
+	return nil
+}
+
+// BlocklistEmails blocklists the given e-mails, creating blocklisted subscribers
+// for the ones that aren't subscribers yet, and unsubscribes them from all lists.
+func (c *Core) BlocklistEmails(emails []string, source string) error {
+	for i := 0; i < len(emails); i += blocklistBatchSize {
+		end := i + blocklistBatchSize
+		if end > len(emails) {
+			end = len(emails)
+		}
+
+		batch := emails[i:end]
+		uuids := make([]string, len(batch))
+		for n := range batch {
+			uuids[n] = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if _, err := c.q.BlocklistEmails.Exec(pq.Array(uuids), pq.Array(batch), source); err != nil {
+			c.log.Printf("error blocklisting e-mails: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetTags returns all tags along with their usage counts across the given
+// object types (campaign, list, subscriber).
+func (c *Core) GetTags(types []string) ([]models.Tag, error) {
+	out := []models.Tag{}
+	if len(types) == 0 {
+		return out, nil
+	}
+
+	if err := c.q.GetTags.Select(&out, pq.StringArray(types)); err != nil {
+		c.log.Printf("error fetching tags: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.tags}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// MergeTags replaces all occurrences of the given tags with newTag across the given
+// object types. This is used for renaming and merging tags. If newTag is empty, the
+// tags are deleted. It returns the number of objects of each type that were updated.
+func (c *Core) MergeTags(tags []string, newTag string, types []string) (models.Tag, error) {
+	out := models.Tag{}
+	if len(types) == 0 {
+		return out, nil
+	}
+
+	if tg := normalizeTags([]string{newTag}); len(tg) > 0 {
+		newTag = tg[0]
+	} else {
+		newTag = ""
+	}
+
+	if err := c.q.MergeTags.Get(&out, pq.StringArray(tags), newTag, pq.StringArray(types)); err != nil {
+		c.log.Printf("error updating tags: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.tags}", "error", pqErrMsg(err)))
+	}
+
+	out.Tag = newTag
+	out.Total = out.Campaigns + out.Lists + out.Subscribers
+
+	return out, nil
+}
@@ -8,10 +8,11 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-// GetTemplates retrieves all templates.
-func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, error) {
+// GetTemplates retrieves all templates optionally filtered by folder
+// (> 0 for a folder, < 0 for templates not in any folder).
+func (c *Core) GetTemplates(status string, noBody bool, folderID int) ([]models.Template, error) {
 	out := []models.Template{}
-	if err := c.q.GetTemplates.Select(&out, 0, noBody, status); err != nil {
+	if err := c.q.GetTemplates.Select(&out, 0, noBody, status, folderID); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
@@ -22,7 +23,7 @@ func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, erro
 // GetTemplate retrieves a given template.
 func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 	var out []models.Template
-	if err := c.q.GetTemplates.Select(&out, id, noBody, ""); err != nil {
+	if err := c.q.GetTemplates.Select(&out, id, noBody, "", 0); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
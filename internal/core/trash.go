@@ -0,0 +1,71 @@
+package core
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetTrash returns trashed (soft-deleted) items of the given types.
+func (c *Core) GetTrash(types []string) ([]models.TrashItem, error) {
+	out := []models.TrashItem{}
+	if err := c.q.GetTrash.Select(&out, pq.Array(types)); err != nil {
+		c.log.Printf("error fetching trash: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.trash}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RestoreTrash restores trashed items of a given type.
+func (c *Core) RestoreTrash(typ string, ids []int) (int, error) {
+	var n int
+	if err := c.q.RestoreTrash.Get(&n, typ, pq.Array(ids)); err != nil {
+		// A list's custom domain or a campaign's archive slug has been taken
+		// by another one since it was trashed.
+		if isListDomainConflict(err) {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("lists.domainExists"))
+		}
+		if isArchiveSlugConflict(err) {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.archiveSlugExists"))
+		}
+		c.log.Printf("error restoring trash: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.trash}", "error", pqErrMsg(err)))
+	}
+
+	if n == 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.trash}"))
+	}
+
+	return n, nil
+}
+
+// PurgeTrash permanently deletes trashed items of the given types. If ids are
+// given, only those items are deleted. Otherwise, all items trashed before the
+// given time are deleted.
+func (c *Core) PurgeTrash(types []string, ids []int, before time.Time) (int, error) {
+	if ids == nil {
+		ids = []int{}
+	}
+
+	var res struct {
+		Total    int            `db:"total"`
+		BodyRefs pq.StringArray `db:"body_refs"`
+	}
+	if err := c.q.PurgeTrash.Get(&res, pq.Array(types), pq.Array(ids), before); err != nil {
+		c.log.Printf("error purging trash: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.trash}", "error", pqErrMsg(err)))
+	}
+
+	// Delete the bodies of the deleted campaigns from the media store.
+	c.deleteCampaignBodies(res.BodyRefs)
+
+	return res.Total, nil
+}
@@ -194,13 +194,16 @@ func (c *Core) formatUsers(users []models.User) []models.User {
 				for _, perm := range p.Permissions {
 					u.ListPermissionsMap[p.ID][perm] = struct{}{}
 
-					// List IDs with get / manage permissions.
+					// List IDs with get / manage / send permissions.
 					if perm == "list:get" {
 						u.GetListIDs = append(u.GetListIDs, p.ID)
 					}
 					if perm == "list:manage" {
 						u.ManageListIDs = append(u.ManageListIDs, p.ID)
 					}
+					if perm == "list:send" {
+						u.SendListIDs = append(u.SendListIDs, p.ID)
+					}
 				}
 			}
 		}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetWarehouseExports returns the export states of the tables that have been
+// exported to the data warehouse.
+func (c *Core) GetWarehouseExports() ([]models.WarehouseExport, error) {
+	out := []models.WarehouseExport{}
+	if err := c.q.GetWarehouseExports.Select(&out); err != nil {
+		c.log.Printf("error fetching warehouse exports: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{warehouse.exports}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetWarehouseRows returns the next batch of rows of a table to export after
+// the (cursorAt, cursorID) cursor, and the cursor of the last row. The rows of
+// tables that are never updated (views, clicks) are fetched by their IDs.
+func (c *Core) GetWarehouseRows(table string, cursorAt time.Time, cursorID int64, limit int) ([][]interface{}, time.Time, int64, error) {
+	var (
+		stmt *sqlx.Stmt
+		args []interface{}
+	)
+	switch table {
+	case models.WarehouseTableSubscribers:
+		stmt, args = c.q.GetWarehouseSubscribers, []interface{}{cursorAt, cursorID, limit}
+	case models.WarehouseTableDeliveries:
+		stmt, args = c.q.GetWarehouseDeliveries, []interface{}{cursorAt, cursorID, limit}
+	case models.WarehouseTableViews:
+		stmt, args = c.q.GetWarehouseViews, []interface{}{cursorID, limit}
+	case models.WarehouseTableClicks:
+		stmt, args = c.q.GetWarehouseClicks, []interface{}{cursorID, limit}
+	default:
+		return nil, cursorAt, cursorID, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", table))
+	}
+
+	rows, err := stmt.Queryx(args...)
+	if err != nil {
+		c.log.Printf("error fetching warehouse rows (%s): %v", table, err)
+		return nil, cursorAt, cursorID, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", table, "error", pqErrMsg(err)))
+	}
+	defer rows.Close()
+
+	out := [][]interface{}{}
+	for rows.Next() {
+		r, err := rows.SliceScan()
+		if err != nil {
+			c.log.Printf("error fetching warehouse rows (%s): %v", table, err)
+			return nil, cursorAt, cursorID, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", table, "error", pqErrMsg(err)))
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.log.Printf("error fetching warehouse rows (%s): %v", table, err)
+		return nil, cursorAt, cursorID, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", table, "error", pqErrMsg(err)))
+	}
+
+	// The id is the first column and the cursor timestamp the last.
+	if len(out) > 0 {
+		last := out[len(out)-1]
+		if id, ok := last[0].(int64); ok {
+			cursorID = id
+		}
+		if t, ok := last[len(last)-1].(time.Time); ok {
+			cursorAt = t
+		}
+	}
+
+	return out, cursorAt, cursorID, nil
+}
+
+// UpdateWarehouseCursor advances the cursor of a table's export after n rows
+// have been exported.
+func (c *Core) UpdateWarehouseCursor(table string, cursorAt time.Time, cursorID int64, n int) error {
+	if _, err := c.q.UpdateWarehouseCursor.Exec(table, cursorAt, cursorID, n); err != nil {
+		c.log.Printf("error updating warehouse cursor (%s): %v", table, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{warehouse.exports}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// UpdateWarehouseRun records the status of a run of a table's export.
+func (c *Core) UpdateWarehouseRun(table, status, errMsg string) error {
+	if _, err := c.q.UpdateWarehouseRun.Exec(table, status, errMsg); err != nil {
+		c.log.Printf("error updating warehouse export (%s): %v", table, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{warehouse.exports}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// ResetWarehouseExport resets the cursor of a table's export so that the
+// whole table is exported again on the next run.
+func (c *Core) ResetWarehouseExport(table string) error {
+	if _, err := c.q.ResetWarehouseExport.Exec(table); err != nil {
+		c.log.Printf("error resetting warehouse export (%s): %v", table, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{warehouse.exports}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
@@ -0,0 +1,313 @@
+// Package eventbus publishes listmonk's internal events (message sends,
+// campaign views, link clicks, bounces, subscriptions, unsubscriptions) to a
+// Kafka topic or a NATS subject as JSON so that downstream pipelines can
+// consume them in real time instead of polling the API. Events are queued in
+// memory and published in batches in the background. Publishing never blocks
+// the caller; events are dropped when the queue is full.
+package eventbus
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	DriverKafka = "kafka"
+	DriverNATS  = "nats"
+
+	// Version of the event envelope schema.
+	Version = 1
+
+	TypeMessageSent  = "message.sent"
+	TypeCampaignView = "campaign.view"
+	TypeLinkClick    = "link.click"
+	TypeBounce       = "bounce.recorded"
+	TypeSubscribed   = "subscriber.subscribed"
+	TypeUnsubscribed = "subscriber.unsubscribed"
+
+	// topicTypeTag in a topic is replaced by the event type, eg:
+	// listmonk.{type} => listmonk.message.sent.
+	topicTypeTag = "{type}"
+
+	defaultTopic         = "listmonk.events"
+	defaultBufferSize    = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+)
+
+// Types is the list of all the event types.
+var Types = []string{TypeMessageSent, TypeCampaignView, TypeLinkClick, TypeBounce, TypeSubscribed, TypeUnsubscribed}
+
+// Event is the envelope in which all events are published.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Message is the data of message.sent events.
+type Message struct {
+	CampaignID     int    `json:"campaign_id"`
+	CampaignUUID   string `json:"campaign_uuid"`
+	SubscriberID   int    `json:"subscriber_id"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+	Email          string `json:"email"`
+	Messenger      string `json:"messenger"`
+}
+
+// View is the data of campaign.view events. The subscriber is empty if
+// individual tracking is disabled.
+type View struct {
+	CampaignUUID   string `json:"campaign_uuid"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+	Proxy          bool   `json:"proxy"`
+}
+
+// Click is the data of link.click events. The subscriber is empty if
+// individual tracking is disabled.
+type Click struct {
+	LinkUUID       string `json:"link_uuid"`
+	URL            string `json:"url"`
+	CampaignUUID   string `json:"campaign_uuid"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+	BotReason      string `json:"bot_reason"`
+}
+
+// Bounce is the data of bounce.recorded events.
+type Bounce struct {
+	Email          string `json:"email"`
+	SubscriberUUID string `json:"subscriber_uuid"`
+	CampaignUUID   string `json:"campaign_uuid"`
+	Type           string `json:"type"`
+	Source         string `json:"source"`
+}
+
+// Subscription is the data of subscriber.subscribed and
+// subscriber.unsubscribed events. Depending on the action, the subscriber is
+// identified by the ID or the UUID, and the lists by IDs, UUIDs, or the
+// campaign whose lists were unsubscribed from.
+type Subscription struct {
+	SubscriberID   int      `json:"subscriber_id,omitempty"`
+	SubscriberUUID string   `json:"subscriber_uuid,omitempty"`
+	Email          string   `json:"email,omitempty"`
+	ListIDs        []int    `json:"list_ids,omitempty"`
+	ListUUIDs      []string `json:"list_uuids,omitempty"`
+	CampaignUUID   string   `json:"campaign_uuid,omitempty"`
+	Status         string   `json:"status"`
+	Source         string   `json:"source,omitempty"`
+}
+
+// Opt is the event bus config.
+type Opt struct {
+	Driver string `koanf:"driver"`
+
+	// Topic is the Kafka topic or NATS subject events are published to.
+	// {type} in it is replaced by the event type.
+	Topic string `koanf:"topic"`
+
+	// Events are the types of events to publish. All events are published
+	// if it's empty.
+	Events []string `koanf:"events"`
+
+	// BufferSize is the maximum number of events queued in memory.
+	BufferSize int `koanf:"buffer_size"`
+
+	// Events are published in batches of up to BatchSize events, or
+	// every FlushInterval.
+	BatchSize     int           `koanf:"batch_size"`
+	FlushInterval time.Duration `koanf:"flush_interval"`
+
+	Kafka KafkaOpt `koanf:"kafka"`
+	NATS  NATSOpt  `koanf:"nats"`
+}
+
+// driver publishes batches of encoded events to topics.
+type driver interface {
+	Publish(topic string, events []Event) error
+	Close() error
+}
+
+// Bus queues and publishes events.
+type Bus struct {
+	opt    Opt
+	drv    driver
+	types  map[string]bool
+	log    *log.Logger
+	queue  chan Event
+	wg     sync.WaitGroup
+	closed bool
+	mu     sync.RWMutex
+}
+
+// New returns a new instance of the event bus.
+func New(o Opt, lo *log.Logger) (*Bus, error) {
+	if o.Topic == "" {
+		o.Topic = defaultTopic
+	}
+	if o.BufferSize < 1 {
+		o.BufferSize = defaultBufferSize
+	}
+	if o.BatchSize < 1 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.FlushInterval < time.Millisecond*10 {
+		o.FlushInterval = defaultFlushInterval
+	}
+
+	types := make(map[string]bool, len(Types))
+	for _, t := range Types {
+		types[t] = len(o.Events) == 0
+	}
+	for _, t := range o.Events {
+		if _, ok := types[t]; !ok {
+			return nil, fmt.Errorf("unknown event type: %s", t)
+		}
+		types[t] = true
+	}
+
+	var (
+		drv driver
+		err error
+	)
+	switch o.Driver {
+	case DriverKafka:
+		drv, err = newKafka(o.Kafka)
+	case DriverNATS:
+		drv, err = newNATS(o.NATS)
+	default:
+		return nil, fmt.Errorf("unknown event bus driver: %s", o.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bus{
+		opt:   o,
+		drv:   drv,
+		types: types,
+		log:   lo,
+		queue: make(chan Event, o.BufferSize),
+	}, nil
+}
+
+// Driver returns the name of the configured driver.
+func (b *Bus) Driver() string {
+	return b.opt.Driver
+}
+
+// Publish queues an event for publishing. It never blocks and drops the
+// event if the queue is full. Events of types that aren't enabled are ignored.
+func (b *Bus) Publish(typ string, data interface{}) {
+	if !b.types[typ] {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+
+	select {
+	case b.queue <- Event{ID: uuid.Must(uuid.NewV4()).String(), Type: typ, Version: Version, CreatedAt: time.Now(), Data: data}:
+	default:
+		b.log.Printf("event bus queue full. dropping %s event", typ)
+	}
+}
+
+// Start starts publishing the queued events in batches in a goroutine.
+func (b *Bus) Start() {
+	// Added before the goroutine starts so that a Close() that follows
+	// immediately waits for it.
+	b.wg.Add(1)
+	go b.run()
+}
+
+// run publishes the queued events in batches until the bus is closed.
+func (b *Bus) run() {
+	defer b.wg.Done()
+
+	var (
+		batch = make([]Event, 0, b.opt.BatchSize)
+		t     = time.NewTicker(b.opt.FlushInterval)
+	)
+	defer t.Stop()
+
+	for {
+		select {
+		case e, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+
+			batch = append(batch, e)
+			if len(batch) >= b.opt.BatchSize {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-t.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Close stops accepting events, publishes the queued events, and closes
+// the connection to the broker.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	b.drv.Close()
+}
+
+// flush publishes a batch of events grouped by their topics. Batches that
+// can't be published are logged and dropped.
+func (b *Bus) flush(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if !strings.Contains(b.opt.Topic, topicTypeTag) {
+		if err := b.drv.Publish(b.opt.Topic, batch); err != nil {
+			b.log.Printf("error publishing %d events to %s: %v", len(batch), b.opt.Driver, err)
+		}
+		return
+	}
+
+	var (
+		topics = []string{}
+		groups = map[string][]Event{}
+	)
+	for _, e := range batch {
+		t := strings.ReplaceAll(b.opt.Topic, topicTypeTag, e.Type)
+		if _, ok := groups[t]; !ok {
+			topics = append(topics, t)
+		}
+		groups[t] = append(groups[t], e)
+	}
+
+	for _, t := range topics {
+		if err := b.drv.Publish(t, groups[t]); err != nil {
+			b.log.Printf("error publishing %d events to %s: %v", len(groups[t]), b.opt.Driver, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package eventbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a NATS server that records the messages published to it.
+type fakeNATS struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	connect string
+	msgs    map[string][]Event
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeNATS{ln: ln, msgs: map[string][]Event{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeNATS) serve(c net.Conn) {
+	defer c.Close()
+
+	io.WriteString(c, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			s.mu.Lock()
+			s.connect = line[8:]
+			s.mu.Unlock()
+
+		case line == "PING":
+			io.WriteString(c, "PONG\r\n")
+
+		case strings.HasPrefix(line, "PUB "):
+			f := strings.Fields(line)
+			n, _ := strconv.Atoi(f[2])
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+
+			if f[1] == "denied" {
+				io.WriteString(c, "-ERR 'Permissions Violation for Publish to \"denied\"'\r\n")
+				continue
+			}
+
+			var e Event
+			json.Unmarshal(b[:n], &e)
+			s.mu.Lock()
+			s.msgs[f[1]] = append(s.msgs[f[1]], e)
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestNATS(t *testing.T) {
+	s := newFakeNATS(t)
+	defer s.ln.Close()
+
+	b, err := New(Opt{
+		Driver:        DriverNATS,
+		Topic:         "listmonk.{type}",
+		Events:        []string{TypeMessageSent, TypeLinkClick},
+		FlushInterval: time.Millisecond * 20,
+		NATS:          NATSOpt{URL: "nats://user:pass@" + s.ln.Addr().String()},
+	}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Start()
+
+	b.Publish(TypeMessageSent, map[string]int{"campaign_id": 1})
+	b.Publish(TypeLinkClick, map[string]int{"link_id": 2})
+	b.Publish(TypeMessageSent, map[string]int{"campaign_id": 3})
+
+	// Disabled event types are ignored.
+	b.Publish(TypeBounce, map[string]int{"id": 4})
+	b.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.Contains(s.connect, `"user":"user"`) || !strings.Contains(s.connect, `"pass":"pass"`) {
+		t.Errorf("credentials not sent in CONNECT: %s", s.connect)
+	}
+	if len(s.msgs) != 2 {
+		t.Fatalf("expected 2 subjects, got %v", s.msgs)
+	}
+
+	sent := s.msgs["listmonk.message.sent"]
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 sent events, got %d", len(sent))
+	}
+	for _, e := range sent {
+		if e.ID == "" || e.Type != TypeMessageSent || e.Version != Version || e.CreatedAt.IsZero() {
+			t.Errorf("invalid event envelope: %+v", e)
+		}
+	}
+	if d, _ := sent[1].Data.(map[string]interface{}); d["campaign_id"] != float64(3) {
+		t.Errorf("unexpected event data: %v", sent[1].Data)
+	}
+	if len(s.msgs["listmonk.link.click"]) != 1 {
+		t.Errorf("expected 1 click event, got %d", len(s.msgs["listmonk.link.click"]))
+	}
+}
+
+func TestNATSError(t *testing.T) {
+	s := newFakeNATS(t)
+	defer s.ln.Close()
+
+	n, err := newNATS(NATSOpt{URL: "nats://" + s.ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err := n.Publish("denied", []Event{{ID: "1"}}); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("expected permissions error, got %v", err)
+	}
+
+	// The connection is re-established after an error.
+	if err := n.Publish("allowed", []Event{{ID: "2"}}); err != nil {
+		t.Errorf("error publishing after reconnect: %v", err)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		recs = map[string][]kafkaRecord{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != kafkaContentType {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if u, p, _ := r.BasicAuth(); u != "user" || p != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Records []kafkaRecord `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		topic := strings.TrimPrefix(r.URL.Path, "/topics/")
+		if topic == "broken" {
+			io.WriteString(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"topic not found"}]}`)
+			return
+		}
+
+		mu.Lock()
+		recs[topic] = append(recs[topic], req.Records...)
+		mu.Unlock()
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":1}]}`)
+	}))
+	defer srv.Close()
+
+	k, err := newKafka(KafkaOpt{URL: srv.URL + "/", Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []Event{{ID: "a", Type: TypeBounce}, {ID: "b", Type: TypeBounce}}
+	if err := k.Publish("listmonk.events", events); err != nil {
+		t.Fatal(err)
+	}
+	if r := recs["listmonk.events"]; len(r) != 2 || r[0].Key != "a" || r[1].Value.ID != "b" {
+		t.Errorf("unexpected records: %+v", r)
+	}
+
+	if err := k.Publish("broken", events); err == nil || !strings.Contains(err.Error(), "topic not found") {
+		t.Errorf("expected record error, got %v", err)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	lo := log.New(io.Discard, "", 0)
+	for _, o := range []Opt{
+		{Driver: "redis"},
+		{Driver: DriverKafka},
+		{Driver: DriverNATS, NATS: NATSOpt{URL: "http://localhost"}},
+		{Driver: DriverNATS, Events: []string{"unknown"}},
+	} {
+		if _, err := New(o, lo); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+}
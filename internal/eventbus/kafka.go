@@ -0,0 +1,111 @@
+package eventbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaOpt is the config of a Kafka REST proxy (Confluent REST Proxy v2
+// API, or compatible ones like Redpanda's HTTP proxy) that events are
+// produced to.
+type KafkaOpt struct {
+	URL      string        `koanf:"url"`
+	Username string        `koanf:"username"`
+	Password string        `koanf:"password"`
+	Timeout  time.Duration `koanf:"timeout"`
+}
+
+type kafka struct {
+	opt    KafkaOpt
+	client *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+func newKafka(o KafkaOpt) (*kafka, error) {
+	if o.URL == "" {
+		return nil, fmt.Errorf("kafka: url is not set")
+	}
+	if o.Timeout < 1 {
+		o.Timeout = time.Second * 10
+	}
+	o.URL = strings.TrimRight(o.URL, "/")
+
+	return &kafka{opt: o, client: &http.Client{Timeout: o.Timeout}}, nil
+}
+
+// Publish produces a batch of events to a topic in a single request. The
+// event ID is the record key.
+func (k *kafka) Publish(topic string, events []Event) error {
+	recs := make([]kafkaRecord, 0, len(events))
+	for _, e := range events {
+		recs = append(recs, kafkaRecord{Key: e.ID, Value: e})
+	}
+
+	b, err := json.Marshal(struct {
+		Records []kafkaRecord `json:"records"`
+	}{recs})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, k.opt.URL+"/topics/"+url.PathEscape(topic), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.opt.Username != "" {
+		req.SetBasicAuth(k.opt.Username, k.opt.Password)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kafka: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// Records that failed are reported per record with a 200 response.
+	var res struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil
+	}
+
+	failed, msg := 0, ""
+	for _, o := range res.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			failed++
+			msg = o.Error
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("kafka: %d of %d records failed: %s", failed, len(events), msg)
+	}
+
+	return nil
+}
+
+func (k *kafka) Close() error {
+	return nil
+}
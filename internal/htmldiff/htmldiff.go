@@ -0,0 +1,274 @@
+// Package htmldiff computes word level differences between two versions of
+// a campaign body. HTML tags, comments, and template expressions are never
+// split, so that diffs of HTML bodies can be reviewed and rendered without
+// breaking the markup.
+package htmldiff
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+
+	// maxEdits is the maximum number of token edits computed before falling
+	// back to a coarser diff. It bounds the memory and time taken by large
+	// rewrites.
+	maxEdits = 1000
+)
+
+// Op is a run of text that is equal in, inserted into, or deleted from the
+// new version.
+type Op struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Diff returns the ops that turn a into b. Words, whitespace, punctuation,
+// HTML tags, and {{ template }} expressions are compared as whole tokens. If
+// the versions differ too much to be compared word by word, they're compared
+// line by line, and failing that, b replaces a entirely.
+func Diff(a, b string) []Op {
+	if a == b {
+		if a == "" {
+			return []Op{}
+		}
+		return []Op{{Type: OpEqual, Text: a}}
+	}
+
+	if ops, ok := diffTokens(tokenize(a), tokenize(b)); ok {
+		return ops
+	}
+	if ops, ok := diffTokens(splitLines(a), splitLines(b)); ok {
+		return ops
+	}
+
+	return merge([]Op{{Type: OpDelete, Text: a}, {Type: OpInsert, Text: b}})
+}
+
+// Changed returns true if any of the ops is an insertion or a deletion.
+func Changed(ops []Op) bool {
+	for _, o := range ops {
+		if o.Type != OpEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize splits a string into HTML tags and comments, template expressions,
+// words, runs of whitespace, and individual punctuation characters.
+func tokenize(s string) []string {
+	var out []string
+	for len(s) > 0 {
+		n := tokenLen(s)
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	return out
+}
+
+// tokenLen returns the length of the token that s starts with.
+func tokenLen(s string) int {
+	switch {
+	case strings.HasPrefix(s, "<!--"):
+		if i := strings.Index(s[4:], "-->"); i >= 0 {
+			return i + 7
+		}
+		return len(s)
+
+	case strings.HasPrefix(s, "{{"):
+		if i := strings.Index(s[2:], "}}"); i >= 0 {
+			return i + 4
+		}
+
+	case s[0] == '<' && len(s) > 1 && (isLetter(s[1]) || s[1] == '/' || s[1] == '!'):
+		// Quoted attribute values may contain '>'.
+		var quote byte
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '>':
+				return i + 1
+			}
+		}
+		return len(s)
+	}
+
+	r, n := utf8.DecodeRuneInString(s)
+	switch {
+	case unicode.IsSpace(r):
+		return runLen(s, unicode.IsSpace)
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		return runLen(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+	}
+
+	return n
+}
+
+// runLen returns the length of the prefix of s whose runes satisfy fn.
+func runLen(s string, fn func(rune) bool) int {
+	for i, r := range s {
+		if !fn(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// splitLines splits a string into lines that retain their line breaks.
+func splitLines(s string) []string {
+	return strings.SplitAfter(s, "\n")
+}
+
+// diffTokens diffs two token lists with Myers' algorithm after trimming
+// their common prefix and suffix. It returns false if they differ by more
+// than maxEdits tokens.
+func diffTokens(a, b []string) ([]Op, bool) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	mid, ok := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	if !ok {
+		return nil, false
+	}
+
+	out := make([]Op, 0, len(mid)+2)
+	if pre > 0 {
+		out = append(out, Op{Type: OpEqual, Text: strings.Join(a[:pre], "")})
+	}
+	out = append(out, mid...)
+	if suf > 0 {
+		out = append(out, Op{Type: OpEqual, Text: strings.Join(a[len(a)-suf:], "")})
+	}
+
+	return merge(out), true
+}
+
+// myers returns the shortest edit script that turns a into b, or false if
+// it's longer than maxEdits.
+func myers(a, b []string) ([]Op, bool) {
+	var (
+		n, m  = len(a), len(b)
+		total = n + m
+		off   = total + 1
+		v     = make([]int, 2*total+3)
+
+		// trace[d] holds the furthest x reached on the diagonals -d..d
+		// after d edits, for backtracking.
+		trace [][]int
+		end   = -1
+	)
+
+	for d := 0; d <= total && end < 0; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+
+			if x >= n && y >= m {
+				end = d
+				break
+			}
+		}
+
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+
+	// Walk back from the end, recording the ops in reverse.
+	var (
+		out  []Op
+		x, y = n, m
+	)
+	for d := end; d > 0; d-- {
+		var (
+			prev = trace[d-1]
+			k    = x - y
+			down = k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1])
+			pk   = k - 1
+		)
+		if down {
+			pk = k + 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+
+		// The position after the edit, from which the diagonal snake started.
+		mx, my := px+1, py
+		if down {
+			mx, my = px, py+1
+		}
+		for x > mx && y > my {
+			out = append(out, Op{Type: OpEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+
+		if down {
+			out = append(out, Op{Type: OpInsert, Text: b[py]})
+		} else {
+			out = append(out, Op{Type: OpDelete, Text: a[px]})
+		}
+		x, y = px, py
+	}
+	for x > 0 && y > 0 {
+		out = append(out, Op{Type: OpEqual, Text: a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return out, true
+}
+
+// merge joins consecutive ops of the same type and drops empty ones.
+func merge(ops []Op) []Op {
+	out := make([]Op, 0, len(ops))
+	for _, o := range ops {
+		if o.Text == "" {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Type == o.Type {
+			out[n-1].Text += o.Text
+			continue
+		}
+		out = append(out, o)
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package htmldiff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// apply rebuilds both versions from the ops.
+func apply(ops []Op) (string, string) {
+	var a, b strings.Builder
+	for _, o := range ops {
+		if o.Type != OpInsert {
+			a.WriteString(o.Text)
+		}
+		if o.Type != OpDelete {
+			b.WriteString(o.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		a, b string
+		exp  []Op
+	}{
+		{"", "", []Op{}},
+		{"same", "same", []Op{{OpEqual, "same"}}},
+		{
+			`<p>Hello world</p>`,
+			`<p>Hello there world</p>`,
+			[]Op{{OpEqual, "<p>Hello "}, {OpInsert, "there "}, {OpEqual, "world</p>"}},
+		},
+		{
+			// Tags are compared whole, even with '>' in attributes.
+			`<a href="https://a.com?x=>">Link</a>`,
+			`<a href="https://b.com?x=>">Link</a>`,
+			[]Op{{OpDelete, `<a href="https://a.com?x=>">`}, {OpInsert, `<a href="https://b.com?x=>">`}, {OpEqual, "Link</a>"}},
+		},
+		{
+			`Hi {{ .Subscriber.Name }}!`,
+			`Hi {{ .Subscriber.FirstName }}!`,
+			[]Op{{OpEqual, "Hi "}, {OpDelete, "{{ .Subscriber.Name }}"}, {OpInsert, "{{ .Subscriber.FirstName }}"}, {OpEqual, "!"}},
+		},
+		{
+			"<b>old</b> text",
+			"<i>new</i> text",
+			[]Op{{OpDelete, "<b>old</b>"}, {OpInsert, "<i>new</i>"}, {OpEqual, " text"}},
+		},
+	}
+
+	for _, c := range cases {
+		ops := Diff(c.a, c.b)
+		if !reflect.DeepEqual(ops, c.exp) {
+			t.Errorf("Diff(%q, %q):\n got %v\nwant %v", c.a, c.b, ops, c.exp)
+		}
+		if a, b := apply(ops); a != c.a || b != c.b {
+			t.Errorf("ops don't rebuild the versions: %q, %q", a, b)
+		}
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 3000; i++ {
+		a.WriteString("<p>alpha beta</p>\n")
+		b.WriteString("<p>gamma delta</p>\n")
+	}
+
+	// Versions that differ entirely still rebuild from the ops.
+	ops := Diff(a.String(), b.String())
+	if !Changed(ops) {
+		t.Fatal("expected changes")
+	}
+	if x, y := apply(ops); x != a.String() || y != b.String() {
+		t.Error("ops don't rebuild the versions")
+	}
+}
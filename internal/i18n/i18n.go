@@ -8,6 +8,7 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 // I18n offers translation functions over a language map.
@@ -15,6 +16,9 @@ type I18n struct {
 	code    string `json:"code"`
 	name    string `json:"name"`
 	langMap map[string]string
+
+	// Guards the fields above against language maps being reloaded at runtime.
+	mut sync.RWMutex
 }
 
 var reParam = regexp.MustCompile(`(?i)\{([a-z0-9-.]+)\}`)
@@ -51,32 +55,77 @@ func (i *I18n) Load(b []byte) error {
 		return err
 	}
 
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
 	for k, v := range l {
 		i.langMap[k] = v
 	}
 
+	// Language maps loaded on top of the default map carry their own identity.
+	if code, ok := l["_.code"]; ok {
+		i.code = code
+	}
+	if name, ok := l["_.name"]; ok {
+		i.name = name
+	}
+
 	return nil
 }
 
+// Replace replaces the instance's language map with that of the given instance.
+// This is used to reload a language that is shared across the app in-place.
+func (i *I18n) Replace(n *I18n) {
+	n.mut.RLock()
+	code, name, l := n.code, n.name, make(map[string]string, len(n.langMap))
+	for k, v := range n.langMap {
+		l[k] = v
+	}
+	n.mut.RUnlock()
+
+	i.mut.Lock()
+	i.code, i.name, i.langMap = code, name, l
+	i.mut.Unlock()
+}
+
+// Keys returns all the keys in the language map.
+func (i *I18n) Keys() []string {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
+
+	out := make([]string, 0, len(i.langMap))
+	for k := range i.langMap {
+		out = append(out, k)
+	}
+	return out
+}
+
 // Name returns the canonical name of the language.
 func (i *I18n) Name() string {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
 	return i.name
 }
 
 // Code returns the ISO code of the language.
 func (i *I18n) Code() string {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
 	return i.code
 }
 
 // JSON returns the languagemap as raw JSON.
 func (i *I18n) JSON() []byte {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
+
 	b, _ := json.Marshal(i.langMap)
 	return b
 }
 
 // T returns the translation for the given key similar to vue i18n's t().
 func (i *I18n) T(key string) string {
-	s, ok := i.langMap[key]
+	s, ok := i.get(key)
 	if !ok {
 		return key
 	}
@@ -98,7 +147,7 @@ func (i *I18n) Ts(key string, params ...string) string {
 		return key + `: Invalid arguments`
 	}
 
-	s, ok := i.langMap[key]
+	s, ok := i.get(key)
 	if !ok {
 		return key
 	}
@@ -117,7 +166,7 @@ func (i *I18n) Ts(key string, params ...string) string {
 // It expects the language string in the map to be of the form `Singular | Plural` and
 // returns `Plural` if n > 1, or `Singular` otherwise.
 func (i *I18n) Tc(key string, n int) string {
-	s, ok := i.langMap[key]
+	s, ok := i.get(key)
 	if !ok {
 		return key
 	}
@@ -130,6 +179,14 @@ func (i *I18n) Tc(key string, n int) string {
 	return i.getSingular(s)
 }
 
+// get returns the raw value of a key from the language map.
+func (i *I18n) get(key string) (string, bool) {
+	i.mut.RLock()
+	s, ok := i.langMap[key]
+	i.mut.RUnlock()
+	return s, ok
+}
+
 // getSingular returns the singular term from the vuei18n pipe separated value.
 // singular term | plural term
 func (i *I18n) getSingular(s string) string {
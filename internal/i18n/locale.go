@@ -0,0 +1,75 @@
+package i18n
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// rtlLangs is the list of base languages that are written right-to-left.
+var rtlLangs = map[string]bool{
+	"ar": true,
+	"dv": true,
+	"fa": true,
+	"he": true,
+	"ku": true,
+	"ps": true,
+	"sd": true,
+	"ug": true,
+	"ur": true,
+	"yi": true,
+}
+
+// IsRTL returns true if the given language code is of a right-to-left language.
+func IsRTL(code string) bool {
+	base := strings.ToLower(strings.SplitN(strings.ReplaceAll(code, "_", "-"), "-", 2)[0])
+	return rtlLangs[base]
+}
+
+// IsRTL returns true if the language is written right-to-left.
+func (i *I18n) IsRTL() bool {
+	return IsRTL(i.Code())
+}
+
+// Dir returns the text direction of the language for use in the HTML dir attribute.
+func (i *I18n) Dir() string {
+	if i.IsRTL() {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// FormatDate formats the given time with the Go layout string. Month (Jan, January) and
+// weekday (Mon, Monday) names in the layout are replaced with the language's
+// translated (short) names from the language map.
+func (i *I18n) FormatDate(t time.Time, layout string) string {
+	if layout == "" {
+		layout = "2 Jan 2006"
+	}
+
+	// Swap the month and weekday names in the layout with placeholders
+	// that are substituted after formatting.
+	layout = strings.NewReplacer("January", "\x01", "Jan", "\x01", "Monday", "\x02", "Mon", "\x02").Replace(layout)
+	out := t.Format(layout)
+
+	return strings.NewReplacer(
+		"\x01", i.T(fmt.Sprintf("globals.months.%d", t.Month())),
+		"\x02", i.T(fmt.Sprintf("globals.days.%d", t.Weekday()+1)),
+	).Replace(out)
+}
+
+// FormatNumber formats the given number with the language's digit grouping and
+// decimal separators, rounded to the given number of decimal places.
+func (i *I18n) FormatNumber(n float64, decimals int) string {
+	tag, err := language.Parse(i.Code())
+	if err != nil {
+		tag = language.English
+	}
+
+	return message.NewPrinter(tag).Sprint(number.Decimal(n,
+		number.MinFractionDigits(decimals), number.MaxFractionDigits(decimals)))
+}
@@ -0,0 +1,319 @@
+// Package l10n extracts the translatable strings of a campaign's subject and
+// body, exchanges them with external translation tools as XLIFF or PO files,
+// and applies the translations to generate the campaign's language variants.
+package l10n
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formats of translation files.
+const (
+	FormatXLIFF = "xliff"
+	FormatPO    = "po"
+)
+
+// IDs of the units. Body units are numbered, eg: body.1, body.2.
+const (
+	unitSubject = "subject"
+	unitBody    = "body."
+)
+
+// ErrNoTranslations is returned when a translation file has no translated units.
+var ErrNoTranslations = errors.New("no translated strings found")
+
+var (
+	// Paragraphs of plain text and Markdown bodies are separated by blank lines.
+	reParagraphs = regexp.MustCompile(`\n[ \t]*\n`)
+
+	htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// Unit is a translatable string with its translation, if there's one.
+type Unit struct {
+	ID     string
+	Source string
+	Target string
+}
+
+// Content is the subject and body of a campaign or one of its variants.
+type Content struct {
+	Subject     string
+	Body        string
+	ContentType string
+}
+
+// span is the location of a translatable string in a body.
+type span struct {
+	start, end int
+}
+
+// Extract returns the translatable units of the content: the subject and
+// the text in the body. The text of HTML bodies is segmented by tags and
+// that of plain text and Markdown bodies by paragraphs.
+func Extract(c Content) []Unit {
+	out := []Unit{{ID: unitSubject, Source: c.Subject}}
+
+	isHTML := isHTMLType(c.ContentType)
+	for i, s := range segment(c.Body, isHTML) {
+		out = append(out, Unit{ID: unitBody + strconv.Itoa(i+1), Source: decode(c.Body[s.start:s.end], isHTML)})
+	}
+
+	return out
+}
+
+// Apply returns the content with its units replaced with the given translations
+// (unit ID => translation). Units without translations are left untranslated
+// and their count is returned.
+func Apply(c Content, tr map[string]string) (Content, int, error) {
+	var (
+		out     = c
+		missing = 0
+		found   = 0
+	)
+
+	if t := strings.TrimSpace(tr[unitSubject]); t != "" {
+		out.Subject = t
+		found++
+	} else {
+		missing++
+	}
+
+	var (
+		isHTML = isHTMLType(c.ContentType)
+		b      strings.Builder
+		last   = 0
+	)
+	for i, s := range segment(c.Body, isHTML) {
+		t, ok := tr[unitBody+strconv.Itoa(i+1)]
+		if !ok || strings.TrimSpace(t) == "" {
+			missing++
+			continue
+		}
+		found++
+
+		b.WriteString(c.Body[last:s.start])
+		if isHTML {
+			b.WriteString(htmlEscaper.Replace(t))
+		} else {
+			b.WriteString(t)
+		}
+		last = s.end
+	}
+	b.WriteString(c.Body[last:])
+	out.Body = b.String()
+
+	if found == 0 {
+		return c, missing, ErrNoTranslations
+	}
+
+	return out, missing, nil
+}
+
+// segment returns the spans of the translatable strings in a body.
+func segment(body string, isHTML bool) []span {
+	var out []span
+	if isHTML {
+		out = segmentHTML(body)
+	} else {
+		out = segmentText(body)
+	}
+
+	// Skip strings that have nothing to translate, eg: only numbers,
+	// punctuation, or template expressions.
+	n := 0
+	for _, s := range out {
+		if hasText(body[s.start:s.end]) {
+			out[n] = s
+			n++
+		}
+	}
+
+	return out[:n]
+}
+
+// segmentHTML returns the spans of the text between the tags of an HTML body,
+// excluding comments and the contents of <style> and <script> tags. Leading and
+// trailing whitespace is excluded from the spans.
+func segmentHTML(body string) []span {
+	var (
+		out   []span
+		start = 0
+		i     = 0
+	)
+
+	add := func(start, end int) {
+		for start < end && isSpace(body[start]) {
+			start++
+		}
+		for end > start && isSpace(body[end-1]) {
+			end--
+		}
+		if start < end {
+			out = append(out, span{start, end})
+		}
+	}
+
+	for i < len(body) {
+		switch {
+		// Template expressions in text are a part of the text.
+		case strings.HasPrefix(body[i:], "{{"):
+			i = skipPast(body, i+2, "}}")
+
+		case body[i] == '<' && strings.HasPrefix(body[i:], "<!--"):
+			add(start, i)
+			i = skipPast(body, i+4, "-->")
+			start = i
+
+		case body[i] == '<' && i+1 < len(body) && (isLetter(body[i+1]) || body[i+1] == '/' || body[i+1] == '!'):
+			add(start, i)
+
+			name := tagName(body[i+1:])
+			i = skipTag(body, i)
+
+			// Skip the contents of tags that don't have text.
+			if name == "style" || name == "script" {
+				if end := strings.Index(strings.ToLower(body[i:]), "</"+name); end >= 0 {
+					i = skipTag(body, i+end)
+				} else {
+					i = len(body)
+				}
+			}
+			start = i
+
+		default:
+			i++
+		}
+	}
+	add(start, len(body))
+
+	return out
+}
+
+// segmentText returns the spans of the paragraphs of a plain text or Markdown
+// body. Fenced code blocks are skipped.
+func segmentText(body string) []span {
+	var (
+		out   []span
+		start = 0
+		fence = false
+	)
+
+	add := func(start, end int) {
+		for start < end && isSpace(body[start]) {
+			start++
+		}
+		for end > start && isSpace(body[end-1]) {
+			end--
+		}
+		if start >= end {
+			return
+		}
+
+		// Code fences can have blank lines in them.
+		p := body[start:end]
+		if n := strings.Count(p, "```"); n%2 == 1 {
+			fence = !fence
+			return
+		} else if fence || n > 0 {
+			return
+		}
+		out = append(out, span{start, end})
+	}
+
+	for _, loc := range reParagraphs.FindAllStringIndex(body, -1) {
+		add(start, loc[0])
+		start = loc[1]
+	}
+	add(start, len(body))
+
+	return out
+}
+
+// skipTag returns the position after the end of the tag that starts at i,
+// skipping quoted attribute values and template expressions in it.
+func skipTag(body string, i int) int {
+	var quote byte
+	for i++; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case strings.HasPrefix(body[i:], "{{"):
+			i = skipPast(body, i+2, "}}") - 1
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(body)
+}
+
+// skipPast returns the position after the first occurrence of sep in
+// body from i, or the end of the body.
+func skipPast(body string, i int, sep string) int {
+	if n := strings.Index(body[i:], sep); n >= 0 {
+		return i + n + len(sep)
+	}
+	return len(body)
+}
+
+// tagName returns the lowercase name of the tag at the start of s.
+func tagName(s string) string {
+	n := 0
+	for n < len(s) && (isLetter(s[n]) || (n > 0 && s[n] >= '0' && s[n] <= '9')) {
+		n++
+	}
+	return strings.ToLower(s[:n])
+}
+
+// decode returns the text of a string in a body.
+func decode(s string, isHTML bool) string {
+	if !isHTML {
+		return s
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// hasText checks whether a string has any letters outside template expressions.
+func hasText(s string) bool {
+	for s != "" {
+		i := strings.Index(s, "{{")
+		if i < 0 {
+			i = len(s)
+		}
+
+		for _, r := range html.UnescapeString(s[:i]) {
+			if unicode.IsLetter(r) {
+				return true
+			}
+		}
+
+		if i == len(s) {
+			break
+		}
+		s = s[skipPast(s, i+2, "}}"):]
+	}
+
+	return false
+}
+
+func isHTMLType(contentType string) bool {
+	return contentType != "plain" && contentType != "markdown"
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
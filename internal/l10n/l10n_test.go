@@ -0,0 +1,157 @@
+package l10n
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testContent = Content{
+	Subject: "Hello {{ .Subscriber.FirstName }}",
+	Body: `<p class="{{ if .Subscriber.Attribs.vip }}vip{{ end }}">Hi &amp; welcome, <b>friend</b></p>` +
+		`<style>p { color: red; }</style><!-- Comment --><a href="{{ TrackLink "https://listmonk.app" }}">Click here</a> 2024`,
+	ContentType: "richtext",
+}
+
+func TestExtract(t *testing.T) {
+	exp := []Unit{
+		{ID: "subject", Source: "Hello {{ .Subscriber.FirstName }}"},
+		{ID: "body.1", Source: "Hi & welcome,"},
+		{ID: "body.2", Source: "friend"},
+		{ID: "body.3", Source: "Click here"},
+	}
+
+	units := Extract(testContent)
+	if len(units) != len(exp) {
+		t.Fatalf("expected %d units, got %d: %+v", len(exp), len(units), units)
+	}
+	for i, u := range units {
+		if u != exp[i] {
+			t.Errorf("unit %d: expected %+v, got %+v", i, exp[i], u)
+		}
+	}
+}
+
+func TestExtractMarkdown(t *testing.T) {
+	c := Content{
+		Subject:     "Hello",
+		Body:        "First line\nsecond line\n\n```\ncode\n\nmore code\n```\n\n{{ TrackView }}\n\nLast",
+		ContentType: "markdown",
+	}
+
+	units := Extract(c)
+	if len(units) != 3 || units[1].Source != "First line\nsecond line" || units[2].Source != "Last" {
+		t.Fatalf("unexpected units: %+v", units)
+	}
+}
+
+func TestApply(t *testing.T) {
+	out, missing, err := Apply(testContent, map[string]string{
+		"subject": "Hallo {{ .Subscriber.FirstName }}",
+		"body.1":  "Hallo & willkommen,",
+		"body.3":  "Hier klicken",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `<p class="{{ if .Subscriber.Attribs.vip }}vip{{ end }}">Hallo &amp; willkommen, <b>friend</b></p>` +
+		`<style>p { color: red; }</style><!-- Comment --><a href="{{ TrackLink "https://listmonk.app" }}">Hier klicken</a> 2024`
+	if out.Body != exp {
+		t.Errorf("unexpected body:\n%s", out.Body)
+	}
+	if out.Subject != "Hallo {{ .Subscriber.FirstName }}" {
+		t.Errorf("unexpected subject: %s", out.Subject)
+	}
+	if missing != 1 {
+		t.Errorf("expected 1 missing unit, got %d", missing)
+	}
+
+	if _, _, err := Apply(testContent, nil); err != ErrNoTranslations {
+		t.Errorf("expected ErrNoTranslations, got %v", err)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	units := Extract(testContent)
+	units[0].Target = "Hallo \"{{ .Subscriber.FirstName }}\""
+	units[1].Target = "Zeile eins\nZeile zwei"
+
+	for _, f := range []struct {
+		name  string
+		write func(*bytes.Buffer) error
+		parse func(*bytes.Buffer) (string, map[string]string, error)
+	}{
+		{
+			FormatXLIFF,
+			func(b *bytes.Buffer) error { return WriteXLIFF(b, units, "campaign-1", "en", "de") },
+			func(b *bytes.Buffer) (string, map[string]string, error) { return ParseXLIFF(b) },
+		},
+		{
+			FormatPO,
+			func(b *bytes.Buffer) error { return WritePO(b, units, "campaign-1", "en", "de") },
+			func(b *bytes.Buffer) (string, map[string]string, error) { return ParsePO(b) },
+		},
+	} {
+		var b bytes.Buffer
+		if err := f.write(&b); err != nil {
+			t.Fatalf("%s: error writing: %v", f.name, err)
+		}
+
+		lang, tr, err := f.parse(&b)
+		if err != nil {
+			t.Fatalf("%s: error parsing: %v", f.name, err)
+		}
+		if lang != "de" {
+			t.Errorf("%s: expected language de, got %s", f.name, lang)
+		}
+		if len(tr) != 2 || tr["subject"] != units[0].Target || tr["body.1"] != units[1].Target {
+			t.Errorf("%s: unexpected translations: %+v", f.name, tr)
+		}
+	}
+}
+
+func TestParseXLIFF2(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="en" trgLang="fr">
+  <file id="f1">
+    <unit id="subject"><segment><source>Hello</source><target>Bonjour</target></segment></unit>
+    <unit id="body.1"><segment><source>Hi</source><target></target></segment></unit>
+  </file>
+</xliff>`
+
+	lang, tr, err := ParseXLIFF(bytes.NewBufferString(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang != "fr" || len(tr) != 1 || tr["subject"] != "Bonjour" {
+		t.Errorf("unexpected result: %s %+v", lang, tr)
+	}
+}
+
+func TestParsePOFuzzy(t *testing.T) {
+	po := `msgid ""
+msgstr ""
+"Language: es\n"
+
+#, fuzzy
+msgctxt "subject"
+msgid "Hello"
+msgstr "Hola"
+
+msgctxt "body.1"
+msgid ""
+"Line one\n"
+"line two"
+msgstr ""
+"Línea uno\n"
+"línea dos"
+`
+
+	lang, tr, err := ParsePO(bytes.NewBufferString(po))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang != "es" || len(tr) != 1 || tr["body.1"] != "Línea uno\nlínea dos" {
+		t.Errorf("unexpected result: %s %+v", lang, tr)
+	}
+}
@@ -0,0 +1,152 @@
+package l10n
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WritePO writes the units as a gettext PO file. The unit IDs are the
+// message contexts (msgctxt) of the entries.
+func WritePO(w io.Writer, units []Unit, original, srcLang, tgtLang string) error {
+	b := bufio.NewWriter(w)
+
+	b.WriteString("# " + original + "\n")
+	b.WriteString("msgid \"\"\nmsgstr \"\"\n")
+	b.WriteString(`"Content-Type: text/plain; charset=UTF-8\n"` + "\n")
+	b.WriteString(`"Language: ` + poEscape(tgtLang) + `\n"` + "\n")
+	b.WriteString(`"X-Source-Language: ` + poEscape(srcLang) + `\n"` + "\n")
+
+	for _, u := range units {
+		b.WriteString("\nmsgctxt " + poQuote(u.ID) + "\n")
+		b.WriteString("msgid " + poQuote(u.Source) + "\n")
+		b.WriteString("msgstr " + poQuote(u.Target) + "\n")
+	}
+
+	return b.Flush()
+}
+
+// ParsePO parses a gettext PO file and returns its language and translations
+// (msgctxt => msgstr). Fuzzy entries are skipped.
+func ParsePO(r io.Reader) (string, map[string]string, error) {
+	var (
+		lang = ""
+		out  = map[string]string{}
+
+		ctx, id, str string
+		fuzzy, hasID bool
+
+		// Field that continuation lines are appended to.
+		cur *string
+	)
+
+	flush := func() {
+		switch {
+		case !hasID:
+		case id == "" && ctx == "":
+			// The header.
+			for _, l := range strings.Split(str, "\n") {
+				if k, v, ok := strings.Cut(l, ":"); ok && strings.TrimSpace(k) == "Language" {
+					lang = strings.TrimSpace(v)
+				}
+			}
+		case !fuzzy && strings.TrimSpace(str) != "":
+			out[ctx] = str
+		}
+
+		ctx, id, str = "", "", ""
+		fuzzy, hasID = false, false
+		cur = nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		l := strings.TrimSpace(sc.Text())
+
+		switch {
+		case l == "":
+			continue
+
+		case strings.HasPrefix(l, "#"):
+			// A comment starts a new entry.
+			if hasID {
+				flush()
+			}
+			if strings.HasPrefix(l, "#,") && strings.Contains(l, "fuzzy") {
+				fuzzy = true
+			}
+			continue
+
+		case strings.HasPrefix(l, `"`):
+			if cur == nil {
+				return "", nil, fmt.Errorf("error parsing PO: unexpected string on line %d", n)
+			}
+			s, err := poUnquote(l)
+			if err != nil {
+				return "", nil, fmt.Errorf("error parsing PO: line %d: %v", n, err)
+			}
+			*cur += s
+			continue
+		}
+
+		key, val, _ := strings.Cut(l, " ")
+		s, err := poUnquote(strings.TrimSpace(val))
+		if err != nil {
+			return "", nil, fmt.Errorf("error parsing PO: line %d: %v", n, err)
+		}
+
+		switch key {
+		case "msgctxt":
+			if hasID {
+				flush()
+			}
+			ctx, cur = s, &ctx
+		case "msgid":
+			if hasID {
+				flush()
+			}
+			id, cur, hasID = s, &id, true
+		case "msgstr", "msgstr[0]":
+			str, cur = s, &str
+		default:
+			// Plural forms and other fields don't apply.
+			cur = nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", nil, fmt.Errorf("error reading PO: %v", err)
+	}
+	flush()
+
+	return lang, out, nil
+}
+
+// poQuote returns a PO string. Multi-line strings are split into lines.
+func poQuote(s string) string {
+	if !strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		return `"` + poEscape(s) + `"`
+	}
+
+	var b strings.Builder
+	b.WriteString(`""`)
+	for _, l := range strings.SplitAfter(s, "\n") {
+		if l != "" {
+			b.WriteString("\n\"" + poEscape(l) + `"`)
+		}
+	}
+	return b.String()
+}
+
+func poEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(s)
+}
+
+func poUnquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid string: %s", s)
+	}
+	return strconv.Unquote(s)
+}
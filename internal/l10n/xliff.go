@@ -0,0 +1,124 @@
+package l10n
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const xliffNS = "urn:oasis:names:tc:xliff:document:1.2"
+
+// xliffDoc is an XLIFF 1.2 or 2.0 document.
+type xliffDoc struct {
+	XMLName xml.Name    `xml:"xliff"`
+	NS      string      `xml:"xmlns,attr,omitempty"`
+	Version string      `xml:"version,attr"`
+	TrgLang string      `xml:"trgLang,attr,omitempty"`
+	Files   []xliffFile `xml:"file"`
+}
+
+type xliffFile struct {
+	Original       string `xml:"original,attr,omitempty"`
+	SourceLanguage string `xml:"source-language,attr,omitempty"`
+	TargetLanguage string `xml:"target-language,attr,omitempty"`
+	Datatype       string `xml:"datatype,attr,omitempty"`
+
+	// XLIFF 1.2 units, optionally in groups.
+	Units  []xliffUnit  `xml:"body>trans-unit"`
+	Groups []xliffGroup `xml:"body>group"`
+
+	// XLIFF 2.0 units.
+	Units2 []xliffUnit2 `xml:"unit"`
+}
+
+type xliffGroup struct {
+	Units []xliffUnit `xml:"trans-unit"`
+}
+
+type xliffUnit struct {
+	ID     string       `xml:"id,attr"`
+	Source string       `xml:"source"`
+	Target *xliffTarget `xml:"target"`
+	Note   string       `xml:"note,omitempty"`
+}
+
+type xliffTarget struct {
+	Text string `xml:",chardata"`
+}
+
+type xliffUnit2 struct {
+	ID       string `xml:"id,attr"`
+	Segments []struct {
+		Source string `xml:"source"`
+		Target string `xml:"target"`
+	} `xml:"segment"`
+}
+
+// WriteXLIFF writes the units as an XLIFF 1.2 document. original names the
+// source, eg: the campaign.
+func WriteXLIFF(w io.Writer, units []Unit, original, srcLang, tgtLang string) error {
+	f := xliffFile{
+		Original:       original,
+		SourceLanguage: srcLang,
+		TargetLanguage: tgtLang,
+		Datatype:       "html",
+		Units:          make([]xliffUnit, 0, len(units)),
+	}
+	for _, u := range units {
+		f.Units = append(f.Units, xliffUnit{ID: u.ID, Source: u.Source, Target: &xliffTarget{Text: u.Target}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(xliffDoc{NS: xliffNS, Version: "1.2", Files: []xliffFile{f}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ParseXLIFF parses an XLIFF 1.2 or 2.0 document and returns its target
+// language and translations (unit ID => translation).
+func ParseXLIFF(r io.Reader) (string, map[string]string, error) {
+	var doc xliffDoc
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("error parsing XLIFF: %v", err)
+	}
+
+	var (
+		lang = doc.TrgLang
+		out  = map[string]string{}
+	)
+	for _, f := range doc.Files {
+		if lang == "" {
+			lang = f.TargetLanguage
+		}
+
+		units := f.Units
+		for _, g := range f.Groups {
+			units = append(units, g.Units...)
+		}
+		for _, u := range units {
+			if u.Target != nil && strings.TrimSpace(u.Target.Text) != "" {
+				out[u.ID] = u.Target.Text
+			}
+		}
+
+		for _, u := range f.Units2 {
+			var t strings.Builder
+			for _, s := range u.Segments {
+				t.WriteString(s.Target)
+			}
+			if strings.TrimSpace(t.String()) != "" {
+				out[u.ID] = t.String()
+			}
+		}
+	}
+
+	return lang, out, nil
+}
@@ -0,0 +1,121 @@
+// Package leader elects a single leader among multiple instances (replicas)
+// of the app that share a database with a Postgres advisory lock. Only the
+// leader runs scheduled work (campaign scanning, pruning, syncs) while all
+// the instances serve HTTP traffic.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LockID is the key of the Postgres advisory lock held by the leader.
+const LockID int64 = 0x6c6d6c6561646572
+
+// Elector campaigns for and holds leadership. Advisory locks are held by
+// a DB session, so the lock is held on a dedicated connection. If the
+// connection dies, Postgres releases the lock and another instance takes over.
+type Elector struct {
+	db       *sqlx.DB
+	interval time.Duration
+	log      *log.Logger
+
+	conn     *sql.Conn
+	isLeader atomic.Bool
+	mut      sync.Mutex
+}
+
+// New returns a new Elector that tries to acquire leadership (or checks that
+// it's still held) at the given interval.
+func New(db *sqlx.DB, interval time.Duration, l *log.Logger) *Elector {
+	return &Elector{
+		db:       db,
+		interval: interval,
+		log:      l,
+	}
+}
+
+// Run is a blocking function that campaigns for leadership at the interval.
+func (e *Elector) Run() {
+	e.check()
+
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for range t.C {
+		e.check()
+	}
+}
+
+// IsLeader returns true if the instance is the leader.
+func (e *Elector) IsLeader() bool {
+	return e.isLeader.Load()
+}
+
+// Close releases leadership.
+func (e *Elector) Close() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	e.conn.ExecContext(ctx, `SELECT PG_ADVISORY_UNLOCK($1)`, LockID)
+	e.conn.Close()
+	e.conn = nil
+	e.isLeader.Store(false)
+}
+
+// check acquires the lock if it isn't held, or verifies that the connection
+// holding it is alive.
+func (e *Elector) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	// Leader. Check that the connection that holds the lock is alive.
+	if e.IsLeader() {
+		_, err := e.conn.ExecContext(ctx, `SELECT 1`)
+		if err == nil {
+			return
+		}
+
+		e.log.Printf("lost leadership: %v", err)
+		e.conn.Close()
+		e.conn = nil
+		e.isLeader.Store(false)
+	}
+
+	if e.conn == nil {
+		conn, err := e.db.Conn(ctx)
+		if err != nil {
+			e.log.Printf("error getting DB connection for leader election: %v", err)
+			return
+		}
+		e.conn = conn
+	}
+
+	var ok bool
+	if err := e.conn.QueryRowContext(ctx, `SELECT PG_TRY_ADVISORY_LOCK($1)`, LockID).Scan(&ok); err != nil {
+		e.log.Printf("error acquiring leader lock: %v", err)
+		e.conn.Close()
+		e.conn = nil
+		return
+	}
+
+	if ok {
+		e.log.Println("acquired leadership. running scheduled jobs on this instance")
+		e.isLeader.Store(true)
+	}
+}
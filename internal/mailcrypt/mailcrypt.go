@@ -0,0 +1,197 @@
+// Package mailcrypt signs and encrypts MIME entities for outgoing e-mails.
+// Messages are signed with an instance-level S/MIME certificate and are
+// encrypted to a recipient's S/MIME certificate or OpenPGP public key
+// (S/MIME, RFC 8551 and PGP/MIME, RFC 3156).
+package mailcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+const (
+	// KeyTypeSMIME is an X.509 certificate used for S/MIME encryption.
+	KeyTypeSMIME = "smime"
+
+	// KeyTypePGP is an OpenPGP public key used for PGP/MIME encryption.
+	KeyTypePGP = "pgp"
+
+	pemCert   = "CERTIFICATE"
+	pgpPubKey = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+)
+
+// Key is a recipient's public key that messages are encrypted to.
+type Key struct {
+	Type        string
+	Fingerprint string
+
+	cert     *x509.Certificate
+	entities openpgp.EntityList
+}
+
+// ParseKey parses a PEM encoded X.509 certificate or an ASCII armored
+// OpenPGP public key.
+func ParseKey(s string) (*Key, error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case strings.HasPrefix(s, pgpPubKey):
+		ents, err := openpgp.ReadArmoredKeyRing(strings.NewReader(s))
+		if err != nil {
+			return nil, fmt.Errorf("error reading PGP key: %v", err)
+		}
+		if len(ents) == 0 {
+			return nil, errors.New("no PGP keys found")
+		}
+
+		return &Key{
+			Type:        KeyTypePGP,
+			Fingerprint: strings.ToUpper(hex.EncodeToString(ents[0].PrimaryKey.Fingerprint[:])),
+			entities:    ents,
+		}, nil
+
+	case strings.Contains(s, "-----BEGIN "+pemCert):
+		b, _ := pem.Decode([]byte(s))
+		if b == nil || b.Type != pemCert {
+			return nil, errors.New("invalid PEM certificate")
+		}
+
+		cert, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error reading certificate: %v", err)
+		}
+
+		// Key transport to other key types (ECDH) isn't supported.
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, errors.New("only RSA certificates are supported for S/MIME encryption")
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+		return &Key{
+			Type:        KeyTypeSMIME,
+			Fingerprint: strings.ToUpper(hex.EncodeToString(sum[:])),
+			cert:        cert,
+		}, nil
+	}
+
+	return nil, errors.New("key should be a PEM certificate or an armored PGP public key")
+}
+
+// Check checks whether messages can be encrypted to the key, eg: that the
+// certificate hasn't expired or that the PGP key has a valid encryption subkey.
+func (k *Key) Check() error {
+	if k.Type == KeyTypeSMIME {
+		now := time.Now()
+		if now.Before(k.cert.NotBefore) || now.After(k.cert.NotAfter) {
+			return fmt.Errorf("certificate is valid only between %s and %s",
+				k.cert.NotBefore.Format(time.RFC3339), k.cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	_, err := k.Encrypt([]byte("Content-Type: text/plain\r\n\r\ntest\r\n"))
+	return err
+}
+
+// Encrypt encrypts a MIME entity (headers and body) and returns the
+// encrypted MIME entity that replaces it.
+func (k *Key) Encrypt(entity []byte) ([]byte, error) {
+	if k.Type == KeyTypeSMIME {
+		return k.encryptSMIME(entity)
+	}
+	return k.encryptPGP(entity)
+}
+
+// encryptSMIME returns an application/pkcs7-mime enveloped-data entity.
+func (k *Key) encryptSMIME(entity []byte) ([]byte, error) {
+	der, err := envelope(entity, k.cert)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	writeBase64(&b, der)
+
+	return b.Bytes(), nil
+}
+
+// encryptPGP returns a multipart/encrypted PGP/MIME entity.
+func (k *Key) encryptPGP(entity []byte) ([]byte, error) {
+	var msg bytes.Buffer
+	aw, err := armor.Encode(&msg, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openpgp.Encrypt(aw, k.entities, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting with PGP key: %v", err)
+	}
+	if _, err := w.Write(entity); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	bound, err := boundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"%s\"\r\n\r\n", bound)
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	b.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	b.WriteString("Version: 1\r\n\r\n")
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	b.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	b.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	b.Write(bytes.ReplaceAll(msg.Bytes(), []byte("\n"), []byte("\r\n")))
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// boundary returns a random MIME multipart boundary.
+func boundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return "=_" + hex.EncodeToString(b), nil
+}
+
+// writeBase64 writes base64 encoded data wrapped at 76 characters.
+func writeBase64(w *bytes.Buffer, data []byte) {
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 76 {
+		w.WriteString(s[:76])
+		w.WriteString("\r\n")
+		s = s[76:]
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
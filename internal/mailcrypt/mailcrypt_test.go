@@ -0,0 +1,238 @@
+package mailcrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEntity = []byte("Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: 7bit\r\n\r\n" +
+	"Hello,\r\nthis is a test message.\r\n")
+
+// newCert returns a self-signed S/MIME certificate and PEM encoded
+// certificate and private key.
+func newCert(t *testing.T, key crypto.Signer) (string, string) {
+	t.Helper()
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "listmonk test"},
+		EmailAddresses:        []string{"test@listmonk.app"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshalling key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: pemCert, Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+// openssl runs openssl with the given files written to a temp directory
+// and returns the contents of the out file. The test is skipped if openssl
+// isn't installed.
+func openssl(t *testing.T, files map[string]string, args ...string) []byte {
+	t.Helper()
+
+	bin, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not found")
+	}
+
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("openssl %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			certPEM, keyPEM := newCert(t, key)
+			s, err := NewSigner(certPEM, keyPEM)
+			if err != nil {
+				t.Fatalf("error creating signer: %v", err)
+			}
+
+			msg, err := s.Sign(testEntity)
+			if err != nil {
+				t.Fatalf("error signing: %v", err)
+			}
+
+			out := openssl(t, map[string]string{"msg": "MIME-Version: 1.0\r\n" + string(msg), "cert.pem": certPEM},
+				"smime", "-verify", "-in", "msg", "-CAfile", "cert.pem", "-out", "out")
+			if !bytes.Equal(out, testEntity) {
+				t.Errorf("verified content doesn't match:\n%q\n%q", out, testEntity)
+			}
+		})
+	}
+}
+
+func TestEncryptSMIME(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM := newCert(t, key)
+
+	k, err := ParseKey(certPEM)
+	if err != nil {
+		t.Fatalf("error parsing key: %v", err)
+	}
+	if k.Type != KeyTypeSMIME {
+		t.Fatalf("expected key type %s, got %s", KeyTypeSMIME, k.Type)
+	}
+	if err := k.Check(); err != nil {
+		t.Fatalf("error checking key: %v", err)
+	}
+
+	msg, err := k.Encrypt(testEntity)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+
+	// The content key is encrypted with RSA-OAEP, which is supported by
+	// the CMS and not the legacy PKCS #7 (smime) command.
+	files := map[string]string{"msg": "MIME-Version: 1.0\r\n" + string(msg), "cert.pem": certPEM, "key.pem": keyPEM}
+	out := openssl(t, files, "cms", "-decrypt", "-in", "msg", "-recip", "cert.pem", "-inkey", "key.pem", "-out", "out")
+	if !bytes.Equal(out, testEntity) {
+		t.Errorf("decrypted content doesn't match:\n%q\n%q", out, testEntity)
+	}
+
+	info := openssl(t, files, "cms", "-cmsout", "-print", "-in", "msg", "-out", "out")
+	if !bytes.Contains(info, []byte("rsaesOaep")) {
+		t.Errorf("content key isn't encrypted with RSA-OAEP:\n%s", info)
+	}
+}
+
+func TestEncryptPGP(t *testing.T) {
+	bin, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg not found")
+	}
+
+	home := t.TempDir()
+	gpg := func(stdin []byte, args ...string) []byte {
+		t.Helper()
+
+		cmd := exec.Command(bin, append([]string{"--homedir", home, "--batch", "--yes", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %s: %v: %s", strings.Join(args, " "), err, stderr.String())
+		}
+		return out
+	}
+
+	// An RSA primary key with an RSA encryption subkey.
+	gpg(nil, "--quick-gen-key", "listmonk test <test@listmonk.app>", "rsa2048", "cert,sign", "1d")
+	var fpr string
+	for _, l := range strings.Split(string(gpg(nil, "--with-colons", "--list-keys", "test@listmonk.app")), "\n") {
+		if strings.HasPrefix(l, "fpr:") {
+			fpr = strings.Trim(strings.TrimPrefix(l, "fpr:"), ":")
+			break
+		}
+	}
+	gpg(nil, "--quick-add-key", fpr, "rsa2048", "encr", "1d")
+	pub := gpg(nil, "--armor", "--export", "test@listmonk.app")
+
+	k, err := ParseKey(string(pub))
+	if err != nil {
+		t.Fatalf("error parsing key: %v", err)
+	}
+	if k.Type != KeyTypePGP {
+		t.Fatalf("expected key type %s, got %s", KeyTypePGP, k.Type)
+	}
+	if err := k.Check(); err != nil {
+		t.Fatalf("error checking key: %v", err)
+	}
+
+	msg, err := k.Encrypt(testEntity)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if !bytes.HasPrefix(msg, []byte("Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"")) {
+		t.Fatalf("unexpected PGP/MIME entity: %q", msg)
+	}
+
+	start := bytes.Index(msg, []byte("-----BEGIN PGP MESSAGE-----"))
+	end := bytes.Index(msg, []byte("-----END PGP MESSAGE-----"))
+	if start < 0 || end < 0 {
+		t.Fatalf("no PGP message in entity: %q", msg)
+	}
+
+	out := gpg(msg[start:end+len("-----END PGP MESSAGE-----")], "--decrypt")
+	if !bytes.Equal(out, testEntity) {
+		t.Errorf("decrypted content doesn't match:\n%q\n%q", out, testEntity)
+	}
+}
+
+func TestParseKeyRejects(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert, _ := newCert(t, ecKey)
+
+	for name, s := range map[string]string{
+		"empty":      "",
+		"garbage":    "not a key",
+		"bad pem":    "-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----",
+		"bad pgp":    "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nbm90IGEga2V5\n-----END PGP PUBLIC KEY BLOCK-----",
+		"ecdsa cert": ecCert,
+	} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package mailcrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAOAEP       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PKCS #7 / CMS structures (RFC 5652). Implicit and explicit [0] wrappers
+// and SETs of raw values are built by hand as encoding/asn1 ignores tag
+// parameters on asn1.RawValue fields.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	IssuerAndSerial    issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerial        issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// rsaOAEPParams are the RSAES-OAEP-params (RFC 4055) for OAEP with SHA-256
+// and MGF1 with SHA-256. The label (pSourceAlgorithm) is left as the default empty one.
+type rsaOAEPParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue
+}
+
+// Signer signs messages with an S/MIME certificate and its private key.
+type Signer struct {
+	cert  *x509.Certificate
+	chain [][]byte
+	key   crypto.Signer
+}
+
+// NewSigner returns a Signer for a PEM encoded certificate (optionally
+// followed by its intermediate certificates) and its PEM encoded private key.
+func NewSigner(certPEM, keyPEM string) (*Signer, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("error reading S/MIME certificate and key: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error reading S/MIME certificate: %v", err)
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported S/MIME private key")
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, errors.New("only RSA and ECDSA keys are supported for S/MIME signing")
+	}
+
+	return &Signer{cert: cert, chain: pair.Certificate, key: key}, nil
+}
+
+// Sign signs a MIME entity (headers and body) and returns the
+// multipart/signed entity that replaces it. The entity should have CRLF
+// line endings and be 7-bit safe as it's signed byte for byte.
+func (s *Signer) Sign(entity []byte) ([]byte, error) {
+	sig, err := s.sign(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	bound, err := boundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"%s\"\r\n\r\n", bound)
+	b.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.Write(entity)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", bound)
+	b.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	writeBase64(&b, sig)
+	fmt.Fprintf(&b, "--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// sign returns a detached DER encoded PKCS #7 SignedData signature of data.
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	// Signed attributes.
+	var attrs [][]byte
+	for _, a := range []struct {
+		oid asn1.ObjectIdentifier
+		val interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest[:]},
+		{oidSigningTime, time.Now().UTC()},
+	} {
+		v, err := asn1.Marshal(a.val)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := asn1.Marshal(attribute{
+			Type:   a.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: v},
+		})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, b)
+	}
+
+	// DER requires the elements of a SET OF to be sorted.
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	attrBody := bytes.Join(attrs, nil)
+
+	// The signature is over the attributes encoded as a SET and not the [0]
+	// that they're tagged with in SignerInfo.
+	toSign, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrBody})
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(toSign)
+
+	sig, err := s.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("error signing: %v", err)
+	}
+
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSASHA256}
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(s.chain, nil)},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			IssuerAndSerial:    issuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer}, Serial: s.cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrBody},
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	}
+	sd.ContentInfo.ContentType = oidData
+
+	return wrapContentInfo(oidSignedData, sd)
+}
+
+// envelope returns DER encoded PKCS #7 EnvelopedData of data encrypted
+// with AES-256-CBC, and the content key encrypted to the RSA certificate
+// with RSAES-OAEP (SHA-256). PKCS #1 v1.5 key transport isn't used as it's
+// open to padding oracle attacks.
+func envelope(data []byte, cert *x509.Certificate) ([]byte, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("only RSA certificates are supported for S/MIME encryption")
+	}
+
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	// PKCS #7 padding.
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	plain := make([]byte, len(data), len(data)+pad)
+	copy(plain, data)
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	enc := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, plain)
+
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting content key: %v", err)
+	}
+
+	sha256Alg, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA256})
+	if err != nil {
+		return nil, err
+	}
+	oaepDER, err := asn1.Marshal(rsaOAEPParams{
+		HashAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: sha256Alg}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := envelopedData{
+		Version: 0,
+		RecipientInfos: []recipientInfo{{
+			Version:                0,
+			IssuerAndSerial:        issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAOAEP, Parameters: asn1.RawValue{FullBytes: oaepDER}},
+			EncryptedKey:           encKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: enc},
+		},
+	}
+
+	return wrapContentInfo(oidEnvelopedData, ed)
+}
+
+// wrapContentInfo wraps a PKCS #7 content type in a ContentInfo.
+func wrapContentInfo(oid asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	b, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oid,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b},
+	})
+}
@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Defaults for fetching per-subscriber attachments from templated URLs.
+	defaultAttachMaxSize   = 10 * 1024 * 1024
+	defaultAttachTimeout   = time.Second * 10
+	defaultAttachCacheSize = 100 * 1024 * 1024
+	defaultAttachCacheTTL  = time.Hour
+)
+
+// attachCache is a size bound cache of attachments fetched from templated
+// URLs so that a file that's shared by multiple subscribers (eg: selected by
+// an attribute) isn't fetched for every message. When the cache is full, the
+// oldest entries are evicted.
+type attachCache struct {
+	items   map[string]attachCacheItem
+	keys    []string
+	size    int64
+	maxSize int64
+	ttl     time.Duration
+	sync.Mutex
+}
+
+type attachCacheItem struct {
+	a       models.Attachment
+	expires time.Time
+}
+
+func newAttachCache(maxSize int64, ttl time.Duration) *attachCache {
+	return &attachCache{
+		items:   make(map[string]attachCacheItem),
+		maxSize: maxSize,
+		ttl:     ttl,
+	}
+}
+
+func (c *attachCache) get(key string) (models.Attachment, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	it, ok := c.items[key]
+	if !ok || time.Now().After(it.expires) {
+		return models.Attachment{}, false
+	}
+
+	return it.a, true
+}
+
+func (c *attachCache) put(key string, a models.Attachment) {
+	size := int64(len(a.Content))
+	if size > c.maxSize {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if old, ok := c.items[key]; ok {
+		c.size -= int64(len(old.a.Content))
+	} else {
+		c.keys = append(c.keys, key)
+	}
+	c.items[key] = attachCacheItem{a: a, expires: time.Now().Add(c.ttl)}
+	c.size += size
+
+	// Evict the oldest entries.
+	for c.size > c.maxSize && len(c.keys) > 0 {
+		k := c.keys[0]
+		c.keys = c.keys[1:]
+		if it, ok := c.items[k]; ok {
+			c.size -= int64(len(it.a.Content))
+			delete(c.items, k)
+		}
+	}
+}
+
+// subAttachments renders the campaign's templated attachment URLs for a
+// message's subscriber and fetches the attachments. URLs that render to an
+// empty string are skipped, which allows attachments to be selected
+// conditionally per subscriber. If the campaign has an event, the subscriber's
+// calendar invite is attached.
+func (m *Manager) subAttachments(msg CampaignMessage) ([]models.Attachment, error) {
+	var (
+		out = make([]models.Attachment, 0, len(msg.Campaign.AttachmentURLTpls))
+		b   bytes.Buffer
+	)
+	for _, t := range msg.Campaign.AttachmentURLTpls {
+		b.Reset()
+		if err := t.ExecuteTemplate(&b, models.ContentTpl, &msg); err != nil {
+			return nil, fmt.Errorf("error rendering attachment URL: %v", err)
+		}
+
+		u := strings.TrimSpace(b.String())
+		if u == "" {
+			continue
+		}
+
+		a, err := m.fetchAttachment(u)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+
+	// Calendar invite for the campaign's event.
+	if msg.Campaign.Event != nil {
+		out = append(out, m.makeICS(msg))
+	}
+
+	return out, nil
+}
+
+// fetchAttachment fetches an attachment from a URL or returns it from the cache.
+func (m *Manager) fetchAttachment(u string) (models.Attachment, error) {
+	if a, ok := m.attachCache.get(u); ok {
+		return a, nil
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") {
+		return models.Attachment{}, fmt.Errorf("invalid attachment URL: %s", u)
+	}
+
+	resp, err := m.attachClient.Get(u)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("error fetching attachment %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Attachment{}, fmt.Errorf("error fetching attachment %s: status %d", u, resp.StatusCode)
+	}
+	if resp.ContentLength > m.cfg.AttachmentMaxSize {
+		return models.Attachment{}, fmt.Errorf("attachment %s exceeds the size limit of %d bytes", u, m.cfg.AttachmentMaxSize)
+	}
+
+	// Read one byte more than the limit to detect oversized bodies
+	// that don't have a Content-Length.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, m.cfg.AttachmentMaxSize+1))
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("error reading attachment %s: %v", u, err)
+	}
+	if int64(len(body)) > m.cfg.AttachmentMaxSize {
+		return models.Attachment{}, fmt.Errorf("attachment %s exceeds the size limit of %d bytes", u, m.cfg.AttachmentMaxSize)
+	}
+
+	// Use the filename in the Content-Disposition header, if there's one,
+	// or the last bit of the URL path.
+	name := path.Base(pu.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = path.Base(params["filename"])
+	}
+	if name == "" || name == "/" || name == "." {
+		name = "attachment"
+	}
+
+	ctype := resp.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ctype); err == nil {
+		ctype = mt
+	}
+
+	a := models.Attachment{
+		Name:    name,
+		Content: body,
+		Header:  MakeAttachmentHeader(name, "base64", ctype),
+	}
+	m.attachCache.put(u, a)
+
+	return a, nil
+}
@@ -0,0 +1,173 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Date layouts that date attributes are parsed with for {{ Attr }} formatting.
+var attrDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+var (
+	// Template actions, eg: {{ .Subscriber.FirstName }}.
+	reTplAction = regexp.MustCompile(`{{-?\s*(.+?)\s*-?}}`)
+
+	// Subscriber fields that can be empty.
+	reMergeField = regexp.MustCompile(`\.Subscriber\.(Attribs((\.[a-zA-Z0-9_]+)+)|FirstName|LastName|Name)\b`)
+
+	// {{ Attr "key" }} without a fallback.
+	reAttrNoFallback = regexp.MustCompile(`^Attr\s+"([^"]+)"\s*(\.)?$`)
+)
+
+// subAttr returns a subscriber's attribute (or one of the name fields) for
+// {{ Attr "key" "fallback" "format" }}. Nested attributes are accessed with
+// dots, eg: "address.city". The fallback is returned if the value is missing
+// or empty. Formats are "title", "upper", "lower", "date:<layout>",
+// and "number:<decimals>".
+func (m *Manager) subAttr(msg *CampaignMessage, key string, args ...string) (string, error) {
+	var fallback, format string
+	if len(args) > 0 {
+		fallback = args[0]
+	}
+	if len(args) > 1 {
+		format = args[1]
+	}
+
+	val := lookupAttr(msg, key)
+	if val == nil {
+		return fallback, nil
+	}
+
+	str := strings.TrimSpace(fmt.Sprintf("%v", val))
+	if str == "" {
+		return fallback, nil
+	}
+
+	switch {
+	case format == "":
+		return str, nil
+	case format == "title":
+		return titleCase(str), nil
+	case format == "upper":
+		return strings.ToUpper(str), nil
+	case format == "lower":
+		return strings.ToLower(str), nil
+	case strings.HasPrefix(format, "date:"):
+		for _, l := range attrDateLayouts {
+			if t, err := time.Parse(l, str); err == nil {
+				return m.subLang(msg).FormatDate(t, strings.TrimPrefix(format, "date:")), nil
+			}
+		}
+		return fallback, nil
+	case strings.HasPrefix(format, "number:"):
+		d, err := strconv.Atoi(strings.TrimPrefix(format, "number:"))
+		if err != nil {
+			return "", fmt.Errorf("invalid Attr format: %s", format)
+		}
+		f, err := toFloat(val)
+		if err != nil {
+			if f, err = toFloat(str); err != nil {
+				return fallback, nil
+			}
+		}
+		return m.subLang(msg).FormatNumber(f, d), nil
+	}
+
+	return "", fmt.Errorf("unknown Attr format: %s", format)
+}
+
+// lookupAttr returns the value of a subscriber attribute or name field by key.
+func lookupAttr(msg *CampaignMessage, key string) interface{} {
+	var cur interface{} = map[string]interface{}(msg.Subscriber.Attribs)
+	for _, k := range strings.Split(key, ".") {
+		mp, ok := cur.(map[string]interface{})
+		if !ok {
+			cur = nil
+			break
+		}
+		if cur, ok = mp[k]; !ok {
+			break
+		}
+	}
+	if cur != nil {
+		return cur
+	}
+
+	switch key {
+	case "name":
+		return msg.Subscriber.Name
+	case "first_name":
+		return msg.Subscriber.FirstName()
+	case "last_name":
+		return msg.Subscriber.LastName()
+	case "email":
+		return msg.Subscriber.Email
+	}
+
+	return nil
+}
+
+// titleCase upper cases the first letter of every word.
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		out := r
+		if unicode.IsSpace(prev) || prev == '-' {
+			out = unicode.ToUpper(r)
+		}
+		prev = r
+		return out
+	}, s)
+}
+
+// MergeFieldsWithoutFallback returns the subscriber fields and attributes that
+// are used in template text without a fallback value, eg: {{ .Subscriber.FirstName }}
+// or {{ Attr "city" }}, that'd render as empty for subscribers who don't have them.
+// Fields used in {{ if }} / {{ with }} blocks or with {{ or }} / {{ default }} are
+// considered to have fallbacks.
+func MergeFieldsWithoutFallback(texts ...string) []string {
+	seen := map[string]bool{}
+	for _, t := range texts {
+		for _, a := range reTplAction.FindAllStringSubmatch(t, -1) {
+			act := a[1]
+
+			if r := reAttrNoFallback.FindStringSubmatch(act); r != nil {
+				seen[r[1]] = true
+				continue
+			}
+
+			f := strings.Fields(act)
+			if len(f) == 0 {
+				continue
+			}
+			switch f[0] {
+			case "if", "else", "with", "range", "or", "default", "Attr":
+				continue
+			}
+			if strings.Contains(act, "| default") || strings.Contains(act, "|default") {
+				continue
+			}
+
+			for _, mf := range reMergeField.FindAllStringSubmatch(act, -1) {
+				if mf[2] != "" {
+					seen[strings.TrimPrefix(mf[2], ".")] = true
+				} else {
+					seen[mf[1]] = true
+				}
+			}
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+
+	return out
+}
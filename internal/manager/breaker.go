@@ -0,0 +1,360 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Circuit breaker states.
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+
+	// breakerMaxCooldown is the maximum time a breaker stays open before it
+	// probes the messenger, as the cooldown doubles on every failed probe.
+	breakerMaxCooldown = time.Minute * 30
+
+	// breakerCheckInterval is the interval at which campaigns paused by open
+	// breakers are checked for resumption.
+	breakerCheckInterval = time.Second * 5
+)
+
+// ErrCircuitOpen is returned when a message is pushed to a messenger whose
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("messenger circuit breaker is open")
+
+// BreakerStats is the state of a messenger's circuit breaker.
+type BreakerStats struct {
+	Messenger string     `json:"messenger"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"last_error"`
+	RetryAt   *time.Time `json:"retry_at"`
+
+	// IDs of the campaigns paused by the breaker that are resumed when it
+	// probes the messenger.
+	PausedCampaigns []int `json:"paused_campaigns"`
+}
+
+// breaker is a circuit breaker around a messenger's Push. It trips (opens)
+// after a number of consecutive failed pushes, after which pushes fail right
+// away. Once the cooldown is over, it lets a single push through as a probe
+// (half-open) that closes it on success, or re-opens it with double the
+// cooldown on failure.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	trips    int
+	probing  bool
+	retryAt  time.Time
+	lastErr  error
+
+	// changed is closed and replaced on every change of state to wake up the
+	// pushes waiting on a probe.
+	changed chan struct{}
+
+	// Campaigns paused by the breaker.
+	paused map[int]bool
+
+	mu sync.Mutex
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+		changed:   make(chan struct{}),
+		paused:    make(map[int]bool),
+	}
+}
+
+// allow checks whether a push can go through. When the breaker is half-open,
+// only the probe goes through and the other pushes get a channel to wait on
+// for its outcome.
+func (b *breaker) allow() (bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.retryAt) {
+			return false, nil
+		}
+		b.setState(BreakerHalfOpen)
+	case BreakerClosed:
+		return true, nil
+	}
+
+	if b.probing {
+		return false, b.changed
+	}
+	b.probing = true
+	return true, nil
+}
+
+// done records the outcome of a push and returns the state the breaker
+// switched to, or an empty string if it didn't change.
+func (b *breaker) done(err error) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerHalfOpen {
+			return ""
+		}
+
+		b.probing = false
+		b.trips = 0
+		b.setState(BreakerClosed)
+		return BreakerClosed
+	}
+
+	b.lastErr = err
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.failures < b.threshold {
+			return ""
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+	default:
+		// Pushes that were in flight when the breaker tripped.
+		return ""
+	}
+
+	// Trip the breaker.
+	d := b.cooldown << b.trips
+	if d > breakerMaxCooldown || d <= 0 {
+		d = breakerMaxCooldown
+	} else {
+		b.trips++
+	}
+	b.retryAt = time.Now().Add(d)
+	b.setState(BreakerOpen)
+
+	return BreakerOpen
+}
+
+func (b *breaker) setState(s string) {
+	b.state = s
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// reason returns the reason for campaigns being paused by the breaker.
+func (b *breaker) reason() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return fmt.Errorf("messenger '%s' is failing: %v. Sending resumes automatically when it recovers", b.name, b.lastErr)
+}
+
+// stats returns the state of the breaker.
+func (b *breaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := BreakerStats{
+		Messenger:       b.name,
+		State:           b.state,
+		Failures:        b.failures,
+		PausedCampaigns: make([]int, 0, len(b.paused)),
+	}
+	if b.lastErr != nil {
+		out.LastError = b.lastErr.Error()
+	}
+	if b.state == BreakerOpen {
+		t := b.retryAt
+		out.RetryAt = &t
+	}
+	for id := range b.paused {
+		out.PausedCampaigns = append(out.PausedCampaigns, id)
+	}
+	sort.Ints(out.PausedCampaigns)
+
+	return out
+}
+
+// push pushes a message to a messenger through its circuit breaker, if there's one.
+func (m *Manager) push(name string, msg models.Message) error {
+	b, ok := m.breakers[name]
+	if !ok {
+		return m.messengers[name].Push(msg)
+	}
+
+	for {
+		ok, wait := b.allow()
+		if ok {
+			break
+		}
+		if wait == nil {
+			return ErrCircuitOpen
+		}
+
+		// Wait for the outcome of the probe.
+		<-wait
+	}
+
+	err := m.messengers[name].Push(msg)
+	switch b.done(err) {
+	case BreakerOpen:
+		st := b.stats()
+		m.log.Printf("circuit breaker of messenger %s tripped: %v. retrying at %s", name, err, st.RetryAt.Format(time.RFC3339))
+		m.pauseBreakerCampaigns(b)
+	case BreakerClosed:
+		m.log.Printf("circuit breaker of messenger %s closed. messenger has recovered", name)
+	}
+
+	return err
+}
+
+// messengerName returns the name of the messenger that a campaign's messages
+// are pushed to, which is the simulation messenger if the campaign is simulated.
+func (m *Manager) messengerName(c *models.Campaign) string {
+	if c.Simulate && m.cfg.SimulationMessenger != "" {
+		return m.cfg.SimulationMessenger
+	}
+	return c.Messenger
+}
+
+// pauseBreakerCampaigns pauses the running campaigns on a messenger whose
+// circuit breaker has tripped.
+func (m *Manager) pauseBreakerCampaigns(b *breaker) {
+	reason := b.reason()
+
+	m.pipesMut.RLock()
+	defer m.pipesMut.RUnlock()
+
+	for _, p := range m.pipes {
+		if m.messengerName(p.camp) != b.name || !p.pause(reason, b.name) {
+			continue
+		}
+
+		b.mu.Lock()
+		b.paused[p.camp.ID] = true
+		b.mu.Unlock()
+	}
+}
+
+// watchBreakers is a blocking function that periodically resumes the campaigns
+// paused by circuit breakers once they're due for a probe, so that the
+// campaigns' messages probe the messengers.
+func (m *Manager) watchBreakers() {
+	t := time.NewTicker(breakerCheckInterval)
+	defer t.Stop()
+
+	loaded := false
+	for range t.C {
+		if m.halted.Load() {
+			continue
+		}
+
+		// Only the instance that processes campaigns resumes the campaigns
+		// that were paused before a restart.
+		if !loaded && m.cfg.ScanCampaigns && (m.cfg.IsLeader == nil || m.cfg.IsLeader()) {
+			m.loadBreakerCampaigns()
+			loaded = true
+		}
+
+		for _, b := range m.breakers {
+			for _, id := range m.dueBreakerCampaigns(b) {
+				ok, err := m.store.ResumeCampaign(id)
+				if err != nil {
+					m.log.Printf("error resuming campaign %d paused by circuit breaker: %v", id, err)
+					continue
+				}
+
+				// Campaigns that have been paused or resumed by hand in the
+				// meantime aren't resumed.
+				b.mu.Lock()
+				delete(b.paused, id)
+				b.mu.Unlock()
+
+				if ok {
+					m.log.Printf("resumed campaign %d paused by circuit breaker of messenger %s", id, b.name)
+				}
+			}
+		}
+	}
+}
+
+// loadBreakerCampaigns loads the campaigns that were paused by circuit breakers
+// before a restart so that they're resumed. As the breakers start out closed,
+// they're resumed right away and probe the messengers. Campaigns whose
+// messengers no longer have breakers are resumed as well.
+func (m *Manager) loadBreakerCampaigns() {
+	camps, err := m.store.GetBreakerPausedCampaigns()
+	if err != nil {
+		m.log.Printf("error fetching campaigns paused by circuit breakers: %v", err)
+		return
+	}
+
+	for id, name := range camps {
+		if b, ok := m.breakers[name]; ok {
+			b.mu.Lock()
+			b.paused[id] = true
+			b.mu.Unlock()
+			continue
+		}
+
+		if ok, err := m.store.ResumeCampaign(id); err != nil {
+			m.log.Printf("error resuming campaign %d paused by circuit breaker: %v", id, err)
+		} else if ok {
+			m.log.Printf("resumed campaign %d paused by circuit breaker of messenger %s", id, name)
+		}
+	}
+}
+
+// dueBreakerCampaigns returns the campaigns paused by a breaker that can be
+// resumed: the breaker is due for a probe or has closed, and the campaigns
+// have finished pausing.
+func (m *Manager) dueBreakerCampaigns(b *breaker) []int {
+	b.mu.Lock()
+	due := len(b.paused) > 0 && (b.state != BreakerOpen || !time.Now().Before(b.retryAt))
+	ids := make([]int, 0, len(b.paused))
+	if due {
+		for id := range b.paused {
+			ids = append(ids, id)
+		}
+	}
+	b.mu.Unlock()
+
+	m.pipesMut.RLock()
+	defer m.pipesMut.RUnlock()
+
+	out := ids[:0]
+	for _, id := range ids {
+		if _, ok := m.pipes[id]; !ok {
+			out = append(out, id)
+		}
+	}
+
+	return out
+}
+
+// BreakerStats returns the states of the messengers' circuit breakers.
+func (m *Manager) BreakerStats() []BreakerStats {
+	out := make([]BreakerStats, 0, len(m.breakers))
+	for _, b := range m.breakers {
+		out = append(out, b.stats())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Messenger < out[j].Messenger
+	})
+
+	return out
+}
@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// concAdjustInterval is the interval at which the stats of the workers are
+	// sampled and the adaptive concurrency is adjusted.
+	concAdjustInterval = time.Second * 5
+
+	// concSaturation is the fraction of time the workers spend pushing messages
+	// above which they're considered busy.
+	concSaturation = 0.8
+)
+
+// ConcurrencyStats are the live stats of the campaign message workers. The
+// latency, error rate, and saturation are of the last sampling interval.
+type ConcurrencyStats struct {
+	Adaptive   bool    `json:"adaptive"`
+	Current    int     `json:"current"`
+	Min        int     `json:"min"`
+	Max        int     `json:"max"`
+	QueueDepth int     `json:"queue_depth"`
+	QueueSize  int     `json:"queue_size"`
+	Latency    string  `json:"avg_latency"`
+	ErrorRate  float64 `json:"error_rate"`
+	Saturation float64 `json:"saturation"`
+}
+
+// concurrency tracks the number of active campaign message workers, and the
+// latency and the errors of the messages they push, based on which the number
+// is adjusted when adaptive concurrency is enabled. Workers beyond the limit
+// are parked until it's raised.
+type concurrency struct {
+	limit atomic.Int64
+
+	// Counters of the current sampling interval.
+	sent    atomic.Int64
+	errors  atomic.Int64
+	latency atomic.Int64
+
+	// wake is closed and replaced when the limit changes to wake up the
+	// parked workers.
+	wake chan struct{}
+	mu   sync.Mutex
+
+	stats    ConcurrencyStats
+	statsMut sync.RWMutex
+}
+
+func newConcurrency(limit int) *concurrency {
+	c := &concurrency{wake: make(chan struct{})}
+	c.limit.Store(int64(limit))
+	return c
+}
+
+// park returns a channel that's closed when the limit changes if the worker
+// with the given ID is beyond the limit, and nil if it's active.
+func (c *concurrency) park(id int) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(id) < c.limit.Load() {
+		return nil
+	}
+	return c.wake
+}
+
+// setLimit sets the number of active workers and wakes up the parked ones.
+func (c *concurrency) setLimit(n int) {
+	c.mu.Lock()
+	c.limit.Store(int64(n))
+	close(c.wake)
+	c.wake = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// record records the latency and the outcome of a message push.
+func (c *concurrency) record(d time.Duration, err error) {
+	c.sent.Add(1)
+	c.latency.Add(int64(d))
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// watchConcurrency is a blocking function that periodically samples the stats
+// of the workers and adjusts their number when adaptive concurrency is enabled.
+func (m *Manager) watchConcurrency() {
+	t := time.NewTicker(concAdjustInterval)
+	defer t.Stop()
+
+	for range t.C {
+		m.adjustConcurrency(concAdjustInterval)
+	}
+}
+
+// adjustConcurrency samples the stats of the workers over the last interval
+// and adjusts their number (AIMD). The number is cut by a quarter when the
+// messengers' average latency or error rate exceed the thresholds, and is
+// raised when messages are piling up in the queue and the workers are busy
+// or held back by the per-worker message rate.
+func (m *Manager) adjustConcurrency(interval time.Duration) {
+	var (
+		c       = m.conc
+		sent    = c.sent.Swap(0)
+		errs    = c.errors.Swap(0)
+		latency = time.Duration(c.latency.Swap(0))
+		limit   = int(c.limit.Load())
+		queued  = len(m.campMsgQ)
+	)
+
+	st := ConcurrencyStats{
+		Adaptive:   m.cfg.AdaptiveConcurrency,
+		Current:    limit,
+		Min:        m.cfg.MinConcurrency,
+		Max:        m.cfg.Concurrency,
+		QueueDepth: queued,
+		QueueSize:  cap(m.campMsgQ),
+		Latency:    "0s",
+	}
+	if sent > 0 {
+		avg := latency / time.Duration(sent)
+		st.Latency = avg.String()
+		st.ErrorRate = float64(errs) / float64(sent)
+
+		// The fraction of the active workers' time spent pushing messages.
+		st.Saturation = float64(latency) / float64(interval*time.Duration(limit))
+		if st.Saturation > 1 {
+			st.Saturation = 1
+		}
+
+		// There should be at least as many messages as workers for the rates
+		// to be meaningful.
+		if m.cfg.AdaptiveConcurrency && sent >= int64(limit) {
+			// The workers are busy if they spend most of their time pushing
+			// messages or are held back by the per-worker message rate.
+			var (
+				capacity = float64(limit*m.cfg.MessageRate) * interval.Seconds()
+				busy     = st.Saturation >= concSaturation || float64(sent) >= capacity*concSaturation
+			)
+
+			n := limit
+			switch {
+			case (m.cfg.AdaptiveLatency > 0 && avg > m.cfg.AdaptiveLatency) || st.ErrorRate > m.cfg.AdaptiveErrorRate:
+				n = limit * 3 / 4
+				if n == limit {
+					n--
+				}
+			case queued >= limit && busy:
+				n = limit + limit/4
+				if n == limit {
+					n++
+				}
+			}
+
+			if n < m.cfg.MinConcurrency {
+				n = m.cfg.MinConcurrency
+			} else if n > m.cfg.Concurrency {
+				n = m.cfg.Concurrency
+			}
+			if n != limit {
+				m.log.Printf("adjusting concurrency from %d to %d (latency %s, errors %.1f%%, queued %d)",
+					limit, n, st.Latency, st.ErrorRate*100, queued)
+				c.setLimit(n)
+				st.Current = n
+			}
+		}
+	}
+
+	c.statsMut.Lock()
+	c.stats = st
+	c.statsMut.Unlock()
+}
+
+// ConcurrencyStats returns the live stats of the campaign message workers.
+func (m *Manager) ConcurrencyStats() ConcurrencyStats {
+	m.conc.statsMut.RLock()
+	st := m.conc.stats
+	m.conc.statsMut.RUnlock()
+
+	st.Adaptive = m.cfg.AdaptiveConcurrency
+	st.Current = int(m.conc.limit.Load())
+	st.Min = m.cfg.MinConcurrency
+	st.Max = m.cfg.Concurrency
+	st.QueueDepth = len(m.campMsgQ)
+	st.QueueSize = cap(m.campMsgQ)
+	if st.Latency == "" {
+		st.Latency = "0s"
+	}
+
+	return st
+}
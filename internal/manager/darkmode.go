@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"regexp"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// darkModeMeta is the set of standard hints that tell e-mail clients that a
+// message supports both light and dark colour schemes.
+const darkModeMeta = `<meta name="color-scheme" content="light dark" />
+<meta name="supported-color-schemes" content="light dark" />
+<style type="text/css">:root { color-scheme: light dark; supported-color-schemes: light dark; }</style>
+`
+
+// darkModeSimCSS simulates clients that invert the colours of messages that
+// don't have dark mode styles (eg: Outlook, Gmail apps). Images are inverted
+// back so that they look as they are.
+const darkModeSimCSS = `<style type="text/css">
+:root { color-scheme: dark; }
+html { background: #fff; filter: invert(1) hue-rotate(180deg); }
+img, video, picture, svg, [style*="background-image"] { filter: invert(1) hue-rotate(180deg); }
+</style>
+`
+
+var (
+	reHeadClose   = regexp.MustCompile(`(?i)</head\s*>`)
+	reColorScheme = regexp.MustCompile(`(?i)<meta[^>]+name\s*=\s*["']?color-scheme`)
+	reDarkMedia   = regexp.MustCompile(`(?i)@media\s*\(\s*prefers-color-scheme\s*:\s*dark\s*\)`)
+)
+
+// InjectDarkModeMeta inserts the dark mode meta tags and CSS hints into the
+// <head> of an HTML message body. Bodies without a <head> or that already
+// declare a color-scheme are returned as-is.
+func InjectDarkModeMeta(body []byte) []byte {
+	if reColorScheme.Match(body) {
+		return body
+	}
+
+	loc := reHeadClose.FindIndex(body)
+	if loc == nil {
+		return body
+	}
+
+	return insertAt(body, loc[0], darkModeMeta)
+}
+
+// SimulateDarkMode transforms a rendered HTML message body to look the way
+// dark mode e-mail clients would show it. Messages that have
+// "prefers-color-scheme: dark" styles get them applied unconditionally, as
+// dark mode aware clients (eg: Apple Mail) would do. Others get their colours
+// inverted.
+func SimulateDarkMode(body []byte) []byte {
+	if reDarkMedia.Match(body) {
+		return reDarkMedia.ReplaceAll(body, []byte("@media all"))
+	}
+
+	pos := 0
+	if loc := reHeadClose.FindIndex(body); loc != nil {
+		pos = loc[0]
+	}
+
+	return insertAt(body, pos, darkModeSimCSS)
+}
+
+// applyDarkModeMeta injects the dark mode hints into a rendered campaign
+// message if it's enabled. Pre-rendered static bodies already have them.
+func (m *Manager) applyDarkModeMeta(msg *CampaignMessage) {
+	if !m.cfg.DarkModeMeta || (msg.Campaign.StaticBody != nil && msg.variant == nil) || msg.Campaign.ContentType == models.CampaignContentTypePlain {
+		return
+	}
+
+	msg.body = InjectDarkModeMeta(msg.body)
+}
+
+func insertAt(b []byte, pos int, s string) []byte {
+	out := make([]byte, 0, len(b)+len(s))
+	out = append(out, b[:pos]...)
+	out = append(out, s...)
+	return append(out, b[pos:]...)
+}
@@ -0,0 +1,22 @@
+package manager
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// feedbackID returns the Feedback-ID header of a campaign's messages in the
+// format recommended by Gmail, eg: c42:l1-3:regular:listmonk, with the campaign
+// and list identifiers, the campaign type, and the sender identifier. The
+// identifiers are prefixed so that the ones reported by the feedback loops can
+// be mapped back to campaigns.
+func (m *Manager) feedbackID(c *models.Campaign) string {
+	lists := make([]string, 0, len(c.ListIDs))
+	for _, id := range c.ListIDs {
+		lists = append(lists, strconv.FormatInt(id, 10))
+	}
+
+	return "c" + strconv.Itoa(c.ID) + ":l" + strings.Join(lists, "-") + ":" + c.Type + ":" + m.cfg.FeedbackIDSender
+}
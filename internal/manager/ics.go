@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// makeICS returns a calendar invite (ICS) with METHOD:REQUEST for a campaign's
+// event addressed to the message's subscriber.
+func (m *Manager) makeICS(msg CampaignMessage) models.Attachment {
+	var (
+		c = msg.Campaign
+		e = c.Event
+		b strings.Builder
+	)
+
+	line := func(s string) {
+		b.WriteString(foldICSLine(s))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//listmonk//listmonk//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:REQUEST")
+	line("BEGIN:VEVENT")
+	line("UID:" + c.UUID + "@listmonk")
+	line("DTSTAMP:" + time.Now().UTC().Format(icsTimeFormat))
+	line("DTSTART:" + e.StartAt.UTC().Format(icsTimeFormat))
+	line("DTEND:" + e.EndAt.UTC().Format(icsTimeFormat))
+	line("SUMMARY:" + icsEscaper.Replace(e.Title))
+	if e.Description != "" {
+		line("DESCRIPTION:" + icsEscaper.Replace(e.Description))
+	}
+	if e.Location != "" {
+		line("LOCATION:" + icsEscaper.Replace(e.Location))
+	}
+	if e.URL != "" {
+		line("URL:" + e.URL)
+	}
+	if from, err := mail.ParseAddress(c.FromEmail); err == nil {
+		line(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", icsParam(from.Name), from.Address))
+	}
+	line(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:%s",
+		icsParam(msg.Subscriber.Name), msg.Subscriber.Email))
+	line("SEQUENCE:0")
+	line("STATUS:CONFIRMED")
+	line("END:VEVENT")
+	line("END:VCALENDAR")
+
+	const name = "invite.ics"
+	h := MakeAttachmentHeader(name, "base64", "")
+	h.Set("Content-Type", `text/calendar; charset="utf-8"; method=REQUEST; name="`+name+`"`)
+
+	return models.Attachment{
+		Name:    name,
+		Content: []byte(b.String()),
+		Header:  h,
+	}
+}
+
+// icsParam quotes an ICS property parameter value.
+func icsParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// foldICSLine folds a content line longer than 75 octets into multiple lines
+// as per RFC 5545, without splitting multi-byte characters.
+func foldICSLine(s string) string {
+	if len(s) <= 75 {
+		return s
+	}
+
+	var (
+		b strings.Builder
+		n = 0
+	)
+	for _, r := range s {
+		l := len(string(r))
+		if n+l > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += l
+	}
+
+	return b.String()
+}
@@ -1,20 +1,29 @@
 package manager
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net/http"
 	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/sanitize"
 	"github.com/knadh/listmonk/models"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -25,8 +34,14 @@ const (
 	ContentTpl = "content"
 
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// Default validity of the signed {{ FileURL }} links.
+	defaultFileURLExpiry = time.Hour * 24 * 30
 )
 
+// ErrHalted is returned when a message is pushed while all sending is halted.
+var ErrHalted = errors.New("sending is halted")
+
 // Store represents a data backend, such as a database,
 // that provides subscriber and campaign records.
 type Store interface {
@@ -35,10 +50,29 @@ type Store interface {
 	GetCampaign(campID int) (*models.Campaign, error)
 	GetAttachment(mediaID int) (models.Attachment, error)
 	UpdateCampaignStatus(campID int, status string) error
+
+	// PauseCampaign pauses a campaign with an error that's recorded as the
+	// reason and the messenger whose circuit breaker paused it, if any.
+	// ResumeCampaign resumes a campaign if it's still paused by a breaker, and
+	// GetBreakerPausedCampaigns returns the campaigns paused by breakers
+	// mapped to the messengers.
+	PauseCampaign(campID int, reason error, breaker string) error
+	ResumeCampaign(campID int) (bool, error)
+	GetBreakerPausedCampaigns() (map[int]string, error)
 	UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error
+	UpdateCampaignVariantCounts(campID int, sent map[string]int) error
+	UpdateCampaignDomainCounts(campID int, sent map[string]int) error
 	CreateLink(url string) (string, error)
+	CreateLinkSlug(campID int, linkUUID, slug string) (string, error)
 	BlocklistSubscriber(id int64) error
 	DeleteSubscriber(id int64) error
+	IsSendingHalted() (bool, error)
+
+	// Outbox review of small campaigns.
+	StartOutbox(campID, maxRecipients int) (bool, error)
+	SaveOutboxMessage(msg models.OutboxMessage) error
+	GetOutboxMessages(campID int, subIDs []int) (map[int]models.OutboxMessage, error)
+	FinishOutbox(campID int) error
 }
 
 // Messenger is an interface for a generic messaging backend,
@@ -62,6 +96,7 @@ type Manager struct {
 	store      Store
 	i18n       *i18n.I18n
 	messengers map[string]Messenger
+	breakers   map[string]*breaker
 	notifCB    models.AdminNotifCallback
 	log        *log.Logger
 
@@ -72,12 +107,22 @@ type Manager struct {
 	tpls    map[int]*models.Template
 	tplsMut sync.RWMutex
 
+	// Compiled campaign templates cached by campaign revision.
+	campTpls *campTplCache
+
+	// Per-subscriber attachments fetched from templated URLs.
+	attachCache  *attachCache
+	attachClient *http.Client
+
 	// Links generated using Track() are cached here so as to not query
 	// the database for the link UUID for every message sent. This has to
 	// be locked as it may be used externally when previewing campaigns.
 	links    map[string]string
 	linksMut sync.RWMutex
 
+	// Short link slugs cached by campaign ID and link UUID.
+	slugs map[string]string
+
 	nextPipes chan *pipe
 	campMsgQ  chan CampaignMessage
 	msgQ      chan models.Message
@@ -88,7 +133,21 @@ type Manager struct {
 	slidingCount int
 	slidingStart time.Time
 
+	// halted is the emergency stop. When it's set, campaigns aren't processed
+	// and messages aren't sent.
+	halted atomic.Bool
+
+	// draining is set when the manager is being shut down. Campaigns and
+	// batches aren't picked up anymore.
+	draining atomic.Bool
+
+	// conc is the number of active campaign message workers.
+	conc *concurrency
+
 	tplFuncs template.FuncMap
+
+	// sanitizer sanitizes the subscriber data that's exposed to templates.
+	sanitizer *sanitize.Sanitizer
 }
 
 // CampaignMessage represents an instance of campaign message to be pushed out,
@@ -97,13 +156,27 @@ type CampaignMessage struct {
 	Campaign   *models.Campaign
 	Subscriber models.Subscriber
 
-	from     string
-	to       string
-	subject  string
-	body     []byte
+	// Sender is the sender profile of the campaign's lists merged over the
+	// instance's, for footers.
+	Sender models.SenderProfile
+
+	from      string
+	to        string
+	subject   string
+	preheader string
+	body      []byte
+
+	// variant is the language variant of the campaign picked for the
+	// subscriber. nil for the campaign's default content.
+	variant *models.CampaignVariant
+
 	altBody  []byte
 	unsubURL string
 
+	// noTrack disables the view and link tracking in the rendered message,
+	// eg: for the hosted web view of a message.
+	noTrack bool
+
 	pipe *pipe
 }
 
@@ -121,14 +194,110 @@ type Config struct {
 	FromEmail             string
 	IndividualTracking    bool
 	LinkTrackURL          string
+	ShortLinkURL          string
 	UnsubURL              string
 	OptinURL              string
 	MessageURL            string
+	RSVPURL               string
+	ReferralURL           string
 	ViewTrackURL          string
 	ArchiveURL            string
+	FileURL               string
 	RootURL               string
 	UnsubHeader           bool
 
+	// FeedbackID attaches a Feedback-ID header with the campaign and list
+	// identifiers and FeedbackIDSender to campaign messages.
+	FeedbackID       bool
+	FeedbackIDSender string
+
+	// ReplyTracking sets Message-IDs with the campaign and subscriber UUIDs
+	// on campaign messages so that replies to them can be tracked.
+	ReplyTracking bool
+
+	// Sanitize is the policy with which subscribers' names and attributes
+	// are sanitized before they're rendered in templates.
+	Sanitize sanitize.Policy
+
+	// TemplateFuncs are the Sprig functions that are available to campaigns
+	// and to campaign and transactional templates. All of them are available
+	// if it's empty. listmonk's own functions are always available.
+	TemplateFuncs []string
+
+	// RenderTimeout and RenderMaxSize (bytes) limit the time taken to render
+	// a campaign message and the size of its rendered templates. 0 disables
+	// a limit.
+	RenderTimeout time.Duration
+	RenderMaxSize int
+
+	// Sender is the instance's sender profile that's available to campaign
+	// templates, over which the profiles of campaigns' lists are merged.
+	Sender models.SenderProfile
+
+	// SimulationMessenger is the messenger that the messages of simulated
+	// campaigns are pushed to instead of their own, eg: one that discards
+	// them, to run campaigns without delivering anything.
+	SimulationMessenger string
+
+	// BreakerThreshold is the number of consecutive failed pushes to a
+	// messenger after which its circuit breaker trips and its campaigns are
+	// paused. BreakerCooldown is the time after which the messenger is probed
+	// for recovery. 0 disables the breakers.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// AdaptiveConcurrency scales the number of active campaign message workers
+	// between MinConcurrency and Concurrency based on the depth of the message
+	// queue, and the messengers' average latency and error rate (0-1) against
+	// AdaptiveLatency and AdaptiveErrorRate.
+	AdaptiveConcurrency bool
+	MinConcurrency      int
+	AdaptiveLatency     time.Duration
+	AdaptiveErrorRate   float64
+
+	// FileURLExpiry is the time for which the signed {{ FileURL }} links in
+	// messages are valid after the messages are rendered.
+	FileURLExpiry time.Duration
+
+	// ShortLinks generates short {{ TrackLink }} URLs with slugs instead of
+	// the link, campaign, and subscriber UUIDs.
+	ShortLinks bool
+
+	// DarkModeMeta injects the standard dark mode meta tags and CSS hints into
+	// the <head> of HTML campaign messages.
+	DarkModeMeta bool
+
+	// SigningKey is the secret with which the {{ MessageURL }} links are signed.
+	SigningKey []byte
+
+	// OutboxReviewThreshold is the number of recipients up to which campaigns
+	// are rendered into the outbox for review and are only sent after they're
+	// approved. 0 disables the review.
+	OutboxReviewThreshold int
+
+	// PublicLinkExpiry is the time for which the signed unsubscribe and
+	// opt-in links in messages are valid. 0 means the links don't expire.
+	PublicLinkExpiry time.Duration
+
+	// Limits for per-subscriber attachments fetched from the templated
+	// attachment URLs of campaigns.
+	AttachmentMaxSize   int64
+	AttachmentTimeout   time.Duration
+	AttachmentCacheSize int64
+	AttachmentCacheTTL  time.Duration
+
+	// SlidingWindowCB is called when the sliding window message limit is reached
+	// with the number of messages sent and the time sending is paused for.
+	SlidingWindowCB func(sent int, wait time.Duration)
+
+	// MessageSentCB, if set, is called after every campaign message that's
+	// pushed to its messenger successfully.
+	MessageSentCB func(c *models.Campaign, sub models.Subscriber)
+
+	// LoadLang returns the language map for a subscriber's language code. If it's
+	// not set, the default language is used for all subscribers.
+	LoadLang func(code string) *i18n.I18n
+
 	// Interval to scan the DB for active campaign checkpoints.
 	ScanInterval time.Duration
 
@@ -138,6 +307,12 @@ type Config struct {
 	// (exposed to the internet, private etc.) where only one does campaign
 	// processing while the others handle other kinds of traffic.
 	ScanCampaigns bool
+
+	// IsLeader, if set, is checked before every scan and only the instance
+	// that's the leader among the ones sharing the DB processes campaigns.
+	// When an instance loses leadership, it stops its running campaigns, which
+	// are then picked up by the new leader.
+	IsLeader func() bool
 }
 
 type msgError struct {
@@ -147,6 +322,10 @@ type msgError struct {
 
 var pushTimeout = time.Second * 3
 
+// drainStopTimeout is the time to wait for stopped campaigns to be saved
+// after the drain timeout elapses.
+var drainStopTimeout = time.Second * 5
+
 // New returns a new instance of Mailer.
 func New(cfg Config, store Store, notifCB models.AdminNotifCallback, i *i18n.I18n, l *log.Logger) *Manager {
 	if cfg.BatchSize < 1 {
@@ -158,6 +337,27 @@ func New(cfg Config, store Store, notifCB models.AdminNotifCallback, i *i18n.I18
 	if cfg.MessageRate < 1 {
 		cfg.MessageRate = 1
 	}
+	if !cfg.AdaptiveConcurrency || cfg.MinConcurrency > cfg.Concurrency {
+		cfg.MinConcurrency = cfg.Concurrency
+	} else if cfg.MinConcurrency < 1 {
+		cfg.MinConcurrency = 1
+	}
+
+	if cfg.AttachmentMaxSize < 1 {
+		cfg.AttachmentMaxSize = defaultAttachMaxSize
+	}
+	if cfg.AttachmentTimeout < 1 {
+		cfg.AttachmentTimeout = defaultAttachTimeout
+	}
+	if cfg.AttachmentCacheSize < 1 {
+		cfg.AttachmentCacheSize = defaultAttachCacheSize
+	}
+	if cfg.AttachmentCacheTTL < 1 {
+		cfg.AttachmentCacheTTL = defaultAttachCacheTTL
+	}
+	if cfg.FileURLExpiry < 1 {
+		cfg.FileURLExpiry = defaultFileURLExpiry
+	}
 
 	m := &Manager{
 		cfg:          cfg,
@@ -166,13 +366,23 @@ func New(cfg Config, store Store, notifCB models.AdminNotifCallback, i *i18n.I18
 		notifCB:      notifCB,
 		log:          l,
 		messengers:   make(map[string]Messenger),
+		breakers:     make(map[string]*breaker),
 		pipes:        make(map[int]*pipe),
 		tpls:         make(map[int]*models.Template),
+		campTpls:     &campTplCache{tpls: make(map[string]campTpl)},
+		attachCache:  newAttachCache(cfg.AttachmentCacheSize, cfg.AttachmentCacheTTL),
+		attachClient: &http.Client{Timeout: cfg.AttachmentTimeout},
 		links:        make(map[string]string),
+		slugs:        make(map[string]string),
 		nextPipes:    make(chan *pipe, 1000),
 		campMsgQ:     make(chan CampaignMessage, cfg.Concurrency*cfg.MessageRate*2),
 		msgQ:         make(chan models.Message, cfg.Concurrency*cfg.MessageRate*2),
 		slidingStart: time.Now(),
+		sanitizer:    sanitize.New(cfg.Sanitize),
+
+		// Adaptive concurrency starts with the minimum number of workers
+		// and scales up.
+		conc: newConcurrency(cfg.MinConcurrency),
 	}
 	m.tplFuncs = m.makeGnericFuncMap()
 
@@ -186,12 +396,21 @@ func (m *Manager) AddMessenger(msg Messenger) error {
 		return fmt.Errorf("messenger '%s' is already loaded", id)
 	}
 	m.messengers[id] = msg
+
+	if m.cfg.BreakerThreshold > 0 && m.cfg.BreakerCooldown > 0 {
+		m.breakers[id] = newBreaker(id, m.cfg.BreakerThreshold, m.cfg.BreakerCooldown)
+	}
+
 	return nil
 }
 
 // PushMessage pushes an arbitrary non-campaign Message to be sent out by the workers.
 // It times out if the queue is busy.
 func (m *Manager) PushMessage(msg models.Message) error {
+	if m.halted.Load() {
+		return ErrHalted
+	}
+
 	t := time.NewTicker(pushTimeout)
 	defer t.Stop()
 
@@ -207,6 +426,10 @@ func (m *Manager) PushMessage(msg models.Message) error {
 // PushCampaignMessage pushes a campaign messages into a queue to be sent out by the workers.
 // It times out if the queue is busy.
 func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
+	if m.halted.Load() {
+		return ErrHalted
+	}
+
 	t := time.NewTicker(pushTimeout)
 	defer t.Stop()
 
@@ -257,16 +480,24 @@ func (m *Manager) GetCampaignStats(id int) CampStats {
 // until all subscribers are exhausted, at which point, a campaign is marked
 // as "finished".
 func (m *Manager) Run() {
+	// Pick up the halt state and keep it in sync with the data source so that
+	// halting sending on one instance halts it on all the instances that share it.
+	m.syncHalt()
+	go m.watchHalt(m.cfg.ScanInterval)
+
 	if m.cfg.ScanCampaigns {
 		// Periodically scan campaigns and push running campaigns to nextPipes
 		// to fetch subscribers from the campaign.
 		go m.scanCampaigns(m.cfg.ScanInterval)
 	}
 
-	// Spawn N message workers.
+	// Spawn N message workers. With adaptive concurrency, the ones beyond the
+	// current limit are parked.
 	for i := 0; i < m.cfg.Concurrency; i++ {
-		go m.worker()
+		go m.worker(i)
 	}
+	go m.watchConcurrency()
+	go m.watchBreakers()
 
 	// Indefinitely wait on the pipe queue to fetch the next set of subscribers
 	// for any active campaigns.
@@ -322,15 +553,33 @@ func (m *Manager) GetTpl(id int) (*models.Template, error) {
 // compiled campaign templates.
 func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 	f := template.FuncMap{
-		"TrackLink": func(url string, msg *CampaignMessage) string {
-			subUUID := msg.Subscriber.UUID
-			if !m.cfg.IndividualTracking {
-				subUUID = dummyUUID
+		"TrackLink": func(url string, args ...interface{}) (string, error) {
+			// The last argument is the message, appended on compilation. It
+			// may be preceded by a custom short link slug.
+			if len(args) == 0 {
+				return "", errors.New("TrackLink: missing message argument")
+			}
+			msg, ok := args[len(args)-1].(*CampaignMessage)
+			if !ok {
+				return "", errors.New("TrackLink: invalid message argument")
+			}
+
+			if msg.noTrack {
+				return url, nil
+			}
+
+			slug := ""
+			if len(args) > 1 {
+				slug = fmt.Sprintf("%v", args[0])
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			return m.trackLink(url, slug, msg), nil
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
+			if msg.noTrack {
+				return ""
+			}
+
 			subUUID := msg.Subscriber.UUID
 			if !m.cfg.IndividualTracking {
 				subUUID = dummyUUID
@@ -343,22 +592,74 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 			return msg.unsubURL
 		},
 		"ManageURL": func(msg *CampaignMessage) string {
-			return msg.unsubURL + "?manage=true"
+			return msg.unsubURL + "&manage=true"
 		},
 		"OptinURL": func(msg *CampaignMessage) string {
 			// Add list IDs.
 			// TODO: Show private lists list on optin e-mail
-			return fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UUID, "")
+			return fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UUID, "t="+m.LinkToken(LinkOptin, msg.Subscriber.UUID))
 		},
 		"MessageURL": func(msg *CampaignMessage) string {
-			return fmt.Sprintf(m.cfg.MessageURL, c.UUID, msg.Subscriber.UUID)
+			return fmt.Sprintf(m.cfg.MessageURL, c.UUID, msg.Subscriber.UUID, m.MessageSig(c.UUID, msg.Subscriber.UUID))
+		},
+		"RSVPURL": func(status string, msg *CampaignMessage) string {
+			return fmt.Sprintf(m.cfg.RSVPURL, c.UUID, msg.Subscriber.UUID, status, m.MessageSig(c.UUID, msg.Subscriber.UUID))
+		},
+		"ReferralCode": func(msg *CampaignMessage) string {
+			return msg.Subscriber.ReferralCode
+		},
+		"ReferralURL": func(msg *CampaignMessage) string {
+			return fmt.Sprintf(m.cfg.ReferralURL, msg.Subscriber.ReferralCode)
 		},
 		"ArchiveURL": func() string {
 			return m.cfg.ArchiveURL
 		},
+		"FileURL": func(name string) string {
+			exp := time.Now().Add(m.cfg.FileURLExpiry).Unix()
+			return fmt.Sprintf(m.cfg.FileURL, url.PathEscape(name), exp, m.FileSig(name, exp))
+		},
 		"RootURL": func() string {
 			return m.cfg.RootURL
 		},
+		"Lang": func(msg *CampaignMessage) string {
+			return m.subLang(msg).Code()
+		},
+		"IsRTL": func(msg *CampaignMessage) bool {
+			return m.subLang(msg).IsRTL()
+		},
+		"TextDir": func(msg *CampaignMessage) string {
+			return m.subLang(msg).Dir()
+		},
+		"FormatDate": func(t interface{}, layout string, msg *CampaignMessage) (string, error) {
+			tm, err := toTime(t)
+			if err != nil {
+				return "", err
+			}
+			return m.subLang(msg).FormatDate(tm, layout), nil
+		},
+		"Attr": func(key string, args ...interface{}) (string, error) {
+			// The last argument is the message, appended on compilation.
+			if len(args) == 0 {
+				return "", errors.New("Attr: missing message argument")
+			}
+			msg, ok := args[len(args)-1].(*CampaignMessage)
+			if !ok {
+				return "", errors.New("Attr: invalid message argument")
+			}
+
+			opts := make([]string, 0, len(args)-1)
+			for _, a := range args[:len(args)-1] {
+				opts = append(opts, fmt.Sprintf("%v", a))
+			}
+			return m.subAttr(msg, key, opts...)
+		},
+		"FormatNumber": func(n interface{}, decimals int, msg *CampaignMessage) (string, error) {
+			f, err := toFloat(n)
+			if err != nil {
+				return "", err
+			}
+			return m.subLang(msg).FormatNumber(f, decimals), nil
+		},
 	}
 
 	for k, v := range m.tplFuncs {
@@ -368,10 +669,34 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 	return f
 }
 
+// MessageSig returns the signature of the hosted web view URL ({{ MessageURL }})
+// of a campaign's message to a subscriber.
+func (m *Manager) MessageSig(campUUID, subUUID string) string {
+	h := hmac.New(sha256.New, m.cfg.SigningKey)
+	h.Write([]byte(campUUID + ":" + subUUID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FileSig returns the signature of a {{ FileURL }} download link of a file
+// that expires at the given Unix timestamp.
+func (m *Manager) FileSig(name string, exp int64) string {
+	h := hmac.New(sha256.New, m.cfg.SigningKey)
+	h.Write([]byte("file:" + name + ":" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (m *Manager) GenericTemplateFuncs() template.FuncMap {
 	return m.tplFuncs
 }
 
+// subLang returns the language map for the subscriber of a message.
+func (m *Manager) subLang(msg *CampaignMessage) *i18n.I18n {
+	if msg.Subscriber.Lang == "" || m.cfg.LoadLang == nil {
+		return m.i18n
+	}
+	return m.cfg.LoadLang(msg.Subscriber.Lang)
+}
+
 // StopCampaign marks a running campaign as stopped so that all its queued messages are ignored.
 func (m *Manager) StopCampaign(id int) {
 	m.pipesMut.RLock()
@@ -381,6 +706,85 @@ func (m *Manager) StopCampaign(id int) {
 	m.pipesMut.RUnlock()
 }
 
+// Halt halts or resumes all sending. On halting, campaigns that are being processed
+// are stopped immediately without changing their status, and their queued messages
+// are dropped. They continue from where they stopped once sending is resumed.
+// Arbitrary messages pushed while sending is halted are rejected.
+func (m *Manager) Halt(halt bool) {
+	if m.halted.Swap(halt) == halt {
+		return
+	}
+
+	if !halt {
+		m.log.Println("sending resumed")
+		return
+	}
+
+	m.stopPipes()
+	m.log.Println("sending halted. stopped all campaigns")
+}
+
+// stopPipes stops all the running campaigns without changing their status
+// so that they're picked up again.
+func (m *Manager) stopPipes() {
+	m.pipesMut.RLock()
+	for _, p := range m.pipes {
+		p.Suspend()
+	}
+	m.pipesMut.RUnlock()
+}
+
+// Drain gracefully stops campaign processing for a shutdown. No new campaigns
+// or batches of subscribers are picked up, the messages of the batches that
+// are already queued are sent, and the campaigns' sent counts and checkpoints
+// are saved without changing their status so that they're resumed on the next
+// start. If the timeout elapses first, the remaining queued messages are
+// skipped. It returns false if the campaigns couldn't be saved in time.
+func (m *Manager) Drain(timeout time.Duration) bool {
+	m.draining.Store(true)
+
+	m.pipesMut.RLock()
+	n := len(m.pipes)
+	for _, p := range m.pipes {
+		p.suspended.Store(true)
+	}
+	m.pipesMut.RUnlock()
+
+	if n == 0 {
+		return true
+	}
+
+	m.log.Printf("draining %d running campaign(s)", n)
+	if m.waitPipes(timeout) {
+		return true
+	}
+
+	// Timed out. Skip the queued messages so that the checkpoints get saved.
+	m.log.Printf("timed out draining campaigns. skipping queued messages")
+	m.stopPipes()
+
+	return m.waitPipes(drainStopTimeout)
+}
+
+// waitPipes waits until all the running campaigns have been cleaned up
+// or the timeout elapses.
+func (m *Manager) waitPipes(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !m.HasRunningCampaigns() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	return !m.HasRunningCampaigns()
+}
+
+// IsHalted returns true if all sending is halted.
+func (m *Manager) IsHalted() bool {
+	return m.halted.Load()
+}
+
 // Close closes and exits the campaign manager.
 func (m *Manager) Close() {
 	close(m.nextPipes)
@@ -398,6 +802,21 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 		select {
 		// Periodically scan the data source for campaigns to process.
 		case <-t.C:
+			// Sending is halted. Running campaigns are picked up once it's resumed.
+			if m.halted.Load() || m.draining.Load() {
+				continue
+			}
+
+			// Another instance is the leader. Stop any campaigns that were
+			// running when leadership was lost.
+			if m.cfg.IsLeader != nil && !m.cfg.IsLeader() {
+				if m.HasRunningCampaigns() {
+					m.log.Println("not the leader. stopping running campaigns")
+					m.stopPipes()
+				}
+				continue
+			}
+
 			ids, counts := m.getCurrentCampaigns()
 			campaigns, err := m.store.NextCampaigns(ids, counts)
 			if err != nil {
@@ -428,13 +847,25 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 
 // worker is a blocking function that perpetually listents to events (message) on different
 // queues and processes them.
-func (m *Manager) worker() {
+func (m *Manager) worker(id int) {
 	// Counter to keep track of the message / sec rate limit.
 	numMsg := 0
 	for {
+		// Workers beyond the concurrency limit don't pick up campaign messages
+		// until the limit is raised.
+		campMsgQ := m.campMsgQ
+		wake := m.conc.park(id)
+		if wake != nil {
+			campMsgQ = nil
+		}
+
 		select {
+		// The concurrency limit has changed.
+		case <-wake:
+			continue
+
 		// Campaign message.
-		case msg, ok := <-m.campMsgQ:
+		case msg, ok := <-campMsgQ:
 			if !ok {
 				return
 			}
@@ -475,6 +906,14 @@ func (m *Manager) worker() {
 				h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>`)
 			}
 
+			if m.cfg.FeedbackID {
+				h.Set(models.EmailHeaderFeedbackID, m.feedbackID(msg.Campaign))
+			}
+
+			if m.cfg.ReplyTracking {
+				h.Set(models.EmailHeaderMessageId, m.messageID(msg))
+			}
+
 			// Attach any custom headers.
 			if len(msg.Campaign.Headers) > 0 {
 				for _, set := range msg.Campaign.Headers {
@@ -486,9 +925,27 @@ func (m *Manager) worker() {
 
 			out.Headers = h
 
-			err := m.messengers[msg.Campaign.Messenger].Push(out)
+			// Fetch the subscriber's personalized attachments and calendar invite, if any.
+			// If they can't be fetched, the message is not sent and is counted as an error.
+			var err error
+			if len(msg.Campaign.AttachmentURLTpls) > 0 || msg.Campaign.Event != nil {
+				var atts []models.Attachment
+				if atts, err = m.subAttachments(msg); err == nil {
+					out.Attachments = append(append([]models.Attachment{}, msg.Campaign.Attachments...), atts...)
+				}
+			}
+
+			if err == nil {
+				start := time.Now()
+				err = m.push(m.messengerName(msg.Campaign), out)
+				if !errors.Is(err, ErrCircuitOpen) {
+					m.conc.record(time.Since(start), err)
+				}
+			}
 			if err != nil {
 				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
+			} else if m.cfg.MessageSentCB != nil {
+				m.cfg.MessageSentCB(msg.Campaign, msg.Subscriber)
 			}
 
 			// Increment the send rate or the error counter if there was an error.
@@ -496,7 +953,11 @@ func (m *Manager) worker() {
 				// Mark the message as done.
 				msg.pipe.wg.Done()
 
-				if err != nil {
+				if errors.Is(err, ErrCircuitOpen) {
+					// The messenger is failing. The campaign is paused and
+					// the message isn't counted as an error.
+					m.pauseBreakerCampaigns(m.breakers[m.messengerName(msg.Campaign)])
+				} else if err != nil {
 					msg.pipe.OnError()
 				} else {
 					id := uint64(msg.Subscriber.ID)
@@ -505,6 +966,8 @@ func (m *Manager) worker() {
 					}
 					msg.pipe.rate.Incr(1)
 					msg.pipe.sent.Add(1)
+					msg.pipe.addVariantSent(msg.VariantLang())
+					msg.pipe.addDomainSent(msg.Subscriber.Email)
 				}
 			}
 
@@ -514,7 +977,13 @@ func (m *Manager) worker() {
 				return
 			}
 
-			err := m.messengers[msg.Messenger].Push(msg)
+			// Sending was halted after the message was queued.
+			if m.halted.Load() {
+				m.log.Printf("sending halted. dropped message '%s'", msg.Subject)
+				continue
+			}
+
+			err := m.push(msg.Messenger, msg)
 			if err != nil {
 				m.log.Printf("error sending message '%s': %v", msg.Subject, err)
 			}
@@ -522,6 +991,27 @@ func (m *Manager) worker() {
 	}
 }
 
+// syncHalt sets the halt state from the data source.
+func (m *Manager) syncHalt() {
+	halted, err := m.store.IsSendingHalted()
+	if err != nil {
+		m.log.Printf("error fetching sending halt state: %v", err)
+		return
+	}
+
+	m.Halt(halted)
+}
+
+// watchHalt is a blocking function that periodically syncs the halt state from the data source.
+func (m *Manager) watchHalt(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		m.syncHalt()
+	}
+}
+
 // getRunningCampaignIDs returns the IDs of campaigns currently being processed.
 func (m *Manager) getRunningCampaignIDs() []int64 {
 	// Needs to return an empty slice in case there are no campaigns.
@@ -565,32 +1055,46 @@ func (m *Manager) isCampaignProcessing(id int) bool {
 	return ok
 }
 
-// trackLink register a URL and return its UUID to be used in message templates
-// for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+// trackLink register a URL and return its tracking URL to be used in message
+// templates for tracking links.
+func (m *Manager) trackLink(url, slug string, msg *CampaignMessage) string {
 	url = strings.ReplaceAll(url, "&amp;", "&")
 
 	m.linksMut.RLock()
-	if uu, ok := m.links[url]; ok {
-		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
-	}
+	uu, ok := m.links[url]
 	m.linksMut.RUnlock()
 
-	// Register link.
-	uu, err := m.store.CreateLink(url)
-	if err != nil {
-		m.log.Printf("error registering tracking for link '%s': %v", url, err)
+	if !ok {
+		// Register link.
+		u, err := m.store.CreateLink(url)
+		if err != nil {
+			m.log.Printf("error registering tracking for link '%s': %v", url, err)
+
+			// If the registration fails, fail over to the original URL.
+			return url
+		}
+		uu = u
+
+		m.linksMut.Lock()
+		m.links[url] = uu
+		m.linksMut.Unlock()
+	}
 
-		// If the registration fails, fail over to the original URL.
-		return url
+	// Short links aren't generated for previews that have dummy campaigns.
+	if m.cfg.ShortLinks && msg.Campaign.ID > 0 && msg.Campaign.UUID != dummyUUID {
+		s, err := m.shortLink(uu, slug, msg)
+		if err == nil {
+			return s
+		}
+		m.log.Printf("error generating short link for '%s': %v", url, err)
 	}
 
-	m.linksMut.Lock()
-	m.links[url] = uu
-	m.linksMut.Unlock()
+	subUUID := msg.Subscriber.UUID
+	if !m.cfg.IndividualTracking {
+		subUUID = dummyUUID
+	}
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, msg.Campaign.UUID, subUUID)
 }
 
 // sendNotif sends a notification to registered admin e-mails.
@@ -625,7 +1129,14 @@ func (m *Manager) makeGnericFuncMap() template.FuncMap {
 		},
 	}
 
+	allowed := make(map[string]bool, len(m.cfg.TemplateFuncs))
+	for _, k := range m.cfg.TemplateFuncs {
+		allowed[k] = true
+	}
 	for k, v := range sprig.GenericFuncMap() {
+		if deniedTplFuncs[k] || (len(allowed) > 0 && !allowed[k]) {
+			continue
+		}
 		f[k] = v
 	}
 
@@ -663,3 +1174,39 @@ func MakeAttachmentHeader(filename, encoding, contentType string) textproto.MIME
 	h.Set("Content-Transfer-Encoding", encoding)
 	return h
 }
+
+// toTime converts a time value passed to a template function to time.Time.
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t != nil {
+			return *t, nil
+		}
+	case null.Time:
+		return t.Time, nil
+	case string:
+		return time.Parse(time.RFC3339, t)
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time value: %v", v)
+}
+
+// toFloat converts a numeric value passed to a template function to float64.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float32:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+
+	return 0, fmt.Errorf("invalid number: %v", v)
+}
@@ -1,8 +1,8 @@
 package manager
 
 import (
-	"bytes"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/models"
 )
@@ -14,48 +14,158 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 	msg := CampaignMessage{
 		Campaign:   c,
 		Subscriber: s,
+		Sender:     m.cfg.Sender.Merge(c.Sender),
 
-		subject:  c.Subject,
-		from:     c.FromEmail,
-		to:       s.Email,
-		unsubURL: fmt.Sprintf(m.cfg.UnsubURL, c.UUID, s.UUID),
+		subject:   c.Subject,
+		preheader: c.Preheader,
+		from:      c.FromEmail,
+		to:        s.Email,
+		unsubURL:  m.UnsubURL(c.UUID, s.UUID),
+		variant:   c.Variant(s.Lang),
 	}
 
-	if err := msg.render(); err != nil {
+	if err := m.renderMessage(&msg); err != nil {
 		return msg, err
 	}
+	m.applyDarkModeMeta(&msg)
 
 	return msg, nil
 }
 
+// NewWebViewMessage returns a campaign message rendered for the hosted web view
+// of the message ({{ MessageURL }}). View and link tracking are not rendered.
+func (m *Manager) NewWebViewMessage(c *models.Campaign, s models.Subscriber) (CampaignMessage, error) {
+	msg := CampaignMessage{
+		Campaign:   c,
+		Subscriber: s,
+		Sender:     m.cfg.Sender.Merge(c.Sender),
+
+		subject:   c.Subject,
+		preheader: c.Preheader,
+		from:      c.FromEmail,
+		to:        s.Email,
+		unsubURL:  m.UnsubURL(c.UUID, s.UUID),
+		noTrack:   true,
+		variant:   c.Variant(s.Lang),
+	}
+
+	if err := m.renderMessage(&msg); err != nil {
+		return msg, err
+	}
+	m.applyDarkModeMeta(&msg)
+
+	return msg, nil
+}
+
+// renderMessage renders a message with the subscriber's name and attributes
+// sanitized for the templates. The message retains the original subscriber
+// for the messengers. Rendering is subject to the configured size and time
+// limits so that a runaway template can't stall the pipeline.
+func (m *Manager) renderMessage(msg *CampaignMessage) error {
+	sub := msg.Subscriber
+	msg.Subscriber = m.sanitizer.Subscriber(sub)
+	defer func() {
+		msg.Subscriber = sub
+	}()
+
+	lim := renderLimits{maxSize: m.cfg.RenderMaxSize}
+	if m.cfg.RenderTimeout <= 0 {
+		return msg.render(lim)
+	}
+	lim.deadline = time.Now().Add(m.cfg.RenderTimeout)
+
+	// Rendering is aborted on the first write after the deadline, but a
+	// template that runs without writing anything, eg: a long loop with no
+	// output, can't be interrupted. Such a render is abandoned on timeout.
+	// It works on a copy of the message so that it doesn't modify the message
+	// after it's been abandoned.
+	var (
+		cp   = *msg
+		done = make(chan error, 1)
+		t    = time.NewTimer(m.cfg.RenderTimeout)
+	)
+	defer t.Stop()
+
+	go func() {
+		done <- cp.render(lim)
+	}()
+
+	select {
+	case err := <-done:
+		*msg = cp
+		return err
+	case <-t.C:
+		return errRenderTimeout
+	}
+}
+
+// SanitizeSubscriber returns a copy of a subscriber with the name and
+// attributes sanitized for rendering in templates, eg: transactional ones.
+func (m *Manager) SanitizeSubscriber(sub models.Subscriber) models.Subscriber {
+	return m.sanitizer.Subscriber(sub)
+}
+
 // render takes a Message, executes its pre-compiled Campaign.Tpl
 // and applies the resultant bytes to Message.body to be used in messages.
-func (m *CampaignMessage) render() error {
-	out := bytes.Buffer{}
+func (m *CampaignMessage) render(lim renderLimits) error {
+	out := &limitWriter{lim: lim}
+
+	// Use the subscriber's language variant, if there's one.
+	var (
+		tpl     = m.Campaign.Tpl
+		subjTpl = m.Campaign.SubjectTpl
+		static  = m.Campaign.StaticBody
+	)
+	if v := m.variant; v != nil && v.Tpl != nil {
+		tpl, subjTpl, static = v.Tpl, v.SubjectTpl, nil
+		m.subject = v.Subject
+	} else {
+		m.variant = nil
+	}
 
 	// Render the subject if it's a template.
-	if m.Campaign.SubjectTpl != nil {
-		if err := m.Campaign.SubjectTpl.ExecuteTemplate(&out, models.ContentTpl, m); err != nil {
+	if subjTpl != nil {
+		if err := subjTpl.ExecuteTemplate(out, models.ContentTpl, m); err != nil {
 			return err
 		}
-		m.subject = out.String()
-		out.Reset()
+		m.subject = out.buf.String()
+		out.buf.Reset()
 	}
 
-	// Compile the main template.
-	if err := m.Campaign.Tpl.ExecuteTemplate(&out, models.BaseTpl, m); err != nil {
-		return err
+	// Render the preheader if it's a template.
+	if m.Campaign.PreheaderTpl != nil {
+		if err := m.Campaign.PreheaderTpl.ExecuteTemplate(out, models.ContentTpl, m); err != nil {
+			return err
+		}
+		m.preheader = strings.TrimSpace(out.buf.String())
+		out.buf.Reset()
 	}
-	m.body = out.Bytes()
 
-	// Is there an alt body?
-	if m.Campaign.ContentType != models.CampaignContentTypePlain && m.Campaign.AltBody.Valid {
+	// Compile the main template, unless it's static and has been pre-rendered.
+	if static != nil {
+		m.body = static
+	} else {
+		if err := tpl.ExecuteTemplate(out, models.BaseTpl, m); err != nil {
+			return err
+		}
+		m.body = out.buf.Bytes()
+	}
+
+	// Inject the preheader into HTML messages. This always returns a new
+	// slice and doesn't modify the shared StaticBody.
+	if m.Campaign.ContentType != models.CampaignContentTypePlain && m.preheader != "" {
+		m.body = InjectPreheader(m.body, m.preheader)
+	}
+
+	// Is there an alt body? It's in the campaign's default language and isn't
+	// used for variants.
+	if m.Campaign.ContentType != models.CampaignContentTypePlain && m.Campaign.AltBody.Valid && m.variant == nil {
 		if m.Campaign.AltBodyTpl != nil {
-			b := bytes.Buffer{}
-			if err := m.Campaign.AltBodyTpl.ExecuteTemplate(&b, models.ContentTpl, m); err != nil {
+			b := &limitWriter{lim: lim}
+			if err := m.Campaign.AltBodyTpl.ExecuteTemplate(b, models.ContentTpl, m); err != nil {
 				return err
 			}
-			m.altBody = b.Bytes()
+			m.altBody = b.buf.Bytes()
 		} else {
 			m.altBody = []byte(m.Campaign.AltBody.String)
 		}
@@ -69,6 +179,20 @@ func (m *CampaignMessage) Subject() string {
 	return m.subject
 }
 
+// Preheader returns the rendered preheader of the message.
+func (m *CampaignMessage) Preheader() string {
+	return m.preheader
+}
+
+// VariantLang returns the language of the campaign variant of the message,
+// or an empty string if it has the campaign's default content.
+func (m *CampaignMessage) VariantLang() string {
+	if m.variant == nil {
+		return ""
+	}
+	return m.variant.Lang
+}
+
 // Body returns a copy of the message body.
 func (m *CampaignMessage) Body() []byte {
 	out := make([]byte, len(m.body))
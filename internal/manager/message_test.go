@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/sanitize"
+	"github.com/knadh/listmonk/models"
+)
+
+func TestNewCampaignMessageSanitize(t *testing.T) {
+	sub := models.Subscriber{
+		UUID:    "00000000-0000-0000-0000-000000000000",
+		Email:   "tom@example.com",
+		Name:    `Tom & Jerry <b>{{ .Campaign.UUID }}</b>`,
+		Attribs: models.JSON{"city": "A&B"},
+	}
+
+	for _, mode := range []string{sanitize.ModeEscape, sanitize.ModeStrip} {
+		m := New(Config{
+			UnsubURL: "https://example.com/subscription/%s/%s",
+			Sanitize: sanitize.Policy{Enabled: true, Mode: mode},
+		}, nil, nil, nil, log.New(io.Discard, "", 0))
+
+		c := models.Campaign{
+			UUID:         "11111111-1111-1111-1111-111111111111",
+			Subject:      "Hi {{ .Subscriber.Name }}",
+			ContentType:  models.CampaignContentTypeRichtext,
+			Body:         `<p>{{ .Subscriber.Name }} from {{ .Subscriber.Attribs.city }}</p>`,
+			TemplateBody: `{{ template "content" . }}`,
+		}
+		if err := c.CompileTemplate(m.TemplateFuncs(&c)); err != nil {
+			t.Fatalf("%s: error compiling template: %v", mode, err)
+		}
+
+		msg, err := m.NewCampaignMessage(&c, sub)
+		if err != nil {
+			t.Fatalf("%s: error rendering message: %v", mode, err)
+		}
+
+		var (
+			body = string(msg.Body())
+			exp  = map[string][2]string{
+				sanitize.ModeEscape: {
+					`<p>Tom &amp; Jerry &lt;b&gt; .Campaign.UUID &lt;/b&gt; from A&amp;B</p>`,
+					`Hi Tom & Jerry <b> .Campaign.UUID </b>`,
+				},
+				sanitize.ModeStrip: {
+					`<p>Tom &amp; Jerry  .Campaign.UUID  from A&amp;B</p>`,
+					`Hi Tom & Jerry  .Campaign.UUID `,
+				},
+			}[mode]
+		)
+
+		// Values are HTML-escaped once by the template in the body, and not
+		// at all in the subject.
+		if !strings.Contains(body, exp[0]) {
+			t.Errorf("%s: unexpected body: %q", mode, body)
+		}
+		if msg.Subject() != exp[1] {
+			t.Errorf("%s: unexpected subject: %q", mode, msg.Subject())
+		}
+
+		// The message retains the original subscriber.
+		if msg.Subscriber.Name != sub.Name {
+			t.Errorf("%s: subscriber modified: %q", mode, msg.Subscriber.Name)
+		}
+	}
+}
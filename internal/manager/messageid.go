@@ -0,0 +1,26 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"strings"
+)
+
+// messageID returns a Message-ID for a campaign message that embeds the
+// campaign and subscriber UUIDs, eg: <{campaign}.{subscriber}.{random}@domain>,
+// so that replies, which reference it in their In-Reply-To and References
+// headers, can be mapped back to the campaign and the subscriber.
+func (m *Manager) messageID(msg CampaignMessage) string {
+	host := "localhost"
+	if a, err := mail.ParseAddress(msg.from); err == nil {
+		if i := strings.LastIndex(a.Address, "@"); i > -1 {
+			host = a.Address[i+1:]
+		}
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return "<" + msg.Campaign.UUID + "." + msg.Subscriber.UUID + "." + hex.EncodeToString(b) + "@" + host + ">"
+}
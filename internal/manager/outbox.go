@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"github.com/knadh/listmonk/models"
+)
+
+// holdForReview checks if a campaign that's picked up has few enough recipients
+// to be held for review, and if yes, marks it for its messages to be rendered
+// into the outbox instead of being sent.
+func (m *Manager) holdForReview(c *models.Campaign) error {
+	if m.cfg.OutboxReviewThreshold < 1 || c.Outbox != "" {
+		return nil
+	}
+
+	ok, err := m.store.StartOutbox(c.ID, m.cfg.OutboxReviewThreshold)
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.Outbox = models.CampaignOutboxRendering
+		m.log.Printf("rendering campaign (%s) into the outbox for review", c.Name)
+	}
+
+	return nil
+}
+
+// saveToOutbox stores a rendered message in the outbox instead of sending it.
+func (p *pipe) saveToOutbox(msg CampaignMessage) {
+	defer p.wg.Done()
+
+	if err := p.m.store.SaveOutboxMessage(models.OutboxMessage{
+		CampaignID:   p.camp.ID,
+		SubscriberID: msg.Subscriber.ID,
+		Email:        msg.Subscriber.Email,
+		Subject:      msg.subject,
+		Body:         string(msg.body),
+		AltBody:      string(msg.altBody),
+	}); err != nil {
+		p.m.log.Printf("error saving outbox message (%s) (%s): %v", p.camp.Name, msg.Subscriber.Email, err)
+		p.OnError()
+		return
+	}
+
+	if id := uint64(msg.Subscriber.ID); id > p.lastID.Load() {
+		p.lastID.Store(id)
+	}
+}
+
+// loadOutbox returns the approved outbox messages of a batch of subscribers
+// mapped by the subscriber IDs.
+func (p *pipe) loadOutbox(subs []models.Subscriber) (map[int]models.OutboxMessage, error) {
+	ids := make([]int, 0, len(subs))
+	for _, s := range subs {
+		ids = append(ids, s.ID)
+	}
+
+	return p.m.store.GetOutboxMessages(p.camp.ID, ids)
+}
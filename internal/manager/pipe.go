@@ -1,7 +1,9 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,6 +12,21 @@ import (
 	"github.com/paulbellamy/ratecounter"
 )
 
+const (
+	// Batch sizes are adapted so that pushing a batch of messages takes roughly
+	// batchTargetDuration. Faster delivery fetches bigger batches (fewer DB round trips)
+	// and slower delivery fetches smaller ones, bounded by a factor of the configured BatchSize.
+	batchTargetDuration = time.Second * 5
+	batchSizeFactor     = 10
+	minBatchSize        = 100
+)
+
+// subBatch is the result of a (pre)fetch of a batch of subscribers.
+type subBatch struct {
+	subs []models.Subscriber
+	err  error
+}
+
 type pipe struct {
 	camp       *models.Campaign
 	rate       *ratecounter.RateCounter
@@ -20,6 +37,34 @@ type pipe struct {
 	stopped    atomic.Bool
 	withErrors atomic.Bool
 
+	// reason is the error due to which the campaign was paused, eg: by a
+	// messenger's circuit breaker. nil if it was paused due to too many errors.
+	// breaker is the messenger whose circuit breaker paused it, if any.
+	reason  error
+	breaker string
+
+	// suspended indicates that the campaign is being stopped without
+	// changing its status (halt, shutdown, loss of leadership) so that it's
+	// picked up again from its checkpoint.
+	suspended atomic.Bool
+
+	// Current (adaptive) number of subscribers to fetch in a batch.
+	batchSize int
+
+	// The next batch of subscribers that's fetched in the background while
+	// the current batch is being pushed, and the in-flight prefetch, which
+	// cleanup() waits for before it writes the checkpoint.
+	nextBatch  chan subBatch
+	prefetchWg sync.WaitGroup
+
+	// Sent counts of the campaign's language variants ("" is the default).
+	variantSent    map[string]int
+	variantSentMut sync.Mutex
+
+	// Sent counts of the recipient e-mail domains.
+	domainSent    map[string]int
+	domainSentMut sync.Mutex
+
 	m *Manager
 }
 
@@ -32,7 +77,7 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 	}
 
 	// Load the template.
-	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+	if err := m.CompileCampaign(c); err != nil {
 		return nil, err
 	}
 
@@ -41,12 +86,18 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 		return nil, err
 	}
 
+	// Small campaigns may be held for review in the outbox.
+	if err := m.holdForReview(c); err != nil {
+		return nil, err
+	}
+
 	// Add the campaign to the active map.
 	p := &pipe{
-		camp: c,
-		rate: ratecounter.NewRateCounter(time.Minute),
-		wg:   &sync.WaitGroup{},
-		m:    m,
+		camp:      c,
+		rate:      ratecounter.NewRateCounter(time.Minute),
+		wg:        &sync.WaitGroup{},
+		batchSize: m.cfg.BatchSize,
+		m:         m,
 	}
 
 	// Increment the waitgroup so that Wait() blocks immediately. This is necessary
@@ -74,9 +125,18 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 // It returns a bool indicating whether any subscribers were processed
 // in the current batch or not. A false indicates that all subscribers
 // have been processed, or that a campaign has been paused or cancelled.
+//
+// Subscribers are fetched in keyset paginated batches (the campaign's last_subscriber_id
+// checkpoint). While a batch is being pushed, the next one is prefetched in the background.
 func (p *pipe) NextSubscribers() (bool, error) {
-	// Fetch a batch of subscribers.
-	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize)
+	// The campaign has been stopped (eg: all sending has been halted), or the
+	// manager is draining for a shutdown and no new batches are picked up.
+	if p.stopped.Load() || p.m.draining.Load() {
+		return false, nil
+	}
+
+	// Fetch a batch of subscribers, or pick up the prefetched batch.
+	subs, err := p.fetch()
 	if err != nil {
 		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", p.camp.Name, err)
 	}
@@ -86,6 +146,24 @@ func (p *pipe) NextSubscribers() (bool, error) {
 		return false, nil
 	}
 
+	// The messages of an approved outbox are sent as they were reviewed.
+	var (
+		approved = p.camp.Outbox == models.CampaignOutboxApproved
+		outbox   map[int]models.OutboxMessage
+	)
+	if approved {
+		if outbox, err = p.loadOutbox(subs); err != nil {
+			return false, fmt.Errorf("error fetching campaign outbox (%s): %v", p.camp.Name, err)
+		}
+	}
+
+	// Prefetch the next batch while this one is being pushed. The prefetched batch
+	// advances the checkpoint in the DB, but if the campaign is stopped midway,
+	// cleanup() waits for the prefetch to land and then resets the checkpoint to
+	// the last subscriber that was actually processed.
+	p.prefetch()
+	start := time.Now()
+
 	// Is there a sliding window limit configured?
 	hasSliding := p.m.cfg.SlidingWindow &&
 		p.m.cfg.SlidingWindowRate > 0 &&
@@ -93,9 +171,41 @@ func (p *pipe) NextSubscribers() (bool, error) {
 
 	// Push messages.
 	for _, s := range subs {
+		// The campaign was stopped midway. Skip the rest of the batch.
+		if p.stopped.Load() {
+			break
+		}
+
+		// Subscribers who weren't in the reviewed outbox are skipped.
+		ob, ok := outbox[s.ID]
+		if approved && !ok {
+			continue
+		}
+
 		msg, err := p.newMessage(s)
 		if err != nil {
 			p.m.log.Printf("error rendering message (%s) (%s): %v", p.camp.Name, s.Email, err)
+
+			// A template that times out would time out for every subscriber,
+			// each abandoning a render that runs on in the background.
+			// Pause the campaign instead of moving on to the next subscriber.
+			if errors.Is(err, errRenderTimeout) {
+				p.pause(fmt.Errorf("%v. Fix the campaign's template and resume it", err), "")
+				break
+			}
+			continue
+		}
+
+		if approved {
+			msg.subject, msg.body = ob.Subject, []byte(ob.Body)
+			if ob.AltBody != "" {
+				msg.altBody = []byte(ob.AltBody)
+			}
+		}
+
+		// The campaign is held for review. Render the message into the outbox.
+		if p.camp.Outbox == models.CampaignOutboxRendering {
+			p.saveToOutbox(msg)
 			continue
 		}
 
@@ -125,15 +235,104 @@ func (p *pipe) NextSubscribers() (bool, error) {
 					p.m.slidingStart.Format(time.RFC822Z),
 					wait.Round(time.Second)*1)
 
+				if p.m.cfg.SlidingWindowCB != nil {
+					p.m.cfg.SlidingWindowCB(p.m.slidingCount, wait)
+				}
+
 				p.m.slidingCount = 0
 				time.Sleep(wait)
 			}
 		}
 	}
 
+	p.adaptBatchSize(len(subs), time.Since(start))
+
 	return true, nil
 }
 
+// fetch returns the prefetched batch of subscribers if there's one, or fetches
+// a batch from the DB.
+func (p *pipe) fetch() ([]models.Subscriber, error) {
+	if p.nextBatch != nil {
+		b := <-p.nextBatch
+		p.nextBatch = nil
+		return b.subs, b.err
+	}
+
+	return p.m.store.NextSubscribers(p.camp.ID, p.batchSize)
+}
+
+// prefetch fetches the next batch of subscribers in the background.
+func (p *pipe) prefetch() {
+	ch := make(chan subBatch, 1)
+	p.nextBatch = ch
+
+	size := p.batchSize
+	p.prefetchWg.Add(1)
+	go func() {
+		defer p.prefetchWg.Done()
+		subs, err := p.m.store.NextSubscribers(p.camp.ID, size)
+		ch <- subBatch{subs: subs, err: err}
+	}()
+}
+
+// adaptBatchSize adjusts the batch size for the next fetch based on the delivery
+// throughput of the last batch of n subscribers that took d to push.
+func (p *pipe) adaptBatchSize(n int, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	var (
+		rate    = float64(n) / d.Seconds()
+		target  = int(rate * batchTargetDuration.Seconds())
+		minSize = p.m.cfg.BatchSize / batchSizeFactor
+		maxSize = p.m.cfg.BatchSize * batchSizeFactor
+	)
+	if minSize < minBatchSize {
+		minSize = minBatchSize
+	}
+
+	// Move halfway towards the target to smoothen out fluctuations.
+	size := (p.batchSize + target) / 2
+	if size < minSize {
+		size = minSize
+	} else if size > maxSize {
+		size = maxSize
+	}
+
+	p.batchSize = size
+}
+
+// addVariantSent increments the sent count of a language variant.
+func (p *pipe) addVariantSent(lang string) {
+	if len(p.camp.Variants) == 0 {
+		return
+	}
+
+	p.variantSentMut.Lock()
+	if p.variantSent == nil {
+		p.variantSent = make(map[string]int)
+	}
+	p.variantSent[lang]++
+	p.variantSentMut.Unlock()
+}
+
+// addDomainSent increments the sent count of the domain of a recipient e-mail.
+func (p *pipe) addDomainSent(email string) {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return
+	}
+
+	p.domainSentMut.Lock()
+	if p.domainSent == nil {
+		p.domainSent = make(map[string]int)
+	}
+	p.domainSent[strings.ToLower(email[i+1:])]++
+	p.domainSentMut.Unlock()
+}
+
 func (p *pipe) OnError() {
 	if p.m.cfg.MaxSendErrors < 1 {
 		return
@@ -165,6 +364,27 @@ func (p *pipe) Stop(withErrors bool) {
 	p.stopped.Store(true)
 }
 
+// pause stops a campaign with an error as the reason for pausing it, and
+// the messenger whose circuit breaker paused it, if any. It returns false if
+// the campaign was already stopped.
+func (p *pipe) pause(reason error, breaker string) bool {
+	if p.stopped.Load() {
+		return false
+	}
+
+	p.reason = reason
+	p.breaker = breaker
+	p.Stop(true)
+	return true
+}
+
+// Suspend stops a campaign without changing its status so that it's picked
+// up again from its checkpoint.
+func (p *pipe) Suspend() {
+	p.suspended.Store(true)
+	p.Stop(false)
+}
+
 func (p *pipe) newMessage(s models.Subscriber) (CampaignMessage, error) {
 	msg, err := p.m.NewCampaignMessage(p.camp, s)
 	if err != nil {
@@ -184,11 +404,60 @@ func (p *pipe) cleanup() {
 		p.m.pipesMut.Unlock()
 	}()
 
-	// Update campaign's "sent" count.
+	// A prefetch that's still in flight moves the checkpoint forward in the DB.
+	// Wait for it so that it doesn't overwrite the checkpoint written below,
+	// which would skip the prefetched batch when the campaign is resumed.
+	p.prefetchWg.Wait()
+
+	// Update campaign's "sent" count and the checkpoint.
 	if err := p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), int(p.lastID.Load())); err != nil {
 		p.m.log.Printf("error updating campaign counts (%s): %v", p.camp.Name, err)
 	}
 
+	// Update the sent counts of the language variants.
+	p.variantSentMut.Lock()
+	if len(p.variantSent) > 0 {
+		if err := p.m.store.UpdateCampaignVariantCounts(p.camp.ID, p.variantSent); err != nil {
+			p.m.log.Printf("error updating campaign variant counts (%s): %v", p.camp.Name, err)
+		}
+	}
+	p.variantSentMut.Unlock()
+
+	// Update the sent counts of the recipient domains.
+	p.domainSentMut.Lock()
+	if len(p.domainSent) > 0 {
+		if err := p.m.store.UpdateCampaignDomainCounts(p.camp.ID, p.domainSent); err != nil {
+			p.m.log.Printf("error updating campaign domain counts (%s): %v", p.camp.Name, err)
+		}
+	}
+	p.domainSentMut.Unlock()
+
+	// Sending has been halted. The campaign retains its status and is
+	// picked up again once sending is resumed.
+	if p.m.halted.Load() && !p.withErrors.Load() {
+		p.m.log.Printf("sending halted. stopped campaign (%s)", p.camp.Name)
+		return
+	}
+
+	// The campaign was suspended (shutdown, loss of leadership). It retains its
+	// status and is resumed from the checkpoint.
+	if p.suspended.Load() && !p.withErrors.Load() {
+		p.m.log.Printf("suspended campaign (%s) at %d sent", p.camp.Name, p.sent.Load())
+		return
+	}
+
+	// The campaign was paused with a reason, eg: by a circuit breaker.
+	if p.withErrors.Load() && p.reason != nil {
+		if err := p.m.store.PauseCampaign(p.camp.ID, p.reason, p.breaker); err != nil {
+			p.m.log.Printf("error updating campaign (%s) status to %s: %v", p.camp.Name, models.CampaignStatusPaused, err)
+		} else {
+			p.m.log.Printf("set campaign (%s) to %s: %v", p.camp.Name, models.CampaignStatusPaused, p.reason)
+		}
+
+		_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, p.reason.Error())
+		return
+	}
+
 	// The campaign was auto-paused due to errors.
 	if p.withErrors.Load() {
 		if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusPaused); err != nil {
@@ -208,6 +477,19 @@ func (p *pipe) cleanup() {
 		return
 	}
 
+	// All the messages of a campaign held for review have been rendered into the
+	// outbox. It's paused until the outbox is approved.
+	if c.Status == models.CampaignStatusRunning && p.camp.Outbox == models.CampaignOutboxRendering {
+		if err := p.m.store.FinishOutbox(p.camp.ID); err != nil {
+			p.m.log.Printf("error finishing campaign (%s) outbox: %v", p.camp.Name, err)
+			return
+		}
+
+		p.m.log.Printf("campaign (%s) is in the outbox for review", p.camp.Name)
+		_ = p.m.sendNotif(c, models.CampaignStatusPaused, "Messages are in the outbox for review")
+		return
+	}
+
 	// If a running campaign has exhausted subscribers, it's finished.
 	if c.Status == models.CampaignStatusRunning {
 		c.Status = models.CampaignStatusFinished
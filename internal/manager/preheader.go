@@ -0,0 +1,33 @@
+package manager
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+)
+
+// preheaderTpl is the hidden block that carries the preheader. Clients show
+// its text in the inbox next to the subject, but not in the message itself.
+// The trailing zero-width spaces keep clients from pulling body text into
+// the preview after a short preheader.
+const preheaderTpl = `<div style="display:none;font-size:1px;color:transparent;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;mso-hide:all;">%s` +
+	`&#847;&zwnj;&nbsp;&#847;&zwnj;&nbsp;&#847;&zwnj;&nbsp;&#847;&zwnj;&nbsp;&#847;&zwnj;&nbsp;&#847;&zwnj;&nbsp;</div>
+`
+
+var reBodyOpen = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// InjectPreheader inserts a hidden preheader right after the opening <body>
+// tag of an HTML message, or at the beginning of bodies that don't have one.
+// The preheader text is HTML escaped.
+func InjectPreheader(body []byte, preheader string) []byte {
+	if preheader == "" {
+		return body
+	}
+
+	pos := 0
+	if loc := reBodyOpen.FindIndex(body); loc != nil {
+		pos = loc[1]
+	}
+
+	return insertAt(body, pos, fmt.Sprintf(preheaderTpl, html.EscapeString(preheader)))
+}
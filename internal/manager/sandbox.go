@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+var (
+	errRenderTimeout = errors.New("rendering the template timed out")
+	errRenderSize    = errors.New("rendered message exceeds the maximum size")
+
+	// deniedTplFuncs are the Sprig functions that are never available to
+	// campaigns and templates as they expose the host's environment, or can
+	// allocate unbounded memory or burn CPU without writing anything, which
+	// the render limits can't interrupt.
+	deniedTplFuncs = map[string]bool{
+		"env":       true,
+		"expandenv": true,
+
+		"until":     true,
+		"untilStep": true,
+		"seq":       true,
+		"repeat":    true,
+
+		"derivePassword":           true,
+		"genPrivateKey":            true,
+		"genCA":                    true,
+		"genCAWithKey":             true,
+		"genSelfSignedCert":        true,
+		"genSelfSignedCertWithKey": true,
+		"genSignedCert":            true,
+		"genSignedCertWithKey":     true,
+		"bcrypt":                   true,
+		"htpasswd":                 true,
+	}
+)
+
+// IsTemplateFunc checks whether name is a Sprig function that can be allowed
+// in campaigns and templates.
+func IsTemplateFunc(name string) bool {
+	if deniedTplFuncs[name] {
+		return false
+	}
+	_, ok := sprig.GenericFuncMap()[name]
+	return ok
+}
+
+// renderLimits are the limits on rendering a message's templates.
+type renderLimits struct {
+	// maxSize is the maximum size in bytes of a rendered template. 0 disables the limit.
+	maxSize int
+
+	// deadline is the time after which rendering is aborted. A zero value disables it.
+	deadline time.Time
+}
+
+// limitWriter is a buffer that templates are rendered into. Writes fail once
+// the size limit is exceeded or the deadline has passed, which aborts the
+// execution of the template.
+type limitWriter struct {
+	buf bytes.Buffer
+	lim renderLimits
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.lim.maxSize > 0 && w.buf.Len()+len(p) > w.lim.maxSize {
+		return 0, errRenderSize
+	}
+	if !w.lim.deadline.IsZero() && time.Now().After(w.lim.deadline) {
+		return 0, errRenderTimeout
+	}
+
+	return w.buf.Write(p)
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Length of the random slugs of short links.
+	shortLinkSlugLen = 6
+
+	// Number of attempts at registering a slug before giving up.
+	shortLinkAttempts = 5
+
+	slugChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// reSlug matches custom short link slugs, eg: {{ TrackLink "https://x.com" "spring-sale" }}.
+var reSlug = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// shortLink returns the short tracking URL of a link in a campaign, eg:
+// url.com/l/{slug}/{subscriber}. The slug is unique per link per campaign.
+// A custom slug is used if it's given and available. If it's taken by another
+// link, a random suffix is added to it.
+func (m *Manager) shortLink(linkUUID, customSlug string, msg *CampaignMessage) (string, error) {
+	campID := msg.Campaign.ID
+	key := fmt.Sprintf("%d:%s", campID, linkUUID)
+
+	m.linksMut.RLock()
+	slug, ok := m.slugs[key]
+	m.linksMut.RUnlock()
+
+	if !ok {
+		if customSlug != "" && !reSlug.MatchString(customSlug) {
+			return "", fmt.Errorf("invalid short link slug '%s'", customSlug)
+		}
+
+		for i := 0; i < shortLinkAttempts && slug == ""; i++ {
+			var cand string
+			switch {
+			case customSlug != "" && i == 0:
+				cand = customSlug
+			case customSlug != "":
+				cand = customSlug + "-" + randSlug(3)
+			default:
+				// Grow the slug on every collision.
+				cand = randSlug(shortLinkSlugLen + i)
+			}
+
+			s, err := m.store.CreateLinkSlug(campID, linkUUID, cand)
+			if err != nil {
+				return "", err
+			}
+			slug = s
+		}
+		if slug == "" {
+			return "", fmt.Errorf("could not find an available short link slug")
+		}
+
+		m.linksMut.Lock()
+		m.slugs[key] = slug
+		m.linksMut.Unlock()
+	}
+
+	// If individual tracking is disabled, the link doesn't identify the subscriber.
+	u := fmt.Sprintf(m.cfg.ShortLinkURL, slug)
+	if !m.cfg.IndividualTracking || msg.Subscriber.ID < 1 {
+		return u, nil
+	}
+
+	return u + "/" + strconv.FormatInt(int64(msg.Subscriber.ID), 36) + "." + m.ShortLinkSig(slug, msg.Subscriber.ID), nil
+}
+
+// ShortLinkSig returns the signature of a subscriber's short link that
+// prevents the (sequential) subscriber IDs in the links from being tampered with.
+func (m *Manager) ShortLinkSig(slug string, subID int) string {
+	h := hmac.New(sha256.New, m.cfg.SigningKey)
+	h.Write([]byte("link:" + slug + ":" + strconv.Itoa(subID)))
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// randSlug returns a random alphanumeric string of length n.
+func randSlug(n int) string {
+	var (
+		b   strings.Builder
+		max = big.NewInt(int64(len(slugChars)))
+	)
+	for i := 0; i < n; i++ {
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			c = big.NewInt(0)
+		}
+		b.WriteByte(slugChars[c.Int64()])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Purposes of signed public links. A token signed for one purpose
+// can't be used for another.
+const (
+	// LinkManage is the purpose of the unsubscribe and preferences links
+	// and the privacy (export, wipe) and billing actions on the preferences page.
+	LinkManage = "manage"
+
+	// LinkOptin is the purpose of the double opt-in confirmation links.
+	LinkOptin = "optin"
+
+	// LinkPreview is the purpose of the temporary links to admin previews
+	// of a subscriber's public pages.
+	LinkPreview = "preview"
+)
+
+var (
+	// ErrLinkInvalid is returned when a public link's token is malformed
+	// or its signature doesn't match.
+	ErrLinkInvalid = errors.New("invalid link signature")
+
+	// ErrLinkExpired is returned when a public link's token has expired.
+	ErrLinkExpired = errors.New("link has expired")
+)
+
+// LinkToken returns a signed token for a subscriber's public link with the
+// given purpose, eg: {exp}.{sig}. The token expires after the configured
+// PublicLinkExpiry and never expires if it's 0.
+func (m *Manager) LinkToken(purpose, subUUID string) string {
+	return m.TempLinkToken(purpose, subUUID, m.cfg.PublicLinkExpiry)
+}
+
+// TempLinkToken returns a signed token for a link with the given purpose and
+// key that expires after ttl. A ttl of 0 never expires the token.
+func (m *Manager) TempLinkToken(purpose, key string, ttl time.Duration) string {
+	var exp int64
+	if ttl > 0 {
+		exp = time.Now().Add(ttl).Unix()
+	}
+
+	e := strconv.FormatInt(exp, 10)
+	return e + "." + m.linkSig(purpose, key, e)
+}
+
+// CheckLinkToken verifies the signature and the expiry of a public link's
+// token for the given purpose and key, eg: the subscriber's UUID.
+func (m *Manager) CheckLinkToken(tok, purpose, key string) error {
+	e, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return ErrLinkInvalid
+	}
+
+	exp, err := strconv.ParseInt(e, 10, 64)
+	if err != nil || exp < 0 {
+		return ErrLinkInvalid
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(m.linkSig(purpose, key, e))) {
+		return ErrLinkInvalid
+	}
+
+	if exp > 0 && time.Now().Unix() > exp {
+		return ErrLinkExpired
+	}
+
+	return nil
+}
+
+// UnsubURL returns a subscriber's signed unsubscribe URL from a campaign.
+func (m *Manager) UnsubURL(campUUID, subUUID string) string {
+	return fmt.Sprintf(m.cfg.UnsubURL, campUUID, subUUID) + "?t=" + m.LinkToken(LinkManage, subUUID)
+}
+
+// linkSig returns the signature of a public link's purpose, key, and expiry.
+func (m *Manager) linkSig(purpose, key, exp string) string {
+	h := hmac.New(sha256.New, m.cfg.SigningKey)
+	h.Write([]byte("pub:" + purpose + ":" + key + ":" + exp))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
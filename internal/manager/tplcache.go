@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/fnv"
+	"html/template"
+	"sync"
+	"text/template/parse"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// maxCampTpls is the maximum number of compiled campaign templates held in the
+// cache. The cache is reset when it fills up.
+const maxCampTpls = 1000
+
+// campTpl is a compiled campaign template cached by the campaign's revision.
+type campTpl struct {
+	campID int
+	c      models.Campaign
+}
+
+// campTplCache caches compiled campaign templates keyed by the campaign's
+// revision, a hash of its content and template, so that the templates of
+// running, resumed, archived, and previewed campaigns are not re-compiled
+// (Markdown conversion, parsing) every time. An edited campaign or template
+// has a new revision and is compiled afresh.
+type campTplCache struct {
+	tpls map[string]campTpl
+	sync.RWMutex
+}
+
+// CompileCampaign compiles a campaign's templates, or loads them from the cache
+// if the campaign's current revision has already been compiled. If the
+// campaign's body has no dynamic template expressions, it's rendered once
+// into Campaign.StaticBody which is then used for every subscriber.
+func (m *Manager) CompileCampaign(c *models.Campaign) error {
+	rev := campRevision(c)
+
+	m.campTpls.RLock()
+	t, ok := m.campTpls.tpls[rev]
+	m.campTpls.RUnlock()
+
+	if ok {
+		c.Tpl = t.c.Tpl
+		c.SubjectTpl = t.c.SubjectTpl
+		c.PreheaderTpl = t.c.PreheaderTpl
+		c.AltBodyTpl = t.c.AltBodyTpl
+		c.StaticBody = t.c.StaticBody
+		c.AttachmentURLTpls = t.c.AttachmentURLTpls
+		c.Variants = t.c.Variants
+		return nil
+	}
+
+	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+		return err
+	}
+
+	// Render static bodies once.
+	c.StaticBody = nil
+	if isStaticTpl(c.Tpl) {
+		var b bytes.Buffer
+		if err := c.Tpl.ExecuteTemplate(&b, models.BaseTpl, nil); err == nil {
+			c.StaticBody = b.Bytes()
+			if m.cfg.DarkModeMeta && c.ContentType != models.CampaignContentTypePlain {
+				c.StaticBody = InjectDarkModeMeta(c.StaticBody)
+			}
+		}
+	}
+
+	m.campTpls.Lock()
+	if len(m.campTpls.tpls) >= maxCampTpls {
+		m.campTpls.tpls = make(map[string]campTpl)
+	}
+	m.campTpls.tpls[rev] = campTpl{
+		campID: c.ID,
+		c: models.Campaign{
+			Tpl:          c.Tpl,
+			SubjectTpl:   c.SubjectTpl,
+			PreheaderTpl: c.PreheaderTpl,
+			AltBodyTpl:   c.AltBodyTpl,
+			StaticBody:   c.StaticBody,
+
+			AttachmentURLTpls: c.AttachmentURLTpls,
+			Variants:          c.Variants,
+		},
+	}
+	m.campTpls.Unlock()
+
+	return nil
+}
+
+// DeleteCampaignTpls removes all cached template revisions of a campaign.
+// This is called when a campaign is updated or deleted.
+func (m *Manager) DeleteCampaignTpls(campID int) {
+	m.campTpls.Lock()
+	for rev, t := range m.campTpls.tpls {
+		if t.campID == campID {
+			delete(m.campTpls.tpls, rev)
+		}
+	}
+	m.campTpls.Unlock()
+}
+
+// campRevision returns a hash of all the fields of a campaign that
+// go into its compiled templates.
+func campRevision(c *models.Campaign) string {
+	h := fnv.New128a()
+	for _, s := range []string{c.UUID, c.Subject, c.Preheader, c.ContentType, c.Body, c.AltBody.String, c.TemplateBody} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	for _, s := range c.AttachmentURLs {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	for _, v := range c.Variants {
+		for _, s := range []string{v.Lang, v.Subject, v.Body} {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isStaticTpl checks whether a template (and its associated templates) is
+// made up of only static text and template inclusions and thus renders the
+// same output for every subscriber.
+func isStaticTpl(t *template.Template) bool {
+	if t == nil {
+		return false
+	}
+
+	for _, tp := range t.Templates() {
+		if tp.Tree == nil || tp.Tree.Root == nil {
+			continue
+		}
+		for _, n := range tp.Tree.Root.Nodes {
+			switch n.(type) {
+			case *parse.TextNode, *parse.TemplateNode:
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
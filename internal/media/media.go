@@ -7,6 +7,14 @@ import (
 	"gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// TypeMedia is media (images, attachments etc.) that's publicly linked to.
+	TypeMedia = "media"
+
+	// TypeFile is a file that's downloaded with signed, expiring URLs.
+	TypeFile = "file"
+)
+
 // Media represents an uploaded object.
 type Media struct {
 	ID          int         `db:"id" json:"id"`
@@ -18,6 +26,8 @@ type Media struct {
 	ThumbURL    null.String `json:"thumb_url"`
 	Provider    string      `json:"provider"`
 	Meta        models.JSON `db:"meta" json:"meta"`
+	Type        string      `db:"type" json:"type"`
+	Downloads   int         `db:"downloads" json:"downloads"`
 	URL         string      `json:"url"`
 
 	Total int `db:"total" json:"-"`
@@ -0,0 +1,65 @@
+// Package devnull is a messenger that discards messages instead of delivering
+// them. Campaigns sent with it run through the entire pipeline (rendering,
+// batching, rate limiting, stats) for load tests and in staging environments.
+package devnull
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Name is the name of the messenger.
+const Name = "devnull"
+
+// ErrSimulated is returned by the pushes that fail as per Options.ErrorRate.
+var ErrSimulated = errors.New("devnull: simulated delivery error")
+
+// Options are the options for simulating a messenger's behaviour.
+type Options struct {
+	// Latency is the time taken by every push.
+	Latency time.Duration
+
+	// ErrorRate is the fraction (0-1) of pushes that fail.
+	ErrorRate float64
+}
+
+// Devnull is a messenger that discards messages.
+type Devnull struct {
+	o Options
+}
+
+// New returns a new instance of the devnull messenger.
+func New(o Options) *Devnull {
+	return &Devnull{o: o}
+}
+
+// Name returns the messenger's name.
+func (d *Devnull) Name() string {
+	return Name
+}
+
+// Push discards a message after the simulated latency.
+func (d *Devnull) Push(m models.Message) error {
+	if d.o.Latency > 0 {
+		time.Sleep(d.o.Latency)
+	}
+
+	if d.o.ErrorRate > 0 && rand.Float64() < d.o.ErrorRate {
+		return ErrSimulated
+	}
+
+	return nil
+}
+
+// Flush is a no-op.
+func (d *Devnull) Flush() error {
+	return nil
+}
+
+// Close is a no-op.
+func (d *Devnull) Close() error {
+	return nil
+}
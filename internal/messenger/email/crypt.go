@@ -0,0 +1,301 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/mailcrypt"
+	"github.com/knadh/smtppool"
+)
+
+const secureDialTimeout = time.Second * 10
+
+// Crypt has the options for signing and encrypting outgoing e-mails.
+type Crypt struct {
+	// Signer, if set, S/MIME signs all outgoing e-mails.
+	Signer *mailcrypt.Signer
+
+	// Encrypt encrypts e-mails to subscribers who have public keys.
+	Encrypt bool
+}
+
+// SetCrypt sets the signing and encryption options on the messenger.
+func (e *Emailer) SetCrypt(c Crypt) {
+	e.crypt = c
+}
+
+// pushSecure builds the MIME message, signs and/or encrypts it, and sends it.
+// smtppool only sends the messages that it builds itself, so secure messages
+// are sent over a separate connection to the server.
+func (e *Emailer) pushSecure(srv *Server, em smtppool.Email, key *mailcrypt.Key) error {
+	sender, rcpt, msg, err := e.makeMessage(em, key)
+	if err != nil {
+		return err
+	}
+
+	return sendRaw(srv, sender, rcpt, msg)
+}
+
+// makeMessage builds the full message, signed and/or encrypted if configured,
+// and returns it with its envelope sender and recipients.
+func (e *Emailer) makeMessage(em smtppool.Email, key *mailcrypt.Key) (string, []string, []byte, error) {
+	entity, err := makeEntity(em)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if e.crypt.Signer != nil {
+		if entity, err = e.crypt.Signer.Sign(entity); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	if key != nil {
+		if entity, err = key.Encrypt(entity); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	from, err := mail.ParseAddress(em.From)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid from address '%s': %v", em.From, err)
+	}
+
+	// Headers.
+	var b bytes.Buffer
+	writeHeader(&b, "From", em.From)
+	writeHeader(&b, "To", strings.Join(em.To, ", "))
+	if len(em.Cc) > 0 {
+		writeHeader(&b, "Cc", strings.Join(em.Cc, ", "))
+	}
+	writeHeader(&b, "Subject", mime.QEncoding.Encode("utf-8", em.Subject))
+	writeHeader(&b, "Date", time.Now().Format(time.RFC1123Z))
+	if id := em.Headers.Get("Message-Id"); id != "" {
+		writeHeader(&b, "Message-Id", id)
+	} else {
+		writeHeader(&b, "Message-Id", makeMessageID(from.Address))
+	}
+	writeHeader(&b, "MIME-Version", "1.0")
+	for k, v := range em.Headers {
+		switch strings.ToLower(k) {
+		case "content-type", "content-transfer-encoding", "mime-version", "subject", "from", "to", "cc", "message-id":
+			continue
+		}
+		writeHeader(&b, k, v[0])
+	}
+	b.Write(entity)
+
+	// Envelope.
+	sender := from.Address
+	if em.Sender != "" {
+		if a, err := mail.ParseAddress(em.Sender); err == nil {
+			sender = a.Address
+		}
+	}
+
+	var rcpt []string
+	for _, l := range [][]string{em.To, em.Cc, em.Bcc} {
+		for _, r := range l {
+			a, err := mail.ParseAddress(r)
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("invalid recipient address '%s': %v", r, err)
+			}
+			rcpt = append(rcpt, a.Address)
+		}
+	}
+
+	return sender, rcpt, b.Bytes(), nil
+}
+
+// makeEntity builds the MIME entity (Content-* headers and body) of an e-mail.
+func makeEntity(em smtppool.Email) ([]byte, error) {
+	var body []byte
+	switch {
+	case len(em.HTML) > 0 && len(em.Text) > 0:
+		bound, err := makeBoundary()
+		if err != nil {
+			return nil, err
+		}
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=\"%s\"\r\n\r\n", bound)
+		fmt.Fprintf(&b, "--%s\r\n", bound)
+		writeTextPart(&b, "text/plain", em.Text)
+		fmt.Fprintf(&b, "--%s\r\n", bound)
+		writeTextPart(&b, "text/html", em.HTML)
+		fmt.Fprintf(&b, "--%s--\r\n", bound)
+		body = b.Bytes()
+
+	case len(em.HTML) > 0:
+		var b bytes.Buffer
+		writeTextPart(&b, "text/html", em.HTML)
+		body = b.Bytes()
+
+	default:
+		var b bytes.Buffer
+		writeTextPart(&b, "text/plain", em.Text)
+		body = b.Bytes()
+	}
+
+	if len(em.Attachments) == 0 {
+		return body, nil
+	}
+
+	bound, err := makeBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", bound)
+	fmt.Fprintf(&b, "--%s\r\n", bound)
+	b.Write(body)
+	for _, a := range em.Attachments {
+		ctype := a.Header.Get("Content-Type")
+		if ctype == "" {
+			if ctype = mime.TypeByExtension(filepath.Ext(a.Filename)); ctype == "" {
+				ctype = "application/octet-stream"
+			}
+		}
+		name := mime.QEncoding.Encode("utf-8", a.Filename)
+
+		fmt.Fprintf(&b, "--%s\r\n", bound)
+		writeHeader(&b, "Content-Type", fmt.Sprintf("%s; name=\"%s\"", ctype, name))
+		writeHeader(&b, "Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+		writeHeader(&b, "Content-Transfer-Encoding", "base64")
+		b.WriteString("\r\n")
+
+		s := base64.StdEncoding.EncodeToString(a.Content)
+		for len(s) > 76 {
+			b.WriteString(s[:76] + "\r\n")
+			s = s[76:]
+		}
+		b.WriteString(s + "\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", bound)
+
+	return b.Bytes(), nil
+}
+
+// writeTextPart writes a quoted-printable encoded text part. Quoted-printable
+// keeps the part 7-bit safe, which signatures need to survive transit.
+func writeTextPart(b *bytes.Buffer, ctype string, body []byte) {
+	writeHeader(b, "Content-Type", ctype+"; charset=UTF-8")
+	writeHeader(b, "Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	// The writer converts line breaks to CRLF.
+	w := quotedprintable.NewWriter(b)
+	w.Write(body)
+	w.Close()
+	b.WriteString("\r\n")
+}
+
+func writeHeader(b *bytes.Buffer, key, val string) {
+	b.WriteString(key)
+	b.WriteString(": ")
+	b.WriteString(val)
+	b.WriteString("\r\n")
+}
+
+func makeBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "=_" + hex.EncodeToString(b), nil
+}
+
+func makeMessageID(from string) string {
+	host := "localhost"
+	if i := strings.LastIndex(from, "@"); i > -1 {
+		host = from[i+1:]
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), host)
+}
+
+// sendRaw sends a pre-built message over a new connection to the server.
+func sendRaw(srv *Server, from string, to []string, msg []byte) error {
+	addr := net.JoinHostPort(srv.Host, fmt.Sprintf("%d", srv.Port))
+	dialer := &net.Dialer{Timeout: secureDialTimeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if srv.SSL {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, srv.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	cl, err := smtp.NewClient(conn, srv.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cl.Close()
+
+	if srv.HelloHostname != "" {
+		if err := cl.Hello(srv.HelloHostname); err != nil {
+			return err
+		}
+	}
+
+	// STARTTLS.
+	if !srv.SSL && srv.TLSConfig != nil {
+		if ok, _ := cl.Extension("STARTTLS"); ok {
+			if err := cl.StartTLS(srv.TLSConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	if srv.Auth != nil {
+		if ok, _ := cl.Extension("AUTH"); ok {
+			if err := cl.Auth(srv.Auth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := cl.Mail(from); err != nil {
+		return err
+	}
+	for _, r := range to {
+		if err := cl.Rcpt(r); err != nil {
+			return err
+		}
+	}
+
+	w, err := cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return cl.Quit()
+}
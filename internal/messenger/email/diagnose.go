@@ -0,0 +1,268 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const diagDialTimeout = time.Second * 10
+
+// Extensions that are checked for and reported in a diagnostic.
+var diagExtensions = []string{"STARTTLS", "AUTH", "SIZE", "8BITMIME", "SMTPUTF8",
+	"PIPELINING", "CHUNKING", "ENHANCEDSTATUSCODES", "DSN", "REQUIRETLS"}
+
+// Diagnostic is the result of a step by step connection test of an SMTP server.
+type Diagnostic struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// OK is true if all steps succeeded.
+	OK    bool             `json:"ok"`
+	Steps []DiagnosticStep `json:"steps"`
+
+	// Extensions advertised by the server in response to EHLO with their
+	// parameters, eg: "AUTH PLAIN LOGIN".
+	Extensions []string `json:"extensions"`
+	TLSVersion string   `json:"tls_version"`
+	TLSCipher  string   `json:"tls_cipher"`
+
+	// Total time taken by all the steps in milliseconds.
+	Latency int64  `json:"latency_ms"`
+	Error   string `json:"error"`
+}
+
+// DiagnosticStep is one step of an SMTP diagnostic (connect, ehlo, starttls, auth, send, quit).
+type DiagnosticStep struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Duration int64  `json:"duration_ms"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Diagnose connects to an SMTP server and runs through EHLO, STARTTLS, and
+// authentication, recording the outcome and latency of every step. If m has
+// recipients, m is also sent. The diagnostic stops at the first failed step.
+func Diagnose(srv Server, m models.Message) Diagnostic {
+	out := Diagnostic{
+		Host:       srv.Host,
+		Port:       srv.Port,
+		Steps:      []DiagnosticStep{},
+		Extensions: []string{},
+	}
+
+	start := time.Now()
+	defer func() {
+		out.Latency = time.Since(start).Milliseconds()
+		out.OK = out.Error == ""
+	}()
+
+	// step runs a step and records it. It returns false if the step failed.
+	step := func(name string, fn func() (string, error)) bool {
+		t := time.Now()
+		msg, err := fn()
+
+		s := DiagnosticStep{Name: name, OK: err == nil, Duration: time.Since(t).Milliseconds(), Message: msg}
+		if err != nil {
+			s.Error = err.Error()
+			out.Error = fmt.Sprintf("%s: %v", name, err)
+		}
+		out.Steps = append(out.Steps, s)
+
+		return err == nil
+	}
+
+	if err := srv.setup(); err != nil {
+		out.Error = err.Error()
+		return out
+	}
+
+	// Connect.
+	var (
+		conn net.Conn
+		cl   *smtp.Client
+	)
+	if !step("connect", func() (string, error) {
+		var (
+			addr   = net.JoinHostPort(srv.Host, fmt.Sprintf("%d", srv.Port))
+			dialer = &net.Dialer{Timeout: diagDialTimeout}
+			err    error
+		)
+		if srv.SSL {
+			conn, err = tls.DialWithDialer(dialer, "tcp", addr, srv.TLSConfig)
+		} else {
+			conn, err = dialer.Dial("tcp", addr)
+		}
+		if err != nil {
+			return "", err
+		}
+		conn.SetDeadline(time.Now().Add(diagDialTimeout * 3))
+
+		if c, ok := conn.(*tls.Conn); ok {
+			out.setTLS(c.ConnectionState())
+		}
+
+		// Read the greeting.
+		cl, err = smtp.NewClient(conn, srv.Host)
+		if err != nil {
+			conn.Close()
+			return "", err
+		}
+
+		return conn.RemoteAddr().String(), nil
+	}) {
+		return out
+	}
+	defer cl.Close()
+
+	// EHLO.
+	if !step("ehlo", func() (string, error) {
+		host := srv.HelloHostname
+		if host == "" {
+			host = "localhost"
+		}
+		if err := cl.Hello(host); err != nil {
+			return "", err
+		}
+
+		out.readExtensions(cl)
+		return host, nil
+	}) {
+		return out
+	}
+
+	// STARTTLS.
+	if !srv.SSL && srv.TLSConfig != nil {
+		if !step("starttls", func() (string, error) {
+			if ok, _ := cl.Extension("STARTTLS"); !ok {
+				return "", fmt.Errorf("server doesn't support STARTTLS")
+			}
+			if err := cl.StartTLS(srv.TLSConfig); err != nil {
+				return "", err
+			}
+
+			if st, ok := cl.TLSConnectionState(); ok {
+				out.setTLS(st)
+			}
+
+			// Extensions (eg: AUTH) may change after STARTTLS.
+			out.readExtensions(cl)
+			return out.TLSVersion, nil
+		}) {
+			return out
+		}
+	}
+
+	// Auth.
+	if srv.Auth != nil {
+		if !step("auth", func() (string, error) {
+			if ok, _ := cl.Extension("AUTH"); !ok {
+				return "", fmt.Errorf("server doesn't support AUTH")
+			}
+			if err := cl.Auth(srv.Auth); err != nil {
+				return "", err
+			}
+			return srv.AuthProtocol, nil
+		}) {
+			return out
+		}
+	}
+
+	// Optionally, send a message.
+	if len(m.To) > 0 {
+		if !step("send", func() (string, error) {
+			from := m.From
+			if a, err := mail.ParseAddress(m.From); err == nil {
+				from = a.Address
+			}
+
+			if err := cl.Mail(from); err != nil {
+				return "", err
+			}
+			for _, r := range m.To {
+				if err := cl.Rcpt(r); err != nil {
+					return "", err
+				}
+			}
+
+			w, err := cl.Data()
+			if err != nil {
+				return "", err
+			}
+			if _, err := w.Write(makeDiagMessage(m)); err != nil {
+				return "", err
+			}
+			if err := w.Close(); err != nil {
+				return "", err
+			}
+
+			return strings.Join(m.To, ", "), nil
+		}) {
+			return out
+		}
+	}
+
+	step("quit", func() (string, error) {
+		return "", cl.Quit()
+	})
+
+	return out
+}
+
+// readExtensions records the extensions that the server advertises.
+func (d *Diagnostic) readExtensions(cl *smtp.Client) {
+	d.Extensions = d.Extensions[:0]
+	for _, e := range diagExtensions {
+		if ok, param := cl.Extension(e); ok {
+			d.Extensions = append(d.Extensions, strings.TrimSpace(e+" "+param))
+		}
+	}
+}
+
+// setTLS records the TLS version and cipher suite of a connection.
+func (d *Diagnostic) setTLS(st tls.ConnectionState) {
+	switch st.Version {
+	case tls.VersionTLS10:
+		d.TLSVersion = "TLS 1.0"
+	case tls.VersionTLS11:
+		d.TLSVersion = "TLS 1.1"
+	case tls.VersionTLS12:
+		d.TLSVersion = "TLS 1.2"
+	case tls.VersionTLS13:
+		d.TLSVersion = "TLS 1.3"
+	default:
+		d.TLSVersion = fmt.Sprintf("0x%04X", st.Version)
+	}
+	d.TLSCipher = tls.CipherSuiteName(st.CipherSuite)
+}
+
+// makeDiagMessage builds a minimal single part message for a diagnostic send.
+func makeDiagMessage(m models.Message) []byte {
+	var b bytes.Buffer
+
+	ctype := "text/html"
+	if m.ContentType == models.CampaignContentTypePlain {
+		ctype = "text/plain"
+	}
+
+	writeHeader(&b, "From", m.From)
+	writeHeader(&b, "To", strings.Join(m.To, ", "))
+	writeHeader(&b, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&b, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&b, "Message-Id", makeMessageID(m.From))
+	writeHeader(&b, "MIME-Version", "1.0")
+	writeHeader(&b, "Content-Type", ctype+"; charset=UTF-8")
+	b.WriteString("\r\n")
+	b.Write(m.Body)
+
+	return b.Bytes()
+}
@@ -6,8 +6,10 @@ import (
 	"math/rand"
 	"net/smtp"
 	"net/textproto"
+	"strconv"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/mailcrypt"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/smtppool"
 )
@@ -38,6 +40,11 @@ type Server struct {
 // Emailer is the SMTP e-mail messenger.
 type Emailer struct {
 	servers []*Server
+	crypt   Crypt
+
+	// mx, if set, delivers e-mails directly to the recipients' mail servers
+	// instead of via the SMTP servers.
+	mx *mxDeliverer
 }
 
 // New returns an SMTP e-mail Messenger backend with the given SMTP servers.
@@ -48,33 +55,8 @@ func New(servers ...Server) (*Emailer, error) {
 
 	for _, srv := range servers {
 		s := srv
-		var auth smtp.Auth
-		switch s.AuthProtocol {
-		case "cram":
-			auth = smtp.CRAMMD5Auth(s.Username, s.Password)
-		case "plain":
-			auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
-		case "login":
-			auth = &smtppool.LoginAuth{Username: s.Username, Password: s.Password}
-		case "", "none":
-		default:
-			return nil, fmt.Errorf("unknown SMTP auth type '%s'", s.AuthProtocol)
-		}
-		s.Opt.Auth = auth
-
-		// TLS config.
-		if s.TLSType != "none" {
-			s.TLSConfig = &tls.Config{}
-			if s.TLSSkipVerify {
-				s.TLSConfig.InsecureSkipVerify = s.TLSSkipVerify
-			} else {
-				s.TLSConfig.ServerName = s.Host
-			}
-
-			// SSL/TLS, not STARTTLS.
-			if s.TLSType == "TLS" {
-				s.Opt.SSL = true
-			}
+		if err := s.setup(); err != nil {
+			return nil, err
 		}
 
 		pool, err := smtppool.New(s.Opt)
@@ -89,6 +71,55 @@ func New(servers ...Server) (*Emailer, error) {
 	return e, nil
 }
 
+// NewMX returns an e-mail Messenger backend that delivers e-mails directly
+// to the recipients' mail (MX) servers.
+func NewMX(o MXOpt) *Emailer {
+	return &Emailer{mx: newMXDeliverer(o)}
+}
+
+// MXStats returns the per-domain delivery stats of direct MX delivery. It
+// returns nil if the messenger delivers via SMTP servers.
+func (e *Emailer) MXStats() []MXDomainStats {
+	if e.mx == nil {
+		return nil
+	}
+	return e.mx.domainStats()
+}
+
+// setup configures the server's auth and TLS options.
+func (s *Server) setup() error {
+	var auth smtp.Auth
+	switch s.AuthProtocol {
+	case "cram":
+		auth = smtp.CRAMMD5Auth(s.Username, s.Password)
+	case "plain":
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	case "login":
+		auth = &smtppool.LoginAuth{Username: s.Username, Password: s.Password}
+	case "", "none":
+	default:
+		return fmt.Errorf("unknown SMTP auth type '%s'", s.AuthProtocol)
+	}
+	s.Opt.Auth = auth
+
+	// TLS config.
+	if s.TLSType != "none" {
+		s.TLSConfig = &tls.Config{}
+		if s.TLSSkipVerify {
+			s.TLSConfig.InsecureSkipVerify = s.TLSSkipVerify
+		} else {
+			s.TLSConfig.ServerName = s.Host
+		}
+
+		// SSL/TLS, not STARTTLS.
+		if s.TLSType == "TLS" {
+			s.Opt.SSL = true
+		}
+	}
+
+	return nil
+}
+
 // Name returns the Server's name.
 func (e *Emailer) Name() string {
 	return emName
@@ -104,7 +135,7 @@ func (e *Emailer) Push(m models.Message) error {
 	)
 	if ln > 1 {
 		srv = e.servers[rand.Intn(ln)]
-	} else {
+	} else if ln == 1 {
 		srv = e.servers[0]
 	}
 
@@ -132,9 +163,14 @@ func (e *Emailer) Push(m models.Message) error {
 
 	em.Headers = textproto.MIMEHeader{}
 
-	// Attach SMTP level headers.
-	for k, v := range srv.EmailHeaders {
-		em.Headers.Set(k, v)
+	// Attach SMTP level headers. Their values can have placeholders for the
+	// message's identifiers, eg: provider specific reputation and tracking headers.
+	if srv != nil {
+		for k, v := range srv.EmailHeaders {
+			if v = headerValue(v, m); v != "" {
+				em.Headers.Set(k, v)
+			}
+		}
 	}
 
 	// Attach e-mail level headers.
@@ -175,9 +211,47 @@ func (e *Emailer) Push(m models.Message) error {
 		}
 	}
 
+	// Sign and/or encrypt the message.
+	var key *mailcrypt.Key
+	if e.crypt.Encrypt && m.Subscriber.PublicKey != "" {
+		k, err := mailcrypt.ParseKey(m.Subscriber.PublicKey)
+		if err != nil {
+			return fmt.Errorf("error reading public key of %s: %v", m.Subscriber.Email, err)
+		}
+		key = k
+	}
+	if e.mx != nil {
+		return e.pushMX(em, key)
+	}
+	if key != nil || e.crypt.Signer != nil {
+		return e.pushSecure(srv, em, key)
+	}
+
 	return srv.pool.Send(em)
 }
 
+// headerValue replaces the placeholders in a header value with the identifiers
+// of the message's campaign and subscriber. Placeholders that don't apply to the
+// message, eg: campaign identifiers on transactional messages, are empty.
+func headerValue(v string, m models.Message) string {
+	if !strings.Contains(v, "{") {
+		return v
+	}
+
+	var campID, campUUID, campType string
+	if m.Campaign != nil {
+		campID, campUUID, campType = strconv.Itoa(m.Campaign.ID), m.Campaign.UUID, m.Campaign.Type
+	}
+
+	return strings.TrimSpace(strings.NewReplacer(
+		"{campaign_id}", campID,
+		"{campaign_uuid}", campUUID,
+		"{campaign_type}", campType,
+		"{subscriber_uuid}", m.Subscriber.UUID,
+		"{feedback_id}", m.Headers.Get(models.EmailHeaderFeedbackID),
+	).Replace(v))
+}
+
 // Flush flushes the message queue to the server.
 func (e *Emailer) Flush() error {
 	return nil
@@ -188,5 +262,8 @@ func (e *Emailer) Close() error {
 	for _, s := range e.servers {
 		s.pool.Close()
 	}
+	if e.mx != nil {
+		e.mx.close()
+	}
 	return nil
 }
@@ -0,0 +1,563 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/mailcrypt"
+	"github.com/knadh/smtppool"
+)
+
+const (
+	mxPort        = 25
+	mxDialTimeout = time.Second * 30
+	mxSendTimeout = time.Minute * 5
+	mxLookupTTL   = time.Minute * 10
+
+	// Maximum number of deferred messages held in the retry queue. Messages
+	// that are deferred when the queue is full are failed.
+	mxMaxQueue = 10000
+)
+
+var (
+	// errNullMX is returned for domains that publish a null MX (RFC 7505),
+	// that is, they don't accept e-mail.
+	errNullMX = errors.New("domain doesn't accept e-mail (null MX)")
+
+	errNoTLS = errors.New("server doesn't support STARTTLS")
+)
+
+// MXOpt has the options for delivering e-mails directly to the recipients'
+// mail (MX) servers instead of via an SMTP relay.
+type MXOpt struct {
+	// Hostname sent in EHLO. Defaults to the system's hostname. It should
+	// match the reverse DNS of the sending IP.
+	HelloHostname string `json:"hello_hostname"`
+
+	// RequireTLS fails deliveries to servers that don't support STARTTLS
+	// and verifies their certificates. By default, STARTTLS is used whenever
+	// it's offered without verifying the certificates (opportunistic TLS).
+	RequireTLS bool `json:"require_tls"`
+
+	// Maximum number of concurrent connections to a recipient domain.
+	MaxConns int `json:"max_conns"`
+
+	// Time after which idle connections to the mail servers are closed.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+
+	// Number of times a temporarily failed (4xx, network error) delivery is
+	// retried in the background, RetryInterval apart (doubling every attempt).
+	MaxRetries    int           `json:"max_retries"`
+	RetryInterval time.Duration `json:"retry_interval"`
+}
+
+// MXDomainStats has the delivery counts and the last error of a recipient domain.
+type MXDomainStats struct {
+	Domain      string     `json:"domain"`
+	Sent        int        `json:"sent"`
+	Deferred    int        `json:"deferred"`
+	Failed      int        `json:"failed"`
+	LastError   string     `json:"last_error"`
+	LastErrorAt *time.Time `json:"last_error_at"`
+}
+
+// mxMsg is a message to the recipients in one domain.
+type mxMsg struct {
+	domain string
+	from   string
+	rcpt   []string
+	body   []byte
+
+	attempts int
+	next     time.Time
+}
+
+// mxConn is a cached connection to a mail server.
+type mxConn struct {
+	host string
+	conn net.Conn
+	cl   *smtp.Client
+	used time.Time
+}
+
+// mxHosts are the looked up mail servers of a domain.
+type mxHosts struct {
+	hosts []string
+	err   error
+	exp   time.Time
+}
+
+// mxDeliverer delivers messages directly to the recipient domains' mail
+// servers with per-domain connection caching and a retry queue for
+// temporary failures.
+type mxDeliverer struct {
+	opt   MXOpt
+	hello string
+
+	mut   sync.Mutex
+	mxs   map[string]mxHosts
+	idle  map[string][]*mxConn
+	slots map[string]chan struct{}
+	stats map[string]*MXDomainStats
+	queue []*mxMsg
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newMXDeliverer(o MXOpt) *mxDeliverer {
+	if o.MaxConns < 1 {
+		o.MaxConns = 2
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = time.Second * 15
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Minute * 5
+	}
+
+	hello := o.HelloHostname
+	if hello == "" {
+		if h, err := os.Hostname(); err == nil {
+			hello = h
+		} else {
+			hello = "localhost"
+		}
+	}
+
+	d := &mxDeliverer{
+		opt:   o,
+		hello: hello,
+		mxs:   make(map[string]mxHosts),
+		idle:  make(map[string][]*mxConn),
+		slots: make(map[string]chan struct{}),
+		stats: make(map[string]*MXDomainStats),
+		quit:  make(chan struct{}),
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d
+}
+
+// deliver delivers a message to its domain's mail servers. Temporary failures
+// are queued for retries and nil is returned. Permanent failures, and temporary
+// failures that can't be retried anymore, return the error.
+func (d *mxDeliverer) deliver(m *mxMsg) error {
+	err := d.send(m)
+	if err == nil {
+		d.record(m.domain, func(s *MXDomainStats) { s.Sent++ })
+		return nil
+	}
+
+	if isTempErr(err) && m.attempts < d.opt.MaxRetries {
+		d.mut.Lock()
+		ok := len(d.queue) < mxMaxQueue
+		if ok {
+			m.next = time.Now().Add(d.opt.RetryInterval * time.Duration(1<<m.attempts))
+			m.attempts++
+			d.queue = append(d.queue, m)
+		}
+		d.mut.Unlock()
+
+		if ok {
+			d.recordErr(m.domain, err, func(s *MXDomainStats) { s.Deferred++ })
+			return nil
+		}
+	}
+
+	d.recordErr(m.domain, err, func(s *MXDomainStats) { s.Failed++ })
+	return fmt.Errorf("error delivering to %s: %v", m.domain, err)
+}
+
+// send sends a message to the first of its domain's mail servers (in the order of
+// MX preference) that accepts it or rejects it permanently.
+func (d *mxDeliverer) send(m *mxMsg) error {
+	hosts, err := d.lookup(m.domain)
+	if err != nil {
+		return err
+	}
+
+	// Limit the concurrent connections to the domain.
+	slot := d.slot(m.domain)
+	slot <- struct{}{}
+	defer func() { <-slot }()
+
+	for _, h := range hosts {
+		if err = d.sendHost(m, h); err == nil || !isTempErr(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// sendHost sends a message over a cached or a new connection to a mail server.
+func (d *mxDeliverer) sendHost(m *mxMsg, host string) error {
+	c, reused := d.getConn(m.domain, host), true
+	if c == nil {
+		var err error
+		if c, err = d.dial(host); err != nil {
+			return err
+		}
+		reused = false
+	}
+
+	err := c.send(m)
+
+	// The server may have closed a cached connection in the meantime.
+	// Retry once on a new connection.
+	if err != nil && reused && !isSMTPErr(err) {
+		c.close()
+		if c, err = d.dial(host); err != nil {
+			return err
+		}
+		err = c.send(m)
+	}
+
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	d.putConn(m.domain, c)
+	return nil
+}
+
+// dial connects to a mail server and says hello, upgrading the connection
+// with STARTTLS if the server supports it.
+func (d *mxDeliverer) dial(host string) (*mxConn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", mxPort)), mxDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(mxDialTimeout))
+
+	cl, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &mxConn{host: host, conn: conn, cl: cl}
+	if err := cl.Hello(d.hello); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	if ok, _ := cl.Extension("STARTTLS"); ok {
+		cfg := &tls.Config{ServerName: host, InsecureSkipVerify: !d.opt.RequireTLS}
+		if err := cl.StartTLS(cfg); err != nil {
+			c.close()
+			return nil, err
+		}
+	} else if d.opt.RequireTLS {
+		c.close()
+		return nil, errNoTLS
+	}
+
+	return c, nil
+}
+
+// send sends a message in an SMTP transaction on the connection.
+func (c *mxConn) send(m *mxMsg) error {
+	c.conn.SetDeadline(time.Now().Add(mxSendTimeout))
+
+	if err := c.cl.Mail(m.from); err != nil {
+		return err
+	}
+	for _, r := range m.rcpt {
+		if err := c.cl.Rcpt(r); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	c.used = time.Now()
+	return nil
+}
+
+func (c *mxConn) close() {
+	c.conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if err := c.cl.Quit(); err != nil {
+		c.cl.Close()
+	}
+}
+
+// lookup returns the mail servers of a domain in the order of preference.
+// A domain without MX records is its own mail server (implicit MX).
+func (d *mxDeliverer) lookup(domain string) ([]string, error) {
+	d.mut.Lock()
+	r, ok := d.mxs[domain]
+	d.mut.Unlock()
+	if ok && time.Now().Before(r.exp) {
+		return r.hosts, r.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mxDialTimeout)
+	defer cancel()
+
+	r = mxHosts{exp: time.Now().Add(mxLookupTTL)}
+	mxs, err := net.DefaultResolver.LookupMX(ctx, domain)
+	switch {
+	case err != nil:
+		var dErr *net.DNSError
+		if errors.As(err, &dErr) && dErr.IsNotFound {
+			r.hosts = []string{domain}
+		} else {
+			// Resolution errors aren't cached.
+			return nil, err
+		}
+	case len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == ""):
+		r.err = errNullMX
+	default:
+		sort.SliceStable(mxs, func(i, j int) bool { return mxs[i].Pref < mxs[j].Pref })
+		for _, mx := range mxs {
+			r.hosts = append(r.hosts, strings.TrimSuffix(mx.Host, "."))
+		}
+	}
+
+	d.mut.Lock()
+	d.mxs[domain] = r
+	d.mut.Unlock()
+
+	return r.hosts, r.err
+}
+
+// slot returns the channel that limits the concurrent connections to a domain.
+func (d *mxDeliverer) slot(domain string) chan struct{} {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	s, ok := d.slots[domain]
+	if !ok {
+		s = make(chan struct{}, d.opt.MaxConns)
+		d.slots[domain] = s
+	}
+	return s
+}
+
+// getConn returns a cached connection to a domain's mail server, if there's one.
+func (d *mxDeliverer) getConn(domain, host string) *mxConn {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	conns := d.idle[domain]
+	for i, c := range conns {
+		if c.host == host {
+			d.idle[domain] = append(conns[:i], conns[i+1:]...)
+			return c
+		}
+	}
+	return nil
+}
+
+// putConn caches a connection to a domain's mail server for reuse.
+func (d *mxDeliverer) putConn(domain string, c *mxConn) {
+	d.mut.Lock()
+	d.idle[domain] = append(d.idle[domain], c)
+	d.mut.Unlock()
+}
+
+// run periodically closes idle connections and retries the deferred messages
+// that are due.
+func (d *mxDeliverer) run() {
+	defer d.wg.Done()
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-d.quit:
+			return
+
+		case now := <-t.C:
+			var (
+				stale []*mxConn
+				due   []*mxMsg
+			)
+
+			d.mut.Lock()
+			for dom, conns := range d.idle {
+				n := 0
+				for _, c := range conns {
+					if now.Sub(c.used) > d.opt.IdleTimeout {
+						stale = append(stale, c)
+					} else {
+						conns[n] = c
+						n++
+					}
+				}
+				if n == 0 {
+					delete(d.idle, dom)
+				} else {
+					d.idle[dom] = conns[:n]
+				}
+			}
+
+			n := 0
+			for _, m := range d.queue {
+				if now.After(m.next) {
+					due = append(due, m)
+				} else {
+					d.queue[n] = m
+					n++
+				}
+			}
+			d.queue = d.queue[:n]
+			d.mut.Unlock()
+
+			for _, c := range stale {
+				c.close()
+			}
+
+			for _, m := range due {
+				d.record(m.domain, func(s *MXDomainStats) { s.Deferred-- })
+				go d.deliver(m)
+			}
+		}
+	}
+}
+
+// record updates the delivery stats of a domain.
+func (d *mxDeliverer) record(domain string, fn func(s *MXDomainStats)) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	s, ok := d.stats[domain]
+	if !ok {
+		s = &MXDomainStats{Domain: domain}
+		d.stats[domain] = s
+	}
+	fn(s)
+}
+
+// recordErr updates the delivery stats of a domain with an error.
+func (d *mxDeliverer) recordErr(domain string, err error, fn func(s *MXDomainStats)) {
+	now := time.Now()
+	d.record(domain, func(s *MXDomainStats) {
+		s.LastError = err.Error()
+		s.LastErrorAt = &now
+		fn(s)
+	})
+}
+
+// domainStats returns the delivery stats of the recipient domains with
+// the most failed and deferred deliveries first.
+func (d *mxDeliverer) domainStats() []MXDomainStats {
+	d.mut.Lock()
+	out := make([]MXDomainStats, 0, len(d.stats))
+	for _, s := range d.stats {
+		out = append(out, *s)
+	}
+	d.mut.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Failed != out[j].Failed {
+			return out[i].Failed > out[j].Failed
+		}
+		if out[i].Deferred != out[j].Deferred {
+			return out[i].Deferred > out[j].Deferred
+		}
+		return out[i].Domain < out[j].Domain
+	})
+
+	return out
+}
+
+// close stops the retry queue and closes the cached connections. Deferred
+// messages that are still in the queue are dropped.
+func (d *mxDeliverer) close() {
+	close(d.quit)
+	d.wg.Wait()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, conns := range d.idle {
+		for _, c := range conns {
+			c.close()
+		}
+	}
+	d.idle = make(map[string][]*mxConn)
+	d.queue = nil
+}
+
+// isTempErr checks if a delivery error is temporary, ie, everything except
+// 5xx replies from the server and null MX domains.
+func isTempErr(err error) bool {
+	if errors.Is(err, errNullMX) {
+		return false
+	}
+
+	var e *textproto.Error
+	if errors.As(err, &e) {
+		return e.Code < 500
+	}
+	return true
+}
+
+// isSMTPErr checks if an error is a reply from the server as opposed to a
+// connection error.
+func isSMTPErr(err error) bool {
+	var e *textproto.Error
+	return errors.As(err, &e)
+}
+
+// pushMX builds a message and delivers it to the mail servers of each of its
+// recipients' domains.
+func (e *Emailer) pushMX(em smtppool.Email, key *mailcrypt.Key) error {
+	sender, rcpt, msg, err := e.makeMessage(em, key)
+	if err != nil {
+		return err
+	}
+
+	// Group the recipients by their domains.
+	var (
+		domains []string
+		byDom   = map[string][]string{}
+	)
+	for _, r := range rcpt {
+		i := strings.LastIndexByte(r, '@')
+		if i < 0 {
+			return fmt.Errorf("invalid recipient address '%s'", r)
+		}
+
+		dom := strings.ToLower(r[i+1:])
+		if _, ok := byDom[dom]; !ok {
+			domains = append(domains, dom)
+		}
+		byDom[dom] = append(byDom[dom], r)
+	}
+
+	var errs []error
+	for _, dom := range domains {
+		if err := e.mx.deliver(&mxMsg{domain: dom, from: sender, rcpt: byDom[dom], body: msg}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
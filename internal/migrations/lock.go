@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LockID is the key of the Postgres advisory lock that's held while migrations
+// run so that multiple instances (replicas) upgrading the same database
+// at the same time do not run migrations concurrently.
+const LockID int64 = 0x6c6973746d6f6e6b
+
+// Lock acquires the migration advisory lock on a dedicated DB connection. It
+// blocks until any other instance that's running migrations releases the lock.
+// The returned function releases the lock.
+func Lock(db *sqlx.DB) (func(), error) {
+	ctx := context.Background()
+
+	// Advisory locks are held by a session, so the same connection has to be
+	// used to acquire and release it.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT PG_ADVISORY_LOCK($1)`, LockID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		conn.ExecContext(ctx, `SELECT PG_ADVISORY_UNLOCK($1)`, LockID)
+		conn.Close()
+	}, nil
+}
+
+// IsLocked checks whether the migration lock is currently held, ie: whether
+// migrations are being run by an instance.
+func IsLocked(db *sqlx.DB) (bool, error) {
+	// A bigint advisory lock key is split into two 32-bit halves in pg_locks.
+	var ok bool
+	err := db.Get(&ok, `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory'
+		AND classid = $1::BIGINT::OID AND objid = $2::BIGINT::OID AND objsubid = 1 AND granted)`,
+		LockID>>32, LockID&0xffffffff)
+
+	return ok, err
+}
@@ -0,0 +1,993 @@
+package migrations
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V5_1_0 performs the DB migrations.
+func V5_1_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("IMPORTANT: this upgrade creates full-text search indexes and might take a while if you have a large database. Please be patient ...")
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_subs_search ON subscribers USING GIN ((TO_TSVECTOR('simple', email || ' ' || name) || JSONB_TO_TSVECTOR('simple', attribs, '["string", "numeric"]')));
+		CREATE INDEX IF NOT EXISTS idx_lists_search ON lists USING GIN (TO_TSVECTOR('simple', name || ' ' || description));
+		CREATE INDEX IF NOT EXISTS idx_tpls_search ON templates USING GIN ((SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B')));
+	`); err != nil {
+		return err
+	}
+
+	// Saved subscriber queries.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_queries (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			query            TEXT NOT NULL,
+			shared           BOOLEAN NOT NULL DEFAULT false,
+			user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_queries_user_id ON subscriber_queries(user_id);
+
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS subscriber_query_id INTEGER NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber tags.
+	if _, err := db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS tags VARCHAR(100)[] NOT NULL DEFAULT '{}';
+		CREATE INDEX IF NOT EXISTS idx_subs_tags ON subscribers USING GIN (tags);
+	`); err != nil {
+		return err
+	}
+
+	// Folders for campaigns and templates.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS folders (
+			id              SERIAL PRIMARY KEY,
+			name            TEXT NOT NULL,
+			description     TEXT NOT NULL DEFAULT '',
+			archived        BOOLEAN NOT NULL DEFAULT false,
+			created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS folder_id INTEGER NULL REFERENCES folders(id) ON DELETE SET NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS folder_id INTEGER NULL REFERENCES folders(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_camps_folder_id ON campaigns(folder_id);
+	`); err != nil {
+		return err
+	}
+
+	// Trash (soft-delete) for lists, campaigns, and templates.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE NULL;
+		CREATE INDEX IF NOT EXISTS idx_lists_deleted_at ON lists(deleted_at) WHERE deleted_at IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS idx_camps_deleted_at ON campaigns(deleted_at) WHERE deleted_at IS NOT NULL;
+		INSERT INTO settings (key, value) VALUES('app.trash_retention_days', '30') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// List groups.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS list_groups (
+			id              SERIAL PRIMARY KEY,
+			name            TEXT NOT NULL,
+			description     TEXT NOT NULL DEFAULT '',
+			created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS group_id INTEGER NULL REFERENCES list_groups(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_lists_group_id ON lists(group_id);
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS list_group_ids INTEGER[] NOT NULL DEFAULT '{}';
+	`); err != nil {
+		return err
+	}
+
+	// Public list landing page metadata.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS logo_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS lang TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	// Notification channels for admin alerts.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES('notifications', '[]') ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Subscriber language for public pages and e-mails.
+	if _, err := db.Exec(`ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS lang TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	// Bounce and complaint rate alert rules.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES('alert_rules', '[]') ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Event log for the activity feed.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS event_log (
+			id               BIGSERIAL PRIMARY KEY,
+			type             TEXT NOT NULL,
+			message          TEXT NOT NULL,
+			meta             JSONB NOT NULL DEFAULT '{}',
+			user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_log_type ON event_log(type, id);
+	`); err != nil {
+		return err
+	}
+
+	// Per-campaign unsubscribes for campaign reports.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_unsubscribes (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_unsubs_camp_id ON campaign_unsubscribes(campaign_id);
+	`); err != nil {
+		return err
+	}
+
+	// Flag views from prefetching image proxies.
+	if _, err := db.Exec(`ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS proxy BOOLEAN NOT NULL DEFAULT false`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES('privacy.discount_proxy_opens', 'false') ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Clicks from security scanners and bots.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS link_clicks_bots (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			reason           TEXT NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_bot_clicks_camp_id ON link_clicks_bots(campaign_id);
+		INSERT INTO settings (key, value) VALUES
+			('privacy.filter_bot_clicks', 'true'),
+			('privacy.bot_click_ips', '[]')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Provenance of subscribers and subscriptions.
+	if _, err := db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+		ALTER TABLE subscriber_lists ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	// Deep merging of JSONB attributes in imports.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION JSONB_DEEP_MERGE(a JSONB, b JSONB) RETURNS JSONB AS $$
+		BEGIN
+		    IF a IS NULL OR b IS NULL OR JSONB_TYPEOF(a) != 'object' OR JSONB_TYPEOF(b) != 'object' THEN
+		        RETURN COALESCE(b, a);
+		    END IF;
+
+		    RETURN COALESCE((
+		        SELECT JSONB_OBJECT_AGG(COALESCE(ka, kb),
+		            CASE WHEN va IS NULL THEN vb WHEN vb IS NULL THEN va ELSE JSONB_DEEP_MERGE(va, vb) END)
+		        FROM JSONB_EACH(a) e1(ka, va) FULL JOIN JSONB_EACH(b) e2(kb, vb) ON ka = kb
+		    ), '{}');
+		END;
+		$$ LANGUAGE plpgsql IMMUTABLE;
+	`); err != nil {
+		return err
+	}
+
+	// Proof-of-consent records on subscriptions.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.record_consent', 'false'),
+			('privacy.consent_version', '""')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Per-subscriber attachments fetched from templated URLs.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS attachment_urls TEXT[] NOT NULL DEFAULT '{}'`); err != nil {
+		return err
+	}
+
+	// Calendar invites and RSVPs.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS event JSONB NULL;
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'rsvp_status') THEN
+				CREATE TYPE rsvp_status AS ENUM ('accepted', 'declined', 'tentative');
+			END IF;
+		END$$;
+		CREATE TABLE IF NOT EXISTS campaign_rsvps (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			status           rsvp_status NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY(campaign_id, subscriber_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber referrals.
+	if _, err := db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS referral_code TEXT NOT NULL UNIQUE DEFAULT SUBSTR(MD5(RANDOM()::TEXT || CLOCK_TIMESTAMP()::TEXT), 1, 12);
+		CREATE TABLE IF NOT EXISTS subscriber_referrals (
+			subscriber_id   INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			referrer_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_referrals_referrer ON subscriber_referrals(referrer_id);
+	`); err != nil {
+		return err
+	}
+
+	// Paid lists with Stripe subscriptions.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS stripe_price_id TEXT NOT NULL DEFAULT '';
+		CREATE TABLE IF NOT EXISTS stripe_subscriptions (
+			id                 TEXT NOT NULL PRIMARY KEY,
+			subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			customer_id        TEXT NOT NULL,
+			price_ids          TEXT[] NOT NULL DEFAULT '{}',
+			status             TEXT NOT NULL,
+			current_period_end TIMESTAMP WITH TIME ZONE NULL,
+			created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_stripe_subs_sub_id ON stripe_subscriptions(subscriber_id);
+		INSERT INTO settings (key, value) VALUES
+			('billing.stripe', '{"enabled": false, "secret_key": "", "webhook_secret": ""}')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Alternative opt-in confirmation methods.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS optin_method TEXT NOT NULL DEFAULT 'link';
+		CREATE TABLE IF NOT EXISTS subscriber_optin_codes (
+			subscriber_id   INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			code            TEXT NOT NULL,
+			list_ids        INTEGER[] NOT NULL DEFAULT '{}',
+			attempts        INTEGER NOT NULL DEFAULT 0,
+			expires_at      TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		INSERT INTO settings (key, value) VALUES('privacy.optin_sms_messenger', '""') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Public page template overrides.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('appearance.public.templates', '[]')
+		ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Custom public domains of lists.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_lists_domain ON lists(domain) WHERE domain != '' AND deleted_at IS NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Dark mode meta injection in campaign messages.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('app.dark_mode_meta', 'false')
+		ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Message size and image weight budgets.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.message_size_limit', '0'),
+			('app.image_weight_limit', '0')
+		ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Campaign preheaders.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS preheader TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	// Campaign language variants.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+		CREATE TABLE IF NOT EXISTS campaign_variant_stats (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			lang             TEXT NOT NULL DEFAULT '',
+			sent             INT NOT NULL DEFAULT 0,
+			PRIMARY KEY(campaign_id, lang)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Syncs of external suppression lists.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'suppression_sync_status') THEN
+				CREATE TYPE suppression_sync_status AS ENUM ('pending', 'applied', 'rejected', 'failed');
+			END IF;
+		END$$;
+		CREATE TABLE IF NOT EXISTS suppression_syncs (
+			id               SERIAL PRIMARY KEY,
+			source_uuid      TEXT NOT NULL,
+			source_name      TEXT NOT NULL DEFAULT '',
+			status           suppression_sync_status NOT NULL DEFAULT 'pending',
+			total            INT NOT NULL DEFAULT 0,
+			added            TEXT[] NOT NULL DEFAULT '{}',
+			removed          TEXT[] NOT NULL DEFAULT '{}',
+			error            TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			applied_at       TIMESTAMP WITH TIME ZONE NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_suppression_syncs_source ON suppression_syncs(source_uuid, created_at);
+		INSERT INTO settings (key, value) VALUES ('privacy.suppression_sources', '[]') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// S/MIME and PGP encryption.
+	if _, err := db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS public_key TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value) VALUES
+			('security.smime_sign', 'false'),
+			('security.smime_cert', '""'),
+			('security.smime_key', '""'),
+			('security.encrypt_messages', 'false')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Campaign autosave revisions.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_revisions (
+			id               SERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			data             JSONB NOT NULL DEFAULT '{}',
+			created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_revisions_camp_id ON campaign_revisions(campaign_id);
+	`); err != nil {
+		return err
+	}
+
+	// Campaign presets.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_presets (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			description      TEXT NOT NULL DEFAULT '',
+
+			subject          TEXT NOT NULL DEFAULT '',
+			preheader        TEXT NOT NULL DEFAULT '',
+			from_email       TEXT NOT NULL DEFAULT '',
+			body             TEXT NOT NULL DEFAULT '',
+			altbody          TEXT NULL,
+			content_type     content_type NOT NULL DEFAULT 'richtext',
+			template_id      INTEGER REFERENCES templates(id) ON DELETE SET NULL,
+			headers          JSONB NOT NULL DEFAULT '[]',
+			messenger        TEXT NOT NULL DEFAULT '',
+			tags             VARCHAR(100)[],
+
+			-- Default lists of campaigns created from the preset.
+			list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+
+			-- UTM parameters ({"source", "medium", "campaign"}) added to the links
+			-- in the body of campaigns created from the preset.
+			utm              JSONB NOT NULL DEFAULT '{}',
+
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Versions for detecting concurrent edits.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	`); err != nil {
+		return err
+	}
+
+	// Per-list campaign permissions. Roles that could work with campaigns
+	// retain access to the campaigns of all lists.
+	if _, err := db.Exec(`
+		UPDATE roles SET permissions = ARRAY_APPEND(permissions, 'lists:send_all')
+			WHERE type = 'user' AND parent_id IS NULL
+			AND permissions && '{campaigns:get,campaigns:manage}'::TEXT[]
+			AND NOT ('lists:send_all' = ANY(permissions));
+	`); err != nil {
+		return err
+	}
+
+	// Per-domain sent counts of campaigns.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_domain_stats (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			domain           TEXT NOT NULL,
+			sent             INT NOT NULL DEFAULT 0,
+			PRIMARY KEY(campaign_id, domain)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Retries of campaigns to their soft-bounced recipients.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS retry_of INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS retry_attempt INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_camps_retry_of ON campaigns(retry_of) WHERE retry_of IS NOT NULL;
+		INSERT INTO settings (key, value) VALUES ('bounce.retry_max_attempts', '3') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Dynamic lists.
+	if _, err := db.Exec(`ALTER TABLE lists ADD COLUMN IF NOT EXISTS rules JSONB NULL;`); err != nil {
+		return err
+	}
+
+	// Downloadable files with signed URLs.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'media_type') THEN
+				CREATE TYPE media_type AS ENUM ('media', 'file');
+			END IF;
+		END$$;
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS type media_type NOT NULL DEFAULT 'media';
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS downloads INTEGER NOT NULL DEFAULT 0;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_media_files ON media(filename) WHERE type = 'file';
+	`); err != nil {
+		return err
+	}
+
+	// Short tracked links.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS link_slugs (
+			slug             TEXT NOT NULL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+			UNIQUE(campaign_id, link_id)
+		);
+		INSERT INTO settings (key, value) VALUES ('app.short_links', 'false') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Compressed and externally stored campaign bodies.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS body_gz BYTEA NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS body_ref TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	// Pre-send campaign checklists.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_checklist (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			item             TEXT NOT NULL,
+			created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			PRIMARY KEY(campaign_id, item)
+		);
+		INSERT INTO settings (key, value) VALUES ('app.require_campaign_checklist', 'false') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Timezones of campaign schedules.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS send_at_tz TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value) VALUES ('app.default_timezone', '"UTC"') ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Follow-up campaign chains.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'followup_audience') THEN
+				CREATE TYPE followup_audience AS ENUM ('openers', 'clickers');
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'followup_status') THEN
+				CREATE TYPE followup_status AS ENUM ('waiting', 'scheduled', 'cancelled');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS campaign_followups (
+			id               SERIAL PRIMARY KEY,
+			parent_id        INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			campaign_id      INTEGER NOT NULL UNIQUE REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			audience         followup_audience NOT NULL,
+			url              TEXT NOT NULL DEFAULT '',
+			delay_days       INT NOT NULL DEFAULT 0,
+			status           followup_status NOT NULL DEFAULT 'waiting',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_followups_parent_id ON campaign_followups(parent_id);
+		CREATE INDEX IF NOT EXISTS idx_camp_followups_status ON campaign_followups(status);
+	`); err != nil {
+		return err
+	}
+
+	// Foreign key on the saved subscriber queries of campaigns.
+	if _, err := db.Exec(`
+		UPDATE campaigns SET subscriber_query_id = NULL
+			WHERE subscriber_query_id IS NOT NULL AND subscriber_query_id NOT IN (SELECT id FROM subscriber_queries);
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'campaigns_subscriber_query_id_fkey') THEN
+				ALTER TABLE campaigns ADD CONSTRAINT campaigns_subscriber_query_id_fkey
+					FOREIGN KEY (subscriber_query_id) REFERENCES subscriber_queries(id) ON DELETE SET NULL;
+			END IF;
+		END$$;
+	`); err != nil {
+		return err
+	}
+
+	// Searchable text of compressed campaign bodies.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS body_search TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	// Bodies that are compressed in the DB are decompressed for the search
+	// text. Those in the media store are picked up when they're next saved.
+	var camps []struct {
+		ID     int    `db:"id"`
+		BodyGz []byte `db:"body_gz"`
+	}
+	if err := db.Select(&camps, `SELECT id, body_gz FROM campaigns WHERE body_gz IS NOT NULL AND body_search = ''`); err != nil {
+		return err
+	}
+	for _, c := range camps {
+		gz, err := gzip.NewReader(bytes.NewReader(c.BodyGz))
+		if err != nil {
+			lo.Printf("error reading compressed body of campaign %d: %v", c.ID, err)
+			continue
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil {
+			lo.Printf("error reading compressed body of campaign %d: %v", c.ID, err)
+			continue
+		}
+
+		if _, err := db.Exec(`UPDATE campaigns SET body_search = LEFT($2, 100000) WHERE id = $1`, c.ID, string(body)); err != nil {
+			return err
+		}
+	}
+
+	// The campaign search index includes body_search.
+	if _, err := db.Exec(`
+		DROP INDEX IF EXISTS idx_camps_search;
+		CREATE INDEX idx_camps_search ON campaigns USING GIN ((SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D')));
+	`); err != nil {
+		return err
+	}
+
+	// Archive slugs of campaigns in the trash can be reused.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns DROP CONSTRAINT IF EXISTS campaigns_archive_slug_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_camps_archive_slug ON campaigns(archive_slug) WHERE deleted_at IS NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Lead capture forms and their captures.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS lead_forms (
+			id               SERIAL PRIMARY KEY,
+			uuid             UUID NOT NULL UNIQUE,
+			name             TEXT NOT NULL,
+			api_key          TEXT NOT NULL UNIQUE,
+			enabled          BOOLEAN NOT NULL DEFAULT true,
+			list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+			tags             VARCHAR(100)[] NOT NULL DEFAULT '{}',
+			tx_template_id   INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL,
+			rate_limit       INTEGER NOT NULL DEFAULT 10,
+			redirect_url     TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS lead_captures (
+			id               BIGSERIAL PRIMARY KEY,
+			form_id          INTEGER NOT NULL REFERENCES lead_forms(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			utm              JSONB NOT NULL DEFAULT '{}',
+			referrer         TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_lead_captures_form_id ON lead_captures(form_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_lead_captures_sub_id ON lead_captures(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// Event triggered rules.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS event_rules (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			event            TEXT NOT NULL,
+			enabled          BOOLEAN NOT NULL DEFAULT true,
+			tx_template_id   INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL,
+			list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+			tags             VARCHAR(100)[] NOT NULL DEFAULT '{}',
+			dedup_minutes    INTEGER NOT NULL DEFAULT 0,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_rules_event ON event_rules(event);
+
+		CREATE TABLE IF NOT EXISTS event_triggers (
+			id               BIGSERIAL PRIMARY KEY,
+			rule_id          INTEGER NOT NULL REFERENCES event_rules(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			event            TEXT NOT NULL,
+			payload          JSONB NOT NULL DEFAULT '{}',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_triggers_rule_sub ON event_triggers(rule_id, subscriber_id, created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Log of rotated subscriber UUIDs.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_uuid_rotations (
+			id              BIGSERIAL PRIMARY KEY,
+			subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			old_uuid        UUID NOT NULL,
+			reason          TEXT NOT NULL DEFAULT '',
+			user_id         INTEGER NULL,
+			created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_uuid_rotations_old_uuid ON subscriber_uuid_rotations(old_uuid);
+		CREATE INDEX IF NOT EXISTS idx_sub_uuid_rotations_sub_id ON subscriber_uuid_rotations(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// Signed public unsubscribe and opt-in links. Unsigned links are accepted
+	// until require_signed_links is turned on.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.require_signed_links', 'false'),
+			('privacy.signed_link_expiry', '"0"')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Outbox review of small campaigns.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS outbox TEXT NOT NULL DEFAULT '';
+		CREATE TABLE IF NOT EXISTS campaign_outbox (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			email            TEXT NOT NULL,
+			subject          TEXT NOT NULL,
+			body             TEXT NOT NULL,
+			altbody          TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(campaign_id, subscriber_id)
+		);
+		INSERT INTO settings (key, value) VALUES ('app.outbox_review_threshold', '0')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Direct MX delivery.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('smtp_mx.enabled', 'false'),
+			('smtp_mx.hello_hostname', '""'),
+			('smtp_mx.require_tls', 'false'),
+			('smtp_mx.max_conns', '2'),
+			('smtp_mx.idle_timeout', '"15s"'),
+			('smtp_mx.max_retries', '5'),
+			('smtp_mx.retry_interval', '"5m"')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Feedback-ID headers and imported postmaster stats.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS postmaster_stats (
+			id                   BIGSERIAL PRIMARY KEY,
+
+			-- Mailbox provider the stats are from, eg: gmail.
+			provider             TEXT NOT NULL,
+
+			-- Sending domain and the day the stats are of.
+			domain               TEXT NOT NULL,
+			date                 DATE NOT NULL,
+
+			-- Feedback-ID identifier the stats are of. Empty for domain-wide stats.
+			identifier           TEXT NOT NULL DEFAULT '',
+			campaign_id          INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+			spam_rate            DOUBLE PRECISION NULL,
+			domain_reputation    TEXT NOT NULL DEFAULT '',
+			ip_reputation        TEXT NOT NULL DEFAULT '',
+			spf_success_rate     DOUBLE PRECISION NULL,
+			dkim_success_rate    DOUBLE PRECISION NULL,
+			dmarc_success_rate   DOUBLE PRECISION NULL,
+			delivery_error_rate  DOUBLE PRECISION NULL,
+
+			-- Rest of the provider's data as-is.
+			meta                 JSONB NOT NULL DEFAULT '{}',
+			created_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(provider, domain, date, identifier)
+		);
+		CREATE INDEX IF NOT EXISTS idx_postmaster_stats_camp_id ON postmaster_stats(campaign_id);
+		INSERT INTO settings (key, value) VALUES
+			('feedback_id.enabled', 'true'),
+			('feedback_id.sender', '"listmonk"')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Re-permission (re-opt-in) runs.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'repermission_action') THEN
+				CREATE TYPE repermission_action AS ENUM ('remove', 'unsubscribe');
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'repermission_status') THEN
+				CREATE TYPE repermission_status AS ENUM ('waiting', 'done', 'cancelled');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS repermissions (
+			id               SERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL UNIQUE REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			list_ids         INT[] NOT NULL,
+			deadline         TIMESTAMP WITH TIME ZONE NOT NULL,
+			action           repermission_action NOT NULL DEFAULT 'remove',
+			status           repermission_status NOT NULL DEFAULT 'waiting',
+			removed          INT NOT NULL DEFAULT 0,
+			created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			completed_at     TIMESTAMP WITH TIME ZONE NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_repermissions_status ON repermissions(status);
+
+		CREATE TABLE IF NOT EXISTS repermission_subscriptions (
+			repermission_id  INTEGER NOT NULL REFERENCES repermissions(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+			PRIMARY KEY(repermission_id, subscriber_id, list_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Saved SQL reports and their results.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'report_result_status') THEN
+				CREATE TYPE report_result_status AS ENUM ('success', 'error');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS reports (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			description      TEXT NOT NULL DEFAULT '',
+			query            TEXT NOT NULL,
+			schedule         TEXT NOT NULL DEFAULT '',
+			last_run_at      TIMESTAMP WITH TIME ZONE NULL,
+			created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS report_results (
+			id               BIGSERIAL PRIMARY KEY,
+			report_id        INTEGER NOT NULL REFERENCES reports(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			status           report_result_status NOT NULL,
+			scheduled        BOOLEAN NOT NULL DEFAULT false,
+			columns          JSONB NOT NULL DEFAULT '[]',
+			data             JSONB NOT NULL DEFAULT '[]',
+			num_rows         INT NOT NULL DEFAULT 0,
+			truncated        BOOLEAN NOT NULL DEFAULT false,
+			error            TEXT NOT NULL DEFAULT '',
+			duration_ms      INT NOT NULL DEFAULT 0,
+			created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_report_results_report ON report_results(report_id, id);
+	`); err != nil {
+		return err
+	}
+
+	// Incremental exports of tables to the data warehouse.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS warehouse_exports (
+			name             TEXT NOT NULL PRIMARY KEY,
+			cursor_at        TIMESTAMP WITH TIME ZONE NULL,
+			cursor_id        BIGINT NOT NULL DEFAULT 0,
+			exported         BIGINT NOT NULL DEFAULT 0,
+			files            INT NOT NULL DEFAULT 0,
+			last_run_at      TIMESTAMP WITH TIME ZONE NULL,
+			last_status      TEXT NOT NULL DEFAULT '',
+			last_error       TEXT NOT NULL DEFAULT '',
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Human replies to campaign messages.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_replies (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			message_id       TEXT NOT NULL UNIQUE,
+			from_email       TEXT NOT NULL DEFAULT '',
+			subject          TEXT NOT NULL DEFAULT '',
+			body             TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_replies_camp_id ON campaign_replies(campaign_id);
+		CREATE INDEX IF NOT EXISTS idx_camp_replies_sub_id ON campaign_replies(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// Validation policies for the e-mails of new subscribers.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.block_role_accounts', 'false'),
+			('privacy.role_accounts', '[]'),
+			('privacy.check_mx', 'false'),
+			('privacy.email_deny_patterns', '[]')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Revisions of campaign content recorded on updates, besides autosaves.
+	if _, err := db.Exec(`ALTER TABLE campaign_revisions ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'autosave'`); err != nil {
+		return err
+	}
+
+	// Sanitization of subscriber data in templates.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('security.sanitize_enabled', 'false'),
+			('security.sanitize_mode', '"strip"'),
+			('security.sanitize_max_name_len', '200'),
+			('security.sanitize_max_attrib_len', '1000'),
+			('security.sanitize_allowed_attribs', '[]'),
+			('security.sanitize_raw_fields', '[]')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Template function allowlist and rendering limits.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('security.template_funcs', '[]'),
+			('security.template_render_timeout', '"10s"'),
+			('security.template_max_size', '10240')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Adaptive concurrency.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.adaptive_concurrency', 'false'),
+			('app.min_concurrency', '1'),
+			('app.adaptive_latency', '"2s"'),
+			('app.adaptive_error_rate', '5')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Messenger circuit breakers.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS paused_by_breaker TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value) VALUES
+			('app.breaker_threshold', '20'),
+			('app.breaker_cooldown', '"1m"')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Compliance profiles of lists.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS compliance_profile TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value) VALUES
+			('compliance.profiles', '[{"name": "can-spam", "require_address": true, "require_unsubscribe": true, "require_sender": true}, {"name": "casl", "require_address": true, "require_unsubscribe": true, "require_sender": true}, {"name": "gdpr", "require_address": false, "require_unsubscribe": true, "require_sender": true}]')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Sender profiles of the instance and lists.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS sender_profile JSONB NOT NULL DEFAULT '{}';
+		INSERT INTO settings (key, value) VALUES
+			('sender.company_name', '""'),
+			('sender.address', '""'),
+			('sender.links', '[]')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Simulated campaigns.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS simulate BOOLEAN NOT NULL DEFAULT false`); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package notifs routes internal admin notifications (campaign status, import
+// status, bounce spikes, sending quota) to one or more configured channels such
+// as e-mail, Slack, generic webhooks, and PagerDuty.
+package notifs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Channel types.
+const (
+	TypeEmail     = "email"
+	TypeSlack     = "slack"
+	TypeWebhook   = "webhook"
+	TypePagerDuty = "pagerduty"
+)
+
+// Notification event types that channels can subscribe to.
+const (
+	EventCampaign = "campaign"
+	EventImport   = "import"
+	EventBounce   = "bounce"
+	EventQuota    = "quota"
+
+	// Subscription status changes are high volume and are only sent to
+	// channels that explicitly subscribe to them.
+	EventSubscription = "subscription"
+)
+
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Channel represents a notification channel.
+type Channel struct {
+	UUID    string   `json:"uuid"`
+	Enabled bool     `json:"enabled"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	URL     string   `json:"url"`
+	Emails  []string `json:"emails"`
+	Key     string   `json:"key"`
+	Events  []string `json:"events"`
+}
+
+// Notif is a notification that's pushed to channels.
+type Notif struct {
+	Event   string      `json:"event"`
+	Subject string      `json:"subject"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+
+	// Name of the e-mail template to render the notification with.
+	Tpl string `json:"-"`
+}
+
+// EmailFunc sends a notification as an e-mail to the given recipients.
+type EmailFunc func(to []string, n Notif) error
+
+// Opt represents notification options.
+type Opt struct {
+	Channels []Channel
+	RootURL  string
+	Timeout  time.Duration
+}
+
+// Notifs dispatches notifications to channels.
+type Notifs struct {
+	opt   Opt
+	email EmailFunc
+	c     *http.Client
+	log   *log.Logger
+}
+
+// New returns a new instance of Notifs.
+func New(o Opt, email EmailFunc, lo *log.Logger) *Notifs {
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 5
+	}
+
+	return &Notifs{
+		opt:   o,
+		email: email,
+		c:     &http.Client{Timeout: o.Timeout},
+		log:   lo,
+	}
+}
+
+// Push sends a notification asynchronously to all enabled channels that are
+// subscribed to the notification's event. Channels with no events receive all
+// events except subscription events.
+func (n *Notifs) Push(no Notif) {
+	for _, ch := range n.opt.Channels {
+		if !ch.Enabled || !ch.hasEvent(no.Event) {
+			continue
+		}
+
+		go func(ch Channel) {
+			if err := n.Send(ch, no); err != nil {
+				n.log.Printf("error sending notification to channel '%s': %v", ch.Name, err)
+			}
+		}(ch)
+	}
+}
+
+// Send sends a notification to a single channel.
+func (n *Notifs) Send(ch Channel, no Notif) error {
+	switch ch.Type {
+	case TypeEmail:
+		if len(ch.Emails) == 0 {
+			return nil
+		}
+		return n.email(ch.Emails, no)
+
+	case TypeSlack:
+		text := no.Subject
+		if no.Message != "" {
+			text += "\n" + no.Message
+		}
+		return n.post(ch.URL, map[string]string{"text": text}, nil)
+
+	case TypeWebhook:
+		// An optional key is sent as a bearer token.
+		var h http.Header
+		if ch.Key != "" {
+			h = http.Header{"Authorization": []string{"Bearer " + ch.Key}}
+		}
+		return n.post(ch.URL, no, h)
+
+	case TypePagerDuty:
+		u := ch.URL
+		if u == "" {
+			u = pagerDutyURL
+		}
+
+		return n.post(u, map[string]interface{}{
+			"routing_key":  ch.Key,
+			"event_action": "trigger",
+			"payload": map[string]interface{}{
+				"summary":        no.Subject,
+				"source":         n.opt.RootURL,
+				"severity":       "warning",
+				"component":      "listmonk",
+				"group":          no.Event,
+				"custom_details": no.Data,
+			},
+		}, nil)
+	}
+
+	return fmt.Errorf("unknown channel type: %s", ch.Type)
+}
+
+// post posts the given payload as JSON to the URL.
+func (n *Notifs) post(u string, data interface{}, h http.Header) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "listmonk")
+
+	r, err := n.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Drain and close the body to let the Transport reuse the connection
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return fmt.Errorf("non 2xx response from %s: %d", u, r.StatusCode)
+	}
+
+	return nil
+}
+
+// hasEvent checks if the channel is subscribed to the given event.
+func (ch Channel) hasEvent(ev string) bool {
+	if len(ch.Events) == 0 {
+		return ev != EventSubscription
+	}
+
+	for _, e := range ch.Events {
+		if e == ev {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,334 @@
+// Package parquet is a minimal writer of Apache Parquet files for exporting
+// flat tables. It writes a single row group of nullable columns with one
+// gzip compressed, PLAIN encoded data page per column, which is readable by
+// all the common Parquet readers and data warehouses.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Type is the type of a column.
+type Type int
+
+// Column types.
+const (
+	Int64 Type = iota
+	Double
+	Boolean
+	String
+	Timestamp
+)
+
+// Column is a column of a table.
+type Column struct {
+	Name string
+	Type Type
+}
+
+const magic = "PAR1"
+
+// Parquet physical types.
+const (
+	typeBoolean   = 0
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Parquet converted types, encodings, codecs, and page types.
+const (
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	repetitionOptional = 1
+
+	pageData = 0
+)
+
+// columnChunk is an encoded column and its metadata.
+type columnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// Write writes rows as a Parquet file. The values in a row should be in the
+// order of the columns and be of the Go type of the column's type, ie: int64,
+// float64, bool, string or []byte, time.Time, or nil for NULL.
+func Write(w io.Writer, cols []Column, rows [][]interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+
+	chunks := make([]columnChunk, len(cols))
+	for i, c := range cols {
+		b, size, err := encodeColumn(c, i, rows)
+		if err != nil {
+			return err
+		}
+
+		chunks[i] = columnChunk{
+			offset:           int64(buf.Len()),
+			uncompressedSize: size,
+			compressedSize:   int64(len(b)),
+		}
+		buf.Write(b)
+	}
+
+	meta := fileMetaData(cols, chunks, len(rows))
+
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(meta)))
+	buf.Write(meta)
+	buf.Write(l[:])
+	buf.WriteString(magic)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodeColumn encodes the values of a column as a data page with its header
+// and returns it with its uncompressed size.
+func encodeColumn(c Column, idx int, rows [][]interface{}) ([]byte, int64, error) {
+	var (
+		defs  = make([]byte, (len(rows)+7)/8)
+		vals  bytes.Buffer
+		bools []bool
+		b8    [8]byte
+	)
+
+	for n, r := range rows {
+		if idx >= len(r) || r[idx] == nil {
+			continue
+		}
+
+		// Definition level 1 marks a non-NULL value.
+		defs[n/8] |= 1 << (n % 8)
+
+		v := r[idx]
+		switch c.Type {
+		case Int64:
+			i, err := toInt64(v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("column %s: %v", c.Name, err)
+			}
+			binary.LittleEndian.PutUint64(b8[:], uint64(i))
+			vals.Write(b8[:])
+
+		case Double:
+			f, err := toFloat64(v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("column %s: %v", c.Name, err)
+			}
+			binary.LittleEndian.PutUint64(b8[:], math.Float64bits(f))
+			vals.Write(b8[:])
+
+		case Boolean:
+			b, ok := v.(bool)
+			if !ok {
+				return nil, 0, fmt.Errorf("column %s: unexpected value %T", c.Name, v)
+			}
+			bools = append(bools, b)
+
+		case String:
+			var s []byte
+			switch t := v.(type) {
+			case string:
+				s = []byte(t)
+			case []byte:
+				s = t
+			default:
+				return nil, 0, fmt.Errorf("column %s: unexpected value %T", c.Name, v)
+			}
+			var l [4]byte
+			binary.LittleEndian.PutUint32(l[:], uint32(len(s)))
+			vals.Write(l[:])
+			vals.Write(s)
+
+		case Timestamp:
+			t, ok := v.(time.Time)
+			if !ok {
+				return nil, 0, fmt.Errorf("column %s: unexpected value %T", c.Name, v)
+			}
+			binary.LittleEndian.PutUint64(b8[:], uint64(t.UnixMicro()))
+			vals.Write(b8[:])
+		}
+	}
+
+	// Booleans are bit-packed.
+	if c.Type == Boolean {
+		p := make([]byte, (len(bools)+7)/8)
+		for i, b := range bools {
+			if b {
+				p[i/8] |= 1 << (i % 8)
+			}
+		}
+		vals.Write(p)
+	}
+
+	// The page is the length prefixed definition levels as a single
+	// bit-packed run of the RLE/bit-packing hybrid encoding, followed by the
+	// values. There are no repetition levels as the columns are flat.
+	var levels bytes.Buffer
+	var v [binary.MaxVarintLen64]byte
+	levels.Write(v[:binary.PutUvarint(v[:], uint64(len(defs))<<1|1)])
+	levels.Write(defs)
+
+	var page bytes.Buffer
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(levels.Len()))
+	page.Write(l[:])
+	page.Write(levels.Bytes())
+	page.Write(vals.Bytes())
+
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	if _, err := zw.Write(page.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	// Page header.
+	var h compactWriter
+	h.begin()
+	h.i32(1, pageData)
+	h.i32(2, int32(page.Len()))
+	h.i32(3, int32(zb.Len()))
+	h.beginStruct(5)
+	h.i32(1, int32(len(rows)))
+	h.i32(2, encodingPlain)
+	h.i32(3, encodingRLE)
+	h.i32(4, encodingRLE)
+	h.end()
+	h.end()
+
+	out := append(h.buf.Bytes(), zb.Bytes()...)
+	return out, int64(h.buf.Len() + page.Len()), nil
+}
+
+// fileMetaData returns the encoded file metadata (footer).
+func fileMetaData(cols []Column, chunks []columnChunk, numRows int) []byte {
+	var w compactWriter
+	w.begin()
+
+	// Version.
+	w.i32(1, 1)
+
+	// Schema: the root followed by the columns.
+	w.list(2, ctStruct, len(cols)+1)
+	w.begin()
+	w.str(4, "schema")
+	w.i32(5, int32(len(cols)))
+	w.end()
+	for _, c := range cols {
+		typ, conv := physicalType(c.Type)
+
+		w.begin()
+		w.i32(1, typ)
+		w.i32(3, repetitionOptional)
+		w.str(4, c.Name)
+		if conv >= 0 {
+			w.i32(6, conv)
+		}
+		w.end()
+	}
+
+	w.i64(3, int64(numRows))
+
+	// Row groups.
+	var total int64
+	for _, c := range chunks {
+		total += c.uncompressedSize
+	}
+	w.list(4, ctStruct, 1)
+	w.begin()
+	w.list(1, ctStruct, len(cols))
+	for i, c := range cols {
+		typ, _ := physicalType(c.Type)
+		ch := chunks[i]
+
+		w.begin()
+		w.i64(2, ch.offset)
+		w.beginStruct(3)
+		w.i32(1, typ)
+		w.list(2, ctI32, 2)
+		w.elemI32(encodingPlain)
+		w.elemI32(encodingRLE)
+		w.list(3, ctBinary, 1)
+		w.elemStr(c.Name)
+		w.i32(4, codecGzip)
+		w.i64(5, int64(numRows))
+		w.i64(6, ch.uncompressedSize)
+		w.i64(7, ch.compressedSize)
+		w.i64(9, ch.offset)
+		w.end()
+		w.end()
+	}
+	w.i64(2, total)
+	w.i64(3, int64(numRows))
+	w.end()
+
+	w.str(6, "listmonk")
+	w.end()
+
+	return w.buf.Bytes()
+}
+
+// physicalType returns the Parquet physical type of a column type and its
+// converted type, or -1 if there is none.
+func physicalType(t Type) (int32, int32) {
+	switch t {
+	case Double:
+		return typeDouble, -1
+	case Boolean:
+		return typeBoolean, -1
+	case String:
+		return typeByteArray, convertedUTF8
+	case Timestamp:
+		return typeInt64, convertedTimestampMicros
+	}
+	return typeInt64, -1
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case int64:
+		return t, nil
+	case int:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case []byte:
+		return strconv.ParseInt(string(t), 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected value %T", v)
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case []byte:
+		// NUMERIC values are returned by the driver as bytes.
+		return strconv.ParseFloat(string(t), 64)
+	}
+	return 0, fmt.Errorf("unexpected value %T", v)
+}
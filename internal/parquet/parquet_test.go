@@ -0,0 +1,161 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	var (
+		ts   = time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+		cols = []Column{
+			{Name: "id", Type: Int64},
+			{Name: "email", Type: String},
+			{Name: "enabled", Type: Boolean},
+			{Name: "created_at", Type: Timestamp},
+		}
+		rows = [][]interface{}{
+			{int64(1), "a@listmonk.app", true, ts},
+			{int64(2), []byte("b@listmonk.app"), nil, nil},
+			{nil, "c@listmonk.app", false, ts},
+		}
+	)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, cols, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatal("missing magic bytes")
+	}
+
+	// Footer.
+	l := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := readStruct(t, bytes.NewReader(b[len(b)-8-l:len(b)-8]))
+	if meta[3].(int64) != 3 {
+		t.Fatalf("expected 3 rows, got %v", meta[3])
+	}
+
+	schema := meta[2].([]interface{})
+	if len(schema) != len(cols)+1 {
+		t.Fatalf("expected %d schema elements, got %d", len(cols)+1, len(schema))
+	}
+	for i, c := range cols {
+		if name := string(schema[i+1].(map[int16]interface{})[4].([]byte)); name != c.Name {
+			t.Errorf("expected column %s, got %s", c.Name, name)
+		}
+	}
+
+	// Column values.
+	chunks := meta[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+	readCol := func(i int) ([]byte, []byte) {
+		off := chunks[i].(map[int16]interface{})[3].(map[int16]interface{})[9].(int64)
+		r := bytes.NewReader(b[off:])
+		h := readStruct(t, r)
+
+		zr, err := gzip.NewReader(io.LimitReader(r, h[3].(int64)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(page)) != h[2].(int64) {
+			t.Fatalf("expected page size %d, got %d", h[2], len(page))
+		}
+
+		// Skip the length and the header of the bit-packed run.
+		n := binary.LittleEndian.Uint32(page)
+		return page[5 : 4+n], page[4+n:]
+	}
+
+	defs, vals := readCol(0)
+	if defs[0] != 0b011 || len(vals) != 16 || binary.LittleEndian.Uint64(vals[8:]) != 2 {
+		t.Errorf("unexpected id column: %v %v", defs, vals)
+	}
+
+	defs, vals = readCol(1)
+	if defs[0] != 0b111 || !bytes.Contains(vals, []byte("b@listmonk.app")) {
+		t.Errorf("unexpected email column: %v %q", defs, vals)
+	}
+
+	defs, vals = readCol(2)
+	if defs[0] != 0b101 || len(vals) != 1 || vals[0] != 0b01 {
+		t.Errorf("unexpected enabled column: %v %v", defs, vals)
+	}
+
+	_, vals = readCol(3)
+	if int64(binary.LittleEndian.Uint64(vals)) != ts.UnixMicro() {
+		t.Errorf("unexpected created_at column: %v", vals)
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	err := Write(io.Discard, []Column{{Name: "id", Type: Int64}}, [][]interface{}{{"one"}})
+	if err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+}
+
+// readStruct reads a Thrift compact struct as a map of field IDs to values.
+func readStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	out := map[int16]interface{}{}
+
+	var id int16
+	for {
+		h, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == 0 {
+			return out
+		}
+
+		if d := int16(h >> 4); d != 0 {
+			id += d
+		} else {
+			v, _ := binary.ReadUvarint(r)
+			id = int16(v>>1) ^ -int16(v&1)
+		}
+		out[id] = readValue(t, r, h&0x0f)
+	}
+}
+
+func readValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	switch typ {
+	case ctI32, ctI64:
+		v, _ := binary.ReadUvarint(r)
+		return int64(v>>1) ^ -int64(v&1)
+
+	case ctBinary:
+		l, _ := binary.ReadUvarint(r)
+		b := make([]byte, l)
+		io.ReadFull(r, b)
+		return b
+
+	case ctList:
+		h, _ := r.ReadByte()
+		n := uint64(h >> 4)
+		if n == 15 {
+			n, _ = binary.ReadUvarint(r)
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			out[i] = readValue(t, r, h&0x0f)
+		}
+		return out
+
+	case ctStruct:
+		return readStruct(t, r)
+	}
+
+	t.Fatalf("unexpected thrift type %d", typ)
+	return nil
+}
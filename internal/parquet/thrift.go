@@ -0,0 +1,99 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol field types.
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// compactWriter writes Thrift structs in the compact protocol that Parquet
+// uses for its page headers and file metadata. Only the handful of types
+// that the metadata needs are supported.
+type compactWriter struct {
+	buf bytes.Buffer
+
+	// ID of the last field written in the current struct, and those of the
+	// enclosing structs.
+	last  int16
+	stack []int16
+}
+
+// begin begins a top level struct or a struct that's an element of a list.
+func (w *compactWriter) begin() {
+	w.stack = append(w.stack, w.last)
+	w.last = 0
+}
+
+// beginStruct begins a struct field.
+func (w *compactWriter) beginStruct(id int16) {
+	w.field(id, ctStruct)
+	w.begin()
+}
+
+// end ends the current struct.
+func (w *compactWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, ctI32)
+	w.elemI32(v)
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, ctI64)
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *compactWriter) str(id int16, s string) {
+	w.field(id, ctBinary)
+	w.elemStr(s)
+}
+
+// list writes the header of a list field of n elements of the given type.
+// The elements are written with the elem* and begin() methods.
+func (w *compactWriter) list(id int16, typ byte, n int) {
+	w.field(id, ctList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.buf.WriteByte(0xf0 | typ)
+	w.uvarint(uint64(n))
+}
+
+func (w *compactWriter) elemI32(v int32) {
+	w.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (w *compactWriter) elemStr(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// field writes a field header, as a delta from the last field ID if possible.
+func (w *compactWriter) field(id int16, typ byte) {
+	if d := id - w.last; d > 0 && d <= 15 {
+		w.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.uvarint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	w.last = id
+}
+
+func (w *compactWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf.Write(b[:n])
+}
@@ -0,0 +1,232 @@
+// Package querylog wraps the Postgres database/sql driver to time queries and
+// log the ones that take longer than a threshold along with the name of the
+// query from the queries file. This helps diagnose slow queries on large
+// instances without having to enable logging on the DB server.
+package querylog
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxQueryLen is the length to which unnamed (dynamically generated)
+// queries are truncated in the log.
+const maxQueryLen = 200
+
+// QueryLog logs slow queries.
+type QueryLog struct {
+	threshold time.Duration
+	log       *log.Logger
+
+	// SQL query string -> query name.
+	names map[string]string
+	mu    sync.RWMutex
+
+	numSlow uint64
+}
+
+// New returns a new QueryLog that logs queries that take longer than threshold.
+// A threshold of 0 disables logging.
+func New(threshold time.Duration, lo *log.Logger) *QueryLog {
+	return &QueryLog{
+		threshold: threshold,
+		log:       lo,
+		names:     make(map[string]string),
+	}
+}
+
+// SetNames sets the SQL query -> name map that's used to identify queries in the log.
+func (q *QueryLog) SetNames(names map[string]string) {
+	q.mu.Lock()
+	q.names = names
+	q.mu.Unlock()
+}
+
+// NumSlow returns the number of slow queries logged since the start.
+func (q *QueryLog) NumSlow() uint64 {
+	return atomic.LoadUint64(&q.numSlow)
+}
+
+// Connector returns a driver.Connector for the given Postgres DSN that
+// times and logs slow queries.
+func (q *QueryLog) Connector(dsn string) (driver.Connector, error) {
+	c, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector{Connector: c, ql: q}, nil
+}
+
+// observe logs a query if it took longer than the threshold.
+func (q *QueryLog) observe(query string, start time.Time) {
+	if q.threshold <= 0 {
+		return
+	}
+
+	d := time.Since(start)
+	if d < q.threshold {
+		return
+	}
+	atomic.AddUint64(&q.numSlow, 1)
+
+	q.mu.RLock()
+	name, ok := q.names[query]
+	q.mu.RUnlock()
+
+	if !ok {
+		name = strings.Join(strings.Fields(query), " ")
+		if len(name) > maxQueryLen {
+			name = name[:maxQueryLen] + "..."
+		}
+	}
+
+	q.log.Printf("slow query (%s): %s", d.Round(time.Millisecond), name)
+}
+
+type connector struct {
+	driver.Connector
+	ql *QueryLog
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: cn, ql: c.ql}, nil
+}
+
+// conn wraps a pq connection and times the queries executed on it.
+type conn struct {
+	driver.Conn
+	ql *QueryLog
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		st  driver.Stmt
+		err error
+	)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{Stmt: st, query: query, ql: c.ql}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := qc.QueryContext(ctx, query, args)
+	c.ql.observe(query, start)
+
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	res, err := ec.ExecContext(ctx, query, args)
+	c.ql.observe(query, start)
+
+	return res, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return v.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// stmt wraps a prepared statement and times its execution.
+type stmt struct {
+	driver.Stmt
+	query string
+	ql    *QueryLog
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	defer s.ql.observe(s.query, start)
+
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+
+	return s.Stmt.Exec(namedToValues(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	defer s.ql.observe(s.query, start)
+
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+
+	return s.Stmt.Query(namedToValues(args))
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return v.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedToValues(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
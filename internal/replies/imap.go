@@ -0,0 +1,230 @@
+package replies
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient is a minimal IMAP4rev1 client that implements the few commands
+// required to fetch and flag unread messages in a folder.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	opt  Opt
+}
+
+// imapResp is an untagged response line with the literals ({n}) in it.
+type imapResp struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(o Opt) (*imapClient, error) {
+	addr := net.JoinHostPort(o.Host, strconv.Itoa(o.Port))
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	d := &net.Dialer{Timeout: o.Timeout}
+	if o.TLSEnabled {
+		conn, err = tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+			ServerName:         o.Host,
+			InsecureSkipVerify: o.TLSSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		})
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), opt: o}
+	c.conn.SetDeadline(time.Now().Add(o.Timeout))
+
+	// Greeting.
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", strings.TrimSpace(line))
+	}
+
+	return c, nil
+}
+
+// Login authenticates with the server, selects the folder, and returns its
+// UIDVALIDITY.
+func (c *imapClient) Login(user, pass, folder string) (string, error) {
+	if _, err := c.cmd("LOGIN " + quote(user) + " " + quote(pass)); err != nil {
+		return "", fmt.Errorf("login failed: %v", err)
+	}
+
+	res, err := c.cmd("SELECT " + quote(folder))
+	if err != nil {
+		return "", fmt.Errorf("error selecting %s: %v", folder, err)
+	}
+
+	for _, r := range res {
+		if i := strings.Index(r.line, "[UIDVALIDITY "); i > -1 {
+			v := r.line[i+13:]
+			if j := strings.IndexByte(v, ']'); j > -1 {
+				return v[:j], nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// Unseen returns the UIDs, starting from a UID, of the unread messages in
+// the folder in ascending order.
+func (c *imapClient) Unseen(from int) ([]int, error) {
+	res, err := c.cmd("UID SEARCH UNSEEN UID " + strconv.Itoa(from) + ":*")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []int
+	for _, r := range res {
+		if !strings.HasPrefix(r.line, "* SEARCH") {
+			continue
+		}
+		for _, f := range strings.Fields(r.line[8:]) {
+			// n:* always matches the last message even if its UID is < n.
+			if uid, err := strconv.Atoi(f); err == nil && uid >= from {
+				out = append(out, uid)
+			}
+		}
+	}
+	sort.Ints(out)
+
+	return out, nil
+}
+
+// Fetch returns the raw message of a UID without marking it as read.
+func (c *imapClient) Fetch(uid int) ([]byte, error) {
+	res, err := c.cmd("UID FETCH " + strconv.Itoa(uid) + " (BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range res {
+		if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+// MarkSeen flags a message as read.
+func (c *imapClient) MarkSeen(uid int) error {
+	_, err := c.cmd("UID STORE " + strconv.Itoa(uid) + ` +FLAGS.SILENT (\Seen)`)
+	return err
+}
+
+// Close logs out and closes the connection.
+func (c *imapClient) Close() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
+
+// cmd sends a tagged command and returns its untagged responses if it
+// completed with OK.
+func (c *imapClient) cmd(cmd string) ([]imapResp, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%03d", c.tag)
+
+	c.conn.SetDeadline(time.Now().Add(c.opt.Timeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var out []imapResp
+	for {
+		r, err := c.readResp()
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(r.line, tag+" ") {
+			out = append(out, r)
+			continue
+		}
+
+		status := strings.TrimPrefix(r.line, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("%s", status)
+		}
+		return out, nil
+	}
+}
+
+// readResp reads a response line along with the literals ({n}\r\n followed
+// by n bytes) in it.
+func (c *imapClient) readResp() (imapResp, error) {
+	var (
+		r imapResp
+		b strings.Builder
+	)
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return r, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		n, ok := literalSize(line)
+		if !ok {
+			b.WriteString(line)
+			r.line = b.String()
+			return r, nil
+		}
+
+		if n > maxMessageSize {
+			return r, fmt.Errorf("message size %d exceeds the limit of %d", n, maxMessageSize)
+		}
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, lit)
+		b.WriteString(line)
+	}
+}
+
+// literalSize returns the size n of a line that ends in a literal, {n}.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	i := strings.LastIndexByte(line, '{')
+	if i < 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(line[i+1 : len(line)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// quote returns an IMAP quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,232 @@
+package replies
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxMessageSize = 10 << 20
+
+	// maxBodySize is the maximum size of the text of a reply that's stored.
+	maxBodySize = 10000
+
+	// maxPartDepth is the maximum depth of nested multipart messages that
+	// are looked into for the text.
+	maxPartDepth = 5
+)
+
+var (
+	// reMessageID matches the Message-IDs of campaign messages,
+	// <{campaign_uuid}.{subscriber_uuid}.{random}@domain>.
+	reMessageID = regexp.MustCompile(`(?i)<([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\.([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\.[0-9a-f]+@[^>]+>`)
+
+	reTags   = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]+>`)
+	reSpaces = regexp.MustCompile(`\n\s*\n\s*\n+`)
+
+	wordDecoder = &mime.WordDecoder{
+		// Charsets other than UTF-8 and ASCII are decoded as is and made valid UTF-8.
+		CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
+			return r, nil
+		},
+	}
+)
+
+// reply is a reply parsed from an e-mail.
+type reply struct {
+	CampaignUUID   string
+	SubscriberUUID string
+	MessageID      string
+	From           string
+	Subject        string
+	Body           string
+}
+
+// parse parses a raw e-mail and returns the reply if it references the
+// Message-ID of a campaign message. Automatic replies (out-of-office,
+// bounces, and other machine generated messages) are ignored.
+func parse(raw []byte) (reply, bool) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return reply{}, false
+	}
+	h := msg.Header
+
+	if isAutomatic(h) {
+		return reply{}, false
+	}
+
+	campUUID, subUUID, ok := matchMessageID(h)
+	if !ok {
+		return reply{}, false
+	}
+
+	r := reply{
+		CampaignUUID:   campUUID,
+		SubscriberUUID: subUUID,
+		MessageID:      strings.TrimSpace(h.Get("Message-Id")),
+		Subject:        decodeHeader(h.Get("Subject")),
+	}
+
+	// Replies without a Message-ID are deduplicated on their hash.
+	if r.MessageID == "" {
+		sum := sha256.Sum256(raw)
+		r.MessageID = "<" + hex.EncodeToString(sum[:16]) + "@listmonk>"
+	}
+
+	if a, err := mail.ParseAddress(decodeHeader(h.Get("From"))); err == nil {
+		r.From = a.Address
+	} else {
+		r.From = decodeHeader(h.Get("From"))
+	}
+
+	body, isHTML := textBody(h.Get("Content-Type"), h.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	if isHTML {
+		body = htmlToText(body)
+	}
+	r.Body = cleanText(body)
+
+	return r, true
+}
+
+// matchMessageID returns the campaign and subscriber UUIDs from the
+// campaign Message-ID referenced by In-Reply-To, or the last one referenced
+// by References.
+func matchMessageID(h mail.Header) (string, string, bool) {
+	if m := reMessageID.FindStringSubmatch(h.Get("In-Reply-To")); m != nil {
+		return strings.ToLower(m[1]), strings.ToLower(m[2]), true
+	}
+
+	if m := reMessageID.FindAllStringSubmatch(h.Get("References"), -1); len(m) > 0 {
+		l := m[len(m)-1]
+		return strings.ToLower(l[1]), strings.ToLower(l[2]), true
+	}
+
+	return "", "", false
+}
+
+// isAutomatic checks whether a message was generated automatically, eg:
+// out-of-office auto-responses, vacation messages, and delivery reports.
+func isAutomatic(h mail.Header) bool {
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+	if h.Get("X-Autoreply") != "" || h.Get("X-Autorespond") != "" || h.Get("X-Auto-Response-Suppress") == "All" {
+		return true
+	}
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+
+	if strings.TrimSpace(h.Get("Return-Path")) == "<>" {
+		return true
+	}
+
+	if t, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && t == "multipart/report" {
+		return true
+	}
+
+	from := strings.ToLower(h.Get("From"))
+	return strings.Contains(from, "mailer-daemon@") || strings.Contains(from, "postmaster@")
+}
+
+// textBody returns the text of a message or a part, preferring text/plain
+// over text/html in multipart messages.
+func textBody(contentType, encoding string, r io.Reader, depth int) (string, bool) {
+	typ, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		typ = "text/plain"
+	}
+
+	if strings.HasPrefix(typ, "multipart/") {
+		if depth >= maxPartDepth || params["boundary"] == "" {
+			return "", false
+		}
+
+		var (
+			htmlBody string
+			mr       = multipart.NewReader(r, params["boundary"])
+		)
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(strings.ToLower(p.Header.Get("Content-Disposition")), "attachment") {
+				continue
+			}
+
+			body, isHTML := textBody(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, depth+1)
+			if body == "" {
+				continue
+			}
+			if !isHTML {
+				return body, false
+			}
+			if htmlBody == "" {
+				htmlBody = body
+			}
+		}
+
+		return htmlBody, htmlBody != ""
+	}
+
+	if typ != "text/plain" && typ != "text/html" {
+		return "", false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(r, maxMessageSize))
+	return string(b), typ == "text/html"
+}
+
+// htmlToText strips the tags in an HTML body.
+func htmlToText(s string) string {
+	s = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n\n", "</div>", "\n").Replace(s)
+	return html.UnescapeString(reTags.ReplaceAllString(s, ""))
+}
+
+// cleanText makes a text valid UTF-8, removes excess blank lines, and
+// truncates it to maxBodySize.
+func cleanText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\x00", "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSpace(reSpaces.ReplaceAllString(s, "\n\n"))
+
+	if len(s) > maxBodySize {
+		s = s[:maxBodySize]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+
+	return s
+}
+
+// decodeHeader decodes RFC 2047 encoded words in a header.
+func decodeHeader(s string) string {
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		s = d
+	}
+	return strings.ToValidUTF8(strings.TrimSpace(s), "")
+}
@@ -0,0 +1,246 @@
+// Package replies watches an IMAP mailbox for human replies to campaign
+// messages. Campaign messages carry Message-IDs with the campaign and
+// subscriber UUIDs, which replies reference in their In-Reply-To and
+// References headers. Replies are recorded against the campaign and the
+// subscriber, and optionally forwarded to e-mail addresses and a webhook.
+// Automatic replies (out-of-office, bounces) are ignored.
+package replies
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	defaultFolder       = "INBOX"
+	defaultScanInterval = time.Minute * 5
+	defaultMaxMessages  = 500
+	defaultTimeout      = time.Second * 30
+)
+
+// Opt is the config of the reply mailbox.
+type Opt struct {
+	Host          string `koanf:"host"`
+	Port          int    `koanf:"port"`
+	Username      string `koanf:"username"`
+	Password      string `koanf:"password"`
+	Folder        string `koanf:"folder"`
+	TLSEnabled    bool   `koanf:"tls_enabled"`
+	TLSSkipVerify bool   `koanf:"tls_skip_verify"`
+
+	ScanInterval time.Duration `koanf:"scan_interval"`
+	Timeout      time.Duration `koanf:"timeout"`
+
+	// MaxMessages is the maximum number of unread messages fetched in a scan.
+	MaxMessages int `koanf:"max_messages"`
+
+	// Replies are forwarded to these e-mail addresses and POSTed to the
+	// webhook as JSON, if they're set.
+	ForwardEmails  []string `koanf:"forward_emails"`
+	ForwardWebhook string   `koanf:"forward_webhook"`
+}
+
+// Hooks are the callbacks with which replies are recorded and forwarded.
+type Hooks struct {
+	// Record records a reply and returns it with the campaign and subscriber
+	// details. ok is false if the campaign or the subscriber doesn't exist,
+	// or if the reply was already recorded.
+	Record func(r models.CampaignReply) (out models.CampaignReply, ok bool, err error)
+
+	// ForwardEmail forwards a recorded reply to e-mail addresses.
+	ForwardEmail func(to []string, r models.CampaignReply) error
+
+	// IsLeader, if set, is checked before every scan so that only one of
+	// multiple instances sharing the DB scans the mailbox.
+	IsLeader func() bool
+}
+
+// Watcher scans the mailbox for replies.
+type Watcher struct {
+	opt   Opt
+	h     Hooks
+	log   *log.Logger
+	httpc *http.Client
+
+	// UIDs up to lastUID in the folder with the UIDVALIDITY uidValidity
+	// have already been scanned. Messages that aren't replies are left
+	// unread for the mailbox's other readers and aren't fetched again.
+	uidValidity string
+	lastUID     int
+	mu          sync.Mutex
+}
+
+// New returns a new instance of the reply watcher.
+func New(o Opt, h Hooks, lo *log.Logger) (*Watcher, error) {
+	if o.Host == "" {
+		return nil, fmt.Errorf("reply mailbox host is not set")
+	}
+	if h.Record == nil {
+		return nil, fmt.Errorf("reply record hook is not set")
+	}
+	if o.Port == 0 {
+		o.Port = 143
+		if o.TLSEnabled {
+			o.Port = 993
+		}
+	}
+	if o.Folder == "" {
+		o.Folder = defaultFolder
+	}
+	if o.ScanInterval < time.Second*10 {
+		o.ScanInterval = defaultScanInterval
+	}
+	if o.Timeout < time.Second {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxMessages < 1 {
+		o.MaxMessages = defaultMaxMessages
+	}
+
+	return &Watcher{
+		opt:   o,
+		h:     h,
+		log:   lo,
+		httpc: &http.Client{Timeout: o.Timeout},
+	}, nil
+}
+
+// Run is a blocking function that scans the mailbox at the configured interval.
+func (w *Watcher) Run() {
+	for {
+		if w.h.IsLeader == nil || w.h.IsLeader() {
+			if n, err := w.Scan(); err != nil {
+				w.log.Printf("error scanning reply mailbox: %v", err)
+			} else if n > 0 {
+				w.log.Printf("recorded %d campaign replies", n)
+			}
+		}
+
+		time.Sleep(w.opt.ScanInterval)
+	}
+}
+
+// Scan fetches the unread messages in the mailbox that haven't been scanned
+// yet, records the replies to campaign messages and marks them as read, and
+// forwards them. It returns the number of replies recorded.
+func (w *Watcher) Scan() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c, err := dialIMAP(w.opt)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	validity, err := c.Login(w.opt.Username, w.opt.Password, w.opt.Folder)
+	if err != nil {
+		return 0, err
+	}
+	if validity != w.uidValidity {
+		w.uidValidity, w.lastUID = validity, 0
+	}
+
+	uids, err := c.Unseen(w.lastUID + 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(uids) > w.opt.MaxMessages {
+		uids = uids[:w.opt.MaxMessages]
+	}
+
+	num := 0
+	for _, uid := range uids {
+		raw, err := c.Fetch(uid)
+		if err != nil {
+			return num, err
+		}
+		w.lastUID = uid
+
+		r, ok := parse(raw)
+		if !ok {
+			continue
+		}
+
+		out, ok, err := w.h.Record(models.CampaignReply{
+			CampaignUUID:   r.CampaignUUID,
+			SubscriberUUID: r.SubscriberUUID,
+			MessageID:      r.MessageID,
+			FromEmail:      r.From,
+			Subject:        r.Subject,
+			Body:           r.Body,
+		})
+		if err != nil {
+			return num, err
+		}
+
+		if err := c.MarkSeen(uid); err != nil {
+			return num, err
+		}
+		if !ok {
+			continue
+		}
+		num++
+
+		w.forward(out)
+	}
+
+	return num, nil
+}
+
+// forward forwards a reply to the configured e-mail addresses and webhook.
+func (w *Watcher) forward(r models.CampaignReply) {
+	if len(w.opt.ForwardEmails) > 0 && w.h.ForwardEmail != nil {
+		if err := w.h.ForwardEmail(w.opt.ForwardEmails, r); err != nil {
+			w.log.Printf("error forwarding reply %s: %v", r.MessageID, err)
+		}
+	}
+
+	if w.opt.ForwardWebhook != "" {
+		if err := w.postWebhook(r); err != nil {
+			w.log.Printf("error posting reply %s to webhook: %v", r.MessageID, err)
+		}
+	}
+}
+
+func (w *Watcher) postWebhook(r models.CampaignReply) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.httpc.Post(w.opt.ForwardWebhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// ForwardBody returns the text body of a reply that's forwarded by e-mail.
+func ForwardBody(r models.CampaignReply) string {
+	var b strings.Builder
+	b.WriteString("From: " + r.FromEmail + "\n")
+	b.WriteString("Subject: " + r.Subject + "\n")
+	b.WriteString("Campaign: " + r.CampaignName + " (" + strconv.Itoa(r.CampaignID) + ")\n")
+	b.WriteString("Subscriber: " + r.SubscriberEmail + " (" + strconv.Itoa(r.SubscriberID) + ")\n\n")
+	b.WriteString(r.Body)
+	return b.String()
+}
@@ -0,0 +1,268 @@
+package replies
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	campUUID = "2e6b3b47-5fb5-4b1b-a7d2-0b8a8c8f1f6e"
+	subUUID  = "ef1a4b66-4d7d-4a8b-b6a1-98f4a2a3ab45"
+	msgID    = "<" + campUUID + "." + subUUID + ".a1b2c3d4e5f60718@listmonk.app>"
+)
+
+func TestParse(t *testing.T) {
+	raw := "From: =?utf-8?q?J=C3=B6hn?= <John@example.com>\r\n" +
+		"To: news@listmonk.app\r\n" +
+		"Subject: Re: =?utf-8?q?Caf=C3=A9?= news\r\n" +
+		"Message-Id: <reply-1@example.com>\r\n" +
+		"In-Reply-To: " + msgID + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b1\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Hello <b>there</b></p>\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Thanks, that's caf=C3=A9 news!\r\n" +
+		"--b1--\r\n"
+
+	r, ok := parse([]byte(raw))
+	if !ok {
+		t.Fatal("reply not matched")
+	}
+	if r.CampaignUUID != campUUID || r.SubscriberUUID != subUUID {
+		t.Errorf("unexpected UUIDs: %s %s", r.CampaignUUID, r.SubscriberUUID)
+	}
+	if r.MessageID != "<reply-1@example.com>" || r.From != "John@example.com" || r.Subject != "Re: Café news" {
+		t.Errorf("unexpected headers: %+v", r)
+	}
+	if r.Body != "Thanks, that's café news!" {
+		t.Errorf("unexpected body: %q", r.Body)
+	}
+}
+
+func TestParseHTMLReferences(t *testing.T) {
+	other := "<" + subUUID + "." + campUUID + ".ff@listmonk.app>"
+	raw := "From: jane@example.com\r\n" +
+		"Subject: Re: news\r\n" +
+		"References: <unrelated@example.com> " + other + "\r\n " + strings.ToUpper(msgID) + "\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"PHN0eWxlPnB7fTwvc3R5bGU+PHA+U291bmRzIGdvb2QgJmFtcDsgZmluZTwvcD4=\r\n"
+
+	r, ok := parse([]byte(raw))
+	if !ok {
+		t.Fatal("reply not matched")
+	}
+	if r.CampaignUUID != campUUID || r.SubscriberUUID != subUUID {
+		t.Errorf("the last reference wasn't matched: %s %s", r.CampaignUUID, r.SubscriberUUID)
+	}
+	if r.Body != "Sounds good & fine" {
+		t.Errorf("unexpected body: %q", r.Body)
+	}
+	if !strings.HasSuffix(r.MessageID, "@listmonk>") {
+		t.Errorf("expected a generated Message-ID, got %s", r.MessageID)
+	}
+}
+
+func TestParseIgnored(t *testing.T) {
+	for name, hdr := range map[string]string{
+		"auto-submitted": "Auto-Submitted: auto-replied\r\nIn-Reply-To: " + msgID,
+		"precedence":     "Precedence: bulk\r\nIn-Reply-To: " + msgID,
+		"x-autoreply":    "X-Autoreply: yes\r\nIn-Reply-To: " + msgID,
+		"null sender":    "Return-Path: <>\r\nIn-Reply-To: " + msgID,
+		"report":         "Content-Type: multipart/report; report-type=delivery-status; boundary=x\r\nIn-Reply-To: " + msgID,
+		"no reference":   "In-Reply-To: <something@example.com>",
+	} {
+		raw := "From: jane@example.com\r\nSubject: Re: news\r\n" + hdr + "\r\n\r\nhello\r\n"
+		if _, ok := parse([]byte(raw)); ok {
+			t.Errorf("%s: message should've been ignored", name)
+		}
+	}
+
+	raw := "From: MAILER-DAEMON@mx.example.com\r\nIn-Reply-To: " + msgID + "\r\n\r\nundeliverable\r\n"
+	if _, ok := parse([]byte(raw)); ok {
+		t.Error("mailer-daemon message should've been ignored")
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	s := cleanText("a\r\n\r\n\r\n\r\nb\x00" + strings.Repeat("é", maxBodySize))
+	if !strings.HasPrefix(s, "a\n\nb") || len(s) > maxBodySize || !utf8Valid(s) {
+		t.Errorf("unexpected text: %q...", s[:10])
+	}
+}
+
+func utf8Valid(s string) bool {
+	return strings.ToValidUTF8(s, "") == s
+}
+
+// fakeIMAP is an IMAP server with a fixed set of messages that records the
+// UIDs that are flagged as seen.
+type fakeIMAP struct {
+	ln   net.Listener
+	msgs map[int]string
+
+	mu   sync.Mutex
+	seen map[int]bool
+}
+
+func newFakeIMAP(t *testing.T, msgs map[int]string) *fakeIMAP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeIMAP{ln: ln, msgs: msgs, seen: map[int]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeIMAP) serve(c net.Conn) {
+	defer c.Close()
+
+	io.WriteString(c, "* OK IMAP4rev1 ready\r\n")
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		tag, cmd := f[0], strings.ToUpper(f[1])
+
+		switch {
+		case cmd == "LOGIN":
+			if f[2] != `"user"` || f[3] != `"p\"ass"` {
+				io.WriteString(c, tag+" NO [AUTHENTICATIONFAILED] invalid credentials\r\n")
+				continue
+			}
+
+		case cmd == "SELECT":
+			io.WriteString(c, "* 3 EXISTS\r\n* OK [UIDVALIDITY 42] UIDs valid\r\n")
+
+		case cmd == "UID" && f[2] == "SEARCH":
+			from, _ := strconv.Atoi(strings.TrimSuffix(f[5], ":*"))
+			s.mu.Lock()
+			var uids []string
+			for uid := range s.msgs {
+				if !s.seen[uid] && uid >= from {
+					uids = append(uids, strconv.Itoa(uid))
+				}
+			}
+			s.mu.Unlock()
+			io.WriteString(c, "* SEARCH "+strings.Join(uids, " ")+"\r\n")
+
+		case cmd == "UID" && f[2] == "FETCH":
+			uid, _ := strconv.Atoi(f[3])
+			m := s.msgs[uid]
+			fmt.Fprintf(c, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, uid, len(m), m)
+
+		case cmd == "UID" && f[2] == "STORE":
+			uid, _ := strconv.Atoi(f[3])
+			s.mu.Lock()
+			s.seen[uid] = true
+			s.mu.Unlock()
+
+		case cmd == "LOGOUT":
+			io.WriteString(c, "* BYE\r\n"+tag+" OK LOGOUT completed\r\n")
+			return
+		}
+
+		io.WriteString(c, tag+" OK completed\r\n")
+	}
+}
+
+func TestScan(t *testing.T) {
+	reply := "From: jane@example.com\r\nSubject: Re: news\r\nMessage-Id: <r1@example.com>\r\nIn-Reply-To: " + msgID + "\r\n\r\nHi!\r\n"
+	s := newFakeIMAP(t, map[int]string{
+		1: reply,
+		2: "From: bob@example.com\r\nSubject: Hello\r\n\r\nNot a reply\r\n",
+		3: strings.Replace(reply, "<r1@", "<r2@", 1),
+	})
+	defer s.ln.Close()
+
+	var (
+		recorded []models.CampaignReply
+		fwd      []string
+	)
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	w, err := New(Opt{
+		Host:          host,
+		Port:          p,
+		Username:      "user",
+		Password:      `p"ass`,
+		Timeout:       time.Second * 5,
+		ForwardEmails: []string{"team@listmonk.app"},
+	}, Hooks{
+		Record: func(r models.CampaignReply) (models.CampaignReply, bool, error) {
+			recorded = append(recorded, r)
+			// The second reply is a duplicate.
+			return r, r.MessageID == "<r1@example.com>", nil
+		},
+		ForwardEmail: func(to []string, r models.CampaignReply) error {
+			fwd = append(fwd, r.MessageID)
+			return nil
+		},
+	}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(recorded) != 2 || len(fwd) != 1 || fwd[0] != "<r1@example.com>" {
+		t.Errorf("unexpected scan: n=%d recorded=%v forwarded=%v", n, recorded, fwd)
+	}
+	if recorded[0].CampaignUUID != campUUID || recorded[0].Body != "Hi!" {
+		t.Errorf("unexpected reply: %+v", recorded[0])
+	}
+
+	s.mu.Lock()
+	if !s.seen[1] || s.seen[2] || !s.seen[3] {
+		t.Errorf("only replies should be marked seen: %v", s.seen)
+	}
+	s.mu.Unlock()
+
+	// Messages that were already scanned aren't fetched again.
+	if n, err := w.Scan(); err != nil || n != 0 || len(recorded) != 2 {
+		t.Errorf("unexpected rescan: n=%d err=%v recorded=%d", n, err, len(recorded))
+	}
+	if w.lastUID != 3 {
+		t.Errorf("expected last UID 3, got %d", w.lastUID)
+	}
+
+	w.opt.Password = "wrong"
+	if _, err := w.Scan(); err == nil || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("expected login error, got %v", err)
+	}
+}
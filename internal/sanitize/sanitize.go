@@ -0,0 +1,163 @@
+// Package sanitize sanitizes subscriber-provided data (names and attributes)
+// before it's exposed to campaign and transactional templates, so that data
+// submitted by anyone on public forms can't inject HTML or template
+// expressions into messages that render it raw (eg: with Safe) or in
+// contexts that aren't escaped, like subjects and plain text bodies.
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// ModeStrip strips HTML tags from values.
+	ModeStrip = "strip"
+
+	// ModeEscape keeps HTML in values as text that's escaped by the HTML
+	// templates' contextual escaping. Values aren't escaped here as that
+	// would escape them twice in HTML bodies, eg: & as &amp;amp;.
+	ModeEscape = "escape"
+
+	// FieldName is the subscriber's name in Policy.RawFields. Attributes
+	// are attribs.<key>.
+	FieldName    = "name"
+	fieldAttribs = "attribs."
+)
+
+var (
+	// HTML tags, comments, and unterminated tags, eg: <b>, </p>, <!-- -->, <img src=x.
+	reTag = regexp.MustCompile(`(?s)<!--.*?(-->|$)|</?[a-zA-Z!][^>]*(>|$)`)
+
+	// Template delimiters.
+	tplDelims = strings.NewReplacer("{{", "", "}}", "")
+)
+
+// Policy is the sanitization policy of subscriber data.
+type Policy struct {
+	Enabled bool
+
+	// Mode is how HTML in values is handled: strip or escape.
+	Mode string
+
+	// MaxNameLen and MaxAttribLen are the maximum lengths (in characters)
+	// of the name and of string attribute values. Longer values are
+	// truncated. 0 disables the limit.
+	MaxNameLen   int
+	MaxAttribLen int
+
+	// AllowedAttribs are the top level attribute keys that are exposed to
+	// templates. All attributes are exposed if it's empty.
+	AllowedAttribs []string
+
+	// RawFields are the fields that are exposed as-is: name, or
+	// attribs.<key> for a top level attribute.
+	RawFields []string
+}
+
+// Sanitizer sanitizes subscriber data with a policy.
+type Sanitizer struct {
+	p       Policy
+	allowed map[string]bool
+	raw     map[string]bool
+}
+
+// New returns a new Sanitizer. A nil Sanitizer, returned when the policy is
+// disabled, leaves data untouched.
+func New(p Policy) *Sanitizer {
+	if !p.Enabled {
+		return nil
+	}
+	if p.Mode != ModeEscape {
+		p.Mode = ModeStrip
+	}
+
+	s := &Sanitizer{p: p, raw: make(map[string]bool, len(p.RawFields))}
+	for _, f := range p.RawFields {
+		s.raw[strings.TrimSpace(f)] = true
+	}
+	if len(p.AllowedAttribs) > 0 {
+		s.allowed = make(map[string]bool, len(p.AllowedAttribs))
+		for _, k := range p.AllowedAttribs {
+			s.allowed[strings.TrimSpace(k)] = true
+		}
+	}
+
+	return s
+}
+
+// Subscriber returns a copy of a subscriber with the name and attributes
+// sanitized. The original attributes aren't modified.
+func (s *Sanitizer) Subscriber(sub models.Subscriber) models.Subscriber {
+	if s == nil {
+		return sub
+	}
+
+	if !s.raw[FieldName] {
+		sub.Name = s.String(sub.Name, s.p.MaxNameLen)
+	}
+
+	if sub.Attribs != nil {
+		attribs := make(models.JSON, len(sub.Attribs))
+		for k, v := range sub.Attribs {
+			if s.allowed != nil && !s.allowed[k] {
+				continue
+			}
+			if s.raw[fieldAttribs+k] {
+				attribs[k] = v
+				continue
+			}
+			attribs[k] = s.value(v)
+		}
+		sub.Attribs = attribs
+	}
+
+	return sub
+}
+
+// String sanitizes a string value and truncates it to maxLen characters.
+// Template delimiters are always removed.
+func (s *Sanitizer) String(v string, maxLen int) string {
+	if s == nil {
+		return v
+	}
+
+	if s.p.Mode != ModeEscape {
+		v = reTag.ReplaceAllString(v, "")
+	}
+	v = tplDelims.Replace(v)
+
+	if maxLen > 0 && utf8.RuneCountInString(v) > maxLen {
+		v = string([]rune(v)[:maxLen])
+	}
+
+	return v
+}
+
+// value sanitizes the strings in an attribute value, recursing into nested
+// objects and arrays.
+func (s *Sanitizer) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return s.String(t, s.p.MaxAttribLen)
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[s.String(k, 0)] = s.value(val)
+		}
+		return out
+
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = s.value(val)
+		}
+		return out
+	}
+
+	return v
+}
@@ -0,0 +1,79 @@
+package sanitize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestSubscriber(t *testing.T) {
+	sub := models.Subscriber{
+		Name: `John <script>alert(1)</script>{{ .Campaign.UUID }} Doe`,
+		Attribs: models.JSON{
+			"city":    "<b>Berlin</b>",
+			"bio":     "<p>Hello</p>",
+			"secret":  "hidden",
+			"age":     float64(30),
+			"address": map[string]interface{}{"street": "<i>Main</i> St.", "tags": []interface{}{"<u>a</u>", "b"}},
+		},
+	}
+
+	s := New(Policy{
+		Enabled:        true,
+		Mode:           ModeStrip,
+		MaxNameLen:     8,
+		AllowedAttribs: []string{"city", "bio", "age", "address"},
+		RawFields:      []string{"attribs.bio"},
+	})
+	out := s.Subscriber(sub)
+
+	if out.Name != "John ale" {
+		t.Errorf("unexpected name: %q", out.Name)
+	}
+
+	exp := models.JSON{
+		"city":    "Berlin",
+		"bio":     "<p>Hello</p>",
+		"age":     float64(30),
+		"address": map[string]interface{}{"street": "Main St.", "tags": []interface{}{"a", "b"}},
+	}
+	if !reflect.DeepEqual(out.Attribs, exp) {
+		t.Errorf("unexpected attribs: %v", out.Attribs)
+	}
+
+	// The original subscriber isn't modified.
+	if sub.Attribs["city"] != "<b>Berlin</b>" {
+		t.Error("original attribs modified")
+	}
+}
+
+func TestString(t *testing.T) {
+	// Escaped values are left to the templates' escaping, but template
+	// delimiters are removed and values are truncated.
+	esc := New(Policy{Enabled: true, Mode: ModeEscape})
+	if v := esc.String(`<a href="x">Tom & Jerry</a>{{ .X }}`, 0); v != `<a href="x">Tom & Jerry</a> .X ` {
+		t.Errorf("unexpected escaped value: %q", v)
+	}
+	if v := esc.String("Tom & Jerry", 6); v != "Tom & " {
+		t.Errorf("unexpected truncated value: %q", v)
+	}
+
+	strip := New(Policy{Enabled: true})
+	for in, exp := range map[string]string{
+		"a < b > c":             "a < b > c",
+		"x <img src=x onerror=": "x ",
+		"<!-- c -->text":        "text",
+		"{<b></b>{ .X }}":       " .X ",
+	} {
+		if v := strip.String(in, 0); v != exp {
+			t.Errorf("String(%q) = %q, want %q", in, v, exp)
+		}
+	}
+
+	// Disabled policies leave data untouched.
+	off := New(Policy{})
+	if v := off.String("<b>x</b>", 1); v != "<b>x</b>" {
+		t.Errorf("disabled sanitizer modified value: %q", v)
+	}
+}
@@ -0,0 +1,287 @@
+// Package seal encrypts and decrypts secret values (passwords, API keys) that
+// are stored at rest in the DB with AES-256-GCM. The key is derived from the
+// key string with Argon2id and a random salt that's stored with every value.
+// Encrypted values are strings prefixed with a format version marker so that
+// they can be told apart from plaintext values that are yet to be encrypted.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Prefix is the marker prepended to encrypted values. The format is
+// enc:v2:base64(salt + nonce + ciphertext).
+const Prefix = "enc:v2:"
+
+// prefixV1 is the marker of values encrypted with the SHA-256 hash of the key
+// string (enc:v1:base64(nonce + ciphertext)). They're decrypted, but never
+// encrypted, and are re-encrypted in the current format when the secrets are
+// re-encrypted.
+const prefixV1 = "enc:v1:"
+
+// minKeyLen is the minimum length of the key string.
+const minKeyLen = 16
+
+// Argon2id parameters (RFC 9106, second recommended option).
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+	saltLen    = 16
+)
+
+// ErrNoKey is returned when an encrypted value is decrypted without a key.
+var ErrNoKey = errors.New("value is encrypted but no encryption key is configured")
+
+// Seal encrypts and decrypts values with a key. A nil *Seal is valid and
+// represents the absence of a key where values are stored as plaintext.
+type Seal struct {
+	key *key
+
+	// Old keys that values are tried against when they can't be
+	// decrypted with the key, for rotating keys.
+	old []*key
+}
+
+// key is a key string and the ciphers derived from it.
+type key struct {
+	str string
+
+	// The salt and cipher that values are encrypted with. Deriving keys is
+	// deliberately slow, so one salt is used for all the values encrypted
+	// in the lifetime of the key.
+	salt []byte
+	aead cipher.AEAD
+
+	// Ciphers derived with the salts of decrypted values (salt => cipher)
+	// and the legacy (v1) cipher.
+	derived map[string]cipher.AEAD
+	v1      cipher.AEAD
+	mu      sync.Mutex
+}
+
+// New returns a Seal for the given key string.
+func New(k string) (*Seal, error) {
+	key, err := newKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Seal{key: key}, nil
+}
+
+// AddOldKey adds an old key that values are decrypted with if they can't be
+// decrypted with the current key. Values are always encrypted with the
+// current key.
+func (s *Seal) AddOldKey(k string) error {
+	key, err := newKey(k)
+	if err != nil {
+		return err
+	}
+
+	s.old = append(s.old, key)
+	return nil
+}
+
+// IsEncrypted checks whether a value is encrypted.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, Prefix) || strings.HasPrefix(s, prefixV1)
+}
+
+// Encrypt encrypts a value. Empty and already encrypted values, and all
+// values when there's no key, are returned as-is.
+func (s *Seal) Encrypt(v string) (string, error) {
+	if s == nil || v == "" || IsEncrypted(v) {
+		return v, nil
+	}
+
+	a := s.key.aead
+	nonce := make([]byte, a.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 0, len(s.key.salt)+len(nonce)+len(v)+a.Overhead())
+	b = append(b, s.key.salt...)
+	b = append(b, nonce...)
+	b = a.Seal(b, nonce, []byte(v), nil)
+
+	return Prefix + base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// Decrypt decrypts a value. Plaintext values are returned as-is.
+func (s *Seal) Decrypt(v string) (string, error) {
+	if !IsEncrypted(v) {
+		return v, nil
+	}
+	if s == nil {
+		return "", ErrNoKey
+	}
+
+	isV1 := strings.HasPrefix(v, prefixV1)
+	if isV1 {
+		v = strings.TrimPrefix(v, prefixV1)
+	} else {
+		v = strings.TrimPrefix(v, Prefix)
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(v)
+	if err != nil {
+		return "", fmt.Errorf("error decoding encrypted value: %v", err)
+	}
+
+	var salt []byte
+	if !isV1 {
+		if len(b) < saltLen {
+			return "", errors.New("invalid encrypted value")
+		}
+		salt, b = b[:saltLen], b[saltLen:]
+	}
+
+	for _, k := range append([]*key{s.key}, s.old...) {
+		var a cipher.AEAD
+		if isV1 {
+			a = k.v1
+		} else if a, err = k.cipher(salt); err != nil {
+			return "", err
+		}
+
+		n := a.NonceSize()
+		if len(b) < n {
+			return "", errors.New("invalid encrypted value")
+		}
+
+		if out, err := a.Open(nil, b[:n], b[n:], nil); err == nil {
+			return string(out), nil
+		}
+	}
+
+	return "", errors.New("error decrypting value. Is the encryption key correct?")
+}
+
+// EncryptFields encrypts the string values at the given paths in a map.
+func (s *Seal) EncryptFields(m map[string]interface{}, paths [][]string) error {
+	return walkFields(m, paths, s.Encrypt)
+}
+
+// DecryptFields decrypts the string values at the given paths in a map.
+func (s *Seal) DecryptFields(m map[string]interface{}, paths [][]string) error {
+	return walkFields(m, paths, s.Decrypt)
+}
+
+// newKey returns a key for the given key string with a new random salt
+// that values are encrypted with.
+func newKey(str string) (*key, error) {
+	if len(str) < minKeyLen {
+		return nil, fmt.Errorf("encryption key should be at least %d characters", minKeyLen)
+	}
+
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	k := &key{str: str, salt: salt, derived: map[string]cipher.AEAD{}}
+	a, err := k.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	k.aead = a
+
+	h := sha256.Sum256([]byte(str))
+	if k.v1, err = newAEAD(h[:]); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// cipher returns the cipher for the key derived with the given salt.
+func (k *key) cipher(salt []byte) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if a, ok := k.derived[string(salt)]; ok {
+		return a, nil
+	}
+
+	a, err := newAEAD(argon2.IDKey([]byte(k.str), salt, kdfTime, kdfMemory, kdfThreads, 32))
+	if err != nil {
+		return nil, err
+	}
+	k.derived[string(salt)] = a
+
+	return a, nil
+}
+
+// newAEAD returns an AES-256-GCM cipher with the given 32 byte key.
+func newAEAD(k []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// walkFields applies fn to the string values at the given paths in m. Each path
+// is a list of map keys where "*" matches every item of an array.
+func walkFields(m map[string]interface{}, paths [][]string, fn func(string) (string, error)) error {
+	for _, p := range paths {
+		if err := walkPath(m, p, fn); err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(p, "."), err)
+		}
+	}
+	return nil
+}
+
+func walkPath(v interface{}, path []string, fn func(string) (string, error)) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		val, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+
+		if len(path) > 1 {
+			return walkPath(val, path[1:], fn)
+		}
+
+		str, ok := val.(string)
+		if !ok {
+			return nil
+		}
+		out, err := fn(str)
+		if err != nil {
+			return err
+		}
+		t[path[0]] = out
+
+	case []interface{}:
+		if path[0] != "*" {
+			return nil
+		}
+		for _, item := range t {
+			if err := walkPath(item, path[1:], fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
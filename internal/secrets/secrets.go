@@ -0,0 +1,269 @@
+// Package secrets resolves config and settings values that reference secrets
+// stored in external providers instead of holding them as plaintext.
+// A reference is a string value in one of the following forms:
+//
+//	file:/run/secrets/db_password              (a file, eg: Docker/Kubernetes secrets)
+//	vault:secret/data/listmonk#db_password     (a field in a HashiCorp Vault KV secret)
+//	ssm:/listmonk/db_password                  (an AWS SSM Parameter Store parameter)
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderFile  = "file"
+	ProviderVault = "vault"
+	ProviderSSM   = "ssm"
+
+	// maxSize is the maximum size of a secret.
+	maxSize = 64 * 1024
+)
+
+var reRef = regexp.MustCompile(`^(file|vault|ssm):(\S+)$`)
+
+// Opt represents the options of the providers.
+type Opt struct {
+	// HashiCorp Vault server address and token.
+	VaultAddr  string
+	VaultToken string
+
+	// AWS region and credentials for SSM.
+	AWSRegion       string
+	AWSAccessKey    string
+	AWSSecretKey    string
+	AWSSessionToken string
+
+	Timeout time.Duration
+}
+
+// Resolver resolves secret references and remembers them so that they can
+// be re-fetched to check for changes.
+type Resolver struct {
+	opt    Opt
+	client *http.Client
+
+	// ref => last resolved value.
+	refs map[string]string
+	sync.Mutex
+}
+
+// New returns a new Resolver.
+func New(o Opt) *Resolver {
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 10
+	}
+
+	return &Resolver{
+		opt:    o,
+		client: &http.Client{Timeout: o.Timeout},
+		refs:   make(map[string]string),
+	}
+}
+
+// IsRef checks whether a value is a secret reference.
+func IsRef(s string) bool {
+	return reRef.MatchString(s)
+}
+
+// Resolve fetches the secret that a reference points to.
+func (r *Resolver) Resolve(ref string) (string, error) {
+	val, err := r.fetch(ref)
+	if err != nil {
+		return "", err
+	}
+
+	r.Lock()
+	r.refs[ref] = val
+	r.Unlock()
+
+	return val, nil
+}
+
+// ResolveMap replaces all the secret references in the string values of
+// a nested map (and the maps in its arrays) with their secrets.
+func (r *Resolver) ResolveMap(m map[string]interface{}) error {
+	for k, v := range m {
+		out, err := r.resolveVal(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+		m[k] = out
+	}
+
+	return nil
+}
+
+// NumRefs returns the number of references that have been resolved.
+func (r *Resolver) NumRefs() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.refs)
+}
+
+// Changed re-fetches all the resolved references and returns the ones whose
+// secrets have changed since they were last resolved.
+func (r *Resolver) Changed() ([]string, error) {
+	r.Lock()
+	refs := make(map[string]string, len(r.refs))
+	for k, v := range r.refs {
+		refs[k] = v
+	}
+	r.Unlock()
+
+	var out []string
+	for ref, old := range refs {
+		val, err := r.fetch(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ref, err)
+		}
+
+		if val != old {
+			out = append(out, ref)
+		}
+	}
+
+	return out, nil
+}
+
+func (r *Resolver) resolveVal(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		if !IsRef(t) {
+			return t, nil
+		}
+		return r.Resolve(t)
+
+	case map[string]interface{}:
+		if err := r.ResolveMap(t); err != nil {
+			return nil, err
+		}
+		return t, nil
+
+	case []interface{}:
+		for i, item := range t {
+			out, err := r.resolveVal(item)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = out
+		}
+		return t, nil
+	}
+
+	return v, nil
+}
+
+// fetch fetches the secret of a reference from its provider.
+func (r *Resolver) fetch(ref string) (string, error) {
+	p := reRef.FindStringSubmatch(ref)
+	if p == nil {
+		return "", fmt.Errorf("invalid secret reference: %s", ref)
+	}
+
+	switch p[1] {
+	case ProviderFile:
+		return r.fetchFile(p[2])
+	case ProviderVault:
+		return r.fetchVault(p[2])
+	case ProviderSSM:
+		return r.fetchSSM(p[2])
+	}
+
+	return "", fmt.Errorf("unknown secret provider: %s", p[1])
+}
+
+// fetchFile reads a secret from a file. Trailing whitespace is trimmed.
+func (r *Resolver) fetchFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxSize))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), " \r\n\t"), nil
+}
+
+// fetchVault reads a field of a secret from Vault. The reference is the API
+// path of the secret and the field, eg: secret/data/listmonk#db_password.
+// Both the KV v1 and v2 response formats are supported.
+func (r *Resolver) fetchVault(ref string) (string, error) {
+	if r.opt.VaultAddr == "" {
+		return "", errors.New("vault address is not configured")
+	}
+
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", errors.New("vault reference should be of the form path#field")
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimRight(r.opt.VaultAddr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.opt.VaultToken)
+
+	var res struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := r.doJSON(req, &res); err != nil {
+		return "", err
+	}
+
+	// KV v2 nests the fields in data.data.
+	data := res.Data
+	if b, ok := res.Data["data"]; ok {
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(b, &inner); err == nil {
+			data = inner
+		}
+	}
+
+	b, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field '%s' not found in vault secret", field)
+	}
+
+	var val string
+	if err := json.Unmarshal(b, &val); err != nil {
+		return "", fmt.Errorf("field '%s' in vault secret is not a string", field)
+	}
+
+	return val, nil
+}
+
+// doJSON does an HTTP request and decodes the JSON response.
+func (r *Resolver) doJSON(req *http.Request, out interface{}) error {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const ssmTarget = "AmazonSSM.GetParameter"
+
+// fetchSSM reads a parameter from AWS SSM Parameter Store. SecureString
+// parameters are decrypted.
+func (r *Resolver) fetchSSM(name string) (string, error) {
+	o := r.opt
+	if o.AWSRegion == "" || o.AWSAccessKey == "" || o.AWSSecretKey == "" {
+		return "", errors.New("AWS region and credentials are not configured")
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"Name":           name,
+		"WithDecryption": true,
+	})
+
+	host := fmt.Sprintf("ssm.%s.amazonaws.com", o.AWSRegion)
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", ssmTarget)
+	r.signAWS(req, host, body, time.Now().UTC())
+
+	var res struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := r.doJSON(req, &res); err != nil {
+		return "", err
+	}
+
+	return res.Parameter.Value, nil
+}
+
+// signAWS signs an SSM request with AWS Signature Version 4.
+func (r *Resolver) signAWS(req *http.Request, host string, body []byte, now time.Time) {
+	var (
+		o       = r.opt
+		amzDate = now.Format("20060102T150405Z")
+		date    = now.Format("20060102")
+		scope   = date + "/" + o.AWSRegion + "/ssm/aws4_request"
+	)
+
+	req.Header.Set("Host", host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signed := "content-type;host;x-amz-date;x-amz-target"
+	headers := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"x-amz-target:" + ssmTarget + "\n"
+	if o.AWSSessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.AWSSessionToken)
+		signed = "content-type;host;x-amz-date;x-amz-security-token;x-amz-target"
+		headers = "content-type:" + req.Header.Get("Content-Type") + "\n" +
+			"host:" + host + "\n" +
+			"x-amz-date:" + amzDate + "\n" +
+			"x-amz-security-token:" + o.AWSSessionToken + "\n" +
+			"x-amz-target:" + ssmTarget + "\n"
+	}
+
+	canonical := "POST\n/\n\n" + headers + "\n" + signed + "\n" + hashHex(body)
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+o.AWSSecretKey), date)
+	key = hmacSHA256(key, o.AWSRegion)
+	key = hmacSHA256(key, "ssm")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.AWSAccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
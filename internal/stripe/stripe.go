@@ -0,0 +1,241 @@
+// Package stripe is a minimal Stripe client for syncing paid list
+// subscriptions. It verifies webhook events and talks to the handful of
+// Stripe API endpoints that are required.
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiURL = "https://api.stripe.com/v1"
+
+	// Maximum age of a webhook event's signature timestamp.
+	sigTolerance = time.Minute * 5
+
+	// Webhook events that carry a subscription object.
+	EventSubCreated = "customer.subscription.created"
+	EventSubUpdated = "customer.subscription.updated"
+	EventSubDeleted = "customer.subscription.deleted"
+)
+
+// Opt represents the Stripe config.
+type Opt struct {
+	SecretKey     string
+	WebhookSecret string
+}
+
+// Stripe is a Stripe API and webhook client.
+type Stripe struct {
+	o      Opt
+	client *http.Client
+}
+
+// Event is a Stripe webhook event.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// Subscription is a Stripe subscription object.
+type Subscription struct {
+	ID               string `json:"id"`
+	Customer         string `json:"customer"`
+	Status           string `json:"status"`
+	CurrentPeriodEnd int64  `json:"current_period_end"`
+	Items            struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// Customer is a Stripe customer object.
+type Customer struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type errResp struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// New returns a new instance of the Stripe client.
+func New(o Opt) *Stripe {
+	timeout := time.Second * 10
+
+	return &Stripe{
+		o: o,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   10,
+				MaxConnsPerHost:       100,
+				ResponseHeaderTimeout: timeout,
+				IdleConnTimeout:       timeout,
+			},
+		}}
+}
+
+// ParseEvent verifies the Stripe-Signature header of a webhook request
+// against the webhook secret and returns the event in the body. Events
+// are rejected if there's no webhook secret.
+func (s *Stripe) ParseEvent(sigHeader string, body []byte) (Event, error) {
+	// Without a secret, anyone could sign events.
+	if s.o.WebhookSecret == "" {
+		return Event{}, errors.New("no webhook secret configured")
+	}
+
+	var (
+		ts   string
+		sigs []string
+	)
+	for _, p := range strings.Split(sigHeader, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			continue
+		}
+
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return Event{}, errors.New("invalid signature header")
+	}
+
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Event{}, errors.New("invalid signature timestamp")
+	}
+	if time.Since(time.Unix(t, 0)) > sigTolerance {
+		return Event{}, errors.New("signature timestamp is too old")
+	}
+
+	// The signature is a HMAC of "timestamp.body".
+	h := hmac.New(sha256.New, []byte(s.o.WebhookSecret))
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	expected := h.Sum(nil)
+
+	valid := false
+	for _, sig := range sigs {
+		b, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(b, expected) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return Event{}, errors.New("signature mismatch")
+	}
+
+	var ev Event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return Event{}, fmt.Errorf("error parsing event: %v", err)
+	}
+
+	return ev, nil
+}
+
+// GetCustomer fetches a customer.
+func (s *Stripe) GetCustomer(id string) (Customer, error) {
+	var out Customer
+	if err := s.do(http.MethodGet, "/customers/"+url.PathEscape(id), nil, &out); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+// NewPortalSession creates a customer portal session for a customer and
+// returns its URL. The customer is sent back to returnURL from the portal.
+func (s *Stripe) NewPortalSession(customerID, returnURL string) (string, error) {
+	var out struct {
+		URL string `json:"url"`
+	}
+	if err := s.do(http.MethodPost, "/billing_portal/sessions", url.Values{
+		"customer":   {customerID},
+		"return_url": {returnURL},
+	}, &out); err != nil {
+		return "", err
+	}
+
+	return out.URL, nil
+}
+
+// PriceIDs returns the IDs of the prices in a subscription.
+func (s Subscription) PriceIDs() []string {
+	out := make([]string, 0, len(s.Items.Data))
+	for _, it := range s.Items.Data {
+		out = append(out, it.Price.ID)
+	}
+
+	return out
+}
+
+// IsActive indicates whether the subscription entitles the customer
+// to the subscription's paid lists.
+func (s Subscription) IsActive() bool {
+	return s.Status == "active" || s.Status == "trialing"
+}
+
+// do makes a Stripe API request and decodes the JSON response into out.
+func (s *Stripe) do(method, uri string, params url.Values, out interface{}) error {
+	var body io.Reader
+	if params != nil {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequest(method, apiURL+uri, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.o.SecretKey, "")
+	if params != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e errResp
+		if err := json.Unmarshal(b, &e); err == nil && e.Error.Message != "" {
+			return fmt.Errorf("stripe: %s", e.Error.Message)
+		}
+		return fmt.Errorf("stripe: unexpected status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(b, out)
+}
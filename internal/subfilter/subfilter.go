@@ -0,0 +1,387 @@
+// Package subfilter compiles structured subscriber filters (trees of field,
+// operator, value conditions) into SQL expressions that can be used in place
+// of arbitrary SQL subscriber queries. Only whitelisted fields and operators
+// are accepted and all values are escaped as SQL literals, so filters are safe
+// to accept from users who aren't allowed to run raw SQL.
+package subfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Group operators.
+const (
+	OpAnd = "and"
+	OpOr  = "or"
+)
+
+// Condition operators.
+const (
+	OpEq          = "eq"
+	OpNeq         = "neq"
+	OpGt          = "gt"
+	OpGte         = "gte"
+	OpLt          = "lt"
+	OpLte         = "lte"
+	OpContains    = "contains"
+	OpNotContains = "not_contains"
+	OpStartsWith  = "starts_with"
+	OpEndsWith    = "ends_with"
+	OpIn          = "in"
+	OpNotIn       = "not_in"
+	OpEmpty       = "empty"
+	OpNotEmpty    = "not_empty"
+)
+
+const (
+	// maxDepth is the maximum nesting depth of groups.
+	maxDepth = 5
+
+	// maxRules is the maximum number of conditions in a filter.
+	maxRules = 50
+
+	// attribPrefix is the field prefix for subscriber attributes, eg: attribs.city
+	attribPrefix = "attribs."
+)
+
+// Field types.
+const (
+	typeText = iota
+	typeDate
+	typeTags
+	typeAttrib
+)
+
+// fields is the whitelist of filterable subscriber fields and their SQL columns.
+var fields = map[string]struct {
+	col string
+	typ int
+}{
+	"email":      {"subscribers.email", typeText},
+	"name":       {"subscribers.name", typeText},
+	"status":     {"subscribers.status::TEXT", typeText},
+	"lang":       {"subscribers.lang", typeText},
+	"created_at": {"subscribers.created_at", typeDate},
+	"updated_at": {"subscribers.updated_at", typeDate},
+	"tags":       {"subscribers.tags", typeTags},
+}
+
+// operators lists the operators allowed on each field type.
+var operators = map[int][]string{
+	typeText:   {OpEq, OpNeq, OpContains, OpNotContains, OpStartsWith, OpEndsWith, OpIn, OpNotIn, OpEmpty, OpNotEmpty},
+	typeDate:   {OpEq, OpNeq, OpGt, OpGte, OpLt, OpLte},
+	typeTags:   {OpContains, OpNotContains, OpIn, OpNotIn, OpEmpty, OpNotEmpty},
+	typeAttrib: {OpEq, OpNeq, OpGt, OpGte, OpLt, OpLte, OpContains, OpNotContains, OpStartsWith, OpEndsWith, OpIn, OpNotIn, OpEmpty, OpNotEmpty},
+}
+
+var (
+	reAttribKey = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,64}$`)
+
+	errEmpty = errors.New("empty filter")
+)
+
+// Filter is a node in a filter tree. It's either a group of rules joined by
+// Op (and, or) or a single condition on a field.
+//
+//	{"op": "and", "rules": [
+//		{"field": "attribs.city", "operator": "eq", "value": "Bengaluru"},
+//		{"op": "or", "rules": [
+//			{"field": "tags", "operator": "contains", "value": "vip"},
+//			{"field": "created_at", "operator": "gte", "value": "2024-01-01"}
+//		]}
+//	]}
+type Filter struct {
+	// Group.
+	Op    string   `json:"op,omitempty"`
+	Rules []Filter `json:"rules,omitempty"`
+
+	// Condition.
+	Field    string          `json:"field,omitempty"`
+	Operator string          `json:"operator,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// Parse parses a JSON filter.
+func Parse(b []byte) (Filter, error) {
+	var f Filter
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("invalid filter: %v", err)
+	}
+
+	return f, nil
+}
+
+// Compile validates the filter and compiles it into an SQL expression
+// on the subscribers table.
+func (f Filter) Compile() (string, error) {
+	n := 0
+	return f.compile(0, &n)
+}
+
+func (f Filter) compile(depth int, n *int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("filter is nested deeper than %d levels", maxDepth)
+	}
+
+	// Single condition.
+	if f.Field != "" {
+		*n++
+		if *n > maxRules {
+			return "", fmt.Errorf("filter has more than %d rules", maxRules)
+		}
+		return f.compileCond()
+	}
+
+	// Group.
+	op := strings.ToLower(f.Op)
+	if op == "" {
+		op = OpAnd
+	}
+	if op != OpAnd && op != OpOr {
+		return "", fmt.Errorf("unknown group operator: %s", f.Op)
+	}
+	if len(f.Rules) == 0 {
+		return "", errEmpty
+	}
+
+	out := make([]string, 0, len(f.Rules))
+	for _, r := range f.Rules {
+		exp, err := r.compile(depth+1, n)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, exp)
+	}
+
+	return "(" + strings.Join(out, " "+strings.ToUpper(op)+" ") + ")", nil
+}
+
+// compileCond compiles a single field condition.
+func (f Filter) compileCond() (string, error) {
+	var (
+		col  string
+		path string
+		typ  int
+	)
+	if strings.HasPrefix(f.Field, attribPrefix) {
+		keys := strings.Split(strings.TrimPrefix(f.Field, attribPrefix), ".")
+		quoted := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if !reAttribKey.MatchString(k) {
+				return "", fmt.Errorf("invalid attribute: %s", f.Field)
+			}
+			quoted = append(quoted, pq.QuoteLiteral(k))
+		}
+		path = "ARRAY[" + strings.Join(quoted, ",") + "]"
+		col = "subscribers.attribs #>> " + path
+		typ = typeAttrib
+	} else {
+		fl, ok := fields[f.Field]
+		if !ok {
+			return "", fmt.Errorf("unknown field: %s", f.Field)
+		}
+		col, typ = fl.col, fl.typ
+	}
+
+	if !hasOp(operators[typ], f.Operator) {
+		return "", fmt.Errorf("operator %s is not allowed on %s", f.Operator, f.Field)
+	}
+
+	switch f.Operator {
+	case OpEmpty, OpNotEmpty:
+		var exp string
+		switch typ {
+		case typeTags:
+			exp = fmt.Sprintf("COALESCE(CARDINALITY(%s), 0) = 0", col)
+		default:
+			exp = fmt.Sprintf("COALESCE(%s, '') = ''", col)
+		}
+		if f.Operator == OpNotEmpty {
+			exp = "NOT " + exp
+		}
+		return exp, nil
+
+	case OpIn, OpNotIn:
+		vals, err := f.strings()
+		if err != nil {
+			return "", err
+		}
+		if typ == typeTags {
+			exp := fmt.Sprintf("%s && ARRAY[%s]::VARCHAR(100)[]", col, strings.Join(vals, ","))
+			if f.Operator == OpNotIn {
+				exp = "NOT (" + exp + ")"
+			}
+			return exp, nil
+		}
+
+		not := ""
+		if f.Operator == OpNotIn {
+			not = "NOT "
+		}
+		return fmt.Sprintf("%s %sIN (%s)", col, not, strings.Join(vals, ",")), nil
+	}
+
+	switch typ {
+	case typeTags:
+		v, err := f.string()
+		if err != nil {
+			return "", err
+		}
+		exp := fmt.Sprintf("%s @> ARRAY[%s]::VARCHAR(100)[]", col, pq.QuoteLiteral(v))
+		if f.Operator == OpNotContains {
+			exp = "NOT (" + exp + ")"
+		}
+		return exp, nil
+
+	case typeDate:
+		v, err := f.string()
+		if err != nil {
+			return "", err
+		}
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return "", fmt.Errorf("invalid date for %s: %s", f.Field, v)
+			}
+		}
+		return fmt.Sprintf("%s %s %s::TIMESTAMP WITH TIME ZONE", col, cmpOp(f.Operator), pq.QuoteLiteral(v)), nil
+	}
+
+	// Numeric comparisons on attributes.
+	if typ == typeAttrib {
+		switch f.Operator {
+		case OpGt, OpGte, OpLt, OpLte:
+			num, err := f.number()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("(CASE WHEN JSONB_TYPEOF(subscribers.attribs #> %s) = 'number' THEN (%s)::NUMERIC END) %s %s",
+				path, col, cmpOp(f.Operator), num), nil
+		}
+	}
+
+	// Text comparisons.
+	v, err := f.string()
+	if err != nil {
+		return "", err
+	}
+	switch f.Operator {
+	case OpEq:
+		return fmt.Sprintf("%s = %s", col, pq.QuoteLiteral(v)), nil
+	case OpNeq:
+		return fmt.Sprintf("%s IS DISTINCT FROM %s", col, pq.QuoteLiteral(v)), nil
+	case OpContains:
+		return fmt.Sprintf("%s ILIKE %s", col, pq.QuoteLiteral("%"+escapeLike(v)+"%")), nil
+	case OpNotContains:
+		return fmt.Sprintf("COALESCE(%s, '') NOT ILIKE %s", col, pq.QuoteLiteral("%"+escapeLike(v)+"%")), nil
+	case OpStartsWith:
+		return fmt.Sprintf("%s ILIKE %s", col, pq.QuoteLiteral(escapeLike(v)+"%")), nil
+	case OpEndsWith:
+		return fmt.Sprintf("%s ILIKE %s", col, pq.QuoteLiteral("%"+escapeLike(v))), nil
+	}
+
+	return "", fmt.Errorf("operator %s is not allowed on %s", f.Operator, f.Field)
+}
+
+// string returns the condition's value as a string. Numbers and booleans
+// are converted to their string forms.
+func (f Filter) string() (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(f.Value, &v); err != nil {
+		return "", fmt.Errorf("invalid value for %s", f.Field)
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	}
+
+	return "", fmt.Errorf("invalid value for %s", f.Field)
+}
+
+// strings returns the condition's list value as quoted SQL literals.
+func (f Filter) strings() ([]string, error) {
+	var vals []interface{}
+	if err := json.Unmarshal(f.Value, &vals); err != nil || len(vals) == 0 {
+		return nil, fmt.Errorf("%s expects a non-empty list of values", f.Operator)
+	}
+
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		switch t := v.(type) {
+		case string:
+			out = append(out, pq.QuoteLiteral(t))
+		case float64:
+			out = append(out, pq.QuoteLiteral(strconv.FormatFloat(t, 'f', -1, 64)))
+		default:
+			return nil, fmt.Errorf("invalid value in list for %s", f.Field)
+		}
+	}
+
+	return out, nil
+}
+
+// number returns the condition's value as a formatted number.
+func (f Filter) number() (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(f.Value, &v); err != nil {
+		return "", fmt.Errorf("invalid number for %s", f.Field)
+	}
+
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case string:
+		// ParseFloat accepts NaN and Inf, which aren't valid SQL numbers.
+		n, err := strconv.ParseFloat(t, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return "", fmt.Errorf("invalid number for %s: %s", f.Field, t)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("invalid number for %s", f.Field)
+}
+
+// cmpOp returns the SQL comparison operator for a filter operator.
+func cmpOp(op string) string {
+	switch op {
+	case OpNeq:
+		return "!="
+	case OpGt:
+		return ">"
+	case OpGte:
+		return ">="
+	case OpLt:
+		return "<"
+	case OpLte:
+		return "<="
+	}
+	return "="
+}
+
+// escapeLike escapes LIKE pattern characters in a string.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func hasOp(ops []string, op string) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
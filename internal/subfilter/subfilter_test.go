@@ -0,0 +1,141 @@
+package subfilter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"eq", `{"field": "email", "operator": "eq", "value": "a@b.com"}`,
+			`subscribers.email = 'a@b.com'`},
+		{"eq quote", `{"field": "name", "operator": "eq", "value": "O'Brien"}`,
+			`subscribers.name = 'O''Brien'`},
+		{"eq backslash", `{"field": "name", "operator": "eq", "value": "a\\b"}`,
+			` E'a\\b'`},
+		{"neq", `{"field": "lang", "operator": "neq", "value": "en"}`,
+			`subscribers.lang IS DISTINCT FROM 'en'`},
+		{"contains escapes like", `{"field": "name", "operator": "contains", "value": "50%_off"}`,
+			`subscribers.name ILIKE  E'%50\\%\\_off%'`},
+		{"not contains", `{"field": "name", "operator": "not_contains", "value": "x"}`,
+			`COALESCE(subscribers.name, '') NOT ILIKE '%x%'`},
+		{"starts with", `{"field": "email", "operator": "starts_with", "value": "admin"}`,
+			`subscribers.email ILIKE 'admin%'`},
+		{"ends with", `{"field": "email", "operator": "ends_with", "value": "@x.com"}`,
+			`subscribers.email ILIKE '%@x.com'`},
+		{"status in", `{"field": "status", "operator": "in", "value": ["enabled", "blocklisted"]}`,
+			`subscribers.status::TEXT IN ('enabled','blocklisted')`},
+		{"not in", `{"field": "lang", "operator": "not_in", "value": ["en", 1]}`,
+			`subscribers.lang NOT IN ('en','1')`},
+		{"empty", `{"field": "name", "operator": "empty"}`,
+			`COALESCE(subscribers.name, '') = ''`},
+		{"not empty", `{"field": "name", "operator": "not_empty"}`,
+			`NOT COALESCE(subscribers.name, '') = ''`},
+		{"tags contains", `{"field": "tags", "operator": "contains", "value": "vip"}`,
+			`subscribers.tags @> ARRAY['vip']::VARCHAR(100)[]`},
+		{"tags not in", `{"field": "tags", "operator": "not_in", "value": ["a", "b"]}`,
+			`NOT (subscribers.tags && ARRAY['a','b']::VARCHAR(100)[])`},
+		{"tags empty", `{"field": "tags", "operator": "empty"}`,
+			`COALESCE(CARDINALITY(subscribers.tags), 0) = 0`},
+		{"date", `{"field": "created_at", "operator": "gte", "value": "2024-01-01"}`,
+			`subscribers.created_at >= '2024-01-01'::TIMESTAMP WITH TIME ZONE`},
+		{"date rfc3339", `{"field": "updated_at", "operator": "lt", "value": "2024-01-01T10:00:00Z"}`,
+			`subscribers.updated_at < '2024-01-01T10:00:00Z'::TIMESTAMP WITH TIME ZONE`},
+		{"attrib eq", `{"field": "attribs.city", "operator": "eq", "value": "Bengaluru"}`,
+			`subscribers.attribs #>> ARRAY['city'] = 'Bengaluru'`},
+		{"attrib nested", `{"field": "attribs.a.b", "operator": "eq", "value": true}`,
+			`subscribers.attribs #>> ARRAY['a','b'] = 'true'`},
+		{"attrib number", `{"field": "attribs.age", "operator": "gt", "value": 30}`,
+			`(CASE WHEN JSONB_TYPEOF(subscribers.attribs #> ARRAY['age']) = 'number' THEN (subscribers.attribs #>> ARRAY['age'])::NUMERIC END) > 30`},
+		{"attrib number string", `{"field": "attribs.age", "operator": "lte", "value": "2.5"}`,
+			`(CASE WHEN JSONB_TYPEOF(subscribers.attribs #> ARRAY['age']) = 'number' THEN (subscribers.attribs #>> ARRAY['age'])::NUMERIC END) <= 2.5`},
+		{"group", `{"op": "or", "rules": [
+				{"field": "lang", "operator": "eq", "value": "en"},
+				{"rules": [{"field": "tags", "operator": "contains", "value": "vip"}]}
+			]}`,
+			`(subscribers.lang = 'en' OR (subscribers.tags @> ARRAY['vip']::VARCHAR(100)[]))`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := Parse([]byte(c.in))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			out, err := f.Compile()
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if !strings.Contains(out, c.out) {
+				t.Errorf("got %q, want %q", out, c.out)
+			}
+		})
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	// Builds a group of n conditions.
+	rules := func(n int) string {
+		r := make([]string, n)
+		for i := range r {
+			r[i] = `{"field": "lang", "operator": "eq", "value": "en"}`
+		}
+		return `{"rules": [` + strings.Join(r, ",") + `]}`
+	}
+
+	// Builds groups nested n levels deep.
+	nested := func(n int) string {
+		out := `{"field": "lang", "operator": "eq", "value": "en"}`
+		for i := 0; i < n; i++ {
+			out = fmt.Sprintf(`{"rules": [%s]}`, out)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name string
+		in   string
+	}{
+		{"unknown field", `{"field": "password", "operator": "eq", "value": "x"}`},
+		{"raw sql field", `{"field": "1=1; --", "operator": "eq", "value": "x"}`},
+		{"bad attrib key", `{"field": "attribs.a'b", "operator": "eq", "value": "x"}`},
+		{"empty attrib key", `{"field": "attribs.", "operator": "eq", "value": "x"}`},
+		{"unknown operator", `{"field": "email", "operator": "like", "value": "x"}`},
+		{"operator on wrong type", `{"field": "email", "operator": "gt", "value": "x"}`},
+		{"contains on date", `{"field": "created_at", "operator": "contains", "value": "2024"}`},
+		{"nan", `{"field": "attribs.age", "operator": "gt", "value": "NaN"}`},
+		{"inf", `{"field": "attribs.age", "operator": "gt", "value": "Inf"}`},
+		{"negative infinity", `{"field": "attribs.age", "operator": "lt", "value": "-Infinity"}`},
+		{"non numeric", `{"field": "attribs.age", "operator": "gt", "value": "1; DROP TABLE subscribers"}`},
+		{"number as list", `{"field": "attribs.age", "operator": "gt", "value": [1]}`},
+		{"invalid date", `{"field": "created_at", "operator": "gt", "value": "yesterday"}`},
+		{"object value", `{"field": "email", "operator": "eq", "value": {"a": 1}}`},
+		{"empty list", `{"field": "lang", "operator": "in", "value": []}`},
+		{"list with object", `{"field": "lang", "operator": "in", "value": [{"a": 1}]}`},
+		{"empty group", `{"op": "and", "rules": []}`},
+		{"unknown group op", `{"op": "xor", "rules": [{"field": "lang", "operator": "eq", "value": "en"}]}`},
+		{"too deep", nested(maxDepth + 1)},
+		{"too many rules", rules(maxRules + 1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := Parse([]byte(c.in))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if out, err := f.Compile(); err == nil {
+				t.Errorf("expected error, got %q", out)
+			}
+		})
+	}
+
+	if _, err := Parse([]byte(`{"rules": `)); err == nil {
+		t.Error("expected error on invalid JSON")
+	}
+}
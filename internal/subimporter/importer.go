@@ -1,9 +1,9 @@
 // Package subimporter implements a bulk ZIP/CSV importer of subscribers.
-// It implements a simple queue for buffering imports and committing records
-// to DB along with ZIP and CSV handling utilities. It is meant to be used as
-// a singleton as each Importer instance is stateful, where it keeps track of
-// an import in progress. Only one import should happen on a single importer
-// instance at a time.
+// Imports are queued as jobs (sessions) that are run by a fixed number of
+// concurrent workers. Each job buffers and commits records to the DB in
+// batches and has its own status, logs, and stop, pause, and resume controls.
+// Finished jobs are retained in a job history. It also has ZIP and CSV
+// handling utilities.
 package subimporter
 
 import (
@@ -21,6 +21,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/internal/i18n"
@@ -28,6 +29,7 @@ import (
 	"github.com/lib/pq"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -36,18 +38,40 @@ const (
 
 	// commitBatchSize is the number of inserts to commit in a single SQL transaction.
 	commitBatchSize = 10000
+
+	// maxQueuedJobs is the maximum number of jobs that can wait in the queue.
+	maxQueuedJobs = 100
+
+	// maxJobHistory is the number of finished jobs retained in the job history.
+	maxJobHistory = 50
+
+	// maxReportErrors is the maximum number of row errors retained in a dry run report.
+	maxReportErrors = 1000
 )
 
 // Various import statuses.
 const (
 	StatusNone      = "none"
+	StatusQueued    = "queued"
 	StatusImporting = "importing"
+	StatusPaused    = "paused"
 	StatusStopping  = "stopping"
+	StatusStopped   = "stopped"
 	StatusFinished  = "finished"
 	StatusFailed    = "failed"
 
 	ModeSubscribe = "subscribe"
 	ModeBlocklist = "blocklist"
+
+	// Policies for subscribers in an import that already exist.
+	ConflictOverwrite = "overwrite"
+	ConflictMerge     = "merge"
+	ConflictSubscribe = "subscribe"
+	ConflictSkip      = "skip"
+
+	LevelError     = "error"
+	LevelWarning   = "warning"
+	LevelDuplicate = "duplicate"
 )
 
 // Importer represents the bulk CSV subscriber import system.
@@ -57,9 +81,18 @@ type Importer struct {
 	i18n                  *i18n.I18n
 	domainBlocklist       map[string]bool
 	hasBlocklistWildcards bool
+	policy                *policy
+
+	queue chan *Session
+
+	// All jobs (queued, active, and the finished history) in the order of creation.
+	jobs   []*Session
+	lastID int
+
+	// The most recent job whose stats and logs are returned by GetStats()
+	// and GetLogs(). It's cleared by Stop() once it's done.
+	cur *Session
 
-	stop   chan bool
-	status Status
 	sync.RWMutex
 }
 
@@ -67,20 +100,39 @@ type Importer struct {
 type Options struct {
 	UpsertStmt         *sql.Stmt
 	BlocklistStmt      *sql.Stmt
+	CountEmailsStmt    *sql.Stmt
 	UpdateListDateStmt *sql.Stmt
 	NotifCB            models.AdminNotifCallback
 
+	// Number of import jobs to run concurrently.
+	Concurrency int
+
 	// Lookup table for blocklisted domains.
 	DomainBlocklist []string
+
+	// Validation policy for the e-mails of new subscribers.
+	Policy Policy
 }
 
-// Session represents a single import session.
+// Session represents a single import job.
 type Session struct {
 	im       *Importer
 	subQueue chan SubReq
 	log      *log.Logger
+	logBuf   *logBuf
+
+	opt     SessionOpt
+	srcPath string
+	delim   rune
+
+	status   Status
+	stop     chan struct{}
+	stopOnce sync.Once
 
-	opt SessionOpt
+	// resume is set when the job is paused and is closed to resume it.
+	resume chan struct{}
+
+	sync.RWMutex
 }
 
 // SessionOpt represents the options for an importer session.
@@ -91,15 +143,56 @@ type SessionOpt struct {
 	Overwrite bool   `json:"overwrite"`
 	Delim     string `json:"delim"`
 	ListIDs   []int  `json:"lists"`
+
+	// OnConflict is the policy (ConflictOverwrite, ConflictMerge, ConflictSubscribe,
+	// ConflictSkip) for subscribers that already exist. If it's not set, it's
+	// ConflictOverwrite if Overwrite is set and ConflictSubscribe otherwise.
+	OnConflict string `json:"on_conflict"`
+
+	// DryRun only validates the file and reports the projected
+	// counts without writing anything to the DB.
+	DryRun bool `json:"dry_run"`
 }
 
-// Status represents statistics from an ongoing import session.
+// Status represents statistics from an import job.
 type Status struct {
-	Name     string `json:"name"`
-	Total    int    `json:"total"`
-	Imported int    `json:"imported"`
-	Status   string `json:"status"`
-	logBuf   *bytes.Buffer
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Mode       string    `json:"mode"`
+	Total      int       `json:"total"`
+	Imported   int       `json:"imported"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  null.Time `json:"started_at"`
+	FinishedAt null.Time `json:"finished_at"`
+
+	// Report is the validation report of a dry run.
+	Report *Report `json:"report,omitempty"`
+}
+
+// Report represents the validation report and projected counts of a dry run.
+type Report struct {
+	// Rows that would be imported, excluding invalid and duplicate rows.
+	Valid      int `json:"valid"`
+	Invalid    int `json:"invalid"`
+	Duplicates int `json:"duplicates"`
+	Warnings   int `json:"warnings"`
+
+	// Valid rows that would create new subscribers and those that match existing ones.
+	New      int `json:"new"`
+	Existing int `json:"existing"`
+
+	// Per-row errors and warnings. Only the first maxReportErrors are retained.
+	Errors          []RowError `json:"errors"`
+	ErrorsTruncated bool       `json:"errors_truncated"`
+}
+
+// RowError represents a validation error or warning on a row in a dry run.
+type RowError struct {
+	Line    int    `json:"line"`
+	Email   string `json:"email"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
 }
 
 // SubReq is a wrapper over the Subscriber model.
@@ -108,6 +201,10 @@ type SubReq struct {
 	Lists          []int    `json:"lists"`
 	ListUUIDs      []string `json:"list_uuids"`
 	PreconfirmSubs bool     `json:"preconfirm_subscriptions"`
+
+	// Consent is an optional proof-of-consent record collected by the caller
+	// that's recorded on the subscriptions.
+	Consent *models.Consent `json:"consent"`
 }
 
 type importStatusTpl struct {
@@ -117,11 +214,26 @@ type importStatusTpl struct {
 	Total    int
 }
 
+// logBuf is a log buffer that's safe to read while it's being written to.
+type logBuf struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
 var (
 	// ErrIsImporting is thrown when an import request is made while an
 	// import is already running.
 	ErrIsImporting = errors.New("import is already running")
 
+	// ErrQueueFull is thrown when a job is queued while the queue is full.
+	ErrQueueFull = errors.New("too many imports in the queue")
+
+	// ErrJobNotFound is thrown when a job doesn't exist.
+	ErrJobNotFound = errors.New("import job not found")
+
+	// ErrJobNotActive is thrown when a job that has finished is paused or resumed.
+	ErrJobNotActive = errors.New("import job is not active")
+
 	csvHeaders = map[string]bool{
 		"email":      true,
 		"name":       true,
@@ -130,15 +242,19 @@ var (
 	regexCleanStr = regexp.MustCompile("[[:^ascii:]]")
 )
 
-// New returns a new instance of Importer.
+// New returns a new instance of Importer and starts the import job workers.
 func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+
 	im := Importer{
 		opt:             opt,
 		db:              db,
 		i18n:            i,
 		domainBlocklist: make(map[string]bool, len(opt.DomainBlocklist)),
-		status:          Status{Status: StatusNone, logBuf: bytes.NewBuffer(nil)},
-		stop:            make(chan bool, 1),
+		policy:          newPolicy(opt.Policy),
+		queue:           make(chan *Session, maxQueuedJobs),
 	}
 
 	// Domain blocklist.
@@ -154,110 +270,345 @@ func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
 		}
 	}
 
+	for i := 0; i < opt.Concurrency; i++ {
+		go im.worker()
+	}
+
 	return &im
 }
 
-// NewSession returns an new instance of Session. It takes the name
+// NewSession returns an new instance of Session (job). It takes the name
 // of the uploaded file, but doesn't do anything with it but retains it for stats.
+// The job has to be queued with Queue().
 func (im *Importer) NewSession(opt SessionOpt) (*Session, error) {
-	if !im.isDone() {
-		return nil, errors.New("an import is already running")
-	}
-
 	im.Lock()
-	im.status = Status{Status: StatusImporting,
-		Name:   opt.Filename,
-		logBuf: bytes.NewBuffer(nil)}
+	im.lastID++
+	id := im.lastID
 	im.Unlock()
 
+	lb := &logBuf{}
 	s := &Session{
 		im:       im,
-		log:      log.New(im.status.logBuf, "", log.Ldate|log.Ltime|log.Lshortfile),
+		log:      log.New(lb, "", log.Ldate|log.Ltime|log.Lshortfile),
+		logBuf:   lb,
 		subQueue: make(chan SubReq, commitBatchSize),
 		opt:      opt,
+		stop:     make(chan struct{}),
+		status: Status{
+			ID:        id,
+			Name:      opt.Filename,
+			Mode:      opt.Mode,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+		},
 	}
 
-	s.log.Printf("processing '%s'", opt.Filename)
+	if opt.DryRun {
+		s.status.Report = &Report{Errors: []RowError{}}
+		s.log.Printf("processing '%s' (dry run)", opt.Filename)
+	} else {
+		s.log.Printf("processing '%s'", opt.Filename)
+	}
 	return s, nil
 }
 
-// GetStats returns the global Stats of the importer.
-func (im *Importer) GetStats() Status {
+// Queue adds a job to import the given CSV file to the queue.
+func (im *Importer) Queue(s *Session, srcPath string, delim rune) error {
+	s.srcPath = srcPath
+	s.delim = delim
+
+	im.Lock()
+	select {
+	case im.queue <- s:
+	default:
+		im.Unlock()
+		return ErrQueueFull
+	}
+	im.jobs = append(im.jobs, s)
+	im.cur = s
+	im.pruneJobs()
+	im.Unlock()
+
+	s.log.Printf("queued import job #%d", s.status.ID)
+	return nil
+}
+
+// GetJobs returns the stats of all the jobs, most recent first.
+func (im *Importer) GetJobs() []Status {
 	im.RLock()
 	defer im.RUnlock()
-	return Status{
-		Name:     im.status.Name,
-		Status:   im.status.Status,
-		Total:    im.status.Total,
-		Imported: im.status.Imported,
+
+	out := make([]Status, 0, len(im.jobs))
+	for i := len(im.jobs) - 1; i >= 0; i-- {
+		out = append(out, im.jobs[i].GetStats())
 	}
+	return out
 }
 
-// GetLogs returns the log entries of the last import session.
-func (im *Importer) GetLogs() []byte {
+// GetJob returns a job by its ID.
+func (im *Importer) GetJob(id int) (*Session, error) {
 	im.RLock()
 	defer im.RUnlock()
 
-	if im.status.logBuf == nil {
-		return []byte{}
+	for _, s := range im.jobs {
+		if s.status.ID == id {
+			return s, nil
+		}
 	}
-	return im.status.logBuf.Bytes()
+	return nil, ErrJobNotFound
 }
 
-// setStatus sets the Importer's status.
-func (im *Importer) setStatus(status string) {
+// DeleteJob stops a job if it's queued or active, or removes it
+// from the job history if it's done.
+func (im *Importer) DeleteJob(id int) error {
+	s, err := im.GetJob(id)
+	if err != nil {
+		return err
+	}
+
+	if !s.isDone() {
+		s.Stop()
+		return nil
+	}
+
 	im.Lock()
-	im.status.Status = status
+	for i, j := range im.jobs {
+		if j == s {
+			im.jobs = append(im.jobs[:i], im.jobs[i+1:]...)
+			break
+		}
+	}
+	if im.cur == s {
+		im.cur = nil
+	}
 	im.Unlock()
+
+	return nil
 }
 
-// getStatus get's the Importer's status.
-func (im *Importer) getStatus() string {
+// GetStats returns the stats of the most recent job.
+func (im *Importer) GetStats() Status {
 	im.RLock()
-	status := im.status.Status
+	s := im.cur
 	im.RUnlock()
-	return status
+
+	if s == nil {
+		return Status{Status: StatusNone}
+	}
+	return s.GetStats()
 }
 
-// isDone returns true if the importer is working (importing|stopping).
-func (im *Importer) isDone() bool {
-	s := true
+// GetLogs returns the log entries of the most recent job.
+func (im *Importer) GetLogs() []byte {
 	im.RLock()
-	if im.getStatus() == StatusImporting || im.getStatus() == StatusStopping {
-		s = false
-	}
+	s := im.cur
 	im.RUnlock()
-	return s
+
+	if s == nil {
+		return []byte{}
+	}
+	return s.GetLogs()
 }
 
-// incrementImportCount sets the Importer's "imported" counter.
-func (im *Importer) incrementImportCount(n int) {
+// Stop stops the most recent job if it's active. If it's done,
+// it's cleared so that GetStats() reports no job.
+func (im *Importer) Stop() {
 	im.Lock()
-	im.status.Imported += n
+	s := im.cur
+	if s != nil && s.isDone() {
+		im.cur = nil
+		s = nil
+	}
 	im.Unlock()
+
+	if s != nil {
+		s.Stop()
+	}
+}
+
+// worker picks up jobs from the queue and runs them one after the other.
+func (im *Importer) worker() {
+	for s := range im.queue {
+		// The job was stopped while it was in the queue.
+		if s.isStopped() {
+			s.setStatus(StatusStopped)
+			continue
+		}
+
+		s.run()
+	}
+}
+
+// pruneJobs removes the oldest finished jobs beyond the history limit.
+// The caller should hold the lock.
+func (im *Importer) pruneJobs() {
+	n := len(im.jobs) - maxJobHistory
+	if n <= 0 {
+		return
+	}
+
+	jobs := make([]*Session, 0, len(im.jobs))
+	for _, s := range im.jobs {
+		if n > 0 && s.isDone() && s != im.cur {
+			n--
+			continue
+		}
+		jobs = append(jobs, s)
+	}
+	im.jobs = jobs
 }
 
 // sendNotif sends admin notifications for import completions.
-func (im *Importer) sendNotif(status string) error {
+func (s *Session) sendNotif(status string) error {
 	var (
-		s   = im.GetStats()
+		st  = s.GetStats()
 		out = importStatusTpl{
-			Name:     s.Name,
+			Name:     st.Name,
 			Status:   status,
-			Imported: s.Imported,
-			Total:    s.Total,
+			Imported: st.Imported,
+			Total:    st.Total,
 		}
 		subject = fmt.Sprintf("%s: %s import",
 			cases.Title(language.Und).String(status),
-			s.Name)
+			st.Name)
 	)
-	return im.opt.NotifCB(subject, out)
+	return s.im.opt.NotifCB(subject, out)
+}
+
+// GetStats returns the stats of the job.
+func (s *Session) GetStats() Status {
+	s.RLock()
+	defer s.RUnlock()
+
+	out := s.status
+	if s.status.Report != nil {
+		r := *s.status.Report
+		r.Errors = append([]RowError(nil), r.Errors...)
+		out.Report = &r
+	}
+	return out
+}
+
+// GetLogs returns the log entries of the job.
+func (s *Session) GetLogs() []byte {
+	return s.logBuf.Bytes()
+}
+
+// Pause pauses an active job. Records that are already processed are committed.
+func (s *Session) Pause() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.status.Status != StatusImporting {
+		return ErrJobNotActive
+	}
+	if s.resume == nil {
+		s.resume = make(chan struct{})
+	}
+	s.status.Status = StatusPaused
+	s.log.Println("paused")
+
+	return nil
+}
+
+// Resume resumes a paused job.
+func (s *Session) Resume() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.resume == nil {
+		return ErrJobNotActive
+	}
+	close(s.resume)
+	s.resume = nil
+	s.status.Status = StatusImporting
+	s.log.Println("resumed")
+
+	return nil
+}
+
+// Stop stops a queued, active, or paused job.
+func (s *Session) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+
+		s.Lock()
+		switch s.status.Status {
+		case StatusQueued:
+			s.status.Status = StatusStopped
+		case StatusImporting, StatusPaused:
+			s.status.Status = StatusStopping
+		}
+		s.Unlock()
+
+		s.log.Println("stop request received")
+	})
+}
+
+// run runs the job, loading the CSV file in to the queue while it's
+// concurrently committed to the DB, and blocks until it's done.
+func (s *Session) run() {
+	s.Lock()
+	s.status.Status = StatusImporting
+	s.status.StartedAt = null.TimeFrom(time.Now())
+	s.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		err := s.Start()
+
+		// Stop loading the file if the records can't be committed.
+		if err != nil {
+			s.Stop()
+		}
+		done <- err
+	}()
+
+	loadErr := s.LoadCSV(s.srcPath, s.delim)
+	close(s.subQueue)
+	commitErr := <-done
+
+	status := StatusFinished
+	switch {
+	case loadErr != nil:
+		s.log.Printf("error loading '%s': %v", s.opt.Filename, loadErr)
+		status = StatusFailed
+	case commitErr != nil:
+		status = StatusFailed
+	case s.isStopped():
+		status = StatusStopped
+	}
+
+	s.Lock()
+	s.status.Status = status
+	s.status.FinishedAt = null.TimeFrom(time.Now())
+	s.Unlock()
+
+	// Dry runs don't change anything.
+	if s.opt.DryRun {
+		r := s.GetStats().Report
+		s.log.Printf("dry run %s: %d valid (%d new, %d existing), %d invalid, %d duplicates, %d warnings",
+			status, r.Valid, r.New, r.Existing, r.Invalid, r.Duplicates, r.Warnings)
+		return
+	}
+
+	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(s.opt.ListIDs)); err != nil {
+		s.log.Printf("error updating lists date: %v", err)
+	}
+
+	s.log.Printf("import %s", status)
+	s.sendNotif(status)
 }
 
 // Start is a blocking function that selects on a channel queue until all
-// subscriber entries in the import session are imported. It should be
-// invoked as a goroutine.
-func (s *Session) Start() {
+// subscriber entries in the import session are imported, or the session is
+// stopped. When the session is paused, the pending records are committed and
+// it waits to be resumed.
+func (s *Session) Start() error {
+	if s.opt.DryRun {
+		return s.validate()
+	}
+
 	var (
 		tx    *sql.Tx
 		stmt  *sql.Stmt
@@ -266,13 +617,56 @@ func (s *Session) Start() {
 		cur   = 0
 
 		listIDs = make([]int, len(s.opt.ListIDs))
+
+		// Provenance of the subscribers and subscriptions created by the job.
+		source = fmt.Sprintf("%s:%d", models.SourceImport, s.status.ID)
 	)
 
 	for i, v := range s.opt.ListIDs {
 		listIDs[i] = v
 	}
 
-	for sub := range s.subQueue {
+	// commit commits the current transaction batch.
+	commit := func() error {
+		if cur == 0 {
+			return nil
+		}
+		n := cur
+		cur = 0
+
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			s.log.Printf("error committing to DB: %v", err)
+			return err
+		}
+		s.incrementImportCount(n)
+		s.log.Printf("imported %d", total)
+		return nil
+	}
+
+loop:
+	for {
+		var sub SubReq
+		select {
+		case v, ok := <-s.subQueue:
+			if !ok {
+				break loop
+			}
+			sub = v
+		case <-s.stop:
+			break loop
+		}
+
+		// The job is paused. Commit what's pending and wait.
+		if s.isPaused() {
+			if err := commit(); err != nil {
+				return err
+			}
+			if !s.waitIfPaused() {
+				break loop
+			}
+		}
+
 		if cur == 0 {
 			// New transaction batch.
 			tx, err = s.im.db.Begin()
@@ -292,85 +686,134 @@ func (s *Session) Start() {
 		if err != nil {
 			s.log.Printf("error generating UUID: %v", err)
 			tx.Rollback()
-			break
+			return err
 		}
 
 		if s.opt.Mode == ModeSubscribe {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(listIDs), s.opt.SubStatus, s.opt.Overwrite)
+			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(listIDs), s.opt.SubStatus, s.opt.OnConflict, source)
 		} else if s.opt.Mode == ModeBlocklist {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs)
+			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, source)
 		}
 		if err != nil {
 			s.log.Printf("error executing insert: %v", err)
 			tx.Rollback()
-			break
+			return err
 		}
 		cur++
 		total++
 
 		// Batch size is met. Commit.
 		if cur%commitBatchSize == 0 {
-			if err := tx.Commit(); err != nil {
-				tx.Rollback()
-				s.log.Printf("error committing to DB: %v", err)
-			} else {
-				s.im.incrementImportCount(cur)
-				s.log.Printf("imported %d", total)
+			if err := commit(); err != nil {
+				return err
 			}
+		}
+	}
+
+	// Commit the remaining records.
+	return commit()
+}
 
-			cur = 0
+// validate is the dry run counterpart of Start() that counts the subscribers
+// in the queue that exist in the DB in batches, without writing anything.
+func (s *Session) validate() error {
+	emails := make([]string, 0, commitBatchSize)
+
+	count := func() error {
+		if len(emails) == 0 {
+			return nil
+		}
+
+		var n int
+		if err := s.im.opt.CountEmailsStmt.QueryRow(pq.Array(emails)).Scan(&n); err != nil {
+			s.log.Printf("error counting existing subscribers: %v", err)
+			return err
 		}
+
+		s.Lock()
+		s.status.Report.Existing += n
+		s.status.Report.New += len(emails) - n
+		s.status.Imported += len(emails)
+		s.Unlock()
+
+		emails = emails[:0]
+		return nil
 	}
 
-	// Queue's closed and there's nothing left to commit.
-	if cur == 0 {
-		s.im.setStatus(StatusFinished)
-		s.log.Printf("imported finished")
-		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-			s.log.Printf("error updating lists date: %v", err)
+loop:
+	for {
+		select {
+		case sub, ok := <-s.subQueue:
+			if !ok {
+				break loop
+			}
+
+			if s.isPaused() {
+				if err := count(); err != nil {
+					return err
+				}
+				if !s.waitIfPaused() {
+					break loop
+				}
+			}
+
+			emails = append(emails, sub.Email)
+			if len(emails) >= commitBatchSize {
+				if err := count(); err != nil {
+					return err
+				}
+			}
+		case <-s.stop:
+			break loop
 		}
-		s.im.sendNotif(StatusFinished)
-		return
 	}
 
-	// Queue's closed and there are records left to commit.
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		s.im.setStatus(StatusFailed)
-		s.log.Printf("error committing to DB: %v", err)
-		s.im.sendNotif(StatusFailed)
+	return count()
+}
+
+// exists checks whether a subscriber with the given e-mail exists. Errors
+// are logged and treated as the subscriber not existing.
+func (s *Session) exists(email string) bool {
+	var n int
+	if err := s.im.opt.CountEmailsStmt.QueryRow(pq.Array([]string{email})).Scan(&n); err != nil {
+		s.log.Printf("error checking existing subscriber: %v", err)
+		return false
+	}
+
+	return n > 0
+}
+
+// rowError logs an invalid row and records it in the dry run report.
+func (s *Session) rowError(line int, email, level, msg string) {
+	s.log.Printf("line %d: %s: %s", line, email, msg)
+	if !s.opt.DryRun {
 		return
 	}
 
-	s.im.incrementImportCount(cur)
-	s.im.setStatus(StatusFinished)
-	s.log.Printf("imported finished")
-	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-		s.log.Printf("error updating lists date: %v", err)
+	s.Lock()
+	defer s.Unlock()
+
+	r := s.status.Report
+	switch level {
+	case LevelWarning:
+		r.Warnings++
+	case LevelDuplicate:
+		r.Duplicates++
+	default:
+		r.Invalid++
 	}
-	s.im.sendNotif(StatusFinished)
-}
 
-// Stop stops an active import session.
-func (s *Session) Stop() {
-	close(s.subQueue)
+	if len(r.Errors) >= maxReportErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, RowError{Line: line, Email: email, Level: level, Message: msg})
 }
 
 // ExtractZIP takes a ZIP file's path and extracts all .csv files in it to
 // a temporary directory, and returns the name of the temp directory and the
 // list of extracted .csv files.
 func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, error) {
-	if s.im.isDone() {
-		return "", nil, ErrIsImporting
-	}
-
-	failed := true
-	defer func() {
-		if failed {
-			s.im.setStatus(StatusFailed)
-		}
-	}()
-
 	z, err := zip.OpenReader(srcPath)
 	if err != nil {
 		return "", nil, err
@@ -433,29 +876,17 @@ func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, err
 		return "", nil, errors.New("no CSV files found in the ZIP")
 	}
 
-	failed = false
 	return dir, files, nil
 }
 
-// LoadCSV loads a CSV file and validates and imports the subscriber entries in it.
+// LoadCSV loads a CSV file and validates and queues the subscriber entries in it
+// to be imported. It returns when the whole file is loaded or the job is stopped.
 func (s *Session) LoadCSV(srcPath string, delim rune) error {
-	if s.im.isDone() {
-		return ErrIsImporting
-	}
-
-	// Default status is "failed" in case the function
-	// returns at one of the many possible errors.
-	failed := true
-	defer func() {
-		if failed {
-			s.im.setStatus(StatusFailed)
-		}
-	}()
-
 	f, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
 	// Count the total number of lines in the file. This doesn't distinguish
 	// between "blank" and non "blank" lines, and is only used to derive
@@ -470,10 +901,10 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		return errors.New("empty file")
 	}
 
-	s.im.Lock()
+	s.Lock()
 	// Exclude the header from count.
-	s.im.status.Total = numLines - 1
-	s.im.Unlock()
+	s.status.Total = numLines - 1
+	s.Unlock()
 
 	// Rewind, now that we've done a linecount on the same handler.
 	_, _ = f.Seek(0, 0)
@@ -497,18 +928,23 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 	var (
 		lnHdr = len(hdrKeys)
 		i     = 0
+
+		// E-mails and attribute types seen in the file that are used to
+		// report duplicates and inconsistent attribute types in dry runs.
+		seen        map[string]int
+		attribTypes map[string]string
 	)
+	if s.opt.DryRun {
+		seen = make(map[string]int)
+		attribTypes = make(map[string]string)
+	}
+
 	for {
 		i++
 
 		// Check for the stop signal.
-		select {
-		case <-s.im.stop:
-			failed = false
-			close(s.subQueue)
-			s.log.Println("stop request received")
+		if s.isStopped() {
 			return nil
-		default:
 		}
 
 		cols, err := rd.Read()
@@ -516,7 +952,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 			break
 		} else if err != nil {
 			if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
-				s.log.Printf("skipping line %d. %v", i, err)
+				s.rowError(i, "", LevelError, fmt.Sprintf("skipping line. %v", err))
 				continue
 			} else {
 				s.log.Printf("error reading CSV '%s'", err)
@@ -526,7 +962,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 
 		lnCols := len(cols)
 		if lnCols < lnHdr {
-			s.log.Printf("skipping line %d. column count (%d) does not match minimum header count (%d)", i, lnCols, lnHdr)
+			s.rowError(i, "", LevelError, fmt.Sprintf("skipping line. column count (%d) does not match minimum header count (%d)", lnCols, lnHdr))
 			continue
 		}
 
@@ -546,10 +982,19 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 
 		sub, err = s.im.ValidateFields(sub)
 		if err != nil {
-			s.log.Printf("skipping line %d: %s: %v", i, sub.Email, err)
+			s.rowError(i, sub.Email, LevelError, fmt.Sprintf("skipping line: %v", err))
 			continue
 		}
 
+		// E-mails that are being blocklisted and those of existing subscribers
+		// aren't subject to the validation policy.
+		if s.opt.Mode != ModeBlocklist {
+			if err := s.im.ValidatePolicy(sub.Email); err != nil && !s.exists(sub.Email) {
+				s.rowError(i, sub.Email, LevelError, fmt.Sprintf("skipping line: %v", err))
+				continue
+			}
+		}
+
 		// JSON attributes.
 		if len(row["attributes"]) > 0 {
 			var (
@@ -557,37 +1002,126 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 				b       = []byte(row["attributes"])
 			)
 			if err := json.Unmarshal(b, &attribs); err != nil {
-				s.log.Printf("skipping invalid attributes JSON on line %d for '%s': %v", i, sub.Email, err)
+				s.rowError(i, sub.Email, LevelWarning, fmt.Sprintf("skipping invalid attributes JSON: %v", err))
 			} else {
 				sub.Attribs = attribs
 			}
 		}
 
+		if s.opt.DryRun {
+			// Duplicate e-mails in the file.
+			if ln, ok := seen[sub.Email]; ok {
+				s.rowError(i, sub.Email, LevelDuplicate, fmt.Sprintf("duplicate of line %d", ln))
+				continue
+			}
+			seen[sub.Email] = i
+
+			// Attribute values whose types differ from the ones seen earlier in the file.
+			for k, v := range sub.Attribs {
+				typ := attribType(v)
+				if typ == "null" {
+					continue
+				}
+
+				if t, ok := attribTypes[k]; !ok {
+					attribTypes[k] = typ
+				} else if t != typ {
+					s.rowError(i, sub.Email, LevelWarning, fmt.Sprintf("attribute '%s' is %s, but is %s on earlier lines", k, typ, t))
+				}
+			}
+
+			s.Lock()
+			s.status.Report.Valid++
+			s.Unlock()
+		}
+
 		// Send the subscriber to the queue.
-		s.subQueue <- sub
+		select {
+		case s.subQueue <- sub:
+		case <-s.stop:
+			return nil
+		}
 	}
 
-	close(s.subQueue)
-	failed = false
 	return nil
 }
 
-// Stop sends a signal to stop the existing import.
-func (im *Importer) Stop() {
-	if im.getStatus() != StatusImporting {
-		im.Lock()
-		im.status = Status{Status: StatusNone}
-		im.Unlock()
-		return
+// setStatus sets the job's status.
+func (s *Session) setStatus(status string) {
+	s.Lock()
+	s.status.Status = status
+	s.Unlock()
+}
+
+// incrementImportCount increments the job's "imported" counter.
+func (s *Session) incrementImportCount(n int) {
+	s.Lock()
+	s.status.Imported += n
+	s.Unlock()
+}
+
+// isDone returns true if the job is neither queued nor active.
+func (s *Session) isDone() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	switch s.status.Status {
+	case StatusStopped, StatusFinished, StatusFailed:
+		return true
 	}
+	return false
+}
 
+// isStopped returns true if a stop has been requested on the job.
+func (s *Session) isStopped() bool {
 	select {
-	case im.stop <- true:
-		im.setStatus(StatusStopping)
+	case <-s.stop:
+		return true
 	default:
+		return false
 	}
 }
 
+// isPaused returns true if the job is paused.
+func (s *Session) isPaused() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.resume != nil
+}
+
+// waitIfPaused blocks while the job is paused. It returns false
+// if the job is stopped while waiting.
+func (s *Session) waitIfPaused() bool {
+	s.RLock()
+	ch := s.resume
+	s.RUnlock()
+
+	if ch == nil {
+		return !s.isStopped()
+	}
+
+	select {
+	case <-ch:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// Write writes to the log buffer.
+func (l *logBuf) Write(b []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.buf.Write(b)
+}
+
+// Bytes returns a copy of the log buffer's contents.
+func (l *logBuf) Bytes() []byte {
+	l.Lock()
+	defer l.Unlock()
+	return append([]byte(nil), l.buf.Bytes()...)
+}
+
 // SanitizeEmail validates and sanitizes an e-mail string and returns the lowercased,
 // e-mail component of an e-mail string.
 func (im *Importer) SanitizeEmail(email string) (string, error) {
@@ -679,6 +1213,25 @@ func (s *Session) mapCSVHeaders(csvHdrs []string, knownHdrs map[string]bool) map
 	return hdrKeys
 }
 
+// attribType returns the JSON type of an attribute value.
+func attribType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
 // countLines counts the number of line breaks in a file. This does not
 // distinguish between "blank" and non "blank" lines.
 // Credit: https://stackoverflow.com/a/24563853
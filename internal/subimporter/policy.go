@@ -0,0 +1,177 @@
+package subimporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mxLookupTimeout = time.Second * 5
+	mxCacheTTL      = time.Hour
+	mxCacheSize     = 10000
+
+	// mxErrCacheTTL is the duration for which lookups that failed, eg: timed
+	// out, are cached so that the e-mails of a domain whose DNS is failing,
+	// eg: in a bulk import, don't each wait for a lookup.
+	mxErrCacheTTL = time.Minute * 5
+)
+
+// DefaultRoleAccounts are the local parts of common role addresses that are
+// blocked when Policy.BlockRoleAccounts is set without a custom list.
+var DefaultRoleAccounts = []string{
+	"abuse", "admin", "administrator", "billing", "contact", "help", "hostmaster",
+	"info", "marketing", "no-reply", "noc", "noreply", "office", "postmaster",
+	"root", "sales", "security", "support", "webmaster",
+}
+
+// Policy is the set of validation rules applied to the e-mails of new
+// subscribers on top of the syntax and domain blocklist checks.
+type Policy struct {
+	// BlockRoleAccounts blocks role addresses (info@, admin@ ...) whose
+	// local parts are in RoleAccounts, or DefaultRoleAccounts if it's empty.
+	BlockRoleAccounts bool
+	RoleAccounts      []string
+
+	// CheckMX requires the e-mail's domain to have MX records.
+	CheckMX bool
+
+	// DenyPatterns are regular expressions matched against the lowercased
+	// e-mail. E-mails that match any of them are blocked.
+	DenyPatterns []string
+}
+
+// policy is the compiled Policy.
+type policy struct {
+	roleAccounts map[string]bool
+	checkMX      bool
+	denyPatterns []*regexp.Regexp
+
+	// Cached MX lookups, and the cached domains in the order in which they
+	// were added, the oldest of which are evicted when the cache is full.
+	mxCache map[string]mxResult
+	mxOrder []string
+	mu      sync.Mutex
+}
+
+type mxResult struct {
+	ok      bool
+	expires time.Time
+}
+
+// newPolicy compiles a Policy. Invalid deny patterns, which are rejected when
+// the settings are saved, are skipped.
+func newPolicy(p Policy) *policy {
+	out := &policy{checkMX: p.CheckMX, mxCache: make(map[string]mxResult)}
+
+	if p.BlockRoleAccounts {
+		roles := p.RoleAccounts
+		if len(roles) == 0 {
+			roles = DefaultRoleAccounts
+		}
+
+		out.roleAccounts = make(map[string]bool, len(roles))
+		for _, r := range roles {
+			out.roleAccounts[strings.ToLower(strings.TrimSuffix(strings.TrimSpace(r), "@"))] = true
+		}
+	}
+
+	for _, p := range p.DenyPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			continue
+		}
+		out.denyPatterns = append(out.denyPatterns, re)
+	}
+
+	return out
+}
+
+// ValidatePolicy checks a sanitized e-mail of a new subscriber against the
+// validation policy and returns an error if it's not allowed.
+func (im *Importer) ValidatePolicy(email string) error {
+	p := im.policy
+	if p == nil {
+		return nil
+	}
+
+	i := strings.LastIndexByte(email, '@')
+	if i < 1 {
+		return errors.New(im.i18n.T("subscribers.invalidEmail"))
+	}
+	local, domain := email[:i], email[i+1:]
+
+	// Sub-addresses (info+news@) are checked by the base address.
+	if j := strings.IndexByte(local, '+'); j > 0 {
+		local = local[:j]
+	}
+	if p.roleAccounts[local] {
+		return errors.New(im.i18n.T("subscribers.roleAccount"))
+	}
+
+	for _, re := range p.denyPatterns {
+		if re.MatchString(email) {
+			return errors.New(im.i18n.T("subscribers.emailDenied"))
+		}
+	}
+
+	if p.checkMX && !p.hasMX(domain) {
+		return errors.New(im.i18n.T("subscribers.noMX"))
+	}
+
+	return nil
+}
+
+// hasMX checks whether a domain has MX records that accept mail. Domains
+// that don't exist, have no MX records, or have a null MX (RFC 7505) fail.
+// Lookups that fail for other reasons (eg: timeouts) pass so that DNS
+// issues don't block signups. Results, including the failed lookups, are cached.
+func (p *policy) hasMX(domain string) bool {
+	p.mu.Lock()
+	if r, ok := p.mxCache[domain]; ok && time.Now().Before(r.expires) {
+		p.mu.Unlock()
+		return r.ok
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), mxLookupTimeout)
+	defer cancel()
+
+	var (
+		ok  = false
+		ttl = mxCacheTTL
+	)
+	mx, err := net.DefaultResolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			ok, ttl = true, mxErrCacheTTL
+		}
+	} else {
+		for _, m := range mx {
+			if m.Host != "." && m.Host != "" {
+				ok = true
+				break
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.mxCache[domain]; !exists {
+		// Evict the oldest domains to make room.
+		for len(p.mxCache) >= mxCacheSize && len(p.mxOrder) > 0 {
+			delete(p.mxCache, p.mxOrder[0])
+			p.mxOrder = p.mxOrder[1:]
+		}
+		p.mxOrder = append(p.mxOrder, domain)
+	}
+	p.mxCache[domain] = mxResult{ok: ok, expires: time.Now().Add(ttl)}
+
+	return ok
+}
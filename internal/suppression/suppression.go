@@ -0,0 +1,295 @@
+// Package suppression fetches e-mail addresses from external suppression
+// lists: CSV files hosted at a URL and the suppression APIs of e-mail
+// providers (Sendgrid, Postmark, Mailgun).
+package suppression
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// MaxEmails is the maximum number of e-mails fetched from a source.
+	MaxEmails = 1000000
+
+	// maxCSVSize is the maximum size of a CSV file.
+	maxCSVSize = 100 * 1024 * 1024
+
+	// maxPages is the maximum number of pages fetched from a paginated API.
+	maxPages = 5000
+
+	sendgridURL = "https://api.sendgrid.com/v3/suppression/"
+	sendgridMax = 500
+
+	postmarkURL = "https://api.postmarkapp.com/message-streams/%s/suppressions/dump"
+)
+
+// Sendgrid's suppression groups.
+var sendgridLists = []string{"bounces", "blocks", "spam_reports", "invalid_emails", "unsubscribes"}
+
+// Mailgun's suppression lists.
+var mailgunLists = []string{"bounces", "unsubscribes", "complaints"}
+
+// Fetcher fetches e-mails from suppression sources.
+type Fetcher struct {
+	client *http.Client
+}
+
+// New returns a new Fetcher with the given HTTP timeout.
+func New(timeout time.Duration) *Fetcher {
+	return &Fetcher{client: &http.Client{Timeout: timeout}}
+}
+
+// Fetch fetches the e-mails from a suppression source. E-mails are lowercased
+// and de-duplicated, and values that don't look like e-mails are skipped.
+func (f *Fetcher) Fetch(s models.SuppressionSource) ([]string, error) {
+	var (
+		emails []string
+		err    error
+	)
+
+	switch s.Type {
+	case models.SuppressionTypeCSV:
+		emails, err = f.fetchCSV(s)
+	case models.SuppressionTypeSendgrid:
+		emails, err = f.fetchSendgrid(s)
+	case models.SuppressionTypePostmark:
+		emails, err = f.fetchPostmark(s)
+	case models.SuppressionTypeMailgun:
+		emails, err = f.fetchMailgun(s)
+	default:
+		return nil, fmt.Errorf("unknown suppression source type: %s", s.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean up and de-duplicate.
+	var (
+		out  = make([]string, 0, len(emails))
+		seen = make(map[string]struct{}, len(emails))
+	)
+	for _, e := range emails {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !isEmail(e) {
+			continue
+		}
+		if _, ok := seen[e]; ok {
+			continue
+		}
+		seen[e] = struct{}{}
+		out = append(out, e)
+	}
+
+	if len(out) > MaxEmails {
+		return nil, fmt.Errorf("source has more than %d e-mails", MaxEmails)
+	}
+
+	return out, nil
+}
+
+// fetchCSV fetches a CSV file and reads e-mails from its "email" column, or the
+// first column if there's no header with one. The optional key is sent as the
+// Authorization header.
+func (f *Fetcher) fetchCSV(s models.SuppressionSource) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Key != "" {
+		req.Header.Set("Authorization", s.Key)
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := csv.NewReader(io.LimitReader(resp.Body, maxCSVSize))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	var (
+		out []string
+		col = 0
+		n   = 0
+	)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %v", err)
+		}
+		n++
+
+		// Look for an "email" column in the header.
+		if n == 1 {
+			found := false
+			for i, h := range rec {
+				if strings.EqualFold(strings.TrimSpace(h), "email") {
+					col, found = i, true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+		}
+
+		if col < len(rec) {
+			out = append(out, rec[col])
+		}
+		if len(out) > MaxEmails {
+			return nil, fmt.Errorf("source has more than %d e-mails", MaxEmails)
+		}
+	}
+
+	return out, nil
+}
+
+// fetchSendgrid fetches the e-mails on all of Sendgrid's global suppression lists.
+func (f *Fetcher) fetchSendgrid(s models.SuppressionSource) ([]string, error) {
+	var out []string
+	for _, l := range sendgridLists {
+		for page := 0; page < maxPages; page++ {
+			u := sendgridURL + l + "?limit=" + strconv.Itoa(sendgridMax) + "&offset=" + strconv.Itoa(page*sendgridMax)
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+s.Key)
+
+			var res []struct {
+				Email string `json:"email"`
+			}
+			if err := f.getJSON(req, &res); err != nil {
+				return nil, fmt.Errorf("error fetching sendgrid %s: %v", l, err)
+			}
+
+			for _, r := range res {
+				out = append(out, r.Email)
+			}
+			if len(res) < sendgridMax || len(out) > MaxEmails {
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// fetchPostmark fetches the suppressions of a Postmark message stream. The URL
+// is the name of the stream (default: outbound) and the key is the server token.
+func (f *Fetcher) fetchPostmark(s models.SuppressionSource) ([]string, error) {
+	stream := s.URL
+	if stream == "" {
+		stream = "outbound"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(postmarkURL, url.PathEscape(stream)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", s.Key)
+
+	var res struct {
+		Suppressions []struct {
+			EmailAddress string `json:"EmailAddress"`
+		} `json:"Suppressions"`
+	}
+	if err := f.getJSON(req, &res); err != nil {
+		return nil, fmt.Errorf("error fetching postmark suppressions: %v", err)
+	}
+
+	out := make([]string, 0, len(res.Suppressions))
+	for _, r := range res.Suppressions {
+		out = append(out, r.EmailAddress)
+	}
+
+	return out, nil
+}
+
+// fetchMailgun fetches the e-mails on a Mailgun domain's suppression lists. The URL
+// is the domain's API base, eg: https://api.mailgun.net/v3/mg.site.com and the
+// key is the API key.
+func (f *Fetcher) fetchMailgun(s models.SuppressionSource) ([]string, error) {
+	var out []string
+	for _, l := range mailgunLists {
+		next := strings.TrimRight(s.URL, "/") + "/" + l + "?limit=1000"
+
+		for page := 0; page < maxPages && next != ""; page++ {
+			req, err := http.NewRequest(http.MethodGet, next, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.SetBasicAuth("api", s.Key)
+
+			var res struct {
+				Items []struct {
+					Address string `json:"address"`
+				} `json:"items"`
+				Paging struct {
+					Next string `json:"next"`
+				} `json:"paging"`
+			}
+			if err := f.getJSON(req, &res); err != nil {
+				return nil, fmt.Errorf("error fetching mailgun %s: %v", l, err)
+			}
+
+			for _, r := range res.Items {
+				out = append(out, r.Address)
+			}
+			if len(res.Items) == 0 || len(out) > MaxEmails {
+				break
+			}
+			next = res.Paging.Next
+		}
+	}
+
+	return out, nil
+}
+
+func (f *Fetcher) getJSON(req *http.Request, out interface{}) error {
+	resp, err := f.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (f *Fetcher) do(req *http.Request) (*http.Response, error) {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		resp.Body.Close()
+		return nil, errors.New(resp.Status + ": " + strings.TrimSpace(string(b)))
+	}
+
+	return resp, nil
+}
+
+// isEmail is a cheap check for values that look like e-mail addresses.
+func isEmail(s string) bool {
+	at := strings.LastIndexByte(s, '@')
+	return at > 0 && at < len(s)-3 && strings.IndexByte(s[at:], '.') > 0 && !strings.ContainsAny(s, " ,;<>")
+}
@@ -0,0 +1,228 @@
+// Package tracker buffers campaign view and link click registrations in memory
+// and periodically flushes them to the DB in bulk. Inserting every tracking hit
+// individually puts heavy load on the DB during large campaigns where thousands
+// of views and clicks come in every second.
+package tracker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Store represents the DB store that buffered views and clicks are flushed to.
+type Store interface {
+	RegisterCampaignViews([]models.CampaignView) error
+	RegisterLinkClicks([]models.LinkClick) error
+}
+
+// Opt represents the tracker options.
+type Opt struct {
+	// FlushInterval is the interval at which buffered views and clicks are written to the DB.
+	FlushInterval time.Duration
+
+	// BatchSize is the number of buffered views or clicks that triggers a flush
+	// before FlushInterval.
+	BatchSize int
+
+	// MaxBuffer is the maximum number of views or clicks held in memory. Hits beyond
+	// this (eg: when the DB is unavailable) are dropped.
+	MaxBuffer int
+}
+
+// Tracker buffers campaign views and link clicks.
+type Tracker struct {
+	opt   Opt
+	store Store
+	log   *log.Logger
+
+	views  []models.CampaignView
+	clicks []models.LinkClick
+	mu     sync.Mutex
+
+	chFlush chan bool
+	chStop  chan bool
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+// New returns a new instance of Tracker.
+func New(opt Opt, store Store, lo *log.Logger) *Tracker {
+	return &Tracker{
+		opt:     opt,
+		store:   store,
+		log:     lo,
+		views:   make([]models.CampaignView, 0, opt.BatchSize),
+		clicks:  make([]models.LinkClick, 0, opt.BatchSize),
+		chFlush: make(chan bool, 1),
+		chStop:  make(chan bool),
+	}
+}
+
+// AddView buffers a campaign view.
+func (t *Tracker) AddView(v models.CampaignView) {
+	t.mu.Lock()
+	if len(t.views) >= t.opt.MaxBuffer {
+		t.mu.Unlock()
+		t.log.Printf("tracker buffer full. dropping campaign view")
+		return
+	}
+
+	t.views = append(t.views, v)
+	full := len(t.views) >= t.opt.BatchSize
+	t.mu.Unlock()
+
+	if full {
+		t.signalFlush()
+	}
+}
+
+// AddClick buffers a link click.
+func (t *Tracker) AddClick(c models.LinkClick) {
+	t.mu.Lock()
+	if len(t.clicks) >= t.opt.MaxBuffer {
+		t.mu.Unlock()
+		t.log.Printf("tracker buffer full. dropping link click")
+		return
+	}
+
+	t.clicks = append(t.clicks, c)
+	full := len(t.clicks) >= t.opt.BatchSize
+	t.mu.Unlock()
+
+	if full {
+		t.signalFlush()
+	}
+}
+
+// Start starts the flusher that periodically writes the buffered views and
+// clicks to the DB in a goroutine.
+func (t *Tracker) Start() {
+	// Added before the goroutine starts so that a Close() that follows
+	// immediately waits for it.
+	t.wg.Add(1)
+	go t.run()
+}
+
+// run flushes the buffered views and clicks until the tracker is closed.
+func (t *Tracker) run() {
+	defer t.wg.Done()
+
+	tk := time.NewTicker(t.opt.FlushInterval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			t.flush(true)
+		case <-t.chFlush:
+			t.flush(true)
+		case <-t.chStop:
+			return
+		}
+	}
+}
+
+// Close stops the flusher and writes any remaining buffered views and clicks
+// to the DB. It should be called on shutdown.
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.chStop)
+	t.wg.Wait()
+
+	// There's no next flush to retry failed writes in on shutdown.
+	t.flush(false)
+}
+
+// signalFlush signals the flusher without blocking if a flush is already pending.
+func (t *Tracker) signalFlush() {
+	select {
+	case t.chFlush <- true:
+	default:
+	}
+}
+
+// flush writes the buffered views and clicks to the DB in batches. If requeue
+// is set, batches that fail to be written are put back in the buffer (up to
+// MaxBuffer) to be retried on the next flush. The rest are dropped and logged.
+func (t *Tracker) flush(requeue bool) {
+	t.mu.Lock()
+	views, clicks := t.views, t.clicks
+	t.views = make([]models.CampaignView, 0, t.opt.BatchSize)
+	t.clicks = make([]models.LinkClick, 0, t.opt.BatchSize)
+	t.mu.Unlock()
+
+	var failedViews []models.CampaignView
+	for len(views) > 0 {
+		n := len(views)
+		if n > t.opt.BatchSize {
+			n = t.opt.BatchSize
+		}
+		if err := t.store.RegisterCampaignViews(views[:n]); err != nil {
+			t.log.Printf("error flushing %d campaign views: %v", n, err)
+			failedViews = append(failedViews, views[:n]...)
+		}
+		views = views[n:]
+	}
+
+	var failedClicks []models.LinkClick
+	for len(clicks) > 0 {
+		n := len(clicks)
+		if n > t.opt.BatchSize {
+			n = t.opt.BatchSize
+		}
+		if err := t.store.RegisterLinkClicks(clicks[:n]); err != nil {
+			t.log.Printf("error flushing %d link clicks: %v", n, err)
+			failedClicks = append(failedClicks, clicks[:n]...)
+		}
+		clicks = clicks[n:]
+	}
+
+	if len(failedViews) == 0 && len(failedClicks) == 0 {
+		return
+	}
+
+	if !requeue {
+		t.log.Printf("lost %d campaign views and %d link clicks that couldn't be written", len(failedViews), len(failedClicks))
+		return
+	}
+
+	// Put the failed hits back ahead of the ones that came in during the flush,
+	// up to the buffer limit.
+	t.mu.Lock()
+	nViews, nClicks := len(t.views), len(t.clicks)
+	t.views = requeueHits(failedViews, t.views, t.opt.MaxBuffer)
+	t.clicks = requeueHits(failedClicks, t.clicks, t.opt.MaxBuffer)
+	lostViews := len(failedViews) - (len(t.views) - nViews)
+	lostClicks := len(failedClicks) - (len(t.clicks) - nClicks)
+	t.mu.Unlock()
+
+	if lostViews > 0 || lostClicks > 0 {
+		t.log.Printf("tracker buffer full. lost %d campaign views and %d link clicks that couldn't be written", lostViews, lostClicks)
+	}
+}
+
+// requeueHits prepends failed hits to the buffer, dropping the oldest failed
+// hits that don't fit in limit.
+func requeueHits[T any](failed, buf []T, limit int) []T {
+	room := limit - len(buf)
+	if room <= 0 {
+		return buf
+	}
+	if len(failed) > room {
+		failed = failed[len(failed)-room:]
+	}
+
+	out := make([]T, 0, len(failed)+len(buf))
+	out = append(out, failed...)
+	return append(out, buf...)
+}
@@ -0,0 +1,216 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/knadh/listmonk/internal/parquet"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	bqURL       = "https://bigquery.googleapis.com/bigquery/v2"
+	bqUploadURL = "https://bigquery.googleapis.com/upload/bigquery/v2"
+	bqScope     = "https://www.googleapis.com/auth/bigquery"
+	bqTokenURL  = "https://oauth2.googleapis.com/token"
+
+	// Maximum time to wait for a load job to finish.
+	bqJobTimeout = time.Minute * 10
+)
+
+// BigQueryOpt is the config of a BigQuery dataset that export files are
+// loaded into.
+type BigQueryOpt struct {
+	Project  string `koanf:"project"`
+	Dataset  string `koanf:"dataset"`
+	Location string `koanf:"location"`
+
+	// Path to the JSON key file of a service account with the BigQuery Data
+	// Editor and Job User roles.
+	CredentialsFile string `koanf:"credentials_file"`
+}
+
+type bigQueryStore struct {
+	opt    BigQueryOpt
+	client *http.Client
+}
+
+type bqJob struct {
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Status struct {
+		State       string   `json:"state"`
+		ErrorResult *bqError `json:"errorResult"`
+	} `json:"status"`
+}
+
+type bqError struct {
+	Message string `json:"message"`
+}
+
+func newBigQuery(o BigQueryOpt) (*bigQueryStore, error) {
+	if o.Project == "" || o.Dataset == "" {
+		return nil, fmt.Errorf("bigquery: project and dataset are required")
+	}
+
+	b, err := os.ReadFile(o.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("bigquery: error reading credentials: %v", err)
+	}
+
+	var key struct {
+		Email      string `json:"client_email"`
+		PrivateKey string `json:"private_key"`
+		KeyID      string `json:"private_key_id"`
+		TokenURL   string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("bigquery: error parsing credentials: %v", err)
+	}
+	if key.TokenURL == "" {
+		key.TokenURL = bqTokenURL
+	}
+
+	cfg := &jwt.Config{
+		Email:        key.Email,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.KeyID,
+		Scopes:       []string{bqScope},
+		TokenURL:     key.TokenURL,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
+	return &bigQueryStore{opt: o, client: cfg.Client(ctx)}, nil
+}
+
+// Put loads an export file into the target table with a load job, creating
+// the table if it doesn't exist, and waits for the job to finish.
+func (s *bigQueryStore) Put(target, format string, cols []parquet.Column, b []byte) error {
+	load := map[string]interface{}{
+		"destinationTable": map[string]string{
+			"projectId": s.opt.Project,
+			"datasetId": s.opt.Dataset,
+			"tableId":   target,
+		},
+		"createDisposition": "CREATE_IF_NEEDED",
+		"writeDisposition":  "WRITE_APPEND",
+	}
+
+	// Parquet files carry their schema. CSV files need one.
+	if format == FormatParquet {
+		load["sourceFormat"] = "PARQUET"
+	} else {
+		load["sourceFormat"] = "CSV"
+		load["skipLeadingRows"] = 1
+		load["allowQuotedNewlines"] = true
+		load["schema"] = bqSchema(cols)
+	}
+
+	job := map[string]interface{}{
+		"configuration": map[string]interface{}{"load": load},
+	}
+	if s.opt.Location != "" {
+		job["jobReference"] = map[string]string{"location": s.opt.Location}
+	}
+	cfg, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	// Multipart upload of the job config and the file.
+	var (
+		body bytes.Buffer
+		mw   = multipart.NewWriter(&body)
+	)
+	p, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	p.Write(cfg)
+	p, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+	p.Write(b)
+	mw.Close()
+
+	u := fmt.Sprintf("%s/projects/%s/jobs?uploadType=multipart", bqUploadURL, url.PathEscape(s.opt.Project))
+	var res bqJob
+	if err := s.do(http.MethodPost, u, "multipart/related; boundary="+mw.Boundary(), &body, &res); err != nil {
+		return err
+	}
+
+	// Wait for the job to finish.
+	deadline := time.Now().Add(bqJobTimeout)
+	for res.Status.State != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("bigquery: timed out waiting for load job %s", res.JobReference.JobID)
+		}
+		time.Sleep(time.Second * 2)
+
+		u := fmt.Sprintf("%s/projects/%s/jobs/%s?location=%s", bqURL, url.PathEscape(s.opt.Project),
+			url.PathEscape(res.JobReference.JobID), url.QueryEscape(res.JobReference.Location))
+		if err := s.do(http.MethodGet, u, "", nil, &res); err != nil {
+			return err
+		}
+	}
+
+	if res.Status.ErrorResult != nil {
+		return fmt.Errorf("bigquery: load job %s failed: %s", res.JobReference.JobID, res.Status.ErrorResult.Message)
+	}
+
+	return nil
+}
+
+func (s *bigQueryStore) do(method, u, cType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if cType != "" {
+		req.Header.Set("Content-Type", cType)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bigquery: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error bqError `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+		return fmt.Errorf("bigquery: %s: %s", resp.Status, e.Error.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// bqSchema returns the BigQuery schema of a table's columns.
+func bqSchema(cols []parquet.Column) map[string]interface{} {
+	fields := make([]map[string]string, len(cols))
+	for i, c := range cols {
+		typ := "STRING"
+		switch c.Type {
+		case parquet.Int64:
+			typ = "INTEGER"
+		case parquet.Double:
+			typ = "FLOAT"
+		case parquet.Boolean:
+			typ = "BOOLEAN"
+		case parquet.Timestamp:
+			typ = "TIMESTAMP"
+		}
+		fields[i] = map[string]string{"name": c.Name, "type": typ, "mode": "NULLABLE"}
+	}
+
+	return map[string]interface{}{"fields": fields}
+}
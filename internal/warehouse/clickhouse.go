@@ -0,0 +1,76 @@
+package warehouse
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/parquet"
+)
+
+// ClickHouseOpt is the config of a ClickHouse database that export files are
+// inserted into over its HTTP interface.
+type ClickHouseOpt struct {
+	URL      string `koanf:"url"`
+	Database string `koanf:"database"`
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+}
+
+type clickHouseStore struct {
+	opt ClickHouseOpt
+}
+
+var chFormats = map[string]string{
+	FormatParquet: "Parquet",
+	FormatCSV:     "CSVWithNames",
+}
+
+func newClickHouse(o ClickHouseOpt) (*clickHouseStore, error) {
+	if o.URL == "" {
+		return nil, fmt.Errorf("clickhouse: url is not set")
+	}
+	if o.Database == "" {
+		o.Database = "default"
+	}
+	o.URL = strings.TrimRight(o.URL, "/")
+
+	return &clickHouseStore{opt: o}, nil
+}
+
+// Put inserts an export file into the target table, which should exist.
+func (s *clickHouseStore) Put(target, format string, _ []parquet.Column, b []byte) error {
+	q := url.Values{}
+	q.Set("query", fmt.Sprintf("INSERT INTO %s.%s FORMAT %s", chIdent(s.opt.Database), chIdent(target), chFormats[format]))
+	q.Set("date_time_input_format", "best_effort")
+
+	req, err := http.NewRequest(http.MethodPost, s.opt.URL+"/?"+q.Encode(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if s.opt.Username != "" {
+		req.Header.Set("X-ClickHouse-User", s.opt.Username)
+		req.Header.Set("X-ClickHouse-Key", s.opt.Password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("clickhouse: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<12))
+		return fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// chIdent quotes a ClickHouse identifier.
+func chIdent(s string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(s) + "`"
+}
@@ -0,0 +1,76 @@
+package warehouse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/parquet"
+	"github.com/rhnvrm/simples3"
+)
+
+// S3Opt is the config of an S3 (or compatible) bucket that export files
+// are written to.
+type S3Opt struct {
+	URL        string `koanf:"url"`
+	AccessKey  string `koanf:"aws_access_key_id"`
+	SecretKey  string `koanf:"aws_secret_access_key"`
+	Region     string `koanf:"aws_default_region"`
+	Bucket     string `koanf:"bucket"`
+	BucketPath string `koanf:"bucket_path"`
+}
+
+type s3Store struct {
+	s3  *simples3.S3
+	opt S3Opt
+}
+
+var contentTypes = map[string]string{
+	FormatParquet: "application/vnd.apache.parquet",
+	FormatCSV:     "text/csv",
+}
+
+func newS3(o S3Opt) (*s3Store, error) {
+	if o.Bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is not set")
+	}
+	if o.URL == "" {
+		o.URL = fmt.Sprintf("https://s3.%s.amazonaws.com", o.Region)
+	}
+	o.URL = strings.TrimRight(o.URL, "/")
+
+	var cl *simples3.S3
+	if o.AccessKey == "" && o.SecretKey == "" {
+		// Fallback to the IAM role if there are no keys.
+		cl, _ = simples3.NewUsingIAM(o.Region)
+	}
+	if cl == nil {
+		cl = simples3.New(o.Region, o.AccessKey, o.SecretKey)
+	}
+	cl.SetEndpoint(o.URL)
+
+	return &s3Store{s3: cl, opt: o}, nil
+}
+
+// Put uploads an export file to the bucket as
+// bucket_path/target/dt=YYYY-MM-DD/target-timestamp.format, which can be
+// queried as a date partitioned table by most query engines.
+func (s *s3Store) Put(target, format string, _ []parquet.Column, b []byte) error {
+	key := fileName(target, format)
+	if p := strings.Trim(s.opt.BucketPath, "/"); p != "" {
+		key = p + "/" + key
+	}
+
+	name := key[strings.LastIndex(key, "/")+1:]
+	if _, err := s.s3.FilePut(simples3.UploadInput{
+		Bucket:      s.opt.Bucket,
+		ObjectKey:   key,
+		ContentType: contentTypes[format],
+		FileName:    name,
+		Body:        bytes.NewReader(b),
+	}); err != nil {
+		return fmt.Errorf("s3: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+// Package warehouse exports batches of rows of listmonk's tables as Parquet
+// or CSV files to a data warehouse or object store (S3, BigQuery, ClickHouse)
+// so that campaign data can be analysed without querying the production DB.
+package warehouse
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/parquet"
+	"github.com/knadh/listmonk/models"
+)
+
+// Export file formats.
+const (
+	FormatParquet = "parquet"
+	FormatCSV     = "csv"
+)
+
+// Destinations.
+const (
+	DestS3         = "s3"
+	DestBigQuery   = "bigquery"
+	DestClickHouse = "clickhouse"
+)
+
+const (
+	defaultBatchSize = 50000
+	defaultInterval  = time.Hour
+	minInterval      = time.Minute
+
+	// Timestamp format for CSV values that all the destinations can parse.
+	csvTimeFormat = "2006-01-02 15:04:05.999999Z07:00"
+)
+
+// Tables are the tables that can be exported and their columns, in the order
+// of the columns in the export queries.
+var Tables = map[string][]parquet.Column{
+	models.WarehouseTableSubscribers: {
+		{Name: "id", Type: parquet.Int64},
+		{Name: "uuid", Type: parquet.String},
+		{Name: "email", Type: parquet.String},
+		{Name: "name", Type: parquet.String},
+		{Name: "attribs", Type: parquet.String},
+		{Name: "status", Type: parquet.String},
+		{Name: "tags", Type: parquet.String},
+		{Name: "lang", Type: parquet.String},
+		{Name: "source", Type: parquet.String},
+		{Name: "created_at", Type: parquet.Timestamp},
+		{Name: "updated_at", Type: parquet.Timestamp},
+	},
+	models.WarehouseTableDeliveries: {
+		{Name: "id", Type: parquet.Int64},
+		{Name: "uuid", Type: parquet.String},
+		{Name: "name", Type: parquet.String},
+		{Name: "subject", Type: parquet.String},
+		{Name: "type", Type: parquet.String},
+		{Name: "status", Type: parquet.String},
+		{Name: "messenger", Type: parquet.String},
+		{Name: "lists", Type: parquet.String},
+		{Name: "to_send", Type: parquet.Int64},
+		{Name: "sent", Type: parquet.Int64},
+		{Name: "started_at", Type: parquet.Timestamp},
+		{Name: "created_at", Type: parquet.Timestamp},
+		{Name: "updated_at", Type: parquet.Timestamp},
+	},
+	models.WarehouseTableViews: {
+		{Name: "id", Type: parquet.Int64},
+		{Name: "campaign_id", Type: parquet.Int64},
+		{Name: "subscriber_id", Type: parquet.Int64},
+		{Name: "proxy", Type: parquet.Boolean},
+		{Name: "created_at", Type: parquet.Timestamp},
+	},
+	models.WarehouseTableClicks: {
+		{Name: "id", Type: parquet.Int64},
+		{Name: "campaign_id", Type: parquet.Int64},
+		{Name: "link_id", Type: parquet.Int64},
+		{Name: "url", Type: parquet.String},
+		{Name: "subscriber_id", Type: parquet.Int64},
+		{Name: "created_at", Type: parquet.Timestamp},
+	},
+}
+
+// TableOpt is the export config of a table.
+type TableOpt struct {
+	Enabled  bool          `koanf:"enabled"`
+	Interval time.Duration `koanf:"interval"`
+	Format   string        `koanf:"format"`
+
+	// Name of the table (or the path prefix on S3) at the destination.
+	Target string `koanf:"target"`
+}
+
+// Opt is the data warehouse config.
+type Opt struct {
+	Destination string              `koanf:"destination"`
+	Format      string              `koanf:"format"`
+	BatchSize   int                 `koanf:"batch_size"`
+	Tables      map[string]TableOpt `koanf:"tables"`
+
+	S3         S3Opt         `koanf:"s3"`
+	BigQuery   BigQueryOpt   `koanf:"bigquery"`
+	ClickHouse ClickHouseOpt `koanf:"clickhouse"`
+}
+
+// store is a destination that export files are written to.
+type store interface {
+	Put(target, format string, cols []parquet.Column, b []byte) error
+}
+
+// Warehouse exports files to the configured destination.
+type Warehouse struct {
+	opt   Opt
+	store store
+
+	// Tables that are being exported.
+	running map[string]bool
+	mu      sync.Mutex
+}
+
+var httpClient = &http.Client{Timeout: time.Minute * 5}
+
+// New returns a Warehouse for the given config.
+func New(o Opt) (*Warehouse, error) {
+	if o.BatchSize < 1 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.Format == "" {
+		o.Format = FormatParquet
+	}
+	if err := checkFormat(o.Format); err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]TableOpt, len(Tables))
+	for name, t := range o.Tables {
+		if _, ok := Tables[name]; !ok {
+			return nil, fmt.Errorf("unknown table '%s'", name)
+		}
+
+		if t.Interval == 0 {
+			t.Interval = defaultInterval
+		} else if t.Interval < minInterval {
+			t.Interval = minInterval
+		}
+		if t.Format == "" {
+			t.Format = o.Format
+		}
+		if err := checkFormat(t.Format); err != nil {
+			return nil, fmt.Errorf("table '%s': %v", name, err)
+		}
+		if t.Target == "" {
+			t.Target = "listmonk_" + name
+		}
+		tables[name] = t
+	}
+	o.Tables = tables
+
+	var (
+		st  store
+		err error
+	)
+	switch o.Destination {
+	case DestS3:
+		st, err = newS3(o.S3)
+	case DestBigQuery:
+		st, err = newBigQuery(o.BigQuery)
+	case DestClickHouse:
+		st, err = newClickHouse(o.ClickHouse)
+	default:
+		return nil, fmt.Errorf("unknown destination '%s'. Select s3, bigquery, or clickhouse", o.Destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Warehouse{
+		opt:     o,
+		store:   st,
+		running: map[string]bool{},
+	}, nil
+}
+
+// Destination returns the name of the destination.
+func (w *Warehouse) Destination() string {
+	return w.opt.Destination
+}
+
+// BatchSize returns the maximum number of rows in an export file.
+func (w *Warehouse) BatchSize() int {
+	return w.opt.BatchSize
+}
+
+// Table returns the export config of a table. Tables that aren't configured
+// are disabled.
+func (w *Warehouse) Table(name string) TableOpt {
+	return w.opt.Tables[name]
+}
+
+// Lock marks a table as being exported and returns false if it already is.
+func (w *Warehouse) Lock(table string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running[table] {
+		return false
+	}
+	w.running[table] = true
+	return true
+}
+
+// Unlock marks a table as not being exported.
+func (w *Warehouse) Unlock(table string) {
+	w.mu.Lock()
+	delete(w.running, table)
+	w.mu.Unlock()
+}
+
+// IsRunning returns true if a table is being exported.
+func (w *Warehouse) IsRunning(table string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running[table]
+}
+
+// Export encodes rows of a table in the table's format and writes them to
+// the destination.
+func (w *Warehouse) Export(table string, rows [][]interface{}) error {
+	t, ok := w.opt.Tables[table]
+	if !ok {
+		return fmt.Errorf("table '%s' is not configured", table)
+	}
+
+	b, err := Encode(t.Format, Tables[table], rows)
+	if err != nil {
+		return err
+	}
+
+	return w.store.Put(t.Target, t.Format, Tables[table], b)
+}
+
+// Encode encodes rows as a Parquet or CSV (with a header row) file.
+func Encode(format string, cols []parquet.Column, rows [][]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if format == FormatParquet {
+		if err := parquet.Write(&b, cols, rows); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	wr := csv.NewWriter(&b)
+	rec := make([]string, len(cols))
+	for i, c := range cols {
+		rec[i] = c.Name
+	}
+	if err := wr.Write(rec); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		for i := range rec {
+			rec[i] = ""
+			if i >= len(r) {
+				continue
+			}
+
+			switch v := r[i].(type) {
+			case nil:
+			case string:
+				rec[i] = v
+			case []byte:
+				rec[i] = string(v)
+			case int64:
+				rec[i] = strconv.FormatInt(v, 10)
+			case float64:
+				rec[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				rec[i] = strconv.FormatBool(v)
+			case time.Time:
+				rec[i] = v.UTC().Format(csvTimeFormat)
+			default:
+				rec[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		if err := wr.Write(rec); err != nil {
+			return nil, err
+		}
+	}
+	wr.Flush()
+
+	return b.Bytes(), wr.Error()
+}
+
+// fileName returns a unique name for an export file of a target table.
+func fileName(target, format string) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf("%s/dt=%s/%s-%d.%s", target, now.Format("2006-01-02"), target, now.UnixNano(), format)
+}
+
+func checkFormat(f string) error {
+	if f != FormatParquet && f != FormatCSV {
+		return fmt.Errorf("unknown format '%s'. Select parquet or csv", f)
+	}
+	return nil
+}
@@ -49,18 +49,79 @@ const (
 	CampaignContentTypeMarkdown = "markdown"
 	CampaignContentTypePlain    = "plain"
 
+	// Kinds of campaign revisions.
+	CampaignRevisionAutosave = "autosave"
+	CampaignRevisionUpdate   = "update"
+
+	// Audiences and statuses of follow-up campaigns.
+	FollowupAudienceOpeners  = "openers"
+	FollowupAudienceClickers = "clickers"
+	FollowupStatusWaiting    = "waiting"
+	FollowupStatusScheduled  = "scheduled"
+	FollowupStatusCancelled  = "cancelled"
+
+	// Statuses of report results.
+	ReportStatusSuccess = "success"
+	ReportStatusError   = "error"
+
+	// Tables that are exported to the data warehouse and the statuses of their exports.
+	WarehouseTableSubscribers = "subscribers"
+	WarehouseTableDeliveries  = "deliveries"
+	WarehouseTableViews       = "views"
+	WarehouseTableClicks      = "clicks"
+	WarehouseStatusSuccess    = "success"
+	WarehouseStatusError      = "error"
+
+	// Actions and statuses of re-permission runs.
+	RepermissionActionRemove      = "remove"
+	RepermissionActionUnsubscribe = "unsubscribe"
+	RepermissionStatusWaiting     = "waiting"
+	RepermissionStatusDone        = "done"
+	RepermissionStatusCancelled   = "cancelled"
+
+	// Outbox review states of campaigns.
+	CampaignOutboxRendering = "rendering"
+	CampaignOutboxPending   = "pending"
+	CampaignOutboxApproved  = "approved"
+
+	// RSVP responses to campaign calendar invites.
+	RSVPAccepted  = "accepted"
+	RSVPDeclined  = "declined"
+	RSVPTentative = "tentative"
+
 	// List.
 	ListTypePrivate = "private"
 	ListTypePublic  = "public"
 	ListOptinSingle = "single"
 	ListOptinDouble = "double"
 
+	// Double opt-in confirmation methods of lists.
+	OptinMethodLink = "link"
+	OptinMethodCode = "code"
+	OptinMethodSMS  = "sms"
+
+	// Compliance profiles that lists can be assigned.
+	ComplianceCANSPAM = "can-spam"
+	ComplianceCASL    = "casl"
+	ComplianceGDPR    = "gdpr"
+
 	// User.
 	UserTypeUser       = "user"
 	UserTypeAPI        = "api"
 	UserStatusEnabled  = "enabled"
 	UserStatusDisabled = "disabled"
 
+	// Subscriber and subscription sources (provenance).
+	SourceAdmin   = "admin"
+	SourceAPI     = "api"
+	SourceForm    = "form"
+	SourceImport  = "import"
+	SourceArchive = "archive"
+	SourceOptin   = "optin"
+	SourceStripe  = "stripe"
+	SourceLead    = "lead"
+	SourceEvent   = "event"
+
 	// Role.
 	RoleTypeUser = "user"
 	RoleTypeList = "list"
@@ -75,6 +136,10 @@ const (
 	EmailHeaderSubscriberUUID = "X-Listmonk-Subscriber"
 	EmailHeaderCampaignUUID   = "X-Listmonk-Campaign"
 
+	// Header with the campaign and list identifiers for feedback loops,
+	// eg: Gmail Postmaster Tools.
+	EmailHeaderFeedbackID = "Feedback-ID"
+
 	// Standard e-mail headers.
 	EmailHeaderDate        = "Date"
 	EmailHeaderFrom        = "From"
@@ -87,9 +152,44 @@ const (
 	BounceTypeSoft      = "soft"
 	BounceTypeComplaint = "complaint"
 
+	// Alert rule metrics.
+	AlertMetricBounce    = "bounce"
+	AlertMetricComplaint = "complaint"
+
+	// External suppression list sources.
+	SuppressionTypeCSV      = "csv"
+	SuppressionTypeSendgrid = "sendgrid"
+	SuppressionTypePostmark = "postmark"
+	SuppressionTypeMailgun  = "mailgun"
+
+	// Suppression sync statuses.
+	SuppressionSyncPending  = "pending"
+	SuppressionSyncApplied  = "applied"
+	SuppressionSyncRejected = "rejected"
+	SuppressionSyncFailed   = "failed"
+
 	// Templates.
 	TemplateTypeCampaign = "campaign"
 	TemplateTypeTx       = "tx"
+
+	// Search result types.
+	SearchTypeCampaign   = "campaign"
+	SearchTypeTemplate   = "template"
+	SearchTypeList       = "list"
+	SearchTypeSubscriber = "subscriber"
+
+	// Trash item types.
+	TrashTypeCampaign = "campaign"
+	TrashTypeList     = "list"
+	TrashTypeTemplate = "template"
+
+	// Event log types.
+	EventLogCampaign = "campaign"
+	EventLogImport   = "import"
+	EventLogSettings = "settings"
+	EventLogBounce   = "bounce"
+
+	EventLogSubscribers = "subscribers"
 )
 
 // Headers represents an array of string maps used to represent SMTP, HTTP headers etc.
@@ -122,9 +222,16 @@ var regTplFuncs = []regTplFunc{
 	},
 
 	{
-		regExp:  regexp.MustCompile(`{{(\s+)?(TrackView|UnsubscribeURL|ManageURL|OptinURL|MessageURL)(\s+)?}}`),
+		regExp:  regexp.MustCompile(`{{(\s+)?(TrackView|UnsubscribeURL|ManageURL|OptinURL|MessageURL|ReferralCode|ReferralURL|Lang|IsRTL|TextDir)(\s+)?}}`),
 		replace: `{{ $2 . }}`,
 	},
+
+	// Functions that take arguments, eg: {{ FormatDate .Campaign.SendAt "2 Jan 2006" }}
+	// and {{ Attr "first_name" "there" }}.
+	{
+		regExp:  regexp.MustCompile(`{{(\s+)?(FormatDate|FormatNumber|RSVPURL|Attr)\s+(.+?)(\s+)?}}`),
+		replace: `{{ $2 $3 . }}`,
+	},
 }
 
 // AdminNotifCallback is a callback function that's called
@@ -139,6 +246,9 @@ type PageResults struct {
 	Total   int    `json:"total"`
 	PerPage int    `json:"per_page"`
 	Page    int    `json:"page"`
+
+	// Cursor of the next page with cursor pagination. 0 on the last page.
+	NextCursor int `json:"next_cursor,omitempty"`
 }
 
 // Base holds common fields shared across models.
@@ -191,6 +301,7 @@ type User struct {
 	ListPermissionsMap map[int]map[string]struct{} `db:"-" json:"-"`
 	GetListIDs         []int                       `db:"-" json:"-"`
 	ManageListIDs      []int                       `db:"-" json:"-"`
+	SendListIDs        []int                       `db:"-" json:"-"`
 	HasPassword        bool                        `db:"-" json:"-"`
 }
 
@@ -239,7 +350,19 @@ type Subscriber struct {
 	Name    string         `db:"name" json:"name" form:"name"`
 	Attribs JSON           `db:"attribs" json:"attribs"`
 	Status  string         `db:"status" json:"status"`
+	Tags    pq.StringArray `db:"tags" json:"tags"`
+	Lang    string         `db:"lang" json:"lang"`
 	Lists   types.JSONText `db:"lists" json:"lists"`
+
+	// Source is the provenance of the subscriber, eg: import:12, api:apiuser, admin:john, form.
+	Source string `db:"source" json:"source"`
+
+	// ReferralCode is the code in the subscriber's referral link.
+	ReferralCode string `db:"referral_code" json:"referral_code"`
+
+	// PublicKey is the subscriber's S/MIME certificate or OpenPGP public key
+	// that e-mails to them are encrypted with.
+	PublicKey string `db:"public_key" json:"public_key"`
 }
 type subLists struct {
 	SubscriberID int            `db:"subscriber_id"`
@@ -251,9 +374,54 @@ type Subscription struct {
 	List
 	SubscriptionStatus    null.String     `db:"subscription_status" json:"subscription_status"`
 	SubscriptionCreatedAt null.String     `db:"subscription_created_at" json:"subscription_created_at"`
+	SubscriptionSource    null.String     `db:"subscription_source" json:"subscription_source"`
 	Meta                  json.RawMessage `db:"meta" json:"meta"`
 }
 
+// Consent is a proof-of-consent record. Records are appended to the
+// `consent` array in a subscription's meta whenever consent is given,
+// eg: on a public form subscription and on double opt-in confirmation.
+type Consent struct {
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Source      string    `json:"source"`
+	TextVersion string    `json:"text_version"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SubscriptionChange is a change of the status of a subscriber's subscription
+// to a list, with the reason code and note for the change.
+type SubscriptionChange struct {
+	ListID         int    `db:"list_id" json:"list_id"`
+	ListUUID       string `db:"list_uuid" json:"list_uuid"`
+	ListName       string `db:"list_name" json:"list_name"`
+	PreviousStatus string `db:"previous_status" json:"previous_status"`
+	Status         string `db:"status" json:"status"`
+	Reason         string `db:"reason" json:"reason"`
+	Note           string `db:"note" json:"note"`
+}
+
+// ListReferrer represents a subscriber on a list's referral leaderboard
+// with the number of subscribers they referred to the list.
+type ListReferrer struct {
+	ID           int    `db:"id" json:"id"`
+	UUID         string `db:"uuid" json:"uuid"`
+	Email        string `db:"email" json:"email"`
+	Name         string `db:"name" json:"name"`
+	ReferralCode string `db:"referral_code" json:"referral_code"`
+	Referrals    int    `db:"referrals" json:"referrals"`
+}
+
+// StripeSubscription represents a subscriber's Stripe subscription and the
+// paid lists that it grants access to.
+type StripeSubscription struct {
+	ID               string         `db:"id" json:"id"`
+	CustomerID       string         `db:"customer_id" json:"customer_id"`
+	Status           string         `db:"status" json:"status"`
+	CurrentPeriodEnd null.Time      `db:"current_period_end" json:"current_period_end"`
+	Lists            pq.StringArray `db:"lists" json:"lists"`
+}
+
 // SubscriberExportProfile represents a subscriber's collated data in JSON for export.
 type SubscriberExportProfile struct {
 	Email         string          `db:"email" json:"-"`
@@ -293,10 +461,29 @@ type List struct {
 	Optin            string         `db:"optin" json:"optin"`
 	Tags             pq.StringArray `db:"tags" json:"tags"`
 	Description      string         `db:"description" json:"description"`
+	GroupID          null.Int       `db:"group_id" json:"group_id"`
+	LogoURL          string         `db:"logo_url" json:"logo_url"`
+	Lang             string         `db:"lang" json:"lang"`
+	StripePriceID    string         `db:"stripe_price_id" json:"stripe_price_id"`
+	OptinMethod      string         `db:"optin_method" json:"optin_method"`
+	Domain           string         `db:"domain" json:"domain"`
+	Version          int            `db:"version" json:"version"`
 	SubscriberCount  int            `db:"subscriber_count" json:"subscriber_count"`
 	SubscriberCounts StringIntMap   `db:"subscriber_statuses" json:"subscriber_statuses"`
 	SubscriberID     int            `db:"subscriber_id" json:"-"`
 
+	// Rules is the structured subscriber filter of a dynamic list. The
+	// subscribers that match it are the list's members.
+	Rules null.JSON `db:"rules" json:"rules"`
+
+	// ComplianceProfile is the profile (eg: can-spam) whose requirements the
+	// campaigns sent to the list have to meet.
+	ComplianceProfile string `db:"compliance_profile" json:"compliance_profile"`
+
+	// SenderProfile overrides the fields of the instance's sender profile
+	// that it sets in the messages of campaigns sent to the list.
+	SenderProfile SenderProfile `db:"sender_profile" json:"sender_profile"`
+
 	// This is only relevant when querying the lists of a subscriber.
 	SubscriptionStatus    string    `db:"subscription_status" json:"subscription_status,omitempty"`
 	SubscriptionCreatedAt null.Time `db:"subscription_created_at" json:"subscription_created_at,omitempty"`
@@ -307,6 +494,43 @@ type List struct {
 	Total int `db:"total" json:"-"`
 }
 
+// ListGroup represents a group of lists that campaigns can target. The group's
+// subscriber counts are aggregated across its member lists.
+type ListGroup struct {
+	Base
+
+	Name             string          `db:"name" json:"name"`
+	Description      string          `db:"description" json:"description"`
+	Lists            json.RawMessage `db:"lists" json:"lists"`
+	SubscriberCount  int             `db:"subscriber_count" json:"subscriber_count"`
+	SubscriberCounts StringIntMap    `db:"subscriber_statuses" json:"subscriber_statuses"`
+}
+
+// ListArchive represents a portable export of a list with its subscribers and
+// their subscription states. Archives are streamed, so Subscribers is always
+// the last field.
+type ListArchive struct {
+	Version     int                     `json:"version"`
+	List        List                    `json:"list"`
+	Subscribers []ListArchiveSubscriber `json:"subscribers"`
+}
+
+// ListArchiveSubscriber represents a subscriber and their subscription in a list archive.
+type ListArchiveSubscriber struct {
+	ID        int             `db:"id" json:"-"`
+	UUID      string          `db:"uuid" json:"uuid"`
+	Email     string          `db:"email" json:"email"`
+	Name      string          `db:"name" json:"name"`
+	Attribs   json.RawMessage `db:"attribs" json:"attribs"`
+	Status    string          `db:"status" json:"status"`
+	Tags      pq.StringArray  `db:"tags" json:"tags"`
+	CreatedAt null.Time       `db:"created_at" json:"created_at"`
+
+	SubscriptionStatus    string          `db:"subscription_status" json:"subscription_status"`
+	SubscriptionMeta      json.RawMessage `db:"subscription_meta" json:"subscription_meta"`
+	SubscriptionCreatedAt null.Time       `db:"subscription_created_at" json:"subscription_created_at"`
+}
+
 // Campaign represents an e-mail campaign.
 type Campaign struct {
 	Base
@@ -330,13 +554,74 @@ type Campaign struct {
 	ArchiveSlug       null.String     `db:"archive_slug" json:"archive_slug"`
 	ArchiveTemplateID int             `db:"archive_template_id" json:"archive_template_id"`
 	ArchiveMeta       json.RawMessage `db:"archive_meta" json:"archive_meta"`
+	SubscriberQueryID null.Int        `db:"subscriber_query_id" json:"subscriber_query_id"`
+	FolderID          null.Int        `db:"folder_id" json:"folder_id"`
+
+	// List groups that are expanded to their member lists at send time.
+	ListGroupIDs pq.Int64Array `db:"list_group_ids" json:"list_groups"`
+
+	// IDs of the lists (including the ones in list groups) that a running
+	// campaign is sent to. Only set by the next-campaigns query.
+	ListIDs pq.Int64Array `db:"list_ids" json:"-"`
+
+	// Templated URLs from which per-subscriber attachments are fetched at send
+	// time, eg: https://invoices.site.com/{{ .Subscriber.UUID }}.pdf
+	AttachmentURLs pq.StringArray `db:"attachment_urls" json:"attachment_urls"`
+
+	// Optional calendar event whose invite (ICS) is attached to every message.
+	Event *CampaignEvent `db:"event" json:"event"`
+
+	// IANA timezone that SendAt was scheduled in, eg: Europe/Berlin.
+	SendAtTZ string `db:"send_at_tz" json:"send_at_tz"`
+
+	// Optional inbox preview text (preheader) that is injected into the
+	// rendered HTML as a hidden block right after <body>.
+	Preheader string `db:"preheader" json:"preheader"`
+
+	// Language variants of the subject and body. A subscriber gets the variant
+	// of their language, or the campaign's own subject and body if there's none.
+	Variants CampaignVariants `db:"variants" json:"variants"`
+
+	// Sender is the sender profile of the first of the campaign's lists that
+	// has one, which is merged over the instance's profile in messages.
+	Sender SenderProfile `db:"sender_profile" json:"-"`
+
+	// Version is incremented on every edit and is used to detect concurrent edits.
+	Version int `db:"version" json:"version"`
+
+	// The campaign whose soft-bounced recipients this campaign retries.
+	RetryOf      null.Int `db:"retry_of" json:"retry_of"`
+	RetryAttempt int      `db:"retry_attempt" json:"retry_attempt"`
+
+	// Outbox review state of the campaign's messages. Empty if the campaign
+	// isn't held for review.
+	Outbox string `db:"outbox" json:"outbox"`
+
+	// Messenger whose circuit breaker paused the campaign. It's resumed when
+	// the messenger recovers, unless it's paused or resumed by hand.
+	PausedByBreaker string `db:"paused_by_breaker" json:"paused_by_breaker"`
+
+	// Simulate runs the campaign through the pipeline with its messages
+	// discarded instead of delivered.
+	Simulate bool `db:"simulate" json:"simulate"`
 
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
 	ArchiveTemplateBody string             `db:"archive_template_body" json:"-"`
 	Tpl                 *template.Template `json:"-"`
 	SubjectTpl          *txttpl.Template   `json:"-"`
+	PreheaderTpl        *txttpl.Template   `json:"-"`
 	AltBodyTpl          *template.Template `json:"-"`
+	AttachmentURLTpls   []*txttpl.Template `json:"-"`
+
+	// StaticBody is the pre-rendered body of a campaign whose template and
+	// body have no dynamic expressions.
+	StaticBody []byte `json:"-"`
+
+	// Large bodies are stored gzipped (BodyGz) or as an object in the media
+	// store (BodyRef) with an empty Body, and are loaded into Body on retrieval.
+	BodyGz  []byte `db:"body_gz" json:"-"`
+	BodyRef string `db:"body_ref" json:"-"`
 
 	// List of media (attachment) IDs obtained from the next-campaign query
 	// while sending a campaign.
@@ -350,12 +635,276 @@ type Campaign struct {
 	Total int `db:"total" json:"-"`
 }
 
+// CampaignEvent is a calendar event on a campaign. A per-subscriber calendar
+// invite (ICS) for the event is attached to every message of the campaign.
+type CampaignEvent struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	URL         string    `json:"url"`
+	StartAt     time.Time `json:"start_at"`
+	EndAt       time.Time `json:"end_at"`
+}
+
+// CampaignVariant is a language variant of a campaign's subject and body.
+// The variant has the campaign's content type.
+type CampaignVariant struct {
+	Lang    string `json:"lang"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+
+	// Compiled templates of the variant.
+	Tpl        *template.Template `json:"-"`
+	SubjectTpl *txttpl.Template   `json:"-"`
+}
+
+// CampaignVariants is the list of language variants of a campaign.
+type CampaignVariants []CampaignVariant
+
+// CampaignVariantStats has the send and engagement counts of a campaign's
+// language variant. Lang is empty for the campaign's default content.
+type CampaignVariantStats struct {
+	Lang   string `db:"lang" json:"lang"`
+	Sent   int    `db:"sent" json:"sent"`
+	Views  int    `db:"views" json:"views"`
+	Clicks int    `db:"clicks" json:"clicks"`
+}
+
+// OutboxMessage is a rendered message of a campaign held in the outbox for review.
+type OutboxMessage struct {
+	ID           int64     `db:"id" json:"id"`
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	SubscriberID int       `db:"subscriber_id" json:"subscriber_id"`
+	Email        string    `db:"email" json:"email"`
+	Name         string    `db:"name" json:"name"`
+	Subject      string    `db:"subject" json:"subject"`
+	Body         string    `db:"body" json:"body,omitempty"`
+	AltBody      string    `db:"altbody" json:"altbody,omitempty"`
+	CreatedAt    null.Time `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of messages in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// PostmasterStat is a day's aggregate reputation and spam stats of a sending
+// domain, or of one of its Feedback-ID identifiers, imported from a mailbox
+// provider's postmaster tools.
+type PostmasterStat struct {
+	ID                int64        `db:"id" json:"id"`
+	Provider          string       `db:"provider" json:"provider"`
+	Domain            string       `db:"domain" json:"domain"`
+	Date              string       `db:"date" json:"date"`
+	Identifier        string       `db:"identifier" json:"identifier"`
+	CampaignID        null.Int     `db:"campaign_id" json:"campaign_id"`
+	CampaignName      null.String  `db:"campaign_name" json:"campaign_name"`
+	SpamRate          null.Float64 `db:"spam_rate" json:"spam_rate"`
+	DomainReputation  string       `db:"domain_reputation" json:"domain_reputation"`
+	IPReputation      string       `db:"ip_reputation" json:"ip_reputation"`
+	SPFSuccessRate    null.Float64 `db:"spf_success_rate" json:"spf_success_rate"`
+	DKIMSuccessRate   null.Float64 `db:"dkim_success_rate" json:"dkim_success_rate"`
+	DMARCSuccessRate  null.Float64 `db:"dmarc_success_rate" json:"dmarc_success_rate"`
+	DeliveryErrorRate null.Float64 `db:"delivery_error_rate" json:"delivery_error_rate"`
+	Meta              JSON         `db:"meta" json:"meta"`
+	UpdatedAt         null.Time    `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of stats in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// ArchiveSearchResult is a sent campaign that matches a full-text search with
+// a snippet of its body. The matched words in the snippet are delimited by
+// \x02 and \x03.
+type ArchiveSearchResult struct {
+	ID          int         `db:"id" json:"id"`
+	UUID        string      `db:"uuid" json:"uuid"`
+	Name        string      `db:"name" json:"name"`
+	Subject     string      `db:"subject" json:"subject"`
+	Archive     bool        `db:"archive" json:"archive"`
+	ArchiveSlug null.String `db:"archive_slug" json:"archive_slug"`
+	CreatedAt   null.Time   `db:"created_at" json:"created_at"`
+	SendAt      null.Time   `db:"send_at" json:"send_at"`
+	Snippet     string      `db:"snippet" json:"snippet"`
+
+	// Pseudofield for getting the total number of results in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// CampaignRetry is a campaign in the chain of soft-bounce retries of a
+// campaign (attempt 0) with its send, bounce, and engagement counts.
+type CampaignRetry struct {
+	ID           int       `db:"id" json:"id"`
+	UUID         string    `db:"uuid" json:"uuid"`
+	Name         string    `db:"name" json:"name"`
+	Status       string    `db:"status" json:"status"`
+	RetryOf      int       `db:"retry_of" json:"retry_of"`
+	RetryAttempt int       `db:"retry_attempt" json:"retry_attempt"`
+	SendAt       null.Time `db:"send_at" json:"send_at"`
+	StartedAt    null.Time `db:"started_at" json:"started_at"`
+	ToSend       int       `db:"to_send" json:"to_send"`
+	Sent         int       `db:"sent" json:"sent"`
+	SoftBounces  int       `db:"soft_bounces" json:"soft_bounces"`
+	Bounces      int       `db:"bounces" json:"bounces"`
+	Views        int       `db:"views" json:"views"`
+	Clicks       int       `db:"clicks" json:"clicks"`
+}
+
+// CampaignFollowup is a follow-up campaign that's automatically scheduled to the
+// openers or clickers of its parent campaign DelayDays after the parent finishes.
+type CampaignFollowup struct {
+	ID             int       `db:"id" json:"id"`
+	ParentID       int       `db:"parent_id" json:"parent_id"`
+	CampaignID     int       `db:"campaign_id" json:"campaign_id"`
+	Depth          int       `db:"depth" json:"depth"`
+	Name           string    `db:"name" json:"name"`
+	CampaignStatus string    `db:"campaign_status" json:"campaign_status"`
+	Audience       string    `db:"audience" json:"audience"`
+	URL            string    `db:"url" json:"url"`
+	DelayDays      int       `db:"delay_days" json:"delay_days"`
+	Status         string    `db:"status" json:"status"`
+	SendAt         null.Time `db:"send_at" json:"send_at"`
+	AudienceSize   int       `db:"audience_size" json:"audience_size"`
+	ToSend         int       `db:"to_send" json:"to_send"`
+	Sent           int       `db:"sent" json:"sent"`
+	Views          int       `db:"views" json:"views"`
+	Clicks         int       `db:"clicks" json:"clicks"`
+	CreatedAt      null.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      null.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Repermission is a re-permission (re-opt-in) run. Its opt-in campaign asks the
+// unconfirmed subscribers of double opt-in lists to confirm, and the targeted
+// subscriptions that are still unconfirmed at the deadline are removed or
+// unsubscribed depending on Action.
+type Repermission struct {
+	ID             int           `db:"id" json:"id"`
+	CampaignID     int           `db:"campaign_id" json:"campaign_id"`
+	CampaignName   string        `db:"campaign_name" json:"campaign_name"`
+	CampaignStatus string        `db:"campaign_status" json:"campaign_status"`
+	ListIDs        pq.Int64Array `db:"list_ids" json:"list_ids"`
+	Deadline       null.Time     `db:"deadline" json:"deadline"`
+	Action         string        `db:"action" json:"action"`
+	Status         string        `db:"status" json:"status"`
+	ToSend         int           `db:"to_send" json:"to_send"`
+	Sent           int           `db:"sent" json:"sent"`
+	Targeted       int           `db:"targeted" json:"targeted"`
+	Confirmed      int           `db:"confirmed" json:"confirmed"`
+	Unsubscribed   int           `db:"unsubscribed" json:"unsubscribed"`
+	Pending        int           `db:"pending" json:"pending"`
+	Removed        int           `db:"removed" json:"removed"`
+	CreatedAt      null.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt      null.Time     `db:"updated_at" json:"updated_at"`
+	CompletedAt    null.Time     `db:"completed_at" json:"completed_at"`
+}
+
+// RepermissionTarget is the number of unconfirmed subscriptions to a list that
+// a re-permission run would target.
+type RepermissionTarget struct {
+	ListID      int    `db:"list_id" json:"list_id"`
+	ListName    string `db:"list_name" json:"list_name"`
+	Optin       string `db:"optin" json:"optin"`
+	Unconfirmed int    `db:"unconfirmed" json:"unconfirmed"`
+}
+
+// CampaignDomainStats has the send, bounce, and engagement counts of a
+// campaign's recipients on an e-mail domain. Rates are percentages of Sent.
+type CampaignDomainStats struct {
+	Domain      string  `db:"domain" json:"domain"`
+	Sent        int     `db:"sent" json:"sent"`
+	Bounces     int     `db:"bounces" json:"bounces"`
+	HardBounces int     `db:"hard_bounces" json:"hard_bounces"`
+	Complaints  int     `db:"complaints" json:"complaints"`
+	Views       int     `db:"views" json:"views"`
+	Clicks      int     `db:"clicks" json:"clicks"`
+	BounceRate  float64 `db:"bounce_rate" json:"bounce_rate"`
+	ViewRate    float64 `db:"view_rate" json:"view_rate"`
+}
+
+// CampaignRevision is an autosaved, unsaved state of a campaign being edited,
+// or the content of a campaign as it was before an update. Data is the
+// campaign's fields (JSON keys) as they were sent by the editor or saved.
+type CampaignRevision struct {
+	ID            int             `db:"id" json:"id"`
+	CampaignID    int             `db:"campaign_id" json:"campaign_id"`
+	Kind          string          `db:"kind" json:"kind"`
+	Data          json.RawMessage `db:"data" json:"data,omitempty"`
+	CreatedBy     null.Int        `db:"created_by" json:"created_by"`
+	CreatedByName string          `db:"created_by_name" json:"created_by_name"`
+	CreatedAt     null.Time       `db:"created_at" json:"created_at"`
+}
+
+// CampaignChecklistItem is an item on a campaign's pre-send checklist.
+type CampaignChecklistItem struct {
+	Item          string    `db:"item" json:"item"`
+	Done          bool      `db:"done" json:"done"`
+	CreatedBy     null.Int  `db:"created_by" json:"created_by"`
+	CreatedByName string    `db:"created_by_name" json:"created_by_name"`
+	CreatedAt     null.Time `db:"created_at" json:"created_at"`
+}
+
+// CampaignPreset is a saved, reusable campaign preset (content, headers,
+// messenger, default lists and UTM parameters) that new campaigns are created from.
+type CampaignPreset struct {
+	Base
+
+	Name        string         `db:"name" json:"name"`
+	Description string         `db:"description" json:"description"`
+	Subject     string         `db:"subject" json:"subject"`
+	Preheader   string         `db:"preheader" json:"preheader"`
+	FromEmail   string         `db:"from_email" json:"from_email"`
+	Body        string         `db:"body" json:"body"`
+	AltBody     null.String    `db:"altbody" json:"altbody"`
+	ContentType string         `db:"content_type" json:"content_type"`
+	TemplateID  null.Int       `db:"template_id" json:"template_id"`
+	Headers     Headers        `db:"headers" json:"headers"`
+	Messenger   string         `db:"messenger" json:"messenger"`
+	Tags        pq.StringArray `db:"tags" json:"tags"`
+	ListIDs     pq.Int64Array  `db:"list_ids" json:"lists"`
+	UTM         CampaignUTM    `db:"utm" json:"utm"`
+}
+
+// CampaignUTM has the UTM parameters that are added to the links in a campaign's body.
+type CampaignUTM struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+}
+
+// SenderProfile is the organisation that sends messages. Its details are
+// available to campaign templates as .Sender, eg: for footers.
+type SenderProfile struct {
+	CompanyName string       `json:"company_name"`
+	Address     string       `json:"address"`
+	Links       []SenderLink `json:"links"`
+}
+
+// SenderLink is a link on a sender profile, eg: to a social media profile.
+type SenderLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// CampaignRSVPs has the counts of RSVP responses to a campaign's calendar invite.
+type CampaignRSVPs struct {
+	Accepted  int `db:"accepted" json:"accepted"`
+	Declined  int `db:"declined" json:"declined"`
+	Tentative int `db:"tentative" json:"tentative"`
+}
+
 // CampaignMeta contains fields tracking a campaign's progress.
 type CampaignMeta struct {
 	CampaignID int `db:"campaign_id" json:"-"`
 	Views      int `db:"views" json:"views"`
 	Clicks     int `db:"clicks" json:"clicks"`
-	Bounces    int `db:"bounces" json:"bounces"`
+
+	// Views excluding those from known prefetching image proxies.
+	ViewsAdjusted int `db:"views_adjusted" json:"views_adjusted"`
+
+	// Clicks from security scanners and bots that are excluded from Clicks.
+	BotClicks int `db:"bot_clicks" json:"bot_clicks"`
+	Bounces   int `db:"bounces" json:"bounces"`
+
+	// Human replies to the campaign's messages.
+	Replies int `db:"replies" json:"replies"`
 
 	// This is a list of {list_id, name} pairs unlike Subscriber.Lists[]
 	// because lists can be deleted after a campaign is finished, resulting
@@ -381,17 +930,110 @@ type CampaignStats struct {
 	NetRate   int       `json:"net_rate"`
 }
 
+// CampaignSendPlan is the resolved audience of a campaign from a dry run.
+// Lists and Domains are JSON arrays of per-list and per-domain counts.
+type CampaignSendPlan struct {
+	Recipients  int            `db:"recipients" json:"recipients"`
+	Blocklisted int            `db:"blocklisted" json:"blocklisted"`
+	Ineligible  int            `db:"ineligible" json:"ineligible"`
+	Lists       types.JSONText `db:"lists" json:"lists"`
+	Domains     types.JSONText `db:"domains" json:"domains"`
+
+	// Subscriptions of recipients who are on more than one of the campaign's
+	// lists. They are sent only one message.
+	Duplicates int `db:"duplicates" json:"duplicates"`
+
+	// Estimated time to send the campaign at the current rate settings.
+	Rate            float64 `json:"rate"`
+	EstimatedTime   string  `json:"estimated_time"`
+	EstimatedSecs   int     `json:"estimated_seconds"`
+	SubscriberQuery string  `json:"subscriber_query"`
+}
+
+// SubscriberDomainCount is the number of subscribers under an e-mail domain.
+type SubscriberDomainCount struct {
+	Domain string `db:"domain" json:"domain"`
+	Count  int    `db:"count" json:"count"`
+}
+
+// AudienceCount is the number of recipients of a prospective campaign.
+// Estimated is true if the count is the query planner's estimate.
+type AudienceCount struct {
+	Count     int  `json:"count"`
+	Estimated bool `json:"estimated"`
+}
+
 type CampaignAnalyticsCount struct {
 	CampaignID int       `db:"campaign_id" json:"campaign_id"`
 	Count      int       `db:"count" json:"count"`
 	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
 }
 
+// CampaignBounceRate represents the bounce and complaint counts of a running campaign.
+type CampaignBounceRate struct {
+	ID         int    `db:"id" json:"id"`
+	Name       string `db:"name" json:"name"`
+	Sent       int    `db:"sent" json:"sent"`
+	Bounces    int    `db:"bounces" json:"bounces"`
+	Complaints int    `db:"complaints" json:"complaints"`
+}
+
+// CampaignView is a campaign view (tracking pixel hit) that's buffered
+// by the tracker and bulk inserted into the DB.
+type CampaignView struct {
+	CampaignUUID   string
+	SubscriberUUID string
+	Proxy          bool
+	CreatedAt      time.Time
+}
+
+// LinkClick is a tracked link click that's buffered by the tracker and
+// bulk inserted into the DB.
+type LinkClick struct {
+	LinkUUID       string
+	CampaignUUID   string
+	SubscriberUUID string
+	BotReason      string
+	CreatedAt      time.Time
+}
+
+// LinkSlug is the link, campaign, and subscriber that a short link resolves to.
+type LinkSlug struct {
+	LinkUUID       string `db:"link_uuid"`
+	URL            string `db:"url"`
+	CampaignUUID   string `db:"campaign_uuid"`
+	SubscriberUUID string `db:"subscriber_uuid"`
+}
+
 type CampaignAnalyticsLink struct {
 	URL   string `db:"url" json:"url"`
 	Count int    `db:"count" json:"count"`
 }
 
+// CampaignComparison represents a campaign's performance metrics for
+// side-by-side comparison with other campaigns. Rates are percentages of
+// the messages sent.
+type CampaignComparison struct {
+	ID            int       `db:"id" json:"id"`
+	Name          string    `db:"name" json:"name"`
+	Subject       string    `db:"subject" json:"subject"`
+	Status        string    `db:"status" json:"status"`
+	Sent          int       `db:"sent" json:"sent"`
+	StartedAt     null.Time `db:"started_at" json:"started_at"`
+	Views         int       `db:"views" json:"views"`
+	ViewsAdjusted int       `db:"views_adjusted" json:"views_adjusted"`
+	Clicks        int       `db:"clicks" json:"clicks"`
+	Bounces       int       `db:"bounces" json:"bounces"`
+	Unsubscribes  int       `db:"unsubscribes" json:"unsubscribes"`
+
+	OpenRate         float64                 `db:"-" json:"open_rate"`
+	AdjustedOpenRate float64                 `db:"-" json:"adjusted_open_rate"`
+	ClickRate        float64                 `db:"-" json:"click_rate"`
+	BounceRate       float64                 `db:"-" json:"bounce_rate"`
+	UnsubscribeRate  float64                 `db:"-" json:"unsubscribe_rate"`
+	TopLinks         []CampaignAnalyticsLink `db:"-" json:"top_links"`
+}
+
 // Campaigns represents a slice of Campaigns.
 type Campaigns []Campaign
 
@@ -401,16 +1043,86 @@ type Template struct {
 
 	Name string `db:"name" json:"name"`
 	// Subject is only for type=tx.
-	Subject   string `db:"subject" json:"subject"`
-	Type      string `db:"type" json:"type"`
-	Body      string `db:"body" json:"body,omitempty"`
-	IsDefault bool   `db:"is_default" json:"is_default"`
+	Subject   string   `db:"subject" json:"subject"`
+	Type      string   `db:"type" json:"type"`
+	Body      string   `db:"body" json:"body,omitempty"`
+	IsDefault bool     `db:"is_default" json:"is_default"`
+	FolderID  null.Int `db:"folder_id" json:"folder_id"`
+	Version   int      `db:"version" json:"version"`
 
 	// Only relevant to tx (transactional) templates.
 	SubjectTpl *txttpl.Template   `json:"-"`
 	Tpl        *template.Template `json:"-"`
 }
 
+// SubscriberQuery represents a saved, named subscriber query (an arbitrary
+// SQL expression) that can be reused to filter subscribers.
+type SubscriberQuery struct {
+	Base
+
+	Name   string   `db:"name" json:"name"`
+	Query  string   `db:"query" json:"query"`
+	Shared bool     `db:"shared" json:"shared"`
+	UserID null.Int `db:"user_id" json:"user_id"`
+
+	// Name of the user who created the query.
+	UserName string `db:"user_name" json:"user_name"`
+}
+
+// Folder represents a folder for organizing campaigns and templates.
+type Folder struct {
+	Base
+
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	Archived    bool   `db:"archived" json:"archived"`
+
+	CampaignCount int `db:"campaign_count" json:"campaign_count"`
+	TemplateCount int `db:"template_count" json:"template_count"`
+}
+
+// TrashItem represents a soft-deleted (trashed) campaign, list, or template.
+type TrashItem struct {
+	Type      string    `db:"type" json:"type"`
+	ID        int       `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	DeletedAt time.Time `db:"deleted_at" json:"deleted_at"`
+
+	// PurgeAt is when the item will be permanently deleted. Null if automatic purging is disabled.
+	PurgeAt null.Time `db:"-" json:"purge_at"`
+}
+
+// EventLog represents an entry in the event log (activity feed).
+type EventLog struct {
+	ID        int64     `db:"id" json:"id"`
+	Type      string    `db:"type" json:"type"`
+	Message   string    `db:"message" json:"message"`
+	Meta      JSON      `db:"meta" json:"meta"`
+	UserID    null.Int  `db:"user_id" json:"user_id"`
+	Username  string    `db:"username" json:"username"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Tag represents a tag and its usage counts across campaigns, lists, and subscribers.
+type Tag struct {
+	Tag         string `db:"tag" json:"tag"`
+	Campaigns   int    `db:"campaigns" json:"campaigns"`
+	Lists       int    `db:"lists" json:"lists"`
+	Subscribers int    `db:"subscribers" json:"subscribers"`
+	Total       int    `db:"total" json:"total"`
+}
+
+// SearchResult represents a single ranked result from the full-text search
+// across campaigns, templates, lists, and subscribers.
+type SearchResult struct {
+	Type        string  `db:"type" json:"type"`
+	ID          int     `db:"id" json:"id"`
+	UUID        string  `db:"uuid" json:"uuid"`
+	Name        string  `db:"name" json:"name"`
+	Description string  `db:"description" json:"description"`
+	Rank        float64 `db:"rank" json:"rank"`
+}
+
 // Bounce represents a single bounce event.
 type Bounce struct {
 	ID        int             `db:"id" json:"id"`
@@ -432,6 +1144,141 @@ type Bounce struct {
 	Total int `db:"total" json:"-"`
 }
 
+// CampaignReply is a human reply to a campaign message.
+type CampaignReply struct {
+	ID              int64     `db:"id" json:"id"`
+	CampaignID      int       `db:"campaign_id" json:"campaign_id"`
+	CampaignUUID    string    `db:"campaign_uuid" json:"campaign_uuid"`
+	CampaignName    string    `db:"campaign_name" json:"campaign_name"`
+	SubscriberID    int       `db:"subscriber_id" json:"subscriber_id"`
+	SubscriberUUID  string    `db:"subscriber_uuid" json:"subscriber_uuid"`
+	SubscriberEmail string    `db:"subscriber_email" json:"subscriber_email"`
+	MessageID       string    `db:"message_id" json:"message_id"`
+	FromEmail       string    `db:"from_email" json:"from_email"`
+	Subject         string    `db:"subject" json:"subject"`
+	Body            string    `db:"body" json:"body"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of replies
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
+
+// SuppressionSync is a sync of an external suppression source into the local
+// blocklist. Added are the source's e-mails that aren't blocklisted locally.
+// Removed are the e-mails that were blocklisted by earlier syncs of the source
+// but are no longer in it. They're only reported and not un-blocklisted.
+type SuppressionSync struct {
+	ID         int            `db:"id" json:"id"`
+	SourceUUID string         `db:"source_uuid" json:"source_uuid"`
+	SourceName string         `db:"source_name" json:"source_name"`
+	Status     string         `db:"status" json:"status"`
+	Total      int            `db:"total" json:"total"`
+	NumAdded   int            `db:"num_added" json:"num_added"`
+	NumRemoved int            `db:"num_removed" json:"num_removed"`
+	Added      pq.StringArray `db:"added" json:"added"`
+	Removed    pq.StringArray `db:"removed" json:"removed"`
+	Error      string         `db:"error" json:"error"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+	AppliedAt  null.Time      `db:"applied_at" json:"applied_at"`
+}
+
+// LeadForm is a website form that captures leads with the public leads API.
+// Leads are subscribed to the form's lists and tagged with its tags.
+type LeadForm struct {
+	Base
+
+	UUID         string         `db:"uuid" json:"uuid"`
+	Name         string         `db:"name" json:"name"`
+	APIKey       string         `db:"api_key" json:"api_key"`
+	Enabled      bool           `db:"enabled" json:"enabled"`
+	ListIDs      pq.Int64Array  `db:"list_ids" json:"lists"`
+	Tags         pq.StringArray `db:"tags" json:"tags"`
+	TxTemplateID null.Int       `db:"tx_template_id" json:"tx_template_id"`
+	RateLimit    int            `db:"rate_limit" json:"rate_limit"`
+	RedirectURL  string         `db:"redirect_url" json:"redirect_url"`
+	Captures     int            `db:"captures" json:"captures"`
+}
+
+// LeadUTM has the UTM parameters a lead was captured with.
+type LeadUTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// EventRule maps a named event posted by an external system to a transactional
+// send, subscriptions, and tags on the event's subscriber.
+type EventRule struct {
+	Base
+
+	Name         string         `db:"name" json:"name"`
+	Event        string         `db:"event" json:"event"`
+	Enabled      bool           `db:"enabled" json:"enabled"`
+	TxTemplateID null.Int       `db:"tx_template_id" json:"tx_template_id"`
+	ListIDs      pq.Int64Array  `db:"list_ids" json:"lists"`
+	Tags         pq.StringArray `db:"tags" json:"tags"`
+	DedupMinutes int            `db:"dedup_minutes" json:"dedup_minutes"`
+	Triggers     int            `db:"triggers" json:"triggers"`
+}
+
+// Report is a saved read-only SQL query that's run on demand or on a cron schedule.
+type Report struct {
+	Base
+
+	Name        string      `db:"name" json:"name"`
+	Description string      `db:"description" json:"description"`
+	Query       string      `db:"query" json:"query"`
+	Schedule    string      `db:"schedule" json:"schedule"`
+	LastRunAt   null.Time   `db:"last_run_at" json:"last_run_at"`
+	LastStatus  null.String `db:"last_status" json:"last_status"`
+	CreatedBy   null.Int    `db:"created_by" json:"created_by"`
+
+	// Name of the user who created the report.
+	UserName string `db:"user_name" json:"user_name"`
+}
+
+// ReportResult is the result of a run of a report. Data is an array of rows,
+// each an array of values in the order of Columns.
+type ReportResult struct {
+	ID         int64           `db:"id" json:"id"`
+	ReportID   int             `db:"report_id" json:"report_id"`
+	Status     string          `db:"status" json:"status"`
+	Scheduled  bool            `db:"scheduled" json:"scheduled"`
+	Columns    json.RawMessage `db:"columns" json:"columns"`
+	Data       json.RawMessage `db:"data" json:"data,omitempty"`
+	NumRows    int             `db:"num_rows" json:"num_rows"`
+	Truncated  bool            `db:"truncated" json:"truncated"`
+	Error      string          `db:"error" json:"error"`
+	DurationMS int             `db:"duration_ms" json:"duration_ms"`
+	CreatedBy  null.Int        `db:"created_by" json:"created_by"`
+	CreatedAt  null.Time       `db:"created_at" json:"created_at"`
+}
+
+// WarehouseExport is the state of the incremental export of a table to the
+// data warehouse. The cursor is the (updated_at or created_at, id) of the
+// last exported row.
+type WarehouseExport struct {
+	Name       string    `db:"name" json:"name"`
+	CursorAt   null.Time `db:"cursor_at" json:"cursor_at"`
+	CursorID   int64     `db:"cursor_id" json:"cursor_id"`
+	Exported   int64     `db:"exported" json:"exported"`
+	Files      int       `db:"files" json:"files"`
+	LastRunAt  null.Time `db:"last_run_at" json:"last_run_at"`
+	LastStatus string    `db:"last_status" json:"last_status"`
+	LastError  string    `db:"last_error" json:"last_error"`
+	UpdatedAt  null.Time `db:"updated_at" json:"updated_at"`
+
+	// Export config of the table.
+	Enabled  bool   `db:"-" json:"enabled"`
+	Format   string `db:"-" json:"format"`
+	Interval string `db:"-" json:"interval"`
+	Target   string `db:"-" json:"target"`
+	Running  bool   `db:"-" json:"running"`
+}
+
 // Message is the message pushed to a Messenger.
 type Message struct {
 	From        string
@@ -595,8 +1442,11 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 		if c.CampaignID == camps[i].ID {
 			camps[i].Lists = c.Lists
 			camps[i].Views = c.Views
+			camps[i].ViewsAdjusted = c.ViewsAdjusted
+			camps[i].BotClicks = c.BotClicks
 			camps[i].Clicks = c.Clicks
 			camps[i].Bounces = c.Bounces
+			camps[i].Replies = c.Replies
 			camps[i].Media = c.Media
 		}
 	}
@@ -622,6 +1472,21 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 		c.SubjectTpl = subjTpl
 	}
 
+	// Same for the preheader.
+	if strings.Contains(c.Preheader, "{{") {
+		ph := c.Preheader
+		for _, r := range regTplFuncs {
+			ph = r.regExp.ReplaceAllString(ph, r.replace)
+		}
+
+		var txtFuncs map[string]interface{} = f
+		phTpl, err := txttpl.New(ContentTpl).Funcs(txtFuncs).Parse(ph)
+		if err != nil {
+			return fmt.Errorf("error compiling preheader: %v", err)
+		}
+		c.PreheaderTpl = phTpl
+	}
+
 	// Compile the base template.
 	body := c.TemplateBody
 	for _, r := range regTplFuncs {
@@ -671,6 +1536,30 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 		c.AltBodyTpl = bTpl
 	}
 
+	c.AttachmentURLTpls = nil
+	for _, u := range c.AttachmentURLs {
+		for _, r := range regTplFuncs {
+			u = r.regExp.ReplaceAllString(u, r.replace)
+		}
+
+		var txtFuncs map[string]interface{} = f
+		uTpl, err := txttpl.New(ContentTpl).Funcs(txtFuncs).Parse(u)
+		if err != nil {
+			return fmt.Errorf("error compiling attachment URL: %v", err)
+		}
+		c.AttachmentURLTpls = append(c.AttachmentURLTpls, uTpl)
+	}
+
+	// Compile the language variants with the same base template.
+	for i, v := range c.Variants {
+		vc := Campaign{Subject: v.Subject, Body: v.Body, ContentType: c.ContentType, TemplateBody: c.TemplateBody}
+		if err := vc.CompileTemplate(f); err != nil {
+			return fmt.Errorf("error compiling variant (%s): %v", v.Lang, err)
+		}
+		c.Variants[i].Tpl = vc.Tpl
+		c.Variants[i].SubjectTpl = vc.SubjectTpl
+	}
+
 	return nil
 }
 
@@ -778,6 +1667,140 @@ func (s Subscriber) LastName() string {
 	return s.Name
 }
 
+// Scan implements the sql.Scanner interface.
+func (e *CampaignEvent) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, e)
+	case string:
+		return json.Unmarshal([]byte(src), e)
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (e CampaignEvent) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// Scan implements the sql.Scanner interface.
+func (u *CampaignUTM) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, u)
+	case string:
+		return json.Unmarshal([]byte(src), u)
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (u CampaignUTM) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *SenderProfile) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, p)
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (p SenderProfile) Value() (driver.Value, error) {
+	if p.Links == nil {
+		p.Links = []SenderLink{}
+	}
+	return json.Marshal(p)
+}
+
+// Merge returns the profile with the fields that are set in o overriding its own.
+func (p SenderProfile) Merge(o SenderProfile) SenderProfile {
+	if o.CompanyName != "" {
+		p.CompanyName = o.CompanyName
+	}
+	if o.Address != "" {
+		p.Address = o.Address
+	}
+	if len(o.Links) > 0 {
+		p.Links = o.Links
+	}
+
+	return p
+}
+
+// AddressLines returns the non-empty lines of the profile's postal address,
+// eg: for rendering it with line breaks in HTML.
+func (p SenderProfile) AddressLines() []string {
+	var out []string
+	for _, l := range strings.Split(p.Address, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
+
+// Value implements the driver.Valuer interface.
+func (u LeadUTM) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// Scan implements the sql.Scanner interface.
+func (v *CampaignVariants) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, v)
+	case string:
+		return json.Unmarshal([]byte(src), v)
+	}
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (v CampaignVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(v)
+}
+
+// Variant returns the variant for the given language code. An exact match
+// is preferred over a match on the base language, eg: "pt-BR" matches a
+// "pt-BR" variant before a "pt" one. nil is returned if there's no match.
+func (c *Campaign) Variant(lang string) *CampaignVariant {
+	if lang == "" || len(c.Variants) == 0 {
+		return nil
+	}
+
+	for i, v := range c.Variants {
+		if strings.EqualFold(v.Lang, lang) {
+			return &c.Variants[i]
+		}
+	}
+
+	base := strings.FieldsFunc(lang, func(r rune) bool { return r == '-' || r == '_' })
+	if len(base) == 0 {
+		return nil
+	}
+	for i, v := range c.Variants {
+		if strings.EqualFold(v.Lang, base[0]) {
+			return &c.Variants[i]
+		}
+	}
+
+	return nil
+}
+
 // Scan implements the sql.Scanner interface.
 func (h *Headers) Scan(src interface{}) error {
 	var b []byte
@@ -856,3 +1879,19 @@ func (u *User) FilterListsByPerm(listIDs []int, get, manage bool) []int {
 
 	return out
 }
+
+// FilterListsBySendPerm returns the list IDs that the user can send campaigns to.
+func (u *User) FilterListsBySendPerm(listIDs []int) []int {
+	if _, ok := u.PermissionsMap[PermListSendAll]; ok {
+		return listIDs
+	}
+
+	out := make([]int, 0, len(listIDs))
+	for _, id := range listIDs {
+		if _, ok := u.ListPermissionsMap[id][PermListSend]; ok {
+			out = append(out, id)
+		}
+	}
+
+	return out
+}
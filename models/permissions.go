@@ -3,8 +3,10 @@ package models
 const (
 	PermListGetAll            = "lists:get_all"
 	PermListManageAll         = "lists:manage_all"
+	PermListSendAll           = "lists:send_all"
 	PermListManage            = "list:manage"
 	PermListGet               = "list:get"
+	PermListSend              = "list:send"
 	PermSubscribersGet        = "subscribers:get"
 	PermSubscribersGetAll     = "subscribers:get_all"
 	PermSubscribersManage     = "subscribers:manage"
@@ -28,4 +30,6 @@ const (
 	PermSettingsGet           = "settings:get"
 	PermSettingsManage        = "settings:manage"
 	PermSettingsMaintain      = "settings:maintain"
+	PermReportsGet            = "reports:get"
+	PermReportsManage         = "reports:manage"
 )
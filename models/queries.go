@@ -14,9 +14,28 @@ type Queries struct {
 	GetDashboardCharts *sqlx.Stmt `query:"get-dashboard-charts"`
 	GetDashboardCounts *sqlx.Stmt `query:"get-dashboard-counts"`
 
+	Search *sqlx.Stmt `query:"search"`
+
+	GetFolders   *sqlx.Stmt `query:"get-folders"`
+	CreateFolder *sqlx.Stmt `query:"create-folder"`
+	UpdateFolder *sqlx.Stmt `query:"update-folder"`
+	DeleteFolder *sqlx.Stmt `query:"delete-folder"`
+	MoveToFolder *sqlx.Stmt `query:"move-to-folder"`
+
+	GetTrash     *sqlx.Stmt `query:"get-trash"`
+	RestoreTrash *sqlx.Stmt `query:"restore-trash"`
+	PurgeTrash   *sqlx.Stmt `query:"purge-trash"`
+
+	InsertEventLog *sqlx.Stmt `query:"insert-event-log"`
+	QueryEventLog  *sqlx.Stmt `query:"query-event-log"`
+
+	GetTags   *sqlx.Stmt `query:"get-tags"`
+	MergeTags *sqlx.Stmt `query:"merge-tags"`
+
 	InsertSubscriber                *sqlx.Stmt `query:"insert-subscriber"`
 	UpsertSubscriber                *sqlx.Stmt `query:"upsert-subscriber"`
 	UpsertBlocklistSubscriber       *sqlx.Stmt `query:"upsert-blocklist-subscriber"`
+	CountSubscribersByEmails        *sqlx.Stmt `query:"count-subscribers-by-emails"`
 	GetSubscriber                   *sqlx.Stmt `query:"get-subscriber"`
 	HasSubscriberLists              *sqlx.Stmt `query:"has-subscriber-list"`
 	GetSubscribersByEmails          *sqlx.Stmt `query:"get-subscribers-by-emails"`
@@ -25,11 +44,17 @@ type Queries struct {
 	GetSubscriberListsLazy          *sqlx.Stmt `query:"get-subscriber-lists-lazy"`
 	UpdateSubscriber                *sqlx.Stmt `query:"update-subscriber"`
 	UpdateSubscriberWithLists       *sqlx.Stmt `query:"update-subscriber-with-lists"`
+	UpdateSubscriberPublicKey       *sqlx.Stmt `query:"update-subscriber-public-key"`
 	BlocklistSubscribers            *sqlx.Stmt `query:"blocklist-subscribers"`
+	CountSubscribersByDomain        *sqlx.Stmt `query:"count-subscribers-by-domain"`
+	BlocklistSubscribersByDomain    *sqlx.Stmt `query:"blocklist-subscribers-by-domain"`
+	DeleteSubscribersByDomain       *sqlx.Stmt `query:"delete-subscribers-by-domain"`
 	AddSubscribersToLists           *sqlx.Stmt `query:"add-subscribers-to-lists"`
+	SetSubscriptionStatuses         *sqlx.Stmt `query:"set-subscription-statuses"`
 	DeleteSubscriptions             *sqlx.Stmt `query:"delete-subscriptions"`
 	DeleteUnconfirmedSubscriptions  *sqlx.Stmt `query:"delete-unconfirmed-subscriptions"`
 	ConfirmSubscriptionOptin        *sqlx.Stmt `query:"confirm-subscription-optin"`
+	RecordSubscriptionConsent       *sqlx.Stmt `query:"record-subscription-consent"`
 	UnsubscribeSubscribersFromLists *sqlx.Stmt `query:"unsubscribe-subscribers-from-lists"`
 	DeleteSubscribers               *sqlx.Stmt `query:"delete-subscribers"`
 	DeleteBlocklistedSubscribers    *sqlx.Stmt `query:"delete-blocklisted-subscribers"`
@@ -41,6 +66,7 @@ type Queries struct {
 	QuerySubscribers                       string     `query:"query-subscribers"`
 	QuerySubscribersCount                  string     `query:"query-subscribers-count"`
 	QuerySubscribersCountAll               *sqlx.Stmt `query:"query-subscribers-count-all"`
+	CountAudience                          string     `query:"count-audience"`
 	QuerySubscribersForExport              string     `query:"query-subscribers-for-export"`
 	QuerySubscribersTpl                    string     `query:"query-subscribers-template"`
 	DeleteSubscribersByQuery               string     `query:"delete-subscribers-by-query"`
@@ -49,6 +75,22 @@ type Queries struct {
 	DeleteSubscriptionsByQuery             string     `query:"delete-subscriptions-by-query"`
 	UnsubscribeSubscribersFromListsByQuery string     `query:"unsubscribe-subscribers-from-lists-by-query"`
 
+	InsertSubscriberReferral *sqlx.Stmt `query:"insert-subscriber-referral"`
+	RotateSubscriberUUID     *sqlx.Stmt `query:"rotate-subscriber-uuid"`
+
+	UpsertOptinCode       *sqlx.Stmt `query:"upsert-optin-code"`
+	UseOptinCode          *sqlx.Stmt `query:"use-optin-code"`
+	IncrOptinCodeAttempts *sqlx.Stmt `query:"incr-optin-code-attempts"`
+
+	UpsertStripeSubscription  *sqlx.Stmt `query:"upsert-stripe-subscription"`
+	SyncPaidListSubscriptions *sqlx.Stmt `query:"sync-paid-list-subscriptions"`
+	GetStripeSubscriptions    *sqlx.Stmt `query:"get-stripe-subscriptions"`
+
+	GetSubscriberQueries  *sqlx.Stmt `query:"get-subscriber-queries"`
+	CreateSubscriberQuery *sqlx.Stmt `query:"create-subscriber-query"`
+	UpdateSubscriberQuery *sqlx.Stmt `query:"update-subscriber-query"`
+	DeleteSubscriberQuery *sqlx.Stmt `query:"delete-subscriber-query"`
+
 	CreateList      *sqlx.Stmt `query:"create-list"`
 	QueryLists      string     `query:"query-lists"`
 	GetLists        *sqlx.Stmt `query:"get-lists"`
@@ -57,13 +99,33 @@ type Queries struct {
 	UpdateListsDate *sqlx.Stmt `query:"update-lists-date"`
 	DeleteLists     *sqlx.Stmt `query:"delete-lists"`
 
-	CreateCampaign        *sqlx.Stmt `query:"create-campaign"`
-	QueryCampaigns        string     `query:"query-campaigns"`
-	GetCampaign           *sqlx.Stmt `query:"get-campaign"`
-	GetCampaignForPreview *sqlx.Stmt `query:"get-campaign-for-preview"`
-	GetCampaignStats      *sqlx.Stmt `query:"get-campaign-stats"`
-	GetCampaignStatus     *sqlx.Stmt `query:"get-campaign-status"`
-	GetArchivedCampaigns  *sqlx.Stmt `query:"get-archived-campaigns"`
+	SyncDynamicList          string     `query:"sync-dynamic-list"`
+	GetCampaignsDynamicLists *sqlx.Stmt `query:"get-campaigns-dynamic-lists"`
+
+	GetCampaignComplianceProfiles *sqlx.Stmt `query:"get-campaign-compliance-profiles"`
+
+	GetListGroups       *sqlx.Stmt `query:"get-list-groups"`
+	CreateListGroup     *sqlx.Stmt `query:"create-list-group"`
+	UpdateListGroup     *sqlx.Stmt `query:"update-list-group"`
+	DeleteListGroup     *sqlx.Stmt `query:"delete-list-group"`
+	SetListGroupLists   *sqlx.Stmt `query:"set-list-group-lists"`
+	GetListGroupListIDs *sqlx.Stmt `query:"get-list-group-list-ids"`
+
+	GetListDomains              *sqlx.Stmt `query:"get-list-domains"`
+	GetListReferrers            *sqlx.Stmt `query:"get-list-referrers"`
+	ExportListArchive           *sqlx.Stmt `query:"export-list-archive"`
+	ImportListArchive           *sqlx.Stmt `query:"import-list-archive"`
+	ImportListArchiveSubscriber *sqlx.Stmt `query:"import-list-archive-subscriber"`
+
+	CreateCampaign         *sqlx.Stmt `query:"create-campaign"`
+	QueryCampaigns         string     `query:"query-campaigns"`
+	GetCampaign            *sqlx.Stmt `query:"get-campaign"`
+	HasCampaignLists       *sqlx.Stmt `query:"has-campaign-lists"`
+	GetCampaignForPreview  *sqlx.Stmt `query:"get-campaign-for-preview"`
+	GetCampaignStats       *sqlx.Stmt `query:"get-campaign-stats"`
+	GetCampaignStatus      *sqlx.Stmt `query:"get-campaign-status"`
+	GetArchivedCampaigns   *sqlx.Stmt `query:"get-archived-campaigns"`
+	SearchCampaignArchives *sqlx.Stmt `query:"search-campaign-archives"`
 
 	// These two queries are read as strings and based on settings.individual_tracking=on/off,
 	// are interpolated and copied to view and click counts. Same query, different tables.
@@ -72,24 +134,81 @@ type Queries struct {
 	GetCampaignClickCounts     *sqlx.Stmt `query:"get-campaign-click-counts"`
 	GetCampaignLinkCounts      *sqlx.Stmt `query:"get-campaign-link-counts"`
 	GetCampaignBounceCounts    *sqlx.Stmt `query:"get-campaign-bounce-counts"`
+	GetCampaignBounceRates     *sqlx.Stmt `query:"get-campaign-bounce-rates"`
+	GetCampaignComparison      *sqlx.Stmt `query:"get-campaign-comparison"`
+	GetCampaignTopLinks        *sqlx.Stmt `query:"get-campaign-top-links"`
 	DeleteCampaignViews        *sqlx.Stmt `query:"delete-campaign-views"`
 	DeleteCampaignLinkClicks   *sqlx.Stmt `query:"delete-campaign-link-clicks"`
 
-	NextCampaigns            *sqlx.Stmt `query:"next-campaigns"`
-	GetRunningCampaign       *sqlx.Stmt `query:"get-running-campaign"`
-	NextCampaignSubscribers  *sqlx.Stmt `query:"next-campaign-subscribers"`
-	GetOneCampaignSubscriber *sqlx.Stmt `query:"get-one-campaign-subscriber"`
-	UpdateCampaign           *sqlx.Stmt `query:"update-campaign"`
-	UpdateCampaignStatus     *sqlx.Stmt `query:"update-campaign-status"`
-	UpdateCampaignCounts     *sqlx.Stmt `query:"update-campaign-counts"`
-	UpdateCampaignArchive    *sqlx.Stmt `query:"update-campaign-archive"`
-	RegisterCampaignView     *sqlx.Stmt `query:"register-campaign-view"`
-	DeleteCampaign           *sqlx.Stmt `query:"delete-campaign"`
-
-	InsertMedia *sqlx.Stmt `query:"insert-media"`
-	GetMedia    *sqlx.Stmt `query:"get-media"`
-	QueryMedia  *sqlx.Stmt `query:"query-media"`
-	DeleteMedia *sqlx.Stmt `query:"delete-media"`
+	NextCampaigns               *sqlx.Stmt `query:"next-campaigns"`
+	GetRunningCampaign          *sqlx.Stmt `query:"get-running-campaign"`
+	NextCampaignSubscribers     *sqlx.Stmt `query:"next-campaign-subscribers"`
+	GetOneCampaignSubscriber    *sqlx.Stmt `query:"get-one-campaign-subscriber"`
+	UpdateCampaign              *sqlx.Stmt `query:"update-campaign"`
+	GetCampaignBodyRef          *sqlx.Stmt `query:"get-campaign-body-ref"`
+	UpdateCampaignStatus        *sqlx.Stmt `query:"update-campaign-status"`
+	PauseCampaign               *sqlx.Stmt `query:"pause-campaign"`
+	ResumeCampaign              *sqlx.Stmt `query:"resume-campaign"`
+	GetBreakerPausedCampaigns   *sqlx.Stmt `query:"get-breaker-paused-campaigns"`
+	UpdateCampaignCounts        *sqlx.Stmt `query:"update-campaign-counts"`
+	UpdateCampaignCheckpoint    *sqlx.Stmt `query:"update-campaign-checkpoint"`
+	UpdateCampaignToSend        *sqlx.Stmt `query:"update-campaign-to-send"`
+	UpdateCampaignArchive       *sqlx.Stmt `query:"update-campaign-archive"`
+	UpdateCampaignSimulate      *sqlx.Stmt `query:"update-campaign-simulate"`
+	RegisterCampaignViews       *sqlx.Stmt `query:"register-campaign-views"`
+	UpsertCampaignRSVP          *sqlx.Stmt `query:"upsert-campaign-rsvp"`
+	GetCampaignRSVPs            *sqlx.Stmt `query:"get-campaign-rsvps"`
+	UpdateCampaignVariantCounts *sqlx.Stmt `query:"update-campaign-variant-counts"`
+	GetCampaignVariantStats     *sqlx.Stmt `query:"get-campaign-variant-stats"`
+	UpdateCampaignDomainCounts  *sqlx.Stmt `query:"update-campaign-domain-counts"`
+	GetCampaignDomainStats      *sqlx.Stmt `query:"get-campaign-domain-stats"`
+	StartCampaignOutbox         *sqlx.Stmt `query:"start-campaign-outbox"`
+	InsertOutboxMessage         *sqlx.Stmt `query:"insert-outbox-message"`
+	FinishCampaignOutbox        *sqlx.Stmt `query:"finish-campaign-outbox"`
+	GetOutboxMessages           *sqlx.Stmt `query:"get-outbox-messages"`
+	QueryOutboxMessages         *sqlx.Stmt `query:"query-outbox-messages"`
+	ApproveCampaignOutbox       *sqlx.Stmt `query:"approve-campaign-outbox"`
+	RejectCampaignOutbox        *sqlx.Stmt `query:"reject-campaign-outbox"`
+	UpsertPostmasterStat        *sqlx.Stmt `query:"upsert-postmaster-stat"`
+	QueryPostmasterStats        *sqlx.Stmt `query:"query-postmaster-stats"`
+	CreateCampaignRetry         *sqlx.Stmt `query:"create-campaign-retry"`
+	GetCampaignRetries          *sqlx.Stmt `query:"get-campaign-retries"`
+	GetCampaignFollowups        *sqlx.Stmt `query:"get-campaign-followups"`
+	UpsertCampaignFollowup      *sqlx.Stmt `query:"upsert-campaign-followup"`
+	DeleteCampaignFollowup      *sqlx.Stmt `query:"delete-campaign-followup"`
+	ScheduleCampaignFollowups   *sqlx.Stmt `query:"schedule-campaign-followups"`
+	GetRepermissionTargets      *sqlx.Stmt `query:"get-repermission-targets"`
+	CreateRepermission          *sqlx.Stmt `query:"create-repermission"`
+	QueryRepermissions          *sqlx.Stmt `query:"query-repermissions"`
+	UpdateRepermission          *sqlx.Stmt `query:"update-repermission"`
+	CancelRepermission          *sqlx.Stmt `query:"cancel-repermission"`
+	ProcessRepermissions        *sqlx.Stmt `query:"process-repermissions"`
+	GetCampaignPresets          *sqlx.Stmt `query:"get-campaign-presets"`
+	CreateCampaignPreset        *sqlx.Stmt `query:"create-campaign-preset"`
+	UpdateCampaignPreset        *sqlx.Stmt `query:"update-campaign-preset"`
+	DeleteCampaignPreset        *sqlx.Stmt `query:"delete-campaign-preset"`
+	InsertCampaignRevision      *sqlx.Stmt `query:"insert-campaign-revision"`
+	GetCampaignRevisions        *sqlx.Stmt `query:"get-campaign-revisions"`
+	GetCampaignChecklist        *sqlx.Stmt `query:"get-campaign-checklist"`
+	SetCampaignChecklistItem    *sqlx.Stmt `query:"set-campaign-checklist-item"`
+	DeleteCampaigns             *sqlx.Stmt `query:"delete-campaigns"`
+	UpdateCampaignsTags         *sqlx.Stmt `query:"update-campaigns-tags"`
+	UpdateCampaignsArchive      *sqlx.Stmt `query:"update-campaigns-archive"`
+	UpdateCampaignsTemplate     *sqlx.Stmt `query:"update-campaigns-template"`
+
+	// Raw templates of next-campaign-subscribers and the recipient count for campaigns
+	// that target a saved subscriber query. These are run in read-only transactions.
+	NextCampaignSubscribersByQuery  string `query:"next-campaign-subscribers-by-query"`
+	CountCampaignSubscribersByQuery string `query:"count-campaign-subscribers-by-query"`
+
+	// Raw query with an optional subscriber query expression for campaign dry runs.
+	GetCampaignSendPlan string `query:"get-campaign-send-plan"`
+
+	InsertMedia          *sqlx.Stmt `query:"insert-media"`
+	GetMedia             *sqlx.Stmt `query:"get-media"`
+	QueryMedia           *sqlx.Stmt `query:"query-media"`
+	DeleteMedia          *sqlx.Stmt `query:"delete-media"`
+	RegisterFileDownload *sqlx.Stmt `query:"register-file-download"`
 
 	CreateTemplate     *sqlx.Stmt `query:"create-template"`
 	GetTemplates       *sqlx.Stmt `query:"get-templates"`
@@ -97,11 +216,16 @@ type Queries struct {
 	SetDefaultTemplate *sqlx.Stmt `query:"set-default-template"`
 	DeleteTemplate     *sqlx.Stmt `query:"delete-template"`
 
-	CreateLink        *sqlx.Stmt `query:"create-link"`
-	RegisterLinkClick *sqlx.Stmt `query:"register-link-click"`
+	CreateLink         *sqlx.Stmt `query:"create-link"`
+	GetLinkURL         *sqlx.Stmt `query:"get-link-url"`
+	CreateLinkSlug     *sqlx.Stmt `query:"create-link-slug"`
+	GetLinkSlug        *sqlx.Stmt `query:"get-link-slug"`
+	RegisterLinkClicks *sqlx.Stmt `query:"register-link-clicks"`
 
-	GetSettings    *sqlx.Stmt `query:"get-settings"`
-	UpdateSettings *sqlx.Stmt `query:"update-settings"`
+	GetSettings      *sqlx.Stmt `query:"get-settings"`
+	UpdateSettings   *sqlx.Stmt `query:"update-settings"`
+	GetSendingHalted *sqlx.Stmt `query:"get-sending-halted"`
+	SetSendingHalted *sqlx.Stmt `query:"set-sending-halted"`
 
 	// GetStats *sqlx.Stmt `query:"get-stats"`
 	RecordBounce              *sqlx.Stmt `query:"record-bounce"`
@@ -110,6 +234,48 @@ type Queries struct {
 	DeleteBouncesBySubscriber *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
 	GetDBInfo                 string     `query:"get-db-info"`
 
+	InsertCampaignReply   *sqlx.Stmt `query:"insert-campaign-reply"`
+	QueryCampaignReplies  *sqlx.Stmt `query:"query-campaign-replies"`
+	DeleteCampaignReplies *sqlx.Stmt `query:"delete-campaign-replies"`
+
+	GetSuppressionDiff          *sqlx.Stmt `query:"get-suppression-diff"`
+	InsertSuppressionSync       *sqlx.Stmt `query:"insert-suppression-sync"`
+	GetSuppressionSyncs         *sqlx.Stmt `query:"get-suppression-syncs"`
+	GetSuppressionLastSyncs     *sqlx.Stmt `query:"get-suppression-last-syncs"`
+	UpdateSuppressionSyncStatus *sqlx.Stmt `query:"update-suppression-sync-status"`
+	BlocklistEmails             *sqlx.Stmt `query:"blocklist-emails"`
+
+	GetLeadForms      *sqlx.Stmt `query:"get-lead-forms"`
+	GetLeadFormByKey  *sqlx.Stmt `query:"get-lead-form-by-key"`
+	CreateLeadForm    *sqlx.Stmt `query:"create-lead-form"`
+	UpdateLeadForm    *sqlx.Stmt `query:"update-lead-form"`
+	UpdateLeadFormKey *sqlx.Stmt `query:"update-lead-form-key"`
+	DeleteLeadForm    *sqlx.Stmt `query:"delete-lead-form"`
+	UpsertLead        *sqlx.Stmt `query:"upsert-lead"`
+
+	GetEventRules     *sqlx.Stmt `query:"get-event-rules"`
+	CreateEventRule   *sqlx.Stmt `query:"create-event-rule"`
+	UpdateEventRule   *sqlx.Stmt `query:"update-event-rule"`
+	DeleteEventRule   *sqlx.Stmt `query:"delete-event-rule"`
+	TriggerEventRules *sqlx.Stmt `query:"trigger-event-rules"`
+
+	GetReports         *sqlx.Stmt `query:"get-reports"`
+	CreateReport       *sqlx.Stmt `query:"create-report"`
+	UpdateReport       *sqlx.Stmt `query:"update-report"`
+	DeleteReport       *sqlx.Stmt `query:"delete-report"`
+	InsertReportResult *sqlx.Stmt `query:"insert-report-result"`
+	GetReportResults   *sqlx.Stmt `query:"get-report-results"`
+	GetReportResult    *sqlx.Stmt `query:"get-report-result"`
+
+	GetWarehouseExports     *sqlx.Stmt `query:"get-warehouse-exports"`
+	UpdateWarehouseCursor   *sqlx.Stmt `query:"update-warehouse-cursor"`
+	UpdateWarehouseRun      *sqlx.Stmt `query:"update-warehouse-run"`
+	ResetWarehouseExport    *sqlx.Stmt `query:"reset-warehouse-export"`
+	GetWarehouseSubscribers *sqlx.Stmt `query:"get-warehouse-subscribers"`
+	GetWarehouseDeliveries  *sqlx.Stmt `query:"get-warehouse-deliveries"`
+	GetWarehouseViews       *sqlx.Stmt `query:"get-warehouse-views"`
+	GetWarehouseClicks      *sqlx.Stmt `query:"get-warehouse-clicks"`
+
 	CreateUser        *sqlx.Stmt `query:"create-user"`
 	UpdateUser        *sqlx.Stmt `query:"update-user"`
 	UpdateUserProfile *sqlx.Stmt `query:"update-user-profile"`
@@ -1,5 +1,27 @@
 package models
 
+import "encoding/json"
+
+// SecretSettings are the paths of the secret fields in the settings that are
+// encrypted at rest. The first element of a path is the settings key and the
+// rest are the fields in its value, where "*" matches every item of an array.
+var SecretSettings = [][]string{
+	{"smtp", "*", "password"},
+	{"messengers", "*", "password"},
+	{"bounce.mailboxes", "*", "password"},
+	{"notifications", "*", "key"},
+	{"privacy.suppression_sources", "*", "key"},
+	{"upload.s3.aws_secret_access_key"},
+	{"bounce.sendgrid_key"},
+	{"bounce.postmark", "password"},
+	{"bounce.forwardemail", "key"},
+	{"security.captcha_secret"},
+	{"security.smime_key"},
+	{"security.oidc", "client_secret"},
+	{"billing.stripe", "secret_key"},
+	{"billing.stripe", "webhook_secret"},
+}
+
 // Settings represents the app settings stored in the DB.
 type Settings struct {
 	AppSiteName                   string   `json:"app.site_name"`
@@ -13,19 +35,35 @@ type Settings struct {
 	EnablePublicArchiveRSSContent bool     `json:"app.enable_public_archive_rss_content"`
 	SendOptinConfirmation         bool     `json:"app.send_optin_confirmation"`
 	CheckUpdates                  bool     `json:"app.check_updates"`
+	DarkModeMeta                  bool     `json:"app.dark_mode_meta"`
+	ShortLinks                    bool     `json:"app.short_links"`
+	RequireCampaignChecklist      bool     `json:"app.require_campaign_checklist"`
+	DefaultTimezone               string   `json:"app.default_timezone"`
 	AppLang                       string   `json:"app.lang"`
 
 	AppBatchSize             int    `json:"app.batch_size"`
 	AppConcurrency           int    `json:"app.concurrency"`
 	AppMaxSendErrors         int    `json:"app.max_send_errors"`
+	OutboxReviewThreshold    int    `json:"app.outbox_review_threshold"`
 	AppMessageRate           int    `json:"app.message_rate"`
 	CacheSlowQueries         bool   `json:"app.cache_slow_queries"`
 	CacheSlowQueriesInterval string `json:"app.cache_slow_queries_interval"`
+	TrashRetentionDays       int    `json:"app.trash_retention_days"`
+	MessageSizeLimit         int    `json:"app.message_size_limit"`
+	ImageWeightLimit         int    `json:"app.image_weight_limit"`
 
 	AppMessageSlidingWindow         bool   `json:"app.message_sliding_window"`
 	AppMessageSlidingWindowDuration string `json:"app.message_sliding_window_duration"`
 	AppMessageSlidingWindowRate     int    `json:"app.message_sliding_window_rate"`
 
+	AppAdaptiveConcurrency bool   `json:"app.adaptive_concurrency"`
+	AppMinConcurrency      int    `json:"app.min_concurrency"`
+	AppAdaptiveLatency     string `json:"app.adaptive_latency"`
+	AppAdaptiveErrorRate   int    `json:"app.adaptive_error_rate"`
+
+	AppBreakerThreshold int    `json:"app.breaker_threshold"`
+	AppBreakerCooldown  string `json:"app.breaker_cooldown"`
+
 	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
 	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
 	PrivacyAllowBlocklist     bool     `json:"privacy.allow_blocklist"`
@@ -34,12 +72,40 @@ type Settings struct {
 	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
 	PrivacyExportable         []string `json:"privacy.exportable"`
 	PrivacyRecordOptinIP      bool     `json:"privacy.record_optin_ip"`
+	PrivacyRecordConsent      bool     `json:"privacy.record_consent"`
+	PrivacyConsentVersion     string   `json:"privacy.consent_version"`
+	PrivacyOptinSMSMessenger  string   `json:"privacy.optin_sms_messenger"`
+	PrivacyDiscountProxyOpens bool     `json:"privacy.discount_proxy_opens"`
+	PrivacyFilterBotClicks    bool     `json:"privacy.filter_bot_clicks"`
+	PrivacyBotClickIPs        []string `json:"privacy.bot_click_ips"`
+	PrivacyRequireSignedLinks bool     `json:"privacy.require_signed_links"`
+	PrivacySignedLinkExpiry   string   `json:"privacy.signed_link_expiry"`
 	DomainBlocklist           []string `json:"privacy.domain_blocklist"`
+	PrivacyBlockRoleAccounts  bool     `json:"privacy.block_role_accounts"`
+	PrivacyRoleAccounts       []string `json:"privacy.role_accounts"`
+	PrivacyCheckMX            bool     `json:"privacy.check_mx"`
+	PrivacyEmailDenyPatterns  []string `json:"privacy.email_deny_patterns"`
 
 	SecurityEnableCaptcha bool   `json:"security.enable_captcha"`
 	SecurityCaptchaKey    string `json:"security.captcha_key"`
 	SecurityCaptchaSecret string `json:"security.captcha_secret"`
 
+	SecuritySMIMESign       bool   `json:"security.smime_sign"`
+	SecuritySMIMECert       string `json:"security.smime_cert"`
+	SecuritySMIMEKey        string `json:"security.smime_key"`
+	SecurityEncryptMessages bool   `json:"security.encrypt_messages"`
+
+	SecuritySanitizeEnabled        bool     `json:"security.sanitize_enabled"`
+	SecuritySanitizeMode           string   `json:"security.sanitize_mode"`
+	SecuritySanitizeMaxNameLen     int      `json:"security.sanitize_max_name_len"`
+	SecuritySanitizeMaxAttribLen   int      `json:"security.sanitize_max_attrib_len"`
+	SecuritySanitizeAllowedAttribs []string `json:"security.sanitize_allowed_attribs"`
+	SecuritySanitizeRawFields      []string `json:"security.sanitize_raw_fields"`
+
+	SecurityTemplateFuncs         []string `json:"security.template_funcs"`
+	SecurityTemplateRenderTimeout string   `json:"security.template_render_timeout"`
+	SecurityTemplateMaxSize       int      `json:"security.template_max_size"`
+
 	OIDC struct {
 		Enabled      bool   `json:"enabled"`
 		ProviderURL  string `json:"provider_url"`
@@ -62,6 +128,17 @@ type Settings struct {
 	UploadS3BucketType         string   `json:"upload.s3.bucket_type"`
 	UploadS3Expiry             string   `json:"upload.s3.expiry"`
 
+	FeedbackIDEnabled bool   `json:"feedback_id.enabled"`
+	FeedbackIDSender  string `json:"feedback_id.sender"`
+
+	SMTPMXEnabled       bool   `json:"smtp_mx.enabled"`
+	SMTPMXHelloHostname string `json:"smtp_mx.hello_hostname"`
+	SMTPMXRequireTLS    bool   `json:"smtp_mx.require_tls"`
+	SMTPMXMaxConns      int    `json:"smtp_mx.max_conns"`
+	SMTPMXIdleTimeout   string `json:"smtp_mx.idle_timeout"`
+	SMTPMXMaxRetries    int    `json:"smtp_mx.max_retries"`
+	SMTPMXRetryInterval string `json:"smtp_mx.retry_interval"`
+
 	SMTP []struct {
 		UUID          string              `json:"uuid"`
 		Enabled       bool                `json:"enabled"`
@@ -92,6 +169,21 @@ type Settings struct {
 		MaxMsgRetries int    `json:"max_msg_retries"`
 	} `json:"messengers"`
 
+	Notifications []struct {
+		UUID    string   `json:"uuid"`
+		Enabled bool     `json:"enabled"`
+		Name    string   `json:"name"`
+		Type    string   `json:"type"`
+		URL     string   `json:"url"`
+		Emails  []string `json:"emails"`
+		Key     string   `json:"key,omitempty"`
+		Events  []string `json:"events"`
+	} `json:"notifications"`
+
+	AlertRules []AlertRule `json:"alert_rules"`
+
+	SuppressionSources []SuppressionSource `json:"privacy.suppression_sources"`
+
 	BounceEnabled        bool `json:"bounce.enabled"`
 	BounceEnableWebhooks bool `json:"bounce.webhooks_enabled"`
 	BounceActions        map[string]struct {
@@ -110,6 +202,13 @@ type Settings struct {
 		Enabled bool   `json:"enabled"`
 		Key     string `json:"key"`
 	} `json:"bounce.forwardemail"`
+	BounceRetryMaxAttempts int `json:"bounce.retry_max_attempts"`
+
+	Stripe struct {
+		Enabled       bool   `json:"enabled"`
+		SecretKey     string `json:"secret_key"`
+		WebhookSecret string `json:"webhook_secret"`
+	} `json:"billing.stripe"`
 	BounceBoxes []struct {
 		UUID          string `json:"uuid"`
 		Enabled       bool   `json:"enabled"`
@@ -129,4 +228,63 @@ type Settings struct {
 	AdminCustomJS   string `json:"appearance.admin.custom_js"`
 	PublicCustomCSS string `json:"appearance.public.custom_css"`
 	PublicCustomJS  string `json:"appearance.public.custom_js"`
+
+	PublicTemplates []PublicTemplate `json:"appearance.public.templates"`
+
+	ComplianceProfiles []ComplianceProfile `json:"compliance.profiles"`
+
+	SenderCompanyName string       `json:"sender.company_name"`
+	SenderAddress     string       `json:"sender.address"`
+	SenderLinks       []SenderLink `json:"sender.links"`
+}
+
+// SettingsChange is the old and the new value of a changed settings key.
+type SettingsChange struct {
+	Key string          `json:"key"`
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+// PublicTemplate overrides one of the bundled public page templates.
+type PublicTemplate struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// SuppressionSource is an external suppression list (a CSV file at a URL or
+// an e-mail provider's suppression API) that's periodically synced into the
+// local blocklist. If RequireApproval is set, the changes of every sync
+// wait for an admin's approval before they're applied.
+type SuppressionSource struct {
+	UUID            string `json:"uuid"`
+	Enabled         bool   `json:"enabled"`
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	URL             string `json:"url"`
+	Key             string `json:"key,omitempty"`
+	Interval        string `json:"interval"`
+	RequireApproval bool   `json:"require_approval"`
+}
+
+// AlertRule auto-pauses running campaigns and alerts admins when a campaign's
+// bounce or complaint rate (%) crosses Threshold between MinSends and
+// MaxSends messages. MaxSends = 0 watches the whole campaign.
+type AlertRule struct {
+	UUID      string  `json:"uuid"`
+	Enabled   bool    `json:"enabled"`
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	MinSends  int     `json:"min_sends"`
+	MaxSends  int     `json:"max_sends"`
+	Pause     bool    `json:"pause"`
+}
+
+// ComplianceProfile is the set of elements that the rendered messages of
+// campaigns sent to lists with the profile should have.
+type ComplianceProfile struct {
+	Name               string `json:"name"`
+	RequireAddress     bool   `json:"require_address"`
+	RequireUnsubscribe bool   `json:"require_unsubscribe"`
+	RequireSender      bool   `json:"require_sender"`
 }
@@ -19,8 +19,8 @@ SELECT s.id AS subscriber_id,
 FROM subscribers s WHERE s.id = ANY($1);
 
 -- name: get-subscribers-by-emails
--- Get subscribers by emails.
-SELECT * FROM subscribers WHERE email=ANY($1);
+-- Get subscribers by lowercased emails.
+SELECT * FROM subscribers WHERE LOWER(email)=ANY($1);
 
 -- name: get-subscriber-lists
 WITH sub AS (
@@ -28,7 +28,7 @@ WITH sub AS (
 )
 SELECT * FROM lists
     LEFT JOIN subscriber_lists ON (lists.id = subscriber_lists.list_id)
-    WHERE subscriber_id = (SELECT id FROM sub)
+    WHERE subscriber_id = (SELECT id FROM sub) AND lists.deleted_at IS NULL
     -- Optional list IDs or UUIDs to filter.
     AND (CASE WHEN CARDINALITY($3::INT[]) > 0 THEN id = ANY($3::INT[])
           WHEN CARDINALITY($4::UUID[]) > 0 THEN uuid = ANY($4::UUID[])
@@ -53,12 +53,13 @@ WITH subs AS (
                     subscriber_lists.created_at AS subscription_created_at,
                     subscriber_lists.updated_at AS subscription_updated_at,
                     subscriber_lists.meta AS subscription_meta,
+                    subscriber_lists.source AS subscription_source,
                     lists.*
             ) l)
         )
     ) AS lists FROM lists
     LEFT JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
-    WHERE subscriber_lists.subscriber_id = ANY($1)
+    WHERE subscriber_lists.subscriber_id = ANY($1) AND lists.deleted_at IS NULL
     GROUP BY subscriber_id
 )
 SELECT id as subscriber_id,
@@ -77,29 +78,31 @@ WITH sub AS (
 SELECT lists.*,
     subscriber_lists.status as subscription_status,
     subscriber_lists.created_at as subscription_created_at,
-    subscriber_lists.meta as subscription_meta
+    subscriber_lists.meta as subscription_meta,
+    subscriber_lists.source as subscription_source
     FROM lists LEFT JOIN subscriber_lists
     ON (subscriber_lists.list_id = lists.id AND subscriber_lists.subscriber_id = (SELECT id FROM sub))
-    WHERE CASE WHEN $3 = TRUE THEN TRUE ELSE subscriber_lists.status IS NOT NULL END
+    WHERE lists.deleted_at IS NULL AND CASE WHEN $3 = TRUE THEN TRUE ELSE subscriber_lists.status IS NOT NULL END
     ORDER BY subscriber_lists.status;
 
 -- name: insert-subscriber
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, status, attribs)
-    VALUES($1, $2, $3, $4, $5)
+    INSERT INTO subscribers (uuid, email, name, status, attribs, tags, lang, source)
+    VALUES($1, $2, $3, $4, $5, COALESCE($9::VARCHAR(100)[], '{}'), $10, $11)
     RETURNING id, status
 ),
 listIDs AS (
-    SELECT id FROM lists WHERE
+    SELECT id FROM lists WHERE deleted_at IS NULL AND
         (CASE WHEN CARDINALITY($6::INT[]) > 0 THEN id=ANY($6)
               ELSE uuid=ANY($7::UUID[]) END)
 ),
 subs AS (
-    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
     VALUES(
         (SELECT id FROM sub),
         UNNEST(ARRAY(SELECT id FROM listIDs)),
-        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END)
+        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END),
+        $11
     )
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
         SET updated_at=NOW(),
@@ -112,25 +115,30 @@ subs AS (
 SELECT id from sub;
 
 -- name: upsert-subscriber
--- Upserts a subscriber where existing subscribers get their names and attributes overwritten.
--- If $7 = true, update values, otherwise, skip.
+-- Upserts a subscriber. $7 is the policy for existing subscribers:
+-- overwrite = overwrite the name, attributes, and subscription statuses,
+-- merge = deep merge the attributes, subscribe = only add subscriptions,
+-- skip = leave existing subscribers and their subscriptions untouched.
 WITH sub AS (
-    INSERT INTO subscribers as s (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'enabled')
+    INSERT INTO subscribers as s (uuid, email, name, attribs, status, source)
+    VALUES($1, $2, $3, $4, 'enabled', $8)
     ON CONFLICT (email)
     DO UPDATE SET
-        name=(CASE WHEN $7 THEN $3 ELSE s.name END),
-        attribs=(CASE WHEN $7 THEN $4 ELSE s.attribs END),
+        name=(CASE WHEN $7::TEXT = 'overwrite' THEN $3 ELSE s.name END),
+        attribs=(CASE WHEN $7 = 'overwrite' THEN $4
+                      WHEN $7 = 'merge' THEN JSONB_DEEP_MERGE(s.attribs, $4)
+                      ELSE s.attribs END),
         updated_at=NOW()
+    WHERE $7 != 'skip'
     RETURNING uuid, id, status
 ),
 subs AS (
-    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    SELECT sub.id, listID, CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed' ELSE $6::subscription_status END
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
+    SELECT sub.id, listID, CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed' ELSE $6::subscription_status END, $8
     FROM sub, UNNEST($5::INT[]) AS listID
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
     SET updated_at = NOW(),
-        status = CASE WHEN $7 THEN EXCLUDED.status ELSE subscriber_lists.status END
+        status = CASE WHEN $7 = 'overwrite' THEN EXCLUDED.status ELSE subscriber_lists.status END
 )
 SELECT uuid, id from sub;
 
@@ -140,23 +148,32 @@ SELECT uuid, id from sub;
 -- existing subscriptions are marked as 'unsubscribed'.
 -- This is used in the bulk importer.
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'blocklisted')
+    INSERT INTO subscribers (uuid, email, name, attribs, status, source)
+    VALUES($1, $2, $3, $4, 'blocklisted', $5)
     ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
     RETURNING id
 )
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM sub);
 
+-- name: count-subscribers-by-emails
+-- Counts the subscribers that exist for the given lowercased e-mails. This is used in
+-- importer dry runs and to exempt existing subscribers from the validation policy.
+SELECT COUNT(*) FROM subscribers WHERE LOWER(email) = ANY($1::TEXT[]);
+
 -- name: update-subscriber
 UPDATE subscribers SET
     email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
     name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
     attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
+    lang=(CASE WHEN $6 != '' THEN $6 ELSE lang END),
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: update-subscriber-public-key
+UPDATE subscribers SET public_key=$2, updated_at=NOW() WHERE id = $1;
+
 -- name: update-subscriber-with-lists
 -- Updates a subscriber's data, and given a list of list_ids, inserts subscriptions
 -- for them while deleting existing subscriptions not in the list.
@@ -166,22 +183,28 @@ WITH s AS (
         name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
         status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
         attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
+        -- NULL tags retain the existing tags.
+        tags=COALESCE($10::VARCHAR(100)[], tags),
+        lang=(CASE WHEN $11 != '' THEN $11 ELSE lang END),
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
 listIDs AS (
-    SELECT id FROM lists WHERE
+    SELECT id FROM lists WHERE deleted_at IS NULL AND
         (CASE WHEN CARDINALITY($6::INT[]) > 0 THEN id=ANY($6)
               ELSE uuid=ANY($7::UUID[]) END)
 ),
 d AS (
+    -- Subscriptions to trashed lists are retained so that they're intact if the list is restored.
     DELETE FROM subscriber_lists WHERE $9 = TRUE AND subscriber_id = $1 AND list_id != ALL(SELECT id FROM listIDs)
+        AND list_id NOT IN (SELECT id FROM lists WHERE deleted_at IS NOT NULL)
 )
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
     VALUES(
         (SELECT id FROM s),
         UNNEST(ARRAY(SELECT id FROM listIDs)),
-        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END)
+        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END),
+        $12
     )
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
     SET status = (
@@ -213,11 +236,89 @@ WITH b AS (
 UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = ANY($1::INT[]);
 
+-- name: count-subscribers-by-domain
+-- Counts the subscribers per e-mail domain in $1, including its subdomains.
+-- $2 = the action (blocklist, delete). Subscribers who are already blocklisted
+-- aren't counted for blocklisting.
+SELECT d.domain, COUNT(s.id) AS count FROM UNNEST($1::TEXT[]) d(domain)
+    LEFT JOIN subscribers s ON (
+        (LOWER(SPLIT_PART(s.email, '@', 2)) = d.domain OR LOWER(SPLIT_PART(s.email, '@', 2)) LIKE '%.' || d.domain)
+        AND ($2 != 'blocklist' OR s.status != 'blocklisted')
+    )
+    GROUP BY d.domain ORDER BY d.domain;
+
+-- name: blocklist-subscribers-by-domain
+-- Blocklists a batch of $2 subscribers under the e-mail domains in $1 (and their
+-- subdomains) and unsubscribes them from their lists. Returns the number of
+-- subscribers blocklisted, which is 0 when there are no more.
+WITH subs AS (
+    SELECT id FROM subscribers
+    WHERE status != 'blocklisted' AND (
+        LOWER(SPLIT_PART(email, '@', 2)) = ANY($1::TEXT[])
+        OR LOWER(SPLIT_PART(email, '@', 2)) LIKE ANY(SELECT '%.' || UNNEST($1::TEXT[]))
+    )
+    LIMIT $2
+),
+b AS (
+    UPDATE subscribers SET status='blocklisted', updated_at=NOW()
+    WHERE id IN (SELECT id FROM subs)
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE subscriber_id IN (SELECT id FROM subs)
+)
+SELECT COUNT(*) FROM subs;
+
+-- name: delete-subscribers-by-domain
+-- Deletes a batch of $2 subscribers under the e-mail domains in $1 (and their
+-- subdomains). Returns the number of subscribers deleted, which is 0 when there
+-- are no more.
+WITH subs AS (
+    SELECT id FROM subscribers
+    WHERE LOWER(SPLIT_PART(email, '@', 2)) = ANY($1::TEXT[])
+        OR LOWER(SPLIT_PART(email, '@', 2)) LIKE ANY(SELECT '%.' || UNNEST($1::TEXT[]))
+    LIMIT $2
+),
+d AS (
+    DELETE FROM subscribers WHERE id IN (SELECT id FROM subs)
+)
+SELECT COUNT(*) FROM subs;
+
 -- name: add-subscribers-to-lists
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    (SELECT a, b, (CASE WHEN $3 != '' THEN $3::subscription_status ELSE 'unconfirmed' END) FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
+    (SELECT a, b, (CASE WHEN $3 != '' THEN $3::subscription_status ELSE 'unconfirmed' END), $4 FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b)
     ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status=(CASE WHEN $3 != '' THEN $3::subscription_status ELSE subscriber_lists.status END);
 
+-- name: set-subscription-statuses
+-- Sets the statuses ($3) of a subscriber's ($1) subscriptions to lists ($2), creating the
+-- subscriptions that don't exist with the source ($7). The reason code ($4) and note ($5) of
+-- each change and the user ($6) who made it are recorded in the subscription's meta.status_change.
+-- Returns the subscriptions whose status changed with their previous status ('' if new).
+WITH s AS (
+    SELECT r.list_id, r.status, r.reason, r.note, lists.uuid AS list_uuid, lists.name AS list_name
+    FROM UNNEST($2::INT[], $3::subscription_status[], $4::TEXT[], $5::TEXT[]) AS r(list_id, status, reason, note)
+    JOIN lists ON (lists.id = r.list_id AND lists.deleted_at IS NULL)
+),
+old AS (
+    SELECT list_id, status FROM subscriber_lists WHERE subscriber_id = $1 AND list_id = ANY($2::INT[])
+),
+up AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, source, meta)
+        SELECT $1, list_id, status, $7, JSONB_BUILD_OBJECT('status_change',
+            JSONB_BUILD_OBJECT('reason', reason, 'note', note, 'user_id', $6::INT, 'timestamp', NOW()))
+        FROM s
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status=EXCLUDED.status,
+        meta=subscriber_lists.meta || EXCLUDED.meta, updated_at=NOW()
+        WHERE subscriber_lists.status != EXCLUDED.status
+    RETURNING list_id, status
+)
+SELECT up.list_id, s.list_uuid, s.list_name, COALESCE(old.status::TEXT, '') AS previous_status,
+    up.status::TEXT AS status, s.reason, s.note
+    FROM up
+    JOIN s ON (s.list_id = up.list_id)
+    LEFT JOIN old ON (old.list_id = up.list_id)
+    ORDER BY up.list_id;
+
 -- name: delete-subscriptions
 DELETE FROM subscriber_lists
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b);
@@ -227,11 +328,51 @@ WITH subID AS (
     SELECT id FROM subscribers WHERE uuid = $1::UUID
 ),
 listIDs AS (
-    SELECT id FROM lists WHERE uuid = ANY($2::UUID[])
+    SELECT id FROM lists WHERE uuid = ANY($2::UUID[]) AND deleted_at IS NULL
 )
 UPDATE subscriber_lists SET status='confirmed', meta=meta || $3, updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs);
 
+-- name: upsert-optin-code
+-- Sets a subscriber's ($1) opt-in confirmation code ($2) for the given lists ($3), replacing
+-- any previous code. The lists of a previous unexpired code are carried over.
+INSERT INTO subscriber_optin_codes (subscriber_id, code, list_ids, expires_at)
+    VALUES($1, $2, $3, NOW() + $4::INTERVAL)
+    ON CONFLICT (subscriber_id) DO UPDATE SET
+        code=$2,
+        list_ids=(CASE WHEN subscriber_optin_codes.expires_at > NOW()
+            THEN ARRAY(SELECT DISTINCT UNNEST(subscriber_optin_codes.list_ids || $3::INT[]))
+            ELSE $3 END),
+        attempts=0,
+        expires_at=NOW() + $4::INTERVAL,
+        created_at=NOW();
+
+-- name: use-optin-code
+-- Deletes a subscriber's ($1) opt-in code if it matches ($2), hasn't expired, and has had
+-- fewer than $3 attempts, and confirms the unconfirmed subscriptions to the code's lists.
+-- Returns the code's list IDs, or no rows if the code is invalid.
+WITH c AS (
+    DELETE FROM subscriber_optin_codes
+    WHERE subscriber_id = $1 AND code = $2 AND expires_at > NOW() AND attempts < $3
+    RETURNING list_ids
+),
+u AS (
+    UPDATE subscriber_lists SET status='confirmed', meta=meta || $4, updated_at=NOW()
+    WHERE subscriber_id = $1 AND status = 'unconfirmed' AND list_id = ANY(SELECT UNNEST(list_ids) FROM c)
+)
+SELECT list_ids FROM c;
+
+-- name: incr-optin-code-attempts
+UPDATE subscriber_optin_codes SET attempts = attempts + 1 WHERE subscriber_id = $1;
+
+-- name: record-subscription-consent
+-- Appends a proof-of-consent record ($4) to the meta.consent array of a subscriber's
+-- subscriptions to the given list IDs ($2) or UUIDs ($3).
+UPDATE subscriber_lists SET meta = JSONB_SET(meta, '{consent}', COALESCE(meta->'consent', '[]') || $4::JSONB)
+    WHERE subscriber_id = $1 AND list_id = ANY(
+        SELECT id FROM lists WHERE id = ANY($2::INT[]) OR uuid = ANY($3::UUID[])
+    );
+
 -- name: unsubscribe-subscribers-from-lists
 WITH listIDs AS (
     SELECT ARRAY(
@@ -254,11 +395,17 @@ WITH lists AS (
 sub AS (
     UPDATE subscribers SET status = (CASE WHEN $3 IS TRUE THEN 'blocklisted' ELSE status END)
     WHERE uuid = $2 RETURNING id
+),
+unsubs AS (
+    UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
+        subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
+        -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
+        CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END
+    RETURNING subscriber_id
 )
-UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
-    subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
-    -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
-    CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END;
+-- Record the unsubscription against the campaign for reports.
+INSERT INTO campaign_unsubscribes (campaign_id, subscriber_id)
+    SELECT id, (SELECT id FROM sub) FROM campaigns WHERE uuid = $1 AND EXISTS (SELECT 1 FROM unsubs);
 
 -- name: delete-unconfirmed-subscriptions
 WITH optins AS (
@@ -267,6 +414,97 @@ WITH optins AS (
 DELETE FROM subscriber_lists
     WHERE status = 'unconfirmed' AND list_id IN (SELECT id FROM optins) AND created_at < $1;
 
+-- subscriber queries
+-- name: rotate-subscriber-uuid
+-- Replaces a subscriber's UUID ($1) with a new one ($2) and logs the old UUID
+-- with the reason ($3) and the user ($4) who rotated it.
+WITH old AS (
+    SELECT id, uuid FROM subscribers WHERE id = $1
+),
+upd AS (
+    UPDATE subscribers SET uuid=$2, updated_at=NOW() WHERE id = $1 RETURNING id
+)
+INSERT INTO subscriber_uuid_rotations (subscriber_id, old_uuid, reason, user_id)
+    SELECT old.id, old.uuid, $3, NULLIF($4, 0) FROM old JOIN upd ON upd.id = old.id
+    RETURNING subscriber_id;
+
+-- name: insert-subscriber-referral
+-- Attributes a subscriber ($1) to the referrer with the referral code $2.
+-- Self-referrals are ignored and a subscriber is only attributed once.
+INSERT INTO subscriber_referrals (subscriber_id, referrer_id)
+    SELECT $1, id FROM subscribers WHERE referral_code = $2 AND id != $1
+    ON CONFLICT (subscriber_id) DO NOTHING;
+
+-- name: upsert-stripe-subscription
+INSERT INTO stripe_subscriptions (id, subscriber_id, customer_id, price_ids, status, current_period_end)
+    VALUES($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (id) DO UPDATE SET
+        subscriber_id=$2, customer_id=$3, price_ids=$4, status=$5, current_period_end=$6, updated_at=NOW();
+
+-- name: sync-paid-list-subscriptions
+-- Subscribes a subscriber ($1) to the paid lists of the given Stripe prices ($2) when
+-- the subscription is active ($3), or unsubscribes them from the lists otherwise, unless
+-- another active subscription of theirs grants access to the list.
+WITH ls AS (
+    SELECT id, stripe_price_id FROM lists
+    WHERE stripe_price_id != '' AND stripe_price_id = ANY($2::TEXT[]) AND deleted_at IS NULL
+),
+unsub AS (
+    UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE NOT $3 AND subscriber_id = $1 AND list_id IN (
+        SELECT id FROM ls WHERE NOT EXISTS (
+            SELECT 1 FROM stripe_subscriptions s WHERE s.subscriber_id = $1
+            AND s.status IN ('active', 'trialing') AND ls.stripe_price_id = ANY(s.price_ids)
+        )
+    )
+)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
+    SELECT $1, id, 'confirmed', $4 FROM ls WHERE $3
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status='confirmed', updated_at=NOW();
+
+-- name: get-stripe-subscriptions
+-- Stripe subscriptions of a subscriber with the names of the paid lists they grant access to.
+SELECT s.id, s.customer_id, s.status, s.current_period_end,
+    COALESCE(ARRAY_AGG(l.name ORDER BY l.name) FILTER (WHERE l.id IS NOT NULL), '{}') AS lists
+    FROM stripe_subscriptions s
+    LEFT JOIN lists l ON (l.stripe_price_id != '' AND l.stripe_price_id = ANY(s.price_ids) AND l.deleted_at IS NULL)
+    WHERE s.subscriber_id = $1
+    GROUP BY s.id
+    ORDER BY s.created_at DESC;
+
+-- name: get-subscriber-queries
+-- Returns saved subscriber queries visible to a user ($2), that is, shared queries and the user's own.
+-- $3 = true returns all queries irrespective of the owner.
+SELECT q.*, COALESCE(u.name, '') AS user_name FROM subscriber_queries q
+    LEFT JOIN users u ON (u.id = q.user_id)
+    WHERE ($1 = 0 OR q.id = $1) AND ($3 = TRUE OR q.shared = TRUE OR q.user_id = $2)
+    ORDER BY q.name;
+
+-- name: create-subscriber-query
+INSERT INTO subscriber_queries (name, query, shared, user_id) VALUES($1, $2, $3, $4) RETURNING id;
+
+-- name: update-subscriber-query
+-- Only the owner ($5) can update a query unless $6 = true.
+UPDATE subscriber_queries SET name=$2, query=$3, shared=$4, updated_at=NOW()
+    WHERE id=$1 AND ($6 = TRUE OR user_id = $5);
+
+-- name: delete-subscriber-query
+-- Queries used by campaigns that are yet to finish cannot be deleted. Campaigns that are done
+-- have their references removed. Only the owner ($2) can delete a query unless $3 = true.
+WITH used AS (
+    SELECT COUNT(*) AS num FROM campaigns
+    WHERE subscriber_query_id = $1 AND status NOT IN ('finished', 'cancelled')
+),
+del AS (
+    DELETE FROM subscriber_queries
+    WHERE id = $1 AND ($3 = TRUE OR user_id = $2) AND (SELECT num FROM used) = 0
+    RETURNING id
+),
+camps AS (
+    UPDATE campaigns SET subscriber_query_id = NULL WHERE subscriber_query_id = (SELECT id FROM del)
+)
+SELECT (SELECT num FROM used) AS used, (SELECT COUNT(*) FROM del) AS deleted;
+
 -- privacy
 -- name: export-subscriber-data
 WITH prof AS (
@@ -276,7 +514,8 @@ WITH prof AS (
 subs AS (
     SELECT subscriber_lists.status AS subscription_status,
             (CASE WHEN lists.type = 'private' THEN 'Private list' ELSE lists.name END) as name,
-            lists.type, subscriber_lists.created_at
+            lists.type, subscriber_lists.created_at,
+            COALESCE(subscriber_lists.meta->'consent', '[]') AS consent
     FROM lists
     LEFT JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
     WHERE subscriber_lists.subscriber_id = (SELECT id FROM prof)
@@ -340,6 +579,34 @@ SELECT COALESCE(SUM(subscriber_count), 0) AS total FROM mat_list_subscriber_stat
     WHERE list_id = ANY(CASE WHEN CARDINALITY($1::INT[]) > 0 THEN $1 ELSE '{0}' END)
     AND ($2 = '' OR status = $2::subscription_status);
 
+-- name: count-audience
+-- raw: true
+-- The distinct recipients of a prospective campaign, resolved the same way as
+-- next-campaign-subscribers. Used (wrapped in COUNT() or EXPLAIN) for audience sizes.
+-- $1 = list IDs, $2 = list group IDs, $3 = excluded list IDs, $4 = campaign type.
+-- %query% = optional arbitrary subscriber query expression.
+SELECT DISTINCT subscribers.id FROM subscriber_lists sl
+    JOIN lists ON (lists.id = sl.list_id AND lists.deleted_at IS NULL)
+    JOIN subscribers ON subscribers.id = sl.subscriber_id
+    WHERE (sl.list_id = ANY($1::INT[]) OR lists.group_id = ANY($2::INT[]))
+    AND subscribers.status != 'blocklisted'
+    AND (
+        -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
+        ($4 = 'optin' AND sl.status = 'unconfirmed' AND lists.optin = 'double')
+        OR (
+            $4 != 'optin' AND (
+                (lists.optin = 'double' AND sl.status = 'confirmed') OR
+                (lists.optin != 'double' AND sl.status != 'unsubscribed')
+            )
+        )
+    )
+    -- Subscribers on any of the excluded lists are left out.
+    AND NOT EXISTS (
+        SELECT 1 FROM subscriber_lists ex WHERE ex.subscriber_id = subscribers.id
+        AND ex.list_id = ANY($3::INT[]) AND ex.status != 'unsubscribed'
+    )
+    %query%
+
 -- name: query-subscribers-for-export
 -- raw: true
 -- Unprepared statement for issuring arbitrary WHERE conditions for
@@ -404,8 +671,8 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
 -- name: add-subscribers-to-lists-by-query
 -- raw: true
 WITH subs AS (%s)
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    (SELECT a, b, (CASE WHEN $5 != '' THEN $5::subscription_status ELSE 'unconfirmed' END) FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($4::INT[]) b)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
+    (SELECT a, b, (CASE WHEN $5 != '' THEN $5::subscription_status ELSE 'unconfirmed' END), $6 FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($4::INT[]) b)
     ON CONFLICT (subscriber_id, list_id) DO NOTHING;
 
 -- name: delete-subscriptions-by-query
@@ -423,7 +690,7 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
 
 -- lists
 -- name: get-lists
-SELECT * FROM lists WHERE (CASE WHEN $1 = '' THEN 1=1 ELSE type=$1::list_type END)
+SELECT * FROM lists WHERE deleted_at IS NULL AND (CASE WHEN $1 = '' THEN 1=1 ELSE type=$1::list_type END)
     AND CASE
         -- Optional list IDs based on user permission.
         WHEN $3 = TRUE THEN TRUE ELSE id = ANY($4::INT[])
@@ -432,11 +699,11 @@ SELECT * FROM lists WHERE (CASE WHEN $1 = '' THEN 1=1 ELSE type=$1::list_type EN
 
 -- name: query-lists
 WITH ls AS (
-    SELECT COUNT(*) OVER () AS total, lists.* FROM lists WHERE
+    SELECT COUNT(*) OVER () AS total, lists.* FROM lists WHERE deleted_at IS NULL AND
     CASE
         WHEN $1 > 0 THEN id = $1
         WHEN $2 != '' THEN uuid = $2::UUID
-        WHEN $3 != '' THEN to_tsvector(name) @@ to_tsquery ($3)
+        WHEN $3 != '' THEN TO_TSVECTOR('simple', name || ' ' || description) @@ TO_TSQUERY('simple', $3)
         ELSE TRUE
     END
     AND ($4 = '' OR type = $4::list_type)
@@ -461,13 +728,14 @@ SELECT ls.*, COALESCE(ss.subscriber_statuses, '{}') AS subscriber_statuses, COAL
 
 -- name: get-lists-by-optin
 -- Can have a list of IDs or a list of UUIDs.
-SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRUE END) AND
+SELECT * FROM lists WHERE deleted_at IS NULL AND (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRUE END) AND
     (CASE WHEN $2::INT[] IS NOT NULL THEN id = ANY($2::INT[])
           WHEN $3::UUID[] IS NOT NULL THEN uuid = ANY($3::UUID[])
     END) ORDER BY name;
 
 -- name: create-list
-INSERT INTO lists (uuid, name, type, optin, tags, description) VALUES($1, $2, $3, $4, $5, $6) RETURNING id;
+INSERT INTO lists (uuid, name, type, optin, tags, description, logo_url, lang, stripe_price_id, optin_method, domain, rules, compliance_profile, sender_profile)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id;
 
 -- name: update-list
 UPDATE lists SET
@@ -476,14 +744,172 @@ UPDATE lists SET
     optin=(CASE WHEN $4 != '' THEN $4::list_optin ELSE optin END),
     tags=$5::VARCHAR(100)[],
     description=(CASE WHEN $6 != '' THEN $6 ELSE description END),
+    logo_url=$7,
+    lang=$8,
+    stripe_price_id=$9,
+    optin_method=(CASE WHEN $10 != '' THEN $10 ELSE optin_method END),
+    domain=$11,
+    rules=$12,
+    compliance_profile=$13,
+    sender_profile=$14,
+    version=version + 1,
     updated_at=NOW()
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: sync-dynamic-list
+-- raw: true
+-- Recomputes the members of a dynamic list from its rules. Subscribers who have
+-- unsubscribed from the list are left as they are and aren't resubscribed.
+-- $1 = list ID, %query% = the compiled rules expression.
+WITH matches AS (
+    SELECT id FROM subscribers WHERE subscribers.status != 'blocklisted' AND (%query%)
+),
+del AS (
+    DELETE FROM subscriber_lists sl WHERE sl.list_id = $1 AND sl.status != 'unsubscribed'
+    AND NOT EXISTS (SELECT 1 FROM matches WHERE matches.id = sl.subscriber_id)
+    RETURNING 1
+),
+ins AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    SELECT id, $1, 'confirmed' FROM matches
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+    RETURNING 1
+),
+u AS (
+    UPDATE lists SET updated_at=NOW() WHERE id = $1
+)
+SELECT (SELECT COUNT(*) FROM matches) AS total, (SELECT COUNT(*) FROM ins) AS added, (SELECT COUNT(*) FROM del) AS removed;
+
+-- name: get-campaigns-dynamic-lists
+-- Dynamic lists targeted (directly or through list groups) by campaigns that are
+-- about to be picked up for processing, excluding the campaigns in $1.
+SELECT DISTINCT lists.id, lists.rules FROM lists
+    JOIN campaigns ON (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+        OR lists.group_id = ANY(campaigns.list_group_ids)
+    )
+    WHERE lists.rules IS NOT NULL AND lists.deleted_at IS NULL
+    AND (campaigns.status='running' OR (campaigns.status='scheduled' AND NOW() >= campaigns.send_at))
+    AND campaigns.deleted_at IS NULL
+    AND NOT(campaigns.id = ANY($1::INT[]));
+
+-- name: get-campaign-compliance-profiles
+-- Compliance profiles of the lists that a campaign targets, directly or through list groups.
+SELECT DISTINCT lists.compliance_profile FROM lists
+    JOIN campaigns ON (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+        OR lists.group_id = ANY(campaigns.list_group_ids)
+    )
+    WHERE campaigns.id = $1 AND lists.compliance_profile != '' AND lists.deleted_at IS NULL
+    ORDER BY lists.compliance_profile;
 
 -- name: update-lists-date
 UPDATE lists SET updated_at=NOW() WHERE id = ANY($1);
 
 -- name: delete-lists
-DELETE FROM lists WHERE id = ALL($1);
+-- Lists are soft-deleted (moved to the trash) and purged later by purge-trash.
+UPDATE lists SET deleted_at=NOW() WHERE id = ANY($1) AND deleted_at IS NULL;
+
+-- name: get-list-domains
+-- Custom public domains of public lists.
+SELECT domain, uuid FROM lists WHERE domain != '' AND type = 'public' AND deleted_at IS NULL;
+
+-- name: get-list-referrers
+-- Referral leaderboard of a list ($1). Only the referred subscribers who are
+-- subscribed to the list are counted.
+SELECT s.id, s.uuid, s.email, s.name, s.referral_code, COUNT(*) AS referrals
+    FROM subscriber_referrals r
+    JOIN subscriber_lists sl ON (sl.subscriber_id = r.subscriber_id AND sl.list_id = $1 AND sl.status != 'unsubscribed')
+    JOIN subscribers s ON (s.id = r.referrer_id)
+    GROUP BY s.id
+    ORDER BY referrals DESC, s.id
+    LIMIT $2;
+
+-- name: export-list-archive
+-- Returns a batch of a list's subscribers along with their subscription states for
+-- exporting portable list archives, starting after the given subscriber ID ($2).
+SELECT s.id, s.uuid, s.email, s.name, s.attribs, s.status, s.tags, s.created_at,
+    sl.status AS subscription_status, sl.meta AS subscription_meta, sl.created_at AS subscription_created_at
+    FROM subscriber_lists sl
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE sl.list_id = $1 AND s.id > $2
+    ORDER BY s.id LIMIT $3;
+
+-- name: import-list-archive
+-- Creates a list from a list archive. If the list's UUID already exists, the existing
+-- list is used (and restored from the trash, if it's there).
+INSERT INTO lists (uuid, name, type, optin, tags, description, logo_url, lang) VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+    ON CONFLICT (uuid) DO UPDATE SET deleted_at=NULL, updated_at=NOW()
+    RETURNING id;
+
+-- name: import-list-archive-subscriber
+-- Upserts a subscriber from a list archive by e-mail and adds the subscription with
+-- the archived subscription state. The archived UUID ($1) is used if it isn't taken,
+-- and the generated UUID ($2) otherwise. Existing subscriber data is only overwritten if $11 = true.
+WITH sub AS (
+    INSERT INTO subscribers (uuid, email, name, attribs, status, tags, source)
+    VALUES(
+        (CASE WHEN $1 != '' AND NOT EXISTS (SELECT 1 FROM subscribers WHERE uuid::TEXT = $1) THEN $1 ELSE $2 END)::UUID,
+        $3, $4, $5, $6, COALESCE($7::VARCHAR(100)[], '{}'), $12
+    )
+    ON CONFLICT (email) DO UPDATE SET
+        name=(CASE WHEN $11 THEN EXCLUDED.name ELSE subscribers.name END),
+        attribs=(CASE WHEN $11 THEN EXCLUDED.attribs ELSE subscribers.attribs END),
+        status=(CASE WHEN $11 THEN EXCLUDED.status ELSE subscribers.status END),
+        tags=(CASE WHEN $11 THEN EXCLUDED.tags ELSE subscribers.tags END),
+        updated_at=NOW()
+    RETURNING id
+)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, meta, source)
+    VALUES((SELECT id FROM sub), $8, $9, $10, $12)
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET
+        status=(CASE WHEN $11 THEN EXCLUDED.status ELSE subscriber_lists.status END),
+        meta=subscriber_lists.meta || EXCLUDED.meta,
+        updated_at=NOW();
+
+-- list groups
+-- name: get-list-groups
+-- Returns list groups with their member lists and aggregate subscription stats
+-- across the member lists. $1 = optional group ID.
+SELECT g.*,
+    COALESCE((
+        SELECT JSON_AGG(JSON_BUILD_OBJECT('id', l.id, 'uuid', l.uuid, 'name', l.name) ORDER BY l.name)
+        FROM lists l WHERE l.group_id = g.id AND l.deleted_at IS NULL
+    ), '[]') AS lists,
+    COALESCE(st.subscriber_statuses, '{}') AS subscriber_statuses,
+    COALESCE(st.subscriber_count, 0) AS subscriber_count
+    FROM list_groups g
+    LEFT JOIN LATERAL (
+        SELECT JSONB_OBJECT_AGG(status, n) AS subscriber_statuses, SUM(n) AS subscriber_count FROM (
+            SELECT ms.status, SUM(ms.subscriber_count) AS n
+            FROM mat_list_subscriber_stats ms
+            JOIN lists l ON (l.id = ms.list_id)
+            WHERE l.group_id = g.id AND l.deleted_at IS NULL AND ms.status IS NOT NULL
+            GROUP BY ms.status
+        ) x
+    ) st ON TRUE
+    WHERE ($1 = 0 OR g.id = $1)
+    ORDER BY g.name;
+
+-- name: create-list-group
+INSERT INTO list_groups (name, description) VALUES($1, $2) RETURNING id;
+
+-- name: update-list-group
+UPDATE list_groups SET name=$2, description=$3, updated_at=NOW() WHERE id=$1;
+
+-- name: delete-list-group
+DELETE FROM list_groups WHERE id=$1;
+
+-- name: set-list-group-lists
+-- Sets the member lists ($2) of a group ($1). Lists that are in the group but not
+-- in $2 are removed from it. A list can only be in one group.
+UPDATE lists SET group_id=(CASE WHEN id = ANY($2::INT[]) THEN $1 ELSE NULL END), updated_at=NOW()
+    WHERE (id = ANY($2::INT[]) OR group_id = $1)
+    AND EXISTS (SELECT 1 FROM list_groups WHERE id = $1);
+
+-- name: get-list-group-list-ids
+-- Returns the IDs of the member lists of the given groups.
+SELECT id FROM lists WHERE group_id = ANY($1::INT[]) AND deleted_at IS NULL;
 
 
 -- campaigns
@@ -500,7 +926,8 @@ counts AS (
     FROM subscriber_lists sl
         JOIN lists l ON sl.list_id = l.id
         JOIN subscribers s ON sl.subscriber_id = s.id
-    WHERE sl.list_id = ANY($14::INT[])
+    WHERE (sl.list_id = ANY($14::INT[]) OR l.group_id = ANY($22::INT[]))
+      AND l.deleted_at IS NULL
       AND s.status != 'blocklisted'
       AND (
         (l.optin = 'double' AND sl.status = 'confirmed') OR
@@ -508,11 +935,12 @@ counts AS (
       )
 ),
 camp AS (
-    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody, content_type, send_at, headers, tags, messenger, template_id, to_send, max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta)
+    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody, content_type, send_at, headers, tags, messenger, template_id, to_send, max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, subscriber_query_id, folder_id, list_group_ids, attachment_urls, event, preheader, variants, body_gz, body_ref, send_at_tz, body_search)
         SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
             (SELECT id FROM tpl), (SELECT to_send FROM counts),
             (SELECT max_sub_id FROM counts), $15, $16,
-            (CASE WHEN $17 = 0 THEN (SELECT id FROM tpl) ELSE $17 END), $18
+            (CASE WHEN $17 = 0 THEN (SELECT id FROM tpl) ELSE $17 END), $18, $20, $21, COALESCE($22::INT[], '{}'),
+            COALESCE($23::TEXT[], '{}'), $24::JSONB, $25, COALESCE($26::JSONB, '[]'), $27, $28, $29, $30
         RETURNING id
 ),
 med AS (
@@ -521,7 +949,7 @@ med AS (
 ),
 insLists AS (
     INSERT INTO campaign_lists (campaign_id, list_id, list_name)
-        SELECT (SELECT id FROM camp), id, name FROM lists WHERE id=ANY($14::INT[])
+        SELECT (SELECT id FROM camp), id, name FROM lists WHERE id=ANY($14::INT[]) AND deleted_at IS NULL
 )
 SELECT id FROM camp;
 
@@ -534,9 +962,14 @@ SELECT id FROM camp;
 -- with every resultant row.
 SELECT  c.id, c.uuid, c.name, c.subject, c.from_email,
         c.messenger, c.started_at, c.to_send, c.sent, c.type,
-        c.body, c.altbody, c.send_at, c.headers, c.status, c.content_type, c.tags,
+        -- Bodies are only fetched if they're asked for ($10).
+        (CASE WHEN $10 THEN c.body ELSE '' END) AS body,
+        (CASE WHEN $10 THEN c.body_gz END) AS body_gz,
+        (CASE WHEN $10 THEN c.body_ref ELSE '' END) AS body_ref,
+        c.altbody, c.send_at, c.send_at_tz, c.headers, c.status, c.content_type, c.tags,
         c.template_id, c.archive, c.archive_slug, c.archive_template_id, c.archive_meta,
-        c.created_at, c.updated_at,
+        c.subscriber_query_id, c.folder_id, c.list_group_ids, c.attachment_urls, c.event, c.preheader, c.variants, c.created_at, c.updated_at,
+        c.retry_of, c.retry_attempt, c.outbox, c.paused_by_breaker, c.simulate,
         COUNT(*) OVER () AS total,
         (
             SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
@@ -546,21 +979,53 @@ SELECT  c.id, c.uuid, c.name, c.subject, c.from_email,
         ) l
     ) AS lists
 FROM campaigns c
-WHERE ($1 = 0 OR id = $1)
+WHERE deleted_at IS NULL AND ($1 = 0 OR id = $1)
     AND (CARDINALITY($2::campaign_status[]) = 0 OR status = ANY($2))
     AND (CARDINALITY($3::VARCHAR(100)[]) = 0 OR $3 <@ tags)
-    AND ($4 = '' OR TO_TSVECTOR(CONCAT(name, ' ', subject)) @@ TO_TSQUERY($4) OR CONCAT(c.name, ' ', c.subject) ILIKE $4)
+    -- Optional folder. < 0 = campaigns that aren't in any folder.
+    AND (CASE WHEN $7 > 0 THEN folder_id = $7 WHEN $7 < 0 THEN folder_id IS NULL ELSE TRUE END)
+    -- Full-text search ($4) with a substring match ($11) on the name and subject for partial
+    -- words and characters that tsqueries drop. Bodies that are stored compressed are searched in body_search.
+    AND ($11 = ''
+        OR ($4 != '' AND (SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D')) @@ TO_TSQUERY('simple', $4))
+        OR CONCAT(c.name, ' ', c.subject) ILIKE $11)
+    -- Optional list IDs based on user permission. All the lists that a campaign targets
+    -- have to be permitted.
+    AND ($8 = TRUE OR NOT EXISTS (
+        SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id AND cl.list_id IS NOT NULL AND cl.list_id != ALL($9::INT[])
+        UNION ALL
+        SELECT 1 FROM lists l WHERE l.group_id = ANY(c.list_group_ids) AND l.deleted_at IS NULL AND l.id != ALL($9::INT[])
+    ))
+    -- Optional cursor (keyset) pagination condition.
+    %cursor%
 ORDER BY %order% OFFSET $5 LIMIT (CASE WHEN $6 < 1 THEN NULL ELSE $6 END);
 
+-- name: has-campaign-lists
+-- Used for checking access permission by list. A campaign is accessible if all the
+-- lists that it targets, directly or via list groups, are in $2.
+SELECT c.id AS campaign_id, NOT EXISTS (
+        SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id AND cl.list_id IS NOT NULL AND cl.list_id != ALL($2::INT[])
+        UNION ALL
+        SELECT 1 FROM lists l WHERE l.group_id = ANY(c.list_group_ids) AND l.deleted_at IS NULL AND l.id != ALL($2::INT[])
+    ) AS has
+FROM campaigns c WHERE c.id = ANY($1::INT[]);
+
 -- name: get-campaign
 SELECT campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body,
+    (
+        -- Sender profile of the first of the campaign's lists (directly or through list groups) that has one.
+        SELECT lists.sender_profile FROM lists WHERE lists.sender_profile != '{}' AND lists.deleted_at IS NULL AND (
+            lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+            OR lists.group_id = ANY(campaigns.list_group_ids)
+        ) ORDER BY lists.id LIMIT 1
+    ) AS sender_profile
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $4 = 'default' THEN templates.id = campaigns.template_id
         ELSE templates.id = campaigns.archive_template_id END
     )
-    WHERE CASE
+    WHERE campaigns.deleted_at IS NULL AND CASE
             WHEN $1 > 0 THEN campaigns.id = $1
             WHEN $3 != '' THEN campaigns.archive_slug = $3
             ELSE uuid = $2
@@ -568,15 +1033,67 @@ SELECT campaigns.*,
 
 -- name: get-archived-campaigns
 SELECT COUNT(*) OVER () AS total, campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body,
+    (
+        -- Sender profile of the first of the campaign's lists (directly or through list groups) that has one.
+        SELECT lists.sender_profile FROM lists WHERE lists.sender_profile != '{}' AND lists.deleted_at IS NULL AND (
+            lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+            OR lists.group_id = ANY(campaigns.list_group_ids)
+        ) ORDER BY lists.id LIMIT 1
+    ) AS sender_profile
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $3 = 'default' THEN templates.id = campaigns.template_id
         ELSE templates.id = campaigns.archive_template_id END
     )
     WHERE campaigns.archive=true AND campaigns.type='regular' AND campaigns.status=ANY('{running, paused, finished}')
+    AND campaigns.deleted_at IS NULL
+    -- Optional list UUID ($4) to only get the archives of campaigns sent to the list.
+    AND ($4 = '' OR EXISTS (
+        SELECT 1 FROM lists l WHERE l.uuid::TEXT = $4 AND (
+            l.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+            OR l.group_id = ANY(campaigns.list_group_ids)
+        )
+    ))
     ORDER by campaigns.created_at DESC OFFSET $1 LIMIT $2;
 
+-- name: search-campaign-archives
+-- Ranked full-text search of sent campaigns with snippets of their bodies (stripped
+-- of styles, template expressions, and tags) in which the matched words are delimited
+-- by CHR(2) and CHR(3). Matching uses the idx_camps_search expression index.
+-- $1 = tsquery, $2 = only archived campaigns?, $3 = optional list UUID,
+-- $4 = search all campaigns?, $5 = list IDs of permitted campaigns.
+WITH q AS (
+    SELECT TO_TSQUERY('simple', $1) AS q
+),
+camps AS (
+    SELECT COUNT(*) OVER () AS total, c.id, c.uuid, c.name, c.subject, c.archive, c.archive_slug,
+        c.created_at, c.send_at, LEFT(c.body, 100000) || c.body_search AS body,
+        TS_RANK(SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D'), q.q) AS rank
+    FROM campaigns c, q
+    WHERE c.type = 'regular' AND c.status = ANY('{running, paused, finished}') AND c.deleted_at IS NULL
+        AND ($2 = FALSE OR c.archive = TRUE)
+        AND (SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D')) @@ q.q
+        AND ($3 = '' OR EXISTS (
+            SELECT 1 FROM lists l WHERE l.uuid::TEXT = $3 AND (
+                l.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = c.id)
+                OR l.group_id = ANY(c.list_group_ids)
+            )
+        ))
+        AND ($4 = TRUE OR NOT EXISTS (
+            SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id AND cl.list_id IS NOT NULL AND cl.list_id != ALL($5::INT[])
+            UNION ALL
+            SELECT 1 FROM lists l WHERE l.group_id = ANY(c.list_group_ids) AND l.deleted_at IS NULL AND l.id != ALL($5::INT[])
+        ))
+    ORDER BY rank DESC, c.created_at DESC OFFSET $6 LIMIT (CASE WHEN $7 < 1 THEN NULL ELSE $7 END)
+)
+SELECT total, id, uuid, name, subject, archive, archive_slug, created_at, send_at,
+    TS_HEADLINE('simple',
+        REGEXP_REPLACE(REGEXP_REPLACE(body, '<(style|script)[^>]*>[^<]*</(style|script)>|\{\{[^}]*\}\}', ' ', 'gi'), '<[^>]*>', ' ', 'g'),
+        q.q, 'StartSel="' || CHR(2) || '", StopSel="' || CHR(3) || '", MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" ... "'
+    ) AS snippet
+FROM camps, q ORDER BY rank DESC, created_at DESC;
+
 -- name: get-campaign-stats
 -- This query is used to lazy load campaign stats (views, counts, list of lists) given a list of campaign IDs.
 -- The query returns results in the same order as the given campaign IDs, and for non-existent campaign IDs,
@@ -591,7 +1108,8 @@ media AS (
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
 ),
 views AS (
-    SELECT campaign_id, COUNT(campaign_id) as num FROM campaign_views
+    SELECT campaign_id, COUNT(campaign_id) as num, COUNT(campaign_id) FILTER (WHERE NOT proxy) AS adjusted
+    FROM campaign_views
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
 ),
@@ -600,15 +1118,28 @@ clicks AS (
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
 ),
+bot_clicks AS (
+    SELECT campaign_id, COUNT(campaign_id) as num FROM link_clicks_bots
+    WHERE campaign_id = ANY($1)
+    GROUP BY campaign_id
+),
 bounces AS (
     SELECT campaign_id, COUNT(campaign_id) as num FROM bounces
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
+),
+replies AS (
+    SELECT campaign_id, COUNT(campaign_id) as num FROM campaign_replies
+    WHERE campaign_id = ANY($1)
+    GROUP BY campaign_id
 )
 SELECT id as campaign_id,
     COALESCE(v.num, 0) AS views,
+    COALESCE(v.adjusted, 0) AS views_adjusted,
     COALESCE(c.num, 0) AS clicks,
+    COALESCE(bc.num, 0) AS bot_clicks,
     COALESCE(b.num, 0) AS bounces,
+    COALESCE(r.num, 0) AS replies,
     COALESCE(l.lists, '[]') AS lists,
     COALESCE(m.media, '[]') AS media
 FROM (SELECT id FROM UNNEST($1) AS id) x
@@ -616,7 +1147,9 @@ LEFT JOIN lists AS l ON (l.campaign_id = id)
 LEFT JOIN media AS m ON (m.campaign_id = id)
 LEFT JOIN views AS v ON (v.campaign_id = id)
 LEFT JOIN clicks AS c ON (c.campaign_id = id)
+LEFT JOIN bot_clicks AS bc ON (bc.campaign_id = id)
 LEFT JOIN bounces AS b ON (b.campaign_id = id)
+LEFT JOIN replies AS r ON (r.campaign_id = id)
 ORDER BY ARRAY_POSITION($1, id);
 
 -- name: get-campaign-for-preview
@@ -627,7 +1160,14 @@ SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE i
         campaign_lists.list_name AS name
         FROM campaign_lists WHERE campaign_lists.campaign_id = campaigns.id
 	) l
-) AS lists
+) AS lists,
+(
+    -- Sender profile of the first of the campaign's lists (directly or through list groups) that has one.
+    SELECT lists.sender_profile FROM lists WHERE lists.sender_profile != '{}' AND lists.deleted_at IS NULL AND (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+        OR lists.group_id = ANY(campaigns.list_group_ids)
+    ) ORDER BY lists.id LIMIT 1
+) AS sender_profile
 FROM campaigns
 LEFT JOIN templates ON (templates.id = (CASE WHEN $2=0 THEN campaigns.template_id ELSE $2 END))
 WHERE campaigns.id = $1;
@@ -646,17 +1186,29 @@ SELECT id, status, to_send, sent, started_at, updated_at
 -- a campaign. This is used to fetch and slice subscribers for the campaign in next-campaign-subscribers.
 WITH camps AS (
     -- Get all running campaigns and their template bodies (if the template's deleted, the default template body instead)
-    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body
+    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1)) AS template_body,
+    (
+        -- Sender profile of the first of the campaign's lists (directly or through list groups) that has one.
+        SELECT lists.sender_profile FROM lists WHERE lists.sender_profile != '{}' AND lists.deleted_at IS NULL AND (
+            lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id)
+            OR lists.group_id = ANY(campaigns.list_group_ids)
+        ) ORDER BY lists.id LIMIT 1
+    ) AS sender_profile
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
+    AND campaigns.deleted_at IS NULL
     AND NOT(campaigns.id = ANY($1::INT[]))
 ),
 campLists AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
-    SELECT lists.id AS list_id, campaign_id, optin FROM lists
-    INNER JOIN campaign_lists ON (campaign_lists.list_id = lists.id)
-    WHERE campaign_lists.campaign_id = ANY(SELECT id FROM camps)
+    -- List groups targeted by a campaign are expanded to their member lists here.
+    SELECT lists.id AS list_id, camps.id AS campaign_id, optin FROM lists
+    INNER JOIN camps ON (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = camps.id)
+        OR lists.group_id = ANY(camps.list_group_ids)
+    )
+    WHERE lists.deleted_at IS NULL
 ),
 campMedia AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
@@ -677,6 +1229,20 @@ counts AS (
             END
         )
     JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    -- Retries only go to the soft-bounced recipients of the campaign they retry.
+    WHERE (camps.retry_attempt = 0 OR (
+        s.id IN (SELECT subscriber_id FROM bounces WHERE campaign_id = camps.retry_of AND type = 'soft')
+        AND s.id NOT IN (SELECT subscriber_id FROM bounces WHERE campaign_id = camps.retry_of AND type != 'soft')
+    ))
+    -- Follow-ups only go to the openers or clickers of their parent campaigns.
+    AND (NOT EXISTS (SELECT 1 FROM campaign_followups WHERE campaign_id = camps.id) OR s.id IN (
+        SELECT cv.subscriber_id FROM campaign_followups f JOIN campaign_views cv ON (cv.campaign_id = f.parent_id)
+            WHERE f.campaign_id = camps.id AND f.audience = 'openers'
+        UNION
+        SELECT lc.subscriber_id FROM campaign_followups f JOIN link_clicks lc ON (lc.campaign_id = f.parent_id)
+            JOIN links ON (links.id = lc.link_id)
+            WHERE f.campaign_id = camps.id AND f.audience = 'clickers' AND (f.url = '' OR links.url = f.url)
+    ))
     GROUP BY camps.id
 ),
 updateCounts AS (
@@ -695,7 +1261,9 @@ u AS (
     FROM (SELECT * FROM counts) co
     WHERE ca.id = co.campaign_id
 )
-SELECT camps.*, campMedia.media_id FROM camps LEFT JOIN campMedia ON (campMedia.campaign_id = camps.id);
+SELECT camps.*, campMedia.media_id,
+    (SELECT ARRAY_AGG(DISTINCT list_id ORDER BY list_id) FROM campLists WHERE campaign_id = camps.id)::INT[] AS list_ids
+    FROM camps LEFT JOIN campMedia ON (campMedia.campaign_id = camps.id);
 
 -- name: get-campaign-analytics-unique-counts
 WITH intval AS (
@@ -732,6 +1300,16 @@ SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), crea
     WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
     GROUP BY campaign_id, "timestamp" ORDER BY "timestamp" ASC;
 
+-- name: get-campaign-bounce-rates
+-- Bounce and complaint counts of running campaigns for evaluating alert rules.
+SELECT c.id, c.name, c.sent,
+    COUNT(b.id) FILTER (WHERE b.type != 'complaint') AS bounces,
+    COUNT(b.id) FILTER (WHERE b.type = 'complaint') AS complaints
+    FROM campaigns c
+    LEFT JOIN bounces b ON (b.campaign_id = c.id)
+    WHERE c.status = 'running'
+    GROUP BY c.id;
+
 -- name: get-campaign-link-counts
 -- raw: true
 -- %s = * or DISTINCT subscriber_id (prepared based on based on individual tracking=on/off). Prepared on boot.
@@ -741,13 +1319,44 @@ SELECT COUNT(%s) AS "count", url
     WHERE campaign_id=ANY($1) AND link_clicks.created_at >= $2 AND link_clicks.created_at <= $3
     GROUP BY links.url ORDER BY "count" DESC LIMIT 50;
 
+-- name: get-campaign-comparison
+-- raw: true
+-- %s = * or DISTINCT subscriber_id (prepared based on based on individual tracking=on/off). Prepared on boot.
+SELECT c.id, c.name, c.subject, c.status, c.sent, c.started_at,
+    (SELECT COUNT(%s) FROM campaign_views WHERE campaign_id = c.id) AS views,
+    (SELECT COUNT(%s) FROM campaign_views WHERE campaign_id = c.id AND NOT proxy) AS views_adjusted,
+    (SELECT COUNT(%s) FROM link_clicks WHERE campaign_id = c.id) AS clicks,
+    (SELECT COUNT(*) FROM bounces WHERE campaign_id = c.id) AS bounces,
+    (SELECT COUNT(*) FROM campaign_unsubscribes WHERE campaign_id = c.id) AS unsubscribes
+    FROM campaigns c
+    WHERE c.id = ANY($1::INT[]) AND c.deleted_at IS NULL;
+
+-- name: get-campaign-top-links
+-- raw: true
+-- Top $2 clicked links of each of the given campaigns ($1).
+-- %s = * or DISTINCT subscriber_id (prepared based on based on individual tracking=on/off). Prepared on boot.
+SELECT campaign_id, url, "count" FROM (
+    SELECT link_clicks.campaign_id, links.url, COUNT(%s) AS "count",
+        ROW_NUMBER() OVER (PARTITION BY link_clicks.campaign_id ORDER BY COUNT(%s) DESC) AS n
+        FROM link_clicks
+        LEFT JOIN links ON (link_clicks.link_id = links.id)
+        WHERE link_clicks.campaign_id = ANY($1::INT[])
+        GROUP BY link_clicks.campaign_id, links.url
+) t WHERE n <= $2 ORDER BY campaign_id, "count" DESC;
+
 -- name: get-running-campaign
 -- Returns the metadata for a running campaign that is required by next-campaign-subscribers to retrieve
 -- a batch of campaign subscribers for processing.
-SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id, lists.id AS list_id
+SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id, lists.id AS list_id,
+    COALESCE(subscriber_queries.query, '') AS subscriber_query, COALESCE(campaigns.retry_of, 0) AS retry_of, retry_attempt,
+    EXISTS (SELECT 1 FROM campaign_followups WHERE campaign_id = campaigns.id) AS is_followup
     FROM campaigns
-    LEFT JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
-    LEFT JOIN lists ON (lists.id = campaign_lists.list_id)
+    -- The campaign's lists and the member lists of its list groups.
+    LEFT JOIN lists ON (
+        (lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = campaigns.id) OR lists.group_id = ANY(campaigns.list_group_ids))
+        AND lists.deleted_at IS NULL
+    )
+    LEFT JOIN subscriber_queries ON (subscriber_queries.id = campaigns.subscriber_query_id)
     WHERE campaigns.id = $1 AND status='running';
 
 -- name: next-campaign-subscribers
@@ -762,26 +1371,30 @@ SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscr
 -- the query planner works as expected. The difference is staggering. ~15 seconds on a subscribers table with 15m
 -- rows and a subscriber_lists table with 70 million rows when fetching subscribers for a campaign with a single list,
 -- vs. a few million seconds using this current approach.
+--
 WITH campLists AS (
+    -- The campaign's lists and the member lists of its list groups.
     SELECT lists.id AS list_id, optin FROM lists
-    LEFT JOIN campaign_lists ON campaign_lists.list_id = lists.id
-    WHERE campaign_lists.campaign_id = $1
+    WHERE lists.deleted_at IS NULL AND (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        OR lists.group_id = ANY(SELECT UNNEST(list_group_ids) FROM campaigns WHERE id = $1)
+    )
 ),
 subs AS (
     SELECT s.*
     FROM (
-        SELECT DISTINCT s.id
+        SELECT DISTINCT subscribers.id
         FROM subscriber_lists sl
         JOIN campLists ON sl.list_id = campLists.list_id
-        JOIN subscribers s ON s.id = sl.subscriber_id
+        JOIN subscribers ON subscribers.id = sl.subscriber_id
         WHERE
             sl.list_id = ANY($5::INT[])
             -- last_subscriber_id
-            AND s.id > $3
+            AND subscribers.id > $3
              -- max_subscriber_id
-            AND s.id <= $4
+            AND subscribers.id <= $4
              -- Subscriber should not be blacklisted.
-            AND s.status != 'blocklisted'
+            AND subscribers.status != 'blocklisted'
             AND (
                 -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
                 ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
@@ -796,7 +1409,7 @@ subs AS (
                     )
                 )
             )
-        ORDER BY s.id LIMIT $6
+        ORDER BY subscribers.id LIMIT $6
     ) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id
 ),
 u AS (
@@ -806,10 +1419,144 @@ u AS (
 )
 SELECT * FROM subs;
 
+-- name: next-campaign-subscribers-by-query
+-- raw: true
+-- Returns a batch of subscribers of a campaign the same way next-campaign-subscribers does,
+-- for campaigns that target a saved subscriber query (or the recipients of another campaign).
+-- As the expression is arbitrary, this is run in a read-only transaction and does not move
+-- the checkpoint, which is updated separately with update-campaign-checkpoint.
+-- %query% = subscriber query expression.
+WITH campLists AS (
+    -- The campaign's lists and the member lists of its list groups.
+    SELECT lists.id AS list_id, optin FROM lists
+    WHERE lists.deleted_at IS NULL AND (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        OR lists.group_id = ANY(SELECT UNNEST(list_group_ids) FROM campaigns WHERE id = $1)
+    )
+)
+SELECT s.*
+FROM (
+    SELECT DISTINCT subscribers.id
+    FROM subscriber_lists sl
+    JOIN campLists ON sl.list_id = campLists.list_id
+    JOIN subscribers ON subscribers.id = sl.subscriber_id
+    WHERE
+        sl.list_id = ANY($5::INT[])
+        AND subscribers.id > $3
+        AND subscribers.id <= $4
+        AND subscribers.status != 'blocklisted'
+        AND (
+            ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
+            OR (
+                $2 != 'optin' AND (
+                    (campLists.optin = 'double' AND sl.status = 'confirmed') OR
+                    (campLists.optin != 'double' AND sl.status != 'unsubscribed')
+                )
+            )
+        )
+        %query%
+    ORDER BY subscribers.id LIMIT $6
+) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id;
+
+-- name: update-campaign-checkpoint
+UPDATE campaigns SET last_subscriber_id=$2, updated_at=NOW() WHERE id=$1;
+
+-- name: count-campaign-subscribers-by-query
+-- raw: true
+-- Counts the recipients of a campaign ($1, type $2) that targets a saved subscriber query
+-- the same way next-campaign-subscribers-by-query resolves them. This is run in a
+-- read-only transaction.
+-- %query% = subscriber query expression.
+WITH campLists AS (
+    SELECT lists.id AS list_id, optin FROM lists
+    WHERE lists.deleted_at IS NULL AND (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        OR lists.group_id = ANY(SELECT UNNEST(list_group_ids) FROM campaigns WHERE id = $1)
+    )
+)
+SELECT COUNT(DISTINCT subscribers.id)
+FROM subscriber_lists sl
+JOIN campLists ON sl.list_id = campLists.list_id
+JOIN subscribers ON subscribers.id = sl.subscriber_id
+WHERE subscribers.status != 'blocklisted'
+    AND (
+        ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
+        OR (
+            $2 != 'optin' AND (
+                (campLists.optin = 'double' AND sl.status = 'confirmed') OR
+                (campLists.optin != 'double' AND sl.status != 'unsubscribed')
+            )
+        )
+    )
+    %query%;
+
+-- name: update-campaign-to-send
+UPDATE campaigns SET to_send=$2 WHERE id=$1;
+
+-- name: get-campaign-send-plan
+-- raw: true
+-- Resolves the audience of a campaign the same way next-campaign-subscribers does, without
+-- sending, and counts the recipients, the subscribers that are skipped, and the recipients
+-- per list and per e-mail domain (top $3 domains).
+-- $1 = campaign ID, $2 = campaign type.
+-- %query% = optional arbitrary subscriber query expression (from a saved subscriber query).
+WITH campLists AS (
+    -- The campaign's lists and the member lists of its list groups.
+    SELECT lists.id AS list_id, lists.name, optin FROM lists
+    WHERE lists.deleted_at IS NULL AND (
+        lists.id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        OR lists.group_id = ANY(SELECT UNNEST(list_group_ids) FROM campaigns WHERE id = $1)
+    )
+),
+subs AS (
+    SELECT sl.list_id, subscribers.id, subscribers.email, subscribers.status = 'blocklisted' AS blocklisted,
+        (
+            -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
+            ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
+            OR (
+                $2 != 'optin' AND (
+                    (campLists.optin = 'double' AND sl.status = 'confirmed') OR
+                    (campLists.optin != 'double' AND sl.status != 'unsubscribed')
+                )
+            )
+        ) AS eligible
+    FROM subscriber_lists sl
+    JOIN campLists ON sl.list_id = campLists.list_id
+    JOIN subscribers ON subscribers.id = sl.subscriber_id
+    WHERE TRUE %query%
+),
+recipients AS (
+    SELECT DISTINCT id, email FROM subs WHERE eligible AND NOT blocklisted
+)
+SELECT
+    (SELECT COUNT(*) FROM recipients) AS recipients,
+    (SELECT COUNT(*) FROM subs WHERE eligible AND NOT blocklisted) - (SELECT COUNT(*) FROM recipients) AS duplicates,
+    (SELECT COUNT(DISTINCT id) FROM subs WHERE eligible AND blocklisted) AS blocklisted,
+    (SELECT COUNT(DISTINCT id) FROM subs WHERE NOT eligible AND id NOT IN (SELECT id FROM subs WHERE eligible)) AS ineligible,
+    COALESCE((
+        SELECT JSON_AGG(l) FROM (
+            SELECT cl.list_id AS id, cl.name, COUNT(s.id) AS subscriptions,
+                COUNT(s.id) FILTER (WHERE s.eligible AND NOT s.blocklisted) AS recipients,
+                COUNT(s.id) FILTER (WHERE s.eligible AND s.blocklisted) AS blocklisted,
+                COUNT(s.id) FILTER (WHERE NOT s.eligible) AS ineligible
+            FROM campLists cl LEFT JOIN subs s ON (s.list_id = cl.list_id)
+            GROUP BY cl.list_id, cl.name ORDER BY cl.name
+        ) l
+    ), '[]') AS lists,
+    COALESCE((
+        SELECT JSON_AGG(d) FROM (
+            SELECT LOWER(SPLIT_PART(email, '@', 2)) AS domain, COUNT(*) AS recipients
+            FROM recipients GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $3
+        ) d
+    ), '[]') AS domains;
+
 -- name: delete-campaign-views
 DELETE FROM campaign_views WHERE created_at < $1;
 
 -- name: delete-campaign-link-clicks
+WITH bots AS (
+    DELETE FROM link_clicks_bots WHERE created_at < $1
+)
 DELETE FROM link_clicks WHERE created_at < $1;
 
 -- name: get-one-campaign-subscriber
@@ -844,6 +1591,17 @@ WITH camp AS (
         archive_slug=$15,
         archive_template_id=$16,
         archive_meta=$17,
+        subscriber_query_id=$19,
+        list_group_ids=COALESCE($20::INT[], '{}'),
+        attachment_urls=COALESCE($21::TEXT[], '{}'),
+        event=$22::JSONB,
+        preheader=$23,
+        variants=COALESCE($24::JSONB, '[]'),
+        body_gz=$25,
+        body_ref=$26,
+        send_at_tz=$27,
+        body_search=$28,
+        version=version + 1,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
@@ -861,9 +1619,17 @@ medi AS (
         ON CONFLICT (campaign_id, media_id) DO NOTHING
 )
 INSERT INTO campaign_lists (campaign_id, list_id, list_name)
-    (SELECT $1 as campaign_id, id, name FROM lists WHERE id=ANY($13::INT[]))
+    (SELECT $1 as campaign_id, id, name FROM lists WHERE id=ANY($13::INT[]) AND deleted_at IS NULL)
     ON CONFLICT (campaign_id, list_id) DO UPDATE SET list_name = EXCLUDED.list_name;
 
+-- name: get-campaign-body-ref
+-- Returns the media store object of a campaign's body and whether another
+-- campaign (eg: a retry) shares it.
+SELECT body_ref, EXISTS(
+        SELECT 1 FROM campaigns c2 WHERE c2.body_ref = c.body_ref AND c2.id != c.id
+    ) AS shared
+    FROM campaigns c WHERE id = $1 AND body_ref != '';
+
 -- name: update-campaign-counts
 UPDATE campaigns SET
     to_send=(CASE WHEN $2 != 0 THEN $2 ELSE to_send END),
@@ -873,7 +1639,21 @@ UPDATE campaigns SET
 WHERE id=$1;
 
 -- name: update-campaign-status
-UPDATE campaigns SET status=$2, updated_at=NOW() WHERE id = $1;
+-- A status change clears the campaign's pause by a circuit breaker so that it isn't resumed.
+UPDATE campaigns SET status=$2, paused_by_breaker='', updated_at=NOW() WHERE id = $1;
+
+-- name: pause-campaign
+-- Pauses a campaign, recording the messenger whose circuit breaker paused it ($2), if any.
+UPDATE campaigns SET status='paused', paused_by_breaker=$2, updated_at=NOW() WHERE id = $1;
+
+-- name: resume-campaign
+-- Resumes a campaign only if it's still paused by a circuit breaker.
+UPDATE campaigns SET status='running', paused_by_breaker='', updated_at=NOW()
+    WHERE id = $1 AND status='paused' AND paused_by_breaker != '';
+
+-- name: get-breaker-paused-campaigns
+SELECT id, paused_by_breaker FROM campaigns
+    WHERE status='paused' AND paused_by_breaker != '' AND deleted_at IS NULL;
 
 -- name: update-campaign-archive
 UPDATE campaigns SET
@@ -884,24 +1664,536 @@ UPDATE campaigns SET
     updated_at=NOW()
     WHERE id=$1;
 
--- name: delete-campaign
-DELETE FROM campaigns WHERE id=$1;
+-- name: update-campaign-simulate
+-- Only campaigns that haven't started can be switched in or out of simulation.
+UPDATE campaigns SET simulate=$2, updated_at=NOW()
+    WHERE id=$1 AND status IN ('draft', 'scheduled');
+
+-- name: delete-campaigns
+-- Campaigns ($1) are soft-deleted (moved to the trash) and purged later by purge-trash.
+-- Running and paused campaigns are cancelled and scheduled campaigns are reverted
+-- to drafts so that they aren't sent if they're restored.
+UPDATE campaigns SET deleted_at=NOW(),
+    status=(CASE WHEN status IN ('running', 'paused') THEN 'cancelled'
+        WHEN status = 'scheduled' THEN 'draft' ELSE status END)
+    WHERE id = ANY($1::INT[]) AND deleted_at IS NULL;
+
+-- name: update-campaigns-tags
+-- Adds ($2 = 'add'), removes ('remove'), or replaces ('set') the tags $3 on the campaigns $1,
+-- removing duplicates and retaining the order of tags.
+UPDATE campaigns SET tags = (CASE
+        WHEN $2 = 'add' THEN ARRAY(
+            SELECT t FROM (
+                SELECT t, MIN(n) AS n FROM UNNEST(COALESCE(tags, '{}') || $3::VARCHAR(100)[]) WITH ORDINALITY AS x(t, n) GROUP BY t
+            ) x ORDER BY n
+        )
+        WHEN $2 = 'remove' THEN ARRAY(
+            SELECT t FROM UNNEST(tags) WITH ORDINALITY AS x(t, n) WHERE NOT (t = ANY($3::VARCHAR(100)[])) ORDER BY n
+        )
+        ELSE $3::VARCHAR(100)[]
+    END), version=version + 1, updated_at=NOW()
+    WHERE id = ANY($1::INT[]) AND deleted_at IS NULL;
+
+-- name: update-campaigns-archive
+-- Publishes ($2 = true) or unpublishes the campaigns $1 on the public archive. Campaigns
+-- without an archive template use their own template.
+UPDATE campaigns SET archive=$2, archive_template_id=COALESCE(archive_template_id, template_id),
+    version=version + 1, updated_at=NOW()
+    WHERE id = ANY($1::INT[]) AND deleted_at IS NULL;
+
+-- name: update-campaigns-template
+-- Sets the template $2 on the campaigns $1. Running campaigns, whose templates are
+-- compiled and in use, are skipped.
+UPDATE campaigns SET template_id=$2, version=version + 1, updated_at=NOW()
+    WHERE id = ANY($1::INT[]) AND status != 'running' AND deleted_at IS NULL;
+
+-- name: register-campaign-views
+-- Bulk inserts campaign views buffered by the tracker. Views on unknown campaigns are dropped.
+-- Views that were buffered before the subscriber's UUID was rotated are attributed by the old UUID.
+WITH v AS (
+    SELECT * FROM UNNEST($1::UUID[], $2::TEXT[], $3::BOOLEAN[], $4::TIMESTAMP WITH TIME ZONE[])
+        AS v(campaign_uuid, subscriber_uuid, proxy, created_at)
+)
+INSERT INTO campaign_views (campaign_id, subscriber_id, proxy, created_at)
+    SELECT campaigns.id, COALESCE(subscribers.id, rot.subscriber_id), v.proxy, v.created_at FROM v
+    JOIN campaigns ON campaigns.uuid = v.campaign_uuid
+    LEFT JOIN subscribers ON subscribers.uuid = NULLIF(v.subscriber_uuid, '')::UUID
+    LEFT JOIN LATERAL (
+        SELECT subscriber_id FROM subscriber_uuid_rotations
+        WHERE old_uuid = NULLIF(v.subscriber_uuid, '')::UUID AND created_at >= v.created_at LIMIT 1
+    ) rot ON subscribers.id IS NULL;
+
+-- name: upsert-campaign-rsvp
+-- Records a subscriber's RSVP response to a campaign's calendar invite.
+INSERT INTO campaign_rsvps (campaign_id, subscriber_id, status)
+    SELECT c.id, s.id, $3::rsvp_status FROM campaigns c, subscribers s
+    WHERE c.uuid = $1::UUID AND s.uuid = $2::UUID AND c.event IS NOT NULL
+    ON CONFLICT (campaign_id, subscriber_id) DO UPDATE SET status = $3::rsvp_status, updated_at = NOW();
+
+-- name: get-campaign-rsvps
+SELECT COUNT(*) FILTER (WHERE status = 'accepted') AS accepted,
+    COUNT(*) FILTER (WHERE status = 'declined') AS declined,
+    COUNT(*) FILTER (WHERE status = 'tentative') AS tentative
+    FROM campaign_rsvps WHERE campaign_id = $1;
+
+-- name: update-campaign-variant-counts
+-- Adds to the sent counts of a campaign's language variants. $2 = langs, $3 = counts.
+INSERT INTO campaign_variant_stats (campaign_id, lang, sent)
+    SELECT $1, l.lang, l.sent FROM UNNEST($2::TEXT[], $3::INT[]) AS l(lang, sent)
+    ON CONFLICT (campaign_id, lang) DO UPDATE SET sent = campaign_variant_stats.sent + EXCLUDED.sent;
+
+-- name: insert-campaign-revision
+-- Inserts a revision of a kind ($2) of a campaign ($1) and prunes all but its latest $5 revisions of the kind.
+WITH ins AS (
+    INSERT INTO campaign_revisions (campaign_id, kind, data, created_by) VALUES($1, $2, $3, $4)
+    RETURNING id, campaign_id, kind, created_by, created_at
+),
+del AS (
+    DELETE FROM campaign_revisions WHERE campaign_id = $1 AND kind = $2 AND id NOT IN (
+        SELECT id FROM campaign_revisions WHERE campaign_id = $1 AND kind = $2 ORDER BY id DESC LIMIT GREATEST($5 - 1, 0)
+    )
+)
+SELECT * FROM ins;
+
+-- name: get-campaign-revisions
+-- Returns the revisions of a campaign ($1), optionally of a kind ($3), latest first.
+-- The data is only returned when a revision ID ($2) is given.
+SELECT r.id, r.campaign_id, r.kind, (CASE WHEN $2 > 0 THEN r.data ELSE NULL END) AS data,
+    r.created_by, COALESCE(u.name, '') AS created_by_name, r.created_at
+    FROM campaign_revisions r
+    LEFT JOIN users u ON u.id = r.created_by
+    WHERE r.campaign_id = $1 AND ($2 = 0 OR r.id = $2) AND ($3 = '' OR r.kind = $3)
+    ORDER BY r.id DESC;
+
+-- name: get-campaign-checklist
+-- Returns the given checklist items ($2) of a campaign ($1) in order, and
+-- whether, when, and by whom they've been checked off.
+SELECT i.item, (c.item IS NOT NULL) AS done, c.created_by,
+    COALESCE(u.name, '') AS created_by_name, c.created_at
+    FROM UNNEST($2::TEXT[]) WITH ORDINALITY AS i(item, n)
+    LEFT JOIN campaign_checklist c ON (c.campaign_id = $1 AND c.item = i.item)
+    LEFT JOIN users u ON u.id = c.created_by
+    ORDER BY i.n;
+
+-- name: set-campaign-checklist-item
+-- Checks ($3=true) or unchecks an item ($2) on a campaign's ($1) checklist.
+WITH del AS (
+    DELETE FROM campaign_checklist WHERE campaign_id = $1 AND item = $2 AND NOT $3
+)
+INSERT INTO campaign_checklist (campaign_id, item, created_by)
+    SELECT $1, $2, NULLIF($4, 0) WHERE $3
+    ON CONFLICT (campaign_id, item) DO UPDATE SET created_by = EXCLUDED.created_by, created_at = NOW();
+
+-- name: get-campaign-presets
+-- Returns all campaign presets or the one with the given ID ($1). The body is only
+-- returned when an ID is given.
+SELECT id, name, description, subject, preheader, from_email,
+    (CASE WHEN $1 > 0 THEN body ELSE '' END) AS body, altbody, content_type,
+    template_id, headers, messenger, tags, list_ids, utm, created_at, updated_at
+    FROM campaign_presets WHERE ($1 = 0 OR id = $1) ORDER BY name;
+
+-- name: create-campaign-preset
+INSERT INTO campaign_presets (name, description, subject, preheader, from_email, body, altbody,
+    content_type, template_id, headers, messenger, tags, list_ids, utm)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, (SELECT id FROM templates WHERE id = $9 AND type = 'campaign'),
+    $10, $11, $12, $13, $14)
+    RETURNING id;
+
+-- name: update-campaign-preset
+UPDATE campaign_presets SET name=$2, description=$3, subject=$4, preheader=$5, from_email=$6,
+    body=$7, altbody=$8, content_type=$9,
+    template_id=(SELECT id FROM templates WHERE id = $10 AND type = 'campaign'),
+    headers=$11, messenger=$12, tags=$13, list_ids=$14, utm=$15, updated_at=NOW()
+    WHERE id=$1;
 
--- name: register-campaign-view
-WITH view AS (
-    SELECT campaigns.id as campaign_id, subscribers.id AS subscriber_id FROM campaigns
-    LEFT JOIN subscribers ON (CASE WHEN $2::TEXT != '' THEN subscribers.uuid = $2::UUID ELSE FALSE END)
-    WHERE campaigns.uuid = $1
+-- name: delete-campaign-preset
+DELETE FROM campaign_presets WHERE id=$1;
+
+-- name: create-campaign-retry
+-- Creates a copy of a campaign ($1) with a new UUID ($2) and name ($3) that's scheduled
+-- to be sent at $4 to the campaign's soft-bounced recipients.
+WITH camp AS (
+    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, body_gz, body_ref, body_search, altbody, content_type, send_at, send_at_tz, status,
+        headers, tags, messenger, template_id, archive_template_id, subscriber_query_id, folder_id,
+        list_group_ids, attachment_urls, event, preheader, variants, retry_of, retry_attempt)
+    SELECT $2, type, $3, subject, from_email, body, body_gz, body_ref, body_search, altbody, content_type, $4, send_at_tz, 'scheduled',
+        headers, tags, messenger, template_id, archive_template_id, subscriber_query_id, folder_id,
+        list_group_ids, attachment_urls, event, preheader, variants, id, retry_attempt + 1
+    FROM campaigns WHERE id = $1
+    RETURNING id
+),
+med AS (
+    INSERT INTO campaign_media (campaign_id, media_id, filename)
+        SELECT (SELECT id FROM camp), media_id, filename FROM campaign_media WHERE campaign_id = $1
+),
+insLists AS (
+    INSERT INTO campaign_lists (campaign_id, list_id, list_name)
+        SELECT (SELECT id FROM camp), list_id, list_name FROM campaign_lists WHERE campaign_id = $1 AND list_id IS NOT NULL
+)
+SELECT id FROM camp;
+
+-- name: get-campaign-retries
+-- Returns a campaign ($1) and the chain of its soft-bounce retries (retries of retries)
+-- with their send, bounce, and engagement counts.
+WITH RECURSIVE chain AS (
+    SELECT id FROM campaigns WHERE id = $1
+    UNION
+    SELECT c.id FROM campaigns c JOIN chain ON c.retry_of = chain.id WHERE c.deleted_at IS NULL
+)
+SELECT c.id, c.uuid, c.name, c.status, COALESCE(c.retry_of, 0) AS retry_of, c.retry_attempt,
+    c.send_at, c.started_at, c.to_send, c.sent,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM bounces WHERE campaign_id = c.id AND type = 'soft') AS soft_bounces,
+    (SELECT COUNT(*) FROM bounces WHERE campaign_id = c.id) AS bounces,
+    (SELECT COUNT(*) FROM campaign_views WHERE campaign_id = c.id) AS views,
+    (SELECT COUNT(*) FROM link_clicks WHERE campaign_id = c.id) AS clicks
+FROM campaigns c WHERE c.id IN (SELECT id FROM chain)
+ORDER BY c.retry_attempt, c.id;
+
+-- name: get-campaign-followups
+-- Returns the chain of follow-ups below a campaign ($1) (follow-ups of follow-ups) with the
+-- size of their audiences (the openers or clickers of their parents), and their send and
+-- engagement counts.
+WITH RECURSIVE chain AS (
+    SELECT f.id, 1 AS depth FROM campaign_followups f WHERE f.parent_id = $1
+    UNION
+    SELECT f.id, chain.depth + 1 FROM campaign_followups f
+        JOIN campaign_followups p ON (p.campaign_id = f.parent_id)
+        JOIN chain ON (chain.id = p.id)
+)
+SELECT f.id, f.parent_id, f.campaign_id, chain.depth, c.name, c.status AS campaign_status, f.audience, f.url,
+    f.delay_days, f.status, c.send_at, c.to_send, c.sent,
+    (CASE WHEN f.audience = 'openers'
+        THEN (SELECT COUNT(DISTINCT subscriber_id) FROM campaign_views WHERE campaign_id = f.parent_id)
+        ELSE (SELECT COUNT(DISTINCT lc.subscriber_id) FROM link_clicks lc JOIN links ON (links.id = lc.link_id)
+            WHERE lc.campaign_id = f.parent_id AND (f.url = '' OR links.url = f.url))
+    END) AS audience_size,
+    (SELECT COUNT(*) FROM campaign_views WHERE campaign_id = c.id) AS views,
+    (SELECT COUNT(*) FROM link_clicks WHERE campaign_id = c.id) AS clicks,
+    f.created_at, f.updated_at
+FROM campaign_followups f
+JOIN chain ON (chain.id = f.id)
+JOIN campaigns c ON (c.id = f.campaign_id)
+WHERE c.deleted_at IS NULL
+ORDER BY chain.depth, f.id;
+
+-- name: upsert-campaign-followup
+-- Makes a campaign ($2) a follow-up of another ($1), or updates it if it already is one.
+-- Nothing is returned if $1 is $2 or one of the follow-ups below $2, which would be a loop.
+WITH RECURSIVE chain AS (
+    SELECT $2::INT AS id
+    UNION
+    SELECT f.campaign_id FROM campaign_followups f JOIN chain ON (f.parent_id = chain.id)
+)
+INSERT INTO campaign_followups (parent_id, campaign_id, audience, url, delay_days)
+    SELECT $1::INT, $2::INT, $3::followup_audience, $4::TEXT, $5::INT WHERE NOT ($1 = ANY(SELECT id FROM chain))
+    ON CONFLICT (campaign_id) DO UPDATE
+        SET parent_id=$1, audience=$3, url=$4, delay_days=$5, status='waiting', updated_at=NOW()
+    RETURNING id;
+
+-- name: delete-campaign-followup
+DELETE FROM campaign_followups WHERE parent_id = $1 AND campaign_id = $2;
+
+-- name: schedule-campaign-followups
+-- Schedules the draft follow-ups of campaigns that have finished to be sent delay_days after
+-- their parents finished (or right away if that's past), and cancels the follow-ups of
+-- campaigns that were cancelled or deleted. Returns the follow-ups that were updated.
+WITH due AS (
+    SELECT f.id, f.campaign_id, (p.status = 'finished' AND p.deleted_at IS NULL) AS ok,
+        p.updated_at + MAKE_INTERVAL(days => f.delay_days) AS send_at
+    FROM campaign_followups f
+    JOIN campaigns p ON (p.id = f.parent_id)
+    WHERE f.status = 'waiting' AND (p.status IN ('finished', 'cancelled') OR p.deleted_at IS NOT NULL)
+),
+camps AS (
+    UPDATE campaigns c SET status='scheduled', send_at=GREATEST(due.send_at, NOW()), updated_at=NOW()
+    FROM due WHERE c.id = due.campaign_id AND due.ok AND c.status = 'draft' AND c.deleted_at IS NULL
+)
+UPDATE campaign_followups f
+    SET status=(CASE WHEN due.ok THEN 'scheduled' ELSE 'cancelled' END)::followup_status, updated_at=NOW()
+    FROM due WHERE f.id = due.id
+    RETURNING f.parent_id, f.campaign_id, f.status;
+
+-- name: get-repermission-targets
+-- Counts the unconfirmed subscriptions of non-blocklisted subscribers to the given lists ($1)
+-- that a re-permission run would target. Only double opt-in lists have unconfirmed subscriptions
+-- that can be re-permissioned.
+SELECT lists.id AS list_id, lists.name AS list_name, lists.optin,
+    COUNT(s.id) FILTER (WHERE lists.optin = 'double') AS unconfirmed
+FROM lists
+LEFT JOIN subscriber_lists sl ON (sl.list_id = lists.id AND sl.status = 'unconfirmed')
+LEFT JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+WHERE lists.id = ANY($1::INT[]) AND lists.deleted_at IS NULL
+GROUP BY lists.id
+ORDER BY lists.id;
+
+-- name: create-repermission
+-- Creates a re-permission run of an opt-in campaign ($1) with a snapshot of the unconfirmed
+-- subscriptions of non-blocklisted subscribers to its double opt-in lists ($2).
+WITH r AS (
+    INSERT INTO repermissions (campaign_id, list_ids, deadline, action, created_by)
+        VALUES($1, $2, $3, $4, NULLIF($5, 0))
+        RETURNING id
+),
+subs AS (
+    INSERT INTO repermission_subscriptions (repermission_id, subscriber_id, list_id)
+        SELECT (SELECT id FROM r), sl.subscriber_id, sl.list_id FROM subscriber_lists sl
+        JOIN lists ON (lists.id = sl.list_id AND lists.optin = 'double')
+        JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+        WHERE sl.list_id = ANY($2::INT[]) AND sl.status = 'unconfirmed'
+)
+SELECT id FROM r;
+
+-- name: query-repermissions
+-- Returns re-permission runs ($1 = 0 for all) with their progress: the number of targeted
+-- subscriptions that have been confirmed, unsubscribed, or are still pending. Runs are
+-- visible if all their lists are in $3, or $2 is TRUE.
+SELECT r.id, r.campaign_id, c.name AS campaign_name, c.status AS campaign_status, c.sent, c.to_send,
+    r.list_ids, r.deadline, r.action, r.status, r.removed,
+    COUNT(rs.subscriber_id) AS targeted,
+    COUNT(rs.subscriber_id) FILTER (WHERE sl.status = 'confirmed') AS confirmed,
+    COUNT(rs.subscriber_id) FILTER (WHERE sl.status = 'unsubscribed') AS unsubscribed,
+    COUNT(rs.subscriber_id) FILTER (WHERE sl.status = 'unconfirmed') AS pending,
+    r.created_at, r.updated_at, r.completed_at
+FROM repermissions r
+JOIN campaigns c ON (c.id = r.campaign_id)
+LEFT JOIN repermission_subscriptions rs ON (rs.repermission_id = r.id)
+LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = rs.subscriber_id AND sl.list_id = rs.list_id)
+WHERE ($1 = 0 OR r.id = $1) AND ($2 OR r.list_ids <@ $3::INT[])
+GROUP BY r.id, c.id
+ORDER BY r.id DESC;
+
+-- name: update-repermission
+-- Updates the deadline and action of a re-permission run that's waiting.
+UPDATE repermissions SET deadline=$2, action=$3, updated_at=NOW() WHERE id = $1 AND status = 'waiting';
+
+-- name: cancel-repermission
+UPDATE repermissions SET status='cancelled', completed_at=NOW(), updated_at=NOW() WHERE id = $1 AND status = 'waiting';
+
+-- name: process-repermissions
+-- Removes or unsubscribes the targeted subscriptions that are still unconfirmed in re-permission
+-- runs whose campaigns have finished and whose deadlines have passed. Runs of campaigns that were
+-- cancelled or deleted are cancelled. Returns the runs that were processed.
+WITH runs AS (
+    SELECT r.id, r.action, (c.status = 'finished' AND c.deleted_at IS NULL) AS ok
+    FROM repermissions r
+    JOIN campaigns c ON (c.id = r.campaign_id)
+    WHERE r.status = 'waiting' AND (
+        (c.status = 'finished' AND r.deadline <= NOW()) OR c.status = 'cancelled' OR c.deleted_at IS NOT NULL
+    )
+),
+subs AS (
+    SELECT rs.repermission_id, rs.subscriber_id, rs.list_id, runs.action
+    FROM repermission_subscriptions rs
+    JOIN runs ON (runs.id = rs.repermission_id AND runs.ok)
+    JOIN subscriber_lists sl ON (sl.subscriber_id = rs.subscriber_id AND sl.list_id = rs.list_id AND sl.status = 'unconfirmed')
+),
+del AS (
+    DELETE FROM subscriber_lists sl USING subs
+    WHERE subs.action = 'remove' AND sl.subscriber_id = subs.subscriber_id AND sl.list_id = subs.list_id
+    RETURNING subs.repermission_id
+),
+unsub AS (
+    UPDATE subscriber_lists sl SET status='unsubscribed', updated_at=NOW() FROM subs
+    WHERE subs.action = 'unsubscribe' AND sl.subscriber_id = subs.subscriber_id AND sl.list_id = subs.list_id
+    RETURNING subs.repermission_id
+),
+counts AS (
+    SELECT repermission_id, COUNT(*) AS n FROM (SELECT * FROM del UNION ALL SELECT * FROM unsub) x
+    GROUP BY repermission_id
+)
+UPDATE repermissions r
+    SET status=(CASE WHEN runs.ok THEN 'done' ELSE 'cancelled' END)::repermission_status,
+        removed=COALESCE(counts.n, 0), completed_at=NOW(), updated_at=NOW()
+    FROM runs LEFT JOIN counts ON (counts.repermission_id = runs.id)
+    WHERE r.id = runs.id
+    RETURNING r.id, r.campaign_id, r.status, r.removed;
+
+-- name: update-campaign-domain-counts
+-- Adds to the sent counts of a campaign's recipient domains. $2 = domains, $3 = counts.
+INSERT INTO campaign_domain_stats (campaign_id, domain, sent)
+    SELECT $1, d.domain, d.sent FROM UNNEST($2::TEXT[], $3::INT[]) AS d(domain, sent)
+    ON CONFLICT (campaign_id, domain) DO UPDATE SET sent = campaign_domain_stats.sent + EXCLUDED.sent;
+
+-- name: get-campaign-domain-stats
+-- Sent counts per recipient domain with the bounces, complaints, views, and clicks
+-- (unique subscribers) attributed to the domains of the subscribers' e-mails. Returns
+-- the top $2 domains by the number of messages sent.
+WITH bounceCounts AS (
+    SELECT LOWER(SPLIT_PART(s.email, '@', 2)) AS domain,
+        COUNT(DISTINCT b.subscriber_id) FILTER (WHERE b.type != 'complaint') AS bounces,
+        COUNT(DISTINCT b.subscriber_id) FILTER (WHERE b.type = 'hard') AS hard_bounces,
+        COUNT(DISTINCT b.subscriber_id) FILTER (WHERE b.type = 'complaint') AS complaints
+    FROM bounces b JOIN subscribers s ON s.id = b.subscriber_id
+    WHERE b.campaign_id = $1 GROUP BY 1
+),
+viewCounts AS (
+    SELECT LOWER(SPLIT_PART(s.email, '@', 2)) AS domain, COUNT(DISTINCT v.subscriber_id) AS views
+    FROM campaign_views v JOIN subscribers s ON s.id = v.subscriber_id
+    WHERE v.campaign_id = $1 GROUP BY 1
+),
+clickCounts AS (
+    SELECT LOWER(SPLIT_PART(s.email, '@', 2)) AS domain, COUNT(DISTINCT c.subscriber_id) AS clicks
+    FROM link_clicks c JOIN subscribers s ON s.id = c.subscriber_id
+    WHERE c.campaign_id = $1 GROUP BY 1
+),
+domains AS (
+    SELECT domain, sent FROM campaign_domain_stats WHERE campaign_id = $1
+    UNION ALL SELECT domain, 0 FROM bounceCounts WHERE domain NOT IN (SELECT domain FROM campaign_domain_stats WHERE campaign_id = $1)
+)
+SELECT d.domain, d.sent,
+    COALESCE(b.bounces, 0) AS bounces, COALESCE(b.hard_bounces, 0) AS hard_bounces,
+    COALESCE(b.complaints, 0) AS complaints, COALESCE(v.views, 0) AS views, COALESCE(c.clicks, 0) AS clicks,
+    (CASE WHEN d.sent > 0 THEN ROUND(COALESCE(b.bounces, 0)::NUMERIC / d.sent * 100, 2) ELSE 0 END) AS bounce_rate,
+    (CASE WHEN d.sent > 0 THEN ROUND(COALESCE(v.views, 0)::NUMERIC / d.sent * 100, 2) ELSE 0 END) AS view_rate
+FROM domains d
+LEFT JOIN bounceCounts b ON b.domain = d.domain
+LEFT JOIN viewCounts v ON v.domain = d.domain
+LEFT JOIN clickCounts c ON c.domain = d.domain
+ORDER BY d.sent DESC, d.domain LIMIT $2;
+
+-- name: start-campaign-outbox
+-- Holds a campaign that hasn't sent anything and has 1 to $2 recipients for
+-- review by rendering its messages into the outbox. Returns the number of
+-- campaigns held (0 or 1).
+WITH u AS (
+    UPDATE campaigns SET outbox='rendering', updated_at=NOW()
+    WHERE id=$1 AND outbox='' AND sent=0 AND to_send BETWEEN 1 AND $2
+    RETURNING id
+),
+d AS (
+    DELETE FROM campaign_outbox WHERE campaign_id = (SELECT id FROM u)
+)
+SELECT COUNT(*) FROM u;
+
+-- name: insert-outbox-message
+INSERT INTO campaign_outbox (campaign_id, subscriber_id, email, subject, body, altbody)
+    VALUES($1, $2, $3, $4, $5, $6)
+    ON CONFLICT (campaign_id, subscriber_id) DO UPDATE
+    SET email=$3, subject=$4, body=$5, altbody=$6, created_at=NOW();
+
+-- name: finish-campaign-outbox
+-- Pauses a campaign whose messages have all been rendered into the outbox for
+-- review and resets its checkpoint so that they're sent from the start on approval.
+UPDATE campaigns SET outbox='pending', status='paused', sent=0, last_subscriber_id=0, updated_at=NOW()
+    WHERE id=$1 AND outbox='rendering';
+
+-- name: get-outbox-messages
+-- Returns the outbox messages of a campaign for the given subscriber IDs ($2).
+SELECT id, campaign_id, subscriber_id, email, subject, body, altbody, created_at
+    FROM campaign_outbox WHERE campaign_id=$1 AND subscriber_id = ANY($2::INT[]);
+
+-- name: query-outbox-messages
+-- Returns a page of a campaign's outbox messages, optionally searched by the
+-- recipient's e-mail or name ($3), or a single message with its body ($2).
+SELECT COUNT(*) OVER () AS total, o.id, o.campaign_id, o.subscriber_id, o.email,
+    COALESCE(s.name, '') AS name, o.subject,
+    (CASE WHEN $2 > 0 THEN o.body ELSE '' END) AS body,
+    (CASE WHEN $2 > 0 THEN o.altbody ELSE '' END) AS altbody,
+    o.created_at
+FROM campaign_outbox o
+LEFT JOIN subscribers s ON (s.id = o.subscriber_id)
+WHERE o.campaign_id = $1
+    AND ($2 = 0 OR o.id = $2)
+    AND ($3 = '' OR o.email ILIKE $3 OR s.name ILIKE $3)
+ORDER BY o.id OFFSET $4 LIMIT (CASE WHEN $5 < 1 THEN NULL ELSE $5 END);
+
+-- name: approve-campaign-outbox
+-- Approves a campaign's reviewed outbox and starts sending it.
+UPDATE campaigns SET outbox='approved', status='running', updated_at=NOW()
+    WHERE id=$1 AND outbox='pending' AND status='paused';
+
+-- name: reject-campaign-outbox
+-- Discards a campaign's reviewed outbox. The campaign stays paused and its
+-- messages are rendered for review again when it's started.
+WITH u AS (
+    UPDATE campaigns SET outbox='', updated_at=NOW()
+    WHERE id=$1 AND outbox='pending'
+    RETURNING id
+),
+d AS (
+    DELETE FROM campaign_outbox WHERE campaign_id = (SELECT id FROM u)
 )
-INSERT INTO campaign_views (campaign_id, subscriber_id)
-    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view));
+SELECT COUNT(*) FROM u;
+
+-- name: upsert-postmaster-stat
+-- Inserts or replaces a day's postmaster stats. Campaign identifiers (c{id})
+-- from the Feedback-ID header are mapped back to their campaigns.
+INSERT INTO postmaster_stats (provider, domain, date, identifier, campaign_id, spam_rate, domain_reputation,
+    ip_reputation, spf_success_rate, dkim_success_rate, dmarc_success_rate, delivery_error_rate, meta)
+    VALUES($1, $2, $3::DATE, $4::TEXT,
+        (SELECT id FROM campaigns WHERE id = SUBSTRING($4::TEXT FROM '^c([0-9]{1,9})$')::INT),
+        $5, $6, $7, $8, $9, $10, $11, $12)
+    ON CONFLICT (provider, domain, date, identifier) DO UPDATE SET
+        campaign_id=EXCLUDED.campaign_id,
+        spam_rate=EXCLUDED.spam_rate,
+        domain_reputation=EXCLUDED.domain_reputation,
+        ip_reputation=EXCLUDED.ip_reputation,
+        spf_success_rate=EXCLUDED.spf_success_rate,
+        dkim_success_rate=EXCLUDED.dkim_success_rate,
+        dmarc_success_rate=EXCLUDED.dmarc_success_rate,
+        delivery_error_rate=EXCLUDED.delivery_error_rate,
+        meta=EXCLUDED.meta,
+        updated_at=NOW();
+
+-- name: query-postmaster-stats
+-- Returns a page of imported postmaster stats, optionally filtered by the provider ($1),
+-- the domain ($2), the campaign ($3), and the date range ($4, $5).
+SELECT COUNT(*) OVER () AS total, p.id, p.provider, p.domain, p.date::TEXT AS date, p.identifier,
+    p.campaign_id, c.name AS campaign_name, p.spam_rate, p.domain_reputation, p.ip_reputation,
+    p.spf_success_rate, p.dkim_success_rate, p.dmarc_success_rate, p.delivery_error_rate, p.meta, p.updated_at
+FROM postmaster_stats p
+LEFT JOIN campaigns c ON (c.id = p.campaign_id)
+WHERE ($1 = '' OR p.provider = $1)
+    AND ($2 = '' OR p.domain = $2)
+    AND ($3 = 0 OR p.campaign_id = $3)
+    AND (NULLIF($4, '') IS NULL OR p.date >= NULLIF($4, '')::DATE)
+    AND (NULLIF($5, '') IS NULL OR p.date <= NULLIF($5, '')::DATE)
+ORDER BY p.date DESC, p.domain, p.identifier OFFSET $6 LIMIT (CASE WHEN $7 < 1 THEN NULL ELSE $7 END);
+
+-- name: get-campaign-variant-stats
+-- Views and clicks (unique subscribers) are attributed to variants by the subscriber's
+-- language, the same way variants are picked at send time: an exact match, then
+-- the base language (pt-BR => pt), else the default ('').
+WITH langs AS (
+    SELECT v->>'lang' AS lang FROM campaigns, JSONB_ARRAY_ELEMENTS(campaigns.variants) v WHERE campaigns.id = $1
+),
+subs AS (
+    SELECT s.id, COALESCE(
+        (SELECT lang FROM langs WHERE LOWER(langs.lang) = LOWER(s.lang) LIMIT 1),
+        (SELECT lang FROM langs WHERE LOWER(langs.lang) = LOWER(SPLIT_PART(REPLACE(s.lang, '_', '-'), '-', 1)) LIMIT 1),
+        ''
+    ) AS lang
+    FROM subscribers s WHERE s.id IN (
+        SELECT subscriber_id FROM campaign_views WHERE campaign_id = $1
+        UNION SELECT subscriber_id FROM link_clicks WHERE campaign_id = $1
+    )
+),
+views AS (
+    SELECT subs.lang, COUNT(DISTINCT v.subscriber_id) AS views FROM campaign_views v
+    JOIN subs ON subs.id = v.subscriber_id WHERE v.campaign_id = $1 GROUP BY subs.lang
+),
+clicks AS (
+    SELECT subs.lang, COUNT(DISTINCT c.subscriber_id) AS clicks FROM link_clicks c
+    JOIN subs ON subs.id = c.subscriber_id WHERE c.campaign_id = $1 GROUP BY subs.lang
+),
+all_langs AS (
+    SELECT '' AS lang UNION SELECT lang FROM langs
+)
+SELECT a.lang, COALESCE(st.sent, 0) AS sent, COALESCE(views.views, 0) AS views, COALESCE(clicks.clicks, 0) AS clicks
+    FROM all_langs a
+    LEFT JOIN campaign_variant_stats st ON st.campaign_id = $1 AND st.lang = a.lang
+    LEFT JOIN views ON views.lang = a.lang
+    LEFT JOIN clicks ON clicks.lang = a.lang
+    ORDER BY a.lang;
 
 -- templates
 -- name: get-templates
 -- Only if the second param ($2) is true, body is returned.
+-- $4 = optional folder ID. < 0 = templates that aren't in any folder.
 SELECT id, name, type, subject, (CASE WHEN $2 = false THEN body ELSE '' END) as body,
-    is_default, created_at, updated_at
-    FROM templates WHERE ($1 = 0 OR id = $1) AND ($3 = '' OR type = $3::template_type)
+    is_default, folder_id, version, created_at, updated_at
+    FROM templates WHERE deleted_at IS NULL AND ($1 = 0 OR id = $1) AND ($3 = '' OR type = $3::template_type)
+    AND (CASE WHEN $4 > 0 THEN folder_id = $4 WHEN $4 < 0 THEN folder_id IS NULL ELSE TRUE END)
     ORDER BY created_at;
 
 -- name: create-template
@@ -912,6 +2204,7 @@ UPDATE templates SET
     name=(CASE WHEN $2 != '' THEN $2 ELSE name END),
     subject=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     body=(CASE WHEN $4 != '' THEN $4 ELSE body END),
+    version=version + 1,
     updated_at=NOW()
 WHERE id = $1;
 
@@ -922,27 +2215,22 @@ WITH u AS (
 UPDATE templates SET is_default=false WHERE id != $1;
 
 -- name: delete-template
--- Delete a template as long as there's more than one. On deletion, set all campaigns
--- with that template to the default template instead.
-WITH tpl AS (
-    DELETE FROM templates WHERE id = $1 AND (SELECT COUNT(id) FROM templates) > 1 AND is_default = false RETURNING id
-),
-def AS (
-    SELECT id FROM templates WHERE is_default = true AND type='campaign' LIMIT 1
-),
-up AS (
-    UPDATE campaigns SET template_id = (SELECT id FROM def) WHERE (SELECT id FROM tpl) > 0 AND template_id = $1
-)
-SELECT id FROM tpl;
+-- Soft-delete (trash) a template as long as there's more than one. Campaigns keep
+-- the template so that it can be restored, and are moved to the default template
+-- when it's purged from the trash.
+UPDATE templates SET deleted_at=NOW()
+    WHERE id = $1 AND deleted_at IS NULL AND is_default = false
+        AND (SELECT COUNT(id) FROM templates WHERE deleted_at IS NULL) > 1
+    RETURNING id;
 
 
 -- media
 -- name: insert-media
-INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, created_at) VALUES($1, $2, $3, $4, $5, $6, NOW()) RETURNING id;
+INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, type, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, NOW()) RETURNING id;
 
 -- name: query-media
 SELECT COUNT(*) OVER () AS total, * FROM media
-    WHERE ($1 = '' OR filename ILIKE $1) AND provider=$2 ORDER BY created_at DESC OFFSET $3 LIMIT $4;
+    WHERE ($1 = '' OR filename ILIKE $1) AND provider=$2 AND type=$5 ORDER BY created_at DESC OFFSET $3 LIMIT $4;
 
 -- name: get-media
 SELECT * FROM media WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE uuid = $2 END;
@@ -950,21 +2238,69 @@ SELECT * FROM media WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE uuid = $2 END;
 -- name: delete-media
 DELETE FROM media WHERE id=$1 RETURNING filename;
 
+-- name: register-file-download
+-- Increments the download count of a file and returns it.
+UPDATE media SET downloads = downloads + 1 WHERE filename = $1 AND type = 'file' RETURNING *;
+
 -- links
 -- name: create-link
 INSERT INTO links (uuid, url) VALUES($1, $2) ON CONFLICT (url) DO UPDATE SET url=EXCLUDED.url RETURNING uuid;
 
--- name: register-link-click
-WITH link AS(
-    SELECT id, url FROM links WHERE uuid = $1
+-- name: get-link-url
+SELECT url FROM links WHERE uuid = $1;
+
+-- name: create-link-slug
+-- Returns the slug of a link in a campaign, inserting the given slug ($3) if the
+-- link doesn't have one. Nothing is returned if the slug is taken by another link.
+WITH link AS (
+    SELECT id FROM links WHERE uuid = $2
+),
+existing AS (
+    SELECT slug FROM link_slugs WHERE campaign_id = $1 AND link_id = (SELECT id FROM link)
+),
+ins AS (
+    INSERT INTO link_slugs (slug, campaign_id, link_id)
+        SELECT $3, $1, (SELECT id FROM link) WHERE NOT EXISTS (SELECT 1 FROM existing)
+        ON CONFLICT DO NOTHING
+        RETURNING slug
 )
-INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
-    (SELECT id FROM campaigns WHERE uuid = $2),
-    (SELECT id FROM subscribers WHERE
-        (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
-    ),
-    (SELECT id FROM link)
-) RETURNING (SELECT url FROM link);
+SELECT slug FROM existing UNION ALL SELECT slug FROM ins;
+
+-- name: get-link-slug
+-- Returns the link, campaign, and (optionally) the subscriber ($2) of a short link slug.
+SELECT links.uuid AS link_uuid, links.url, campaigns.uuid AS campaign_uuid,
+    COALESCE(subscribers.uuid::TEXT, '') AS subscriber_uuid
+    FROM link_slugs
+    JOIN links ON links.id = link_slugs.link_id
+    JOIN campaigns ON campaigns.id = link_slugs.campaign_id
+    LEFT JOIN subscribers ON subscribers.id = $2
+    WHERE link_slugs.slug = $1;
+
+-- name: register-link-clicks
+-- Bulk inserts link clicks buffered by the tracker. Clicks flagged as bots with a
+-- reason ($4) are recorded in link_clicks_bots instead of link_clicks.
+WITH c AS (
+    SELECT * FROM UNNEST($1::UUID[], $2::UUID[], $3::TEXT[], $4::TEXT[], $5::TIMESTAMP WITH TIME ZONE[])
+        AS c(link_uuid, campaign_uuid, subscriber_uuid, reason, created_at)
+),
+click AS (
+    SELECT campaigns.id AS campaign_id, COALESCE(subscribers.id, rot.subscriber_id) AS subscriber_id,
+        links.id AS link_id, c.reason, c.created_at FROM c
+    JOIN links ON links.uuid = c.link_uuid
+    LEFT JOIN campaigns ON campaigns.uuid = c.campaign_uuid
+    LEFT JOIN subscribers ON subscribers.uuid = NULLIF(c.subscriber_uuid, '')::UUID
+    -- Clicks that were buffered before the subscriber's UUID was rotated.
+    LEFT JOIN LATERAL (
+        SELECT subscriber_id FROM subscriber_uuid_rotations
+        WHERE old_uuid = NULLIF(c.subscriber_uuid, '')::UUID AND created_at >= c.created_at LIMIT 1
+    ) rot ON subscribers.id IS NULL
+),
+bot AS (
+    INSERT INTO link_clicks_bots (campaign_id, subscriber_id, link_id, reason, created_at)
+        SELECT campaign_id, subscriber_id, link_id, reason, created_at FROM click WHERE reason != ''
+)
+INSERT INTO link_clicks (campaign_id, subscriber_id, link_id, created_at)
+    SELECT campaign_id, subscriber_id, link_id, created_at FROM click WHERE reason = '';
 
 -- name: get-dashboard-charts
 SELECT data FROM mat_dashboard_charts;
@@ -975,11 +2311,32 @@ SELECT data FROM mat_dashboard_counts;
 -- name: get-settings
 SELECT JSON_OBJECT_AGG(key, value) AS settings FROM (SELECT * FROM settings ORDER BY key) t;
 
+-- name: init-signing-key
+-- Stores the given key as the public URL signing key if one isn't already set and
+-- returns the stored key.
+WITH ins AS (
+    INSERT INTO settings (key, value) VALUES ('security.signing_key', TO_JSONB($1::TEXT))
+    ON CONFLICT (key) DO NOTHING RETURNING value
+)
+SELECT value #>> '{}' FROM ins
+UNION ALL
+SELECT value #>> '{}' FROM settings WHERE key = 'security.signing_key'
+LIMIT 1;
+
 -- name: update-settings
 UPDATE settings AS s SET value = c.value
     -- For each key in the incoming JSON map, update the row with the key and its value.
     FROM(SELECT * FROM JSONB_EACH($1)) AS c(key, value) WHERE s.key = c.key;
 
+-- name: get-sending-halted
+-- The emergency stop that halts all sending. It isn't a part of the settings
+-- that are updated together as it's toggled instantly without a reload.
+SELECT COALESCE((SELECT value::BOOLEAN FROM settings WHERE key = 'app.sending_halted'), FALSE);
+
+-- name: set-sending-halted
+INSERT INTO settings (key, value, updated_at) VALUES ('app.sending_halted', TO_JSONB($1::BOOLEAN), NOW())
+    ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW();
+
 -- name: record-bounce
 -- Insert a bounce and count the bounces for the subscriber and either unsubscribe them,
 WITH sub AS (
@@ -1034,6 +2391,8 @@ WHERE ($1 = 0 OR bounces.id = $1)
     AND ($2 = 0 OR bounces.campaign_id = $2)
     AND ($3 = 0 OR bounces.subscriber_id = $3)
     AND ($4 = '' OR bounces.source = $4)
+    -- Optional cursor (keyset) pagination condition.
+    %cursor%
 ORDER BY %order% OFFSET $5 LIMIT $6;
 
 -- name: delete-bounces
@@ -1046,6 +2405,42 @@ WITH sub AS (
 DELETE FROM bounces WHERE subscriber_id = (SELECT id FROM sub);
 
 
+-- name: insert-campaign-reply
+-- Records a reply to a campaign message by the campaign and subscriber UUIDs
+-- in its Message-ID. Nothing is returned if either doesn't exist or if the
+-- reply was already recorded.
+WITH camp AS (
+    SELECT id, name FROM campaigns WHERE uuid = $1::UUID
+),
+sub AS (
+    SELECT id, email FROM subscribers WHERE uuid = $2::UUID
+),
+ins AS (
+    INSERT INTO campaign_replies (campaign_id, subscriber_id, message_id, from_email, subject, body)
+        SELECT camp.id, sub.id, $3, $4, $5, $6 FROM camp, sub
+    ON CONFLICT (message_id) DO NOTHING
+    RETURNING *
+)
+SELECT ins.id, ins.campaign_id, $1 AS campaign_uuid, camp.name AS campaign_name,
+    ins.subscriber_id, $2 AS subscriber_uuid, sub.email AS subscriber_email,
+    ins.message_id, ins.from_email, ins.subject, ins.body, ins.created_at
+FROM ins, camp, sub;
+
+-- name: query-campaign-replies
+SELECT COUNT(*) OVER () AS total,
+    r.id, r.campaign_id, c.uuid AS campaign_uuid, c.name AS campaign_name,
+    r.subscriber_id, s.uuid AS subscriber_uuid, s.email AS subscriber_email,
+    r.message_id, r.from_email, r.subject, r.body, r.created_at
+FROM campaign_replies r
+JOIN campaigns c ON (c.id = r.campaign_id)
+JOIN subscribers s ON (s.id = r.subscriber_id)
+WHERE ($1 = 0 OR r.campaign_id = $1)
+    AND ($2 = 0 OR r.subscriber_id = $2)
+ORDER BY r.id DESC OFFSET $3 LIMIT $4;
+
+-- name: delete-campaign-replies
+DELETE FROM campaign_replies WHERE campaign_id = $1 AND (CARDINALITY($2::BIGINT[]) = 0 OR id = ANY($2));
+
 -- name: get-db-info
 SELECT JSON_BUILD_OBJECT('version', (SELECT VERSION()),
                         'size_mb', (SELECT ROUND(pg_database_size((SELECT CURRENT_DATABASE()))/(1024^2)))) AS info;
@@ -1251,3 +2646,486 @@ UPDATE roles SET name=$2, permissions=$3 WHERE id=$1 and parent_id IS NULL RETUR
 
 -- name: delete-role
 DELETE FROM roles WHERE id=$1;
+
+-- folders
+-- name: get-folders
+-- $1 = optional folder ID, $2 = include archived folders.
+SELECT f.*,
+    (SELECT COUNT(*) FROM campaigns WHERE folder_id = f.id) AS campaign_count,
+    (SELECT COUNT(*) FROM templates WHERE folder_id = f.id) AS template_count
+    FROM folders f
+    WHERE ($1 = 0 OR f.id = $1) AND ($1 > 0 OR $2 = TRUE OR f.archived = FALSE)
+    ORDER BY f.name;
+
+-- name: create-folder
+INSERT INTO folders (name, description) VALUES($1, $2) RETURNING id;
+
+-- name: update-folder
+UPDATE folders SET name=$2, description=$3, archived=$4, updated_at=NOW() WHERE id=$1;
+
+-- name: delete-folder
+-- Campaigns and templates in the folder are moved out of it (ON DELETE SET NULL).
+DELETE FROM folders WHERE id=$1;
+
+-- name: move-to-folder
+-- Moves campaigns ($2) and templates ($3) into a folder ($1). A NULL folder moves them out of folders.
+WITH camps AS (
+    UPDATE campaigns SET folder_id=$1 WHERE id = ANY($2::INT[]) RETURNING id
+),
+tpls AS (
+    UPDATE templates SET folder_id=$1 WHERE id = ANY($3::INT[]) RETURNING id
+)
+SELECT (SELECT COUNT(*) FROM camps) + (SELECT COUNT(*) FROM tpls);
+
+-- trash
+-- name: get-trash
+-- Returns soft-deleted (trashed) items of the given types ($1).
+SELECT * FROM (
+    SELECT 'campaign' AS type, id, name, deleted_at FROM campaigns WHERE deleted_at IS NOT NULL AND 'campaign' = ANY($1::TEXT[])
+    UNION ALL
+    SELECT 'list' AS type, id, name, deleted_at FROM lists WHERE deleted_at IS NOT NULL AND 'list' = ANY($1::TEXT[])
+    UNION ALL
+    SELECT 'template' AS type, id, name, deleted_at FROM templates WHERE deleted_at IS NOT NULL AND 'template' = ANY($1::TEXT[])
+) t ORDER BY deleted_at DESC;
+
+-- name: restore-trash
+-- Restores trashed items of a given type ($1) by IDs ($2).
+WITH camps AS (
+    UPDATE campaigns SET deleted_at=NULL, updated_at=NOW()
+    WHERE $1 = 'campaign' AND id = ANY($2::INT[]) AND deleted_at IS NOT NULL RETURNING id
+),
+lsts AS (
+    UPDATE lists SET deleted_at=NULL, updated_at=NOW()
+    WHERE $1 = 'list' AND id = ANY($2::INT[]) AND deleted_at IS NOT NULL RETURNING id
+),
+tpls AS (
+    UPDATE templates SET deleted_at=NULL, updated_at=NOW()
+    WHERE $1 = 'template' AND id = ANY($2::INT[]) AND deleted_at IS NOT NULL RETURNING id
+)
+SELECT (SELECT COUNT(*) FROM camps) + (SELECT COUNT(*) FROM lsts) + (SELECT COUNT(*) FROM tpls);
+
+-- name: purge-trash
+-- Permanently deletes trashed items of the given types ($1). If IDs ($2) are given,
+-- only those items are deleted, otherwise, all items that were trashed before $3.
+WITH camps AS (
+    DELETE FROM campaigns WHERE deleted_at IS NOT NULL AND 'campaign' = ANY($1::TEXT[])
+    AND (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id = ANY($2::INT[]) ELSE deleted_at < $3::TIMESTAMP WITH TIME ZONE END)
+    RETURNING id, body_ref
+),
+lsts AS (
+    DELETE FROM lists WHERE deleted_at IS NOT NULL AND 'list' = ANY($1::TEXT[])
+    AND (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id = ANY($2::INT[]) ELSE deleted_at < $3::TIMESTAMP WITH TIME ZONE END)
+    RETURNING id
+),
+tpls AS (
+    DELETE FROM templates WHERE deleted_at IS NOT NULL AND 'template' = ANY($1::TEXT[])
+    AND (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id = ANY($2::INT[]) ELSE deleted_at < $3::TIMESTAMP WITH TIME ZONE END)
+    RETURNING id
+),
+def AS (
+    SELECT id FROM templates WHERE is_default = true AND type='campaign' LIMIT 1
+),
+-- Campaigns (that aren't being purged) that use the purged templates are moved to the default template.
+tplCamps AS (
+    UPDATE campaigns SET
+        template_id = (CASE WHEN template_id IN (SELECT id FROM tpls) THEN (SELECT id FROM def) ELSE template_id END),
+        archive_template_id = (CASE WHEN archive_template_id IN (SELECT id FROM tpls) THEN (SELECT id FROM def) ELSE archive_template_id END)
+    WHERE (template_id IN (SELECT id FROM tpls) OR archive_template_id IN (SELECT id FROM tpls))
+        AND id NOT IN (SELECT id FROM camps)
+)
+SELECT (SELECT COUNT(*) FROM camps) + (SELECT COUNT(*) FROM lsts) + (SELECT COUNT(*) FROM tpls) AS total,
+    -- Media store objects of the deleted campaigns' bodies that no other campaign uses.
+    ARRAY(
+        SELECT DISTINCT body_ref FROM camps WHERE body_ref != ''
+        AND body_ref NOT IN (SELECT body_ref FROM campaigns WHERE id NOT IN (SELECT id FROM camps))
+    ) AS body_refs;
+
+-- event log
+-- name: insert-event-log
+INSERT INTO event_log (type, message, meta, user_id) VALUES($1, $2, $3, NULLIF($4, 0));
+
+-- name: query-event-log
+-- Returns event log entries of the given types ($1, all if empty) older than
+-- the cursor ID ($2, 0 for the latest), newest first.
+SELECT e.id, e.type, e.message, e.meta, e.user_id, COALESCE(u.username, '') AS username, e.created_at
+    FROM event_log e
+    LEFT JOIN users u ON (u.id = e.user_id)
+    WHERE (CARDINALITY($1::TEXT[]) = 0 OR e.type = ANY($1::TEXT[]))
+    AND ($2 = 0 OR e.id < $2)
+    ORDER BY e.id DESC LIMIT $3;
+
+-- tags
+-- name: get-tags
+-- Returns all tags along with their usage counts across campaigns, lists, and subscribers.
+-- $1 = types to count tags in.
+WITH t AS (
+    SELECT UNNEST(tags) AS tag, 'campaign' AS type FROM campaigns WHERE 'campaign' = ANY($1::TEXT[])
+    UNION ALL
+    SELECT UNNEST(tags) AS tag, 'list' AS type FROM lists WHERE 'list' = ANY($1::TEXT[])
+    UNION ALL
+    SELECT UNNEST(tags) AS tag, 'subscriber' AS type FROM subscribers WHERE 'subscriber' = ANY($1::TEXT[])
+)
+SELECT tag,
+    COUNT(*) FILTER (WHERE type = 'campaign') AS campaigns,
+    COUNT(*) FILTER (WHERE type = 'list') AS lists,
+    COUNT(*) FILTER (WHERE type = 'subscriber') AS subscribers,
+    COUNT(*) AS total
+FROM t GROUP BY tag ORDER BY tag;
+
+-- name: merge-tags
+-- Replaces all occurrences of the tags $1 with the tag $2 across campaigns, lists, and
+-- subscribers ($3 = types), removing duplicates and retaining the order of tags.
+-- This is used to rename and merge tags. If $2 is empty, the tags are deleted.
+WITH camps AS (
+    UPDATE campaigns SET tags = ARRAY(
+        SELECT t FROM (
+            SELECT (CASE WHEN t = ANY($1::VARCHAR(100)[]) THEN $2 ELSE t END) AS t, MIN(n) AS n
+            FROM UNNEST(tags) WITH ORDINALITY AS x(t, n) GROUP BY 1
+        ) x WHERE t != '' ORDER BY n
+    )
+    WHERE 'campaign' = ANY($3::TEXT[]) AND tags && $1::VARCHAR(100)[]
+    RETURNING id
+),
+lsts AS (
+    UPDATE lists SET tags = ARRAY(
+        SELECT t FROM (
+            SELECT (CASE WHEN t = ANY($1::VARCHAR(100)[]) THEN $2 ELSE t END) AS t, MIN(n) AS n
+            FROM UNNEST(tags) WITH ORDINALITY AS x(t, n) GROUP BY 1
+        ) x WHERE t != '' ORDER BY n
+    )
+    WHERE 'list' = ANY($3::TEXT[]) AND tags && $1::VARCHAR(100)[]
+    RETURNING id
+),
+subs AS (
+    UPDATE subscribers SET tags = ARRAY(
+        SELECT t FROM (
+            SELECT (CASE WHEN t = ANY($1::VARCHAR(100)[]) THEN $2 ELSE t END) AS t, MIN(n) AS n
+            FROM UNNEST(tags) WITH ORDINALITY AS x(t, n) GROUP BY 1
+        ) x WHERE t != '' ORDER BY n
+    )
+    WHERE 'subscriber' = ANY($3::TEXT[]) AND tags && $1::VARCHAR(100)[]
+    RETURNING id
+)
+SELECT (SELECT COUNT(*) FROM camps) AS campaigns,
+    (SELECT COUNT(*) FROM lsts) AS lists,
+    (SELECT COUNT(*) FROM subs) AS subscribers;
+
+-- search
+-- name: search
+-- Ranked full-text search across campaigns, templates, lists, and subscribers.
+-- Each object type is searched (and limited) independently using the GIN expression
+-- indexes and the results are merged and sorted by rank.
+-- $1 = tsquery, $2 = types to search, $3 = max results per type,
+-- $4 = search all lists?, $5 = permitted list IDs, $6 = search all subscribers?,
+-- $7 = search all campaigns?, $8 = list IDs of permitted campaigns.
+WITH q AS (
+    SELECT TO_TSQUERY('simple', $1) AS q
+),
+camps AS (
+    SELECT 'campaign'::TEXT AS type, c.id, c.uuid::TEXT AS uuid, c.name, c.subject AS description,
+        TS_RANK(SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D'), q.q) AS rank
+    FROM campaigns c, q
+    WHERE 'campaign' = ANY($2::TEXT[]) AND c.deleted_at IS NULL
+        AND (SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D')) @@ q.q
+        AND ($7 = TRUE OR NOT EXISTS (
+            SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id AND cl.list_id IS NOT NULL AND cl.list_id != ALL($8::INT[])
+            UNION ALL
+            SELECT 1 FROM lists l WHERE l.group_id = ANY(c.list_group_ids) AND l.deleted_at IS NULL AND l.id != ALL($8::INT[])
+        ))
+    ORDER BY rank DESC LIMIT $3
+),
+tpls AS (
+    SELECT 'template'::TEXT AS type, t.id, ''::TEXT AS uuid, t.name, t.subject AS description,
+        TS_RANK(SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B'), q.q) AS rank
+    FROM templates t, q
+    WHERE 'template' = ANY($2::TEXT[]) AND t.deleted_at IS NULL
+        AND (SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B')) @@ q.q
+    ORDER BY rank DESC LIMIT $3
+),
+lsts AS (
+    SELECT 'list'::TEXT AS type, l.id, l.uuid::TEXT AS uuid, l.name, l.description,
+        TS_RANK(TO_TSVECTOR('simple', name || ' ' || description), q.q) AS rank
+    FROM lists l, q
+    WHERE 'list' = ANY($2::TEXT[]) AND l.deleted_at IS NULL
+        AND TO_TSVECTOR('simple', name || ' ' || description) @@ q.q
+        AND CASE
+            -- Optional list IDs based on user permission.
+            WHEN $4 = TRUE THEN TRUE ELSE l.id = ANY($5::INT[])
+        END
+    ORDER BY rank DESC LIMIT $3
+),
+subs AS (
+    SELECT 'subscriber'::TEXT AS type, s.id, s.uuid::TEXT AS uuid, s.email AS name, s.name AS description,
+        TS_RANK(TO_TSVECTOR('simple', email || ' ' || name) || JSONB_TO_TSVECTOR('simple', attribs, '["string", "numeric"]'), q.q) AS rank
+    FROM subscribers s, q
+    WHERE 'subscriber' = ANY($2::TEXT[])
+        AND (TO_TSVECTOR('simple', email || ' ' || name) || JSONB_TO_TSVECTOR('simple', attribs, '["string", "numeric"]')) @@ q.q
+        AND CASE
+            -- Users without subscribers:get_all only see subscribers on lists they have access to.
+            WHEN $6 = TRUE THEN TRUE
+            ELSE EXISTS (SELECT 1 FROM subscriber_lists sl WHERE sl.subscriber_id = s.id AND sl.list_id = ANY($5::INT[]))
+        END
+    ORDER BY rank DESC LIMIT $3
+)
+SELECT * FROM camps
+UNION ALL SELECT * FROM tpls
+UNION ALL SELECT * FROM lsts
+UNION ALL SELECT * FROM subs
+ORDER BY rank DESC;
+
+-- suppression syncs
+-- name: get-suppression-diff
+-- Diffs the e-mails of an external suppression source ($1) against the local blocklist.
+-- added = e-mails that aren't blocklisted yet, removed = e-mails blocklisted by earlier syncs
+-- of the source (subscriber source $2) that are no longer in the source.
+WITH src AS (
+    SELECT DISTINCT LOWER(TRIM(e)) AS email FROM UNNEST($1::TEXT[]) e
+)
+SELECT
+    (SELECT COUNT(*) FROM src) AS total,
+    COALESCE((SELECT ARRAY_AGG(src.email ORDER BY src.email) FROM src
+        WHERE NOT EXISTS (SELECT 1 FROM subscribers s WHERE LOWER(s.email) = src.email AND s.status = 'blocklisted')), '{}') AS added,
+    COALESCE((SELECT ARRAY_AGG(s.email ORDER BY s.email) FROM subscribers s
+        WHERE s.source = $2 AND s.status = 'blocklisted'
+        AND NOT EXISTS (SELECT 1 FROM src WHERE src.email = LOWER(s.email))), '{}') AS removed;
+
+-- name: insert-suppression-sync
+INSERT INTO suppression_syncs (source_uuid, source_name, status, total, added, removed, error)
+    VALUES($1, $2, $3::suppression_sync_status, $4, $5, $6, $7) RETURNING id;
+
+-- name: get-suppression-syncs
+-- Returns the latest syncs without the (large) e-mail lists. $1 = optional sync ID.
+SELECT id, source_uuid, source_name, status, total, CARDINALITY(added) AS num_added,
+    CARDINALITY(removed) AS num_removed,
+    (CASE WHEN $1 > 0 THEN added ELSE '{}' END) AS added,
+    (CASE WHEN $1 > 0 THEN removed ELSE '{}' END) AS removed,
+    error, created_at, applied_at
+    FROM suppression_syncs WHERE ($1 = 0 OR id = $1)
+    ORDER BY created_at DESC LIMIT (CASE WHEN $1 > 0 THEN 1 ELSE 100 END);
+
+-- name: get-suppression-last-syncs
+-- Returns the time of the last sync of every source.
+SELECT DISTINCT ON (source_uuid) source_uuid, created_at FROM suppression_syncs
+    ORDER BY source_uuid, created_at DESC;
+
+-- name: update-suppression-sync-status
+-- Only pending syncs can be applied or rejected.
+UPDATE suppression_syncs SET status=$2::suppression_sync_status,
+    applied_at=(CASE WHEN $2 = 'applied' THEN NOW() ELSE NULL END)
+    WHERE id = $1 AND status = 'pending';
+
+-- name: blocklist-emails
+-- Blocklists the given e-mails ($2) with the UUIDs ($1) for ones that aren't subscribers yet,
+-- and unsubscribes them from all lists. $3 = subscriber source.
+WITH sub AS (
+    INSERT INTO subscribers (uuid, email, name, attribs, status, source)
+        SELECT u, e, SPLIT_PART(e, '@', 1), '{}', 'blocklisted', $3
+        FROM UNNEST($1::UUID[], $2::TEXT[]) AS t(u, e)
+    ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
+    RETURNING id
+)
+UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+    WHERE subscriber_id = ANY(SELECT id FROM sub);
+
+-- lead forms
+-- name: get-lead-forms
+-- Returns all lead forms or the one with the given ID ($1) with their capture counts.
+SELECT f.*, (SELECT COUNT(*) FROM lead_captures WHERE form_id = f.id) AS captures
+    FROM lead_forms f WHERE ($1 = 0 OR f.id = $1) ORDER BY f.name;
+
+-- name: get-lead-form-by-key
+SELECT * FROM lead_forms WHERE api_key = $1;
+
+-- name: create-lead-form
+INSERT INTO lead_forms (uuid, name, api_key, enabled, list_ids, tags, tx_template_id, rate_limit, redirect_url)
+    VALUES($1, $2, $3, $4, $5, $6, (SELECT id FROM templates WHERE id = $7 AND type = 'tx'), $8, $9)
+    RETURNING id;
+
+-- name: update-lead-form
+UPDATE lead_forms SET name=$2, enabled=$3, list_ids=$4, tags=$5,
+    tx_template_id=(SELECT id FROM templates WHERE id = $6 AND type = 'tx'),
+    rate_limit=$7, redirect_url=$8, updated_at=NOW()
+    WHERE id=$1;
+
+-- name: update-lead-form-key
+UPDATE lead_forms SET api_key=$2, updated_at=NOW() WHERE id=$1;
+
+-- name: delete-lead-form
+DELETE FROM lead_forms WHERE id=$1;
+
+-- name: upsert-lead
+-- Creates or updates the subscriber of a lead captured by a lead form ($1), adds the
+-- form's tags, subscribes them to the form's lists, and records the capture with its
+-- UTM data ($7) and referrer ($8). New subscribers get the name ($4), language ($6),
+-- and the attributes ($5) with the first-touch UTM data. Existing subscribers only get
+-- the attributes they don't have, as anyone can submit a form with their e-mail, and
+-- their subscriptions are left as they are.
+WITH f AS (
+    SELECT id, list_ids, tags FROM lead_forms WHERE id = $1
+),
+sub AS (
+    INSERT INTO subscribers AS s (uuid, email, name, attribs, status, tags, lang, source)
+        VALUES($2, $3, $4,
+            (CASE WHEN $7::JSONB = '{}' THEN $5::JSONB ELSE $5::JSONB || JSONB_BUILD_OBJECT('utm', $7::JSONB) END),
+            'enabled', (SELECT tags FROM f), $6, $9)
+    ON CONFLICT (email) DO UPDATE SET
+        attribs=$5::JSONB || s.attribs,
+        tags=s.tags || ARRAY(SELECT t FROM UNNEST(EXCLUDED.tags) t WHERE NOT t = ANY(s.tags)),
+        updated_at=NOW()
+    RETURNING id, status, (xmax = 0) AS created
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, source)
+        SELECT sub.id, l.id,
+            (CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed' ELSE 'unconfirmed' END)::subscription_status, $9
+        FROM sub, lists l WHERE l.id = ANY((SELECT list_ids FROM f)) AND l.deleted_at IS NULL
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+),
+cap AS (
+    INSERT INTO lead_captures (form_id, subscriber_id, utm, referrer)
+        SELECT $1, id, $7, $8 FROM sub
+)
+SELECT id, created FROM sub;
+
+-- event rules
+-- name: get-event-rules
+-- Returns all event rules or the one with the given ID ($1) with their trigger counts.
+SELECT r.*, (SELECT COUNT(*) FROM event_triggers WHERE rule_id = r.id) AS triggers
+    FROM event_rules r WHERE ($1 = 0 OR r.id = $1) ORDER BY r.event, r.name;
+
+-- name: create-event-rule
+INSERT INTO event_rules (name, event, enabled, tx_template_id, list_ids, tags, dedup_minutes)
+    VALUES($1, $2, $3, (SELECT id FROM templates WHERE id = $4 AND type = 'tx'), $5, $6, $7)
+    RETURNING id;
+
+-- name: update-event-rule
+UPDATE event_rules SET name=$2, event=$3, enabled=$4,
+    tx_template_id=(SELECT id FROM templates WHERE id = $5 AND type = 'tx'),
+    list_ids=$6, tags=$7, dedup_minutes=$8, updated_at=NOW()
+    WHERE id=$1;
+
+-- name: delete-event-rule
+DELETE FROM event_rules WHERE id=$1;
+
+-- name: trigger-event-rules
+-- Triggers the enabled rules of an event ($1) for a subscriber ($2), skipping the rules
+-- that were already triggered for the subscriber within their de-duplication windows.
+-- The triggers are recorded with the event's payload ($3), the rules' tags are added to
+-- the subscriber, and the triggered rules are returned for the other actions.
+WITH r AS (
+    SELECT * FROM event_rules WHERE event = $1 AND enabled
+        AND (dedup_minutes = 0 OR NOT EXISTS (
+            SELECT 1 FROM event_triggers t WHERE t.rule_id = event_rules.id AND t.subscriber_id = $2
+                AND t.created_at > NOW() - MAKE_INTERVAL(mins => event_rules.dedup_minutes)
+        ))
+),
+ins AS (
+    INSERT INTO event_triggers (rule_id, subscriber_id, event, payload)
+        SELECT id, $2, $1, $3 FROM r
+),
+tg AS (
+    UPDATE subscribers SET tags = tags || ARRAY(
+        SELECT DISTINCT t FROM r, UNNEST(r.tags) t WHERE NOT t = ANY(subscribers.tags)
+    ), updated_at=NOW()
+    WHERE id = $2 AND EXISTS (SELECT 1 FROM r WHERE CARDINALITY(r.tags) > 0)
+)
+SELECT r.*, 0 AS triggers FROM r ORDER BY id;
+
+-- name: get-reports
+-- Returns reports ($1 = 0 for all) with the status of their latest results.
+SELECT r.*, COALESCE(u.name, '') AS user_name, res.status AS last_status
+FROM reports r
+LEFT JOIN users u ON (u.id = r.created_by)
+LEFT JOIN LATERAL (
+    SELECT status FROM report_results WHERE report_id = r.id ORDER BY id DESC LIMIT 1
+) res ON TRUE
+WHERE $1 = 0 OR r.id = $1
+ORDER BY r.name;
+
+-- name: create-report
+INSERT INTO reports (name, description, query, schedule, created_by)
+    VALUES($1, $2, $3, $4, NULLIF($5, 0))
+    RETURNING id;
+
+-- name: update-report
+UPDATE reports SET name=$2, description=$3, query=$4, schedule=$5, updated_at=NOW() WHERE id = $1;
+
+-- name: delete-report
+DELETE FROM reports WHERE id = $1;
+
+-- name: insert-report-result
+-- Records a result of a report ($1) and prunes its older results, keeping the latest $11.
+WITH r AS (
+    UPDATE reports SET last_run_at=NOW() WHERE id = $1 RETURNING id
+),
+del AS (
+    DELETE FROM report_results WHERE report_id = $1 AND id NOT IN (
+        SELECT id FROM report_results WHERE report_id = $1 ORDER BY id DESC LIMIT GREATEST($11::INT - 1, 0)
+    )
+)
+INSERT INTO report_results (report_id, status, scheduled, columns, data, num_rows, truncated, error, duration_ms, created_by)
+    SELECT id, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0) FROM r
+    RETURNING id;
+
+-- name: get-report-results
+-- Returns the results of a report without their data.
+SELECT id, report_id, status, scheduled, columns, num_rows, truncated, error, duration_ms, created_by, created_at
+    FROM report_results WHERE report_id = $1 ORDER BY id DESC;
+
+-- name: get-report-result
+-- Returns a result of a report ($1) with its data. $2 = 0 returns the latest result.
+SELECT * FROM report_results WHERE report_id = $1 AND ($2 = 0 OR id = $2) ORDER BY id DESC LIMIT 1;
+
+-- name: get-warehouse-exports
+SELECT * FROM warehouse_exports ORDER BY name;
+
+-- name: update-warehouse-cursor
+-- Advances the cursor of a table's export ($1) after a file of $4 rows has been exported.
+INSERT INTO warehouse_exports (name, cursor_at, cursor_id, exported, files)
+    VALUES($1, $2, $3, $4, 1)
+    ON CONFLICT (name) DO UPDATE SET cursor_at=$2, cursor_id=$3,
+        exported=warehouse_exports.exported + $4, files=warehouse_exports.files + 1, updated_at=NOW();
+
+-- name: update-warehouse-run
+INSERT INTO warehouse_exports (name, last_run_at, last_status, last_error)
+    VALUES($1, NOW(), $2, $3)
+    ON CONFLICT (name) DO UPDATE SET last_run_at=NOW(), last_status=$2, last_error=$3, updated_at=NOW();
+
+-- name: reset-warehouse-export
+-- Resets the cursor of a table's export so that it's exported in full again.
+DELETE FROM warehouse_exports WHERE name = $1;
+
+-- name: get-warehouse-subscribers
+-- Returns the next $3 subscribers updated after the ($1 updated_at, $2 id) cursor for exporting
+-- to the data warehouse. Rows updated in the last minute are left for the next run so that
+-- those in transactions that haven't committed yet aren't skipped. The columns should be in the
+-- order of warehouse.Tables with the id first and the cursor timestamp last.
+SELECT id, uuid::TEXT, email, name, attribs::TEXT, status::TEXT, TO_JSON(tags)::TEXT AS tags, lang, source,
+    created_at, updated_at
+    FROM subscribers
+    WHERE (updated_at, id) > ($1, $2) AND updated_at < NOW() - INTERVAL '1 minute'
+    ORDER BY updated_at, id LIMIT $3;
+
+-- name: get-warehouse-deliveries
+-- Returns the delivery progress of the next $3 campaigns updated after the ($1 updated_at, $2 id) cursor.
+SELECT c.id, c.uuid::TEXT, c.name, c.subject, c.type::TEXT, c.status::TEXT, c.messenger,
+    COALESCE((SELECT JSON_AGG(list_id ORDER BY list_id) FROM campaign_lists WHERE campaign_id = c.id), '[]')::TEXT AS lists,
+    c.to_send, c.sent, c.started_at, c.created_at, c.updated_at
+    FROM campaigns c
+    WHERE (c.updated_at, c.id) > ($1, $2) AND c.updated_at < NOW() - INTERVAL '1 minute'
+    ORDER BY c.updated_at, c.id LIMIT $3;
+
+-- name: get-warehouse-views
+-- Returns the next $2 campaign views after the id $1. Views are never updated.
+SELECT id, campaign_id, subscriber_id, proxy, created_at
+    FROM campaign_views
+    WHERE id > $1 AND created_at < NOW() - INTERVAL '1 minute'
+    ORDER BY id LIMIT $2;
+
+-- name: get-warehouse-clicks
+-- Returns the next $2 link clicks after the id $1. Clicks are never updated.
+SELECT c.id, c.campaign_id, c.link_id, l.url, c.subscriber_id, c.created_at
+    FROM link_clicks c
+    JOIN links l ON (l.id = c.link_id)
+    WHERE c.id > $1 AND c.created_at < NOW() - INTERVAL '1 minute'
+    ORDER BY c.id LIMIT $2;
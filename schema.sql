@@ -8,11 +8,28 @@ DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('ric
 DROP TYPE IF EXISTS bounce_type CASCADE; CREATE TYPE bounce_type AS ENUM ('soft', 'hard', 'complaint');
 DROP TYPE IF EXISTS template_type CASCADE; CREATE TYPE template_type AS ENUM ('campaign', 'tx');
 DROP TYPE IF EXISTS user_type CASCADE; CREATE TYPE user_type AS ENUM ('user', 'api');
+DROP TYPE IF EXISTS media_type CASCADE; CREATE TYPE media_type AS ENUM ('media', 'file');
 DROP TYPE IF EXISTS user_status CASCADE; CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
 DROP TYPE IF EXISTS role_type CASCADE; CREATE TYPE role_type AS ENUM ('user', 'list');
 
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
+-- Recursively merges JSONB object b into a. Values in b win on conflicts except
+-- when both values are objects, in which case they are merged.
+CREATE OR REPLACE FUNCTION JSONB_DEEP_MERGE(a JSONB, b JSONB) RETURNS JSONB AS $$
+BEGIN
+    IF a IS NULL OR b IS NULL OR JSONB_TYPEOF(a) != 'object' OR JSONB_TYPEOF(b) != 'object' THEN
+        RETURN COALESCE(b, a);
+    END IF;
+
+    RETURN COALESCE((
+        SELECT JSONB_OBJECT_AGG(COALESCE(ka, kb),
+            CASE WHEN va IS NULL THEN vb WHEN vb IS NULL THEN va ELSE JSONB_DEEP_MERGE(va, vb) END)
+        FROM JSONB_EACH(a) e1(ka, va) FULL JOIN JSONB_EACH(b) e2(kb, vb) ON ka = kb
+    ), '{}');
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
 -- subscribers
 DROP TABLE IF EXISTS subscribers CASCADE;
 CREATE TABLE subscribers (
@@ -22,6 +39,17 @@ CREATE TABLE subscribers (
     name            TEXT NOT NULL,
     attribs         JSONB NOT NULL DEFAULT '{}',
     status          subscriber_status NOT NULL DEFAULT 'enabled',
+    tags            VARCHAR(100)[] NOT NULL DEFAULT '{}',
+    lang            TEXT NOT NULL DEFAULT '',
+
+    -- Provenance of the subscriber, eg: import:12, api:apiuser, admin:john, form.
+    source          TEXT NOT NULL DEFAULT '',
+
+    -- Code in the subscriber's referral link that attributes new signups to them.
+    referral_code   TEXT NOT NULL UNIQUE DEFAULT SUBSTR(MD5(RANDOM()::TEXT || CLOCK_TIMESTAMP()::TEXT), 1, 12),
+
+    -- PEM S/MIME certificate or armored OpenPGP public key that e-mails to the subscriber are encrypted with.
+    public_key      TEXT NOT NULL DEFAULT '',
 
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
@@ -31,6 +59,27 @@ DROP INDEX IF EXISTS idx_subs_status; CREATE INDEX idx_subs_status ON subscriber
 DROP INDEX IF EXISTS idx_subs_id_status; CREATE INDEX idx_subs_id_status ON subscribers(id, status);
 DROP INDEX IF EXISTS idx_subs_created_at; CREATE INDEX idx_subs_created_at ON subscribers(created_at);
 DROP INDEX IF EXISTS idx_subs_updated_at; CREATE INDEX idx_subs_updated_at ON subscribers(updated_at);
+DROP INDEX IF EXISTS idx_subs_tags; CREATE INDEX idx_subs_tags ON subscribers USING GIN (tags);
+DROP INDEX IF EXISTS idx_subs_search; CREATE INDEX idx_subs_search ON subscribers USING GIN ((TO_TSVECTOR('simple', email || ' ' || name) || JSONB_TO_TSVECTOR('simple', attribs, '["string", "numeric"]')));
+
+-- subscribers who signed up via another subscriber's referral link.
+DROP TABLE IF EXISTS subscriber_referrals CASCADE;
+CREATE TABLE subscriber_referrals (
+    subscriber_id   INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    referrer_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_referrals_referrer; CREATE INDEX idx_sub_referrals_referrer ON subscriber_referrals(referrer_id);
+
+-- list groups
+DROP TABLE IF EXISTS list_groups CASCADE;
+CREATE TABLE list_groups (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
 
 -- lists
 DROP TABLE IF EXISTS lists CASCADE;
@@ -42,15 +91,49 @@ CREATE TABLE lists (
     optin           list_optin NOT NULL DEFAULT 'single',
     tags            VARCHAR(100)[],
     description     TEXT NOT NULL DEFAULT '',
+    group_id        INTEGER NULL REFERENCES list_groups(id) ON DELETE SET NULL,
+
+    -- Public landing page metadata.
+    logo_url        TEXT NOT NULL DEFAULT '',
+    lang            TEXT NOT NULL DEFAULT '',
+
+    -- Paid lists require an active Stripe subscription to this price.
+    stripe_price_id TEXT NOT NULL DEFAULT '',
+
+    -- How double opt-in subscriptions are confirmed: link (e-mailed link), code (e-mailed code), sms (code via the SMS messenger).
+    optin_method    TEXT NOT NULL DEFAULT 'link',
+
+    -- Custom hostname on which the list's public page, form, and archive are served.
+    domain          TEXT NOT NULL DEFAULT '',
+
+    -- Compliance profile (can-spam, casl, gdpr) whose requirements the list's campaigns have to meet.
+    compliance_profile TEXT NOT NULL DEFAULT '',
+
+    -- Sender profile (company_name, address, links) that overrides the instance's in the list's campaigns.
+    sender_profile  JSONB NOT NULL DEFAULT '{}',
+
+    -- Incremented on every edit for detecting concurrent edits.
+    version         INTEGER NOT NULL DEFAULT 1,
+
+    -- Dynamic lists have a structured subscriber filter whose matching subscribers
+    -- are the list's members. Membership is recomputed when the list is used.
+    rules           JSONB NULL,
 
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    -- Soft-deleted (trashed) lists. These are purged after the trash retention period.
+    deleted_at      TIMESTAMP WITH TIME ZONE NULL
 );
 DROP INDEX IF EXISTS idx_lists_type; CREATE INDEX idx_lists_type ON lists(type);
 DROP INDEX IF EXISTS idx_lists_optin; CREATE INDEX idx_lists_optin ON lists(optin);
 DROP INDEX IF EXISTS idx_lists_name; CREATE INDEX idx_lists_name ON lists(name);
 DROP INDEX IF EXISTS idx_lists_created_at; CREATE INDEX idx_lists_created_at ON lists(created_at);
 DROP INDEX IF EXISTS idx_lists_updated_at; CREATE INDEX idx_lists_updated_at ON lists(updated_at);
+DROP INDEX IF EXISTS idx_lists_group_id; CREATE INDEX idx_lists_group_id ON lists(group_id);
+DROP INDEX IF EXISTS idx_lists_deleted_at; CREATE INDEX idx_lists_deleted_at ON lists(deleted_at) WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_lists_domain; CREATE UNIQUE INDEX idx_lists_domain ON lists(domain) WHERE domain != '' AND deleted_at IS NULL;
+DROP INDEX IF EXISTS idx_lists_search; CREATE INDEX idx_lists_search ON lists USING GIN (TO_TSVECTOR('simple', name || ' ' || description));
 
 
 DROP TABLE IF EXISTS subscriber_lists CASCADE;
@@ -60,6 +143,9 @@ CREATE TABLE subscriber_lists (
     meta               JSONB NOT NULL DEFAULT '{}',
     status             subscription_status NOT NULL DEFAULT 'unconfirmed',
 
+    -- Provenance of the subscription.
+    source             TEXT NOT NULL DEFAULT '',
+
     created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 
@@ -69,6 +155,57 @@ DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
 
+-- Previous UUIDs of subscribers whose UUIDs were rotated to invalidate the links
+-- in e-mails sent to them, eg: after a forwarded e-mail exposed their unsubscribe link.
+DROP TABLE IF EXISTS subscriber_uuid_rotations CASCADE;
+CREATE TABLE subscriber_uuid_rotations (
+    id              BIGSERIAL PRIMARY KEY,
+    subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    old_uuid        UUID NOT NULL,
+    reason          TEXT NOT NULL DEFAULT '',
+    user_id         INTEGER NULL,
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_uuid_rotations_old_uuid; CREATE INDEX idx_sub_uuid_rotations_old_uuid ON subscriber_uuid_rotations(old_uuid);
+DROP INDEX IF EXISTS idx_sub_uuid_rotations_sub_id; CREATE INDEX idx_sub_uuid_rotations_sub_id ON subscriber_uuid_rotations(subscriber_id);
+
+-- Pending opt-in confirmation codes (SHA-256 hashed) of subscribers.
+DROP TABLE IF EXISTS subscriber_optin_codes CASCADE;
+CREATE TABLE subscriber_optin_codes (
+    subscriber_id   INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    code            TEXT NOT NULL,
+    list_ids        INTEGER[] NOT NULL DEFAULT '{}',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    expires_at      TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Stripe subscriptions of subscribers that grant access to paid lists.
+DROP TABLE IF EXISTS stripe_subscriptions CASCADE;
+CREATE TABLE stripe_subscriptions (
+    id                 TEXT NOT NULL PRIMARY KEY,
+    subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    customer_id        TEXT NOT NULL,
+    price_ids          TEXT[] NOT NULL DEFAULT '{}',
+    status             TEXT NOT NULL,
+    current_period_end TIMESTAMP WITH TIME ZONE NULL,
+    created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_stripe_subs_sub_id; CREATE INDEX idx_stripe_subs_sub_id ON stripe_subscriptions(subscriber_id);
+
+-- folders for organizing campaigns and templates
+DROP TABLE IF EXISTS folders CASCADE;
+CREATE TABLE folders (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    archived        BOOLEAN NOT NULL DEFAULT false,
+
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
 CREATE TABLE templates (
@@ -78,11 +215,17 @@ CREATE TABLE templates (
     subject         TEXT NOT NULL,
     body            TEXT NOT NULL,
     is_default      BOOLEAN NOT NULL DEFAULT false,
+    folder_id       INTEGER NULL REFERENCES folders(id) ON DELETE SET NULL,
+
+    -- Incremented on every edit for detecting concurrent edits.
+    version         INTEGER NOT NULL DEFAULT 1,
 
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    deleted_at      TIMESTAMP WITH TIME ZONE NULL
 );
 CREATE UNIQUE INDEX ON templates (is_default) WHERE is_default = true;
+DROP INDEX IF EXISTS idx_tpls_search; CREATE INDEX idx_tpls_search ON templates USING GIN ((SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B')));
 
 
 -- campaigns
@@ -95,8 +238,18 @@ CREATE TABLE campaigns (
     from_email       TEXT NOT NULL,
     body             TEXT NOT NULL,
     altbody          TEXT NULL,
+
+    -- Large bodies are stored gzipped in body_gz, or in the media store as the
+    -- object body_ref, and body is empty. The start of such bodies is kept in
+    -- body_search for full-text search.
+    body_gz          BYTEA NULL,
+    body_ref         TEXT NOT NULL DEFAULT '',
+    body_search      TEXT NOT NULL DEFAULT '',
     content_type     content_type NOT NULL DEFAULT 'richtext',
     send_at          TIMESTAMP WITH TIME ZONE,
+
+    -- IANA timezone that send_at was scheduled in, eg: Europe/Berlin.
+    send_at_tz       TEXT NOT NULL DEFAULT '',
     headers          JSONB NOT NULL DEFAULT '[]',
     status           campaign_status NOT NULL DEFAULT 'draft',
     tags             VARCHAR(100)[],
@@ -109,6 +262,26 @@ CREATE TABLE campaigns (
     messenger        TEXT NOT NULL,
     template_id      INTEGER REFERENCES templates(id) ON DELETE SET DEFAULT DEFAULT 1,
 
+    -- Optional saved subscriber query (subscriber_queries) that further filters the subscribers on the lists.
+    -- The foreign key is added after subscriber_queries is created.
+    subscriber_query_id INTEGER NULL,
+    folder_id        INTEGER NULL REFERENCES folders(id) ON DELETE SET NULL,
+
+    -- List groups (list_groups) that are expanded to their member lists at send time.
+    list_group_ids   INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- Templated URLs from which per-subscriber attachments are fetched at send time.
+    attachment_urls  TEXT[] NOT NULL DEFAULT '{}',
+
+    -- Optional calendar event {title, description, location, url, start_at, end_at} for calendar invites.
+    event            JSONB NULL,
+
+    -- Inbox preview text that's injected into the HTML body. Can be templated.
+    preheader        TEXT NOT NULL DEFAULT '',
+
+    -- Language variants [{lang, subject, body}] picked by the subscriber's language at send time.
+    variants         JSONB NOT NULL DEFAULT '[]',
+
     -- Progress and stats.
     to_send            INT NOT NULL DEFAULT 0,
     sent               INT NOT NULL DEFAULT 0,
@@ -117,18 +290,44 @@ CREATE TABLE campaigns (
 
     -- Publishing.
     archive             BOOLEAN NOT NULL DEFAULT false,
-    archive_slug        TEXT NULL UNIQUE,
+    archive_slug        TEXT NULL,
     archive_template_id INTEGER REFERENCES templates(id) ON DELETE SET DEFAULT DEFAULT 1,
     archive_meta        JSONB NOT NULL DEFAULT '{}',
 
+    -- Incremented on every edit for detecting concurrent edits.
+    version          INTEGER NOT NULL DEFAULT 1,
+
+    -- The campaign whose soft-bounced recipients this campaign retries, and the attempt number.
+    retry_of         INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL,
+    retry_attempt    INTEGER NOT NULL DEFAULT 0,
+
+    -- Outbox review state of small campaigns: '' (none), rendering, pending (awaiting approval), approved.
+    outbox           TEXT NOT NULL DEFAULT '',
+
+    -- Messenger whose circuit breaker paused the campaign, which resumes it once the
+    -- messenger recovers. Cleared when the campaign's status is changed otherwise.
+    paused_by_breaker TEXT NOT NULL DEFAULT '',
+
+    -- Simulated campaigns run through the pipeline but their messages are discarded.
+    simulate         BOOLEAN NOT NULL DEFAULT false,
+
     started_at       TIMESTAMP WITH TIME ZONE,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    deleted_at       TIMESTAMP WITH TIME ZONE NULL
 );
 DROP INDEX IF EXISTS idx_camps_status; CREATE INDEX idx_camps_status ON campaigns(status);
 DROP INDEX IF EXISTS idx_camps_name; CREATE INDEX idx_camps_name ON campaigns(name);
 DROP INDEX IF EXISTS idx_camps_created_at; CREATE INDEX idx_camps_created_at ON campaigns(created_at);
 DROP INDEX IF EXISTS idx_camps_updated_at; CREATE INDEX idx_camps_updated_at ON campaigns(updated_at);
+DROP INDEX IF EXISTS idx_camps_folder_id; CREATE INDEX idx_camps_folder_id ON campaigns(folder_id);
+DROP INDEX IF EXISTS idx_camps_retry_of; CREATE INDEX idx_camps_retry_of ON campaigns(retry_of) WHERE retry_of IS NOT NULL;
+DROP INDEX IF EXISTS idx_camps_deleted_at; CREATE INDEX idx_camps_deleted_at ON campaigns(deleted_at) WHERE deleted_at IS NOT NULL;
+-- Archive slugs are unique among campaigns that aren't in the trash.
+DROP INDEX IF EXISTS idx_camps_archive_slug; CREATE UNIQUE INDEX idx_camps_archive_slug ON campaigns(archive_slug) WHERE deleted_at IS NULL;
+-- Full-text search. The body is truncated as tsvectors have a hard size limit (1 MB) and
+-- large bodies (eg: with inline base64 images) would otherwise fail inserts.
+DROP INDEX IF EXISTS idx_camps_search; CREATE INDEX idx_camps_search ON campaigns USING GIN ((SETWEIGHT(TO_TSVECTOR('simple', name), 'A') || SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') || SETWEIGHT(TO_TSVECTOR('simple', LEFT(body, 100000) || body_search), 'D')));
 
 
 DROP TABLE IF EXISTS campaign_lists CASCADE;
@@ -152,12 +351,58 @@ CREATE TABLE campaign_views (
 
     -- Subscribers may be deleted, but the view counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Views from known prefetching image proxies (eg: Apple Mail Privacy Protection).
+    proxy            BOOLEAN NOT NULL DEFAULT false,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_views_camp_id; CREATE INDEX idx_views_camp_id ON campaign_views(campaign_id);
 DROP INDEX IF EXISTS idx_views_subscriber_id; CREATE INDEX idx_views_subscriber_id ON campaign_views(subscriber_id);
 DROP INDEX IF EXISTS idx_views_date; CREATE INDEX idx_views_date ON campaign_views((TIMEZONE('UTC', created_at)::DATE));
 
+DROP TYPE IF EXISTS rsvp_status CASCADE; CREATE TYPE rsvp_status AS ENUM ('accepted', 'declined', 'tentative');
+DROP TABLE IF EXISTS campaign_rsvps CASCADE;
+CREATE TABLE campaign_rsvps (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status           rsvp_status NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    PRIMARY KEY(campaign_id, subscriber_id)
+);
+
+DROP TABLE IF EXISTS campaign_variant_stats CASCADE;
+CREATE TABLE campaign_variant_stats (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Language of the variant. Empty for the campaign's default content.
+    lang             TEXT NOT NULL DEFAULT '',
+    sent             INT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(campaign_id, lang)
+);
+
+DROP TABLE IF EXISTS campaign_domain_stats CASCADE;
+CREATE TABLE campaign_domain_stats (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Recipient e-mail domain (lowercase).
+    domain           TEXT NOT NULL,
+    sent             INT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(campaign_id, domain)
+);
+
+DROP TABLE IF EXISTS campaign_unsubscribes CASCADE;
+CREATE TABLE campaign_unsubscribes (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_unsubs_camp_id; CREATE INDEX idx_camp_unsubs_camp_id ON campaign_unsubscribes(campaign_id);
+
 -- media
 DROP TABLE IF EXISTS media CASCADE;
 CREATE TABLE media (
@@ -168,8 +413,13 @@ CREATE TABLE media (
     content_type     TEXT NOT NULL DEFAULT 'application/octet-stream',
     thumb            TEXT NOT NULL,
     meta             JSONB NOT NULL DEFAULT '{}',
+
+    -- Files are downloaded with signed, expiring {{ FileURL }} links in campaigns.
+    type             media_type NOT NULL DEFAULT 'media',
+    downloads        INTEGER NOT NULL DEFAULT 0,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
+DROP INDEX IF EXISTS idx_media_files; CREATE UNIQUE INDEX idx_media_files ON media(filename) WHERE type = 'file';
 
 -- campaign_media
 DROP TABLE IF EXISTS campaign_media CASCADE;
@@ -195,6 +445,17 @@ CREATE TABLE links (
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Short slugs of the tracked links in campaigns, eg: /l/{slug}.
+DROP TABLE IF EXISTS link_slugs CASCADE;
+CREATE TABLE link_slugs (
+    slug             TEXT NOT NULL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    UNIQUE(campaign_id, link_id)
+);
+
 DROP TABLE IF EXISTS link_clicks CASCADE;
 CREATE TABLE link_clicks (
     id               BIGSERIAL PRIMARY KEY,
@@ -208,6 +469,18 @@ CREATE TABLE link_clicks (
 DROP INDEX IF EXISTS idx_clicks_camp_id; CREATE INDEX idx_clicks_camp_id ON link_clicks(campaign_id);
 DROP INDEX IF EXISTS idx_clicks_link_id; CREATE INDEX idx_clicks_link_id ON link_clicks(link_id);
 DROP INDEX IF EXISTS idx_clicks_sub_id; CREATE INDEX idx_clicks_sub_id ON link_clicks(subscriber_id);
+
+-- Clicks from security scanners and bots, kept out of link_clicks so that they don't inflate stats.
+DROP TABLE IF EXISTS link_clicks_bots CASCADE;
+CREATE TABLE link_clicks_bots (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    link_id          INTEGER NOT NULL REFERENCES links(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    reason           TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_bot_clicks_camp_id; CREATE INDEX idx_bot_clicks_camp_id ON link_clicks_bots(campaign_id);
 DROP INDEX IF EXISTS idx_clicks_date; CREATE INDEX idx_clicks_date ON link_clicks((TIMEZONE('UTC', created_at)::DATE));
 
 -- settings
@@ -226,18 +499,32 @@ INSERT INTO settings (key, value) VALUES
     ('app.logo_url', '""'),
     ('app.concurrency', '10'),
     ('app.message_rate', '10'),
+    ('app.adaptive_concurrency', 'false'),
+    ('app.min_concurrency', '1'),
+    ('app.adaptive_latency', '"2s"'),
+    ('app.adaptive_error_rate', '5'),
+    ('app.breaker_threshold', '20'),
+    ('app.breaker_cooldown', '"1m"'),
     ('app.batch_size', '1000'),
     ('app.max_send_errors', '1000'),
+    ('app.outbox_review_threshold', '0'),
     ('app.message_sliding_window', 'false'),
     ('app.message_sliding_window_duration', '"1h"'),
     ('app.message_sliding_window_rate', '10000'),
     ('app.cache_slow_queries', 'false'),
     ('app.cache_slow_queries_interval', '"0 3 * * *"'),
+    ('app.trash_retention_days', '30'),
+    ('app.message_size_limit', '0'),
+    ('app.image_weight_limit', '0'),
     ('app.enable_public_archive', 'true'),
     ('app.enable_public_subscription_page', 'true'),
     ('app.enable_public_archive_rss_content', 'true'),
     ('app.send_optin_confirmation', 'true'),
     ('app.check_updates', 'true'),
+    ('app.dark_mode_meta', 'false'),
+    ('app.short_links', 'false'),
+    ('app.require_campaign_checklist', 'false'),
+    ('app.default_timezone', '"UTC"'),
     ('app.notify_emails', '["admin1@mysite.com", "admin2@mysite.com"]'),
     ('app.lang', '"en"'),
     ('privacy.individual_tracking', 'false'),
@@ -248,10 +535,36 @@ INSERT INTO settings (key, value) VALUES
     ('privacy.allow_preferences', 'true'),
     ('privacy.exportable', '["profile", "subscriptions", "campaign_views", "link_clicks"]'),
     ('privacy.domain_blocklist', '[]'),
+    ('privacy.block_role_accounts', 'false'),
+    ('privacy.role_accounts', '[]'),
+    ('privacy.check_mx', 'false'),
+    ('privacy.email_deny_patterns', '[]'),
     ('privacy.record_optin_ip', 'false'),
+    ('privacy.record_consent', 'false'),
+    ('privacy.consent_version', '""'),
+    ('privacy.optin_sms_messenger', '""'),
+    ('privacy.discount_proxy_opens', 'false'),
+    ('privacy.suppression_sources', '[]'),
+    ('privacy.filter_bot_clicks', 'true'),
+    ('privacy.bot_click_ips', '[]'),
+    ('privacy.require_signed_links', 'false'),
+    ('privacy.signed_link_expiry', '"0"'),
     ('security.enable_captcha', 'false'),
     ('security.captcha_key', '""'),
     ('security.captcha_secret', '""'),
+    ('security.smime_sign', 'false'),
+    ('security.smime_cert', '""'),
+    ('security.smime_key', '""'),
+    ('security.encrypt_messages', 'false'),
+    ('security.sanitize_enabled', 'false'),
+    ('security.sanitize_mode', '"strip"'),
+    ('security.sanitize_max_name_len', '200'),
+    ('security.sanitize_max_attrib_len', '1000'),
+    ('security.sanitize_allowed_attribs', '[]'),
+    ('security.sanitize_raw_fields', '[]'),
+    ('security.template_funcs', '[]'),
+    ('security.template_render_timeout', '"10s"'),
+    ('security.template_max_size', '10240'),
     ('security.oidc', '{"enabled": false, "provider_url": "", "client_id": "", "client_secret": ""}'),
     ('upload.provider', '"filesystem"'),
     ('upload.max_file_size', '5000'),
@@ -268,24 +581,42 @@ INSERT INTO settings (key, value) VALUES
     ('upload.s3.bucket_path', '"/"'),
     ('upload.s3.bucket_type', '"public"'),
     ('upload.s3.expiry', '"167h"'),
+    ('feedback_id.enabled', 'true'),
+    ('feedback_id.sender', '"listmonk"'),
+    ('smtp_mx.enabled', 'false'),
+    ('smtp_mx.hello_hostname', '""'),
+    ('smtp_mx.require_tls', 'false'),
+    ('smtp_mx.max_conns', '2'),
+    ('smtp_mx.idle_timeout', '"15s"'),
+    ('smtp_mx.max_retries', '5'),
+    ('smtp_mx.retry_interval', '"5m"'),
     ('smtp',
         '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[]},
           {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"TLS","tls_skip_verify":false,"email_headers":[]}]'),
     ('messengers', '[]'),
+    ('notifications', '[]'),
+    ('alert_rules', '[]'),
+    ('sender.company_name', '""'),
+    ('sender.address', '""'),
+    ('sender.links', '[]'),
+    ('compliance.profiles', '[{"name": "can-spam", "require_address": true, "require_unsubscribe": true, "require_sender": true}, {"name": "casl", "require_address": true, "require_unsubscribe": true, "require_sender": true}, {"name": "gdpr", "require_address": false, "require_unsubscribe": true, "require_sender": true}]'),
     ('bounce.enabled', 'false'),
     ('bounce.webhooks_enabled', 'false'),
     ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "complaint" : {"count": 1, "action": "blocklist"}}'),
+    ('bounce.retry_max_attempts', '3'),
     ('bounce.ses_enabled', 'false'),
     ('bounce.sendgrid_enabled', 'false'),
     ('bounce.sendgrid_key', '""'),
     ('bounce.postmark', '{"enabled": false, "username": "", "password": ""}'),
     ('bounce.forwardemail', '{"enabled": false, "key": ""}'),
+    ('billing.stripe', '{"enabled": false, "secret_key": "", "webhook_secret": ""}'),
     ('bounce.mailboxes',
         '[{"enabled":false, "type": "pop", "host":"pop.yoursite.com","port":995,"auth_protocol":"userpass","username":"username","password":"password","return_path": "bounce@listmonk.yoursite.com","scan_interval":"15m","tls_enabled":true,"tls_skip_verify":false}]'),
     ('appearance.admin.custom_css', '""'),
     ('appearance.admin.custom_js', '""'),
     ('appearance.public.custom_css', '""'),
-    ('appearance.public.custom_js', '""');
+    ('appearance.public.custom_js', '""'),
+    ('appearance.public.templates', '[]');
 
 -- bounces
 DROP TABLE IF EXISTS bounces CASCADE;
@@ -303,6 +634,44 @@ DROP INDEX IF EXISTS idx_bounces_camp_id; CREATE INDEX idx_bounces_camp_id ON bo
 DROP INDEX IF EXISTS idx_bounces_source; CREATE INDEX idx_bounces_source ON bounces(source);
 DROP INDEX IF EXISTS idx_bounces_date; CREATE INDEX idx_bounces_date ON bounces((TIMEZONE('UTC', created_at)::DATE));
 
+-- human replies to campaign messages
+DROP TABLE IF EXISTS campaign_replies CASCADE;
+CREATE TABLE campaign_replies (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Message-ID of the reply by which replies are deduplicated.
+    message_id       TEXT NOT NULL UNIQUE,
+    from_email       TEXT NOT NULL DEFAULT '',
+    subject          TEXT NOT NULL DEFAULT '',
+    body             TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_replies_camp_id; CREATE INDEX idx_camp_replies_camp_id ON campaign_replies(campaign_id);
+DROP INDEX IF EXISTS idx_camp_replies_sub_id; CREATE INDEX idx_camp_replies_sub_id ON campaign_replies(subscriber_id);
+
+-- syncs of external suppression lists into the blocklist
+DROP TYPE IF EXISTS suppression_sync_status CASCADE; CREATE TYPE suppression_sync_status AS ENUM ('pending', 'applied', 'rejected', 'failed');
+DROP TABLE IF EXISTS suppression_syncs CASCADE;
+CREATE TABLE suppression_syncs (
+    id               SERIAL PRIMARY KEY,
+
+    -- UUID and name of the source in the privacy.suppression_sources setting.
+    source_uuid      TEXT NOT NULL,
+    source_name      TEXT NOT NULL DEFAULT '',
+    status           suppression_sync_status NOT NULL DEFAULT 'pending',
+    total            INT NOT NULL DEFAULT 0,
+
+    -- E-mails to be blocklisted, and those blocklisted by earlier syncs that are no longer in the source.
+    added            TEXT[] NOT NULL DEFAULT '{}',
+    removed          TEXT[] NOT NULL DEFAULT '{}',
+    error            TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    applied_at       TIMESTAMP WITH TIME ZONE NULL
+);
+DROP INDEX IF EXISTS idx_suppression_syncs_source; CREATE INDEX idx_suppression_syncs_source ON suppression_syncs(source_uuid, created_at);
+
 -- roles
 DROP TABLE IF EXISTS roles CASCADE;
 CREATE TABLE roles (
@@ -337,6 +706,269 @@ CREATE TABLE users (
     updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- saved subscriber queries
+DROP TABLE IF EXISTS subscriber_queries CASCADE;
+CREATE TABLE subscriber_queries (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    query            TEXT NOT NULL,
+
+    -- Shared queries are visible to all users. Others, only to the user who created them.
+    shared           BOOLEAN NOT NULL DEFAULT false,
+    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sub_queries_user_id; CREATE INDEX idx_sub_queries_user_id ON subscriber_queries(user_id);
+
+-- subscriber_queries is created after campaigns.
+ALTER TABLE campaigns ADD CONSTRAINT campaigns_subscriber_query_id_fkey
+    FOREIGN KEY (subscriber_query_id) REFERENCES subscriber_queries(id) ON DELETE SET NULL;
+
+-- campaign_revisions
+-- Autosaved, unsaved states of campaigns being edited (autosave), and the
+-- content of campaigns as it was before they were updated (update).
+DROP TABLE IF EXISTS campaign_revisions CASCADE;
+CREATE TABLE campaign_revisions (
+    id               SERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    kind             TEXT NOT NULL DEFAULT 'autosave',
+    data             JSONB NOT NULL DEFAULT '{}',
+    created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_revisions_camp_id; CREATE INDEX idx_camp_revisions_camp_id ON campaign_revisions(campaign_id);
+
+-- campaign_outbox
+-- Rendered messages of campaigns held for review before they're sent.
+DROP TABLE IF EXISTS campaign_outbox CASCADE;
+CREATE TABLE campaign_outbox (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    email            TEXT NOT NULL,
+    subject          TEXT NOT NULL,
+    body             TEXT NOT NULL,
+    altbody          TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    UNIQUE(campaign_id, subscriber_id)
+);
+
+-- postmaster_stats
+-- Aggregate reputation and spam stats imported from mailbox providers'
+-- postmaster tools, eg: Gmail Postmaster Tools.
+DROP TABLE IF EXISTS postmaster_stats CASCADE;
+CREATE TABLE postmaster_stats (
+    id                   BIGSERIAL PRIMARY KEY,
+
+    -- Mailbox provider the stats are from, eg: gmail.
+    provider             TEXT NOT NULL,
+
+    -- Sending domain and the day the stats are of.
+    domain               TEXT NOT NULL,
+    date                 DATE NOT NULL,
+
+    -- Feedback-ID identifier the stats are of. Empty for domain-wide stats.
+    identifier           TEXT NOT NULL DEFAULT '',
+    campaign_id          INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    spam_rate            DOUBLE PRECISION NULL,
+    domain_reputation    TEXT NOT NULL DEFAULT '',
+    ip_reputation        TEXT NOT NULL DEFAULT '',
+    spf_success_rate     DOUBLE PRECISION NULL,
+    dkim_success_rate    DOUBLE PRECISION NULL,
+    dmarc_success_rate   DOUBLE PRECISION NULL,
+    delivery_error_rate  DOUBLE PRECISION NULL,
+
+    -- Rest of the provider's data as-is.
+    meta                 JSONB NOT NULL DEFAULT '{}',
+    created_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    UNIQUE(provider, domain, date, identifier)
+);
+DROP INDEX IF EXISTS idx_postmaster_stats_camp_id; CREATE INDEX idx_postmaster_stats_camp_id ON postmaster_stats(campaign_id);
+
+-- repermissions
+-- Re-permission (re-opt-in) runs. An opt-in campaign asks the unconfirmed subscribers of
+-- double opt-in lists to confirm their subscriptions, and the subscriptions that are still
+-- unconfirmed at the deadline are removed or unsubscribed.
+DROP TYPE IF EXISTS repermission_action CASCADE; CREATE TYPE repermission_action AS ENUM ('remove', 'unsubscribe');
+DROP TYPE IF EXISTS repermission_status CASCADE; CREATE TYPE repermission_status AS ENUM ('waiting', 'done', 'cancelled');
+DROP TABLE IF EXISTS repermissions CASCADE;
+CREATE TABLE repermissions (
+    id               SERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL UNIQUE REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_ids         INT[] NOT NULL,
+    deadline         TIMESTAMP WITH TIME ZONE NOT NULL,
+    action           repermission_action NOT NULL DEFAULT 'remove',
+    status           repermission_status NOT NULL DEFAULT 'waiting',
+
+    -- Number of subscriptions removed or unsubscribed at the deadline.
+    removed          INT NOT NULL DEFAULT 0,
+
+    created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at     TIMESTAMP WITH TIME ZONE NULL
+);
+DROP INDEX IF EXISTS idx_repermissions_status; CREATE INDEX idx_repermissions_status ON repermissions(status);
+
+-- repermission_subscriptions
+-- The unconfirmed subscriptions targeted by a re-permission run when it was created.
+DROP TABLE IF EXISTS repermission_subscriptions CASCADE;
+CREATE TABLE repermission_subscriptions (
+    repermission_id  INTEGER NOT NULL REFERENCES repermissions(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY(repermission_id, subscriber_id, list_id)
+);
+
+-- campaign_checklist
+-- Items on campaigns' pre-send checklists that have been checked off.
+DROP TABLE IF EXISTS campaign_checklist CASCADE;
+CREATE TABLE campaign_checklist (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    item             TEXT NOT NULL,
+    created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY(campaign_id, item)
+);
+
+-- campaign_followups
+-- Follow-up campaigns that are automatically scheduled to the openers or clickers
+-- of a parent campaign a number of days after the parent finishes.
+DROP TYPE IF EXISTS followup_audience CASCADE; CREATE TYPE followup_audience AS ENUM ('openers', 'clickers');
+DROP TYPE IF EXISTS followup_status CASCADE; CREATE TYPE followup_status AS ENUM ('waiting', 'scheduled', 'cancelled');
+DROP TABLE IF EXISTS campaign_followups CASCADE;
+CREATE TABLE campaign_followups (
+    id               SERIAL PRIMARY KEY,
+    parent_id        INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    campaign_id      INTEGER NOT NULL UNIQUE REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    audience         followup_audience NOT NULL,
+
+    -- Optional URL of a link in the parent campaign. Only its clickers get the follow-up.
+    url              TEXT NOT NULL DEFAULT '',
+    delay_days       INT NOT NULL DEFAULT 0,
+    status           followup_status NOT NULL DEFAULT 'waiting',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_followups_parent_id; CREATE INDEX idx_camp_followups_parent_id ON campaign_followups(parent_id);
+DROP INDEX IF EXISTS idx_camp_followups_status; CREATE INDEX idx_camp_followups_status ON campaign_followups(status);
+
+-- campaign_presets
+-- Saved, reusable campaign presets that new campaigns are created from.
+DROP TABLE IF EXISTS campaign_presets CASCADE;
+CREATE TABLE campaign_presets (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    description      TEXT NOT NULL DEFAULT '',
+
+    subject          TEXT NOT NULL DEFAULT '',
+    preheader        TEXT NOT NULL DEFAULT '',
+    from_email       TEXT NOT NULL DEFAULT '',
+    body             TEXT NOT NULL DEFAULT '',
+    altbody          TEXT NULL,
+    content_type     content_type NOT NULL DEFAULT 'richtext',
+    template_id      INTEGER REFERENCES templates(id) ON DELETE SET NULL,
+    headers          JSONB NOT NULL DEFAULT '[]',
+    messenger        TEXT NOT NULL DEFAULT '',
+    tags             VARCHAR(100)[],
+
+    -- Default lists of campaigns created from the preset.
+    list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- UTM parameters ({"source", "medium", "campaign"}) added to the links
+    -- in the body of campaigns created from the preset.
+    utm              JSONB NOT NULL DEFAULT '{}',
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- lead_forms
+-- Website forms that capture leads with the public leads API, authenticated
+-- by per-form API keys.
+DROP TABLE IF EXISTS lead_forms CASCADE;
+CREATE TABLE lead_forms (
+    id               SERIAL PRIMARY KEY,
+    uuid             UUID NOT NULL UNIQUE,
+    name             TEXT NOT NULL,
+    api_key          TEXT NOT NULL UNIQUE,
+    enabled          BOOLEAN NOT NULL DEFAULT true,
+
+    -- Lists that leads are subscribed to and tags that are added to them.
+    list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+    tags             VARCHAR(100)[] NOT NULL DEFAULT '{}',
+
+    -- Optional transactional template that's sent to every captured lead.
+    tx_template_id   INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL,
+
+    -- Maximum number of submissions per minute from an IP.
+    rate_limit       INTEGER NOT NULL DEFAULT 10,
+
+    -- URL that HTML form submissions are redirected to.
+    redirect_url     TEXT NOT NULL DEFAULT '',
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- lead_captures
+-- Every lead captured by a lead form with its UTM data and referrer for attribution.
+DROP TABLE IF EXISTS lead_captures CASCADE;
+CREATE TABLE lead_captures (
+    id               BIGSERIAL PRIMARY KEY,
+    form_id          INTEGER NOT NULL REFERENCES lead_forms(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    utm              JSONB NOT NULL DEFAULT '{}',
+    referrer         TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_lead_captures_form_id; CREATE INDEX idx_lead_captures_form_id ON lead_captures(form_id, created_at);
+DROP INDEX IF EXISTS idx_lead_captures_sub_id; CREATE INDEX idx_lead_captures_sub_id ON lead_captures(subscriber_id);
+
+-- event_rules
+-- Rules that map named events posted by external systems to transactional
+-- sends, subscriptions, and tags.
+DROP TABLE IF EXISTS event_rules CASCADE;
+CREATE TABLE event_rules (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    event            TEXT NOT NULL,
+    enabled          BOOLEAN NOT NULL DEFAULT true,
+
+    -- Actions.
+    tx_template_id   INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL,
+    list_ids         INTEGER[] NOT NULL DEFAULT '{}',
+    tags             VARCHAR(100)[] NOT NULL DEFAULT '{}',
+
+    -- A rule is triggered once per subscriber within the window. 0 = every time.
+    dedup_minutes    INTEGER NOT NULL DEFAULT 0,
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_event_rules_event; CREATE INDEX idx_event_rules_event ON event_rules(event);
+
+-- event_triggers
+-- Log of the rules triggered by events, used for de-duplication.
+DROP TABLE IF EXISTS event_triggers CASCADE;
+CREATE TABLE event_triggers (
+    id               BIGSERIAL PRIMARY KEY,
+    rule_id          INTEGER NOT NULL REFERENCES event_rules(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    event            TEXT NOT NULL,
+    payload          JSONB NOT NULL DEFAULT '{}',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_event_triggers_rule_sub; CREATE INDEX idx_event_triggers_rule_sub ON event_triggers(rule_id, subscriber_id, created_at);
+
 -- user sessions
 DROP TABLE IF EXISTS sessions CASCADE;
 CREATE TABLE sessions (
@@ -346,6 +978,78 @@ CREATE TABLE sessions (
 );
 DROP INDEX IF EXISTS idx_sessions; CREATE INDEX idx_sessions ON sessions (id, created_at);
 
+-- event log (activity feed)
+DROP TABLE IF EXISTS event_log CASCADE;
+CREATE TABLE event_log (
+    id               BIGSERIAL PRIMARY KEY,
+    type             TEXT NOT NULL,
+    message          TEXT NOT NULL,
+    meta             JSONB NOT NULL DEFAULT '{}',
+    user_id          INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_event_log_type; CREATE INDEX idx_event_log_type ON event_log(type, id);
+
+-- reports
+-- Saved read-only SQL queries that are run on demand or on a cron schedule
+-- under a restricted database role.
+DROP TYPE IF EXISTS report_result_status CASCADE; CREATE TYPE report_result_status AS ENUM ('success', 'error');
+DROP TABLE IF EXISTS reports CASCADE;
+CREATE TABLE reports (
+    id               SERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    description      TEXT NOT NULL DEFAULT '',
+    query            TEXT NOT NULL,
+
+    -- Standard cron expression that the report is run on. Empty runs it only on demand.
+    schedule         TEXT NOT NULL DEFAULT '',
+    last_run_at      TIMESTAMP WITH TIME ZONE NULL,
+    created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- report_results
+-- The most recent results of each report.
+DROP TABLE IF EXISTS report_results CASCADE;
+CREATE TABLE report_results (
+    id               BIGSERIAL PRIMARY KEY,
+    report_id        INTEGER NOT NULL REFERENCES reports(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status           report_result_status NOT NULL,
+    scheduled        BOOLEAN NOT NULL DEFAULT false,
+
+    -- Column names and rows (arrays of values) of the result.
+    columns          JSONB NOT NULL DEFAULT '[]',
+    data             JSONB NOT NULL DEFAULT '[]',
+    num_rows         INT NOT NULL DEFAULT 0,
+    truncated        BOOLEAN NOT NULL DEFAULT false,
+    error            TEXT NOT NULL DEFAULT '',
+    duration_ms      INT NOT NULL DEFAULT 0,
+    created_by       INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_report_results_report; CREATE INDEX idx_report_results_report ON report_results(report_id, id);
+
+-- warehouse_exports
+-- State of the incremental exports of tables (subscribers, deliveries, views, clicks)
+-- to the data warehouse.
+DROP TABLE IF EXISTS warehouse_exports CASCADE;
+CREATE TABLE warehouse_exports (
+    name             TEXT NOT NULL PRIMARY KEY,
+
+    -- (updated_at or created_at, id) of the last exported row.
+    cursor_at        TIMESTAMP WITH TIME ZONE NULL,
+    cursor_id        BIGINT NOT NULL DEFAULT 0,
+    exported         BIGINT NOT NULL DEFAULT 0,
+    files            INT NOT NULL DEFAULT 0,
+    last_run_at      TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Status of the last run: success, error, or empty if it hasn't run.
+    last_status      TEXT NOT NULL DEFAULT '',
+    last_error       TEXT NOT NULL DEFAULT '',
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
 -- materialized views
 
 -- dashboard stats
@@ -1,5 +1,5 @@
 <!doctype html>
-<html>
+<html lang="{{ Lang }}" dir="{{ TextDir }}">
     <head>
         <title>{{ .Campaign.Subject }}</title>
         <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
@@ -97,6 +97,13 @@
             &nbsp;&nbsp;
             <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
         </p>
+        {{ with .Sender.CompanyName }}<p><strong>{{ . }}</strong></p>{{ end }}
+        {{ with .Sender.AddressLines }}<p>{{ range . }}{{ . }}<br />{{ end }}</p>{{ end }}
+        {{ with .Sender.Links }}
+        <p>
+            {{ range $i, $l := . }}{{ if $i }} &middot; {{ end }}<a href="{{ $l.URL }}" style="color: #888;">{{ $l.Name }}</a>{{ end }}
+        </p>
+        {{ end }}
     </div>
     <div class="gutter" style="padding: 30px;">&nbsp;{{ TrackView }}</div>
 </body>
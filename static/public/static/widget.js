@@ -0,0 +1,65 @@
+/*
+  listmonk embeddable subscription widget.
+
+  <script src="https://listmonk.yoursite.com/public/widget.js"
+    data-lists="list-uuid-1,list-uuid-2" data-theme="dark" data-color="#0055d4"
+    data-title="Join our newsletter" async></script>
+
+  The widget loads the subscription form in an iframe after the script tag (or
+  inside the element matching data-target) and resizes it as the form changes.
+  "listmonk:subscribed", "listmonk:confirmed", and "listmonk:error" events are
+  dispatched on the script tag and bubble up to the document.
+*/
+(function() {
+  var script = document.currentScript;
+  if (!script) {
+    return;
+  }
+
+  var src = new URL(script.src),
+    root = script.src.replace(/\/public\/widget\.js.*$/, ""),
+    d = script.dataset,
+    id = d.id || "lm-" + Math.random().toString(36).substring(2, 10),
+    params = new URLSearchParams();
+
+  (d.lists || "").split(",").forEach(function(l) {
+    l = l.trim();
+    if (l) {
+      params.append("l", l);
+    }
+  });
+
+  ["theme", "color", "title", "lang", "name"].forEach(function(k) {
+    if (d[k]) {
+      params.set(k, d[k]);
+    }
+  });
+  params.set("id", id);
+
+  var frame = document.createElement("iframe");
+  frame.src = root + "/subscription/widget?" + params.toString();
+  frame.title = d.title || "Subscribe";
+  frame.setAttribute("scrolling", "no");
+  frame.style.cssText = "border: 0; width: 100%; max-width: " + (d.width || "480px") + "; height: 360px; overflow: hidden;";
+
+  var target = d.target ? document.querySelector(d.target) : null;
+  if (target) {
+    target.appendChild(frame);
+  } else {
+    script.parentNode.insertBefore(frame, script.nextSibling);
+  }
+
+  window.addEventListener("message", function(e) {
+    var m = e.data;
+    if (e.origin !== src.origin || e.source !== frame.contentWindow || !m || m.listmonk !== "widget" || m.id !== id) {
+      return;
+    }
+
+    if (m.type === "resize") {
+      frame.style.height = Math.ceil(m.height) + "px";
+      return;
+    }
+
+    script.dispatchEvent(new CustomEvent("listmonk:" + m.type, { detail: m, bubbles: true }));
+  });
+})();